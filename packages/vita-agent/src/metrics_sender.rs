@@ -1,11 +1,28 @@
 use anyhow::Result;
 use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
 use std::time::{SystemTime, UNIX_EPOCH};
 
-#[derive(Debug, Serialize, Deserialize)]
-pub struct MetricBatch {
+/// Payload version that numbers posts and may carry deltas
+const DELTA_VERSION: u32 = 3;
+
+/// Response header with the payload version the consumer speaks
+const VERSION_HEADER: &str = "X-Vita-Ingest-Version";
+
+#[derive(Debug, Serialize)]
+pub struct MetricBatch<'a> {
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub version: Option<u32>,
     pub node: String,
-    pub metrics: Vec<RawMetric>,
+    /// Stored by the consumer with every metric as part of its source
+    pub agent_version: &'a str,
+    /// Numbers sequenced posts; metrics may then carry deltas against the
+    /// post numbered base_seq (absent: absolute values only)
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub seq: Option<u64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub base_seq: Option<u64>,
+    pub metrics: Vec<WireMetric<'a>>,
 }
 
 #[derive(Debug, Serialize, Deserialize)]
@@ -25,11 +42,59 @@ pub struct RawMetric {
     pub ts: i64,
 }
 
+impl RawMetric {
+    /// Identifies the metric's series across posts, as the consumer does
+    fn series(&self) -> String {
+        [
+            self.metric_type.as_str(),
+            self.pod_id.as_deref().unwrap_or(""),
+            self.pod_uid.as_deref().unwrap_or(""),
+            self.volume.as_deref().unwrap_or(""),
+            self.container_id.as_deref().unwrap_or(""),
+            self.key.as_str(),
+        ]
+        .join("\0")
+    }
+}
+
+/// A metric as posted: with its value, or in a delta post the change `d`
+/// since the same series in the baseline post
+#[derive(Debug, Serialize)]
+pub struct WireMetric<'a> {
+    #[serde(rename = "type")]
+    pub metric_type: &'a str,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub pod_id: Option<&'a str>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub pod_uid: Option<&'a str>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub volume: Option<&'a str>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub container_id: Option<&'a str>,
+    pub key: &'a str,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub value: Option<f64>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub d: Option<f64>,
+    pub ts: i64,
+}
+
+/// The last post the consumer accepted and the values it rebuilt for
+/// each series, which the next post may send deltas against
+struct Baseline {
+    seq: u64,
+    values: HashMap<String, f64>,
+}
+
 pub struct MetricsSender {
     client: reqwest::Client,
     endpoint: String,
     node_name: String,
     batch: Vec<RawMetric>,
+    /// Set once the consumer reports a payload version with deltas
+    deltas: bool,
+    seq: u64,
+    baseline: Option<Baseline>,
 }
 
 impl MetricsSender {
@@ -39,6 +104,9 @@ impl MetricsSender {
             endpoint,
             node_name,
             batch: Vec::with_capacity(100),
+            deltas: false,
+            seq: 0,
+            baseline: None,
         }
     }
 
@@ -50,30 +118,95 @@ impl MetricsSender {
         if self.batch.is_empty() {
             return Ok(());
         }
+        let metrics = std::mem::replace(&mut self.batch, Vec::with_capacity(100));
 
-        let payload = MetricBatch {
-            node: self.node_name.clone(),
-            metrics: std::mem::replace(&mut self.batch, Vec::with_capacity(100)),
-        };
-
-        match self.client
-            .post(&self.endpoint)
-            .json(&payload)
-            .send()
-            .await
-        {
-            Ok(resp) => {
-                if !resp.status().is_success() {
-                    tracing::warn!("Failed to send metrics: HTTP {}", resp.status());
+        // A delta post the consumer cannot rebuild (it restarted, or missed
+        // the baseline) is refused with 409 and resent with absolute values
+        for _ in 0..2 {
+            let (payload, values) = self.encode(&metrics);
+            let sequenced = payload.seq;
+            let resp = match self.client.post(&self.endpoint).json(&payload).send().await {
+                Ok(resp) => resp,
+                Err(e) => {
+                    tracing::warn!("Failed to send metrics: {}", e);
+                    return Ok(());
                 }
+            };
+
+            if resp.status() == reqwest::StatusCode::CONFLICT && self.baseline.is_some() {
+                tracing::debug!("Consumer lost the delta baseline, resending absolute values");
+                self.baseline = None;
+                continue;
             }
-            Err(e) => {
-                tracing::warn!("Failed to send metrics: {}", e);
+            if !resp.status().is_success() {
+                tracing::warn!("Failed to send metrics: HTTP {}", resp.status());
+                return Ok(());
             }
+
+            self.deltas = resp
+                .headers()
+                .get(VERSION_HEADER)
+                .and_then(|v| v.to_str().ok())
+                .and_then(|v| v.parse::<u32>().ok())
+                .map_or(false, |v| v >= DELTA_VERSION);
+            self.baseline = match sequenced {
+                Some(seq) if self.deltas => Some(Baseline { seq, values }),
+                _ => None,
+            };
+            return Ok(());
         }
 
         Ok(())
     }
+
+    /// Builds the post for metrics, as deltas against the baseline when the
+    /// consumer takes them, and the values the consumer will rebuild
+    fn encode<'a>(&mut self, metrics: &'a [RawMetric]) -> (MetricBatch<'a>, HashMap<String, f64>) {
+        let mut values = HashMap::new();
+        let mut payload = MetricBatch {
+            version: None,
+            node: self.node_name.clone(),
+            agent_version: env!("CARGO_PKG_VERSION"),
+            seq: None,
+            base_seq: None,
+            metrics: Vec::with_capacity(metrics.len()),
+        };
+        if self.deltas {
+            self.seq += 1;
+            payload.version = Some(DELTA_VERSION);
+            payload.seq = Some(self.seq);
+            payload.base_seq = self.baseline.as_ref().map(|b| b.seq);
+        }
+
+        for m in metrics {
+            let mut wire = WireMetric {
+                metric_type: &m.metric_type,
+                pod_id: m.pod_id.as_deref(),
+                pod_uid: m.pod_uid.as_deref(),
+                volume: m.volume.as_deref(),
+                container_id: m.container_id.as_deref(),
+                key: &m.key,
+                value: Some(m.value),
+                d: None,
+                ts: m.ts,
+            };
+            if self.deltas {
+                let series = m.series();
+                let mut value = m.value;
+                if let Some(prev) = self.baseline.as_ref().and_then(|b| b.values.get(&series)) {
+                    let d = m.value - prev;
+                    // The consumer adds d to its copy; keep what it gets
+                    // so rounding never drifts between the two
+                    value = prev + d;
+                    wire.value = None;
+                    wire.d = Some(d);
+                }
+                values.insert(series, value);
+            }
+            payload.metrics.push(wire);
+        }
+        (payload, values)
+    }
 }
 
 pub fn get_timestamp() -> i64 {
@@ -0,0 +1,49 @@
+//go:build authproxy
+
+package main
+
+// Build with -tags authproxy and set AUTH_MODE=proxy to trust the user an
+// authenticating proxy (oauth2-proxy, an SSO gateway) forwards. Only safe
+// when nothing but the proxy can reach the API. Enterprise integrations
+// register their own authenticator the same way.
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+)
+
+func init() {
+	api.RegisterAuthenticator("proxy", func() (api.Authenticator, error) {
+		userHeader := os.Getenv("AUTH_PROXY_USER_HEADER")
+		if userHeader == "" {
+			userHeader = "X-Forwarded-User"
+		}
+		groupsHeader := os.Getenv("AUTH_PROXY_GROUPS_HEADER")
+		if groupsHeader == "" {
+			groupsHeader = "X-Forwarded-Groups"
+		}
+		return proxyAuth{user: userHeader, groups: groupsHeader}, nil
+	})
+}
+
+// proxyAuth admits requests carrying a forwarded user
+type proxyAuth struct {
+	user, groups string
+}
+
+func (a proxyAuth) ValidateRequest(r *http.Request) (*api.Principal, error) {
+	name := r.Header.Get(a.user)
+	if name == "" {
+		return nil, api.ErrUnauthenticated
+	}
+	p := &api.Principal{Name: name, Method: "proxy"}
+	for _, g := range strings.Split(r.Header.Get(a.groups), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			p.Groups = append(p.Groups, g)
+		}
+	}
+	return p, nil
+}
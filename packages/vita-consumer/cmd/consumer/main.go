@@ -2,19 +2,53 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/admin"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/agentconfig"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/agents"
 	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
 	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/compression"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/consistency"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/crd"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/decommission"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/diskguard"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/envelope"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/health"
 	"github.com/nchanged/vitakube/packages/vita-consumer/internal/ingest"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/jobs"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/lastseen"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/maintenance"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/persist"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/processes"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/provenance"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/pseudonym"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/querystats"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/report"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/retention"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/rollup"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/rules"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/sampling"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/sink"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/slo"
 	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
 	"github.com/nchanged/vitakube/packages/vita-consumer/internal/syncer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/tracing"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/usage"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/workload"
 )
 
 func main() {
@@ -28,6 +62,31 @@ func main() {
 	}
 	log.Printf("Using data directory: %s", dataDir)
 
+	// Everything on the data path reads time from one clock so harnesses
+	// can substitute a fake
+	clk := clock.Real
+
+	// LOW_FOOTPRINT targets edge/ARM single-node clusters (under ~128MB
+	// RSS): a smaller buffer, capped DuckDB, rollups as plain averages per
+	// flush, no rules/report analytics, slower informer resyncs, fewer workers
+	lowFootprint := os.Getenv("LOW_FOOTPRINT") == "true"
+	if lowFootprint {
+		if os.Getenv("GOMEMLIMIT") == "" {
+			debug.SetMemoryLimit(96 << 20)
+		}
+		log.Println("Low-footprint mode enabled")
+	}
+
+	// 0b. Tracing (opt-in through the standard OTEL_* variables)
+	shutdownTracing, tracingEnabled, err := tracing.Setup(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	if tracingEnabled {
+		store.EnableTracing()
+		log.Println("OpenTelemetry tracing enabled")
+	}
+
 	// 1. Initialize Stores
 	sqlite, err := store.NewSQLiteStore(filepath.Join(dataDir, "meta.db"))
 	if err != nil {
@@ -35,11 +94,61 @@ func main() {
 	}
 	defer sqlite.Close()
 
-	duck, err := store.NewDuckDBStore(filepath.Join(dataDir, "metrics.duckdb"))
+	// Check the hot catalog queries still hit indexes; SQLITE_AUTO_INDEX
+	// creates whatever is missing instead of only logging it
+	advice, err := sqlite.AdviseIndexes(os.Getenv("SQLITE_AUTO_INDEX") == "true")
+	if err != nil {
+		log.Printf("Index check failed: %v", err)
+	}
+	for _, a := range advice {
+		if a.Created {
+			log.Printf("Created missing index for %s: %s", a.Query, a.Index)
+		} else {
+			log.Printf("Query %q scans instead of using an index (plan: %s); suggested: %s", a.Query, a.Plan, a.Index)
+		}
+	}
+
+	// DUCKDB_MONTHLY_FILES=true splits metrics into metrics-YYYY-MM.duckdb
+	// files, so whole months can be archived or deleted (see the admin
+	// /internal/duckdb/months routes)
+	var duck *store.DuckDBStore
+	if os.Getenv("DUCKDB_MONTHLY_FILES") == "true" {
+		duck, err = store.NewMonthlyDuckDBStore(dataDir, clk.Now())
+	} else {
+		duck, err = store.NewDuckDBStore(filepath.Join(dataDir, "metrics.duckdb"))
+	}
 	if err != nil {
 		log.Fatalf("Failed to open DuckDB: %v", err)
 	}
 	defer duck.Close()
+	if lowFootprint {
+		if err := duck.SetResourceLimits(1, "64MB"); err != nil {
+			log.Printf("Failed to limit DuckDB resources: %v", err)
+		}
+	}
+
+	// 1b. Provenance: metrics and catalog rows are stamped with CLUSTER_ID,
+	// this consumer's CONSUMER_INSTANCE_ID (default: the hostname, i.e. the
+	// pod name) and the reporting agent's version
+	clusterID, instanceID := sourceIdentity()
+	sources, err := provenance.New(clusterID, instanceID, sqlite, duck, clk)
+	if err != nil {
+		log.Fatalf("Failed to record data source: %v", err)
+	}
+	log.Printf("Stamping data with cluster %q, instance %q (source %d)", clusterID, instanceID, sources.Local().ID)
+
+	// 1c. Consistency check before anything writes: broken catalog
+	// references and orphaned metrics left by a crash mid-write. By
+	// default they are only logged; CONSISTENCY_CHECK=repair fixes them,
+	// off skips the check.
+	checker := consistency.NewChecker(sqlite, duck)
+	switch mode := os.Getenv("CONSISTENCY_CHECK"); mode {
+	case "", "repair", "report":
+		checker.Run(context.Background(), "boot", mode == "repair")
+	case "off":
+	default:
+		log.Fatalf("Invalid CONSISTENCY_CHECK %q, want repair, report or off", mode)
+	}
 
 	// 2. Initialize Syncer
 	kubeConfig := os.Getenv("KUBECONFIG")
@@ -51,68 +160,628 @@ func main() {
 		kubeConfig = "" // Force in-cluster config
 	}
 
-	sync, err := syncer.NewResourceSyncer(kubeConfig, sqlite)
-	if err != nil {
-		log.Fatalf("Failed to create Syncer: %v", err)
+	// Connects in the background: without the API server, history is still
+	// served and ingest resolves the pods already in the catalog
+	sync := syncer.NewResourceSyncer(kubeConfig, sqlite)
+	sync.SetClock(clk)
+	if lowFootprint {
+		sync.SetResyncPeriod(time.Hour)
+	}
+	if spec := os.Getenv("OWNERSHIP_ANNOTATIONS"); spec != "" {
+		var keys []string
+		for _, k := range strings.Split(spec, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				keys = append(keys, k)
+			}
+		}
+		sync.SetOwnershipKeys(keys)
+	}
+	if spec := os.Getenv("NODEPOOL_LABELS"); spec != "" {
+		var keys []string
+		for _, k := range strings.Split(spec, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				keys = append(keys, k)
+			}
+		}
+		sync.SetNodepoolLabels(keys)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	// Periodic background work runs as named jobs of one scheduler, listed
+	// and controlled at /internal/jobs on the admin server
+	background := jobs.NewScheduler()
+	background.SetClock(clk)
+
+	// Replica history (workload_state series) and scheduling and startup
+	// latency per pod. Both subscribe losslessly: a dropped event would be
+	// a replica change or a pod's latencies never recorded.
+	replicaEvents, _ := sync.Changes().Subscribe("workload_state", 1024, true)
+	go workload.NewRecorder(duck, clk).Run(replicaEvents)
+	latencyEvents, _ := sync.Changes().Subscribe("pod_latency", 1024, true)
+	go workload.NewLatencyRecorder(sqlite, duck).Run(latencyEvents)
 
 	go sync.Start(ctx)
 
 	// 3. Initialize Buffer
-	ring := buffer.NewRingBuffer(10000) // Hold 10k metrics in RAM
+	ringSize := 10000 // Hold 10k metrics in RAM
+	if lowFootprint {
+		ringSize = 2000
+	}
+	ring := buffer.NewRingBuffer(ringSize)
+	ring.SetClock(clk)
+	// Under pressure best-effort types (custom metrics) are dropped first,
+	// then standard ones, keeping room for pod CPU and memory.
+	// BUFFER_METRIC_CLASSES overrides classes per type, e.g.
+	// "used_mb=critical,app_qps=standard".
+	classes, err := buffer.ParseClasses(os.Getenv("BUFFER_METRIC_CLASSES"))
+	if err != nil {
+		log.Fatalf("Invalid BUFFER_METRIC_CLASSES: %v", err)
+	}
+	ring.SetClasses(classes)
+	pipeline := persist.NewPipeline(ring)
+	pipeline.SetClock(clk)
+
+	// 3a. Running per-deployment totals for the live deployment cards
+	deploymentLive := rollup.NewDeploymentLive(sqlite)
+	deploymentLive.SetClock(clk)
+	ring.AddObserver(deploymentLive)
+	podEvents, _ := sync.Changes().Subscribe("deployment_live", 1024, false)
+	go deploymentLive.Run(podEvents)
+
+	// 3b. Last-seen per pod/PVC (staleness markers), seeded from history
+	seen := lastseen.New()
+	seen.SetClock(clk)
+	ring.AddObserver(seen)
+	// Newest value per series, for endpoints that only need current values
+	latest := persist.NewLatestSink(sqlite, duck, clk)
+	go func() {
+		if err := latest.Seed(context.Background()); err != nil {
+			log.Printf("Failed to seed latest values: %v", err)
+		}
+		if err := seen.Load(sqlite, clk.Now().Add(-24*time.Hour)); err != nil {
+			log.Printf("Failed to load last-seen times: %v", err)
+		}
+	}()
+
+	// 3c. Disk Guard: reject ingest and prune when DATA_DIR runs low, again
+	// with backoff while it stays low. The prune deletes every point, node
+	// total and process sample older than EMERGENCY_RETENTION_HOURS
+	// outright: rolling them up first would need the very space that is
+	// missing, so that history is lost rather than downsampled.
+	emergencyRetention := time.Duration(envInt("EMERGENCY_RETENTION_HOURS", 24)) * time.Hour
+	disk := diskguard.NewMonitor(dataDir, uint64(envInt("DISK_MIN_FREE_MB", 100))<<20, func() {
+		n, err := duck.DeleteBefore(clk.Now().Add(-emergencyRetention))
+		if err != nil {
+			log.Printf("Emergency prune failed: %v", err)
+			return
+		}
+		log.Printf("Emergency prune removed %d metrics older than %s", n, emergencyRetention)
+	})
+	disk.SetClock(clk)
+	go disk.Run(ctx)
+
+	// 3d. Retention: RETENTION_POLICY ("resolution=5m,retention=30d") for
+	// everything, replaced by vitakube.io/retention on namespaces and
+	// workloads. Unset keeps every point until the disk guard prunes.
+	policy, err := retention.ParsePolicy(os.Getenv("RETENTION_POLICY"), retention.Policy{})
+	if err != nil {
+		log.Fatalf("Invalid RETENTION_POLICY: %v", err)
+	}
+	enforcer := retention.NewEnforcer(sqlite, duck, retention.Config{
+		Default: policy,
+		Raw:     time.Duration(envInt("RETENTION_RAW_HOURS", 24)) * time.Hour,
+	})
+	background.Add(jobs.Job{Name: "retention", Interval: time.Hour, Jitter: 0.1, Immediate: true, Run: enforcer.RunOnce})
+
+	// API and ingest get their own muxes (nothing is served from
+	// http.DefaultServeMux, where pprof/expvar register themselves). With
+	// INGEST_ADDR set, ingest also gets its own listener, so agents can
+	// reach it cluster-internally while the API sits behind auth/ingress.
+	apiMux := http.NewServeMux()
+	ingestAddr := os.Getenv("INGEST_ADDR")
+	ingestMux := apiMux
+	if ingestAddr != "" {
+		ingestMux = http.NewServeMux()
+	}
+
+	// 4. Ingestion Server. UIDs resolve through the syncer's cache, then
+	// the catalog, then the API server (ID_RESOLVER_LAYERS, in that order).
+	// Low-footprint mode leaves out the API layer, as each lookup there
+	// lists every pod.
+	resolverCfg := syncer.ResolverConfig{
+		MissTTL:     time.Duration(envInt("ID_RESOLVER_MISS_TTL_SEC", 60)) * time.Second,
+		APIInterval: time.Duration(envInt("ID_RESOLVER_API_INTERVAL_SEC", 30)) * time.Second,
+	}
+	if layers := os.Getenv("ID_RESOLVER_LAYERS"); layers != "" {
+		resolverCfg.Layers = strings.Split(layers, ",")
+	} else if lowFootprint {
+		resolverCfg.Layers = []string{syncer.LayerCache, syncer.LayerSQLite}
+	}
+	resolver, err := sync.Resolver(resolverCfg)
+	if err != nil {
+		log.Fatalf("Invalid ID_RESOLVER_LAYERS: %v", err)
+	}
+	ingestion := ingest.NewIngestionServer(ring, resolver)
+	ingestion.SetDiskGuard(disk)
+	ingestion.SetMaxBodyBytes(int64(envInt("INGEST_MAX_BODY_MB", 8)) << 20)
+	ingestion.SetClock(clk)
+	ingestion.SetSources(sources)
+	// Samples older than this (an agent catching up after an outage) skip
+	// the live buffer and are queued for DuckDB directly
+	if lateMin := envInt("LATE_METRIC_MINUTES", 5); lateMin > 0 {
+		ingestion.SetBackfill(pipeline, time.Duration(lateMin)*time.Minute)
+	}
+	// Samples older than LATE_METRIC_MAX_HOURS (default a week; 0 keeps
+	// all) are dropped: a backlog that old, or an agent's clock that far
+	// off, is not worth a backfill
+	if maxHours := envInt("LATE_METRIC_MAX_HOURS", 7*24); maxHours > 0 {
+		ingestion.SetHorizon(time.Duration(maxHours) * time.Hour)
+	}
+	// INGEST_VERIFY_NODE_ADDRESS=true: posts must come from an address of
+	// the node they report for, so one node cannot report for another
+	if os.Getenv("INGEST_VERIFY_NODE_ADDRESS") == "true" {
+		ingestion.SetNodeVerification(sync)
+	}
+	// Agent approval (AGENT_APPROVAL=manual or auto): posts from agents
+	// not approved yet are refused
+	if mode := os.Getenv("AGENT_APPROVAL"); mode != "" && mode != "off" {
+		registry, err := agents.NewRegistry(sqlite, mode)
+		if err != nil {
+			log.Fatalf("Invalid AGENT_APPROVAL: %v", err)
+		}
+		registry.SetClock(clk)
+		registry.RegisterRoutes(apiMux)
+		ingestion.SetRegistry(registry)
+	}
+
+	// 4a. Datapoints per namespace/node and hour, with optional quotas
+	accountant := usage.New(sqlite)
+	accountant.SetClock(clk)
+	accountant.SetQuotas(usage.Quotas{
+		NamespacePerHour: int64(envInt("INGEST_QUOTA_NAMESPACE_PER_HOUR", 0)),
+		NodePerHour:      int64(envInt("INGEST_QUOTA_NODE_PER_HOUR", 0)),
+	})
+	ingestion.SetAccountant(accountant)
+	go accountant.Run(ctx)
 
-	// 4. Ingestion Server
-	ingestion := ingest.NewIngestionServer(ring, sync)
-	http.HandleFunc("/api/v1/ingest", ingestion.HandleIngest)
+	// Over INGEST_BUDGET_PER_MINUTE points, best-effort series keep 1 in N
+	// points, marked with N when stored
+	if budget := envInt("INGEST_BUDGET_PER_MINUTE", 0); budget > 0 {
+		sampler := sampling.New(int64(budget), ring)
+		sampler.SetClock(clk)
+		ingestion.SetSampler(sampler)
+		log.Printf("Ingest budget %d points per minute, best-effort series are sampled beyond it", budget)
+	}
+
+	// 4b. Optional per-process samples, off by default for their volume
+	processMetrics := os.Getenv("PROCESS_METRICS") == "true"
+	if processMetrics {
+		recorder := processes.New(duck, processes.Config{
+			TopN:      envInt("PROCESS_METRICS_TOP_N", 10),
+			Retention: time.Duration(envInt("PROCESS_METRICS_RETENTION_HOURS", 24)) * time.Hour,
+		})
+		recorder.SetClock(clk)
+		ingestion.SetProcessRecorder(recorder)
+		go recorder.Run(ctx)
+		background.Add(jobs.Job{Name: "process-prune", Interval: time.Hour, Jitter: 0.1, Immediate: true, Run: recorder.Prune})
+	}
+	workers, queue := runtime.NumCPU(), 256
+	if lowFootprint {
+		workers, queue = 1, 32
+	}
+	ingestion.StartWorkers(ctx, envInt("INGEST_WORKERS", workers), envInt("INGEST_QUEUE", queue))
+	ingestMux.HandleFunc("/api/v1/ingest", ingestion.HandleIngest)
+	ingestMux.HandleFunc("/api/v1/ingest/capabilities", ingestion.HandleCapabilities)
+
+	// 4c. Optional stream sink (tee ingested batches to Kafka / NATS)
+	if sinkType := os.Getenv("SINK_TYPE"); sinkType != "" {
+		out, err := sink.New(sink.Config{
+			Type:     sinkType,
+			URL:      os.Getenv("SINK_URL"),
+			Topic:    os.Getenv("SINK_TOPIC"),
+			Encoding: os.Getenv("SINK_ENCODING"),
+			Queue:    envInt("SINK_QUEUE", 1024),
+		})
+		if err != nil {
+			log.Fatalf("Failed to create %s sink: %v", sinkType, err)
+		}
+		ingestion.SetSink(out)
+		go out.Run(ctx)
+	}
+
+	// 4d. Agent settings: agents poll theirs next to ingest, operators
+	// manage them through the API
+	agentConfigs := agentconfig.NewServer(sqlite)
+	ingestMux.HandleFunc("/api/v1/agent-config", agentConfigs.HandleAgentConfig)
+	agentConfigs.RegisterRoutes(apiMux)
 
 	// 5. API Server (Dashboard Endpoints)
-	apiServer := api.NewServer(sqlite, ring)
-	apiServer.RegisterRoutes(http.DefaultServeMux)
+	apiServer := api.NewServer(sqlite, duck, ring, sync)
+	apiServer.SetClock(clk)
+	apiServer.SetLocalSource(sources.Local())
+	apiServer.SetDeploymentTotals(deploymentLive)
+	apiServer.SetLastSeen(seen)
+	apiServer.SetCatalogCache(sync)
+	apiServer.SetClusterStatus(sync)
+	apiServer.SetProcessMetrics(processMetrics)
+	// /status is public: counts only, cached so wallboards cannot load
+	// the stores. STATUS_PAGE=false removes it.
+	if os.Getenv("STATUS_PAGE") != "false" {
+		apiServer.SetStatusPage(api.StatusPage{
+			CacheFor: time.Duration(envInt("STATUS_PAGE_CACHE_SEC", 30)) * time.Second,
+			Disk:     disk,
+		})
+	}
+	// API authentication (AUTH_MODE): none, token (AUTH_TOKENS as
+	// name=token pairs), oidc, or a custom authenticator compiled in with
+	// a build tag (see auth_proxy.go)
+	authCfg := api.AuthConfig{
+		Mode: os.Getenv("AUTH_MODE"),
+		OIDC: api.OIDCConfig{
+			IssuerURL:     os.Getenv("OIDC_ISSUER_URL"),
+			Audience:      os.Getenv("OIDC_AUDIENCE"),
+			UsernameClaim: os.Getenv("OIDC_USERNAME_CLAIM"),
+			GroupsClaim:   os.Getenv("OIDC_GROUPS_CLAIM"),
+		},
+	}
+	if authCfg.Tokens, err = api.ParseTokens(os.Getenv("AUTH_TOKENS")); err != nil {
+		log.Fatalf("Invalid AUTH_TOKENS: %v", err)
+	}
+	auth, err := api.NewAuthenticator(authCfg)
+	if err != nil {
+		log.Fatalf("Failed to set up authentication: %v", err)
+	}
+	_, noAuth := auth.(api.NoAuth)
 
-	// 5. Persist Worker (The Cold Path)
-	go func() {
-		ticker := time.NewTicker(60 * time.Second)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				data := ring.Flush()
-				if len(data) > 0 {
-					log.Printf("Flushing %d metrics to DuckDB...", len(data))
-
-					points := make([]store.MetricPoint, len(data))
-					for i, m := range data {
-						points[i] = store.MetricPoint{
-							Time:       m.Time,
-							ResourceID: m.ResourceID,
-							MetricType: m.Type,
-							Value:      m.Value,
-						}
-					}
-
-					if err := duck.BatchInsert(points); err != nil {
-						log.Printf("Error flushing to DuckDB: %v", err)
-					}
+	// Pod logs are only served to authenticated callers: a bearer token,
+	// the user an authenticating proxy in front of the API forwards, or
+	// whoever the API authenticator admitted
+	logsAuth := api.PodLogsAuth{
+		Token:          os.Getenv("POD_LOGS_TOKEN"),
+		TrustProxyUser: os.Getenv("POD_LOGS_PROXY_AUTH") == "true",
+		Authenticated:  !noAuth,
+	}
+	if logsAuth.Enabled() {
+		apiServer.SetPodLogs(sync, logsAuth)
+	}
+	apiServer.SetFlusher(pipeline)
+	// Points still buffered when a resource was purged are deleted later
+	background.Add(jobs.Job{Name: "purge-sweep", Interval: time.Minute, Jitter: 0.1, Immediate: true, Run: apiServer.SweepPurged})
+	// Series over SERIES_MAX_POINTS are widened to a coarser step, or paged
+	// when requested at full resolution
+	apiServer.SetMaxPoints(envInt("SERIES_MAX_POINTS", 10000))
+	// Workload health scores (restarts, OOMs, throttling, saturation,
+	// containers stuck on errors) run DuckDB queries every interval, so
+	// low-footprint mode leaves them out unless HEALTH_SCORES=true
+	var scorer *health.Scorer
+	if healthScores := os.Getenv("HEALTH_SCORES"); healthScores == "true" || healthScores == "" && !lowFootprint {
+		scorer = health.NewScorer(sqlite, duck, health.Config{
+			Interval:  time.Duration(envInt("HEALTH_SCORE_INTERVAL_SEC", 300)) * time.Second,
+			Retention: time.Duration(envInt("HEALTH_SCORE_RETENTION_DAYS", 7)) * 24 * time.Hour,
+		})
+		background.Add(jobs.Job{Name: "health-scores", Interval: scorer.Interval(), Jitter: 0.1, Immediate: true, Run: scorer.RunOnce})
+		apiServer.SetWorkloadHealth(true)
+	}
+	apiServer.RegisterRoutes(apiMux)
+
+	// 5. Persist Pipeline (The Cold Path). Late metrics reach DuckDB
+	// without passing through the ring buffer.
+	pipeline.Register(persist.NewDuckDBSink(duck), persist.SinkOptions{AcceptLate: true})
+	pipeline.Register(latest, persist.SinkOptions{AcceptLate: true})
+	nodeTotals := rollup.NewNodeTotals(sqlite)
+	if lowFootprint {
+		nodeTotals.SetSimple()
+	}
+	pipeline.Register(persist.NewTotalsSink(duck, nodeTotals, clk), persist.SinkOptions{})
+	if dir := os.Getenv("PARQUET_EXPORT_DIR"); dir != "" {
+		parquet, err := persist.NewParquetSink(dir)
+		if err != nil {
+			log.Fatalf("Failed to create Parquet export: %v", err)
+		}
+		// Exported files leave the node, so they can be envelope-encrypted
+		// with a key file or a Vault transit key
+		if kw, err := exportKey(); err != nil {
+			log.Fatalf("Invalid Parquet export encryption: %v", err)
+		} else if kw != nil {
+			parquet.SetEncryption(kw)
+			log.Printf("Parquet exports encrypted with key %s", kw.ID())
+		}
+		parquet.SetSources(sources)
+		// One file per interval; hold a few intervals' worth across failures
+		interval := time.Duration(envInt("PARQUET_EXPORT_INTERVAL_MIN", 15)) * time.Minute
+		pipeline.Register(parquet, persist.SinkOptions{
+			Interval:   interval,
+			MaxPending: ring.Cap() * int(interval/persist.Interval) * 4,
+		})
+	}
+	if url := os.Getenv("REMOTE_WRITE_URL"); url != "" {
+		labels, err := persist.ParseLabels(os.Getenv("REMOTE_WRITE_LABELS"))
+		if err != nil {
+			log.Fatalf("Invalid REMOTE_WRITE_LABELS: %v", err)
+		}
+		// Names can be replaced by keyed hashes before they leave the
+		// cluster; give each export its own key
+		var pseudonyms *pseudonym.Hasher
+		var hashLabels []string
+		if path := os.Getenv("REMOTE_WRITE_PSEUDONYM_KEY_FILE"); path != "" {
+			if pseudonyms, err = pseudonym.LoadKeyFile(path); err != nil {
+				log.Fatalf("Invalid REMOTE_WRITE_PSEUDONYM_KEY_FILE: %v", err)
+			}
+			hashLabels = persist.DefaultPseudonymLabels
+			if spec := os.Getenv("REMOTE_WRITE_PSEUDONYM_LABELS"); spec != "" {
+				hashLabels = strings.Split(spec, ",")
+				for i := range hashLabels {
+					hashLabels[i] = strings.TrimSpace(hashLabels[i])
 				}
 			}
+			log.Printf("Remote write pseudonymizes labels %s", strings.Join(hashLabels, ","))
 		}
-	}()
+		pipeline.Register(persist.NewRemoteWriteSink(sqlite, persist.RemoteWriteConfig{
+			URL:             url,
+			Username:        os.Getenv("REMOTE_WRITE_USERNAME"),
+			Password:        os.Getenv("REMOTE_WRITE_PASSWORD"),
+			BearerToken:     os.Getenv("REMOTE_WRITE_BEARER_TOKEN"),
+			TenantID:        os.Getenv("REMOTE_WRITE_TENANT"),
+			ExternalLabels:  labels,
+			Pseudonyms:      pseudonyms,
+			PseudonymLabels: hashLabels,
+		}), persist.SinkOptions{MaxBatch: envInt("REMOTE_WRITE_MAX_SAMPLES", 2000)})
+	}
+	go pipeline.Run(ctx)
 
-	// 6. Start HTTP Server
-	go func() {
-		log.Println("Starting Consumer on :8080")
-		if err := http.ListenAndServe(":8080", nil); err != nil {
-			log.Fatalf("HTTP Server failed: %v", err)
+	// 6. Start HTTP Servers. The API has no write timeout: watch streams
+	// stay open.
+	apiAddr := os.Getenv("API_ADDR")
+	if apiAddr == "" {
+		apiAddr = ":8080"
+	}
+	// /status stays public, and so does ingest when it shares the API
+	// listener: agents carry no user credentials
+	public := []string{"/status"}
+	if ingestAddr == "" {
+		public = append(public, "/api/v1/ingest", "/api/v1/ingest/capabilities", "/api/v1/agent-config")
+	}
+	// Responses are compressed by Accept-Encoding; API_COMPRESSION lists
+	// the encodings offered in order of preference, or "off"
+	encodings, err := compression.ParseEncodings(os.Getenv("API_COMPRESSION"))
+	if err != nil {
+		log.Fatalf("Invalid API_COMPRESSION: %v", err)
+	}
+	compressed := compression.Handler(api.Authenticate(auth, apiMux, public...), compression.Config{
+		Encodings: encodings,
+		MinSize:   envInt("API_COMPRESSION_MIN_BYTES", compression.DefaultMinSize),
+	})
+	apiHTTP := newServer(apiAddr, instrument(compressed, tracingEnabled))
+	apiHTTP.ReadTimeout = time.Duration(envInt("API_READ_TIMEOUT_SEC", 30)) * time.Second
+	go serve("Consumer", apiHTTP)
+	if ingestAddr != "" {
+		ingestHTTP := newServer(ingestAddr, instrument(ingestMux, tracingEnabled))
+		ingestHTTP.ReadTimeout = time.Duration(envInt("INGEST_READ_TIMEOUT_SEC", 30)) * time.Second
+		ingestHTTP.WriteTimeout = time.Duration(envInt("INGEST_WRITE_TIMEOUT_SEC", 10)) * time.Second
+		go serve("Ingest", ingestHTTP)
+	}
+
+	// 7. Maintenance (VACUUM / CHECKPOINT in a low-traffic window)
+	windowSpec := os.Getenv("MAINTENANCE_WINDOW")
+	if windowSpec == "" {
+		windowSpec = "03:00-04:00"
+	}
+	window, err := maintenance.ParseWindow(windowSpec)
+	if err != nil {
+		log.Fatalf("Invalid MAINTENANCE_WINDOW: %v", err)
+	}
+	maint := maintenance.NewScheduler(sqlite, duck, window)
+	background.Add(jobs.Job{Name: "maintenance", Interval: time.Minute, Run: maint.RunScheduled})
+	// Nodes deleted and silent for NODE_DECOMMISSION_DAYS are hidden from
+	// node lists; 0 keeps them forever
+	if days := envInt("NODE_DECOMMISSION_DAYS", 7); days > 0 {
+		reaper := decommission.NewReaper(sqlite, duck, decommission.Config{
+			After:        time.Duration(days) * 24 * time.Hour,
+			PurgeMetrics: os.Getenv("NODE_DECOMMISSION_PURGE") == "true",
+		})
+		background.Add(jobs.Job{Name: "node-decommission", Interval: time.Hour, Jitter: 0.1, Immediate: true, Run: reaper.RunOnce})
+	}
+
+	// 7b. Scheduled reports (SMTP delivery is optional; webhooks need no config)
+	// 7c. recording rules (derived series written back to DuckDB) and 7d.
+	// saturation SLOs (rolled up from DuckDB, with burn alerts). All run
+	// DuckDB analytics, so low-footprint mode leaves them out.
+	if !lowFootprint {
+		reports := report.NewScheduler(sqlite, duck, report.SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     envInt("SMTP_PORT", 587),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		})
+		reports.RegisterRoutes(apiMux)
+		// Every minute without jitter: a minute skipped misses its reports
+		background.Add(jobs.Job{Name: "reports", Interval: time.Minute, Run: reports.RunDue})
+
+		recording := rules.NewEngine(sqlite, duck)
+		recording.RegisterRoutes(apiMux)
+		background.Add(jobs.Job{Name: "recording-rules", Interval: 15 * time.Second, Jitter: 0.1, Immediate: true, Run: recording.EvaluateDue})
+
+		slos := slo.NewEngine(sqlite, duck)
+		slos.RegisterRoutes(apiMux)
+		background.Add(jobs.Job{Name: "slos", Interval: time.Minute, Jitter: 0.1, Immediate: true, Run: slos.Evaluate})
+
+		// 7e. Rules and reports declared as VitaRule / VitaReport resources
+		if os.Getenv("CRD_CONFIG") == "true" {
+			controller, err := crd.Connect(kubeConfig, sqlite)
+			if err != nil {
+				log.Fatalf("Failed to start the VitaRule/VitaReport controller: %v", err)
+			}
+			go controller.Run(ctx)
 		}
-	}()
+	}
+	go background.Run(ctx)
+
+	// 8. Admin Server (opt-in: pprof, expvar, debug state, maintenance)
+	if adminAddr := os.Getenv("ADMIN_ADDR"); adminAddr != "" {
+		adminMux := http.NewServeMux()
+		adminServer := admin.NewServer(sync, ring)
+		adminServer.SetResolver(resolver)
+		adminServer.SetStores(sqlite, duck)
+		adminServer.SetIngestErrors(ingestion)
+		adminServer.SetConfigKeys(supportConfigKeys)
+		adminServer.RegisterRoutes(adminMux)
+		maint.RegisterRoutes(adminMux)
+		checker.RegisterRoutes(adminMux)
+		background.RegisterRoutes(adminMux)
+		querystats.RegisterRoutes(adminMux)
+
+		// No write timeout, as for the API: pprof profiles and traces run
+		// for as long as they are asked to
+		go serve("Admin", newServer(adminAddr, adminMux))
+	}
 
 	// Wait for signal
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 	<-sig
 	log.Println("Shutting down...")
+
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
+}
+
+// instrument adds query stats and, when enabled, tracing to a listener's
+// handler
+func instrument(h http.Handler, tracingEnabled bool) http.Handler {
+	handler := querystats.Handler(h)
+	if tracingEnabled {
+		handler = tracing.Handler(handler)
+	}
+	return handler
+}
+
+// newServer returns a server tuned for hundreds of agents holding
+// keep-alive connections: bounded header reads, idle connections reaped
+// after HTTP_IDLE_TIMEOUT_SEC, small headers, and HTTP/2 over cleartext
+// (h2c) so an agent can multiplex its posts over one connection.
+func newServer(addr string, handler http.Handler) *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(envInt("HTTP_READ_HEADER_TIMEOUT_SEC", 10)) * time.Second,
+		IdleTimeout:       time.Duration(envInt("HTTP_IDLE_TIMEOUT_SEC", 120)) * time.Second,
+		MaxHeaderBytes:    envInt("HTTP_MAX_HEADER_KB", 64) << 10,
+		Protocols:         protocols,
+		HTTP2: &http.HTTP2Config{
+			MaxConcurrentStreams: envInt("HTTP2_MAX_STREAMS", 250),
+		},
+	}
+}
+
+// serve runs srv until it fails; the consumer is useless without it
+func serve(name string, srv *http.Server) {
+	log.Printf("Starting %s on %s", name, srv.Addr)
+	if err := srv.ListenAndServe(); err != nil {
+		log.Fatalf("%s server failed: %v", name, err)
+	}
+}
+
+// supportConfigKeys are the settings support bundles include; the admin
+// server redacts credentials among them
+var supportConfigKeys = []string{
+	"ADMIN_ADDR", "AGENT_APPROVAL", "API_ADDR", "API_COMPRESSION", "API_COMPRESSION_MIN_BYTES", "API_READ_TIMEOUT_SEC", "AUTH_MODE",
+	"AUTH_PROXY_GROUPS_HEADER", "AUTH_PROXY_USER_HEADER", "AUTH_TOKENS", "BUFFER_METRIC_CLASSES",
+	"CLUSTER_ID", "CONSISTENCY_CHECK", "CONSUMER_INSTANCE_ID", "CRD_CONFIG", "DATA_DIR", "DISK_MIN_FREE_MB", "DUCKDB_MONTHLY_FILES",
+	"EMERGENCY_RETENTION_HOURS", "GOMEMLIMIT", "HEALTH_SCORES", "HEALTH_SCORE_INTERVAL_SEC",
+	"HEALTH_SCORE_RETENTION_DAYS", "HTTP2_MAX_STREAMS", "HTTP_IDLE_TIMEOUT_SEC", "HTTP_MAX_HEADER_KB",
+	"HTTP_READ_HEADER_TIMEOUT_SEC", "ID_RESOLVER_API_INTERVAL_SEC", "ID_RESOLVER_LAYERS",
+	"ID_RESOLVER_MISS_TTL_SEC", "INGEST_ADDR", "INGEST_BUDGET_PER_MINUTE", "INGEST_MAX_BODY_MB",
+	"INGEST_QUEUE", "INGEST_QUOTA_NAMESPACE_PER_HOUR", "INGEST_QUOTA_NODE_PER_HOUR",
+	"INGEST_READ_TIMEOUT_SEC", "INGEST_VERIFY_NODE_ADDRESS", "INGEST_WORKERS",
+	"INGEST_WRITE_TIMEOUT_SEC", "KUBECONFIG", "KUBERNETES_SERVICE_HOST", "LATE_METRIC_MAX_HOURS", "LATE_METRIC_MINUTES",
+	"LOW_FOOTPRINT", "MAINTENANCE_WINDOW", "NODE_DECOMMISSION_DAYS", "NODE_DECOMMISSION_PURGE",
+	"NODEPOOL_LABELS",
+	"OIDC_AUDIENCE", "OIDC_GROUPS_CLAIM", "OIDC_ISSUER_URL", "OIDC_USERNAME_CLAIM",
+	"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_SERVICE_NAME",
+	"OWNERSHIP_ANNOTATIONS",
+	"PARQUET_ENCRYPTION_KEY_FILE", "PARQUET_ENCRYPTION_VAULT_ADDR", "PARQUET_ENCRYPTION_VAULT_KEY",
+	"PARQUET_ENCRYPTION_VAULT_MOUNT", "PARQUET_EXPORT_DIR", "PARQUET_EXPORT_INTERVAL_MIN",
+	"POD_LOGS_PROXY_AUTH", "POD_LOGS_TOKEN", "PROCESS_METRICS", "PROCESS_METRICS_RETENTION_HOURS",
+	"PROCESS_METRICS_TOP_N", "REMOTE_WRITE_BEARER_TOKEN", "REMOTE_WRITE_LABELS",
+	"REMOTE_WRITE_MAX_SAMPLES", "REMOTE_WRITE_PASSWORD", "REMOTE_WRITE_PSEUDONYM_KEY_FILE",
+	"REMOTE_WRITE_PSEUDONYM_LABELS", "REMOTE_WRITE_TENANT", "REMOTE_WRITE_URL",
+	"REMOTE_WRITE_USERNAME", "RETENTION_POLICY", "RETENTION_RAW_HOURS", "SERIES_MAX_POINTS", "SINK_ENCODING", "SINK_QUEUE", "SINK_TOPIC",
+	"SINK_TYPE", "SINK_URL", "SMTP_FROM", "SMTP_HOST", "SMTP_PASSWORD", "SMTP_PORT", "SMTP_USERNAME",
+	"SQLITE_AUTO_INDEX", "STATUS_PAGE", "STATUS_PAGE_CACHE_SEC", "STORE_FAULTS", "VAULT_TOKEN",
+}
+
+// envInt reads an integer from the environment, falling back to def
+func envInt(key string, def int) int {
+	val := os.Getenv(key)
+	if val == "" {
+		return def
+	}
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using default %d", key, val, def)
+		return def
+	}
+	return i
+}
+
+// sourceIdentity reads the cluster and instance ids stamped on stored data.
+// Without CLUSTER_ID the cluster is "default"; set it when data from
+// several clusters meets in one place.
+func sourceIdentity() (string, string) {
+	cluster := os.Getenv("CLUSTER_ID")
+	if cluster == "" {
+		cluster = "default"
+	}
+	instance := os.Getenv("CONSUMER_INSTANCE_ID")
+	if instance == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			log.Printf("Failed to read hostname for the instance id: %v", err)
+			host = "consumer"
+		}
+		instance = host
+	}
+	return cluster, instance
+}
+
+// exportKey reads the Parquet export encryption settings: a key file
+// (PARQUET_ENCRYPTION_KEY_FILE), or a Vault transit key
+// (PARQUET_ENCRYPTION_VAULT_ADDR, _VAULT_KEY, _VAULT_MOUNT and VAULT_TOKEN).
+// A wrap and unwrap round trip checks the key before the first export.
+func exportKey() (envelope.KeyWrapper, error) {
+	var kw envelope.KeyWrapper
+	if path := os.Getenv("PARQUET_ENCRYPTION_KEY_FILE"); path != "" {
+		k, err := envelope.LoadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		kw = k
+	} else if addr := os.Getenv("PARQUET_ENCRYPTION_VAULT_ADDR"); addr != "" {
+		key := os.Getenv("PARQUET_ENCRYPTION_VAULT_KEY")
+		if key == "" {
+			return nil, fmt.Errorf("PARQUET_ENCRYPTION_VAULT_KEY is required with PARQUET_ENCRYPTION_VAULT_ADDR")
+		}
+		kw = envelope.NewVaultTransit(addr, os.Getenv("PARQUET_ENCRYPTION_VAULT_MOUNT"), key, os.Getenv("VAULT_TOKEN"))
+	} else {
+		return nil, nil
+	}
+
+	probe := make([]byte, 32)
+	wrapped, err := kw.Wrap(probe)
+	if err == nil {
+		_, err = kw.Unwrap(wrapped)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", kw.ID(), err)
+	}
+	return kw, nil
 }
@@ -0,0 +1,64 @@
+// Command decrypt restores Parquet exports encrypted by the consumer
+// (PARQUET_ENCRYPTION_*), writing each FILE.parquet.enc next to it as
+// FILE.parquet:
+//
+//	go run ./cmd/decrypt -key-file key.bin exports/*.enc
+//	VAULT_TOKEN=... go run ./cmd/decrypt -vault-addr https://vault:8200 -vault-key vitakube exports/*.enc
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/envelope"
+)
+
+func main() {
+	keyFile := flag.String("key-file", "", "key file the exports were encrypted with")
+	vaultAddr := flag.String("vault-addr", "", "Vault address, for exports encrypted with a transit key")
+	vaultMount := flag.String("vault-mount", "transit", "mount path of the transit engine")
+	vaultKey := flag.String("vault-key", "", "name of the transit key")
+	outDir := flag.String("out", "", "directory for the decrypted files (default: next to each input)")
+	flag.Parse()
+
+	var kw envelope.KeyWrapper
+	switch {
+	case *keyFile != "":
+		k, err := envelope.LoadKeyFile(*keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		kw = k
+	case *vaultAddr != "" && *vaultKey != "":
+		kw = envelope.NewVaultTransit(*vaultAddr, *vaultMount, *vaultKey, os.Getenv("VAULT_TOKEN"))
+	default:
+		log.Fatal("need -key-file, or -vault-addr and -vault-key")
+	}
+	if flag.NArg() == 0 {
+		log.Fatal("no files given")
+	}
+
+	failed := false
+	for _, src := range flag.Args() {
+		dst := strings.TrimSuffix(src, envelope.Ext)
+		if dst == src {
+			dst = src + ".dec"
+		}
+		if *outDir != "" {
+			dst = filepath.Join(*outDir, filepath.Base(dst))
+		}
+		if err := envelope.DecryptFile(src, dst, kw); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", src, err)
+			failed = true
+			continue
+		}
+		fmt.Println(dst)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,214 @@
+// Command loadgen simulates a fleet of agents posting to a consumer and
+// reports achieved throughput, error rates and request latency, to establish
+// the consumer's capacity limits per release.
+//
+// Pod UIDs are synthetic, so metrics resolve to no catalog resource; the
+// ingest, buffer and flush paths are exercised all the same.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/ingest"
+)
+
+func main() {
+	url := flag.String("url", "http://localhost:8080/api/v1/ingest", "consumer ingest URL")
+	nodes := flag.Int("nodes", 10, "simulated nodes (one poster each)")
+	pods := flag.Int("pods", 30, "pods per node")
+	interval := flag.Duration("interval", time.Second, "post interval per node")
+	duration := flag.Duration("duration", time.Minute, "test duration")
+	timeout := flag.Duration("timeout", 10*time.Second, "per-request timeout")
+	h2c := flag.Bool("h2c", false, "post over HTTP/2 cleartext instead of HTTP/1.1")
+	flag.Parse()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	client := &http.Client{Timeout: *timeout}
+	if *h2c {
+		protocols := new(http.Protocols)
+		protocols.SetUnencryptedHTTP2(true)
+		client.Transport = &http.Transport{Protocols: protocols}
+	}
+	st := &stats{statuses: make(map[string]int)}
+
+	log.Printf("loadgen: %d nodes x %d pods every %s for %s against %s", *nodes, *pods, *interval, *duration, *url)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for n := 0; n < *nodes; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			node := newNode(n, *pods)
+			// Spread nodes over the interval like real agents
+			offset := time.Duration(int64(*interval) * int64(n) / int64(*nodes))
+			select {
+			case <-time.After(offset):
+			case <-ctx.Done():
+				return
+			}
+
+			ticker := time.NewTicker(*interval)
+			defer ticker.Stop()
+			for {
+				node.post(ctx, client, *url, st)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}(n)
+	}
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				st.progress(time.Since(start))
+			}
+		}
+	}()
+
+	wg.Wait()
+	st.report(time.Since(start))
+}
+
+// node is one simulated agent with a fixed set of pods
+type node struct {
+	name    string
+	slices  []string
+	cpu     []float64
+	payload bytes.Buffer
+}
+
+func newNode(n, pods int) *node {
+	nd := &node{name: fmt.Sprintf("loadgen-node-%d", n), cpu: make([]float64, pods)}
+	for p := 0; p < pods; p++ {
+		uid := fmt.Sprintf("%08x-0000-4000-8000-%012x", n, p)
+		nd.slices = append(nd.slices, "kubepods-burstable-pod"+strings.ReplaceAll(uid, "-", "_")+".slice")
+	}
+	return nd
+}
+
+func (nd *node) post(ctx context.Context, client *http.Client, url string, st *stats) {
+	now := time.Now().Unix()
+	req := ingest.IngestRequest{Version: ingest.CurrentVersion, NodeName: nd.name, Metrics: make([]ingest.RawMetric, 0, len(nd.slices)*3)}
+	for i, slice := range nd.slices {
+		nd.cpu[i] += 250 + float64(i%7)*10
+		req.Metrics = append(req.Metrics,
+			ingest.RawMetric{Type: "container", PodID: slice, Key: "cpu_ms", Value: nd.cpu[i], Timestamp: now},
+			ingest.RawMetric{Type: "container", PodID: slice, Key: "mem_mb", Value: 128 + float64(i%50), Timestamp: now},
+			ingest.RawMetric{Type: "container", PodID: slice, Key: "mem_limit_mb", Value: 512, Timestamp: now},
+		)
+	}
+
+	nd.payload.Reset()
+	if err := json.NewEncoder(&nd.payload).Encode(req); err != nil {
+		log.Fatal(err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(nd.payload.Bytes()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	t0 := time.Now()
+	resp, err := client.Do(httpReq)
+	latency := time.Since(t0)
+	if ctx.Err() != nil {
+		return // test over; don't count the cancelled request
+	}
+	if err != nil {
+		st.record("error", 0, latency)
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	accepted := 0
+	if resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusOK {
+		accepted = len(req.Metrics)
+	}
+	st.record(fmt.Sprint(resp.StatusCode), accepted, latency)
+}
+
+type stats struct {
+	mu        sync.Mutex
+	requests  int
+	failed    int
+	metrics   int
+	statuses  map[string]int
+	latencies []time.Duration
+}
+
+func (s *stats) record(status string, accepted int, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	s.statuses[status]++
+	if accepted == 0 {
+		s.failed++
+	}
+	s.metrics += accepted
+	s.latencies = append(s.latencies, latency)
+}
+
+func (s *stats) progress(elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	log.Printf("%s: %d requests, %.0f metrics/s, %d failed", elapsed.Round(time.Second), s.requests, float64(s.metrics)/elapsed.Seconds(), s.failed)
+}
+
+func (s *stats) report(elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	pct := func(p float64) time.Duration {
+		if len(s.latencies) == 0 {
+			return 0
+		}
+		return s.latencies[int(p*float64(len(s.latencies)-1))]
+	}
+
+	codes := make([]string, 0, len(s.statuses))
+	for code, n := range s.statuses {
+		codes = append(codes, fmt.Sprintf("%s=%d", code, n))
+	}
+	sort.Strings(codes)
+
+	errRate := 0.0
+	if s.requests > 0 {
+		errRate = float64(s.failed) / float64(s.requests) * 100
+	}
+
+	fmt.Printf("elapsed:      %s\n", elapsed.Round(time.Millisecond))
+	fmt.Printf("requests:     %d (%.1f/s)\n", s.requests, float64(s.requests)/elapsed.Seconds())
+	fmt.Printf("metrics:      %d accepted (%.0f/s)\n", s.metrics, float64(s.metrics)/elapsed.Seconds())
+	fmt.Printf("errors:       %d (%.2f%%)\n", s.failed, errRate)
+	fmt.Printf("status codes: %s\n", strings.Join(codes, " "))
+	fmt.Printf("latency:      p50=%s p90=%s p99=%s max=%s\n", pct(0.5), pct(0.9), pct(0.99), pct(1))
+}
@@ -0,0 +1,300 @@
+// Command replay feeds a time range of stored metrics back through the
+// ingest pipeline, for reproducing live-aggregation bugs and exercising
+// query code with realistic data.
+//
+// Point it at a copy of a consumer DATA_DIR (DuckDB allows one writer).
+// Points are re-encoded as agent payloads using the pod/PVC UIDs from
+// meta.db, then either POSTed to -target or ingested in-process, in which
+// case the dashboard API is served on -listen over the replayed buffer.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/ingest"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// Metric types the agent reports; derived and workload series are skipped
+var (
+	podTypes = map[string]bool{"cpu_ms": true, "mem_mb": true, "mem_limit_mb": true,
+		"cpu_throttled_ms": true, "cpu_periods": true, "cpu_throttled_periods": true,
+		"mem_working_set_mb": true, "mem_rss_mb": true, "mem_cache_mb": true, "mem_swap_mb": true}
+	pvcTypes = map[string]bool{"total_mb": true, "used_mb": true, "free_mb": true}
+)
+
+// maxBatch keeps payloads well below the ingest body limit
+const maxBatch = 5000
+
+func main() {
+	dataDir := flag.String("data", ".data", "directory holding metrics.duckdb and meta.db (use a copy)")
+	fromFlag := flag.String("from", "", "start of range, RFC3339 or unix seconds (default: to - 1h)")
+	toFlag := flag.String("to", "", "end of range, RFC3339 or unix seconds (default: now)")
+	speed := flag.Float64("speed", 1, "replay speed multiplier; 0 replays as fast as possible")
+	shift := flag.Bool("shift", true, "restamp points with the wall-clock send time so live endpoints see them; rates scale with -speed")
+	target := flag.String("target", "", "ingest URL of a running consumer; empty ingests in-process")
+	listen := flag.String("listen", ":8081", "address for the dashboard API in in-process mode")
+	flag.Parse()
+
+	to := time.Now()
+	if *toFlag != "" {
+		to = mustParseTime(*toFlag)
+	}
+	from := to.Add(-time.Hour)
+	if *fromFlag != "" {
+		from = mustParseTime(*fromFlag)
+	}
+
+	sqlite, err := store.NewSQLiteStore(filepath.Join(*dataDir, "meta.db"))
+	if err != nil {
+		log.Fatalf("Failed to open SQLite: %v", err)
+	}
+	defer sqlite.Close()
+	duck, err := store.NewDuckDBStore(filepath.Join(*dataDir, "metrics.duckdb"))
+	if err != nil {
+		log.Fatalf("Failed to open DuckDB: %v", err)
+	}
+	defer duck.Close()
+
+	podUIDs, err := loadUIDs(sqlite, "pods")
+	if err != nil {
+		log.Fatal(err)
+	}
+	pvcUIDs, err := loadUIDs(sqlite, "pvcs")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	send := postTo(*target)
+	if *target == "" {
+		ring := buffer.NewRingBuffer(100000)
+		server := ingest.NewIngestionServer(ring, &uidResolver{pods: invert(podUIDs), pvcs: invert(pvcUIDs)})
+		send = handleWith(server.HandleIngest)
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v1/ingest", server.HandleIngest)
+		api.NewServer(sqlite, duck, ring, nil).RegisterRoutes(mux)
+		go func() {
+			log.Printf("Serving API over replayed buffer on %s", *listen)
+			if err := http.ListenAndServe(*listen, mux); err != nil {
+				log.Fatalf("HTTP server failed: %v", err)
+			}
+		}()
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	r := &replayer{podUIDs: podUIDs, pvcUIDs: pvcUIDs, speed: *speed, shift: *shift, send: send}
+	log.Printf("Replaying %s - %s at %gx", from.Format(time.RFC3339), to.Format(time.RFC3339), *speed)
+	start := time.Now()
+	if err := duck.ScanRange(ctx, from, to, r.add); err != nil && ctx.Err() == nil {
+		log.Fatalf("Replay failed: %v", err)
+	}
+	if err := r.flush(); err != nil {
+		log.Fatalf("Replay failed: %v", err)
+	}
+	log.Printf("Replayed %d points (%d skipped) in %s", r.sent, r.skipped, time.Since(start).Round(time.Millisecond))
+
+	if *target == "" {
+		log.Printf("Replay finished; API still serving, Ctrl-C to exit")
+		<-ctx.Done()
+	}
+}
+
+// replayer groups points by timestamp and paces their delivery
+type replayer struct {
+	podUIDs, pvcUIDs map[int64]string
+	speed            float64
+	shift            bool
+	send             func(ingest.IngestRequest) error
+
+	first, wallStart time.Time
+	current          time.Time
+	batch            []ingest.RawMetric
+	sent, skipped    int
+}
+
+func (r *replayer) add(p store.MetricPoint) error {
+	raw, ok := r.encode(p)
+	if !ok {
+		r.skipped++
+		return nil
+	}
+	if !p.Time.Equal(r.current) || len(r.batch) >= maxBatch {
+		if err := r.flush(); err != nil {
+			return err
+		}
+		r.current = p.Time
+	}
+	r.batch = append(r.batch, raw)
+	return nil
+}
+
+func (r *replayer) flush() error {
+	if len(r.batch) == 0 {
+		return nil
+	}
+	if r.first.IsZero() {
+		r.first, r.wallStart = r.current, time.Now()
+	}
+
+	if r.speed > 0 {
+		offset := time.Duration(float64(r.current.Sub(r.first)) / r.speed)
+		if wait := time.Until(r.wallStart.Add(offset)); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	if r.shift {
+		now := time.Now().Unix()
+		for i := range r.batch {
+			r.batch[i].Timestamp = now
+		}
+	}
+
+	err := r.send(ingest.IngestRequest{Version: ingest.CurrentVersion, NodeName: "replay", Metrics: r.batch})
+	r.sent += len(r.batch)
+	r.batch = r.batch[:0]
+	return err
+}
+
+// encode rebuilds the agent payload the ingest path would have received
+func (r *replayer) encode(p store.MetricPoint) (ingest.RawMetric, bool) {
+	raw := ingest.RawMetric{Key: p.MetricType, Value: p.Value, Timestamp: p.Time.Unix(), TimestampMs: p.Time.UnixMilli()}
+	switch {
+	case podTypes[p.MetricType]:
+		uid, ok := r.podUIDs[p.ResourceID]
+		if !ok {
+			return raw, false
+		}
+		raw.Type = "container"
+		raw.PodUID = uid
+		raw.PodID = "kubepods-pod" + strings.ReplaceAll(uid, "-", "_") + ".slice"
+	case pvcTypes[p.MetricType]:
+		uid, ok := r.pvcUIDs[p.ResourceID]
+		if !ok {
+			return raw, false
+		}
+		raw.Type = "pvc_usage"
+		raw.Volume = "pvc-" + uid
+	default:
+		return raw, false
+	}
+	return raw, true
+}
+
+func postTo(url string) func(ingest.IngestRequest) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	return func(req ingest.IngestRequest) error {
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		for {
+			resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			// Back off while the consumer's ingest queue is full
+			if resp.StatusCode == http.StatusServiceUnavailable {
+				time.Sleep(time.Second)
+				continue
+			}
+			if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("ingest returned %s", resp.Status)
+			}
+			return nil
+		}
+	}
+}
+
+func handleWith(h http.HandlerFunc) func(ingest.IngestRequest) error {
+	return func(req ingest.IngestRequest) error {
+		body, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodPost, "/api/v1/ingest", bytes.NewReader(body)))
+		if rec.Code != http.StatusAccepted {
+			return fmt.Errorf("ingest returned %d: %s", rec.Code, rec.Body.String())
+		}
+		return nil
+	}
+}
+
+// uidResolver resolves UIDs from the catalog snapshot instead of informers
+type uidResolver struct {
+	pods, pvcs map[string]int64
+}
+
+func (r *uidResolver) GetResourceID(uid, rType string) (int64, bool) {
+	m := r.pods
+	if rType == "pvc" {
+		m = r.pvcs
+	}
+	id, ok := m[uid]
+	return id, ok
+}
+
+func (r *uidResolver) GetResourceIDs(rType string, uids []string) []int64 {
+	out := make([]int64, len(uids))
+	for i, uid := range uids {
+		out[i], _ = r.GetResourceID(uid, rType)
+	}
+	return out
+}
+
+func loadUIDs(sqlite *store.SQLiteStore, table string) (map[int64]string, error) {
+	rows, err := sqlite.Query("SELECT id, uid FROM " + table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var uid string
+		if err := rows.Scan(&id, &uid); err != nil {
+			return nil, err
+		}
+		out[id] = uid
+	}
+	return out, rows.Err()
+}
+
+func invert(m map[int64]string) map[string]int64 {
+	out := make(map[string]int64, len(m))
+	for id, uid := range m {
+		out[uid] = id
+	}
+	return out
+}
+
+func mustParseTime(s string) time.Time {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0)
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		log.Fatalf("Invalid time %q: want RFC3339 or unix seconds", s)
+	}
+	return t
+}
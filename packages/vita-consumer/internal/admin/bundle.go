@@ -0,0 +1,179 @@
+package admin
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/ingest"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/querystats"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// bundleQueryStats is how many endpoints and statements a bundle lists
+const bundleQueryStats = 50
+
+// secretConfigWords mark settings whose values are left out of bundles
+var secretConfigWords = []string{"TOKEN", "PASSWORD", "SECRET", "KEY"}
+
+// IngestErrorReporter exposes the latest refused or failed agent posts
+type IngestErrorReporter interface {
+	RecentErrors() []ingest.IngestError
+}
+
+// SetStores adds the catalog and metrics schemas to support bundles
+func (s *Server) SetStores(sqlite *store.SQLiteStore, duck *store.DuckDBStore) {
+	s.sqlite = sqlite
+	s.duck = duck
+}
+
+// SetIngestErrors adds the latest ingest errors to support bundles
+func (s *Server) SetIngestErrors(r IngestErrorReporter) {
+	s.ingestErrors = r
+}
+
+// SetConfigKeys names the environment settings support bundles include,
+// credentials redacted
+func (s *Server) SetConfigKeys(keys []string) {
+	s.configKeys = keys
+}
+
+// BundleManifest is manifest.json: when and from what a bundle was made
+type BundleManifest struct {
+	CreatedAt      time.Time `json:"created_at"`
+	UptimeSeconds  int64     `json:"uptime_seconds"`
+	GoVersion      string    `json:"go_version"`
+	PayloadVersion int       `json:"payload_version"`
+	Files          []string  `json:"files"`
+	Errors         []string  `json:"errors,omitempty"` // parts that could not be collected
+}
+
+// handleSupportBundle serves GET /internal/support-bundle: a tar.gz to
+// attach to bug reports with the debug state, published variables, the
+// slowest queries, sanitized settings, database schemas and sizes, the
+// latest ingest errors and a goroutine dump. Nothing in it identifies
+// workloads beyond table sizes and ingest errors' node names.
+func (s *Server) handleSupportBundle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now().UTC()
+	manifest := BundleManifest{
+		CreatedAt:      now,
+		UptimeSeconds:  int64(time.Since(s.started).Seconds()),
+		GoVersion:      runtime.Version(),
+		PayloadVersion: ingest.CurrentVersion,
+	}
+	type file struct {
+		name string
+		data []byte
+	}
+	var files []file
+	add := func(name string, v interface{}) {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			manifest.Errors = append(manifest.Errors, fmt.Sprintf("%s: %v", name, err))
+			return
+		}
+		files = append(files, file{name, data})
+	}
+
+	add("state.json", s.state())
+	vars := make(map[string]json.RawMessage)
+	expvar.Do(func(kv expvar.KeyValue) { vars[kv.Key] = json.RawMessage(kv.Value.String()) })
+	add("vars.json", vars)
+	add("querystats.json", querystats.Snapshot(bundleQueryStats))
+	add("config.json", sanitizeConfig(s.configKeys))
+	if s.sqlite != nil {
+		if info, err := s.sqlite.Schema(); err != nil {
+			manifest.Errors = append(manifest.Errors, "sqlite schema: "+err.Error())
+		} else {
+			add("schema/sqlite.json", info)
+		}
+	}
+	if s.duck != nil {
+		if info, err := s.duck.Schema(r.Context()); err != nil {
+			manifest.Errors = append(manifest.Errors, "duckdb schema: "+err.Error())
+		} else {
+			add("schema/duckdb.json", info)
+		}
+	}
+	if s.ingestErrors != nil {
+		add("ingest_errors.json", s.ingestErrors.RecentErrors())
+	}
+	var goroutines bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&goroutines, 1); err != nil {
+		manifest.Errors = append(manifest.Errors, "goroutines: "+err.Error())
+	} else {
+		files = append(files, file{"goroutines.txt", goroutines.Bytes()})
+	}
+	for _, f := range files {
+		manifest.Files = append(manifest.Files, f.name)
+	}
+	add("manifest.json", manifest)
+
+	dir := "vita-support-" + now.Format("20060102T150405Z")
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", dir+".tar.gz"))
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		hdr := &tar.Header{Name: dir + "/" + f.name, Mode: 0o644, Size: int64(len(f.data)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			log.Printf("Failed to write support bundle: %v", err)
+			return
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			log.Printf("Failed to write support bundle: %v", err)
+			return
+		}
+	}
+	if err := tw.Close(); err != nil {
+		log.Printf("Failed to write support bundle: %v", err)
+		return
+	}
+	if err := gz.Close(); err != nil {
+		log.Printf("Failed to write support bundle: %v", err)
+	}
+}
+
+// sanitizeConfig returns the set values of keys, with credentials replaced
+// and passwords removed from URLs. Paths to key files are kept.
+func sanitizeConfig(keys []string) map[string]string {
+	out := make(map[string]string)
+	for _, key := range keys {
+		val, ok := os.LookupEnv(key)
+		if !ok {
+			continue
+		}
+		secret := false
+		for _, word := range secretConfigWords {
+			if strings.Contains(key, word) && !strings.HasSuffix(key, "_FILE") {
+				secret = true
+			}
+		}
+		switch {
+		case secret && val != "":
+			val = "<redacted>"
+		case strings.Contains(val, "://"):
+			if u, err := url.Parse(val); err == nil {
+				val = u.Redacted()
+			}
+		}
+		out[key] = val
+	}
+	return out
+}
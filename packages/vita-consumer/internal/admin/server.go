@@ -0,0 +1,132 @@
+package admin
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/resolve"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// CacheReporter exposes the sizes of the syncer's resolution caches
+type CacheReporter interface {
+	CacheSizes() map[string]int
+}
+
+// ResolverReporter exposes per-layer counters of ID resolution
+type ResolverReporter interface {
+	Stats() resolve.Stats
+}
+
+// Server serves runtime diagnostics (pprof, expvar, state snapshots).
+// It is meant to be bound to a separate, non-public admin port.
+type Server struct {
+	caches   CacheReporter
+	resolver ResolverReporter
+	ring     *buffer.RingBuffer
+	started  time.Time
+
+	// support bundle contents, each optional
+	sqlite       *store.SQLiteStore
+	duck         *store.DuckDBStore
+	ingestErrors IngestErrorReporter
+	configKeys   []string
+}
+
+func NewServer(caches CacheReporter, ring *buffer.RingBuffer) *Server {
+	return &Server{
+		caches:  caches,
+		ring:    ring,
+		started: time.Now(),
+	}
+}
+
+// SetResolver adds ID resolution counters to the state snapshot
+func (s *Server) SetResolver(r ResolverReporter) {
+	s.resolver = r
+}
+
+func (s *Server) RegisterRoutes(mux *http.ServeMux) {
+	// Profiling
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	// Published variables (memstats, cmdline, ...)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	// State snapshot
+	mux.HandleFunc("/internal/debug/state", s.handleState)
+
+	// Everything above and more, packaged for a bug report
+	mux.HandleFunc("/internal/support-bundle", s.handleSupportBundle)
+}
+
+// DebugState is a point-in-time snapshot of the consumer's internals
+type DebugState struct {
+	Timestamp     int64          `json:"timestamp"`
+	UptimeSeconds int64          `json:"uptime_seconds"`
+	Goroutines    int            `json:"goroutines"`
+	HeapAllocMB   float64        `json:"heap_alloc_mb"`
+	HeapObjects   uint64         `json:"heap_objects"`
+	NumGC         uint32         `json:"num_gc"`
+	Buffer        BufferState    `json:"buffer"`
+	Caches        map[string]int `json:"caches"`
+	Resolver      *resolve.Stats `json:"resolver,omitempty"`
+}
+
+// BufferState describes ring buffer occupancy
+type BufferState struct {
+	Size     int     `json:"size"`
+	Capacity int     `json:"capacity"`
+	Percent  float64 `json:"percent"`
+}
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.state())
+}
+
+// state takes a DebugState snapshot
+func (s *Server) state() DebugState {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	size, capacity := s.ring.Len(), s.ring.Cap()
+	var percent float64
+	if capacity > 0 {
+		percent = float64(size) / float64(capacity) * 100
+	}
+
+	state := DebugState{
+		Timestamp:     time.Now().Unix(),
+		UptimeSeconds: int64(time.Since(s.started).Seconds()),
+		Goroutines:    runtime.NumGoroutine(),
+		HeapAllocMB:   float64(mem.HeapAlloc) / 1024 / 1024,
+		HeapObjects:   mem.HeapObjects,
+		NumGC:         mem.NumGC,
+		Buffer: BufferState{
+			Size:     size,
+			Capacity: capacity,
+			Percent:  percent,
+		},
+		Caches: s.caches.CacheSizes(),
+	}
+	if s.resolver != nil {
+		st := s.resolver.Stats()
+		state.Resolver = &st
+	}
+	return state
+}
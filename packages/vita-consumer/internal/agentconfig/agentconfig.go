@@ -0,0 +1,131 @@
+// Package agentconfig serves per-node collection settings that agents poll,
+// so scrape interval, collectors and metric filters can be changed
+// centrally without redeploying the DaemonSet. A cluster default applies
+// to every node; a node's own settings override it field by field.
+package agentconfig
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// Collectors an agent runs. Processes are off unless enabled, as the
+// consumer discards them without process metrics.
+var defaultCollectors = map[string]bool{
+	"system":    true,
+	"container": true,
+	"pvc":       true,
+	"processes": false,
+}
+
+const (
+	defaultScrapeIntervalSec = 1
+	defaultPollIntervalSec   = 30
+)
+
+// Config is stored settings. Unset fields fall through to the cluster
+// default, then to the built-in defaults.
+type Config struct {
+	ScrapeIntervalSec *int `json:"scrape_interval_sec,omitempty"`
+	// PollIntervalSec is how often the agent fetches its settings
+	PollIntervalSec *int            `json:"poll_interval_sec,omitempty"`
+	Collectors      map[string]bool `json:"collectors,omitempty"` // merged per collector
+	// Include and Exclude are metric key globs ("mem_*"); an empty Include
+	// reports every key, Exclude wins over Include. Each list replaces the
+	// one it overrides.
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Validate checks a Config before it is stored
+func (c Config) Validate() error {
+	if c.ScrapeIntervalSec != nil && (*c.ScrapeIntervalSec < 1 || *c.ScrapeIntervalSec > 3600) {
+		return fmt.Errorf("scrape_interval_sec must be between 1 and 3600")
+	}
+	if c.PollIntervalSec != nil && (*c.PollIntervalSec < 5 || *c.PollIntervalSec > 3600) {
+		return fmt.Errorf("poll_interval_sec must be between 5 and 3600")
+	}
+	for name := range c.Collectors {
+		if _, ok := defaultCollectors[name]; !ok {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	for _, p := range append(append([]string{}, c.Include...), c.Exclude...) {
+		if _, err := path.Match(p, ""); p == "" || err != nil {
+			return fmt.Errorf("invalid metric filter %q", p)
+		}
+	}
+	return nil
+}
+
+// Effective is what an agent runs with
+type Effective struct {
+	Node              string          `json:"node"`
+	Version           string          `json:"version"` // changes whenever any setting does
+	ScrapeIntervalSec int             `json:"scrape_interval_sec"`
+	PollIntervalSec   int             `json:"poll_interval_sec"`
+	Collectors        map[string]bool `json:"collectors"`
+	Include           []string        `json:"include"`
+	Exclude           []string        `json:"exclude"`
+}
+
+// apply overlays the fields c sets
+func (e *Effective) apply(c Config) {
+	if c.ScrapeIntervalSec != nil {
+		e.ScrapeIntervalSec = *c.ScrapeIntervalSec
+	}
+	if c.PollIntervalSec != nil {
+		e.PollIntervalSec = *c.PollIntervalSec
+	}
+	for name, on := range c.Collectors {
+		e.Collectors[name] = on
+	}
+	if c.Include != nil {
+		e.Include = c.Include
+	}
+	if c.Exclude != nil {
+		e.Exclude = c.Exclude
+	}
+}
+
+// Resolve returns the settings of a node: built-in defaults, overlaid by the
+// cluster default and the node's own settings
+func Resolve(sqlite *store.SQLiteStore, node string) (Effective, error) {
+	e := Effective{
+		ScrapeIntervalSec: defaultScrapeIntervalSec,
+		PollIntervalSec:   defaultPollIntervalSec,
+		Collectors:        make(map[string]bool, len(defaultCollectors)),
+		Include:           []string{},
+		Exclude:           []string{},
+	}
+	for name, on := range defaultCollectors {
+		e.Collectors[name] = on
+	}
+	for _, scope := range []string{"", node} {
+		raw, ok, err := sqlite.GetAgentConfig(scope)
+		if err != nil {
+			return Effective{}, err
+		}
+		if !ok {
+			continue
+		}
+		var c Config
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return Effective{}, fmt.Errorf("stored config of %q: %w", scope, err)
+		}
+		e.apply(c)
+	}
+
+	// The version covers the settings only, so every node running the
+	// cluster default shares one
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	e.Node = node
+	e.Version = hex.EncodeToString(sum[:8])
+	return e, nil
+}
@@ -0,0 +1,121 @@
+package agentconfig
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// Server serves agent settings: the agent-facing GET on the ingest
+// listener, the management endpoints with the rest of the API
+type Server struct {
+	sqlite *store.SQLiteStore
+}
+
+func NewServer(sqlite *store.SQLiteStore) *Server {
+	return &Server{sqlite: sqlite}
+}
+
+// RegisterRoutes adds the management endpoints
+func (s *Server) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/agent-configs", s.handleConfigs)
+}
+
+// HandleAgentConfig serves GET /api/v1/agent-config?node= to agents. The
+// ETag is the settings version: polls with If-None-Match get 304 until
+// something changes.
+func (s *Server) HandleAgentConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	node := r.URL.Query().Get("node")
+	if node == "" {
+		writeError(w, "node parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	e, err := Resolve(s.sqlite, node)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	etag := `"` + e.Version + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, e)
+}
+
+// handleConfigs lists stored settings (GET), sets a node's (PUT ?node=)
+// and removes them (DELETE ?node=). Without node, PUT and DELETE apply to
+// the cluster default.
+func (s *Server) handleConfigs(w http.ResponseWriter, r *http.Request) {
+	node := r.URL.Query().Get("node")
+	switch r.Method {
+	case http.MethodGet:
+		configs, err := s.sqlite.ListAgentConfigs()
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, configs)
+
+	case http.MethodPut:
+		var c Config
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&c); err != nil {
+			writeError(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := c.Validate(); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := json.Marshal(c)
+		if err := s.sqlite.SetAgentConfig(node, data); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if node == "" {
+			writeJSON(w, c)
+			return
+		}
+		e, err := Resolve(s.sqlite, node)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, e)
+
+	case http.MethodDelete:
+		found, err := s.sqlite.DeleteAgentConfig(node)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			writeError(w, "no settings stored for this node", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
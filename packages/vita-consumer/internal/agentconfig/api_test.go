@@ -0,0 +1,108 @@
+package agentconfig_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/agentconfig"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+)
+
+// TestAgentConfig verifies node settings override the cluster default
+// field by field and that unchanged settings poll as 304
+func TestAgentConfig(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		srv := agentconfig.NewServer(env.SQLite)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v1/agent-config", srv.HandleAgentConfig)
+		srv.RegisterRoutes(mux)
+		call := func(method, path, body, etag string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			if etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			return rec
+		}
+		get := func(node string) (agentconfig.Effective, string, error) {
+			var e agentconfig.Effective
+			rec := call(http.MethodGet, "/api/v1/agent-config?node="+node, "", "")
+			if rec.Code != http.StatusOK {
+				return e, "", fmt.Errorf("GET %s: %d %s", node, rec.Code, rec.Body.String())
+			}
+			return e, rec.Header().Get("ETag"), json.NewDecoder(rec.Body).Decode(&e)
+		}
+
+		if rec := call(http.MethodGet, "/api/v1/agent-config", "", ""); rec.Code != http.StatusBadRequest {
+			return fmt.Errorf("GET without node: %d", rec.Code)
+		}
+		def, etag, err := get("node-a")
+		if err != nil {
+			return err
+		}
+		if def.ScrapeIntervalSec != 1 || !def.Collectors["container"] || def.Collectors["processes"] || etag != `"`+def.Version+`"` {
+			return fmt.Errorf("built-in defaults: %+v, etag %s", def, etag)
+		}
+		if rec := call(http.MethodGet, "/api/v1/agent-config?node=node-a", "", etag); rec.Code != http.StatusNotModified {
+			return fmt.Errorf("unchanged poll: %d, want 304", rec.Code)
+		}
+
+		for _, put := range []struct{ node, body string }{
+			{"", `{"scrape_interval_sec": 5, "exclude": ["mem_*"]}`},
+			{"node-a", `{"scrape_interval_sec": 2, "collectors": {"processes": true}}`},
+		} {
+			if rec := call(http.MethodPut, "/api/v1/agent-configs?node="+put.node, put.body, ""); rec.Code != http.StatusOK {
+				return fmt.Errorf("PUT %q: %d %s", put.node, rec.Code, rec.Body.String())
+			}
+		}
+		for _, bad := range []string{`{"collectors": {"gpu": true}}`, `{"scrape_interval_sec": 0}`, `{"include": ["["]}`, `{"interval": 5}`} {
+			if rec := call(http.MethodPut, "/api/v1/agent-configs?node=node-a", bad, ""); rec.Code != http.StatusBadRequest {
+				return fmt.Errorf("PUT %s: %d, want 400", bad, rec.Code)
+			}
+		}
+
+		a, _, err := get("node-a")
+		if err != nil {
+			return err
+		}
+		if a.ScrapeIntervalSec != 2 || !a.Collectors["processes"] || !a.Collectors["pvc"] || len(a.Exclude) != 1 || a.Version == def.Version {
+			return fmt.Errorf("node-a settings: %+v", a)
+		}
+		if rec := call(http.MethodGet, "/api/v1/agent-config?node=node-a", "", etag); rec.Code != http.StatusOK {
+			return fmt.Errorf("poll after change: %d, want 200", rec.Code)
+		}
+		b, _, err := get("node-b")
+		if err != nil {
+			return err
+		}
+		if b.ScrapeIntervalSec != 5 || b.Collectors["processes"] || b.Exclude[0] != "mem_*" {
+			return fmt.Errorf("node-b settings: %+v", b)
+		}
+
+		if rec := call(http.MethodDelete, "/api/v1/agent-configs?node=node-a", "", ""); rec.Code != http.StatusNoContent {
+			return fmt.Errorf("DELETE node-a: %d", rec.Code)
+		}
+		if a, _, err = get("node-a"); err != nil {
+			return err
+		}
+		if a.Version != b.Version {
+			return fmt.Errorf("node-a back on the cluster default: %+v, want version %s", a, b.Version)
+		}
+		var stored []store.AgentConfig
+		rec := call(http.MethodGet, "/api/v1/agent-configs", "", "")
+		if err := json.NewDecoder(rec.Body).Decode(&stored); err != nil {
+			return err
+		}
+		if len(stored) != 1 || stored[0].Node != "" {
+			return fmt.Errorf("stored settings: %+v", stored)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,75 @@
+package agents
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+)
+
+// RegisterRoutes serves the registrations; deciding on and forgetting
+// agents takes an authenticated caller (see api.RequireAuthenticated)
+func (r *Registry) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/agents", r.handleList)
+	mux.HandleFunc("POST /api/v1/agents/{node}/approve", api.RequireAuthenticated(r.handleDecide(Approved)))
+	mux.HandleFunc("POST /api/v1/agents/{node}/reject", api.RequireAuthenticated(r.handleDecide(Rejected)))
+	mux.HandleFunc("DELETE /api/v1/agents/{node}", api.RequireAuthenticated(r.handleForget))
+}
+
+// handleList serves GET /api/v1/agents[?status=pending|approved|rejected]
+func (r *Registry) handleList(w http.ResponseWriter, req *http.Request) {
+	status := req.URL.Query().Get("status")
+	if status != "" && status != Pending && status != Approved && status != Rejected {
+		writeError(w, "status must be pending, approved or rejected", http.StatusBadRequest)
+		return
+	}
+	regs, err := r.sqlite.ListAgentRegistrations(status)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, regs)
+}
+
+// handleDecide serves POST /api/v1/agents/{node}/approve and .../reject.
+// Rejecting an approved agent stops its metrics from the next post on.
+func (r *Registry) handleDecide(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		node := req.PathValue("node")
+		found, err := r.decide(node, status)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			writeError(w, "agent not registered", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, map[string]string{"node": node, "status": status})
+	}
+}
+
+// handleForget serves DELETE /api/v1/agents/{node}
+func (r *Registry) handleForget(w http.ResponseWriter, req *http.Request) {
+	found, err := r.forget(req.PathValue("node"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		writeError(w, "agent not registered", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
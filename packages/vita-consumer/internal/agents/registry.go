@@ -0,0 +1,213 @@
+// Package agents keeps a registration per node agent and decides whose
+// metrics ingest accepts, so a rogue or misconfigured source cannot write
+// into the store. A new agent is pending until an operator approves it;
+// in auto mode, agents named after a node in the synced catalog are
+// approved on their first post.
+package agents
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// Registration statuses
+const (
+	Pending  = "pending"
+	Approved = "approved"
+	Rejected = "rejected"
+)
+
+// Approval modes
+const (
+	ModeManual = "manual" // every agent waits for an operator
+	ModeAuto   = "auto"   // agents of synced nodes are approved
+)
+
+// touchEvery bounds last_seen writes per agent
+const touchEvery = time.Minute
+
+// maxPending bounds the pending registrations, so posts naming made-up
+// nodes cannot grow the registry without limit. While it is reached,
+// unknown agents are refused without registering, except those auto mode
+// approves.
+const maxPending = 1000
+
+// Registry decides which agents are accepted. Catalog reads and writes
+// happen outside mu, so a slow disk holds up only the posts waiting on
+// them.
+type Registry struct {
+	sqlite *store.SQLiteStore
+	clock  clock.Clock
+	auto   bool
+
+	mu      sync.Mutex
+	status  map[string]string // by node, mirrors agent_registrations
+	pending int               // nodes in status that are Pending
+	touched map[string]time.Time
+	full    time.Time // when refusing at maxPending was last logged
+}
+
+// NewRegistry loads the existing registrations
+func NewRegistry(sqlite *store.SQLiteStore, mode string) (*Registry, error) {
+	if mode != ModeManual && mode != ModeAuto {
+		return nil, fmt.Errorf("agent approval mode must be %s or %s, got %q", ModeManual, ModeAuto, mode)
+	}
+	regs, err := sqlite.ListAgentRegistrations("")
+	if err != nil {
+		return nil, err
+	}
+	r := &Registry{
+		sqlite:  sqlite,
+		clock:   clock.Real,
+		auto:    mode == ModeAuto,
+		status:  make(map[string]string, len(regs)),
+		touched: make(map[string]time.Time),
+	}
+	for _, reg := range regs {
+		r.setLocked(reg.Node, reg.Status)
+	}
+	return r, nil
+}
+
+// SetClock replaces the clock registrations are stamped with
+func (r *Registry) SetClock(c clock.Clock) {
+	r.clock = c
+}
+
+// Admit reports whether an agent's metrics are accepted, registering it
+// on its first post
+func (r *Registry) Admit(node, remoteAddr string) bool {
+	if node == "" {
+		return false
+	}
+	now := r.clock.Now()
+
+	r.mu.Lock()
+	status, known := r.status[node]
+	touch := known && now.Sub(r.touched[node]) >= touchEvery
+	if touch {
+		r.touched[node] = now
+	}
+	r.mu.Unlock()
+	if !known {
+		return r.register(node, remoteAddr, now)
+	}
+
+	if status == Pending && r.auto && r.nodeSynced(node) {
+		if ok, err := r.sqlite.ApprovePendingAgent(node, now); err != nil {
+			log.Printf("Failed to approve agent of node %q: %v", node, err)
+		} else if ok {
+			log.Printf("Agent of node %q approved: node is now synced", node)
+			status = Approved
+			r.mu.Lock()
+			// Unless an operator decided in the meantime
+			if r.status[node] == Pending {
+				r.setLocked(node, Approved)
+			}
+			r.mu.Unlock()
+		}
+	}
+
+	if touch {
+		if err := r.sqlite.TouchAgent(node, remoteAddr, now); err != nil {
+			log.Printf("Failed to update agent of node %q: %v", node, err)
+		}
+	}
+	return status == Approved
+}
+
+// register records an unknown agent on its first post, unless it would
+// be pending while maxPending are
+func (r *Registry) register(node, remoteAddr string, now time.Time) bool {
+	status := Pending
+	if r.auto && r.nodeSynced(node) {
+		status = Approved
+	}
+
+	r.mu.Lock()
+	if cur, ok := r.status[node]; ok {
+		// Registered by a concurrent post
+		r.mu.Unlock()
+		return cur == Approved
+	}
+	if status == Pending && r.pending >= maxPending {
+		if now.Sub(r.full) >= time.Minute {
+			r.full = now
+			log.Printf("Refusing agent of node %q from %s: %d registrations are pending", node, remoteAddr, r.pending)
+		}
+		r.mu.Unlock()
+		return false
+	}
+	r.setLocked(node, status)
+	r.touched[node] = now
+	r.mu.Unlock()
+
+	if err := r.sqlite.RegisterAgent(node, status, remoteAddr, now); err != nil {
+		log.Printf("Failed to register agent of node %q: %v", node, err)
+		r.mu.Lock()
+		if r.status[node] == status {
+			r.deleteLocked(node)
+		}
+		r.mu.Unlock()
+		return false
+	}
+	log.Printf("Agent of node %q registered from %s: %s", node, remoteAddr, status)
+	return status == Approved
+}
+
+// setLocked records a node's status, counting those pending
+func (r *Registry) setLocked(node, status string) {
+	if r.status[node] == Pending {
+		r.pending--
+	}
+	if status == Pending {
+		r.pending++
+	}
+	r.status[node] = status
+}
+
+// deleteLocked forgets a node
+func (r *Registry) deleteLocked(node string) {
+	if r.status[node] == Pending {
+		r.pending--
+	}
+	delete(r.status, node)
+	delete(r.touched, node)
+}
+
+func (r *Registry) nodeSynced(node string) bool {
+	ok, err := r.sqlite.NodeExists(node)
+	if err != nil {
+		log.Printf("Failed to look up node %q: %v", node, err)
+	}
+	return ok
+}
+
+// decide approves or rejects a registered agent
+func (r *Registry) decide(node, status string) (bool, error) {
+	found, err := r.sqlite.SetAgentStatus(node, status, r.clock.Now())
+	if err != nil || !found {
+		return found, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.setLocked(node, status)
+	return true, nil
+}
+
+// forget drops a registration; the agent's next post registers it anew
+func (r *Registry) forget(node string) (bool, error) {
+	found, err := r.sqlite.DeleteAgentRegistration(node)
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deleteLocked(node)
+	return found, nil
+}
@@ -0,0 +1,162 @@
+package agents_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/agents"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/ingest"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestAgentApproval verifies unknown agents are held as pending, auto mode
+// approves agents of synced nodes and operator decisions take effect on
+// the next post, and only for authenticated callers
+func TestAgentApproval(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		syncNode := func(name string) error {
+			if _, err := env.Client.CoreV1().Nodes().Create(ctx, synctest.Node(name, "4", "8Gi"), metav1.CreateOptions{}); err != nil {
+				return err
+			}
+			return env.Eventually(synctest.Timeout, "node synced", func() (bool, error) {
+				n, err := env.QueryInt("SELECT COUNT(*) FROM nodes WHERE name = ?", name)
+				return n == 1, err
+			})
+		}
+		if err := syncNode("node-a"); err != nil {
+			return err
+		}
+
+		if _, err := agents.NewRegistry(env.SQLite, "trusting"); err == nil {
+			return fmt.Errorf("unknown approval mode accepted")
+		}
+		registry, err := agents.NewRegistry(env.SQLite, agents.ModeAuto)
+		if err != nil {
+			return err
+		}
+		registry.SetClock(env.Clock)
+		srv := ingest.NewIngestionServer(env.Ring, env.Resolver)
+		srv.SetClock(env.Clock)
+		srv.SetRegistry(registry)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v1/ingest", srv.HandleIngest)
+		registry.RegisterRoutes(mux)
+		handler := api.Authenticate(synctest.Auth{}, mux, "/api/v1/ingest")
+		send := func(method, path, body, token string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(method, path, strings.NewReader(body))
+			r.Header.Set("Authorization", "Bearer "+token)
+			handler.ServeHTTP(rec, r)
+			return rec
+		}
+		call := func(method, path, body string) *httptest.ResponseRecorder {
+			return send(method, path, body, synctest.AdminToken)
+		}
+		post := func(node string) int {
+			return call(http.MethodPost, "/api/v1/ingest", `{"node": "`+node+`", "metrics": []}`).Code
+		}
+		expect := func(node string, code int) error {
+			if got := post(node); got != code {
+				return fmt.Errorf("post from %s: %d, want %d", node, got, code)
+			}
+			return nil
+		}
+
+		if err := expect("node-a", http.StatusAccepted); err != nil {
+			return err
+		}
+		if err := expect("rogue", http.StatusForbidden); err != nil {
+			return err
+		}
+		var pending []store.AgentRegistration
+		if err := json.NewDecoder(call(http.MethodGet, "/api/v1/agents?status=pending", "").Body).Decode(&pending); err != nil {
+			return err
+		}
+		if len(pending) != 1 || pending[0].Node != "rogue" || pending[0].RemoteAddr == "" {
+			return fmt.Errorf("pending agents: %+v", pending)
+		}
+
+		for _, method := range []string{http.MethodPost, http.MethodDelete} {
+			path := "/api/v1/agents/rogue"
+			if method == http.MethodPost {
+				path += "/approve"
+			}
+			if rec := send(method, path, "", "guess"); rec.Code != http.StatusForbidden {
+				return fmt.Errorf("anonymous %s %s: %d, want 403", method, path, rec.Code)
+			}
+		}
+		if err := expect("rogue", http.StatusForbidden); err != nil {
+			return err
+		}
+		if rec := call(http.MethodPost, "/api/v1/agents/rogue/approve", ""); rec.Code != http.StatusOK {
+			return fmt.Errorf("approve: %d", rec.Code)
+		}
+		if err := expect("rogue", http.StatusAccepted); err != nil {
+			return err
+		}
+		if rec := call(http.MethodPost, "/api/v1/agents/node-a/reject", ""); rec.Code != http.StatusOK {
+			return fmt.Errorf("reject: %d", rec.Code)
+		}
+		if err := expect("node-a", http.StatusForbidden); err != nil {
+			return err
+		}
+		if rec := call(http.MethodPost, "/api/v1/agents/ghost/approve", ""); rec.Code != http.StatusNotFound {
+			return fmt.Errorf("approve unregistered: %d", rec.Code)
+		}
+
+		// An agent that starts before its node syncs is approved once it does
+		if err := expect("node-b", http.StatusForbidden); err != nil {
+			return err
+		}
+		if err := syncNode("node-b"); err != nil {
+			return err
+		}
+		if err := expect("node-b", http.StatusAccepted); err != nil {
+			return err
+		}
+
+		// A forgotten agent registers again, and waits again
+		if rec := call(http.MethodDelete, "/api/v1/agents/rogue", ""); rec.Code != http.StatusNoContent {
+			return fmt.Errorf("forget: %d", rec.Code)
+		}
+		if err := expect("rogue", http.StatusForbidden); err != nil {
+			return err
+		}
+
+		// Decisions survive a restart
+		manual, err := agents.NewRegistry(env.SQLite, agents.ModeManual)
+		if err != nil {
+			return err
+		}
+		if !manual.Admit("node-b", "10.0.0.2") || manual.Admit("node-a", "10.0.0.1") || manual.Admit("rogue", "10.0.0.3") {
+			return fmt.Errorf("registrations not reloaded")
+		}
+
+		// Made-up nodes stop registering at the cap of pending agents,
+		// synced ones still do
+		for i := 0; i < 1000; i++ {
+			registry.Admit(fmt.Sprintf("made-up-%d", i), "10.0.0.9")
+		}
+		if registry.Admit("made-up-again", "10.0.0.9") {
+			return fmt.Errorf("agent over the cap admitted")
+		}
+		if n, err := env.QueryInt("SELECT COUNT(*) FROM agent_registrations WHERE status = 'pending'"); err != nil || n != 1000 {
+			return fmt.Errorf("%d pending registrations (%v), want the cap of 1000", n, err)
+		}
+		if err := syncNode("node-c"); err != nil {
+			return err
+		}
+		if !registry.Admit("node-c", "10.0.0.4") {
+			return fmt.Errorf("agent of a synced node refused at the cap")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,75 @@
+// Package analysis holds the small amount of statistics used to project
+// usage forward from history.
+package analysis
+
+import (
+	"math"
+	"time"
+)
+
+// Trend is a least-squares line through (unix seconds, value) samples
+type Trend struct {
+	Slope     float64 // units per second
+	Intercept float64
+	Samples   int
+}
+
+// Fit computes a linear trend. With fewer than two distinct timestamps the
+// slope is zero and the trend is flat at the mean.
+func Fit(ts []time.Time, vs []float64) Trend {
+	n := len(ts)
+	if n == 0 || n != len(vs) {
+		return Trend{}
+	}
+
+	// Center x to keep the sums well conditioned for unix timestamps
+	x0 := float64(ts[0].Unix())
+	var sx, sy float64
+	for i := range ts {
+		sx += float64(ts[i].Unix()) - x0
+		sy += vs[i]
+	}
+	mx, my := sx/float64(n), sy/float64(n)
+
+	var sxx, sxy float64
+	for i := range ts {
+		dx := float64(ts[i].Unix()) - x0 - mx
+		sxx += dx * dx
+		sxy += dx * (vs[i] - my)
+	}
+
+	t := Trend{Samples: n}
+	if sxx > 0 {
+		t.Slope = sxy / sxx
+	}
+	t.Intercept = my - t.Slope*(x0+mx)
+	return t
+}
+
+// At evaluates the trend at t
+func (t Trend) At(at time.Time) float64 {
+	return t.Slope*float64(at.Unix()) + t.Intercept
+}
+
+// PerDay is the slope expressed per day
+func (t Trend) PerDay() float64 {
+	return t.Slope * 86400
+}
+
+// Reaches returns when the trend crosses target, starting from now. It
+// returns now if the trend is already at or above target, and false if the
+// trend never gets there.
+func (t Trend) Reaches(target float64, now time.Time) (time.Time, bool) {
+	current := t.At(now)
+	if current >= target {
+		return now, true
+	}
+	if t.Slope <= 0 {
+		return time.Time{}, false
+	}
+	secs := (target - current) / t.Slope
+	if math.IsInf(secs, 0) || secs > float64(math.MaxInt64/int64(time.Second)) {
+		return time.Time{}, false
+	}
+	return now.Add(time.Duration(secs * float64(time.Second))), true
+}
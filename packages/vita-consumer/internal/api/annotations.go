@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// Annotation is a change marker to overlay on charts
+type Annotation struct {
+	ID          int64  `json:"id"`
+	Time        int64  `json:"time"`
+	NamespaceID *int64 `json:"namespace_id,omitempty"`
+	Type        string `json:"type"`
+	Kind        string `json:"kind"`
+	Name        string `json:"name"`
+	Message     string `json:"message"`
+}
+
+func (s *Server) handleListAnnotations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to := s.getTimeRange(r)
+	query := "SELECT id, time, namespace_id, type, kind, name, message FROM annotations WHERE time >= ? AND time < ?"
+	args := []interface{}{from.UTC(), to.UTC()}
+
+	if nsID, ok := getQueryInt(r, "namespace"); ok {
+		query += " AND namespace_id = ?"
+		args = append(args, nsID)
+	}
+	if t := r.URL.Query().Get("type"); t != "" {
+		query += " AND type = ?"
+		args = append(args, t)
+	}
+
+	query += " ORDER BY time"
+
+	rows, err := s.sqlite.Query(query, args...)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	annotations := []Annotation{}
+	for rows.Next() {
+		var a Annotation
+		var t time.Time
+		if err := rows.Scan(&a.ID, &t, &a.NamespaceID, &a.Type, &a.Kind, &a.Name, &a.Message); err != nil {
+			continue
+		}
+		a.Time = t.Unix()
+		annotations = append(annotations, a)
+	}
+
+	writeFields(w, r, annotations)
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnauthenticated is returned (possibly wrapped) by authenticators for
+// requests without valid credentials, including tokens that cannot be
+// checked yet because the identity provider's keys are unreachable. Other
+// errors mean the authenticator could not decide.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Principal is the caller an authenticator admitted
+type Principal struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups,omitempty"`
+	Method string   `json:"method"` // authenticator that admitted it: "none", "token", "oidc", ...
+}
+
+// Authenticated reports whether the caller proved an identity, as opposed
+// to being let in by the "none" authenticator
+func (p *Principal) Authenticated() bool {
+	return p != nil && p.Method != AuthNone
+}
+
+// Authenticator decides who makes an API request. Implementations must be
+// safe for concurrent use.
+type Authenticator interface {
+	ValidateRequest(r *http.Request) (*Principal, error)
+}
+
+// Built-in authenticator names for AuthConfig.Mode
+const (
+	AuthNone  = "none"
+	AuthToken = "token"
+	AuthOIDC  = "oidc"
+)
+
+// AuthConfig selects and configures the API authenticator. Mode is a
+// built-in name or one passed to RegisterAuthenticator.
+type AuthConfig struct {
+	Mode   string
+	Tokens map[string]string // token -> principal name, for "token"
+	OIDC   OIDCConfig
+}
+
+// AuthFactory builds a custom authenticator. It reads its own settings,
+// e.g. from the environment.
+type AuthFactory func() (Authenticator, error)
+
+var (
+	authMu        sync.RWMutex
+	authFactories = map[string]AuthFactory{}
+)
+
+// RegisterAuthenticator makes a custom authenticator selectable by name.
+// It is meant to be called from init in a file compiled into the consumer
+// with a build tag, so SSO integrations need no changes to the handlers.
+func RegisterAuthenticator(name string, f AuthFactory) {
+	authMu.Lock()
+	defer authMu.Unlock()
+	switch name {
+	case AuthNone, AuthToken, AuthOIDC, "":
+		panic(fmt.Sprintf("api: authenticator name %q is reserved", name))
+	}
+	if _, ok := authFactories[name]; ok {
+		panic(fmt.Sprintf("api: authenticator %q registered twice", name))
+	}
+	authFactories[name] = f
+}
+
+// NewAuthenticator builds the authenticator cfg selects; an empty Mode is
+// "none"
+func NewAuthenticator(cfg AuthConfig) (Authenticator, error) {
+	switch cfg.Mode {
+	case "", AuthNone:
+		return NoAuth{}, nil
+	case AuthToken:
+		return NewStaticTokens(cfg.Tokens)
+	case AuthOIDC:
+		return NewOIDC(cfg.OIDC)
+	}
+	authMu.RLock()
+	f, ok := authFactories[cfg.Mode]
+	authMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown authenticator %q (want %s)", cfg.Mode, strings.Join(authNames(), ", "))
+	}
+	return f()
+}
+
+func authNames() []string {
+	authMu.RLock()
+	defer authMu.RUnlock()
+	names := []string{AuthNone, AuthToken, AuthOIDC}
+	custom := make([]string, 0, len(authFactories))
+	for name := range authFactories {
+		custom = append(custom, name)
+	}
+	sort.Strings(custom)
+	return append(names, custom...)
+}
+
+// NoAuth admits every request as "anonymous"
+type NoAuth struct{}
+
+func (NoAuth) ValidateRequest(r *http.Request) (*Principal, error) {
+	return &Principal{Name: "anonymous", Method: AuthNone}, nil
+}
+
+// StaticTokens admits "Authorization: Bearer <token>" for a fixed set of
+// tokens, each naming its principal
+type StaticTokens struct {
+	tokens map[string]string
+}
+
+func NewStaticTokens(tokens map[string]string) (*StaticTokens, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("token authentication needs at least one token")
+	}
+	return &StaticTokens{tokens: tokens}, nil
+}
+
+func (a *StaticTokens) ValidateRequest(r *http.Request) (*Principal, error) {
+	bearer, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	// Compare against every token so timing does not reveal which matched
+	var name string
+	for token, n := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+			name = n
+		}
+	}
+	if name == "" {
+		return nil, ErrUnauthenticated
+	}
+	return &Principal{Name: name, Method: AuthToken}, nil
+}
+
+// ParseTokens reads "name=token" pairs separated by commas
+func ParseTokens(raw string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(pair, "=")
+		if !ok || name == "" || token == "" {
+			return nil, fmt.Errorf("invalid token entry %q, want name=token", pair)
+		}
+		out[token] = name
+	}
+	return out, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, ok && token != ""
+}
+
+type principalKey struct{}
+
+// PrincipalFrom returns the caller Authenticate admitted, or nil outside
+// of it
+func PrincipalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// RequireAuthenticated refuses with 403 callers whose identity was not
+// proven, so the routes it guards are closed under the "none"
+// authenticator. Destructive admin routes go through it.
+func RequireAuthenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !PrincipalFrom(r.Context()).Authenticated() {
+			writeError(w, "Authentication required", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Authenticate runs a on every request to next except those to the public
+// paths (exact matches, e.g. /status and agent ingest), answering 401 to
+// callers it rejects and 503 when it cannot decide
+func Authenticate(a Authenticator, next http.Handler, public ...string) http.Handler {
+	open := make(map[string]bool, len(public))
+	for _, p := range public {
+		open[p] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if open[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, err := a.ValidateRequest(r)
+		if err != nil {
+			if errors.Is(err, ErrUnauthenticated) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			log.Printf("Authentication failed: %v", err)
+			writeError(w, "Authentication unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+)
+
+// callAuthenticated sends a request for path through Authenticate and
+// returns the status and the principal the handler saw
+func callAuthenticated(t *testing.T, a Authenticator, path, token string) (int, *Principal) {
+	t.Helper()
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(PrincipalFrom(r.Context()))
+	})
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	Authenticate(a, echo, "/status").ServeHTTP(rec, req)
+	var p *Principal
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, p
+}
+
+// ID tokens signed by the provider admit their subject with its groups;
+// expired tokens, other audiences and other issuers do not
+func TestOIDCAuthentication(t *testing.T) {
+	idp := newFakeIDP(t)
+	clk := clock.NewFake(time.Now())
+	oidc, err := NewOIDC(OIDCConfig{IssuerURL: idp.URL, Audience: "vita"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oidc.SetClock(clk)
+
+	now := clk.Now()
+	claims := map[string]interface{}{
+		"iss": idp.URL, "aud": "vita", "sub": "u-1", "email": "ada@example.com",
+		"groups": []string{"sre"}, "exp": now.Add(time.Hour).Unix(),
+	}
+	code, p := callAuthenticated(t, oidc, "/api/v1/pods", idp.sign(t, "k1", claims))
+	if code != http.StatusOK || p == nil || p.Name != "ada@example.com" || len(p.Groups) != 1 || p.Groups[0] != "sre" || p.Method != AuthOIDC {
+		t.Fatalf("valid ID token: status %d, principal %+v", code, p)
+	}
+
+	rejected := map[string]map[string]interface{}{
+		"expired":        {"exp": now.Add(-time.Hour).Unix()},
+		"wrong audience": {"aud": "other"},
+		"wrong issuer":   {"iss": "https://evil.example.com"},
+	}
+	for name, override := range rejected {
+		bad := map[string]interface{}{}
+		for k, v := range claims {
+			bad[k] = v
+		}
+		for k, v := range override {
+			bad[k] = v
+		}
+		if code, _ := callAuthenticated(t, oidc, "/api/v1/pods", idp.sign(t, "k1", bad)); code != http.StatusUnauthorized {
+			t.Fatalf("%s token: status %d, want 401", name, code)
+		}
+	}
+	if code, _ := callAuthenticated(t, oidc, "/api/v1/pods", ""); code != http.StatusUnauthorized {
+		t.Fatalf("no token: status %d, want 401", code)
+	}
+	if code, p := callAuthenticated(t, oidc, "/status", ""); code != http.StatusOK || p != nil {
+		t.Fatalf("public path: status %d, principal %+v, want 200 and none", code, p)
+	}
+}
+
+func TestStaticTokens(t *testing.T) {
+	tokens, err := ParseTokens("ci=s3cret, grafana=abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	static, err := NewAuthenticator(AuthConfig{Mode: AuthToken, Tokens: tokens})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, p := callAuthenticated(t, static, "/api/v1/pods", "abc"); code != http.StatusOK || p == nil || p.Name != "grafana" {
+		t.Fatalf("static token: status %d, principal %+v", code, p)
+	}
+	if code, _ := callAuthenticated(t, static, "/api/v1/pods", "nope"); code != http.StatusUnauthorized {
+		t.Fatalf("unknown static token: status %d, want 401", code)
+	}
+	if _, err := NewAuthenticator(AuthConfig{Mode: "saml"}); err == nil {
+		t.Fatal("unknown auth mode accepted")
+	}
+}
@@ -0,0 +1,367 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/analysis"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// defaultThresholds are the utilization levels projected when ?thresholds= is absent
+var defaultThresholds = []float64{80, 90, 100}
+
+// CapacityResponse projects cluster CPU and memory usage forward
+type CapacityResponse struct {
+	From      int64              `json:"from"`
+	To        int64              `json:"to"`
+	Step      int64              `json:"step"`
+	Resources []CapacityResource `json:"resources"`
+}
+
+// CapacityResource is the projection for one resource
+type CapacityResource struct {
+	Resource       string                  `json:"resource"` // "cpu" or "memory"
+	Unit           string                  `json:"unit"`
+	Capacity       float64                 `json:"capacity"` // sum of node allocatable
+	Current        float64                 `json:"current"`
+	UtilizationPct *float64                `json:"utilization_pct,omitempty"`
+	GrowthPerDay   float64                 `json:"growth_per_day"`
+	Projections    []ThresholdProjection   `json:"projections"`
+	Namespaces     []NamespaceContribution `json:"namespaces"`
+	Groups         []GroupCapacity         `json:"groups,omitempty"` // with ?group_by=
+	Series         []SeriesPoint           `json:"series"`
+}
+
+// GroupCapacity is the capacity and usage of the nodes sharing a node
+// group value. Usage follows the node a pod ran on; usage of pods no
+// longer in the catalog is reported under "(deleted)".
+type GroupCapacity struct {
+	Name           string   `json:"name"`
+	Capacity       float64  `json:"capacity"`
+	Current        float64  `json:"current"`
+	UtilizationPct *float64 `json:"utilization_pct,omitempty"`
+	GrowthPerDay   float64  `json:"growth_per_day"`
+	SharePct       float64  `json:"share_pct"` // of cluster usage
+}
+
+// ThresholdProjection is when usage reaches a utilization level. ETA is
+// omitted when the trend never gets there (flat or shrinking usage, or
+// unknown capacity).
+type ThresholdProjection struct {
+	ThresholdPct float64  `json:"threshold_pct"`
+	Value        float64  `json:"value"`
+	Reached      bool     `json:"reached"`
+	ETA          *int64   `json:"eta,omitempty"`
+	DaysUntil    *float64 `json:"days_until,omitempty"`
+}
+
+// NamespaceContribution is one namespace's share of usage and growth.
+// Usage from pods that no longer exist is reported under "(deleted)".
+type NamespaceContribution struct {
+	Namespace      string   `json:"namespace"`
+	Current        float64  `json:"current"`
+	GrowthPerDay   float64  `json:"growth_per_day"`
+	SharePct       float64  `json:"share_pct"`
+	GrowthSharePct *float64 `json:"growth_share_pct,omitempty"`
+}
+
+// handleCapacity serves /api/v1/analysis/capacity[?days=&step=&thresholds=&group_by=].
+// days of history (default 14) are bucketed by step seconds (default 3600)
+// and a linear trend is projected to each threshold percentage. group_by
+// (zone, region, nodepool or capacity_type) also splits capacity and usage
+// by node group.
+func (s *Server) handleCapacity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days, ok := getQueryInt(r, "days")
+	if !ok || days <= 0 {
+		days = 14
+	}
+	step, ok := getQueryInt(r, "step")
+	if !ok || step <= 0 {
+		step = 3600
+	}
+	thresholds, err := parseThresholds(r.URL.Query().Get("thresholds"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy != "" {
+		if err := store.ValidNodeGroupDimension(groupBy); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	to := s.now(r)
+	from := to.Add(-time.Duration(days) * 24 * time.Hour)
+
+	var cpuCap, memCap float64
+	rows, err := s.sqlite.Query(`SELECT COALESCE(SUM(cpu_allocatable_m), 0), COALESCE(SUM(mem_allocatable_mb), 0) FROM nodes
+		WHERE decommissioned_at IS NULL`)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rows.Next() {
+		rows.Scan(&cpuCap, &memCap)
+	}
+	rows.Close()
+
+	podNamespaces, err := s.podNamespaces()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var groups *nodeGroupCapacity
+	if groupBy != "" {
+		if groups, err = s.nodeGroupCapacity(groupBy); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	resp := CapacityResponse{From: from.Unix(), To: to.Unix(), Step: step}
+	for _, res := range []struct {
+		name, unit, metric, agg string
+		capacity                float64
+	}{
+		{"cpu", "millicores", "cpu_ms", "rate", cpuCap},
+		{"memory", "MiB", "mem_mb", "avg", memCap},
+	} {
+		points, err := s.duck.QueryBucketedByResource(r.Context(), "pod", res.metric, from, to, store.Bucketing{Step: time.Duration(step) * time.Second}, res.agg)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		c := projectCapacity(points, podNamespaces, res.capacity, thresholds, to)
+		c.Resource, c.Unit = res.name, res.unit
+		if groups != nil {
+			c.Groups = groups.split(points, groups.capacity[res.name], c.Current)
+		}
+		resp.Resources = append(resp.Resources, c)
+	}
+
+	writeJSON(w, resp)
+}
+
+// nodeGroupCapacity is the node group of each pod and the allocatable
+// capacity of each group, by resource
+type nodeGroupCapacity struct {
+	dimension string
+	pods      map[int64]store.NodeGroup
+	capacity  map[string]map[string]float64 // resource -> group -> capacity
+}
+
+func (s *Server) nodeGroupCapacity(dimension string) (*nodeGroupCapacity, error) {
+	g := &nodeGroupCapacity{dimension: dimension, capacity: map[string]map[string]float64{"cpu": {}, "memory": {}}}
+	var err error
+	if g.pods, err = s.sqlite.PodNodeGroups(); err != nil {
+		return nil, err
+	}
+	nodes, err := s.sqlite.NodeGroupsByID()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.sqlite.Query(`SELECT id, COALESCE(cpu_allocatable_m, 0), COALESCE(mem_allocatable_mb, 0) FROM nodes
+		WHERE decommissioned_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var cpu, mem float64
+		if err := rows.Scan(&id, &cpu, &mem); err != nil {
+			return nil, err
+		}
+		name := nodeGroupName(nodes[id], dimension)
+		g.capacity["cpu"][name] += cpu
+		g.capacity["memory"][name] += mem
+	}
+	return g, rows.Err()
+}
+
+// split sums per-pod buckets by node group and fits each group's trend
+// over every bucket, as projectCapacity does for namespaces
+func (g *nodeGroupCapacity) split(points []store.MetricPoint, capacity map[string]float64, clusterCurrent float64) []GroupCapacity {
+	byGroup := map[string]map[time.Time]float64{}
+	seen := map[time.Time]bool{}
+	for name := range capacity {
+		byGroup[name] = map[time.Time]float64{}
+	}
+	for _, p := range points {
+		name := "(deleted)"
+		if group, ok := g.pods[p.ResourceID]; ok {
+			name = nodeGroupName(group, g.dimension)
+		}
+		if byGroup[name] == nil {
+			byGroup[name] = map[time.Time]float64{}
+		}
+		byGroup[name][p.Time] += p.Value
+		seen[p.Time] = true
+	}
+	buckets := make([]time.Time, 0, len(seen))
+	for t := range seen {
+		buckets = append(buckets, t)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Before(buckets[j]) })
+
+	out := make([]GroupCapacity, 0, len(byGroup))
+	for name, series := range byGroup {
+		gc := GroupCapacity{Name: name, Capacity: capacity[name]}
+		if len(buckets) > 0 {
+			vals := make([]float64, len(buckets))
+			for i, t := range buckets {
+				vals[i] = series[t]
+			}
+			gc.Current = vals[len(vals)-1]
+			gc.GrowthPerDay = analysis.Fit(buckets, vals).PerDay()
+		}
+		if gc.Capacity > 0 {
+			pct := gc.Current / gc.Capacity * 100
+			gc.UtilizationPct = &pct
+		}
+		if clusterCurrent > 0 {
+			gc.SharePct = gc.Current / clusterCurrent * 100
+		}
+		out = append(out, gc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// podNamespaces maps pod IDs to namespace names
+func (s *Server) podNamespaces() (map[int64]string, error) {
+	rows, err := s.sqlite.Query(`SELECT p.id, ns.name FROM pods p JOIN namespaces ns ON p.namespace_id = ns.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var ns string
+		if err := rows.Scan(&id, &ns); err != nil {
+			continue
+		}
+		out[id] = ns
+	}
+	return out, rows.Err()
+}
+
+// projectCapacity sums per-pod buckets into cluster and namespace series
+// and fits a trend to each
+func projectCapacity(points []store.MetricPoint, podNamespaces map[int64]string, capacity float64, thresholds []float64, now time.Time) CapacityResource {
+	cluster := map[time.Time]float64{}
+	byNS := map[string]map[time.Time]float64{}
+	for _, p := range points {
+		ns, ok := podNamespaces[p.ResourceID]
+		if !ok {
+			ns = "(deleted)"
+		}
+		if byNS[ns] == nil {
+			byNS[ns] = map[time.Time]float64{}
+		}
+		cluster[p.Time] += p.Value
+		byNS[ns][p.Time] += p.Value
+	}
+
+	buckets := make([]time.Time, 0, len(cluster))
+	for t := range cluster {
+		buckets = append(buckets, t)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Before(buckets[j]) })
+
+	res := CapacityResource{
+		Capacity:    capacity,
+		Projections: []ThresholdProjection{},
+		Namespaces:  []NamespaceContribution{},
+		Series:      make([]SeriesPoint, 0, len(buckets)),
+	}
+	vals := make([]float64, len(buckets))
+	for i, t := range buckets {
+		vals[i] = cluster[t]
+		res.Series = append(res.Series, SeriesPoint{T: t.Unix(), V: vals[i]})
+	}
+	if len(buckets) == 0 {
+		return res
+	}
+
+	trend := analysis.Fit(buckets, vals)
+	last := buckets[len(buckets)-1]
+	res.Current = vals[len(vals)-1]
+	res.GrowthPerDay = trend.PerDay()
+	if capacity > 0 {
+		pct := res.Current / capacity * 100
+		res.UtilizationPct = &pct
+
+		for _, th := range thresholds {
+			proj := ThresholdProjection{ThresholdPct: th, Value: capacity * th / 100}
+			if res.Current >= proj.Value {
+				proj.Reached = true
+			} else if eta, ok := trend.Reaches(proj.Value, now); ok {
+				ts := eta.Unix()
+				d := eta.Sub(now).Hours() / 24
+				proj.ETA, proj.DaysUntil = &ts, &d
+			}
+			res.Projections = append(res.Projections, proj)
+		}
+	}
+
+	// Namespace growth is fitted over every cluster bucket, counting
+	// missing buckets as zero so new namespaces show their ramp-up.
+	for ns, series := range byNS {
+		nsVals := make([]float64, len(buckets))
+		for i, t := range buckets {
+			nsVals[i] = series[t]
+		}
+		c := NamespaceContribution{
+			Namespace:    ns,
+			Current:      series[last],
+			GrowthPerDay: analysis.Fit(buckets, nsVals).PerDay(),
+		}
+		if res.Current > 0 {
+			c.SharePct = c.Current / res.Current * 100
+		}
+		if res.GrowthPerDay > 0 {
+			g := c.GrowthPerDay / res.GrowthPerDay * 100
+			c.GrowthSharePct = &g
+		}
+		res.Namespaces = append(res.Namespaces, c)
+	}
+	sort.Slice(res.Namespaces, func(i, j int) bool {
+		if res.Namespaces[i].GrowthPerDay != res.Namespaces[j].GrowthPerDay {
+			return res.Namespaces[i].GrowthPerDay > res.Namespaces[j].GrowthPerDay
+		}
+		return res.Namespaces[i].Namespace < res.Namespaces[j].Namespace
+	})
+	return res
+}
+
+// parseThresholds reads a comma-separated list of percentages
+func parseThresholds(raw string) ([]float64, error) {
+	if raw == "" {
+		return defaultThresholds, nil
+	}
+	var out []float64
+	for _, part := range strings.Split(raw, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid threshold %q", part)
+		}
+		out = append(out, v)
+	}
+	sort.Float64s(out)
+	return out, nil
+}
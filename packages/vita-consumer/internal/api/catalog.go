@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+const maxCatalogPage = 5000
+
+// CatalogResponse is one page of the catalog change feed. Clients pass
+// Cursor back as since until More is false.
+type CatalogResponse struct {
+	Cursor  int64                 `json:"cursor"`
+	More    bool                  `json:"more"`
+	Changes []store.CatalogChange `json:"changes"`
+}
+
+// handleCatalog serves /api/v1/catalog?since=<cursor>[&limit=]. since=0 (or
+// omitted) returns the whole catalog; later calls return only resources
+// changed since the cursor, each at most once with its current row, and
+// tombstones for deleted ones.
+func (s *Server) handleCatalog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var since int64
+	if v := r.URL.Query().Get("since"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			writeError(w, "since must be a cursor returned by a previous call", http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+	limit, ok := getQueryInt(r, "limit")
+	if !ok || limit <= 0 || limit > maxCatalogPage {
+		limit = maxCatalogPage
+	}
+
+	changes, more, err := s.sqlite.CatalogChanges(since, int(limit))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := CatalogResponse{Cursor: since, More: more, Changes: changes}
+	if len(changes) > 0 {
+		resp.Cursor = changes[len(changes)-1].Seq
+	}
+	writeJSON(w, resp)
+}
@@ -0,0 +1,132 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestCatalogFeed verifies /api/v1/catalog replays the whole catalog from
+// cursor 0 and afterwards returns only what changed
+func TestCatalogFeed(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		svc := synctest.Service("default", "api")
+		if _, err := env.Client.CoreV1().Services("default").Create(ctx, svc, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		var page api.CatalogResponse
+		if err := env.Eventually(synctest.Timeout, "service in catalog feed", func() (bool, error) {
+			if err := env.GetJSON("/api/v1/catalog?since=0", &page); err != nil {
+				return false, err
+			}
+			return findChange(page.Changes, "service", func(c store.CatalogChange) bool {
+				return c.Resource["uid"] == string(svc.UID)
+			}) != nil, nil
+		}); err != nil {
+			return err
+		}
+		if findChange(page.Changes, "namespace", func(c store.CatalogChange) bool { return c.Resource["name"] == "default" }) == nil {
+			return fmt.Errorf("namespace missing from full catalog")
+		}
+
+		cursor := page.Cursor
+		pvc := synctest.PVC("default", "cache")
+		if _, err := env.Client.CoreV1().PersistentVolumeClaims("default").Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Client.CoreV1().Services("default").Delete(ctx, svc.Name, metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+		return env.Eventually(synctest.Timeout, "incremental page has pvc and service tombstone only", func() (bool, error) {
+			if err := env.GetJSON(fmt.Sprintf("/api/v1/catalog?since=%d", cursor), &page); err != nil {
+				return false, err
+			}
+			tomb := findChange(page.Changes, "service", func(c store.CatalogChange) bool { return c.Deleted })
+			added := findChange(page.Changes, "pvc", func(c store.CatalogChange) bool { return c.Resource["uid"] == string(pvc.UID) })
+			if tomb == nil || added == nil {
+				return false, nil
+			}
+			if len(page.Changes) != 2 {
+				return false, fmt.Errorf("expected 2 changes since %d, got %d", cursor, len(page.Changes))
+			}
+			return true, nil
+		})
+	})
+}
+
+// TestLiveGeneration verifies live responses name the catalog generation
+// their metadata comes from, and that it moves with catalog changes
+func TestLiveGeneration(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		pod := synctest.Pod("default", "api-0", "node-a", nil)
+		created, err := env.Client.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pod synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pods")
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+		id, err := env.QueryInt("SELECT id FROM pods WHERE name = 'api-0'")
+		if err != nil {
+			return err
+		}
+		env.Ring.Add(buffer.Metric{Time: env.Clock.Now(), ResourceID: id, Type: "mem_mb", Value: 64})
+
+		var live api.LiveMetricsResponse
+		if err := env.GetJSON("/api/v1/metrics/live", &live); err != nil {
+			return err
+		}
+		gen, err := env.SQLite.CatalogGeneration()
+		if err != nil {
+			return err
+		}
+		if !live.Consistent || live.CatalogGeneration != gen || len(live.Pods) != 1 {
+			return fmt.Errorf("live: consistent %t, generation %d (catalog at %d), %d pods", live.Consistent, live.CatalogGeneration, gen, len(live.Pods))
+		}
+
+		// Moving the pod to another node is a catalog change
+		created.Spec.NodeName = "node-b"
+		if _, err := env.Client.CoreV1().Pods("default").Update(ctx, created, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pod moved", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pods p JOIN nodes n ON p.node_id = n.id WHERE n.name = 'node-b'")
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+		before := live.CatalogGeneration
+		if err := env.GetJSON("/api/v1/metrics/live", &live); err != nil {
+			return err
+		}
+		if live.CatalogGeneration <= before || len(live.Pods) != 1 || live.Pods[0].Node != "node-b" {
+			return fmt.Errorf("after the move: generation %d (was %d), pods %+v", live.CatalogGeneration, before, live.Pods)
+		}
+
+		flushes := env.Ring.Generation()
+		env.Ring.Flush()
+		if env.Ring.Generation() != flushes+1 {
+			return errors.New("flush did not advance the buffer generation")
+		}
+		return nil
+	})
+}
+
+func findChange(changes []store.CatalogChange, kind string, match func(store.CatalogChange) bool) *store.CatalogChange {
+	for i, c := range changes {
+		if c.Kind == kind && match(c) {
+			return &changes[i]
+		}
+	}
+	return nil
+}
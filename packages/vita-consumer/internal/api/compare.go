@@ -0,0 +1,221 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/lastseen"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/units"
+)
+
+const (
+	maxCompareSeries = 20
+	// defaultCompareColumns is how many time buckets a comparison without
+	// a step is split into
+	defaultCompareColumns = 300
+)
+
+// compareKinds maps the kinds that can be compared to their catalog table.
+// Pods and PVCs are compared directly; the others sum the values of their
+// pods.
+var compareKinds = map[string]string{
+	"pod":         "pods",
+	"pvc":         "pvcs",
+	"deployment":  "deployments",
+	"statefulset": "statefulsets",
+	"daemonset":   "daemonsets",
+	"namespace":   "namespaces",
+	"node":        "nodes",
+}
+
+// CompareSeries is one resource's values on the response's time grid,
+// null where it did not report
+type CompareSeries struct {
+	ID     int64      `json:"id"`
+	Name   string     `json:"name"`
+	Values []*float64 `json:"values"`
+}
+
+// CompareResponse holds aligned series: Series[i].Values[j] is the value
+// of resource i in the bucket starting at Times[j]
+type CompareResponse struct {
+	Type    string          `json:"type"`
+	Unit    string          `json:"unit"`
+	Kind    string          `json:"kind"`
+	From    int64           `json:"from"`
+	To      int64           `json:"to"`
+	Step    int64           `json:"step"`
+	Widened bool            `json:"widened,omitempty"`
+	Agg     string          `json:"agg"`
+	Times   []int64         `json:"times"`
+	Series  []CompareSeries `json:"series"`
+}
+
+// handleCompare serves /api/v1/metrics/compare?type=&kind=&ids=[&from=&to=&step=&agg=&unit=].
+// ids (comma-separated, at most 20) are pods or PVCs, or deployments,
+// statefulsets, daemonsets, namespaces or nodes whose pods' values are
+// summed. Every series shares one time grid; agg (default avg, or the
+// per-second rate of counters) reduces each resource to one value per
+// bucket.
+func (s *Server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	resp := CompareResponse{Type: q.Get("type"), Kind: q.Get("kind")}
+	if resp.Type == "" {
+		writeError(w, "type parameter is required", http.StatusBadRequest)
+		return
+	}
+	table, ok := compareKinds[resp.Kind]
+	if !ok {
+		writeError(w, "kind must be pod, pvc, deployment, statefulset, daemonset, namespace or node", http.StatusBadRequest)
+		return
+	}
+	metricKind, ok := lastseen.KindOf(resp.Type)
+	if !ok {
+		writeError(w, fmt.Sprintf("%s is not a per-pod or per-volume metric", resp.Type), http.StatusBadRequest)
+		return
+	}
+	if metricKind == lastseen.PVC && resp.Kind != "pvc" && resp.Kind != "namespace" || metricKind == lastseen.Pod && resp.Kind == "pvc" {
+		writeError(w, fmt.Sprintf("%s cannot be compared by %s", resp.Type, resp.Kind), http.StatusBadRequest)
+		return
+	}
+	ids, err := parseIDList(q.Get("ids"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conv, err := units.NewConverter(resp.Type, q.Get("unit"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp.Unit = conv.Unit
+	resp.Agg = q.Get("agg")
+	if conv.From.Counter {
+		resp.Agg = "rate"
+		if !conv.Rate {
+			resp.Unit += "/s"
+		}
+	} else if resp.Agg == "" {
+		resp.Agg = "avg"
+	} else if !gaugeAggs[resp.Agg] {
+		writeError(w, fmt.Sprintf("unsupported aggregation %q", resp.Agg), http.StatusBadRequest)
+		return
+	}
+
+	from, to := s.getTimeRange(r)
+	resp.From, resp.To = from.Unix(), to.Unix()
+	// The point limit covers every series together
+	span, columns := to.Sub(from), max(s.pointLimit(SeriesQuery{})/len(ids), 2)
+	resp.Step, _ = getQueryInt(r, "step")
+	if resp.Step <= 0 {
+		resp.Step = widenStep(span, min(columns, defaultCompareColumns), false)
+	} else if int64(span/time.Second)/resp.Step >= int64(columns) {
+		resp.Step = widenStep(span, columns-1, false)
+		resp.Widened = true
+	}
+
+	names, err := s.sqlite.ResourceNames(table, ids)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// members maps each resource id the store is asked for to the
+	// compared resources it counts towards
+	members := make(map[int64][]int)
+	var queryIDs []int64
+	for i, id := range ids {
+		if _, ok := names[id]; !ok {
+			writeError(w, fmt.Sprintf("%s %d not found", resp.Kind, id), http.StatusNotFound)
+			return
+		}
+		var ms []int64
+		switch {
+		case resp.Kind == "pod" || resp.Kind == "pvc":
+			ms = []int64{id}
+		case metricKind == lastseen.PVC:
+			ms, err = s.sqlite.NamespacePVCIDs(id)
+		default:
+			ms, err = s.sqlite.ScopePodIDs(resp.Kind, id)
+		}
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, m := range ms {
+			if len(members[m]) == 0 {
+				queryIDs = append(queryIDs, m)
+			}
+			members[m] = append(members[m], i)
+		}
+	}
+
+	memberKind := "pod"
+	if metricKind == lastseen.PVC {
+		memberKind = "pvc"
+	}
+	points, err := s.duck.QueryBucketedForResources(r.Context(), memberKind, resp.Type, queryIDs, from, to,
+		store.Bucketing{Step: time.Duration(resp.Step) * time.Second}, resp.Agg)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The grid covers every bucket of the range, reported or not
+	first := from.Unix() / resp.Step * resp.Step
+	for t := first; t < to.Unix(); t += resp.Step {
+		resp.Times = append(resp.Times, t)
+	}
+	resp.Series = make([]CompareSeries, len(ids))
+	for i, id := range ids {
+		resp.Series[i] = CompareSeries{ID: id, Name: names[id], Values: make([]*float64, len(resp.Times))}
+	}
+	for _, p := range points {
+		col := int((p.Time.Unix() - first) / resp.Step)
+		if col < 0 || col >= len(resp.Times) {
+			continue
+		}
+		for _, i := range members[p.ResourceID] {
+			v := resp.Series[i].Values
+			if v[col] == nil {
+				v[col] = new(float64)
+			}
+			*v[col] += conv.Apply(p.Value)
+		}
+	}
+	if resp.Times == nil {
+		resp.Times = []int64{}
+	}
+	writeJSON(w, resp)
+}
+
+// parseIDList parses the comma-separated ids of a comparison
+func parseIDList(raw string) ([]int64, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("ids parameter is required")
+	}
+	var ids []int64
+	seen := make(map[int64]bool)
+	for _, part := range strings.Split(raw, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid id %q", part)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > maxCompareSeries {
+		return nil, fmt.Errorf("at most %d resources can be compared", maxCompareSeries)
+	}
+	return ids, nil
+}
@@ -0,0 +1,107 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// TestCompare aligns deployments and pods on one time grid, leaving
+// gaps where a resource did not report
+func TestCompare(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		pods := map[string]int64{}
+		deps := map[string]int64{}
+		for _, d := range []struct {
+			name string
+			pods int
+		}{{"web", 2}, {"api", 1}} {
+			rs, id, err := env.CreateDeployment(ctx, synctest.Deployment("shop", d.name, int32(d.pods)))
+			if err != nil {
+				return err
+			}
+			deps[d.name] = id
+			var created []*corev1.Pod
+			for i := 0; i < d.pods; i++ {
+				created = append(created, synctest.Pod("shop", fmt.Sprintf("%s-%d", d.name, i), "node-a", rs))
+			}
+			ids, err := env.CreatePods(ctx, created...)
+			if err != nil {
+				return err
+			}
+			maps.Copy(pods, ids)
+		}
+
+		// web-0 at 100 MiB and 250m, web-1 at 200 MiB; api-0 at 50 MiB and 500m
+		// for the first three minutes only
+		start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+		var points []store.MetricPoint
+		for s := 0; s < 30; s++ {
+			at := start.Add(time.Duration(s) * 10 * time.Second)
+			points = append(points,
+				store.MetricPoint{Time: at, ResourceID: pods["web-0"], MetricType: "mem_mb", Value: 100},
+				store.MetricPoint{Time: at, ResourceID: pods["web-0"], MetricType: "cpu_ms", Value: float64(s * 2500)},
+				store.MetricPoint{Time: at, ResourceID: pods["web-1"], MetricType: "mem_mb", Value: 200})
+			if s < 18 {
+				points = append(points,
+					store.MetricPoint{Time: at, ResourceID: pods["api-0"], MetricType: "mem_mb", Value: 50},
+					store.MetricPoint{Time: at, ResourceID: pods["api-0"], MetricType: "cpu_ms", Value: float64(s * 5000)})
+			}
+		}
+		if err := env.Duck.BatchInsert(points); err != nil {
+			return err
+		}
+		base := fmt.Sprintf("/api/v1/metrics/compare?from=%d&to=%d&step=60", start.Unix(), start.Add(5*time.Minute).Unix())
+		values := func(s api.CompareSeries) []float64 {
+			out := make([]float64, len(s.Values))
+			for i, v := range s.Values {
+				out[i] = -1
+				if v != nil {
+					out[i] = *v
+				}
+			}
+			return out
+		}
+
+		var byDep api.CompareResponse
+		if err := env.GetJSON(fmt.Sprintf("%s&type=mem_mb&kind=deployment&ids=%d,%d", base, deps["web"], deps["api"]), &byDep); err != nil {
+			return err
+		}
+		if len(byDep.Times) != 5 || len(byDep.Series) != 2 || byDep.Series[0].Name != "web" || byDep.Series[1].Name != "api" {
+			return fmt.Errorf("deployment comparison: %+v", byDep)
+		}
+		if got := values(byDep.Series[0]); !slices.Equal(got, []float64{300, 300, 300, 300, 300}) {
+			return fmt.Errorf("web memory = %v, want the sum of its pods", got)
+		}
+		if got := values(byDep.Series[1]); !slices.Equal(got, []float64{50, 50, 50, -1, -1}) {
+			return fmt.Errorf("api memory = %v, want gaps after three minutes", got)
+		}
+
+		var byPod api.CompareResponse
+		if err := env.GetJSON(fmt.Sprintf("%s&type=cpu_ms&unit=millicores&kind=pod&ids=%d,%d", base, pods["web-0"], pods["api-0"]), &byPod); err != nil {
+			return err
+		}
+		if byPod.Agg != "rate" || byPod.Unit != "millicores" || len(byPod.Series) != 2 {
+			return fmt.Errorf("pod comparison: %+v", byPod)
+		}
+		if got := values(byPod.Series[0]); !slices.Equal(got, []float64{250, 250, 250, 250, 250}) {
+			return fmt.Errorf("web-0 cpu = %v, want 250m throughout", got)
+		}
+		if got := values(byPod.Series[1]); !slices.Equal(got, []float64{500, 500, 500, -1, -1}) {
+			return fmt.Errorf("api-0 cpu = %v, want 500m then gaps", got)
+		}
+
+		if err := env.GetJSON(base+"&type=mem_mb&kind=pod&ids=999999", nil); err == nil {
+			return fmt.Errorf("unknown pod accepted")
+		}
+		return nil
+	})
+}
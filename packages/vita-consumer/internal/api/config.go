@@ -0,0 +1,69 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+)
+
+// ConfigConsumer is a pod referencing a configmap or secret
+type ConfigConsumer struct {
+	Pod
+	Via []string `json:"via"` // "volume", "env"
+}
+
+func (s *Server) handleConfigMapConsumers(w http.ResponseWriter, r *http.Request) {
+	s.serveConfigConsumers(w, r, "configmap")
+}
+
+func (s *Server) handleSecretConsumers(w http.ResponseWriter, r *http.Request) {
+	s.serveConfigConsumers(w, r, "secret")
+}
+
+// serveConfigConsumers lists the pods that mount or read env from the named
+// object, i.e. the blast radius of changing it. ?namespace= narrows it down
+// since names are only unique per namespace.
+func (s *Server) serveConfigConsumers(w http.ResponseWriter, r *http.Request, kind string) {
+	query := `
+		SELECT p.id, p.name, p.uid, p.namespace_id, ns.name, p.node_id, n.name, p.deployment_id, d.name,
+			GROUP_CONCAT(DISTINCT cr.via)
+		FROM config_refs cr
+		JOIN pods p ON cr.pod_id = p.id
+		JOIN namespaces ns ON p.namespace_id = ns.id
+		JOIN nodes n ON p.node_id = n.id
+		LEFT JOIN deployments d ON p.deployment_id = d.id
+		WHERE cr.kind = ? AND cr.name = ?
+	`
+	args := []interface{}{kind, r.PathValue("name")}
+
+	if nsID, ok := getQueryInt(r, "namespace"); ok {
+		query += " AND p.namespace_id = ?"
+		args = append(args, nsID)
+	}
+
+	query += " GROUP BY p.id ORDER BY ns.name, p.name"
+
+	rows, err := s.sqlite.Query(query, args...)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	consumers := []ConfigConsumer{}
+	for rows.Next() {
+		var c ConfigConsumer
+		var depName sql.NullString
+		var via string
+		if err := rows.Scan(&c.ID, &c.Name, &c.UID, &c.NamespaceID, &c.Namespace, &c.NodeID, &c.NodeName, &c.DeploymentID, &depName, &via); err != nil {
+			continue
+		}
+		if depName.Valid {
+			c.Deployment = &depName.String
+		}
+		c.Via = strings.Split(via, ",")
+		consumers = append(consumers, c)
+	}
+
+	writeJSON(w, consumers)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Node coverage states
+const (
+	coverageReady    = "ready"     // a running pod with all containers ready
+	coverageNotReady = "not_ready" // pods, but none running and ready
+	coverageMissing  = "missing"   // no live pod of the DaemonSet
+)
+
+// DaemonSetCoverageResponse reports which nodes run a DaemonSet pod.
+// Desired is the controller's count of nodes the DaemonSet targets; when
+// it is below the node count, missing nodes may be excluded by node
+// selectors or taints rather than failing.
+type DaemonSetCoverageResponse struct {
+	DaemonSetID int64          `json:"daemonset_id"`
+	Name        string         `json:"name"`
+	Namespace   string         `json:"namespace"`
+	Desired     *int32         `json:"desired,omitempty"`
+	Ready       int            `json:"ready"`
+	NotReady    int            `json:"not_ready"`
+	Missing     int            `json:"missing"`
+	Nodes       []NodeCoverage `json:"nodes"`
+}
+
+type NodeCoverage struct {
+	NodeID int64         `json:"node_id"`
+	Node   string        `json:"node"`
+	Status string        `json:"status"`
+	Pods   []CoveragePod `json:"pods,omitempty"`
+}
+
+type CoveragePod struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Phase string `json:"phase"`
+	Ready bool   `json:"ready"`
+}
+
+// handleDaemonSetCoverage serves GET /api/v1/daemonsets/{id}/coverage[?status=].
+// Nodes and pods deleted from the cluster are left out; pods that
+// completed or failed do not cover their node. status filters the nodes
+// listed, the counts always cover all nodes.
+func (s *Server) handleDaemonSetCoverage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	filter := r.URL.Query().Get("status")
+	switch filter {
+	case "", coverageReady, coverageNotReady, coverageMissing:
+	default:
+		writeError(w, "status must be ready, not_ready or missing", http.StatusBadRequest)
+		return
+	}
+
+	ds, err := s.sqlite.GetDaemonSet(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, "DaemonSet not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows, err := s.sqlite.DaemonSetCoverage(id)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := DaemonSetCoverageResponse{
+		DaemonSetID: id,
+		Name:        ds.Name,
+		Namespace:   ds.Namespace,
+		Desired:     ds.Desired,
+		Nodes:       []NodeCoverage{},
+	}
+	// By name: a node may have a stub row from before it was first seen
+	var nodes []NodeCoverage
+	for _, row := range rows {
+		if n := len(nodes); n == 0 || nodes[n-1].Node != row.NodeName {
+			nodes = append(nodes, NodeCoverage{NodeID: row.NodeID, Node: row.NodeName, Status: coverageMissing})
+		}
+		if row.PodID == nil {
+			continue
+		}
+		phase := ""
+		if row.Phase != nil {
+			phase = *row.Phase
+		}
+		if phase == "Succeeded" || phase == "Failed" {
+			continue
+		}
+		node := &nodes[len(nodes)-1]
+		node.Pods = append(node.Pods, CoveragePod{ID: *row.PodID, Name: *row.PodName, Phase: phase, Ready: row.Ready})
+		if phase == "Running" && row.Ready {
+			node.Status = coverageReady
+		} else if node.Status == coverageMissing {
+			node.Status = coverageNotReady
+		}
+	}
+
+	for _, n := range nodes {
+		switch n.Status {
+		case coverageReady:
+			resp.Ready++
+		case coverageNotReady:
+			resp.NotReady++
+		default:
+			resp.Missing++
+		}
+		if filter == "" || filter == n.Status {
+			resp.Nodes = append(resp.Nodes, n)
+		}
+	}
+	writeJSON(w, resp)
+}
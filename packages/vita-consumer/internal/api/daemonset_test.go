@@ -0,0 +1,84 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestDaemonSetCoverage reports nodes with a ready, unready or no
+// DaemonSet pod, following pod and node deletions
+func TestDaemonSetCoverage(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		for _, name := range []string{"node-a", "node-b", "node-c"} {
+			if _, err := env.Client.CoreV1().Nodes().Create(ctx, synctest.Node(name, "4", "8Gi"), metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		ds := synctest.DaemonSet("kube-system", "vita-agent")
+		if _, err := env.Client.AppsV1().DaemonSets("kube-system").Create(ctx, ds, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "nodes and daemonset synced", func() (bool, error) {
+			nodes, err := env.QueryInt("SELECT COUNT(*) FROM nodes")
+			if err != nil {
+				return false, err
+			}
+			n, err := env.QueryInt("SELECT COUNT(*) FROM daemonsets WHERE uid = ?", string(ds.UID))
+			return nodes == 3 && n == 1, err
+		}); err != nil {
+			return err
+		}
+		for node, ready := range map[string]bool{"node-a": true, "node-b": false} {
+			if _, err := env.Client.CoreV1().Pods("kube-system").Create(ctx, synctest.DaemonPod(ds, node, ready), metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		if err := env.Eventually(synctest.Timeout, "pods synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pods WHERE phase IS NOT NULL")
+			return n == 2, err
+		}); err != nil {
+			return err
+		}
+		dsID, err := env.QueryInt("SELECT id FROM daemonsets WHERE uid = ?", string(ds.UID))
+		if err != nil {
+			return err
+		}
+		path := fmt.Sprintf("/api/v1/daemonsets/%d/coverage", dsID)
+
+		var cov api.DaemonSetCoverageResponse
+		if err := env.GetJSON(path, &cov); err != nil {
+			return err
+		}
+		status := func() map[string]string {
+			out := map[string]string{}
+			for _, n := range cov.Nodes {
+				out[n.Node] = n.Status
+			}
+			return out
+		}
+		if got := status(); cov.Ready != 1 || cov.NotReady != 1 || cov.Missing != 1 ||
+			got["node-a"] != "ready" || got["node-b"] != "not_ready" || got["node-c"] != "missing" {
+			return fmt.Errorf("coverage = %v (%d/%d/%d), want node-a ready, node-b not_ready, node-c missing", got, cov.Ready, cov.NotReady, cov.Missing)
+		}
+
+		// A deleted pod no longer covers its node; a deleted node is not listed
+		if err := env.Client.CoreV1().Pods("kube-system").Delete(ctx, "vita-agent-node-a", metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+		if err := env.Client.CoreV1().Nodes().Delete(ctx, "node-c", metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+		return env.Eventually(synctest.Timeout, "deletions reflected", func() (bool, error) {
+			cov = api.DaemonSetCoverageResponse{}
+			if err := env.GetJSON(path+"?status=missing", &cov); err != nil {
+				return false, err
+			}
+			return cov.Missing == 1 && len(cov.Nodes) == 1 && cov.Nodes[0].Node == "node-a", nil
+		})
+	})
+}
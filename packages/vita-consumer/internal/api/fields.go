@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// fieldSet is a ?fields= projection: the JSON keys to keep, each with the
+// keys to keep below it (nil keeps the whole value)
+type fieldSet map[string]fieldSet
+
+// parseFields reads ?fields=id,name,containers.image. It returns nil,
+// keeping everything, when the parameter is absent or empty.
+func parseFields(r *http.Request) fieldSet {
+	raw := r.URL.Query().Get("fields")
+	if raw == "" {
+		return nil
+	}
+	var fs fieldSet
+	for _, path := range strings.Split(raw, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if fs == nil {
+			fs = fieldSet{}
+		}
+		node := fs
+		parts := strings.Split(path, ".")
+		for i, part := range parts {
+			if i == len(parts)-1 {
+				// "containers" after "containers.image" keeps all of it
+				node[part] = nil
+				break
+			}
+			child, seen := node[part]
+			if seen && child == nil {
+				break // already kept whole
+			}
+			if child == nil {
+				child = fieldSet{}
+				node[part] = child
+			}
+			node = child
+		}
+	}
+	return fs
+}
+
+// apply keeps only the selected keys of objects; arrays are projected
+// element by element. Unknown names are ignored.
+func (fs fieldSet) apply(v interface{}) interface{} {
+	if fs == nil {
+		return v
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			sub, ok := fs[k]
+			if !ok {
+				delete(v, k)
+				continue
+			}
+			v[k] = sub.apply(child)
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = fs.apply(v[i])
+		}
+		return v
+	}
+	return v
+}
+
+// project re-encodes data through the request's ?fields= selection
+func project(r *http.Request, data interface{}) (interface{}, error) {
+	fs := parseFields(r)
+	if fs == nil {
+		return data, nil
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber() // ids stay exact
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return fs.apply(v), nil
+}
+
+// writeFields writes data as JSON, reduced to the fields the request
+// selects with ?fields=
+func writeFields(w http.ResponseWriter, r *http.Request, data interface{}) {
+	v, err := project(r, data)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, v)
+}
@@ -0,0 +1,58 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestFieldsProjection trims list and detail responses with ?fields=
+func TestFieldsProjection(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		pod := synctest.Pod("default", "web-1", "node-a", nil)
+		pod.Spec.Containers = []corev1.Container{{Name: "app", Image: "nginx:1.27"}}
+		if _, err := env.Client.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pod synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pod_containers")
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+
+		var list []map[string]interface{}
+		if err := env.GetJSON("/api/v1/pods?fields=id,name,namespace,nope", &list); err != nil {
+			return err
+		}
+		if len(list) != 1 || len(list[0]) != 3 || list[0]["name"] != "web-1" || list[0]["namespace"] != "default" || list[0]["id"] == nil {
+			return fmt.Errorf("projected list = %v, want id, name and namespace only", list)
+		}
+
+		id := int64(list[0]["id"].(float64))
+		var detail map[string]interface{}
+		if err := env.GetJSON(fmt.Sprintf("/api/v1/pods/%d?fields=name,containers.image", id), &detail); err != nil {
+			return err
+		}
+		containers, _ := detail["containers"].([]interface{})
+		if len(detail) != 2 || len(containers) != 1 {
+			return fmt.Errorf("projected detail = %v, want name and containers", detail)
+		}
+		if c, _ := containers[0].(map[string]interface{}); len(c) != 1 || c["image"] != "nginx:1.27" {
+			return fmt.Errorf("projected container = %v, want its image only", containers[0])
+		}
+
+		var full []map[string]interface{}
+		if err := env.GetJSON("/api/v1/pods", &full); err != nil {
+			return err
+		}
+		if len(full) != 1 || full[0]["uid"] == nil {
+			return fmt.Errorf("unprojected list = %v, want every field", full)
+		}
+		return nil
+	})
+}
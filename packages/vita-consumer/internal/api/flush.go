@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/persist"
+)
+
+// defaultFlushTimeout bounds how long POST /api/v1/admin/flush waits for
+// sinks when ?timeout= is absent
+const defaultFlushTimeout = 30 * time.Second
+
+// Flusher persists the ring buffer on demand and reports on past flushes
+type Flusher interface {
+	Flush(ctx context.Context) (persist.FlushReport, error)
+	Report() persist.FlushReport
+}
+
+// FlushResponse is the outcome of a manual flush. Complete is false when a
+// sink failed or the timeout passed first; see the sinks' pending counts.
+type FlushResponse struct {
+	persist.FlushReport
+	Complete bool   `json:"complete"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SetFlusher enables GET and POST /api/v1/admin/flush
+func (s *Server) SetFlusher(f Flusher) {
+	s.flusher = f
+}
+
+// handleFlushStatus serves GET /api/v1/admin/flush: when the buffer was
+// last flushed, how much it held, and each sink's last write and backlog
+func (s *Server) handleFlushStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.flusher.Report())
+}
+
+// handleFlush serves POST /api/v1/admin/flush[?timeout=seconds]: drains
+// the buffer and waits until every sink has written it, so operators can
+// confirm nothing is lost before a planned restart. Answers 503 with the
+// report when a sink failed or the timeout (default 30s) passed.
+func (s *Server) handleFlush(w http.ResponseWriter, r *http.Request) {
+	timeout := defaultFlushTimeout
+	if sec, ok := getQueryInt(r, "timeout"); ok && sec > 0 {
+		timeout = time.Duration(sec) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	report, err := s.flusher.Flush(ctx)
+	resp := FlushResponse{FlushReport: report, Complete: err == nil}
+	code := http.StatusOK
+	if err != nil {
+		resp.Error = err.Error()
+		code = http.StatusServiceUnavailable
+	}
+	log.Printf("Audit: %s flushed %d metrics in %dms (complete: %t)", requester(r), report.Metrics, report.DurationMs, resp.Complete)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,111 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/persist"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+)
+
+// brokenSink fails every write
+type brokenSink struct{}
+
+func (brokenSink) Name() string { return "broken" }
+
+func (brokenSink) Write(ctx context.Context, batch []buffer.Metric) error {
+	return errors.New("disk full")
+}
+
+// TestManualFlush forces a flush through the admin endpoint and checks
+// that it waits for sinks that batch by interval and reports failures, and
+// that anonymous callers cannot force one
+func TestManualFlush(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		ring := buffer.NewRingBuffer(100)
+		ring.SetClock(env.Clock)
+		pipeline := persist.NewPipeline(ring)
+		pipeline.SetClock(env.Clock)
+		// Would only write hourly without a forced flush
+		pipeline.Register(persist.NewDuckDBSink(env.Duck), persist.SinkOptions{Interval: time.Hour})
+		runCtx, stop := context.WithCancel(ctx)
+		defer stop()
+		go pipeline.Run(runCtx)
+
+		server := api.NewServer(env.SQLite, env.Duck, ring, nil)
+		server.SetFlusher(pipeline)
+		mux := http.NewServeMux()
+		server.RegisterRoutes(mux)
+		handler := api.Authenticate(synctest.Auth{}, mux)
+		send := func(method, token string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(method, "/api/v1/admin/flush?timeout=5", nil).WithContext(ctx)
+			r.Header.Set("Authorization", "Bearer "+token)
+			handler.ServeHTTP(rec, r)
+			return rec
+		}
+		call := func(method string, out interface{}) (int, error) {
+			rec := send(method, synctest.AdminToken)
+			return rec.Code, json.Unmarshal(rec.Body.Bytes(), out)
+		}
+
+		now := env.Clock.Now()
+		ring.AddBatch([]buffer.Metric{
+			{Time: now.Add(-2 * time.Second), ResourceID: 9001, Type: "mem_mb", Value: 1},
+			{Time: now.Add(-time.Second), ResourceID: 9001, Type: "mem_mb", Value: 2},
+		})
+		if rec := send(http.MethodPost, "guess"); rec.Code != http.StatusForbidden || ring.Len() != 2 {
+			return fmt.Errorf("anonymous flush: status %d, %d metrics left buffered", rec.Code, ring.Len())
+		}
+		var resp api.FlushResponse
+		code, err := call(http.MethodPost, &resp)
+		if err != nil {
+			return err
+		}
+		if code != http.StatusOK || !resp.Complete || resp.Metrics != 2 || resp.Trigger != "manual" {
+			return fmt.Errorf("flush: status %d, response %+v", code, resp)
+		}
+		if len(resp.Sinks) != 1 || resp.Sinks[0].Written != 2 || resp.Sinks[0].Pending != 0 || resp.Sinks[0].LastWrite == nil {
+			return fmt.Errorf("flush sinks = %+v, want duckdb with 2 written", resp.Sinks)
+		}
+		points, err := env.Duck.QuerySeries(ctx, "", 9001, []string{"mem_mb"}, now.Add(-time.Minute), now.Add(time.Minute))
+		if err != nil {
+			return err
+		}
+		if len(points) != 2 {
+			return fmt.Errorf("stored %d points after the flush returned, want 2", len(points))
+		}
+
+		var report persist.FlushReport
+		if code, err = call(http.MethodGet, &report); err != nil {
+			return err
+		}
+		if code != http.StatusOK || report.Time == nil || report.Metrics != 2 || report.Buffered != 0 {
+			return fmt.Errorf("flush status: %d %+v", code, report)
+		}
+
+		// A failing sink makes the flush incomplete, with its metrics pending
+		broken := persist.NewPipeline(ring)
+		broken.SetClock(env.Clock)
+		broken.Register(brokenSink{}, persist.SinkOptions{})
+		go broken.Run(runCtx)
+		server.SetFlusher(broken)
+		ring.Add(buffer.Metric{Time: now, ResourceID: 9001, Type: "mem_mb", Value: 3})
+		resp = api.FlushResponse{}
+		if code, err = call(http.MethodPost, &resp); err != nil {
+			return err
+		}
+		if code != http.StatusServiceUnavailable || resp.Complete || len(resp.Sinks) != 1 || resp.Sinks[0].Pending != 1 || resp.Sinks[0].Error == "" {
+			return fmt.Errorf("failing flush: status %d, response %+v", code, resp)
+		}
+		return nil
+	})
+}
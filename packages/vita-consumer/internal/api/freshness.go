@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/lastseen"
+)
+
+// staleAfter is how long a resource may go without reporting before it is
+// considered stale; several agent intervals, so a slow scrape is not flagged
+const staleAfter = 2 * time.Minute
+
+// Freshness tells resources that stopped reporting (dead but not yet
+// deleted) from quiet ones
+type Freshness struct {
+	LastSeen *int64 `json:"last_seen,omitempty"` // unix seconds of the latest sample
+	Stale    bool   `json:"stale"`
+}
+
+func (s *Server) freshness(kind lastseen.Kind, id int64) Freshness {
+	var f Freshness
+	if at, ok := s.seen.LastSeen(kind, id); ok {
+		unix := at.Unix()
+		f.LastSeen = &unix
+	}
+	f.Stale = s.seen.Stale(kind, id, staleAfter)
+	return f
+}
+
+// keepStale applies ?stale=true|false; without it everything is kept
+func keepStale(r *http.Request, stale bool) bool {
+	switch r.URL.Query().Get("stale") {
+	case "true":
+		return stale
+	case "false":
+		return !stale
+	}
+	return true
+}
+
+// nodeFreshness derives a node's last-seen time from its pods: a node is
+// stale when none of its pods reported recently
+func (s *Server) nodeFreshness(r *http.Request, nodes []Node) ([]Node, error) {
+	rows, err := s.sqlite.Query("SELECT id, node_id FROM pods")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	latest := make(map[int64]time.Time)
+	for rows.Next() {
+		var pod, node int64
+		if err := rows.Scan(&pod, &node); err != nil {
+			continue
+		}
+		if at, ok := s.seen.LastSeen(lastseen.Pod, pod); ok && at.After(latest[node]) {
+			latest[node] = at
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	out := nodes[:0]
+	for _, n := range nodes {
+		at, ok := latest[n.ID]
+		if ok {
+			unix := at.Unix()
+			n.LastSeen = &unix
+		}
+		n.Stale = s.seen.StaleAt(at, ok, staleAfter)
+		if keepStale(r, n.Stale) {
+			out = append(out, n)
+		}
+	}
+	return out, nil
+}
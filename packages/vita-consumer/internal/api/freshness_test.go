@@ -0,0 +1,66 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestStalePods verifies a pod that stops reporting is marked stale and
+// can be filtered, while one that keeps reporting is not
+func TestStalePods(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		for _, name := range []string{"quiet", "dead"} {
+			if _, err := env.Client.CoreV1().Pods("default").Create(ctx, synctest.Pod("default", name, "node-a", nil), metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		if err := env.Eventually(synctest.Timeout, "pods synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pods")
+			return n == 2, err
+		}); err != nil {
+			return err
+		}
+		quiet, err := env.QueryInt("SELECT id FROM pods WHERE name = 'quiet'")
+		if err != nil {
+			return err
+		}
+		dead, err := env.QueryInt("SELECT id FROM pods WHERE name = 'dead'")
+		if err != nil {
+			return err
+		}
+
+		start := env.Clock.Now()
+		env.Ring.Add(buffer.Metric{Time: start, ResourceID: dead, Type: "mem_mb", Value: 10})
+		for i := 0; i < 5; i++ {
+			env.Clock.Advance(time.Minute)
+			env.Ring.Add(buffer.Metric{Time: env.Clock.Now(), ResourceID: quiet, Type: "mem_mb", Value: 10})
+		}
+
+		var stale []api.Pod
+		if err := env.GetJSON("/api/v1/pods?stale=true", &stale); err != nil {
+			return err
+		}
+		if len(stale) != 1 || stale[0].ID != dead {
+			return fmt.Errorf("stale pods = %+v, want only %d", stale, dead)
+		}
+		if stale[0].LastSeen == nil || *stale[0].LastSeen != start.Unix() {
+			return fmt.Errorf("dead pod last_seen = %v, want %d", stale[0].LastSeen, start.Unix())
+		}
+
+		var nodes []api.Node
+		if err := env.GetJSON("/api/v1/nodes?stale=false", &nodes); err != nil {
+			return err
+		}
+		if len(nodes) != 1 || nodes[0].Name != "node-a" {
+			return fmt.Errorf("fresh nodes = %+v, want node-a", nodes)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// HealthScore is a workload's latest health score, 0-100 with 100 healthy,
+// and the signals over the hour before it was computed
+type HealthScore struct {
+	Score            float64 `json:"score"`
+	ScoredAt         int64   `json:"scored_at"`
+	Restarts         int     `json:"restarts"`
+	OOMKills         int     `json:"oom_kills"`
+	ThrottledPct     float64 `json:"throttled_pct"`
+	CPUSaturationPct float64 `json:"cpu_saturation_pct"`
+	MemSaturationPct float64 `json:"mem_saturation_pct"`
+	Errors           int     `json:"errors"`
+}
+
+// WorkloadHealth is one scored workload
+type WorkloadHealth struct {
+	Kind      string `json:"kind"`
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	HealthScore
+}
+
+// WorkloadHealthHistory is a workload's scores over time, oldest first
+type WorkloadHealthHistory struct {
+	Kind   string        `json:"kind"`
+	ID     int64         `json:"id"`
+	From   int64         `json:"from"`
+	To     int64         `json:"to"`
+	Scores []HealthScore `json:"scores"`
+}
+
+// SetWorkloadHealth adds health scores to the deployment list, enables
+// ?sort=health there and serves /api/v1/health/workloads
+func (s *Server) SetWorkloadHealth(enabled bool) {
+	s.health = enabled
+}
+
+func healthScore(h store.WorkloadHealth) HealthScore {
+	return HealthScore{
+		Score:            h.Score,
+		ScoredAt:         h.Time.Unix(),
+		Restarts:         h.Restarts,
+		OOMKills:         h.OOMKills,
+		ThrottledPct:     h.ThrottledPct,
+		CPUSaturationPct: h.CPUSaturationPct,
+		MemSaturationPct: h.MemSaturationPct,
+		Errors:           h.Errors,
+	}
+}
+
+// latestHealth returns the latest score of each workload of kind by id
+func (s *Server) latestHealth(kind string) (map[int64]*HealthScore, error) {
+	scores, err := s.sqlite.LatestWorkloadHealth(kind)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int64]*HealthScore, len(scores))
+	for _, h := range scores {
+		hs := healthScore(h)
+		out[h.WorkloadID] = &hs
+	}
+	return out, nil
+}
+
+// sortByHealth orders items worst first, unscored ones last, when the
+// request asks for ?sort=health
+func sortByHealth[T any](r *http.Request, items []T, health func(T) *HealthScore) {
+	if r.URL.Query().Get("sort") != "health" {
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := health(items[i]), health(items[j])
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return a.Score < b.Score
+	})
+}
+
+// handleWorkloadHealth serves GET /api/v1/health/workloads[?kind=&namespace=&limit=]:
+// the latest score of every workload, worst first
+func (s *Server) handleWorkloadHealth(w http.ResponseWriter, r *http.Request) {
+	kind := r.URL.Query().Get("kind")
+	if _, ok := store.WorkloadKinds[kind]; kind != "" && !ok {
+		writeError(w, "kind must be deployment, statefulset or daemonset", http.StatusBadRequest)
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+
+	scores, err := s.sqlite.LatestWorkloadHealth(kind)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	names, err := s.workloadNames()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	out := []WorkloadHealth{}
+	for _, h := range scores {
+		wl, ok := names[workloadRef{h.Kind, h.WorkloadID}]
+		if !ok || namespace != "" && wl.Namespace != namespace {
+			continue
+		}
+		wl.HealthScore = healthScore(h)
+		out = append(out, wl)
+	}
+	if limit, ok := getQueryInt(r, "limit"); ok && limit > 0 && int(limit) < len(out) {
+		out = out[:limit]
+	}
+	writeFields(w, r, out)
+}
+
+type workloadRef struct {
+	kind string
+	id   int64
+}
+
+// workloadNames returns the name and namespace of every workload
+func (s *Server) workloadNames() (map[workloadRef]WorkloadHealth, error) {
+	out := make(map[workloadRef]WorkloadHealth)
+	for kind, table := range store.WorkloadKinds {
+		rows, err := s.sqlite.Query(fmt.Sprintf(`SELECT w.id, w.name, n.name FROM %s w
+			JOIN namespaces n ON w.namespace_id = n.id`, table))
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			wl := WorkloadHealth{Kind: kind}
+			if err := rows.Scan(&wl.ID, &wl.Name, &wl.Namespace); err != nil {
+				continue
+			}
+			out[workloadRef{kind, wl.ID}] = wl
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// handleWorkloadHealthHistory serves GET /api/v1/health/workloads/{kind}/{id}/history[?hours=]
+// with the scores of the last hours (default 24)
+func (s *Server) handleWorkloadHealthHistory(w http.ResponseWriter, r *http.Request) {
+	kind := r.PathValue("kind")
+	if _, ok := store.WorkloadKinds[kind]; !ok {
+		writeError(w, "kind must be deployment, statefulset or daemonset", http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	hours, ok := getQueryInt(r, "hours")
+	if !ok || hours <= 0 {
+		hours = 24
+	}
+
+	to := s.clock.Now().Add(time.Second)
+	from := to.Add(-time.Duration(hours) * time.Hour)
+	scores, err := s.sqlite.WorkloadHealthHistory(kind, id, from, to)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := WorkloadHealthHistory{Kind: kind, ID: id, From: from.Unix(), To: to.Unix(), Scores: []HealthScore{}}
+	for _, h := range scores {
+		resp.Scores = append(resp.Scores, healthScore(h))
+	}
+	writeJSON(w, resp)
+}
@@ -0,0 +1,127 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/health"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestWorkloadHealth verifies restarts, OOM kills, crash loops and memory
+// saturation lower a deployment's score, and that the deployment list can
+// be sorted worst first
+func TestWorkloadHealth(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		pods := map[string]*corev1.Pod{}
+		for _, name := range []string{"web", "api"} {
+			rs, _, err := env.CreateDeployment(ctx, synctest.Deployment("shop", name, 1))
+			if err != nil {
+				return err
+			}
+			pod := synctest.Pod("shop", name+"-0", "node-a", rs)
+			pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("100Mi")}
+			if _, err := env.CreatePods(ctx, pod); err != nil {
+				return err
+			}
+			pods[name] = pod
+		}
+
+		scorer := health.NewScorer(env.SQLite, env.Duck, health.Config{})
+		score := func(now time.Time) error {
+			scores, err := scorer.Score(ctx, now)
+			if err != nil {
+				return err
+			}
+			return env.SQLite.InsertWorkloadHealth(scores)
+		}
+		start := env.Clock.Now()
+		if err := score(start); err != nil {
+			return err
+		}
+
+		// web crash loops after an OOM kill; api runs close to its memory limit
+		web := pods["web"]
+		web.Status.ContainerStatuses = []corev1.ContainerStatus{{
+			Name:         "app",
+			RestartCount: 2,
+			State:        corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+			LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+				Reason: "OOMKilled", ExitCode: 137, FinishedAt: metav1.NewTime(start.Add(time.Minute)),
+			}},
+		}}
+		if _, err := env.Client.CoreV1().Pods("shop").UpdateStatus(ctx, web, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "container state recorded", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pods WHERE restarts = 2 AND waiting_reason = 'CrashLoopBackOff' AND oom_killed_at IS NOT NULL")
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+		apiID, err := env.QueryInt("SELECT id FROM pods WHERE name = 'api-0'")
+		if err != nil {
+			return err
+		}
+		if err := env.Duck.BatchInsert([]store.MetricPoint{
+			{Time: start.Add(2 * time.Minute), ResourceID: apiID, MetricType: "mem_mb", Value: 95},
+		}); err != nil {
+			return err
+		}
+		now := start.Add(5 * time.Minute)
+		env.Clock.Set(now)
+		if err := score(now); err != nil {
+			return err
+		}
+
+		var deployments []api.Deployment
+		if err := env.GetJSON("/api/v1/deployments?sort=health", &deployments); err != nil {
+			return err
+		}
+		if len(deployments) != 2 || deployments[0].Name != "web" || deployments[0].Health == nil || deployments[1].Health == nil {
+			return fmt.Errorf("deployments sorted by health: %+v", deployments)
+		}
+		// 2 restarts (20) + an OOM kill (20) + a crash loop (15)
+		if h := deployments[0].Health; h.Score != 45 || h.Restarts != 2 || h.OOMKills != 1 || h.Errors != 1 {
+			return fmt.Errorf("web health: %+v", *h)
+		}
+		// 95% of the memory limit: 15 points above the 80% floor
+		if h := deployments[1].Health; h.Score != 85 || h.MemSaturationPct != 95 {
+			return fmt.Errorf("api health: %+v", *h)
+		}
+
+		var worst []api.WorkloadHealth
+		if err := env.GetJSON("/api/v1/health/workloads?kind=deployment&limit=1", &worst); err != nil {
+			return err
+		}
+		if len(worst) != 1 || worst[0].Name != "web" || worst[0].Namespace != "shop" {
+			return fmt.Errorf("worst workloads: %+v", worst)
+		}
+		var history api.WorkloadHealthHistory
+		if err := env.GetJSON(fmt.Sprintf("/api/v1/health/workloads/deployment/%d/history", worst[0].ID), &history); err != nil {
+			return err
+		}
+		if len(history.Scores) != 2 || history.Scores[0].Score != 100 || history.Scores[1].Score != 45 {
+			return fmt.Errorf("web history: %+v", history.Scores)
+		}
+
+		// Nothing new happened: the restarts still count within the hour
+		if err := score(now.Add(5 * time.Minute)); err != nil {
+			return err
+		}
+		if err := env.GetJSON("/api/v1/health/workloads?kind=deployment&limit=1", &worst); err != nil {
+			return err
+		}
+		if worst[0].Restarts != 2 || worst[0].OOMKills != 1 {
+			return fmt.Errorf("restarts counted again or dropped: %+v", worst[0])
+		}
+		return nil
+	})
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/lastseen"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/units"
+)
+
+const (
+	defaultHeatmapBuckets = 20
+	maxHeatmapBuckets     = 100
+	// defaultHeatmapColumns is how many time buckets a heatmap without a
+	// step is split into
+	defaultHeatmapColumns = 120
+)
+
+// gaugeAggs are the aggregations of gauge values per time bucket
+var gaugeAggs = map[string]bool{"avg": true, "min": true, "max": true, "last": true}
+
+// heatmapScopes are the parameters selecting the resources of a heatmap
+var heatmapScopes = []string{"deployment", "statefulset", "daemonset", "namespace", "node"}
+
+// HeatmapResponse is a time x value-bucket matrix: Counts[i][j] is how many
+// resources had a value in [Edges[j], Edges[j+1]) during the time bucket
+// starting at Times[i]. Time buckets without data are left out.
+type HeatmapResponse struct {
+	Type      string    `json:"type"`
+	Unit      string    `json:"unit"`
+	Scope     string    `json:"scope"`
+	ScopeID   int64     `json:"scope_id"`
+	From      int64     `json:"from"`
+	To        int64     `json:"to"`
+	Step      int64     `json:"step"`
+	TZ        string    `json:"tz,omitempty"`
+	Agg       string    `json:"agg"`
+	Resources int       `json:"resources"` // resources in scope
+	Edges     []float64 `json:"edges"`     // buckets+1 boundaries, in unit
+	Times     []int64   `json:"times"`
+	Counts    [][]int64 `json:"counts"`
+	Max       int64     `json:"max"` // largest count, for color scales
+}
+
+// handleHeatmap serves /api/v1/metrics/heatmap?type=&<scope>=[&from=&to=&step=&tz=&buckets=&min=&max=&agg=&unit=]
+// where scope is deployment, statefulset, daemonset, namespace or node
+// (namespace also covers PVC metrics). Each resource's value per time
+// bucket (agg, default avg, or the per-second rate of counters) is counted
+// into one of buckets value ranges between min and max, which default to
+// the range of the data; values outside go to the edge buckets.
+func (s *Server) handleHeatmap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	metricType := q.Get("type")
+	if metricType == "" {
+		writeError(w, "type parameter is required", http.StatusBadRequest)
+		return
+	}
+	kind, ok := lastseen.KindOf(metricType)
+	if !ok {
+		writeError(w, fmt.Sprintf("%s is not a per-pod or per-volume metric", metricType), http.StatusBadRequest)
+		return
+	}
+	resp := HeatmapResponse{Type: metricType, TZ: q.Get("tz")}
+	for _, scope := range heatmapScopes {
+		if id, ok := getQueryInt(r, scope); ok {
+			resp.Scope, resp.ScopeID = scope, id
+			break
+		}
+	}
+	if resp.Scope == "" {
+		writeError(w, "one of deployment, statefulset, daemonset, namespace or node is required", http.StatusBadRequest)
+		return
+	}
+	if kind == lastseen.PVC && resp.Scope != "namespace" {
+		writeError(w, "volume metrics are scoped by namespace", http.StatusBadRequest)
+		return
+	}
+
+	conv, err := units.NewConverter(metricType, q.Get("unit"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp.Unit = conv.Unit
+	resp.Agg = q.Get("agg")
+	if conv.From.Counter {
+		// Distributions of cumulative counters say nothing; use their rate
+		resp.Agg = "rate"
+		if !conv.Rate {
+			resp.Unit += "/s"
+		}
+	} else if resp.Agg == "" {
+		resp.Agg = "avg"
+	} else if !gaugeAggs[resp.Agg] {
+		writeError(w, fmt.Sprintf("unsupported aggregation %q", resp.Agg), http.StatusBadRequest)
+		return
+	}
+
+	n := defaultHeatmapBuckets
+	if v, ok := getQueryInt(r, "buckets"); ok {
+		if v < 1 || v > maxHeatmapBuckets {
+			writeError(w, fmt.Sprintf("buckets must be between 1 and %d", maxHeatmapBuckets), http.StatusBadRequest)
+			return
+		}
+		n = int(v)
+	}
+	// Bounds are given in the response unit; the store works in the
+	// reported one
+	factor := conv.Apply(1)
+	bound := func(param string) (*float64, error) {
+		raw := q.Get(param)
+		if raw == "" {
+			return nil, nil
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("invalid %s %q", param, raw)
+		}
+		v /= factor
+		return &v, nil
+	}
+	lo, err := bound("min")
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hi, err := bound("max")
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if lo != nil && hi != nil && *hi <= *lo {
+		writeError(w, "max must be greater than min", http.StatusBadRequest)
+		return
+	}
+
+	from, to := s.getTimeRange(r)
+	resp.From, resp.To = from.Unix(), to.Unix()
+	// Columns are capped like series points
+	span, limit := to.Sub(from), s.pointLimit(SeriesQuery{})
+	resp.Step, _ = getQueryInt(r, "step")
+	if resp.Step <= 0 {
+		resp.Step = widenStep(span, min(limit, defaultHeatmapColumns), resp.TZ != "")
+	} else if int64(span/time.Second)/resp.Step >= int64(limit) {
+		resp.Step = widenStep(span, max(limit-1, 1), resp.TZ != "")
+	}
+	buckets, err := bucketing(resp.Step, resp.TZ)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ids []int64
+	if kind == lastseen.PVC {
+		ids, err = s.sqlite.NamespacePVCIDs(resp.ScopeID)
+	} else {
+		ids, err = s.sqlite.ScopePodIDs(resp.Scope, resp.ScopeID)
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp.Resources = len(ids)
+
+	hm, err := s.duck.QueryHeatmap(r.Context(), metricType, ids, from, to, buckets, resp.Agg, lo, hi, n)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if lo != nil {
+		hm.Lo = *lo
+	}
+	if hi != nil {
+		hm.Hi = *hi
+	}
+
+	resp.Edges = make([]float64, n+1)
+	width := (hm.Hi - hm.Lo) / float64(n)
+	for i := range resp.Edges {
+		resp.Edges[i] = conv.Apply(hm.Lo + float64(i)*width)
+	}
+	resp.Times = []int64{}
+	resp.Counts = [][]int64{}
+	for _, c := range hm.Cells {
+		t := c.Time.Unix()
+		if len(resp.Times) == 0 || resp.Times[len(resp.Times)-1] != t {
+			resp.Times = append(resp.Times, t)
+			resp.Counts = append(resp.Counts, make([]int64, n))
+		}
+		row := resp.Counts[len(resp.Counts)-1]
+		row[c.Bucket] += c.Count
+		resp.Max = max(resp.Max, row[c.Bucket])
+	}
+	writeJSON(w, resp)
+}
@@ -0,0 +1,84 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// TestHeatmap counts a deployment's pods into CPU and memory value
+// buckets per minute
+func TestHeatmap(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		rs, depID, err := env.CreateDeployment(ctx, synctest.Deployment("shop", "web", 3))
+		if err != nil {
+			return err
+		}
+		var pods []*corev1.Pod
+		for i := 0; i < 3; i++ {
+			pods = append(pods, synctest.Pod("shop", fmt.Sprintf("web-%d", i), "node-a", rs))
+		}
+		podIDs, err := env.CreatePods(ctx, pods...)
+		if err != nil {
+			return err
+		}
+
+		// Five minutes of samples every 10s: web-0 at 100m and 100 MiB, web-1
+		// at 500m and 200 MiB, web-2 at 900m and 300 MiB
+		start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+		var points []store.MetricPoint
+		for i := 0; i < 3; i++ {
+			podID := podIDs[fmt.Sprintf("web-%d", i)]
+			for s := 0; s < 30; s++ {
+				at := start.Add(time.Duration(s) * 10 * time.Second)
+				points = append(points,
+					store.MetricPoint{Time: at, ResourceID: podID, MetricType: "cpu_ms", Value: float64((100 + 400*i) * s * 10)},
+					store.MetricPoint{Time: at, ResourceID: podID, MetricType: "mem_mb", Value: float64(100 * (i + 1))})
+			}
+		}
+		if err := env.Duck.BatchInsert(points); err != nil {
+			return err
+		}
+		base := fmt.Sprintf("/api/v1/metrics/heatmap?deployment=%d&from=%d&to=%d&step=60", depID, start.Unix(), start.Add(5*time.Minute).Unix())
+
+		var cpu api.HeatmapResponse
+		if err := env.GetJSON(base+"&type=cpu_ms&unit=cores&buckets=4&min=0&max=1", &cpu); err != nil {
+			return err
+		}
+		if cpu.Agg != "rate" || cpu.Unit != "cores" || cpu.Resources != 3 || len(cpu.Times) != 5 ||
+			!slices.Equal(cpu.Edges, []float64{0, 0.25, 0.5, 0.75, 1}) || cpu.Max != 1 {
+			return fmt.Errorf("cpu heatmap: %+v", cpu)
+		}
+		for i, row := range cpu.Counts {
+			if !slices.Equal(row, []int64{1, 0, 1, 1}) {
+				return fmt.Errorf("cpu heatmap row %d = %v, want [1 0 1 1]", i, row)
+			}
+		}
+
+		// Without bounds the range is the data's; the maximum lands in the top bucket
+		var mem api.HeatmapResponse
+		if err := env.GetJSON(base+"&type=mem_mb&buckets=2", &mem); err != nil {
+			return err
+		}
+		if mem.Agg != "avg" || !slices.Equal(mem.Edges, []float64{100, 200, 300}) || len(mem.Counts) != 5 || mem.Max != 2 {
+			return fmt.Errorf("memory heatmap: %+v", mem)
+		}
+		for i, row := range mem.Counts {
+			if !slices.Equal(row, []int64{1, 2}) {
+				return fmt.Errorf("memory heatmap row %d = %v, want [1 2]", i, row)
+			}
+		}
+
+		if err := env.GetJSON(base+"&type=used_mb", nil); err == nil {
+			return fmt.Errorf("volume metric accepted for a deployment")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,480 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/rollup"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/rules"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/units"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/workload"
+)
+
+// SeriesPoint is a single timestamped value
+type SeriesPoint struct {
+	T int64   `json:"t"` // unix seconds
+	V float64 `json:"v"`
+}
+
+// ReplicaHistoryResponse holds replica count series for one deployment
+type ReplicaHistoryResponse struct {
+	DeploymentID int64                    `json:"deployment_id"`
+	From         int64                    `json:"from"`
+	To           int64                    `json:"to"`
+	Series       map[string][]SeriesPoint `json:"series"`
+	Lineage      []int64                  `json:"lineage,omitempty"`
+}
+
+// handleDeploymentReplicas serves /api/v1/deployments/replicas?deployment=[&stitch=true].
+// stitch joins the history of deployments deleted and recreated under the
+// same name, see stitchedSeries.
+func (s *Server) handleDeploymentReplicas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	depID, ok := getQueryInt(r, "deployment")
+	if !ok {
+		writeError(w, "deployment parameter is required", http.StatusBadRequest)
+		return
+	}
+	from, to := s.getTimeRange(r)
+
+	ids := []int64{depID}
+	if r.URL.Query().Get("stitch") == "true" {
+		var err error
+		if ids, err = s.sqlite.Lineage("deployments", depID); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	points, err := s.stitchedSeries(ids, func(id int64) ([]store.MetricPoint, error) {
+		return s.duck.QuerySeries(r.Context(), "deployment", id, workload.StateMetrics, from, to)
+	})
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := ReplicaHistoryResponse{
+		DeploymentID: depID,
+		From:         from.Unix(),
+		To:           to.Unix(),
+		Series:       make(map[string][]SeriesPoint),
+	}
+	if len(ids) > 1 {
+		resp.Lineage = ids
+	}
+	for _, t := range workload.StateMetrics {
+		resp.Series[t] = []SeriesPoint{}
+	}
+	for _, p := range points {
+		resp.Series[p.MetricType] = append(resp.Series[p.MetricType], SeriesPoint{T: p.Time.Unix(), V: p.Value})
+	}
+
+	writeJSON(w, resp)
+}
+
+// SeriesResponse is one metric series for one resource
+type SeriesResponse struct {
+	ResourceID int64         `json:"resource_id"`
+	Type       string        `json:"type"`
+	Unit       string        `json:"unit"`
+	From       int64         `json:"from"`
+	To         int64         `json:"to"`
+	Step       int64         `json:"step,omitempty"`
+	TZ         string        `json:"tz,omitempty"`
+	Points     []SeriesPoint `json:"points"`
+	Lineage    []int64       `json:"lineage,omitempty"`
+	// Widened is set when the step was raised to stay within the point
+	// limit; Step is the one used
+	Widened bool `json:"widened,omitempty"`
+	// Next is the cursor of the following page of a full-resolution query
+	Next string `json:"next,omitempty"`
+	// Sources are where the series' points in the range came from
+	Sources []store.Source `json:"sources,omitempty"`
+	// SampleRate is set when ingest sampled the series over the range
+	// under load: at worst 1 in SampleRate points were stored
+	SampleRate int `json:"sample_rate,omitempty"`
+}
+
+// handleSeries serves /api/v1/metrics/series?resource=&type=[&kind=&from=&to=&step=&agg=&unit=&stitch=&tz=&max_points=&full=&cursor=].
+// kind (pod, pvc, deployment, ...) says what resource is, IDs being unique
+// only within a kind; without it series of every kind with that ID match.
+// With step (seconds) points are aggregated per bucket using agg (default
+// avg, or max for counters). tz (an IANA zone) starts day and week buckets
+// at local midnight instead of UTC. unit converts server-side, e.g. unit=GiB for
+// memory or unit=cores for cpu_ms (derived as a rate). stitch=true also
+// returns the points of predecessors and successors of a PVC or workload.
+// statefulset=&ordinal= replace resource to query a StatefulSet replica
+// across all pods that have filled it. Results over the point limit are
+// widened to a coarser step; full=true instead pages raw points, each page
+// naming the next in its next field, passed back as cursor.
+func (s *Server) handleSeries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	sq := SeriesQuery{
+		Kind:   q.Get("kind"),
+		Type:   q.Get("type"),
+		Agg:    q.Get("agg"),
+		Unit:   q.Get("unit"),
+		Stitch: q.Get("stitch") == "true",
+		TZ:     q.Get("tz"),
+		Full:   q.Get("full") == "true",
+		Cursor: q.Get("cursor"),
+	}
+	sq.Step, _ = getQueryInt(r, "step")
+	if n, ok := getQueryInt(r, "max_points"); ok {
+		sq.MaxPoints = int(n)
+	}
+	resourceID, ok := getQueryInt(r, "resource")
+	if ok {
+		sq.Resource = resourceID
+	} else if sq.StatefulSet, sq.Ordinal, ok = replicaIdentity(r); !ok {
+		writeError(w, "resource or statefulset and ordinal parameters are required", http.StatusBadRequest)
+		return
+	}
+	if sq.Type == "" {
+		writeError(w, "type parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	from, to := s.getTimeRange(r)
+	resp, err := s.querySeries(r.Context(), sq, from, to)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if _, ok := err.(badQueryError); ok {
+			code = http.StatusBadRequest
+		}
+		writeError(w, err.Error(), code)
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// SeriesQuery selects one series; see handleSeries for the parameters
+type SeriesQuery struct {
+	Resource int64  `json:"resource"`
+	Kind     string `json:"kind,omitempty"`
+	Type     string `json:"type"`
+	Step     int64  `json:"step,omitempty"`
+	Agg      string `json:"agg,omitempty"`
+	Unit     string `json:"unit,omitempty"`
+	Stitch   bool   `json:"stitch,omitempty"`
+	TZ       string `json:"tz,omitempty"`
+
+	// StatefulSet and Ordinal select a replica instead of Resource
+	StatefulSet int64 `json:"statefulset,omitempty"`
+	Ordinal     int   `json:"ordinal,omitempty"`
+
+	// MaxPoints lowers the server's point limit. Full pages raw points
+	// instead of widening the step; Cursor continues such a query.
+	MaxPoints int    `json:"max_points,omitempty"`
+	Full      bool   `json:"full,omitempty"`
+	Cursor    string `json:"cursor,omitempty"`
+}
+
+// kind is the kind of the queried resources: StatefulSet replicas are pods
+func (sq SeriesQuery) kind() string {
+	if sq.StatefulSet > 0 {
+		return "pod"
+	}
+	return sq.Kind
+}
+
+// badQueryError marks errors caused by the request rather than the store
+type badQueryError struct{ error }
+
+func (s *Server) querySeries(ctx context.Context, sq SeriesQuery, from, to time.Time) (SeriesResponse, error) {
+	conv, err := units.NewConverter(sq.Type, sq.Unit)
+	if err != nil {
+		return SeriesResponse{}, badQueryError{err}
+	}
+	buckets, err := bucketing(sq.Step, sq.TZ)
+	if err != nil {
+		return SeriesResponse{}, badQueryError{err}
+	}
+
+	ids := []int64{sq.Resource}
+	switch {
+	case sq.StatefulSet > 0:
+		if ids, err = s.sqlite.ReplicaPodIDs(sq.StatefulSet, sq.Ordinal); err != nil {
+			return SeriesResponse{}, err
+		}
+		if len(ids) == 0 {
+			return SeriesResponse{}, badQueryError{fmt.Errorf("statefulset %d has no replica %d", sq.StatefulSet, sq.Ordinal)}
+		}
+	case sq.Stitch:
+		if ids, err = s.lineage(sq.Type, sq.Resource); err != nil {
+			return SeriesResponse{}, err
+		}
+	}
+
+	resp := SeriesResponse{
+		ResourceID: sq.Resource,
+		Type:       sq.Type,
+		Unit:       conv.Unit,
+		From:       from.Unix(),
+		To:         to.Unix(),
+		TZ:         sq.TZ,
+	}
+	limit := s.pointLimit(sq)
+	if sq.Full || sq.Cursor != "" {
+		if sq.Step > 0 {
+			return SeriesResponse{}, badQueryError{fmt.Errorf("full resolution cannot be combined with step")}
+		}
+		var cursor *seriesCursor
+		if sq.Cursor != "" {
+			c, err := decodeCursor(sq.Cursor)
+			if err != nil {
+				return SeriesResponse{}, err
+			}
+			cursor = &c
+			resp.To = c.To.Unix() // pages share the first one's range
+		}
+		if resp.Points, resp.Next, err = s.seriesPage(ctx, sq, ids, from, to, cursor, conv, limit); err != nil {
+			return SeriesResponse{}, err
+		}
+		return s.finishSeries(ctx, resp, sq, ids, from, to)
+	}
+
+	step, widened, err := s.fitStep(ctx, sq, ids, from, to, limit)
+	if err != nil {
+		return SeriesResponse{}, err
+	}
+	if widened {
+		buckets.Step = time.Duration(step) * time.Second
+	}
+	agg := sq.Agg
+	if agg == "" {
+		agg = "avg"
+		if conv.From.Counter {
+			agg = "max"
+		}
+	}
+	points, err := s.stitchedSeries(ids, func(id int64) ([]store.MetricPoint, error) {
+		if step > 0 {
+			return s.duck.QueryBucketed(ctx, sq.kind(), id, sq.Type, from, to, buckets, agg)
+		}
+		return s.duck.QuerySeries(ctx, sq.kind(), id, []string{sq.Type}, from, to)
+	})
+	if err != nil {
+		return SeriesResponse{}, err
+	}
+	resp.Step = step
+	resp.Widened = widened
+	resp.Points = convertPoints(points, conv)
+	return s.finishSeries(ctx, resp, sq, ids, from, to)
+}
+
+// finishSeries fills in the resources and sources a series was read from,
+// and whether it was sampled
+func (s *Server) finishSeries(ctx context.Context, resp SeriesResponse, sq SeriesQuery, ids []int64, from, to time.Time) (SeriesResponse, error) {
+	if sq.StatefulSet > 0 {
+		resp.ResourceID = ids[len(ids)-1] // the replica's current pod
+	}
+	if len(ids) > 1 {
+		resp.Lineage = ids
+	}
+	sources, err := s.duck.SeriesSources(ctx, ids, []string{sq.Type}, from, to)
+	if err != nil {
+		return SeriesResponse{}, err
+	}
+	if resp.Sources, err = s.describeSources(sources); err != nil {
+		return SeriesResponse{}, err
+	}
+	if resp.SampleRate, err = s.duck.SeriesSampleRate(ctx, ids, []string{sq.Type}, from, to); err != nil {
+		return SeriesResponse{}, err
+	}
+	return resp, nil
+}
+
+// lineage returns the ids whose series of metricType are stitched together
+// for id. Series keyed by pods and nodes are not stitched: pods are replaced
+// under new names, nodes keep their catalog row.
+func (s *Server) lineage(metricType string, id int64) ([]int64, error) {
+	table := ""
+	switch {
+	case metricType == "total_mb" || metricType == "used_mb" || metricType == "free_mb":
+		table = "pvcs"
+	case isStateMetric(metricType):
+		table = "deployments"
+	default:
+		recording, err := s.sqlite.ListRecordingRules()
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range recording {
+			if rule.Name != metricType {
+				continue
+			}
+			if expr, err := rules.ParseExpr(rule.Expr); err == nil {
+				switch expr.By {
+				case "deployment", "statefulset", "daemonset":
+					table = expr.By + "s"
+				}
+			}
+		}
+	}
+	if table == "" {
+		return []int64{id}, nil
+	}
+	return s.sqlite.Lineage(table, id)
+}
+
+func isStateMetric(metricType string) bool {
+	for _, t := range workload.StateMetrics {
+		if t == metricType {
+			return true
+		}
+	}
+	return false
+}
+
+// stitchedSeries queries each id of a lineage and merges the points by
+// time. The histories of successive resources rarely overlap; where they
+// do, the newer resource's point wins.
+func (s *Server) stitchedSeries(ids []int64, query func(id int64) ([]store.MetricPoint, error)) ([]store.MetricPoint, error) {
+	if len(ids) == 1 {
+		return query(ids[0])
+	}
+	var points []store.MetricPoint
+	for _, id := range ids {
+		p, err := query(id)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p...)
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+
+	type key struct {
+		t      int64
+		metric string
+	}
+	seen := make(map[key]int, len(points))
+	out := points[:0]
+	for _, p := range points {
+		k := key{p.Time.UnixMicro(), p.MetricType}
+		if i, ok := seen[k]; ok {
+			out[i] = p
+			continue
+		}
+		seen[k] = len(out)
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// convertPoints applies a unit conversion; rate conversions differentiate
+// consecutive counter samples and skip counter resets.
+func convertPoints(points []store.MetricPoint, conv *units.Converter) []SeriesPoint {
+	out := make([]SeriesPoint, 0, len(points))
+	for i, p := range points {
+		if !conv.Rate {
+			out = append(out, SeriesPoint{T: p.Time.Unix(), V: conv.Apply(p.Value)})
+			continue
+		}
+		if i == 0 {
+			continue
+		}
+		prev := points[i-1]
+		dt := p.Time.Sub(prev.Time).Seconds()
+		dv := p.Value - prev.Value
+		if dt <= 0 || dv < 0 {
+			continue
+		}
+		out = append(out, SeriesPoint{T: p.Time.Unix(), V: conv.Apply(dv / dt)})
+	}
+	return out
+}
+
+// handleUnits lists metric types with their native units
+func (s *Server) handleUnits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, units.All())
+}
+
+// NodeTotalsResponse holds the precomputed usage series of one node, or
+// of the whole cluster when node is 0
+type NodeTotalsResponse struct {
+	NodeID int64                    `json:"node_id"`
+	From   int64                    `json:"from"`
+	To     int64                    `json:"to"`
+	Step   int64                    `json:"step,omitempty"`
+	TZ     string                   `json:"tz,omitempty"`
+	Series map[string][]SeriesPoint `json:"series"`
+}
+
+// handleNodeTotals serves /api/v1/metrics/totals[?node=&from=&to=&step=&tz=]
+// from the per-minute rollup written during flush: cpu_millicores, mem_mb
+// and pods. Omitting node returns cluster-wide totals. tz aligns day and
+// week steps as for series.
+func (s *Server) handleNodeTotals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	nodeID, _ := getQueryInt(r, "node")
+	from, to := s.getTimeRange(r)
+	step, _ := getQueryInt(r, "step")
+	tz := r.URL.Query().Get("tz")
+	buckets, err := bucketing(step, tz)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := NodeTotalsResponse{
+		NodeID: nodeID,
+		From:   from.Unix(),
+		To:     to.Unix(),
+		Step:   step,
+		TZ:     tz,
+		Series: make(map[string][]SeriesPoint),
+	}
+	for _, t := range []string{rollup.CPUMillicores, rollup.MemMB, rollup.Pods} {
+		points, err := s.duck.QueryNodeTotals(r.Context(), nodeID, t, from, to, buckets)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		series := make([]SeriesPoint, len(points))
+		for i, p := range points {
+			series[i] = SeriesPoint{T: p.Time.Unix(), V: p.Value}
+		}
+		resp.Series[t] = series
+	}
+
+	writeJSON(w, resp)
+}
+
+// bucketing turns step (seconds) and tz (an IANA zone, optional) into
+// store bucketing; a zone needs a step of whole days
+func bucketing(step int64, tz string) (store.Bucketing, error) {
+	b := store.Bucketing{Step: time.Duration(step) * time.Second}
+	if tz == "" {
+		return b, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return b, fmt.Errorf("unknown time zone %q", tz)
+	}
+	b.Loc = loc
+	return b, b.Validate()
+}
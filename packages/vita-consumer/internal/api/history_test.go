@@ -0,0 +1,148 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestRecreatedLineage deletes a deployment and recreates it under the
+// same name: stitched history must cover both incarnations
+func TestRecreatedLineage(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		first := synctest.Deployment("default", "api", 1)
+		second := synctest.Deployment("default", "api", 2)
+		var ids [2]int64
+		for i, dep := range []*appsv1.Deployment{first, second} {
+			if i > 0 {
+				if err := env.Client.AppsV1().Deployments("default").Delete(ctx, first.Name, metav1.DeleteOptions{}); err != nil {
+					return err
+				}
+			}
+			if _, err := env.Client.AppsV1().Deployments("default").Create(ctx, dep, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+			if err := env.Eventually(synctest.Timeout, "deployment synced", func() (bool, error) {
+				n, err := env.QueryInt("SELECT COUNT(*) FROM deployments WHERE uid = ?", string(dep.UID))
+				return n == 1, err
+			}); err != nil {
+				return err
+			}
+			id, err := env.QueryInt("SELECT id FROM deployments WHERE uid = ?", string(dep.UID))
+			if err != nil {
+				return err
+			}
+			ids[i] = id
+		}
+
+		now := env.Clock.Now().Truncate(time.Second)
+		if err := env.Duck.BatchInsert([]store.MetricPoint{
+			{Time: now.Add(-20 * time.Minute), ResourceID: ids[0], MetricType: "replicas_desired", Value: 1},
+			{Time: now.Add(-10 * time.Minute), ResourceID: ids[1], MetricType: "replicas_desired", Value: 2},
+		}); err != nil {
+			return err
+		}
+
+		path := fmt.Sprintf("/api/v1/deployments/replicas?deployment=%d&from=%d&to=%d", ids[1], now.Add(-time.Hour).Unix(), now.Unix())
+		var plain, stitched api.ReplicaHistoryResponse
+		if err := env.GetJSON(path, &plain); err != nil {
+			return err
+		}
+		if err := env.GetJSON(path+"&stitch=true", &stitched); err != nil {
+			return err
+		}
+		if n := len(plain.Series["replicas_desired"]); n != 1 {
+			return fmt.Errorf("unstitched history has %d points, want 1", n)
+		}
+		got := stitched.Series["replicas_desired"]
+		if len(got) != 2 || got[0].V != 1 || got[1].V != 2 {
+			return fmt.Errorf("stitched history = %+v, want values 1 then 2", got)
+		}
+		if len(stitched.Lineage) != 2 || stitched.Lineage[0] != ids[0] || stitched.Lineage[1] != ids[1] {
+			return fmt.Errorf("lineage = %v, want %v", stitched.Lineage, ids)
+		}
+
+		var series api.SeriesResponse
+		if err := env.GetJSON(fmt.Sprintf("/api/v1/metrics/series?resource=%d&type=replicas_desired&from=%d&to=%d&stitch=true",
+			ids[0], now.Add(-time.Hour).Unix(), now.Unix()), &series); err != nil {
+			return err
+		}
+		if len(series.Points) != 2 {
+			return fmt.Errorf("stitched series has %d points, want 2", len(series.Points))
+		}
+		return nil
+	})
+}
+
+// TestTZBuckets buckets by New York business days across the March 2024
+// DST change: 23:30 local on the 9th stays on the 9th, and the 23-hour
+// 10th is one bucket, where UTC days would split both differently
+func TestTZBuckets(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		const pod = 9001
+		at := func(s string) time.Time {
+			t, _ := time.Parse(time.RFC3339, s)
+			return t
+		}
+		if err := env.Duck.BatchInsert([]store.MetricPoint{
+			{Time: at("2024-03-10T04:30:00Z"), ResourceID: pod, MetricType: "mem_mb", Value: 10}, // 9th, 23:30 EST
+			{Time: at("2024-03-10T06:00:00Z"), ResourceID: pod, MetricType: "mem_mb", Value: 2},  // 10th, 01:00 EST
+			{Time: at("2024-03-10T20:00:00Z"), ResourceID: pod, MetricType: "mem_mb", Value: 4},  // 10th, 16:00 EDT
+			{Time: at("2024-03-11T03:30:00Z"), ResourceID: pod, MetricType: "mem_mb", Value: 6},  // 10th, 23:30 EDT
+		}); err != nil {
+			return err
+		}
+		query := func(step int, tz string) ([]api.SeriesPoint, error) {
+			var resp api.SeriesResponse
+			err := env.GetJSON(fmt.Sprintf("/api/v1/metrics/series?resource=%d&type=mem_mb&from=%d&to=%d&step=%d&tz=%s",
+				pod, at("2024-03-01T00:00:00Z").Unix(), at("2024-03-15T00:00:00Z").Unix(), step, tz), &resp)
+			return resp.Points, err
+		}
+
+		days, err := query(86400, "America/New_York")
+		if err != nil {
+			return err
+		}
+		want := []api.SeriesPoint{
+			{T: at("2024-03-09T05:00:00Z").Unix(), V: 10},
+			{T: at("2024-03-10T05:00:00Z").Unix(), V: 4},
+		}
+		if fmt.Sprint(days) != fmt.Sprint(want) {
+			return fmt.Errorf("local days = %v, want %v", days, want)
+		}
+		utc, err := query(86400, "")
+		if err != nil {
+			return err
+		}
+		if len(utc) != 2 || utc[0].V != 16.0/3 {
+			return fmt.Errorf("UTC days = %v, want the first three points together", utc)
+		}
+		weeks, err := query(7*86400, "America/New_York")
+		if err != nil {
+			return err
+		}
+		if len(weeks) != 1 || weeks[0].T != at("2024-03-04T05:00:00Z").Unix() || weeks[0].V != 5.5 {
+			return fmt.Errorf("local weeks = %v, want one from Monday the 4th", weeks)
+		}
+
+		for _, bad := range []string{"step=3600&tz=America/New_York", "step=86400&tz=Mars/Olympus"} {
+			resp, err := http.Get(fmt.Sprintf("%s/api/v1/metrics/series?resource=%d&type=mem_mb&%s", env.API.URL, pod, bad))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				return fmt.Errorf("%s: %s, want 400", bad, resp.Status)
+			}
+		}
+		return nil
+	})
+}
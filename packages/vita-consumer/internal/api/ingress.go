@@ -0,0 +1,163 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+)
+
+// Ingress is an ingress with its routing rules
+type Ingress struct {
+	ID          int64         `json:"id"`
+	Name        string        `json:"name"`
+	UID         string        `json:"uid"`
+	NamespaceID int64         `json:"namespace_id"`
+	Namespace   string        `json:"namespace"`
+	Class       *string       `json:"class,omitempty"`
+	Rules       []IngressRule `json:"rules"`
+}
+
+// IngressRule routes a host/path to a service and the workload behind it
+type IngressRule struct {
+	Host         string  `json:"host"`
+	Path         string  `json:"path"`
+	Service      string  `json:"service"`
+	ServicePort  string  `json:"service_port,omitempty"`
+	WorkloadKind *string `json:"workload_kind,omitempty"`
+	WorkloadID   *int64  `json:"workload_id,omitempty"`
+	Workload     *string `json:"workload,omitempty"`
+}
+
+func (s *Server) handleListIngresses(w http.ResponseWriter, r *http.Request) {
+	serveList(s, w, r, "ingress", func(id int64) ([]Ingress, error) { return s.queryIngresses(r, id) })
+}
+
+// queryIngresses lists ingresses, optionally only those with a rule for ?host=
+func (s *Server) queryIngresses(r *http.Request, id int64) ([]Ingress, error) {
+	query := `
+		SELECT i.id, i.name, i.uid, i.namespace_id, ns.name, i.class,
+			ir.host, ir.path, ir.service_name, ir.service_port,
+			CASE WHEN svc.deployment_id IS NOT NULL THEN 'deployment'
+			     WHEN svc.statefulset_id IS NOT NULL THEN 'statefulset'
+			     WHEN svc.daemonset_id IS NOT NULL THEN 'daemonset' END,
+			COALESCE(svc.deployment_id, svc.statefulset_id, svc.daemonset_id),
+			COALESCE(d.name, sts.name, ds.name)
+		FROM ingresses i
+		JOIN namespaces ns ON i.namespace_id = ns.id
+		LEFT JOIN ingress_rules ir ON ir.ingress_id = i.id
+		LEFT JOIN services svc ON svc.namespace_id = i.namespace_id AND svc.name = ir.service_name
+		LEFT JOIN deployments d ON svc.deployment_id = d.id
+		LEFT JOIN statefulsets sts ON svc.statefulset_id = sts.id
+		LEFT JOIN daemonsets ds ON svc.daemonset_id = ds.id
+		WHERE 1=1
+	`
+	args := []interface{}{}
+
+	if nsID, ok := getQueryInt(r, "namespace"); ok {
+		query += " AND i.namespace_id = ?"
+		args = append(args, nsID)
+	}
+	if host := r.URL.Query().Get("host"); host != "" {
+		query += " AND i.id IN (SELECT ingress_id FROM ingress_rules WHERE host = ?)"
+		args = append(args, host)
+	}
+	if id > 0 {
+		query += " AND i.id = ?"
+		args = append(args, id)
+	}
+
+	query += " ORDER BY ns.name, i.name, ir.host, ir.path"
+
+	rows, err := s.sqlite.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ingresses := []Ingress{}
+	index := make(map[int64]int)
+	for rows.Next() {
+		var ing Ingress
+		var host, path, service, port, kind, workload sql.NullString
+		var workloadID sql.NullInt64
+		if err := rows.Scan(&ing.ID, &ing.Name, &ing.UID, &ing.NamespaceID, &ing.Namespace, &ing.Class,
+			&host, &path, &service, &port, &kind, &workloadID, &workload); err != nil {
+			continue
+		}
+
+		pos, ok := index[ing.ID]
+		if !ok {
+			ing.Rules = []IngressRule{}
+			ingresses = append(ingresses, ing)
+			pos = len(ingresses) - 1
+			index[ing.ID] = pos
+		}
+		if !host.Valid {
+			continue
+		}
+
+		rule := IngressRule{Host: host.String, Path: path.String, Service: service.String, ServicePort: port.String}
+		if kind.Valid {
+			rule.WorkloadKind = &kind.String
+		}
+		if workloadID.Valid {
+			rule.WorkloadID = &workloadID.Int64
+		}
+		if workload.Valid {
+			rule.Workload = &workload.String
+		}
+		ingresses[pos].Rules = append(ingresses[pos].Rules, rule)
+	}
+	return ingresses, nil
+}
+
+// handleIngressPods answers "which pods serve ?host=" by following
+// ingress rule -> service -> workload -> pods
+func (s *Server) handleIngressPods(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	host := r.URL.Query().Get("host")
+	if host == "" {
+		writeError(w, "host parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		SELECT DISTINCT p.id, p.name, p.uid, p.namespace_id, ns.name, p.node_id, n.name, p.deployment_id, d.name
+		FROM ingress_rules ir
+		JOIN ingresses i ON ir.ingress_id = i.id
+		JOIN services svc ON svc.namespace_id = i.namespace_id AND svc.name = ir.service_name
+		JOIN pods p ON p.deployment_id = svc.deployment_id
+			OR p.statefulset_id = svc.statefulset_id
+			OR p.daemonset_id = svc.daemonset_id
+		JOIN namespaces ns ON p.namespace_id = ns.id
+		JOIN nodes n ON p.node_id = n.id
+		LEFT JOIN deployments d ON p.deployment_id = d.id
+		WHERE ir.host = ?
+		ORDER BY p.name
+	`
+
+	rows, err := s.sqlite.Query(query, host)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	pods := []Pod{}
+	for rows.Next() {
+		var p Pod
+		var depName sql.NullString
+		if err := rows.Scan(&p.ID, &p.Name, &p.UID, &p.NamespaceID, &p.Namespace, &p.NodeID, &p.NodeName, &p.DeploymentID, &depName); err != nil {
+			continue
+		}
+		if depName.Valid {
+			p.Deployment = &depName.String
+		}
+		pods = append(pods, p)
+	}
+
+	writeFields(w, r, pods)
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultLatestMaxAge is how old a latest value may be unless max_age says
+const defaultLatestMaxAge = time.Hour
+
+// LatestValue is the newest point of one series
+type LatestValue struct {
+	Kind       string  `json:"kind,omitempty"` // empty for series from before series recorded their kind
+	ResourceID int64   `json:"resource_id"`
+	Type       string  `json:"type"`
+	T          int64   `json:"t"` // unix seconds
+	V          float64 `json:"v"`
+}
+
+// handleLatest serves /api/v1/metrics/latest?type=[&kind=&ids=&max_age=]:
+// the newest value of each series of the comma-separated types, of the
+// resources of kind (any if not given) in ids if given, reported within
+// max_age seconds (default an hour). Values are as of the last flush and read from a table kept for
+// the purpose, so lists and overviews never scan history.
+func (s *Server) handleLatest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	var types []string
+	for _, t := range strings.Split(q.Get("type"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	if len(types) == 0 {
+		writeError(w, "type parameter is required", http.StatusBadRequest)
+		return
+	}
+	var ids []int64
+	if raw := q.Get("ids"); raw != "" {
+		for _, part := range strings.Split(raw, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+			if err != nil || id <= 0 {
+				writeError(w, "invalid id "+strconv.Quote(part), http.StatusBadRequest)
+				return
+			}
+			ids = append(ids, id)
+		}
+	}
+	maxAge := defaultLatestMaxAge
+	if n, ok := getQueryInt(r, "max_age"); ok && n > 0 {
+		maxAge = time.Duration(n) * time.Second
+	}
+
+	points, err := s.sqlite.LatestPoints(s.now(r).Add(-maxAge), q.Get("kind"), types, ids)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := make([]LatestValue, len(points))
+	for i, p := range points {
+		out[i] = LatestValue{Kind: p.ResourceKind, ResourceID: p.ResourceID, Type: p.MetricType, T: p.Time.Unix(), V: p.Value}
+	}
+	writeJSON(w, out)
+}
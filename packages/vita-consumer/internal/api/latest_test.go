@@ -0,0 +1,125 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/persist"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+)
+
+// The latest sink keeps each series' newest point by kind, ignores late
+// ones, seeds an empty table from DuckDB, and serves /api/v1/metrics/latest
+func TestLatestValues(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		now := env.Clock.Now()
+		const pod, other = 978001, 978002
+		sink := persist.NewLatestSink(env.SQLite, env.Duck, env.Clock)
+		if err := sink.Write(ctx, []buffer.Metric{
+			{Time: now.Add(-2 * time.Minute), ResourceID: pod, Kind: "pod", Type: "mem_mb", Value: 10},
+			{Time: now.Add(-time.Minute), ResourceID: pod, Kind: "pod", Type: "mem_mb", Value: 20},
+			{Time: now.Add(-time.Minute), ResourceID: pod, Kind: "pod", Type: "cpu_ms", Value: 5},
+			{Time: now.Add(-2 * time.Hour), ResourceID: other, Kind: "pod", Type: "mem_mb", Value: 99},
+			// A claim sharing the pod's ID is a series of its own
+			{Time: now.Add(-30 * time.Second), ResourceID: pod, Kind: "pvc", Type: "mem_mb", Value: 7},
+		}); err != nil {
+			return err
+		}
+		// A late point must not replace the newer one
+		if err := sink.Write(ctx, []buffer.Metric{{Time: now.Add(-3 * time.Minute), ResourceID: pod, Kind: "pod", Type: "mem_mb", Value: 1}}); err != nil {
+			return err
+		}
+		values, err := env.SQLite.LatestValues(now.Add(-time.Hour), "pod", "mem_mb")
+		if err != nil {
+			return err
+		}
+		if values[pod] != 20 {
+			return fmt.Errorf("latest mem_mb of pod is %v, want 20", values[pod])
+		}
+		if _, ok := values[other]; ok {
+			return fmt.Errorf("series silent for two hours reported within the hour")
+		}
+
+		resp, err := http.Get(fmt.Sprintf("%s/api/v1/metrics/latest?type=mem_mb,cpu_ms&kind=pod&ids=%d,%d", env.API.URL, pod, other))
+		if err != nil {
+			return err
+		}
+		var got []api.LatestValue
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if len(got) != 2 || got[0].Type != "cpu_ms" || got[0].V != 5 || got[1].Type != "mem_mb" || got[1].V != 20 || got[1].Kind != "pod" {
+			return fmt.Errorf("latest endpoint returned %+v", got)
+		}
+		values, err = env.SQLite.LatestValues(now.Add(-time.Hour), "pvc", "mem_mb")
+		if err != nil {
+			return err
+		}
+		if len(values) != 1 || values[pod] != 7 {
+			return fmt.Errorf("latest mem_mb of pvcs %v, want the claim at 7", values)
+		}
+		resp, err = http.Get(fmt.Sprintf("%s/api/v1/metrics/latest?type=mem_mb&ids=%d&max_age=10800", env.API.URL, other))
+		if err != nil {
+			return err
+		}
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if len(got) != 1 || got[0].V != 99 {
+			return fmt.Errorf("latest endpoint with max_age returned %+v", got)
+		}
+		if resp, err = http.Get(env.API.URL + "/api/v1/metrics/latest"); err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			return fmt.Errorf("latest endpoint without type: HTTP %d", resp.StatusCode)
+		}
+
+		// A fresh catalog is seeded from the points DuckDB stored
+		dir, err := os.MkdirTemp("", "synccheck-latest")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		sqlite, err := store.NewSQLiteStore(filepath.Join(dir, "catalog.db"))
+		if err != nil {
+			return err
+		}
+		defer sqlite.Close()
+		duck, err := store.NewDuckDBStore(filepath.Join(dir, "metrics.duckdb"))
+		if err != nil {
+			return err
+		}
+		defer duck.Close()
+		if err := duck.BatchInsert([]store.MetricPoint{
+			{Time: now.Add(-2 * time.Minute), ResourceID: pod, ResourceKind: "pod", MetricType: "mem_mb", Value: 30},
+			{Time: now.Add(-time.Minute), ResourceID: pod, ResourceKind: "pod", MetricType: "mem_mb", Value: 40},
+		}); err != nil {
+			return err
+		}
+		if err := persist.NewLatestSink(sqlite, duck, env.Clock).Seed(ctx); err != nil {
+			return err
+		}
+		if values, err = sqlite.LatestValues(now.Add(-time.Hour), "pod", "mem_mb"); err != nil {
+			return err
+		}
+		if len(values) != 1 || values[pod] != 40 {
+			return fmt.Errorf("seeded latest values %v, want pod at 40", values)
+		}
+		return nil
+	})
+}
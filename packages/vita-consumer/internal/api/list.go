@@ -3,6 +3,10 @@ package api
 import (
 	"database/sql"
 	"net/http"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/lastseen"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
 )
 
 // Node represents a cluster node
@@ -10,6 +14,11 @@ type Node struct {
 	ID   int64  `json:"id"`
 	Name string `json:"name"`
 	UID  string `json:"uid"`
+	// DecommissionedAt is set on nodes retired by the reaper, listed only
+	// with decommissioned=true
+	DecommissionedAt *time.Time `json:"decommissioned_at,omitempty"`
+	store.NodeGroup
+	Freshness
 }
 
 // Namespace represents a K8s namespace
@@ -25,6 +34,8 @@ type Deployment struct {
 	UID         string `json:"uid"`
 	NamespaceID int64  `json:"namespace_id"`
 	Namespace   string `json:"namespace"`
+
+	Health *HealthScore `json:"health,omitempty"` // when health scoring is on
 }
 
 // Pod represents a K8s pod
@@ -38,6 +49,7 @@ type Pod struct {
 	NodeName     string  `json:"node"`
 	DeploymentID *int64  `json:"deployment_id,omitempty"`
 	Deployment   *string `json:"deployment,omitempty"`
+	Freshness
 }
 
 // PVC represents a PersistentVolumeClaim
@@ -47,43 +59,95 @@ type PVC struct {
 	UID         string `json:"uid"`
 	NamespaceID int64  `json:"namespace_id"`
 	Namespace   string `json:"namespace"`
+	// Storage, once the claim is synced with its spec. CapacityMB is what
+	// the bound volume provides.
+	StorageClass *string  `json:"storage_class,omitempty"`
+	VolumeName   *string  `json:"volume_name,omitempty"`
+	Phase        *string  `json:"phase,omitempty"`
+	RequestedMB  *float64 `json:"requested_mb,omitempty"`
+	CapacityMB   *float64 `json:"capacity_mb,omitempty"`
+	// Health is healthy, pending, lost, failing (a mount of it is failing,
+	// see MountError) or unknown
+	Health     string  `json:"health"`
+	MountError *string `json:"mount_error,omitempty"`
 }
 
 func (s *Server) handleListNodes(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	serveList(s, w, r, "node", func(id int64) ([]Node, error) { return s.queryNodes(r, id) })
+}
+
+func (s *Server) handleListNamespaces(w http.ResponseWriter, r *http.Request) {
+	serveList(s, w, r, "namespace", func(id int64) ([]Namespace, error) { return s.queryNamespaces(r, id) })
+}
+
+func (s *Server) handleListDeployments(w http.ResponseWriter, r *http.Request) {
+	serveList(s, w, r, "deployment", func(id int64) ([]Deployment, error) { return s.queryDeployments(r, id) })
+}
+
+func (s *Server) handleListPods(w http.ResponseWriter, r *http.Request) {
+	serveList(s, w, r, "pod", func(id int64) ([]Pod, error) { return s.queryPods(r, id) })
+}
+
+func (s *Server) handleListPVCs(w http.ResponseWriter, r *http.Request) {
+	serveList(s, w, r, "pvc", func(id int64) ([]PVC, error) { return s.queryPVCs(r, id) })
+}
+
+// Each query function applies the request's filters. A non-zero id
+// restricts the result to that single row (used by watch).
+
+func (s *Server) queryNodes(r *http.Request, id int64) ([]Node, error) {
+	query := `SELECT id, name, uid, decommissioned_at, COALESCE(zone, ''), COALESCE(region, ''), COALESCE(nodepool, ''),
+		COALESCE(capacity_type, '') FROM nodes WHERE 1=1`
+	args := []interface{}{}
+
+	if id > 0 {
+		query += " AND id = ?"
+		args = append(args, id)
+	}
+	if r.URL.Query().Get("decommissioned") != "true" {
+		query += " AND decommissioned_at IS NULL"
 	}
 
-	rows, err := s.sqlite.Query("SELECT id, name, uid FROM nodes ORDER BY name")
+	query += " ORDER BY name"
+
+	rows, err := s.sqlite.Query(query, args...)
 	if err != nil {
-		writeError(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
 	nodes := []Node{}
 	for rows.Next() {
 		var n Node
-		if err := rows.Scan(&n.ID, &n.Name, &n.UID); err != nil {
+		var decommissioned sql.NullTime
+		if err := rows.Scan(&n.ID, &n.Name, &n.UID, &decommissioned, &n.Zone, &n.Region, &n.Nodepool, &n.CapacityType); err != nil {
 			continue
 		}
+		if decommissioned.Valid {
+			n.DecommissionedAt = &decommissioned.Time
+		}
 		nodes = append(nodes, n)
 	}
-
-	writeJSON(w, nodes)
+	if err := rows.Err(); err != nil || s.seen == nil {
+		return nodes, err
+	}
+	return s.nodeFreshness(r, nodes)
 }
 
-func (s *Server) handleListNamespaces(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+func (s *Server) queryNamespaces(r *http.Request, id int64) ([]Namespace, error) {
+	query := "SELECT id, name FROM namespaces WHERE 1=1"
+	args := []interface{}{}
+
+	if id > 0 {
+		query += " AND id = ?"
+		args = append(args, id)
 	}
 
-	rows, err := s.sqlite.Query("SELECT id, name FROM namespaces ORDER BY name")
+	query += " ORDER BY name"
+
+	rows, err := s.sqlite.Query(query, args...)
 	if err != nil {
-		writeError(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -95,34 +159,32 @@ func (s *Server) handleListNamespaces(w http.ResponseWriter, r *http.Request) {
 		}
 		namespaces = append(namespaces, ns)
 	}
-
-	writeJSON(w, namespaces)
+	return namespaces, nil
 }
 
-func (s *Server) handleListDeployments(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
+func (s *Server) queryDeployments(r *http.Request, id int64) ([]Deployment, error) {
 	query := `
 		SELECT d.id, d.name, d.uid, d.namespace_id, n.name
 		FROM deployments d
 		JOIN namespaces n ON d.namespace_id = n.id
+		WHERE 1=1
 	`
 	args := []interface{}{}
 
 	if nsID, ok := getQueryInt(r, "namespace"); ok {
-		query += " WHERE d.namespace_id = ?"
+		query += " AND d.namespace_id = ?"
 		args = append(args, nsID)
 	}
+	if id > 0 {
+		query += " AND d.id = ?"
+		args = append(args, id)
+	}
 
 	query += " ORDER BY d.name"
 
 	rows, err := s.sqlite.Query(query, args...)
 	if err != nil {
-		writeError(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -134,16 +196,22 @@ func (s *Server) handleListDeployments(w http.ResponseWriter, r *http.Request) {
 		}
 		deployments = append(deployments, d)
 	}
+	if !s.health {
+		return deployments, nil
+	}
 
-	writeJSON(w, deployments)
-}
-
-func (s *Server) handleListPods(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	health, err := s.latestHealth("deployment")
+	if err != nil {
+		return nil, err
 	}
+	for i := range deployments {
+		deployments[i].Health = health[deployments[i].ID]
+	}
+	sortByHealth(r, deployments, func(d Deployment) *HealthScore { return d.Health })
+	return deployments, nil
+}
 
+func (s *Server) queryPods(r *http.Request, id int64) ([]Pod, error) {
 	query := `
 		SELECT p.id, p.name, p.uid, p.namespace_id, ns.name, p.node_id, n.name, p.deployment_id, d.name
 		FROM pods p
@@ -166,13 +234,16 @@ func (s *Server) handleListPods(w http.ResponseWriter, r *http.Request) {
 		query += " AND p.node_id = ?"
 		args = append(args, nodeID)
 	}
+	if id > 0 {
+		query += " AND p.id = ?"
+		args = append(args, id)
+	}
 
 	query += " ORDER BY p.name"
 
 	rows, err := s.sqlite.Query(query, args...)
 	if err != nil {
-		writeError(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -186,47 +257,59 @@ func (s *Server) handleListPods(w http.ResponseWriter, r *http.Request) {
 		if depName.Valid {
 			p.Deployment = &depName.String
 		}
+		if s.seen != nil {
+			p.Freshness = s.freshness(lastseen.Pod, p.ID)
+			if !keepStale(r, p.Stale) {
+				continue
+			}
+		}
 		pods = append(pods, p)
 	}
-
-	writeJSON(w, pods)
+	return pods, nil
 }
 
-func (s *Server) handleListPVCs(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
+func (s *Server) queryPVCs(r *http.Request, id int64) ([]PVC, error) {
 	query := `
-		SELECT pvc.id, pvc.name, pvc.uid, pvc.namespace_id, n.name
+		SELECT pvc.id, pvc.name, pvc.uid, pvc.namespace_id, n.name,
+			pvc.storage_class, pvc.volume_name, pvc.phase, pvc.requested_mb, pvc.capacity_mb,
+			(SELECT f.message FROM volume_failures f LEFT JOIN pods p ON f.pod_id = p.id
+			 WHERE f.pvc_id = pvc.id AND ` + failingCond + ` ORDER BY f.last_seen DESC LIMIT 1)
 		FROM pvcs pvc
 		JOIN namespaces n ON pvc.namespace_id = n.id
+		WHERE 1=1
 	`
-	args := []interface{}{}
+	args := []interface{}{s.now(r).Add(-failingWindow).UTC()}
 
 	if nsID, ok := getQueryInt(r, "namespace"); ok {
-		query += " WHERE pvc.namespace_id = ?"
+		query += " AND pvc.namespace_id = ?"
 		args = append(args, nsID)
 	}
+	if sc := r.URL.Query().Get("storage_class"); sc != "" {
+		query += " AND pvc.storage_class = ?"
+		args = append(args, sc)
+	}
+	if id > 0 {
+		query += " AND pvc.id = ?"
+		args = append(args, id)
+	}
 
 	query += " ORDER BY pvc.name"
 
 	rows, err := s.sqlite.Query(query, args...)
 	if err != nil {
-		writeError(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
 	pvcs := []PVC{}
 	for rows.Next() {
 		var pvc PVC
-		if err := rows.Scan(&pvc.ID, &pvc.Name, &pvc.UID, &pvc.NamespaceID, &pvc.Namespace); err != nil {
+		if err := rows.Scan(&pvc.ID, &pvc.Name, &pvc.UID, &pvc.NamespaceID, &pvc.Namespace,
+			&pvc.StorageClass, &pvc.VolumeName, &pvc.Phase, &pvc.RequestedMB, &pvc.CapacityMB, &pvc.MountError); err != nil {
 			continue
 		}
+		pvc.Health = pvcHealth(pvc.Phase, pvc.MountError)
 		pvcs = append(pvcs, pvc)
 	}
-
-	writeJSON(w, pvcs)
+	return pvcs, nil
 }
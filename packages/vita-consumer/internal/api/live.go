@@ -1,14 +1,29 @@
 package api
 
 import (
+	"database/sql"
 	"net/http"
+	"slices"
 	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/units"
 )
 
 // LiveMetricsResponse represents the response for live metrics
 type LiveMetricsResponse struct {
-	Timestamp int64     `json:"timestamp"`
-	Pods      []LivePod `json:"pods"`
+	Timestamp int64 `json:"timestamp"`
+	// CatalogGeneration is the /api/v1/catalog seq the pod metadata is
+	// from. Consistent is false when reads kept racing catalog changes or
+	// flushes and the last attempt was served anyway.
+	CatalogGeneration int64             `json:"catalog_generation"`
+	Consistent        bool              `json:"consistent"`
+	Units             map[string]string `json:"units"`
+	Agg               string            `json:"agg"`
+	Pods              []LivePod         `json:"pods"`
+	// Deployments is set with group=deployment
+	Deployments []LiveGroup `json:"deployments,omitempty"`
 }
 
 // LivePod represents a pod with its live metrics
@@ -21,14 +36,37 @@ type LivePod struct {
 	Deployment *string         `json:"deployment,omitempty"`
 	Containers []ContainerInfo `json:"containers"`
 	PVCs       []PVCInfo       `json:"pvcs"`
+
+	deploymentID int64
 }
 
-// ContainerInfo represents container metrics
+// ContainerInfo represents container metrics. The "default" entry is the
+// pod-level cgroup, so its requests/limits are the sums over the pod spec.
 type ContainerInfo struct {
 	ID         string  `json:"id"`
 	CPUms      float64 `json:"cpu_ms"`
 	MemMB      float64 `json:"mem_mb"`
 	MemLimitMB float64 `json:"mem_limit_mb"`
+
+	// Breakdown of mem_mb, for agents that report it
+	MemWorkingSetMB *float64 `json:"mem_working_set_mb,omitempty"`
+	MemRSSMB        *float64 `json:"mem_rss_mb,omitempty"`
+	MemCacheMB      *float64 `json:"mem_cache_mb,omitempty"`
+	MemSwapMB       *float64 `json:"mem_swap_mb,omitempty"`
+
+	// CPUCores is the usage rate derived from the last two cpu_ms samples
+	CPUCores *float64 `json:"cpu_cores,omitempty"`
+	// CPUThrottledPct is the share of CFS periods throttled between the
+	// last two samples, for agents that report throttling
+	CPUThrottledPct *float64 `json:"cpu_throttled_pct,omitempty"`
+
+	CPURequestM  *int64   `json:"cpu_request_m,omitempty"`
+	CPULimitM    *int64   `json:"cpu_limit_m,omitempty"`
+	MemRequestMB *float64 `json:"mem_request_mb,omitempty"`
+
+	// Utilization against the limit, or the request when there is no limit
+	CPUUtilizationPct *float64 `json:"cpu_utilization_pct,omitempty"`
+	MemUtilizationPct *float64 `json:"mem_utilization_pct,omitempty"`
 }
 
 // PVCInfo represents PVC metrics
@@ -41,30 +79,133 @@ type PVCInfo struct {
 	FreeMB     float64 `json:"free_mb"`
 }
 
+const (
+	// liveWindow is how recent a sample must be for its pod to count as live
+	liveWindow = 5 * time.Second
+	// ringWindow bounds what is read from the ring buffer; it is longer than
+	// the flush interval, so in practice the whole buffer
+	ringWindow = 2 * time.Minute
+	// asOfLookback is how far before ?asOf= stored samples are considered.
+	// Stored history is sparser than the ring buffer, so it is wider than
+	// liveWindow.
+	asOfLookback = time.Minute
+	// liveReadAttempts bounds retries of a live read that raced a catalog
+	// change or a flush
+	liveReadAttempts = 3
+)
+
+// querier runs catalog reads, in or outside a transaction
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// handleLiveMetrics serves /api/v1/metrics/live[?deployment=&node=&pod=&asOf=&agg=&group=].
+// agg (last, avg, max or sum; default last) reduces each metric's samples
+// in the live window; cpu_cores is then the average rate across the window
+// for avg and sum, and the peak rate between samples for max.
+// group=deployment also sums the pods of each deployment.
 func (s *Server) handleLiveMetrics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	opts, err := parseLiveOptions(r.URL.Query().Get("agg"), r.URL.Query().Get("group"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Pods with samples in the last few seconds of the ring buffer are live.
+	// With ?asOf= the same view is rebuilt from stored history instead.
+	now := s.clock.Now()
+	cutoffTime := now.Add(-liveWindow)
+	asOf, historical, err := getAsOf(r)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if historical {
+		now, cutoffTime = asOf, asOf.Add(-asOfLookback)
+	}
+
+	// Metrics were resolved to pods against the catalog at ingest, and the
+	// buffer is copied shard by shard. A read that raced a catalog change
+	// or a flush is retried, so metrics and metadata match.
+	var pods []LivePod
+	var gen int64
+	consistent := false
+	for attempt := 1; !consistent && attempt <= liveReadAttempts; attempt++ {
+		before, err := s.sqlite.CatalogGeneration()
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		flushes := s.ring.Generation()
+		var allMetrics []buffer.Metric
+		if historical {
+			if allMetrics, err = s.metricsAt(r, asOf); err != nil {
+				writeError(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		} else {
+			allMetrics = s.liveMetrics(r, now)
+		}
+		gen, err = s.sqlite.ReadCatalog(func(tx *sql.Tx) error {
+			var err error
+			if pods, err = s.livePods(tx, r, allMetrics, cutoffTime, opts.Agg); err != nil {
+				return err
+			}
+			return s.applyContainerSpecs(tx, pods)
+		})
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		consistent = gen == before && (historical || s.ring.Generation() == flushes)
+	}
 
-	// Get recent metrics from ring buffer (last 5 seconds)
-	cutoffTime := time.Now().Add(-5 * time.Second)
-	allMetrics := s.ring.ReadAll()
+	resp := LiveMetricsResponse{
+		Timestamp:         now.Unix(),
+		CatalogGeneration: gen,
+		Consistent:        consistent,
+		Units:             liveUnits(),
+		Agg:               opts.Agg,
+		Pods:              pods,
+	}
+	if opts.Group == "deployment" {
+		resp.Deployments = groupByDeployment(pods)
+	}
+	writeJSON(w, resp)
+}
 
-	// Build pod ID set from recent metrics
+// livePods builds the live view of the pods with samples after cutoff,
+// reading their metadata through q. agg reduces the samples of each metric.
+func (s *Server) livePods(q querier, r *http.Request, allMetrics []buffer.Metric, cutoffTime time.Time, agg string) ([]LivePod, error) {
+	// Build pod ID set from recent metrics, and keep the two latest
+	// samples of each CPU counter per pod to derive rates
 	activePodIDs := make(map[int64]bool)
+	counterSamples := make(map[counterKey]*[2]buffer.Metric)
 	for _, m := range allMetrics {
 		if m.Time.After(cutoffTime) && m.ResourceID > 0 {
 			activePodIDs[m.ResourceID] = true
 		}
+		if liveCounters[m.Type] && m.ResourceID > 0 {
+			k := counterKey{m.ResourceID, m.Type}
+			pair, ok := counterSamples[k]
+			if !ok {
+				pair = &[2]buffer.Metric{}
+				counterSamples[k] = pair
+			}
+			if m.Time.After(pair[1].Time) {
+				pair[0], pair[1] = pair[1], m
+			} else if m.Time.After(pair[0].Time) && m.Time.Before(pair[1].Time) {
+				pair[0] = m
+			}
+		}
 	}
 
 	if len(activePodIDs) == 0 {
-		writeJSON(w, LiveMetricsResponse{
-			Timestamp: time.Now().Unix(),
-			Pods:      []LivePod{},
-		})
-		return
+		return []LivePod{}, nil
 	}
 
 	// Build WHERE clause for SQL query based on filters
@@ -86,7 +227,7 @@ func (s *Server) handleLiveMetrics(w http.ResponseWriter, r *http.Request) {
 
 	// Query pod metadata (we'll filter by active IDs in Go)
 	query := `
-		SELECT p.id, p.name, p.uid, ns.name, n.name, d.name
+		SELECT p.id, p.name, p.uid, ns.name, n.name, d.id, d.name
 		FROM pods p
 		JOIN namespaces ns ON p.namespace_id = ns.id
 		JOIN nodes n ON p.node_id = n.id
@@ -95,21 +236,22 @@ func (s *Server) handleLiveMetrics(w http.ResponseWriter, r *http.Request) {
 		ORDER BY p.name
 	`
 
-	rows, err := s.sqlite.Query(query, args...)
+	rows, err := q.Query(query, args...)
 	if err != nil {
-		writeError(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
 	pods := []LivePod{}
 	for rows.Next() {
 		var p LivePod
+		var depID sql.NullInt64
 		var depName *string
-		if err := rows.Scan(&p.ID, &p.Name, &p.UID, &p.Namespace, &p.Node, &depName); err != nil {
+		if err := rows.Scan(&p.ID, &p.Name, &p.UID, &p.Namespace, &p.Node, &depID, &depName); err != nil {
 			continue
 		}
 		p.Deployment = depName
+		p.deploymentID = depID.Int64
 
 		// Filter by active pod IDs
 		if !activePodIDs[p.ID] {
@@ -119,29 +261,30 @@ func (s *Server) handleLiveMetrics(w http.ResponseWriter, r *http.Request) {
 		// Aggregate container and PVC metrics for this pod
 		containerMetrics := make(map[string]*ContainerInfo)
 		pvcMetrics := make(map[int64]*PVCInfo)
+		gauges := make(map[string]*gaugeAcc)
+		var cpuSamples []buffer.Metric
 
 		for _, m := range allMetrics {
 			if m.ResourceID != p.ID || m.Time.Before(cutoffTime) {
 				continue
 			}
 
-			// Container metrics (cpu_ms, mem_mb, mem_limit_mb)
+			// Container metrics (cpu_ms, mem_*)
 			switch m.Type {
 			case "cpu_ms":
 				if _, ok := containerMetrics["default"]; !ok {
 					containerMetrics["default"] = &ContainerInfo{ID: "default"}
 				}
 				containerMetrics["default"].CPUms = m.Value
-			case "mem_mb":
+				cpuSamples = append(cpuSamples, m)
+			case "mem_mb", "mem_limit_mb", "mem_working_set_mb", "mem_rss_mb", "mem_cache_mb", "mem_swap_mb":
 				if _, ok := containerMetrics["default"]; !ok {
 					containerMetrics["default"] = &ContainerInfo{ID: "default"}
 				}
-				containerMetrics["default"].MemMB = m.Value
-			case "mem_limit_mb":
-				if _, ok := containerMetrics["default"]; !ok {
-					containerMetrics["default"] = &ContainerInfo{ID: "default"}
+				if gauges[m.Type] == nil {
+					gauges[m.Type] = &gaugeAcc{}
 				}
-				containerMetrics["default"].MemLimitMB = m.Value
+				gauges[m.Type].add(m.Value)
 			case "total_mb", "used_mb", "free_mb":
 				// PVC metrics - resource_id points to PVC or pod
 				// We need to identify which PVC this belongs to
@@ -149,6 +292,33 @@ func (s *Server) handleLiveMetrics(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		if c, ok := containerMetrics["default"]; ok {
+			for t, g := range gauges {
+				switch t {
+				case "mem_mb":
+					c.MemMB = g.value(agg)
+				case "mem_limit_mb":
+					c.MemLimitMB = g.value(agg)
+				default:
+					c.setMemory(t, g.value(agg))
+				}
+			}
+			// Aggregated rates fall back to the last two samples, which may
+			// predate the window
+			if rate, ok := windowRate(cpuSamples, agg); ok && agg != "last" {
+				cores := rate / 1000
+				c.CPUCores = &cores
+			} else if dv, dt, ok := counterDelta(counterSamples, p.ID, "cpu_ms"); ok {
+				cores := dv / dt / 1000
+				c.CPUCores = &cores
+			}
+			periods, _, ok := counterDelta(counterSamples, p.ID, "cpu_periods")
+			if throttled, _, ok2 := counterDelta(counterSamples, p.ID, "cpu_throttled_periods"); ok && ok2 && periods > 0 {
+				pct := throttled / periods * 100
+				c.CPUThrottledPct = &pct
+			}
+		}
+
 		for _, c := range containerMetrics {
 			p.Containers = append(p.Containers, *c)
 		}
@@ -158,9 +328,187 @@ func (s *Server) handleLiveMetrics(w http.ResponseWriter, r *http.Request) {
 
 		pods = append(pods, p)
 	}
+	return pods, rows.Err()
+}
+
+// liveTypes are the types the live view reads from the ring buffer
+var liveTypes = append([]string{"cpu_ms", "mem_mb", "mem_limit_mb", "cpu_periods", "cpu_throttled_periods"}, memoryBreakdown...)
+
+// liveMetrics reads what the live view needs from the ring buffer: one
+// pod's samples for ?pod=, otherwise the liveTypes of every pod, within
+// ringWindow of now
+func (s *Server) liveMetrics(r *http.Request, now time.Time) []buffer.Metric {
+	var ms []buffer.Metric
+	if podID, ok := getQueryInt(r, "pod"); ok {
+		ms = s.ring.ReadByResource(podID)
+	} else {
+		ms = s.ring.ReadByTypes(liveTypes...)
+	}
+	cutoff := now.Add(-ringWindow)
+	return slices.DeleteFunc(ms, func(m buffer.Metric) bool { return !m.Time.After(cutoff) })
+}
+
+// liveCounters are the counters whose rate the live view derives
+var liveCounters = map[string]bool{"cpu_ms": true, "cpu_periods": true, "cpu_throttled_periods": true}
+
+type counterKey struct {
+	id         int64
+	metricType string
+}
+
+// counterDelta is the increase of a counter between its last two samples
+// and the seconds between them; ok is false without two samples or across
+// a reset
+func counterDelta(samples map[counterKey]*[2]buffer.Metric, id int64, metricType string) (dv, dt float64, ok bool) {
+	pair := samples[counterKey{id, metricType}]
+	if pair == nil || pair[0].Time.IsZero() {
+		return 0, 0, false
+	}
+	dt = pair[1].Time.Sub(pair[0].Time).Seconds()
+	dv = pair[1].Value - pair[0].Value
+	return dv, dt, dt > 0 && dv >= 0
+}
 
-	writeJSON(w, LiveMetricsResponse{
-		Timestamp: time.Now().Unix(),
-		Pods:      pods,
+// metricsAt loads the stored samples in the asOfLookback window ending at
+// asOf (inclusive, to the millisecond), oldest first like the ring buffer
+func (s *Server) metricsAt(r *http.Request, asOf time.Time) ([]buffer.Metric, error) {
+	var out []buffer.Metric
+	err := s.duck.ScanRange(r.Context(), asOf.Add(-asOfLookback), asOf.Add(time.Millisecond), func(p store.MetricPoint) error {
+		out = append(out, buffer.Metric{Time: p.Time, ResourceID: p.ResourceID, Type: p.MetricType, Value: p.Value})
+		return nil
 	})
+	return out, err
+}
+
+// liveUnits describes the unit of each value field in the live response
+func liveUnits() map[string]string {
+	out := make(map[string]string)
+	types := append([]string{"cpu_ms", "cpu_throttled_ms", "cpu_periods", "cpu_throttled_periods", "mem_mb", "mem_limit_mb"}, memoryBreakdown...)
+	for _, t := range append(types, "total_mb", "used_mb", "free_mb") {
+		if info, ok := units.Lookup(t); ok {
+			out[t] = info.Unit
+		}
+	}
+	return out
+}
+
+// applyContainerSpecs attaches requests/limits from the captured pod specs
+// to live container entries and computes utilization percentages
+func (s *Server) applyContainerSpecs(q querier, pods []LivePod) error {
+	if len(pods) == 0 {
+		return nil
+	}
+
+	ids := make([]interface{}, len(pods))
+	for i, p := range pods {
+		ids[i] = p.ID
+	}
+	rows, err := q.Query(`SELECT pod_id, name, cpu_request_m, cpu_limit_m, mem_request_mb, mem_limit_mb
+		FROM pod_containers WHERE pod_id IN (`+placeholders(len(ids))+`)`, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	specs := make(map[int64][]store.ContainerResources)
+	for rows.Next() {
+		var podID int64
+		var c store.ContainerResources
+		if err := rows.Scan(&podID, &c.Name, &c.CPURequestM, &c.CPULimitM, &c.MemRequestMB, &c.MemLimitMB); err != nil {
+			continue
+		}
+		specs[podID] = append(specs[podID], c)
+	}
+
+	for i := range pods {
+		for j := range pods[i].Containers {
+			c := &pods[i].Containers[j]
+			spec := podTotals(specs[pods[i].ID])
+			for _, cs := range specs[pods[i].ID] {
+				if cs.Name == c.ID {
+					spec = cs
+				}
+			}
+			applySpec(c, spec)
+		}
+	}
+	return nil
+}
+
+// podTotals sums container resources; a limit is only set when every
+// container has one, since otherwise the pod is effectively unbounded
+func podTotals(containers []store.ContainerResources) store.ContainerResources {
+	total := store.ContainerResources{Name: "default"}
+	if len(containers) == 0 {
+		return total
+	}
+
+	var cpuReq, cpuLim int64
+	var memReq, memLim float64
+	cpuLimAll, memLimAll := true, true
+	hasCPUReq, hasMemReq := false, false
+	for _, c := range containers {
+		if c.CPURequestM != nil {
+			cpuReq += *c.CPURequestM
+			hasCPUReq = true
+		}
+		if c.MemRequestMB != nil {
+			memReq += *c.MemRequestMB
+			hasMemReq = true
+		}
+		if c.CPULimitM != nil {
+			cpuLim += *c.CPULimitM
+		} else {
+			cpuLimAll = false
+		}
+		if c.MemLimitMB != nil {
+			memLim += *c.MemLimitMB
+		} else {
+			memLimAll = false
+		}
+	}
+	if hasCPUReq {
+		total.CPURequestM = &cpuReq
+	}
+	if hasMemReq {
+		total.MemRequestMB = &memReq
+	}
+	if cpuLimAll {
+		total.CPULimitM = &cpuLim
+	}
+	if memLimAll {
+		total.MemLimitMB = &memLim
+	}
+	return total
+}
+
+func applySpec(c *ContainerInfo, spec store.ContainerResources) {
+	c.CPURequestM = spec.CPURequestM
+	c.CPULimitM = spec.CPULimitM
+	c.MemRequestMB = spec.MemRequestMB
+	if c.MemLimitMB == 0 && spec.MemLimitMB != nil {
+		c.MemLimitMB = *spec.MemLimitMB
+	}
+
+	if c.CPUCores != nil {
+		var denom int64
+		if spec.CPULimitM != nil {
+			denom = *spec.CPULimitM
+		} else if spec.CPURequestM != nil {
+			denom = *spec.CPURequestM
+		}
+		if denom > 0 {
+			pct := *c.CPUCores * 1000 / float64(denom) * 100
+			c.CPUUtilizationPct = &pct
+		}
+	}
+
+	memDenom := c.MemLimitMB
+	if memDenom == 0 && spec.MemRequestMB != nil {
+		memDenom = *spec.MemRequestMB
+	}
+	if memDenom > 0 {
+		pct := c.MemMB / memDenom * 100
+		c.MemUtilizationPct = &pct
+	}
 }
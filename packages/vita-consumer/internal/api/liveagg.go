@@ -0,0 +1,146 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+)
+
+// liveAggs reduce the samples of a metric within the live window
+var liveAggs = map[string]bool{"last": true, "avg": true, "max": true, "sum": true}
+
+// liveOptions are the aggregation parameters of a live read
+type liveOptions struct {
+	Agg   string // last, avg, max or sum
+	Group string // "" or deployment
+}
+
+func parseLiveOptions(agg, group string) (liveOptions, error) {
+	o := liveOptions{Agg: agg, Group: group}
+	if o.Agg == "" {
+		o.Agg = "last"
+	}
+	if !liveAggs[o.Agg] {
+		return o, fmt.Errorf("agg must be last, avg, max or sum")
+	}
+	if o.Group != "" && o.Group != "deployment" {
+		return o, fmt.Errorf("group must be deployment")
+	}
+	return o, nil
+}
+
+// gaugeAcc accumulates the samples of one gauge
+type gaugeAcc struct {
+	n              int
+	sum, max, last float64
+}
+
+func (a *gaugeAcc) add(v float64) {
+	if a.n == 0 || v > a.max {
+		a.max = v
+	}
+	a.n++
+	a.sum += v
+	a.last = v
+}
+
+func (a *gaugeAcc) value(agg string) float64 {
+	switch agg {
+	case "avg":
+		return a.sum / float64(a.n)
+	case "max":
+		return a.max
+	case "sum":
+		return a.sum
+	}
+	return a.last
+}
+
+// windowRate is the per-second increase of a counter across its samples in
+// the window: first to last for avg and sum, the steepest step between
+// consecutive samples for max. ok is false with fewer than two samples or
+// across a reset.
+func windowRate(samples []buffer.Metric, agg string) (rate float64, ok bool) {
+	if len(samples) < 2 {
+		return 0, false
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Time.Before(samples[j].Time) })
+	if agg == "max" {
+		for i := 1; i < len(samples); i++ {
+			dt := samples[i].Time.Sub(samples[i-1].Time).Seconds()
+			dv := samples[i].Value - samples[i-1].Value
+			if dt <= 0 || dv < 0 {
+				continue
+			}
+			if r := dv / dt; !ok || r > rate {
+				rate, ok = r, true
+			}
+		}
+		return rate, ok
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	dt := last.Time.Sub(first.Time).Seconds()
+	dv := last.Value - first.Value
+	if dt <= 0 || dv < 0 {
+		return 0, false
+	}
+	return dv / dt, true
+}
+
+// LiveGroup is the usage of a deployment's live pods, summed
+type LiveGroup struct {
+	ID              int64    `json:"id"`
+	Name            string   `json:"name"`
+	Namespace       string   `json:"namespace"`
+	Pods            int      `json:"pods"`
+	CPUCores        float64  `json:"cpu_cores"`
+	MemMB           float64  `json:"mem_mb"`
+	MemLimitMB      float64  `json:"mem_limit_mb"`
+	MemWorkingSetMB *float64 `json:"mem_working_set_mb,omitempty"`
+}
+
+// groupByDeployment sums the pod-level values of pods by deployment. Pods
+// of no deployment are left out.
+func groupByDeployment(pods []LivePod) []LiveGroup {
+	byID := make(map[int64]*LiveGroup)
+	for _, p := range pods {
+		if p.deploymentID == 0 {
+			continue
+		}
+		g := byID[p.deploymentID]
+		if g == nil {
+			g = &LiveGroup{ID: p.deploymentID, Name: *p.Deployment, Namespace: p.Namespace}
+			byID[p.deploymentID] = g
+		}
+		g.Pods++
+		for _, c := range p.Containers {
+			if c.ID != "default" {
+				continue
+			}
+			if c.CPUCores != nil {
+				g.CPUCores += *c.CPUCores
+			}
+			g.MemMB += c.MemMB
+			g.MemLimitMB += c.MemLimitMB
+			if c.MemWorkingSetMB != nil {
+				if g.MemWorkingSetMB == nil {
+					g.MemWorkingSetMB = new(float64)
+				}
+				*g.MemWorkingSetMB += *c.MemWorkingSetMB
+			}
+		}
+	}
+
+	out := make([]LiveGroup, 0, len(byID))
+	for _, g := range byID {
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Namespace != out[j].Namespace {
+			return out[i].Namespace < out[j].Namespace
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
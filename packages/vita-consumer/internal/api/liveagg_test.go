@@ -0,0 +1,94 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+)
+
+// TestLiveAgg reduces the live window by agg and sums a deployment's pods
+func TestLiveAgg(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		rs, _, err := env.CreateDeployment(ctx, synctest.Deployment("shop", "web", 2))
+		if err != nil {
+			return err
+		}
+		pods, err := env.CreatePods(ctx, synctest.Pod("shop", "web-0", "node-a", rs), synctest.Pod("shop", "web-1", "node-a", rs))
+		if err != nil {
+			return err
+		}
+		web0, web1 := pods["web-0"], pods["web-1"]
+
+		// web-0 grows from 100 to 400 MiB and runs at 1, 0.5 then 0.5 cores;
+		// web-1 holds 50 MiB at 0.5 cores
+		now := env.Clock.Now()
+		for i, mem := range []float64{100, 200, 300, 400} {
+			at := now.Add(time.Duration(i-4) * time.Second)
+			env.Ring.AddBatch([]buffer.Metric{
+				{Time: at, ResourceID: int64(web0), Type: "mem_mb", Value: mem},
+				{Time: at, ResourceID: int64(web0), Type: "cpu_ms", Value: []float64{0, 1000, 1500, 2000}[i]},
+				{Time: at, ResourceID: int64(web1), Type: "mem_mb", Value: 50},
+				{Time: at, ResourceID: int64(web1), Type: "cpu_ms", Value: float64(500 * i)},
+			})
+		}
+
+		web0Of := func(live api.LiveMetricsResponse) (api.ContainerInfo, error) {
+			for _, p := range live.Pods {
+				if p.ID == int64(web0) && len(p.Containers) == 1 && p.Containers[0].CPUCores != nil {
+					return p.Containers[0], nil
+				}
+			}
+			return api.ContainerInfo{}, fmt.Errorf("web-0 missing from %+v", live.Pods)
+		}
+		for _, tc := range []struct {
+			agg        string
+			mem, cores float64
+		}{
+			{"", 400, 0.5},
+			{"avg", 250, 2.0 / 3},
+			{"max", 400, 1},
+			{"sum", 1000, 2.0 / 3},
+		} {
+			var live api.LiveMetricsResponse
+			if err := env.GetJSON("/api/v1/metrics/live?agg="+tc.agg, &live); err != nil {
+				return err
+			}
+			c, err := web0Of(live)
+			if err != nil {
+				return err
+			}
+			if c.MemMB != tc.mem || math.Abs(*c.CPUCores-tc.cores) > 1e-9 {
+				return fmt.Errorf("agg %q: web-0 mem %v cores %v, want %v and %v", tc.agg, c.MemMB, *c.CPUCores, tc.mem, tc.cores)
+			}
+			want := tc.agg
+			if want == "" {
+				want = "last"
+			}
+			if live.Agg != want || live.Deployments != nil {
+				return fmt.Errorf("agg %q: response agg %q, deployments %v", tc.agg, live.Agg, live.Deployments)
+			}
+		}
+
+		var grouped api.LiveMetricsResponse
+		if err := env.GetJSON("/api/v1/metrics/live?agg=max&group=deployment", &grouped); err != nil {
+			return err
+		}
+		if len(grouped.Deployments) != 1 {
+			return fmt.Errorf("grouped deployments = %+v, want web", grouped.Deployments)
+		}
+		if g := grouped.Deployments[0]; g.Name != "web" || g.Namespace != "shop" || g.Pods != 2 || g.MemMB != 450 || math.Abs(g.CPUCores-1.5) > 1e-9 {
+			return fmt.Errorf("web totals = %+v, want 2 pods, 450 MiB, 1.5 cores", g)
+		}
+
+		if err := env.GetJSON("/api/v1/metrics/live?agg=median", nil); err == nil {
+			return fmt.Errorf("unknown agg accepted")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+)
+
+// LiveDeploymentsResponse carries running totals for the deployment cards
+type LiveDeploymentsResponse struct {
+	Timestamp   int64            `json:"timestamp"`
+	Deployments []LiveDeployment `json:"deployments"`
+}
+
+// LiveDeployment is the current usage summed over a deployment's live pods
+type LiveDeployment struct {
+	ID            int64   `json:"id"`
+	Name          string  `json:"name"`
+	Namespace     string  `json:"namespace"`
+	CPUMillicores float64 `json:"cpu_millicores"`
+	MemMB         float64 `json:"mem_mb"`
+	Pods          int     `json:"pods"`
+	UpdatedAt     int64   `json:"updated_at"`
+}
+
+// handleLiveDeployments serves /api/v1/metrics/live/deployments
+// [?namespace=&deployment=]. Totals are maintained as samples arrive, so
+// they trail ingest by at most one agent interval.
+func (s *Server) handleLiveDeployments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.totals == nil {
+		writeError(w, "Live deployment totals are not enabled", http.StatusNotFound)
+		return
+	}
+
+	resp := LiveDeploymentsResponse{Timestamp: s.clock.Now().Unix(), Deployments: []LiveDeployment{}}
+	totals := s.totals.Totals()
+	if len(totals) == 0 {
+		writeJSON(w, resp)
+		return
+	}
+
+	query := `
+		SELECT d.id, d.name, n.name
+		FROM deployments d
+		JOIN namespaces n ON d.namespace_id = n.id
+		WHERE d.id IN (` + placeholders(len(totals)) + `)`
+	args := make([]interface{}, 0, len(totals)+2)
+	for _, t := range totals {
+		args = append(args, t.DeploymentID)
+	}
+	if nsID, ok := getQueryInt(r, "namespace"); ok {
+		query += " AND d.namespace_id = ?"
+		args = append(args, nsID)
+	}
+	if depID, ok := getQueryInt(r, "deployment"); ok {
+		query += " AND d.id = ?"
+		args = append(args, depID)
+	}
+
+	rows, err := s.sqlite.Query(query, args...)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	type meta struct{ name, namespace string }
+	names := make(map[int64]meta)
+	for rows.Next() {
+		var id int64
+		var m meta
+		if err := rows.Scan(&id, &m.name, &m.namespace); err != nil {
+			continue
+		}
+		names[id] = m
+	}
+
+	for _, t := range totals {
+		m, ok := names[t.DeploymentID]
+		if !ok {
+			continue // filtered out, or deleted since
+		}
+		resp.Deployments = append(resp.Deployments, LiveDeployment{
+			ID:            t.DeploymentID,
+			Name:          m.name,
+			Namespace:     m.namespace,
+			CPUMillicores: t.CPUMillicores,
+			MemMB:         t.MemMB,
+			Pods:          t.Pods,
+			UpdatedAt:     t.UpdatedAt.Unix(),
+		})
+	}
+	sort.Slice(resp.Deployments, func(i, j int) bool {
+		a, b := resp.Deployments[i], resp.Deployments[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	writeJSON(w, resp)
+}
@@ -0,0 +1,70 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+)
+
+// TestLiveDeploymentTotals verifies samples added to the ring buffer show
+// up in the deployment's running totals, and age out with the pods
+func TestLiveDeploymentTotals(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		rs, _, err := env.CreateDeployment(ctx, synctest.Deployment("shop", "web", 2))
+		if err != nil {
+			return err
+		}
+		pods, err := env.CreatePods(ctx, synctest.Pod("shop", "web-1", "node-a", rs), synctest.Pod("shop", "web-2", "node-a", rs))
+		if err != nil {
+			return err
+		}
+		ids := []int64{pods["web-1"], pods["web-2"]}
+
+		// Each pod uses 250m (cpu_ms grows 250 per second) and 100 MB
+		t0 := env.Clock.Now()
+		for step := 0; step < 2; step++ {
+			at := t0.Add(time.Duration(step) * time.Second)
+			for _, id := range ids {
+				env.Ring.AddBatch([]buffer.Metric{
+					{Time: at, ResourceID: id, Type: "cpu_ms", Value: float64(1000 + 250*step)},
+					{Time: at, ResourceID: id, Type: "mem_mb", Value: 100},
+				})
+			}
+		}
+		env.Clock.Set(t0.Add(time.Second))
+
+		var resp api.LiveDeploymentsResponse
+		if err := env.Eventually(synctest.Timeout, "deployment totals", func() (bool, error) {
+			if err := env.GetJSON("/api/v1/metrics/live/deployments", &resp); err != nil {
+				return false, err
+			}
+			if len(resp.Deployments) != 1 {
+				return false, nil
+			}
+			d := resp.Deployments[0]
+			if d.Name != "web" || d.Pods != 2 {
+				return false, nil
+			}
+			if d.CPUMillicores != 500 || d.MemMB != 200 {
+				return false, fmt.Errorf("got %.1fm CPU and %.1f MB, want 500m and 200 MB", d.CPUMillicores, d.MemMB)
+			}
+			return true, nil
+		}); err != nil {
+			return err
+		}
+
+		env.Clock.Advance(time.Minute)
+		if err := env.GetJSON("/api/v1/metrics/live/deployments", &resp); err != nil {
+			return err
+		}
+		if len(resp.Deployments) != 0 {
+			return fmt.Errorf("silent pods still counted: %+v", resp.Deployments)
+		}
+		return nil
+	})
+}
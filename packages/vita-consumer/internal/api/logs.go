@@ -0,0 +1,168 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Log tail bounds: lines per request and bytes read from the API server
+const (
+	defaultLogLines = 200
+	maxLogLines     = 5000
+	maxLogBytes     = 1 << 20
+)
+
+// PodLogSource reads container logs from the Kubernetes API
+type PodLogSource interface {
+	PodLogs(ctx context.Context, namespace, name string, opts *corev1.PodLogOptions) (io.ReadCloser, error)
+}
+
+// PodLogsAuth decides who may read logs. Token requires
+// "Authorization: Bearer <token>"; TrustProxyUser accepts requests carrying
+// a user set by an authenticating proxy (see requester), which is only safe
+// when nothing else can reach the API. Authenticated accepts any caller the
+// API authenticator proved the identity of (see Authenticate).
+type PodLogsAuth struct {
+	Token          string
+	TrustProxyUser bool
+	Authenticated  bool
+}
+
+// Enabled reports whether any way to authenticate is configured
+func (a PodLogsAuth) Enabled() bool {
+	return a.Token != "" || a.TrustProxyUser || a.Authenticated
+}
+
+func (a PodLogsAuth) allow(r *http.Request) bool {
+	if a.Authenticated && PrincipalFrom(r.Context()).Authenticated() {
+		return true
+	}
+	if a.Token != "" {
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok &&
+			subtle.ConstantTimeCompare([]byte(bearer), []byte(a.Token)) == 1 {
+			return true
+		}
+	}
+	if a.TrustProxyUser {
+		for _, h := range []string{"X-Forwarded-User", "X-Remote-User", "X-Auth-Request-User"} {
+			if r.Header.Get(h) != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PodLogsResponse is the tail of one container's log, oldest line first
+type PodLogsResponse struct {
+	PodID     int64    `json:"pod_id"`
+	Pod       string   `json:"pod"`
+	Namespace string   `json:"namespace"`
+	Container string   `json:"container,omitempty"`
+	Previous  bool     `json:"previous,omitempty"`
+	Lines     []string `json:"lines"`
+	Truncated bool     `json:"truncated"` // cut at maxLogBytes
+}
+
+// handlePodLogs serves GET /api/v1/pods/{id}/logs[?container=&lines=&since=&previous=true&timestamps=true].
+// lines (default 200, at most 5000) counts back from the end of the log,
+// or from since (unix seconds) onwards, e.g. the start of a metric spike.
+// previous reads the last terminated container instance.
+func (s *Server) handlePodLogs(w http.ResponseWriter, r *http.Request) {
+	if !s.podLogsAuth.allow(r) {
+		writeError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	lines, ok := getQueryInt(r, "lines")
+	if !ok || lines <= 0 {
+		lines = defaultLogLines
+	}
+	lines = min(lines, maxLogLines)
+	limit := int64(maxLogBytes) + 1 // one more to tell a cut log
+	opts := &corev1.PodLogOptions{
+		Container:  q.Get("container"),
+		Previous:   q.Get("previous") == "true",
+		Timestamps: q.Get("timestamps") == "true",
+		LimitBytes: &limit,
+	}
+	if since, ok := getQueryInt(r, "since"); ok {
+		t := metav1.NewTime(time.Unix(since, 0))
+		opts.SinceTime = &t
+	} else {
+		opts.TailLines = &lines
+	}
+
+	resp := PodLogsResponse{PodID: id, Container: opts.Container, Previous: opts.Previous, Lines: []string{}}
+	pod, err := s.sqlite.GetPodRef(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, "Pod not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if pod.Deleted {
+		writeError(w, "Pod has been deleted, its logs are gone", http.StatusGone)
+		return
+	}
+	resp.Namespace, resp.Pod = pod.Namespace, pod.Name
+
+	stream, err := s.podLogs.PodLogs(r.Context(), resp.Namespace, resp.Pod, opts)
+	if err != nil {
+		code := http.StatusBadGateway
+		switch {
+		case apierrors.IsNotFound(err):
+			code = http.StatusNotFound
+		case apierrors.IsBadRequest(err): // e.g. no container given for a multi-container pod
+			code = http.StatusBadRequest
+		case apierrors.IsForbidden(err):
+			code = http.StatusForbidden
+		}
+		writeError(w, err.Error(), code)
+		return
+	}
+	defer stream.Close()
+
+	body, err := io.ReadAll(io.LimitReader(stream, limit))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if int64(len(body)) > maxLogBytes {
+		body = body[:maxLogBytes]
+		resp.Truncated = true
+	}
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	sc.Buffer(make([]byte, 0, 64<<10), maxLogBytes)
+	for sc.Scan() {
+		resp.Lines = append(resp.Lines, sc.Text())
+	}
+	if opts.SinceTime != nil && int64(len(resp.Lines)) > lines {
+		resp.Lines = resp.Lines[:lines]
+	}
+
+	log.Printf("Audit: %s read logs of pod %s/%s", requester(r), resp.Namespace, resp.Pod)
+	writeJSON(w, resp)
+}
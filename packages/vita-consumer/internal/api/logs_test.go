@@ -0,0 +1,73 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestPodLogs reads a log tail through the API, which must refuse
+// callers without the token
+func TestPodLogs(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		pod := synctest.Pod("default", "chatty", "node-a", nil)
+		if _, err := env.Client.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pod synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pods WHERE uid = ?", string(pod.UID))
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+		id, err := env.QueryInt("SELECT id FROM pods WHERE uid = ?", string(pod.UID))
+		if err != nil {
+			return err
+		}
+
+		get := func(token string) (*http.Response, error) {
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/v1/pods/%d/logs?lines=10", env.API.URL, id), nil)
+			if err != nil {
+				return nil, err
+			}
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			return http.DefaultClient.Do(req)
+		}
+		for _, token := range []string{"", "wrong"} {
+			resp, err := get(token)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusUnauthorized {
+				return fmt.Errorf("logs with token %q: %s, want 401", token, resp.Status)
+			}
+		}
+
+		resp, err := get(synctest.PodLogsToken)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("logs: %s", resp.Status)
+		}
+		var logs api.PodLogsResponse
+		if err := json.NewDecoder(resp.Body).Decode(&logs); err != nil {
+			return err
+		}
+		// The fake clientset answers every log request with "fake logs"
+		if logs.Pod != "chatty" || logs.Namespace != "default" || len(logs.Lines) != 1 || logs.Lines[0] != "fake logs" {
+			return fmt.Errorf("logs = %+v", logs)
+		}
+		return nil
+	})
+}
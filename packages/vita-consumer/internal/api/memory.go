@@ -0,0 +1,86 @@
+package api
+
+import "net/http"
+
+// memoryBreakdown are the parts of mem_mb agents may report per container.
+// Working set is what the kubelet evicts on and close to what the OOM
+// killer sees; RSS and cache tell a leak from a busy page cache.
+var memoryBreakdown = []string{"mem_working_set_mb", "mem_rss_mb", "mem_cache_mb", "mem_swap_mb"}
+
+// memoryTypes are the series returned by /api/v1/metrics/memory
+var memoryTypes = append([]string{"mem_mb", "mem_limit_mb"}, memoryBreakdown...)
+
+// MemoryHistoryResponse holds the memory series of one pod. Types the
+// agent does not report are empty.
+type MemoryHistoryResponse struct {
+	ResourceID int64                    `json:"resource_id"`
+	From       int64                    `json:"from"`
+	To         int64                    `json:"to"`
+	Step       int64                    `json:"step,omitempty"`
+	TZ         string                   `json:"tz,omitempty"`
+	Series     map[string][]SeriesPoint `json:"series"`
+}
+
+// setMemory stores one memoryBreakdown sample
+func (c *ContainerInfo) setMemory(metricType string, v float64) {
+	switch metricType {
+	case "mem_working_set_mb":
+		c.MemWorkingSetMB = &v
+	case "mem_rss_mb":
+		c.MemRSSMB = &v
+	case "mem_cache_mb":
+		c.MemCacheMB = &v
+	case "mem_swap_mb":
+		c.MemSwapMB = &v
+	}
+}
+
+// handleMemoryHistory serves /api/v1/metrics/memory?resource=[&from=&to=&step=&agg=&unit=&tz=]:
+// usage, limit and its breakdown for one pod, each series as for
+// /api/v1/metrics/series. agg=max keeps peaks, which is what an OOM
+// investigation usually wants.
+func (s *Server) handleMemoryHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resourceID, ok := getQueryInt(r, "resource")
+	if !ok {
+		writeError(w, "resource parameter is required", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	sq := SeriesQuery{Resource: resourceID, Agg: q.Get("agg"), Unit: q.Get("unit"), TZ: q.Get("tz")}
+	sq.Step, _ = getQueryInt(r, "step")
+	from, to := s.getTimeRange(r)
+
+	resp := MemoryHistoryResponse{
+		ResourceID: resourceID,
+		From:       from.Unix(),
+		To:         to.Unix(),
+		Step:       sq.Step,
+		TZ:         sq.TZ,
+		Series:     make(map[string][]SeriesPoint, len(memoryTypes)),
+	}
+	for _, t := range memoryTypes {
+		sq.Type = t
+		series, err := s.querySeries(r.Context(), sq, from, to)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if _, ok := err.(badQueryError); ok {
+				code = http.StatusBadRequest
+			}
+			writeError(w, err.Error(), code)
+			return
+		}
+		resp.Series[t] = series.Points
+		// The breakdown types are sampled together, so once one is widened
+		// the rest are queried at its step to stay aligned
+		if series.Step > sq.Step {
+			sq.Step = series.Step
+			resp.Step = series.Step
+		}
+	}
+	writeJSON(w, resp)
+}
@@ -0,0 +1,76 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestMemoryBreakdown verifies working set, RSS, cache and swap samples
+// show up next to mem_mb in the live view and in memory history
+func TestMemoryBreakdown(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		if _, err := env.Client.CoreV1().Pods("default").Create(ctx, synctest.Pod("default", "leaky", "node-a", nil), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pod synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pods")
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+		pod, err := env.QueryInt("SELECT id FROM pods WHERE name = 'leaky'")
+		if err != nil {
+			return err
+		}
+
+		now := env.Clock.Now()
+		sample := map[string]float64{"mem_mb": 900, "mem_working_set_mb": 700, "mem_rss_mb": 600, "mem_cache_mb": 300, "mem_swap_mb": 20}
+		var points []store.MetricPoint
+		for t, v := range sample {
+			env.Ring.Add(buffer.Metric{Time: now, ResourceID: pod, Type: t, Value: v})
+			points = append(points,
+				store.MetricPoint{Time: now.Add(-2 * time.Minute), ResourceID: pod, MetricType: t, Value: v / 2},
+				store.MetricPoint{Time: now.Add(-time.Minute), ResourceID: pod, MetricType: t, Value: v})
+		}
+		if err := env.Duck.BatchInsert(points); err != nil {
+			return err
+		}
+
+		var live api.LiveMetricsResponse
+		if err := env.GetJSON("/api/v1/metrics/live", &live); err != nil {
+			return err
+		}
+		if len(live.Pods) != 1 || len(live.Pods[0].Containers) != 1 {
+			return fmt.Errorf("live pods = %+v, want one pod with one container", live.Pods)
+		}
+		c := live.Pods[0].Containers[0]
+		if c.MemWorkingSetMB == nil || *c.MemWorkingSetMB != 700 || c.MemRSSMB == nil || *c.MemRSSMB != 600 ||
+			c.MemCacheMB == nil || *c.MemCacheMB != 300 || c.MemSwapMB == nil || *c.MemSwapMB != 20 {
+			return fmt.Errorf("live container = %+v, want the 700/600/300/20 breakdown", c)
+		}
+
+		var hist api.MemoryHistoryResponse
+		path := fmt.Sprintf("/api/v1/metrics/memory?resource=%d&from=%d&to=%d&step=3600&agg=max",
+			pod, now.Add(-time.Hour).Unix(), now.Add(time.Hour).Unix())
+		if err := env.GetJSON(path, &hist); err != nil {
+			return err
+		}
+		for t, v := range sample {
+			if pts := hist.Series[t]; len(pts) == 0 || pts[len(pts)-1].V != v {
+				return fmt.Errorf("%s history = %v, want a peak of %v", t, pts, v)
+			}
+		}
+		if pts, ok := hist.Series["mem_limit_mb"]; !ok || len(pts) != 0 {
+			return fmt.Errorf("mem_limit_mb history = %v, want an empty series", pts)
+		}
+		return nil
+	})
+}
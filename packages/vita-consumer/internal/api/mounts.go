@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// failingWindow is how recently a volume warning must have been reported
+// for its mount to count as failing. The kubelet retries, and re-reports,
+// every couple of minutes while a mount fails.
+const failingWindow = 15 * time.Minute
+
+// VolumeFailure is a volume that failed to attach or mount for a pod
+type VolumeFailure struct {
+	ID          int64      `json:"id"`
+	NamespaceID int64      `json:"namespace_id"`
+	Namespace   string     `json:"namespace"`
+	PodID       *int64     `json:"pod_id,omitempty"`
+	Pod         string     `json:"pod"`
+	PVCID       *int64     `json:"pvc_id,omitempty"`
+	PVC         *string    `json:"pvc,omitempty"`
+	Volume      *string    `json:"volume,omitempty"`
+	Reason      string     `json:"reason"`
+	Message     string     `json:"message"`
+	Count       int        `json:"count"`
+	FirstSeen   time.Time  `json:"first_seen"`
+	LastSeen    time.Time  `json:"last_seen"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+}
+
+// handleVolumeFailures serves /api/v1/storage/failures[?namespace=&pvc=&all=true]:
+// mounts failing now, most recently reported first. A failure stops once
+// its pod is ready or deleted, or has not been reported for
+// failingWindow; all=true lists those too.
+func (s *Server) handleVolumeFailures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := `
+		SELECT f.id, f.namespace_id, ns.name, f.pod_id, f.pod_name, f.pvc_id, pvc.name, f.volume,
+			f.reason, f.message, f.count, f.first_seen, f.last_seen, f.resolved_at
+		FROM volume_failures f
+		JOIN namespaces ns ON f.namespace_id = ns.id
+		LEFT JOIN pods p ON f.pod_id = p.id
+		LEFT JOIN pvcs pvc ON f.pvc_id = pvc.id
+		WHERE 1=1
+	`
+	args := []interface{}{}
+
+	if r.URL.Query().Get("all") != "true" {
+		query += " AND " + failingCond
+		args = append(args, s.now(r).Add(-failingWindow).UTC())
+	}
+	if nsID, ok := getQueryInt(r, "namespace"); ok {
+		query += " AND f.namespace_id = ?"
+		args = append(args, nsID)
+	}
+	if pvcID, ok := getQueryInt(r, "pvc"); ok {
+		query += " AND f.pvc_id = ?"
+		args = append(args, pvcID)
+	}
+
+	query += " ORDER BY f.last_seen DESC, f.id DESC"
+
+	rows, err := s.sqlite.Query(query, args...)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	failures := []VolumeFailure{}
+	for rows.Next() {
+		var f VolumeFailure
+		if err := rows.Scan(&f.ID, &f.NamespaceID, &f.Namespace, &f.PodID, &f.Pod, &f.PVCID, &f.PVC, &f.Volume,
+			&f.Reason, &f.Message, &f.Count, &f.FirstSeen, &f.LastSeen, &f.ResolvedAt); err != nil {
+			continue
+		}
+		failures = append(failures, f)
+	}
+
+	writeJSON(w, failures)
+}
+
+// failingCond keeps the failures of volume_failures f, joined with its
+// pod p, that are still failing as of the bound cutoff
+const failingCond = `f.resolved_at IS NULL AND f.last_seen >= ? AND (p.id IS NULL OR p.deleted_at IS NULL)`
+
+// pvcHealth summarizes a claim for the PVC list: "failing" while a mount
+// of it fails, otherwise from its phase
+func pvcHealth(phase, mountError *string) string {
+	switch {
+	case mountError != nil:
+		return "failing"
+	case phase == nil:
+		return "unknown"
+	case *phase == "Lost":
+		return "lost"
+	case *phase == "Pending":
+		return "pending"
+	}
+	return "healthy"
+}
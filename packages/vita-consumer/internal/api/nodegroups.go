@@ -0,0 +1,108 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// unlabelledGroup names nodes whose labels do not say their group
+const unlabelledGroup = "(none)"
+
+// NodeGroupSummary is the nodes sharing one value of a node group
+// dimension
+type NodeGroupSummary struct {
+	Name             string  `json:"name"`
+	Nodes            int     `json:"nodes"`
+	Ready            int     `json:"ready"`
+	Pods             int     `json:"pods"` // running on them now
+	CPUAllocatableM  int64   `json:"cpu_allocatable_m"`
+	MemAllocatableMB float64 `json:"mem_allocatable_mb"`
+}
+
+// NodeGroupsResponse is the /api/v1/node-groups response
+type NodeGroupsResponse struct {
+	GroupBy string             `json:"group_by"`
+	Groups  []NodeGroupSummary `json:"groups"`
+}
+
+// nodeGroupName is a node's value of dimension, unlabelledGroup when it
+// has none
+func nodeGroupName(g store.NodeGroup, dimension string) string {
+	if v := g.Value(dimension); v != "" {
+		return v
+	}
+	return unlabelledGroup
+}
+
+// handleNodeGroups serves /api/v1/node-groups[?group_by=zone|region|nodepool|capacity_type].
+// Nodes not decommissioned are counted per value of the dimension
+// (default nodepool), with their allocatable capacity and pods.
+func (s *Server) handleNodeGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = "nodepool"
+	}
+	if err := store.ValidNodeGroupDimension(groupBy); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	groups, err := s.sqlite.NodeGroupsByID()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows, err := s.sqlite.Query(`
+		SELECT n.id, COALESCE(n.ready, 0), COALESCE(n.cpu_allocatable_m, 0), COALESCE(n.mem_allocatable_mb, 0),
+			(SELECT COUNT(*) FROM pods p WHERE p.node_id = n.id AND p.deleted_at IS NULL
+				AND COALESCE(p.phase, '') NOT IN ('Succeeded', 'Failed'))
+		FROM nodes n
+		WHERE n.decommissioned_at IS NULL AND n.deleted_at IS NULL`)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	byName := make(map[string]*NodeGroupSummary)
+	for rows.Next() {
+		var id, cpu int64
+		var ready bool
+		var mem float64
+		var pods int
+		if err := rows.Scan(&id, &ready, &cpu, &mem, &pods); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		name := nodeGroupName(groups[id], groupBy)
+		g := byName[name]
+		if g == nil {
+			g = &NodeGroupSummary{Name: name}
+			byName[name] = g
+		}
+		g.Nodes++
+		if ready {
+			g.Ready++
+		}
+		g.Pods += pods
+		g.CPUAllocatableM += cpu
+		g.MemAllocatableMB += mem
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := NodeGroupsResponse{GroupBy: groupBy, Groups: []NodeGroupSummary{}}
+	for _, g := range byName {
+		resp.Groups = append(resp.Groups, *g)
+	}
+	sort.Slice(resp.Groups, func(i, j int) bool { return resp.Groups[i].Name < resp.Groups[j].Name })
+	writeJSON(w, resp)
+}
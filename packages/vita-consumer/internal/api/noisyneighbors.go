@@ -0,0 +1,200 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/rollup"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// defaultSaturatedPct is the node CPU utilization counted as saturated
+// when ?threshold= is absent
+const defaultSaturatedPct = 80
+
+// NoisyNeighborsResponse ranks the pods of a node by their share of its
+// CPU while it was saturated
+type NoisyNeighborsResponse struct {
+	NodeID           int64           `json:"node_id"`
+	Node             string          `json:"node"`
+	From             int64           `json:"from"`
+	To               int64           `json:"to"`
+	Step             int64           `json:"step"`
+	AllocatableM     int64           `json:"allocatable_m"`
+	ThresholdPct     float64         `json:"threshold_pct"`
+	Buckets          int             `json:"buckets"`
+	SaturatedBuckets int             `json:"saturated_buckets"`
+	Pods             []NoisyNeighbor `json:"pods"`
+}
+
+// NoisyNeighbor is one pod's CPU on the node. SharePct is its part of the
+// node's usage in saturated buckets, BaselineSharePct in the others; it is
+// absent when the node was never below the threshold.
+type NoisyNeighbor struct {
+	PodID            int64    `json:"pod_id"`
+	Pod              string   `json:"pod"`
+	Namespace        string   `json:"namespace"`
+	AvgUsageM        float64  `json:"avg_usage_m"` // over saturated buckets
+	SharePct         float64  `json:"share_pct"`
+	BaselineSharePct *float64 `json:"baseline_share_pct,omitempty"`
+}
+
+// handleNoisyNeighbors serves /api/v1/analysis/noisy-neighbors?node=[&hours=&step=&threshold=].
+// hours of history (default 6) are bucketed by step seconds (default 300);
+// buckets where the node's CPU rollup reaches threshold percent (default
+// 80) of its allocatable CPU are saturated, and the pods placed on it are
+// ranked by their share of the node's usage in those buckets. The agent
+// reports no IO series, so only CPU is ranked.
+func (s *Server) handleNoisyNeighbors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	nodeID, ok := getQueryInt(r, "node")
+	if !ok || nodeID <= 0 {
+		writeError(w, "node is required", http.StatusBadRequest)
+		return
+	}
+	hours, ok := getQueryInt(r, "hours")
+	if !ok || hours <= 0 {
+		hours = 6
+	}
+	step, ok := getQueryInt(r, "step")
+	if !ok || step <= 0 {
+		step = 300
+	}
+	threshold := float64(defaultSaturatedPct)
+	if raw := r.URL.Query().Get("threshold"); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v <= 0 || v > 100 {
+			writeError(w, "threshold must be a percentage", http.StatusBadRequest)
+			return
+		}
+		threshold = v
+	}
+
+	resp := NoisyNeighborsResponse{NodeID: nodeID, Step: step, ThresholdPct: threshold, Pods: []NoisyNeighbor{}}
+	rows, err := s.sqlite.Query(`SELECT name, cpu_allocatable_m FROM nodes WHERE id = ?`, nodeID)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var allocatable *int64
+	found := rows.Next() && rows.Scan(&resp.Node, &allocatable) == nil
+	rows.Close()
+	if !found {
+		writeError(w, "Node not found", http.StatusNotFound)
+		return
+	}
+	if allocatable == nil || *allocatable <= 0 {
+		writeError(w, "Node has no allocatable CPU recorded", http.StatusConflict)
+		return
+	}
+	resp.AllocatableM = *allocatable
+
+	to := s.now(r)
+	from := to.Add(-time.Duration(hours) * time.Hour)
+	resp.From, resp.To = from.Unix(), to.Unix()
+	b := store.Bucketing{Step: time.Duration(step) * time.Second}
+	nodeCPU, err := s.duck.QueryNodeTotals(r.Context(), nodeID, rollup.CPUMillicores, from, to, b)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	saturated := make(map[int64]bool, len(nodeCPU)) // by bucket start
+	var saturatedTotal, baselineTotal float64
+	for _, p := range nodeCPU {
+		if p.Value >= float64(resp.AllocatableM)*threshold/100 {
+			saturated[p.Time.Unix()] = true
+			saturatedTotal += p.Value
+			resp.SaturatedBuckets++
+		} else {
+			saturated[p.Time.Unix()] = false
+			baselineTotal += p.Value
+		}
+	}
+	resp.Buckets = len(nodeCPU)
+	if resp.SaturatedBuckets == 0 {
+		writeJSON(w, resp)
+		return
+	}
+
+	pods, err := s.nodePods(nodeID)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ids := make([]int64, 0, len(pods))
+	for id := range pods {
+		ids = append(ids, id)
+	}
+	usage, err := s.duck.QueryBucketedForResources(r.Context(), "pod", "cpu_ms", ids, from, to, b, "rate")
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	type podUsage struct{ saturated, baseline float64 }
+	byPod := make(map[int64]*podUsage)
+	for _, p := range usage {
+		sat, ok := saturated[p.Time.Unix()]
+		if !ok {
+			continue // no rollup for the bucket
+		}
+		u := byPod[p.ResourceID]
+		if u == nil {
+			u = &podUsage{}
+			byPod[p.ResourceID] = u
+		}
+		if sat {
+			u.saturated += p.Value
+		} else {
+			u.baseline += p.Value
+		}
+	}
+
+	round := func(v float64) float64 { return math.Round(v*10) / 10 }
+	for id, u := range byPod {
+		n := pods[id]
+		n.AvgUsageM = round(u.saturated / float64(resp.SaturatedBuckets))
+		if saturatedTotal > 0 {
+			n.SharePct = round(u.saturated / saturatedTotal * 100)
+		}
+		if baselineTotal > 0 {
+			share := round(u.baseline / baselineTotal * 100)
+			n.BaselineSharePct = &share
+		}
+		resp.Pods = append(resp.Pods, n)
+	}
+	sort.Slice(resp.Pods, func(i, j int) bool {
+		if resp.Pods[i].SharePct != resp.Pods[j].SharePct {
+			return resp.Pods[i].SharePct > resp.Pods[j].SharePct
+		}
+		return resp.Pods[i].PodID < resp.Pods[j].PodID
+	})
+	writeJSON(w, resp)
+}
+
+// nodePods returns the pods placed on a node
+func (s *Server) nodePods(nodeID int64) (map[int64]NoisyNeighbor, error) {
+	rows, err := s.sqlite.Query(`SELECT p.id, p.name, ns.name FROM pods p
+		JOIN namespaces ns ON p.namespace_id = ns.id
+		WHERE p.node_id = ?`, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]NoisyNeighbor)
+	for rows.Next() {
+		var p NoisyNeighbor
+		if err := rows.Scan(&p.PodID, &p.Pod, &p.Namespace); err != nil {
+			continue
+		}
+		out[p.PodID] = p
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,101 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The pods of a node are ranked by their share of its CPU while it ran
+// above the threshold, against their usual share
+func TestNoisyNeighbors(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		if _, err := env.Client.CoreV1().Nodes().Create(ctx, synctest.Node("noisy-node", "4", "8Gi"), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		for _, name := range []string{"hog", "quiet"} {
+			if _, err := env.Client.CoreV1().Pods("neighbors").Create(ctx, synctest.Pod("neighbors", name, "noisy-node", nil), metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		if err := env.Eventually(synctest.Timeout, "pods placed", func() (bool, error) {
+			n, err := env.QueryInt(`SELECT count(*) FROM pods p JOIN nodes n ON n.id = p.node_id
+			WHERE n.name = 'noisy-node' AND n.cpu_allocatable_m = 4000`)
+			return n == 2, err
+		}); err != nil {
+			return err
+		}
+		nodeID, err := env.QueryInt("SELECT id FROM nodes WHERE name = 'noisy-node'")
+		if err != nil {
+			return err
+		}
+		podID := func(name string) (int64, error) {
+			return env.QueryInt("SELECT p.id FROM pods p JOIN nodes n ON n.id = p.node_id WHERE n.name = 'noisy-node' AND p.name = ?", name)
+		}
+		hog, err := podID("hog")
+		if err != nil {
+			return err
+		}
+		quiet, err := podID("quiet")
+		if err != nil {
+			return err
+		}
+
+		// The node runs at 90% in one five-minute bucket and at 25% in another
+		busy := env.Clock.Now().Truncate(5 * time.Minute).Add(-30 * time.Minute)
+		calm := busy.Add(10 * time.Minute)
+		if err := env.Duck.InsertNodeTotals([]store.NodeTotal{
+			{Time: busy.Add(time.Minute), NodeID: nodeID, MetricType: "cpu_millicores", Value: 3600},
+			{Time: calm.Add(time.Minute), NodeID: nodeID, MetricType: "cpu_millicores", Value: 1000},
+		}); err != nil {
+			return err
+		}
+		// cpu_ms counters two minutes apart at the given millicores
+		var points []store.MetricPoint
+		for _, u := range []struct {
+			pod        int64
+			bucket     time.Time
+			millicores float64
+		}{
+			{hog, busy, 2700}, {quiet, busy, 900},
+			{hog, calm, 200}, {quiet, calm, 800},
+		} {
+			points = append(points,
+				store.MetricPoint{Time: u.bucket.Add(time.Minute), ResourceID: u.pod, MetricType: "cpu_ms", Value: 1e6},
+				store.MetricPoint{Time: u.bucket.Add(3 * time.Minute), ResourceID: u.pod, MetricType: "cpu_ms", Value: 1e6 + u.millicores*120},
+			)
+		}
+		if err := env.Duck.BatchInsert(points); err != nil {
+			return err
+		}
+
+		var resp api.NoisyNeighborsResponse
+		if err := env.GetJSON(fmt.Sprintf("/api/v1/analysis/noisy-neighbors?node=%d", nodeID), &resp); err != nil {
+			return err
+		}
+		if resp.Node != "noisy-node" || resp.AllocatableM != 4000 || resp.Buckets != 2 || resp.SaturatedBuckets != 1 || len(resp.Pods) != 2 {
+			return fmt.Errorf("noisy neighbors = %+v", resp)
+		}
+		top, other := resp.Pods[0], resp.Pods[1]
+		if top.Pod != "hog" || top.AvgUsageM != 2700 || top.SharePct != 75 || top.BaselineSharePct == nil || *top.BaselineSharePct != 20 {
+			return fmt.Errorf("hog = %+v", top)
+		}
+		if other.Pod != "quiet" || other.SharePct != 25 || other.BaselineSharePct == nil || *other.BaselineSharePct != 80 {
+			return fmt.Errorf("quiet = %+v", other)
+		}
+		// At 95% the node was never saturated
+		if err := env.GetJSON(fmt.Sprintf("/api/v1/analysis/noisy-neighbors?node=%d&threshold=95", nodeID), &resp); err != nil {
+			return err
+		}
+		if resp.SaturatedBuckets != 0 || len(resp.Pods) != 0 {
+			return fmt.Errorf("noisy neighbors above 95%% = %+v", resp)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,391 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// oidcLeeway tolerates clock skew with the identity provider
+	oidcLeeway = time.Minute
+	// oidcKeysTTL is how long fetched signing keys are used before a refetch
+	oidcKeysTTL = time.Hour
+	// oidcMinRefresh spaces refetches triggered by unknown key ids, so
+	// forged tokens cannot hammer the provider
+	oidcMinRefresh = time.Minute
+)
+
+// OIDCConfig validates ID tokens from an OpenID Connect provider, sent as
+// "Authorization: Bearer <id token>"
+type OIDCConfig struct {
+	IssuerURL     string
+	Audience      string // the client id tokens must be issued for
+	UsernameClaim string // default "email", falling back to "sub"
+	GroupsClaim   string // default "groups"
+}
+
+// OIDC admits requests bearing a valid ID token. Signing keys come from the
+// provider's discovery document and are fetched on first use, so the
+// provider need not be reachable at startup.
+type OIDC struct {
+	cfg    OIDCConfig
+	client *http.Client
+	clock  clock.Clock
+
+	fetches   singleflight.Group
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey // kid -> key
+	fetchedAt time.Time
+}
+
+func NewOIDC(cfg OIDCConfig) (*OIDC, error) {
+	cfg.IssuerURL = strings.TrimSuffix(cfg.IssuerURL, "/")
+	if cfg.IssuerURL == "" || cfg.Audience == "" {
+		return nil, errors.New("OIDC authentication needs an issuer URL and an audience")
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "email"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	return &OIDC{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}, clock: clock.Real}, nil
+}
+
+// SetClock replaces the clock token expiry is checked against
+func (a *OIDC) SetClock(c clock.Clock) {
+	a.clock = c
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (a *OIDC) ValidateRequest(r *http.Request) (*Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrUnauthenticated)
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed token header", ErrUnauthenticated)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrUnauthenticated)
+	}
+
+	keys, err := a.signingKeys(r.Context(), header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range keys {
+		if verifyJWT(header.Alg, key, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%w: bad signature", ErrUnauthenticated)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrUnauthenticated)
+	}
+	if err := a.checkClaims(claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnauthenticated, err)
+	}
+
+	p := &Principal{Method: AuthOIDC}
+	p.Name, _ = claims[a.cfg.UsernameClaim].(string)
+	if p.Name == "" {
+		p.Name, _ = claims["sub"].(string)
+	}
+	if groups, ok := claims[a.cfg.GroupsClaim].([]interface{}); ok {
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				p.Groups = append(p.Groups, s)
+			}
+		}
+	}
+	return p, nil
+}
+
+func (a *OIDC) checkClaims(claims map[string]interface{}) error {
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != a.cfg.IssuerURL {
+		return fmt.Errorf("issuer %q not trusted", iss)
+	}
+	audOK := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audOK = aud == a.cfg.Audience
+	case []interface{}:
+		for _, v := range aud {
+			audOK = audOK || v == a.cfg.Audience
+		}
+	}
+	if !audOK {
+		return errors.New("token not issued for this audience")
+	}
+	now := a.clock.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(oidcLeeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not valid yet")
+	}
+	return nil
+}
+
+// signingKeys returns the key with kid, or every key when kid is empty
+func (a *OIDC) signingKeys(ctx context.Context, kid string) ([]crypto.PublicKey, error) {
+	keys, err := a.keySet(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+	if kid != "" {
+		if key, ok := keys[kid]; ok {
+			return []crypto.PublicKey{key}, nil
+		}
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrUnauthenticated, kid)
+	}
+	out := make([]crypto.PublicKey, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, key)
+	}
+	return out, nil
+}
+
+// keySet returns the provider's keys, refetching them when they are stale
+// or kid is unknown. Requests arriving during a fetch wait for that one
+// instead of starting their own, and none holds mu while it runs.
+func (a *OIDC) keySet(ctx context.Context, kid string) (map[string]crypto.PublicKey, error) {
+	a.mu.Lock()
+	now := a.clock.Now()
+	keys := a.keys
+	_, known := keys[kid]
+	due := keys == nil || now.Sub(a.fetchedAt) > oidcKeysTTL || (kid != "" && !known)
+	a.mu.Unlock()
+	if !due {
+		return keys, nil
+	}
+
+	_, err, _ := a.fetches.Do("keys", func() (interface{}, error) {
+		a.mu.Lock()
+		now := a.clock.Now()
+		if !a.fetchedAt.IsZero() && now.Sub(a.fetchedAt) <= oidcMinRefresh {
+			a.mu.Unlock()
+			return nil, nil
+		}
+		a.fetchedAt = now
+		a.mu.Unlock()
+
+		// Shared by every waiting request, so none of them cancels it
+		keys, err := a.fetchKeys(context.WithoutCancel(ctx))
+		if err != nil {
+			log.Printf("Fetching OIDC keys failed: %v", err)
+			return nil, err
+		}
+		a.mu.Lock()
+		a.keys = keys
+		a.mu.Unlock()
+		return nil, nil
+	})
+
+	// On errors the old keys stay in use while the provider is unreachable
+	a.mu.Lock()
+	keys = a.keys
+	a.mu.Unlock()
+	if keys == nil {
+		if err == nil {
+			err = errors.New("retrying shortly")
+		}
+		return nil, fmt.Errorf("%w: OIDC keys unavailable: %v", ErrUnauthenticated, err)
+	}
+	return keys, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys reads the discovery document and the JWKS it points to
+func (a *OIDC) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := a.getJSON(ctx, a.cfg.IssuerURL+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("OIDC discovery: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != a.cfg.IssuerURL {
+		return nil, fmt.Errorf("OIDC discovery: issuer %q does not match %q", discovery.Issuer, a.cfg.IssuerURL)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := a.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("OIDC keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("OIDC keys: no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (a *OIDC) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifyJWT checks sig over signed for the RSA and ECDSA algorithms
+// providers use; HMAC and "none" are never accepted
+func verifyJWT(alg string, key crypto.PublicKey, signed, sig []byte) bool {
+	if len(alg) != 5 {
+		return false
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return false
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, hash, digest, sig) == nil
+		case "PS":
+			return rsa.VerifyPSS(k, hash, digest, sig, nil) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" || len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	}
+	return false
+}
+
+func decodeSegment(seg string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+)
+
+// fakeIDP is an OpenID provider serving one RSA key, with a hook run
+// before every key fetch
+type fakeIDP struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	fetches atomic.Int32
+	onKeys  func()
+}
+
+func newFakeIDP(t *testing.T) *fakeIDP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIDP{key: key, onKeys: func() {}}
+	b64 := base64.RawURLEncoding.EncodeToString
+	mux := http.NewServeMux()
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": idp.URL, "jwks_uri": idp.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		idp.fetches.Add(1)
+		idp.onKeys()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "use": "sig",
+			"n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	return idp
+}
+
+// token signs an ID token for audience "vita" under kid
+func (idp *fakeIDP) token(t *testing.T, kid string) string {
+	t.Helper()
+	return idp.sign(t, kid, map[string]interface{}{
+		"iss": idp.URL, "aud": "vita", "sub": "u-1", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+}
+
+// sign signs claims under kid
+func (idp *fakeIDP) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	b64 := base64.RawURLEncoding.EncodeToString
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(sig)
+}
+
+func bearer(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/pods", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+// Requests arriving while the keys are fetched share that fetch
+func TestOIDCSharesKeyFetch(t *testing.T) {
+	idp := newFakeIDP(t)
+	release := make(chan struct{})
+	idp.onKeys = func() { <-release }
+	oidc, err := NewOIDC(OIDCConfig{IssuerURL: idp.URL, Audience: "vita"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := idp.token(t, "k1")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := oidc.ValidateRequest(bearer(token))
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond) // let them all reach the fetch
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("valid token rejected: %v", err)
+		}
+	}
+	if n := idp.fetches.Load(); n != 1 {
+		t.Fatalf("keys fetched %d times, want 1", n)
+	}
+}
+
+// A refetch for an unknown key id does not hold up tokens signed with a
+// known one
+func TestOIDCKnownKeyDoesNotWaitForRefetch(t *testing.T) {
+	idp := newFakeIDP(t)
+	clk := clock.NewFake(time.Now())
+	oidc, err := NewOIDC(OIDCConfig{IssuerURL: idp.URL, Audience: "vita"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oidc.SetClock(clk)
+	if _, err := oidc.ValidateRequest(bearer(idp.token(t, "k1"))); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	idp.onKeys = func() { <-release }
+	clk.Advance(2 * oidcMinRefresh)
+	go oidc.ValidateRequest(bearer(idp.token(t, "rotated")))
+	time.Sleep(50 * time.Millisecond) // let it block in the fetch
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := oidc.ValidateRequest(bearer(idp.token(t, "k1")))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("valid token rejected: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("known key waited for the refetch")
+	}
+}
+
+// Tokens that cannot be checked because the provider is down are refused
+// with 401, not reported as a server error
+func TestOIDCUnreachableProviderIsUnauthorized(t *testing.T) {
+	idp := newFakeIDP(t)
+	token := idp.token(t, "k1")
+	oidc, err := NewOIDC(OIDCConfig{IssuerURL: idp.URL, Audience: "vita"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.Close()
+
+	rec := httptest.NewRecorder()
+	Authenticate(oidc, http.NotFoundHandler()).ServeHTTP(rec, bearer(token))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/units"
+)
+
+// defaultMaxPoints bounds the points of one series response unless
+// SetMaxPoints changes it
+const defaultMaxPoints = 10000
+
+// niceSteps are the step widths (seconds) a series is widened to; past a
+// day, steps are whole days
+var niceSteps = []int64{1, 5, 10, 15, 30, 60, 120, 300, 600, 900, 1800, 3600, 7200, 10800, 21600, 43200, 86400}
+
+// SetMaxPoints sets how many points a series response may hold. Larger
+// results are widened to a coarser step, or paged when full resolution is
+// requested.
+func (s *Server) SetMaxPoints(n int) {
+	s.maxPoints = n
+}
+
+// pointLimit returns the cap for a query: the server's, lowered by the
+// query's own max_points
+func (s *Server) pointLimit(sq SeriesQuery) int {
+	limit := s.maxPoints
+	if limit <= 0 {
+		limit = defaultMaxPoints
+	}
+	if sq.MaxPoints > 0 && sq.MaxPoints < limit {
+		limit = sq.MaxPoints
+	}
+	return limit
+}
+
+// widenStep returns the smallest nice step that covers span in at most
+// limit buckets. Steps of zoned queries stay whole days.
+func widenStep(span time.Duration, limit int, zoned bool) int64 {
+	need := int64(span/time.Second+time.Duration(limit)-1) / int64(limit)
+	if zoned && need < 86400 {
+		need = 86400
+	}
+	for _, step := range niceSteps {
+		if step >= need && (!zoned || step%86400 == 0) {
+			return step
+		}
+	}
+	return (need + 86399) / 86400 * 86400
+}
+
+// fitStep returns the step a query runs at so it returns at most limit
+// points, and whether it had to be widened. Raw queries are counted first.
+func (s *Server) fitStep(ctx context.Context, sq SeriesQuery, ids []int64, from, to time.Time, limit int) (int64, bool, error) {
+	span := to.Sub(from)
+	// Bucket boundaries rarely line up with the range, which adds a bucket
+	buckets := max(limit-1, 1)
+	if sq.Step > 0 {
+		if int64(span/time.Second)/sq.Step < int64(buckets) {
+			return sq.Step, false, nil
+		}
+		if step := widenStep(span, buckets, sq.TZ != ""); step > sq.Step {
+			return step, true, nil
+		}
+		return sq.Step, false, nil
+	}
+
+	var count int64
+	for _, id := range ids {
+		n, err := s.duck.CountSeries(ctx, id, sq.Type, from, to)
+		if err != nil {
+			return 0, false, err
+		}
+		count += n
+	}
+	if count <= int64(limit) {
+		return 0, false, nil
+	}
+	return widenStep(span, buckets, sq.TZ != ""), true, nil
+}
+
+// seriesCursor is where the next page of a full-resolution query starts.
+// It carries the end of the range so every page covers the same one even
+// when to defaults to now.
+type seriesCursor struct {
+	After time.Time // last point already returned
+	To    time.Time
+}
+
+func (c seriesCursor) encode() string {
+	raw := fmt.Sprintf("%d:%d", c.After.UnixMicro(), c.To.UnixMicro())
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(token string) (seriesCursor, error) {
+	bad := badQueryError{fmt.Errorf("cursor must be a next token returned by a previous call")}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return seriesCursor{}, bad
+	}
+	after, to, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return seriesCursor{}, bad
+	}
+	a, err1 := strconv.ParseInt(after, 10, 64)
+	t, err2 := strconv.ParseInt(to, 10, 64)
+	if err1 != nil || err2 != nil || a >= t {
+		return seriesCursor{}, bad
+	}
+	return seriesCursor{After: time.UnixMicro(a).UTC(), To: time.UnixMicro(t).UTC()}, nil
+}
+
+// seriesPage returns up to limit raw points after the cursor, converted,
+// and the token of the next page if more remain. The point the cursor
+// names is fetched again as the base of rate conversions.
+func (s *Server) seriesPage(ctx context.Context, sq SeriesQuery, ids []int64, from, to time.Time, cursor *seriesCursor, conv *units.Converter, limit int) ([]SeriesPoint, string, error) {
+	if cursor != nil {
+		from, to = cursor.After, cursor.To
+	}
+	points, err := s.stitchedSeries(ids, func(id int64) ([]store.MetricPoint, error) {
+		return s.duck.QuerySeriesPage(ctx, sq.kind(), id, sq.Type, from, to, limit+2)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	start := 0
+	if cursor != nil {
+		for start < len(points) && !points[start].Time.After(cursor.After) {
+			start++
+		}
+	}
+	end := min(start+limit, len(points))
+	base := start
+	if conv.Rate && start > 0 {
+		base = start - 1
+	}
+	next := ""
+	if end < len(points) {
+		next = seriesCursor{After: points[end-1].Time, To: to}.encode()
+	}
+	return convertPoints(points[base:end], conv), next, nil
+}
@@ -0,0 +1,92 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+)
+
+// TestSeriesPaging widens a raw query past the point limit to a coarser
+// step, and pages it at full resolution through next cursors
+func TestSeriesPaging(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		const podID = 4242
+		start := time.Now().Add(-2 * time.Hour).Truncate(time.Hour)
+		var points []store.MetricPoint
+		for i := 0; i < 1000; i++ {
+			points = append(points,
+				store.MetricPoint{Time: start.Add(time.Duration(i) * time.Second), ResourceID: podID, MetricType: "mem_mb", Value: float64(i)},
+				store.MetricPoint{Time: start.Add(time.Duration(i) * time.Second), ResourceID: podID, MetricType: "cpu_ms", Value: float64(i * 500)})
+		}
+		if err := env.Duck.BatchInsert(points); err != nil {
+			return err
+		}
+		base := fmt.Sprintf("/api/v1/metrics/series?resource=%d&from=%d&to=%d&max_points=100", podID, start.Unix(), start.Add(1000*time.Second).Unix())
+
+		var widened api.SeriesResponse
+		if err := env.GetJSON(base+"&type=mem_mb", &widened); err != nil {
+			return err
+		}
+		if !widened.Widened || widened.Step != 15 || len(widened.Points) > 100 || len(widened.Points) < 60 {
+			return fmt.Errorf("widened: step %d, %d points (widened %v), want step 15 within 100 points", widened.Step, len(widened.Points), widened.Widened)
+		}
+		var narrow api.SeriesResponse
+		if err := env.GetJSON(base+"&type=mem_mb&step=60", &narrow); err != nil {
+			return err
+		}
+		if narrow.Widened || narrow.Step != 60 {
+			return fmt.Errorf("step 60 within the limit came back as step %d (widened %v)", narrow.Step, narrow.Widened)
+		}
+
+		// Full resolution, including a rate conversion across page boundaries
+		for _, tc := range []struct {
+			query string
+			want  int
+			value func(i int) float64
+		}{
+			{"&type=mem_mb", 1000, func(i int) float64 { return float64(i) }},
+			{"&type=cpu_ms&unit=cores", 999, func(int) float64 { return 0.5 }},
+		} {
+			var got []api.SeriesPoint
+			pages := 0
+			cursor := ""
+			for {
+				var page api.SeriesResponse
+				path := base + tc.query + "&full=true"
+				if cursor != "" {
+					path += "&cursor=" + cursor
+				}
+				if err := env.GetJSON(path, &page); err != nil {
+					return err
+				}
+				if len(page.Points) > 100 || page.Widened {
+					return fmt.Errorf("%s page %d: %d points (widened %v)", tc.query, pages, len(page.Points), page.Widened)
+				}
+				got = append(got, page.Points...)
+				pages++
+				if cursor = page.Next; cursor == "" || pages > 20 {
+					break
+				}
+			}
+			if len(got) != tc.want || pages != 10 {
+				return fmt.Errorf("%s: %d points over %d pages, want %d over 10", tc.query, len(got), pages, tc.want)
+			}
+			offset := 1000 - tc.want
+			for i, p := range got {
+				if p.T != start.Unix()+int64(i+offset) || p.V != tc.value(i+offset) {
+					return fmt.Errorf("%s point %d = %+v", tc.query, i, p)
+				}
+			}
+		}
+
+		if err := env.GetJSON(base+"&type=mem_mb&cursor=bogus", nil); err == nil {
+			return fmt.Errorf("bogus cursor accepted")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+)
+
+// PDB is a PodDisruptionBudget with the workload it protects
+type PDB struct {
+	ID                 int64   `json:"id"`
+	Name               string  `json:"name"`
+	UID                string  `json:"uid"`
+	NamespaceID        int64   `json:"namespace_id"`
+	Namespace          string  `json:"namespace"`
+	MinAvailable       *string `json:"min_available,omitempty"`
+	MaxUnavailable     *string `json:"max_unavailable,omitempty"`
+	DisruptionsAllowed int     `json:"disruptions_allowed"`
+	CurrentHealthy     int     `json:"current_healthy"`
+	DesiredHealthy     int     `json:"desired_healthy"`
+	ExpectedPods       int     `json:"expected_pods"`
+	WorkloadKind       *string `json:"workload_kind,omitempty"`
+	WorkloadID         *int64  `json:"workload_id,omitempty"`
+	Workload           *string `json:"workload,omitempty"`
+	// AtRisk is true when evicting any further pod would violate the budget
+	AtRisk bool `json:"at_risk"`
+}
+
+func (s *Server) handleListPDBs(w http.ResponseWriter, r *http.Request) {
+	serveList(s, w, r, "pdb", func(id int64) ([]PDB, error) { return s.queryPDBs(r, id) })
+}
+
+// queryPDBs lists budgets with at-risk ones first. ?at_risk=true keeps only those.
+func (s *Server) queryPDBs(r *http.Request, id int64) ([]PDB, error) {
+	query := `
+		SELECT b.id, b.name, b.uid, b.namespace_id, ns.name, b.min_available, b.max_unavailable,
+			b.disruptions_allowed, b.current_healthy, b.desired_healthy, b.expected_pods,
+			CASE WHEN b.deployment_id IS NOT NULL THEN 'deployment'
+			     WHEN b.statefulset_id IS NOT NULL THEN 'statefulset' END,
+			COALESCE(b.deployment_id, b.statefulset_id),
+			COALESCE(d.name, sts.name)
+		FROM pdbs b
+		JOIN namespaces ns ON b.namespace_id = ns.id
+		LEFT JOIN deployments d ON b.deployment_id = d.id
+		LEFT JOIN statefulsets sts ON b.statefulset_id = sts.id
+		WHERE 1=1
+	`
+	args := []interface{}{}
+
+	if nsID, ok := getQueryInt(r, "namespace"); ok {
+		query += " AND b.namespace_id = ?"
+		args = append(args, nsID)
+	}
+	if r.URL.Query().Get("at_risk") == "true" {
+		query += " AND b.disruptions_allowed = 0 AND b.expected_pods > 0"
+	}
+	if id > 0 {
+		query += " AND b.id = ?"
+		args = append(args, id)
+	}
+
+	query += " ORDER BY (b.disruptions_allowed = 0 AND b.expected_pods > 0) DESC, ns.name, b.name"
+
+	rows, err := s.sqlite.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pdbs := []PDB{}
+	for rows.Next() {
+		var b PDB
+		var kind, workload sql.NullString
+		var workloadID sql.NullInt64
+		if err := rows.Scan(&b.ID, &b.Name, &b.UID, &b.NamespaceID, &b.Namespace, &b.MinAvailable, &b.MaxUnavailable,
+			&b.DisruptionsAllowed, &b.CurrentHealthy, &b.DesiredHealthy, &b.ExpectedPods,
+			&kind, &workloadID, &workload); err != nil {
+			continue
+		}
+		if kind.Valid {
+			b.WorkloadKind = &kind.String
+		}
+		if workloadID.Valid {
+			b.WorkloadID = &workloadID.Int64
+		}
+		if workload.Valid {
+			b.Workload = &workload.String
+		}
+		b.AtRisk = b.DisruptionsAllowed == 0 && b.ExpectedPods > 0
+		pdbs = append(pdbs, b)
+	}
+	return pdbs, nil
+}
@@ -0,0 +1,438 @@
+package api
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Placement statuses, in increasing severity
+const (
+	placementOK       = "ok"
+	placementAtRisk   = "at_risk"
+	placementViolated = "violated"
+)
+
+var placementSeverity = map[string]int{placementOK: 0, placementAtRisk: 1, placementViolated: 2}
+
+// Topology labels the replica heuristics use
+const (
+	hostnameLabel   = "kubernetes.io/hostname"
+	zoneLabel       = "topology.kubernetes.io/zone"
+	legacyZoneLabel = "failure-domain.beta.kubernetes.io/zone"
+)
+
+// WorkloadPlacement is where a workload's pods run, checked against its
+// spread constraints and (anti-)affinity terms
+type WorkloadPlacement struct {
+	Kind        string                `json:"kind"` // deployment or statefulset
+	ID          int64                 `json:"id"`
+	Name        string                `json:"name"`
+	NamespaceID int64                 `json:"namespace_id"`
+	Namespace   string                `json:"namespace"`
+	Pods        int                   `json:"pods"`
+	Nodes       map[string]int        `json:"nodes"`           // pods per node
+	Zones       map[string]int        `json:"zones,omitempty"` // pods per zone, when nodes are labelled
+	Status      string                `json:"status"`          // worst of Constraints and Issues
+	Constraints []PlacementConstraint `json:"constraints"`
+	Issues      []PlacementIssue      `json:"issues"`
+}
+
+// PlacementConstraint is one rule of the workload's newest pod and how the
+// current placement measures up
+type PlacementConstraint struct {
+	Type              string `json:"type"` // spread, affinity or anti_affinity
+	TopologyKey       string `json:"topology_key"`
+	Selector          string `json:"selector"`
+	Required          bool   `json:"required"` // DoNotSchedule, or requiredDuringScheduling
+	MaxSkew           int32  `json:"max_skew,omitempty"`
+	Skew              int    `json:"skew,omitempty"`
+	WhenUnsatisfiable string `json:"when_unsatisfiable,omitempty"`
+	// Domains counts the pods the selector matches per topology value;
+	// spread constraints include empty domains
+	Domains map[string]int `json:"domains"`
+	Status  string         `json:"status"`
+	Detail  string         `json:"detail,omitempty"`
+}
+
+// PlacementIssue is a placement risk found without a rule saying so, e.g.
+// every replica on one node
+type PlacementIssue struct {
+	Type   string `json:"type"` // single_node or single_zone
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// placementPod is a live pod with what placement needs of it
+type placementPod struct {
+	id          int64
+	name        string
+	namespaceID int64
+	namespace   string
+	nodeID      int64
+	labels      labels.Set
+	rules       store.PlacementRules
+	kind        string
+	workloadID  int64
+	workload    string
+}
+
+// placementNode is a live node's name and labels
+type placementNode struct {
+	name   string
+	labels map[string]string
+}
+
+// handlePlacement serves /api/v1/analysis/placement[?namespace=&kind=&id=&status=].
+// Deployments and StatefulSets are checked against the topology spread
+// constraints and pod (anti-)affinity terms of their newest pod, using the
+// live pods and node labels in the catalog. Spread domains are the nodes
+// carrying the topology key, without regard to node affinity or taints.
+// Workloads with every replica on one node, or in one zone of several,
+// are at risk whatever their rules. Violated workloads come first.
+func (s *Server) handlePlacement(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	status := q.Get("status")
+	if _, ok := placementSeverity[status]; status != "" && !ok {
+		writeError(w, "status must be ok, at_risk or violated", http.StatusBadRequest)
+		return
+	}
+	kind := q.Get("kind")
+	if kind != "" && kind != "deployment" && kind != "statefulset" {
+		writeError(w, "kind must be deployment or statefulset", http.StatusBadRequest)
+		return
+	}
+
+	nodes, err := s.placementNodes()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pods, err := s.placementPods()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type workloadKey struct {
+		kind string
+		id   int64
+	}
+	byWorkload := make(map[workloadKey][]*placementPod)
+	var keys []workloadKey
+	nsID, filterNS := getQueryInt(r, "namespace")
+	id, filterID := getQueryInt(r, "id")
+	for _, p := range pods {
+		if p.kind == "" || (filterNS && p.namespaceID != nsID) || (kind != "" && p.kind != kind) || (filterID && p.workloadID != id) {
+			continue
+		}
+		k := workloadKey{p.kind, p.workloadID}
+		if _, ok := byWorkload[k]; !ok {
+			keys = append(keys, k)
+		}
+		byWorkload[k] = append(byWorkload[k], p)
+	}
+
+	out := []WorkloadPlacement{}
+	for _, k := range keys {
+		wp := evaluatePlacement(byWorkload[k], pods, nodes)
+		if status == "" || wp.Status == status {
+			out = append(out, wp)
+		}
+	}
+	slices.SortFunc(out, func(a, b WorkloadPlacement) int {
+		return cmp.Or(
+			placementSeverity[b.Status]-placementSeverity[a.Status],
+			strings.Compare(a.Namespace, b.Namespace),
+			strings.Compare(a.Name, b.Name),
+			strings.Compare(a.Kind, b.Kind),
+		)
+	})
+	writeJSON(w, out)
+}
+
+func (s *Server) placementNodes() (map[int64]placementNode, error) {
+	rows, err := s.sqlite.Query(`SELECT id, name, labels FROM nodes WHERE deleted_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[int64]placementNode)
+	for rows.Next() {
+		var id int64
+		var n placementNode
+		var encoded sql.NullString
+		if err := rows.Scan(&id, &n.name, &encoded); err != nil {
+			return nil, err
+		}
+		n.labels = make(map[string]string)
+		if encoded.Valid {
+			json.Unmarshal([]byte(encoded.String), &n.labels)
+		}
+		// Nodes registered without it still have a hostname
+		if _, ok := n.labels[hostnameLabel]; !ok {
+			n.labels[hostnameLabel] = n.name
+		}
+		out[id] = n
+	}
+	return out, rows.Err()
+}
+
+// placementPods returns the pods running or about to on live nodes,
+// oldest first
+func (s *Server) placementPods() ([]*placementPod, error) {
+	rows, err := s.sqlite.Query(`
+		SELECT p.id, p.name, p.namespace_id, ns.name, p.node_id, p.labels, p.placement,
+			CASE WHEN p.deployment_id IS NOT NULL THEN 'deployment'
+			     WHEN p.statefulset_id IS NOT NULL THEN 'statefulset' ELSE '' END,
+			COALESCE(p.deployment_id, p.statefulset_id, 0),
+			COALESCE(d.name, sts.name, '')
+		FROM pods p
+		JOIN namespaces ns ON p.namespace_id = ns.id
+		JOIN nodes n ON p.node_id = n.id AND n.deleted_at IS NULL
+		LEFT JOIN deployments d ON p.deployment_id = d.id
+		LEFT JOIN statefulsets sts ON p.statefulset_id = sts.id
+		WHERE p.deleted_at IS NULL AND COALESCE(p.phase, '') NOT IN ('Succeeded', 'Failed')
+		ORDER BY p.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*placementPod
+	for rows.Next() {
+		p := &placementPod{}
+		var encodedLabels, encodedRules sql.NullString
+		if err := rows.Scan(&p.id, &p.name, &p.namespaceID, &p.namespace, &p.nodeID, &encodedLabels, &encodedRules,
+			&p.kind, &p.workloadID, &p.workload); err != nil {
+			return nil, err
+		}
+		if encodedLabels.Valid {
+			json.Unmarshal([]byte(encodedLabels.String), &p.labels)
+		}
+		if encodedRules.Valid {
+			json.Unmarshal([]byte(encodedRules.String), &p.rules)
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// evaluatePlacement checks one workload's pods against the rules of its
+// newest pod
+func evaluatePlacement(own, all []*placementPod, nodes map[int64]placementNode) WorkloadPlacement {
+	newest := own[len(own)-1]
+	wp := WorkloadPlacement{
+		Kind:        newest.kind,
+		ID:          newest.workloadID,
+		Name:        newest.workload,
+		NamespaceID: newest.namespaceID,
+		Namespace:   newest.namespace,
+		Pods:        len(own),
+		Nodes:       make(map[string]int),
+		Status:      placementOK,
+		Constraints: []PlacementConstraint{},
+		Issues:      []PlacementIssue{},
+	}
+	zones := make(map[string]bool)
+	for _, n := range nodes {
+		if z := nodeZone(n); z != "" {
+			zones[z] = true
+		}
+	}
+	for _, p := range own {
+		n := nodes[p.nodeID]
+		wp.Nodes[n.name]++
+		if z := nodeZone(n); z != "" {
+			if wp.Zones == nil {
+				wp.Zones = make(map[string]int)
+			}
+			wp.Zones[z]++
+		}
+	}
+
+	for _, sc := range newest.rules.Spread {
+		wp.Constraints = append(wp.Constraints, evaluateSpread(sc, newest, all, nodes))
+	}
+	for _, term := range newest.rules.Affinity {
+		wp.Constraints = append(wp.Constraints, evaluateAffinity(term, own, all, nodes))
+	}
+
+	// One node is one zone too; only the narrower issue is reported
+	switch {
+	case wp.Pods < 2:
+	case len(wp.Nodes) == 1:
+		wp.Issues = append(wp.Issues, PlacementIssue{Type: "single_node", Status: placementAtRisk,
+			Detail: fmt.Sprintf("all %d replicas run on node %s", wp.Pods, onlyKey(wp.Nodes))})
+	case len(zones) >= 2 && len(wp.Zones) == 1 && wp.Zones[onlyKey(wp.Zones)] == wp.Pods:
+		wp.Issues = append(wp.Issues, PlacementIssue{Type: "single_zone", Status: placementAtRisk,
+			Detail: fmt.Sprintf("all %d replicas run in zone %s of %d", wp.Pods, onlyKey(wp.Zones), len(zones))})
+	}
+
+	for _, c := range wp.Constraints {
+		wp.Status = worsePlacement(wp.Status, c.Status)
+	}
+	for _, issue := range wp.Issues {
+		wp.Status = worsePlacement(wp.Status, issue.Status)
+	}
+	return wp
+}
+
+// evaluateSpread counts the pods the constraint selects in the pod's
+// namespace per domain; the skew is the gap between the fullest and the
+// emptiest domain, or the fullest alone when there are fewer domains than
+// minDomains
+func evaluateSpread(sc store.SpreadConstraint, pod *placementPod, all []*placementPod, nodes map[int64]placementNode) PlacementConstraint {
+	c := PlacementConstraint{
+		Type:              "spread",
+		TopologyKey:       sc.TopologyKey,
+		Selector:          sc.Selector,
+		Required:          sc.WhenUnsatisfiable != "ScheduleAnyway",
+		MaxSkew:           sc.MaxSkew,
+		WhenUnsatisfiable: sc.WhenUnsatisfiable,
+		Domains:           make(map[string]int),
+		Status:            placementOK,
+	}
+	selector, err := labels.Parse(sc.Selector)
+	if err != nil {
+		c.Status, c.Detail = placementAtRisk, fmt.Sprintf("selector: %v", err)
+		return c
+	}
+	for _, n := range nodes {
+		if v, ok := n.labels[sc.TopologyKey]; ok {
+			c.Domains[v] += 0
+		}
+	}
+	if len(c.Domains) == 0 {
+		c.Status, c.Detail = placementAtRisk, fmt.Sprintf("no node has label %s", sc.TopologyKey)
+		return c
+	}
+	for _, p := range all {
+		if p.namespaceID != pod.namespaceID || !selector.Matches(p.labels) {
+			continue
+		}
+		if v, ok := nodes[p.nodeID].labels[sc.TopologyKey]; ok {
+			c.Domains[v]++
+		}
+	}
+	lo, hi := -1, 0
+	for _, n := range c.Domains {
+		hi = max(hi, n)
+		if lo < 0 || n < lo {
+			lo = n
+		}
+	}
+	if int(sc.MinDomains) > len(c.Domains) {
+		lo = 0
+	}
+	c.Skew = hi - lo
+	if c.Skew > int(sc.MaxSkew) {
+		c.Status = placementViolated
+		if !c.Required {
+			c.Status = placementAtRisk
+		}
+		c.Detail = fmt.Sprintf("skew %d across %d domains exceeds %d", c.Skew, len(c.Domains), sc.MaxSkew)
+	}
+	return c
+}
+
+// evaluateAffinity checks every pod of the workload against the term:
+// anti-affinity fails when another selected pod shares its domain,
+// affinity when none does (unless the pod is the only one selected, as the
+// scheduler allows for the first replica)
+func evaluateAffinity(term store.AffinityTerm, own, all []*placementPod, nodes map[int64]placementNode) PlacementConstraint {
+	c := PlacementConstraint{
+		Type:        "affinity",
+		TopologyKey: term.TopologyKey,
+		Selector:    term.Selector,
+		Required:    term.Required,
+		Domains:     make(map[string]int),
+		Status:      placementOK,
+	}
+	if term.Anti {
+		c.Type = "anti_affinity"
+	}
+	selector, err := labels.Parse(term.Selector)
+	if err != nil {
+		c.Status, c.Detail = placementAtRisk, fmt.Sprintf("selector: %v", err)
+		return c
+	}
+	namespaces := term.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{own[0].namespace}
+	}
+	var selected []*placementPod
+	for _, p := range all {
+		if slices.Contains(namespaces, p.namespace) && selector.Matches(p.labels) {
+			selected = append(selected, p)
+			if v, ok := nodes[p.nodeID].labels[term.TopologyKey]; ok {
+				c.Domains[v]++
+			}
+		}
+	}
+
+	var failing []string
+	for _, p := range own {
+		domain, ok := nodes[p.nodeID].labels[term.TopologyKey]
+		shared, others := 0, 0
+		for _, o := range selected {
+			if o.id == p.id {
+				continue
+			}
+			others++
+			if v, found := nodes[o.nodeID].labels[term.TopologyKey]; ok && found && v == domain {
+				shared++
+			}
+		}
+		switch {
+		case term.Anti && shared > 0:
+			failing = append(failing, p.name)
+		case !term.Anti && shared == 0 && (others > 0 || !selector.Matches(p.labels)):
+			failing = append(failing, p.name)
+		}
+	}
+	if len(failing) > 0 {
+		c.Status = placementViolated
+		if !term.Required {
+			c.Status = placementAtRisk
+		}
+		what := "share a domain with a selected pod"
+		if !term.Anti {
+			what = "have no selected pod in their domain"
+		}
+		slices.Sort(failing)
+		c.Detail = fmt.Sprintf("%d pods %s: %s", len(failing), what, strings.Join(failing, ", "))
+	}
+	return c
+}
+
+func nodeZone(n placementNode) string {
+	if z := n.labels[zoneLabel]; z != "" {
+		return z
+	}
+	return n.labels[legacyZoneLabel]
+}
+
+func worsePlacement(a, b string) string {
+	if placementSeverity[b] > placementSeverity[a] {
+		return b
+	}
+	return a
+}
+
+func onlyKey(m map[string]int) string {
+	for k := range m {
+		return k
+	}
+	return ""
+}
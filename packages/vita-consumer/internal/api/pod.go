@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// PodDetail is a pod with the spec fields captured for debugging: what it
+// runs, as whom, and which config it reads
+type PodDetail struct {
+	Pod
+	ServiceAccount string          `json:"service_account,omitempty"`
+	Containers     []ContainerSpec `json:"containers"`
+	ConfigRefs     []PodConfigRef  `json:"config_refs"`
+}
+
+// ContainerSpec lists env var names only; args that look like credentials
+// are redacted when captured
+type ContainerSpec struct {
+	Name            string   `json:"name"`
+	Image           string   `json:"image,omitempty"`
+	ImagePullPolicy string   `json:"image_pull_policy,omitempty"`
+	Args            []string `json:"args,omitempty"`
+	EnvNames        []string `json:"env_names,omitempty"`
+	CPURequestM     *int64   `json:"cpu_request_m,omitempty"`
+	CPULimitM       *int64   `json:"cpu_limit_m,omitempty"`
+	MemRequestMB    *float64 `json:"mem_request_mb,omitempty"`
+	MemLimitMB      *float64 `json:"mem_limit_mb,omitempty"`
+}
+
+type PodConfigRef struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Via  string `json:"via"`
+}
+
+// handlePodDetail serves GET /api/v1/pods/{id}[?fields=]
+func (s *Server) handlePodDetail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	pods, err := s.queryPods(r, id)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(pods) == 0 {
+		writeError(w, "Pod not found", http.StatusNotFound)
+		return
+	}
+
+	detail := PodDetail{Pod: pods[0], Containers: []ContainerSpec{}, ConfigRefs: []PodConfigRef{}}
+	ref, err := s.sqlite.GetPodRef(id)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	detail.ServiceAccount = ref.ServiceAccount
+	containers, err := s.sqlite.PodContainers(id)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, c := range containers {
+		detail.Containers = append(detail.Containers, ContainerSpec{
+			Name:            c.Name,
+			Image:           c.Image,
+			ImagePullPolicy: c.ImagePullPolicy,
+			Args:            c.Args,
+			EnvNames:        c.EnvNames,
+			CPURequestM:     c.CPURequestM,
+			CPULimitM:       c.CPULimitM,
+			MemRequestMB:    c.MemRequestMB,
+			MemLimitMB:      c.MemLimitMB,
+		})
+	}
+	refs, err := s.sqlite.PodConfigRefs(id)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, ref := range refs {
+		detail.ConfigRefs = append(detail.ConfigRefs, PodConfigRef{Kind: ref.Kind, Name: ref.Name, Via: ref.Via})
+	}
+	writeFields(w, r, detail)
+}
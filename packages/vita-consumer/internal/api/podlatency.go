@@ -0,0 +1,114 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/workload"
+)
+
+// PodLatencyResponse is the distribution of pod scheduling and startup
+// latency over a window, cluster-wide and per namespace
+type PodLatencyResponse struct {
+	From       int64              `json:"from"`
+	To         int64              `json:"to"`
+	Cluster    NamespaceLatency   `json:"cluster"`
+	Namespaces []NamespaceLatency `json:"namespaces"`
+}
+
+// NamespaceLatency holds the latencies of the pods of one namespace
+type NamespaceLatency struct {
+	Namespace  string       `json:"namespace,omitempty"`
+	Scheduling LatencyStats `json:"scheduling"`
+	Startup    LatencyStats `json:"startup"`
+}
+
+// LatencyStats summarizes latencies in milliseconds; percentiles are
+// nearest-rank
+type LatencyStats struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// handlePodLatency serves /api/v1/analysis/pod-latency[?hours=&namespace=]:
+// how long pods scheduled within the last hours (default 24) waited for a
+// node after creation, and pods that became ready within them waited to be
+// ready after scheduling, per namespace (name) and cluster-wide. Pods
+// count once, when first scheduled and first ready.
+func (s *Server) handlePodLatency(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	hours, ok := getQueryInt(r, "hours")
+	if !ok || hours <= 0 {
+		hours = 24
+	}
+	namespace := r.URL.Query().Get("namespace")
+	to := s.now(r)
+	from := to.Add(-time.Duration(hours) * time.Hour)
+
+	namespaces, err := s.podNamespaces()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// One point per pod, so a bucket as wide as the window holds it as is
+	b := store.Bucketing{Step: to.Sub(from)}
+	values := make(map[string]map[string][]float64) // metric type -> namespace -> ms
+	for _, metric := range workload.LatencyMetrics {
+		points, err := s.duck.QueryBucketedByResource(r.Context(), "pod", metric, from, to, b, "max")
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		values[metric] = make(map[string][]float64)
+		for _, p := range points {
+			ns, ok := namespaces[p.ResourceID]
+			if !ok || namespace != "" && ns != namespace {
+				continue
+			}
+			values[metric][ns] = append(values[metric][ns], p.Value)
+			values[metric][""] = append(values[metric][""], p.Value)
+		}
+	}
+
+	summarize := func(ns string) NamespaceLatency {
+		return NamespaceLatency{
+			Namespace:  ns,
+			Scheduling: latencyStats(values[workload.MetricSchedulingLatency][ns]),
+			Startup:    latencyStats(values[workload.MetricStartupLatency][ns]),
+		}
+	}
+	resp := PodLatencyResponse{From: from.Unix(), To: to.Unix(), Cluster: summarize(""), Namespaces: []NamespaceLatency{}}
+	seen := make(map[string]bool)
+	for _, byNS := range values {
+		for ns := range byNS {
+			if ns != "" && !seen[ns] {
+				seen[ns] = true
+				resp.Namespaces = append(resp.Namespaces, summarize(ns))
+			}
+		}
+	}
+	sort.Slice(resp.Namespaces, func(i, j int) bool { return resp.Namespaces[i].Namespace < resp.Namespaces[j].Namespace })
+	writeJSON(w, resp)
+}
+
+func latencyStats(values []float64) LatencyStats {
+	if len(values) == 0 {
+		return LatencyStats{}
+	}
+	sort.Float64s(values)
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(values)))) - 1
+		return values[max(i, 0)]
+	}
+	return LatencyStats{Count: len(values), P50: rank(0.5), P90: rank(0.9), P99: rank(0.99), Max: values[len(values)-1]}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Preference values are opaque JSON owned by the UI, e.g. default_namespace,
+// time_range, pinned_workloads or "filter.<name>" for saved filters.
+const maxPrefBytes = 16 << 10
+
+var prefKeyRegex = regexp.MustCompile(`^[a-z0-9_.-]{1,64}$`)
+
+// preferenceScope identifies the caller: the principal the authenticator
+// admitted, if any. Otherwise a bearer token is hashed so it never reaches
+// the database; X-User (set by an auth proxy) is used as is. Requests with
+// neither share the "anonymous" scope.
+func preferenceScope(r *http.Request) string {
+	if p := PrincipalFrom(r.Context()); p.Authenticated() {
+		return "user:" + p.Name
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		sum := sha256.Sum256([]byte(strings.TrimPrefix(auth, "Bearer ")))
+		return "token:" + hex.EncodeToString(sum[:])
+	}
+	if user := r.Header.Get("X-User"); user != "" {
+		return "user:" + user
+	}
+	return "anonymous"
+}
+
+// handlePreferences serves /api/v1/preferences. GET without ?key= returns
+// all preferences of the caller; GET, PUT (JSON body) and DELETE with
+// ?key= act on a single value.
+func (s *Server) handlePreferences(w http.ResponseWriter, r *http.Request) {
+	scope := preferenceScope(r)
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, "key parameter is required", http.StatusBadRequest)
+			return
+		}
+		prefs, err := s.sqlite.GetPreferences(scope)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, prefs)
+		return
+	}
+	if !prefKeyRegex.MatchString(key) {
+		writeError(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		value, err := s.sqlite.GetPreference(scope, key)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, "preference not set", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, value)
+
+	case http.MethodPut:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxPrefBytes+1))
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxPrefBytes {
+			writeError(w, "value too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if !json.Valid(body) {
+			writeError(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := s.sqlite.SetPreference(scope, key, body); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, json.RawMessage(body))
+
+	case http.MethodDelete:
+		found, err := s.sqlite.DeletePreference(scope, key)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			writeError(w, "preference not set", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// processLookback is how far before now (or ?asOf=) the latest process
+// snapshot is searched for
+const processLookback = 5 * time.Minute
+
+// PodProcessesResponse is the latest process snapshot of one pod
+type PodProcessesResponse struct {
+	PodID     int64         `json:"pod_id"`
+	Time      *int64        `json:"time,omitempty"` // of the snapshot; absent without one
+	Processes []ProcessInfo `json:"processes"`
+}
+
+// ProcessInfo is one process of the snapshot. CPUCores is derived from the
+// process's previous sample and absent for new processes.
+type ProcessInfo struct {
+	PID         int64    `json:"pid"`
+	Name        string   `json:"name"`
+	ContainerID string   `json:"container_id,omitempty"`
+	CPUms       float64  `json:"cpu_ms"`
+	CPUCores    *float64 `json:"cpu_cores,omitempty"`
+	MemMB       float64  `json:"mem_mb"`
+}
+
+// SetProcessMetrics enables /api/v1/pods/{id}/processes
+func (s *Server) SetProcessMetrics(enabled bool) {
+	s.processes = enabled
+}
+
+// handlePodProcesses serves GET /api/v1/pods/{id}/processes[?sort=cpu|mem&limit=&asOf=]
+// with the newest snapshot in the five minutes before now
+func (s *Server) handlePodProcesses(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy == "" {
+		sortBy = "cpu"
+	}
+	if sortBy != "cpu" && sortBy != "mem" {
+		writeError(w, "sort must be cpu or mem", http.StatusBadRequest)
+		return
+	}
+
+	now := s.now(r)
+	samples, err := s.duck.QueryProcesses(r.Context(), id, now.Add(-processLookback), now.Add(time.Millisecond))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := PodProcessesResponse{PodID: id, Processes: latestProcesses(samples)}
+	if len(samples) > 0 {
+		t := samples[len(samples)-1].Time.Unix()
+		resp.Time = &t
+	}
+	sort.Slice(resp.Processes, func(i, j int) bool {
+		a, b := resp.Processes[i], resp.Processes[j]
+		if sortBy == "mem" && a.MemMB != b.MemMB {
+			return a.MemMB > b.MemMB
+		}
+		if ac, bc := cores(a), cores(b); ac != bc {
+			return ac > bc
+		}
+		return a.PID < b.PID
+	})
+	if limit, ok := getQueryInt(r, "limit"); ok && limit > 0 && int(limit) < len(resp.Processes) {
+		resp.Processes = resp.Processes[:limit]
+	}
+	writeJSON(w, resp)
+}
+
+// latestProcesses returns the processes of the newest snapshot in samples
+// (ordered by time), with CPU rates against each process's sample before
+func latestProcesses(samples []store.ProcessSample) []ProcessInfo {
+	out := []ProcessInfo{}
+	if len(samples) == 0 {
+		return out
+	}
+	type procKey struct {
+		pid  int64
+		name string // a reused pid is another process
+	}
+	latest := samples[len(samples)-1].Time
+	prev := make(map[procKey]store.ProcessSample)
+	for _, p := range samples {
+		k := procKey{p.PID, p.Name}
+		if !p.Time.Equal(latest) {
+			prev[k] = p
+			continue
+		}
+		info := ProcessInfo{PID: p.PID, Name: p.Name, ContainerID: p.ContainerID, CPUms: p.CPUms, MemMB: p.MemMB}
+		if before, ok := prev[k]; ok {
+			dt := p.Time.Sub(before.Time).Seconds()
+			if dv := p.CPUms - before.CPUms; dt > 0 && dv >= 0 {
+				c := dv / dt / 1000
+				info.CPUCores = &c
+			}
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+func cores(p ProcessInfo) float64 {
+	if p.CPUCores == nil {
+		return 0
+	}
+	return *p.CPUCores
+}
@@ -0,0 +1,93 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/ingest"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/processes"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestPodProcesses verifies agent-reported processes are capped to the
+// top N per pod and served with CPU rates from the previous snapshot
+func TestPodProcesses(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		pod := synctest.Pod("default", "worker", "node-a", nil)
+		if _, err := env.Client.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pod synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pods")
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+		id, err := env.QueryInt("SELECT id FROM pods WHERE name = 'worker'")
+		if err != nil {
+			return err
+		}
+
+		recorder := processes.New(env.Duck, processes.Config{TopN: 2})
+		srv := ingest.NewIngestionServer(env.Ring, env.Resolver)
+		srv.SetProcessRecorder(recorder)
+		post := func(at time.Time, cpu map[string]float64) error {
+			req := ingest.IngestRequest{NodeName: "node-a"}
+			pid := int64(100)
+			for _, name := range []string{"java", "sidecar", "sh"} {
+				pid++
+				req.Processes = append(req.Processes, ingest.RawProcess{
+					PodUID: string(pod.UID), PID: pid, Name: name, CPUms: cpu[name], MemMB: 10 * float64(pid-100), TimestampMs: at.UnixMilli(),
+				})
+			}
+			body, err := json.Marshal(req)
+			if err != nil {
+				return err
+			}
+			rec := httptest.NewRecorder()
+			srv.HandleIngest(rec, httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(string(body))))
+			if rec.Code != http.StatusAccepted {
+				return fmt.Errorf("ingest status = %d, want 202", rec.Code)
+			}
+			return nil
+		}
+		now := env.Clock.Now()
+		if err := post(now.Add(-20*time.Second), map[string]float64{"java": 1000, "sidecar": 500, "sh": 1}); err != nil {
+			return err
+		}
+		if err := post(now.Add(-10*time.Second), map[string]float64{"java": 6000, "sidecar": 1500, "sh": 2}); err != nil {
+			return err
+		}
+		recorder.Flush()
+
+		var resp api.PodProcessesResponse
+		if err := env.GetJSON(fmt.Sprintf("/api/v1/pods/%d/processes", id), &resp); err != nil {
+			return err
+		}
+		if len(resp.Processes) != 2 || resp.Processes[0].Name != "java" || resp.Processes[1].Name != "sidecar" {
+			return fmt.Errorf("processes = %+v, want java then sidecar (sh beyond the top 2)", resp.Processes)
+		}
+		if c := resp.Processes[0].CPUCores; c == nil || *c != 0.5 {
+			return fmt.Errorf("java cpu_cores = %v, want 0.5 (5000ms over 10s)", c)
+		}
+		if resp.Time == nil || *resp.Time != now.Add(-10*time.Second).Unix() {
+			return fmt.Errorf("snapshot time = %v, want the second post", resp.Time)
+		}
+
+		if err := env.GetJSON(fmt.Sprintf("/api/v1/pods/%d/processes?sort=mem&limit=1", id), &resp); err != nil {
+			return err
+		}
+		if len(resp.Processes) != 1 || resp.Processes[0].Name != "sidecar" {
+			return fmt.Errorf("by memory = %+v, want sidecar alone", resp.Processes)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/rules"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// purgeSweepDelay is when a purge's sweep deletes points a second time,
+// catching those that were still in the ring buffer (flushed within a
+// minute)
+const purgeSweepDelay = 2 * time.Minute
+
+// PurgeResponse reports what a purge removed
+type PurgeResponse struct {
+	Kind       string         `json:"kind"`
+	ID         int64          `json:"id"`
+	Name       string         `json:"name"`
+	Removed    map[string]int `json:"removed"` // catalog rows by table
+	Points     int64          `json:"points"`
+	NodeTotals int64          `json:"node_totals"`
+}
+
+// handlePurgeResource serves DELETE /api/v1/admin/resources/{kind}/{id}
+// for namespace, deployment, pod, pvc and node. The catalog entry goes
+// along with every series of its kind and id (metrics, volume usage,
+// replica history, recording rule outputs grouped by it, their latest
+// values), its processes
+// and (for nodes) node totals; namespaces and deployments take their pods
+// with them. Points still buffered are deleted by a sweep queued with the
+// purge (see SweepPurged). Cluster-wide totals keep their contribution,
+// and series written before series recorded their kind are left to
+// retention unless they are recording rule outputs. A resource that still
+// exists in the cluster is re-added by the syncer under a new id.
+func (s *Server) handlePurgeResource(w http.ResponseWriter, r *http.Request) {
+	kind := r.PathValue("kind")
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	switch kind {
+	case "namespace", "deployment", "pod", "pvc", "node":
+	default:
+		writeError(w, fmt.Sprintf("Cannot purge kind %q", kind), http.StatusBadRequest)
+		return
+	}
+
+	set, err := s.sqlite.PurgeSet(kind, id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, "Resource not found", http.StatusNotFound)
+		return
+	case errors.Is(err, store.ErrPurgeConflict):
+		writeError(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Points first: if this fails, the catalog entry is still there to retry
+	resp := PurgeResponse{Kind: kind, ID: id, Name: set.Name, Removed: map[string]int{}}
+	if resp.Points, resp.NodeTotals, err = s.purgePoints(set); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.sqlite.Purge(set, s.clock.Now().Add(purgeSweepDelay)); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if s.cache != nil {
+		s.cache.Forget(set.IDs)
+	}
+	for table, ids := range set.IDs {
+		resp.Removed[table] = len(ids)
+	}
+
+	summary := fmt.Sprintf("removed %s, %d points and %d node totals", describeRemoved(resp.Removed), resp.Points, resp.NodeTotals)
+	log.Printf("Audit: %s purged %s %q (id %d): %s", requester(r), kind, set.Name, id, summary)
+	if err := s.sqlite.InsertAnnotation(store.Annotation{
+		Time:    s.clock.Now(),
+		Type:    "purge",
+		Kind:    kind,
+		Name:    set.Name,
+		Message: fmt.Sprintf("Purged by %s: %s", requester(r), summary),
+	}); err != nil {
+		log.Printf("Failed to record purge annotation: %v", err)
+	}
+	writeJSON(w, resp)
+}
+
+// SweepPurged deletes the points and latest values of purged resources
+// whose sweep is due, written from the ring buffer after their purge. Sweeps are kept in the
+// catalog until they succeed, so a restart does not lose them.
+func (s *Server) SweepPurged(ctx context.Context, now time.Time) error {
+	due, err := s.sqlite.DuePurgeSweeps(now)
+	if err != nil || len(due) == 0 {
+		return err
+	}
+	points, totals, err := s.purgePoints(&store.PurgeSet{IDs: due})
+	if err != nil {
+		return err
+	}
+	if points > 0 || totals > 0 {
+		log.Printf("Purge sweep removed %d points and %d node totals written after their resources were purged", points, totals)
+	}
+	if err := s.sqlite.DeleteLatest(due); err != nil {
+		return err
+	}
+	return s.sqlite.DeletePurgeSweeps(now)
+}
+
+// purgePoints deletes the DuckDB data of everything in set
+func (s *Server) purgePoints(set *store.PurgeSet) (points, totals int64, err error) {
+	for table, ids := range set.IDs {
+		n, err := s.duck.DeleteResourcePoints(store.TableKind(table), ids)
+		if err != nil {
+			return points, totals, err
+		}
+		points += n
+	}
+
+	// Recording rule outputs are keyed by the id of the group they sum
+	// over; those from before series recorded their kind go by rule name
+	recording, err := s.sqlite.ListRecordingRules()
+	if err != nil {
+		return points, totals, err
+	}
+	for _, rule := range recording {
+		expr, err := rules.ParseExpr(rule.Expr)
+		if err != nil || expr.By == "" {
+			continue
+		}
+		n, err := s.duck.DeleteLegacyPoints(set.IDs[expr.By+"s"], []string{rule.Name})
+		if err != nil {
+			return points, totals, err
+		}
+		points += n
+	}
+
+	n, err := s.duck.DeleteProcesses(set.IDs["pods"])
+	if err != nil {
+		return points, totals, err
+	}
+	points += n
+
+	for _, node := range set.IDs["nodes"] {
+		n, err := s.duck.DeleteNodeTotals(node)
+		if err != nil {
+			return points, totals, err
+		}
+		totals += n
+	}
+	return points, totals, nil
+}
+
+func describeRemoved(removed map[string]int) string {
+	var parts []string
+	for _, t := range []string{"namespaces", "nodes", "deployments", "statefulsets", "daemonsets", "pods", "pvcs", "pdbs", "services", "ingresses"} {
+		if n := removed[t]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, t))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// requester identifies who made a request for audit logs: the principal
+// the authenticator admitted or else the user an authenticating proxy
+// forwarded, and the client address
+func requester(r *http.Request) string {
+	if p := PrincipalFrom(r.Context()); p.Authenticated() {
+		return fmt.Sprintf("%s (%s)", p.Name, r.RemoteAddr)
+	}
+	for _, h := range []string{"X-Forwarded-User", "X-Remote-User", "X-Auth-Request-User"} {
+		if u := r.Header.Get(h); u != "" {
+			return fmt.Sprintf("%s (%s)", u, r.RemoteAddr)
+		}
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,165 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestPurgeNamespace verifies purging a namespace removes its catalog rows,
+// points and latest values, leaves other namespaces alone, and is audited, and that
+// anonymous callers cannot purge
+func TestPurgeNamespace(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		rs, _, err := env.CreateDeployment(ctx, synctest.Deployment("scratch", "web", 1))
+		if err != nil {
+			return err
+		}
+		if _, err := env.Client.CoreV1().Pods("scratch").Create(ctx, synctest.Pod("scratch", "web-1", "node-a", rs), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if _, err := env.Client.CoreV1().PersistentVolumeClaims("scratch").Create(ctx, synctest.PVC("scratch", "data"), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if _, err := env.Client.CoreV1().Pods("keep").Create(ctx, synctest.Pod("keep", "app", "node-a", nil), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pods and pvc synced", func() (bool, error) {
+			pods, err := env.QueryInt("SELECT COUNT(*) FROM pods")
+			if err != nil {
+				return false, err
+			}
+			pvcs, err := env.QueryInt("SELECT COUNT(*) FROM pvcs")
+			return pods == 2 && pvcs == 1, err
+		}); err != nil {
+			return err
+		}
+
+		nsID, err := env.QueryInt("SELECT id FROM namespaces WHERE name = 'scratch'")
+		if err != nil {
+			return err
+		}
+		scratchPod, err := env.QueryInt("SELECT id FROM pods WHERE name = 'web-1'")
+		if err != nil {
+			return err
+		}
+		keepPod, err := env.QueryInt("SELECT id FROM pods WHERE name = 'app'")
+		if err != nil {
+			return err
+		}
+		claim, err := env.QueryInt("SELECT id FROM pvcs WHERE name = 'data'")
+		if err != nil {
+			return err
+		}
+		// The kept pod's emptyDir usage has the claim's types
+		now := env.Clock.Now()
+		points := []store.MetricPoint{
+			{Time: now, ResourceID: scratchPod, ResourceKind: "pod", MetricType: "mem_mb", Value: 10},
+			{Time: now, ResourceID: scratchPod, ResourceKind: "pod", MetricType: "custom_queue_depth", Value: 3},
+			{Time: now, ResourceID: claim, ResourceKind: "pvc", MetricType: "used_mb", Value: 100},
+			{Time: now, ResourceID: keepPod, ResourceKind: "pod", MetricType: "mem_mb", Value: 20},
+			{Time: now, ResourceID: keepPod, ResourceKind: "pod", MetricType: "used_mb", Value: 5},
+		}
+		if err := env.Duck.BatchInsert(points); err != nil {
+			return err
+		}
+		if err := env.SQLite.UpsertLatest(points); err != nil {
+			return err
+		}
+
+		req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/api/v1/admin/resources/namespace/%d", env.API.URL, nsID), nil)
+		if err != nil {
+			return err
+		}
+		anon, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		anon.Body.Close()
+		if anon.StatusCode != http.StatusForbidden {
+			return fmt.Errorf("anonymous purge: %s, want 403", anon.Status)
+		}
+
+		var resp api.PurgeResponse
+		if err := env.DeleteJSON(fmt.Sprintf("/api/v1/admin/resources/namespace/%d", nsID), &resp); err != nil {
+			return err
+		}
+		if resp.Removed["pods"] != 1 || resp.Removed["pvcs"] != 1 || resp.Removed["deployments"] != 1 || resp.Points != 3 {
+			return fmt.Errorf("purge removed %+v and %d points, want 1 pod, pvc and deployment and 3 points", resp.Removed, resp.Points)
+		}
+
+		for _, q := range []string{
+			"SELECT COUNT(*) FROM namespaces WHERE name = 'scratch'",
+			"SELECT COUNT(*) FROM deployments",
+			"SELECT COUNT(*) FROM pvcs",
+		} {
+			if n, err := env.QueryInt(q); err != nil || n != 0 {
+				return fmt.Errorf("%s = %d (%v), want 0", q, n, err)
+			}
+		}
+		for id, want := range map[int64]int{scratchPod: 0, keepPod: 2} {
+			points, err := env.Duck.QuerySeries(ctx, "pod", id, []string{"mem_mb", "used_mb", "custom_queue_depth"}, now.Add(-time.Minute), now.Add(time.Minute))
+			if err != nil {
+				return err
+			}
+			if len(points) != want {
+				return fmt.Errorf("pod %d has %d points after purge, want %d", id, len(points), want)
+			}
+		}
+		latest, err := env.SQLite.LatestPoints(now.Add(-time.Minute), "", []string{"mem_mb", "used_mb", "custom_queue_depth"}, nil)
+		if err != nil {
+			return err
+		}
+		if len(latest) != 2 || latest[0].ResourceID != keepPod || latest[1].ResourceID != keepPod {
+			return fmt.Errorf("latest values after purge %+v, want the kept pod's 2", latest)
+		}
+
+		// Points buffered at the time of the purge are swept once due
+		late := []store.MetricPoint{
+			{Time: now.Add(time.Second), ResourceID: scratchPod, ResourceKind: "pod", MetricType: "mem_mb", Value: 11},
+		}
+		if err := env.Duck.BatchInsert(late); err != nil {
+			return err
+		}
+		if err := env.SQLite.UpsertLatest(late); err != nil {
+			return err
+		}
+		if err := env.Server.SweepPurged(ctx, now.Add(time.Minute)); err != nil {
+			return err
+		}
+		if n, err := env.Duck.CountSeries(ctx, scratchPod, "mem_mb", now.Add(-time.Minute), now.Add(time.Minute)); err != nil || n != 1 {
+			return fmt.Errorf("sweep ran early: purged pod has %d points (%v)", n, err)
+		}
+		if err := env.Server.SweepPurged(ctx, now.Add(3*time.Minute)); err != nil {
+			return err
+		}
+		if n, err := env.Duck.CountSeries(ctx, scratchPod, "mem_mb", now.Add(-time.Minute), now.Add(time.Minute)); err != nil || n != 0 {
+			return fmt.Errorf("purged pod has %d points after the sweep (%v)", n, err)
+		}
+		if n, err := env.QueryInt("SELECT COUNT(*) FROM latest_values WHERE resource_kind = 'pod' AND resource_id = ?", scratchPod); err != nil || n != 0 {
+			return fmt.Errorf("purged pod has %d latest values after the sweep (%v)", n, err)
+		}
+		if n, err := env.QueryInt("SELECT COUNT(*) FROM purge_sweeps"); err != nil || n != 0 {
+			return fmt.Errorf("%d sweeps left after running (%v)", n, err)
+		}
+		if n, err := env.QueryInt("SELECT COUNT(*) FROM annotations WHERE type = 'purge' AND name = 'scratch'"); err != nil || n != 1 {
+			return fmt.Errorf("purge annotations = %d (%v), want 1", n, err)
+		}
+
+		// The syncer must not reuse the purged namespace id
+		if _, err := env.Client.CoreV1().Pods("scratch").Create(ctx, synctest.Pod("scratch", "web-2", "node-a", nil), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		return env.Eventually(synctest.Timeout, "namespace re-created", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pods p JOIN namespaces n ON p.namespace_id = n.id WHERE p.name = 'web-2' AND n.name = 'scratch'")
+			return n == 1, err
+		})
+	})
+}
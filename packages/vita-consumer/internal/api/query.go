@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	maxBatchQueries     = 200
+	batchConcurrency    = 8
+	defaultBatchTimeout = 10 * time.Second
+	maxBatchTimeout     = 60 * time.Second
+)
+
+// BatchQueryRequest is the body of POST /api/v1/metrics/query. From/To are
+// unix seconds (default: last hour) and apply to every query.
+type BatchQueryRequest struct {
+	From      int64            `json:"from,omitempty"`
+	To        int64            `json:"to,omitempty"`
+	TimeoutMs int64            `json:"timeout_ms,omitempty"`
+	Queries   []BatchQuerySpec `json:"queries"`
+}
+
+// BatchQuerySpec is a SeriesQuery with a caller-chosen ID echoed in the result
+type BatchQuerySpec struct {
+	ID string `json:"id"`
+	SeriesQuery
+}
+
+// BatchQueryResult holds either a series or the error for one query
+type BatchQueryResult struct {
+	ID     string          `json:"id"`
+	Series *SeriesResponse `json:"series,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+type BatchQueryResponse struct {
+	Results []BatchQueryResult `json:"results"`
+}
+
+// handleBatchQuery runs many series queries concurrently under one
+// deadline. Results are returned in request order; a failing query does not
+// fail the batch.
+func (s *Server) handleBatchQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req BatchQueryRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.Queries) == 0 {
+		writeError(w, "queries is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Queries) > maxBatchQueries {
+		writeError(w, "too many queries", http.StatusBadRequest)
+		return
+	}
+
+	from, to := s.batchRange(r, req.From, req.To)
+	results := s.runBatch(r.Context(), req.Queries, from, to, req.TimeoutMs)
+	writeJSON(w, BatchQueryResponse{Results: results})
+}
+
+// batchRange is the window of a batch: from and to in unix seconds,
+// defaulting to the hour before the request's time
+func (s *Server) batchRange(r *http.Request, fromUnix, toUnix int64) (time.Time, time.Time) {
+	to := s.now(r)
+	if toUnix > 0 {
+		to = time.Unix(toUnix, 0)
+	}
+	from := to.Add(-time.Hour)
+	if fromUnix > 0 {
+		from = time.Unix(fromUnix, 0)
+	}
+	return from, to
+}
+
+// runBatch runs queries concurrently under one deadline of timeoutMs
+// (default and cap: defaultBatchTimeout, maxBatchTimeout). Results are in
+// query order.
+func (s *Server) runBatch(ctx context.Context, queries []BatchQuerySpec, from, to time.Time, timeoutMs int64) []BatchQueryResult {
+	timeout := defaultBatchTimeout
+	if timeoutMs > 0 {
+		timeout = time.Duration(timeoutMs) * time.Millisecond
+	}
+	if timeout > maxBatchTimeout {
+		timeout = maxBatchTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	results := make([]BatchQueryResult, len(queries))
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i, q := range queries {
+		results[i].ID = q.ID
+		if q.Type == "" {
+			results[i].Error = "type is required"
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, q BatchQuerySpec) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				results[i].Error = ctx.Err().Error()
+				return
+			}
+
+			series, err := s.querySeries(ctx, q.SeriesQuery, from, to)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].Series = &series
+		}(i, q)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/health"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+const (
+	defaultStormFactor      = 3
+	defaultStormMinRestarts = 3
+	defaultStormBaseline    = 24 * time.Hour
+)
+
+// RestartStormsResponse lists workloads restarting well above their usual
+// rate in the window before At
+type RestartStormsResponse struct {
+	At            int64          `json:"at"`
+	WindowSec     int64          `json:"window_sec"`
+	BaselineHours int64          `json:"baseline_hours"`
+	Factor        float64        `json:"factor"`
+	MinRestarts   int            `json:"min_restarts"`
+	Storms        []RestartStorm `json:"storms"`
+}
+
+// RestartStorm is a workload's restarts in the window against its hourly
+// baseline, with what changed around it. Ratio is absent when the baseline
+// is zero.
+type RestartStorm struct {
+	Kind            string       `json:"kind"`
+	ID              int64        `json:"id"`
+	Name            string       `json:"name"`
+	Namespace       string       `json:"namespace"`
+	Restarts        int          `json:"restarts"`
+	BaselinePerHour float64      `json:"baseline_per_hour"`
+	Ratio           *float64     `json:"ratio,omitempty"`
+	OOMKills        int          `json:"oom_kills"`
+	LastOOMAt       *int64       `json:"last_oom_at,omitempty"`
+	Rollouts        []Annotation `json:"rollouts"`
+	ConfigChanges   []Annotation `json:"config_changes"`
+}
+
+// handleRestartStorms serves GET /api/v1/analysis/restart-storms[?factor=&min_restarts=&baseline_hours=&namespace=].
+// Health scoring counts each workload's restarts over the hour before each
+// run; a workload storms when the latest count is at least min_restarts
+// (default 3) and more than factor (default 3) times its average over the
+// baseline_hours (default 24) before that hour. Rollouts of the workload
+// and config changes in its namespace from the hour before the window on
+// are listed with it, as are its OOM kills.
+func (s *Server) handleRestartStorms(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	factor := float64(defaultStormFactor)
+	if raw := q.Get("factor"); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v < 1 {
+			writeError(w, "factor must be a number of at least 1", http.StatusBadRequest)
+			return
+		}
+		factor = v
+	}
+	minRestarts := defaultStormMinRestarts
+	if n, ok := getQueryInt(r, "min_restarts"); ok && n > 0 {
+		minRestarts = int(n)
+	}
+	baseline := defaultStormBaseline
+	if n, ok := getQueryInt(r, "baseline_hours"); ok && n > 0 {
+		baseline = time.Duration(n) * time.Hour
+	}
+	namespace := q.Get("namespace")
+
+	now := s.clock.Now()
+	windowStart := now.Add(-health.Window)
+	history, err := s.sqlite.WorkloadHealthBetween(windowStart.Add(-baseline), now.Add(time.Second))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	type stats struct {
+		latest       *store.WorkloadHealth
+		baselineSum  int
+		baselineRuns int
+	}
+	byWorkload := make(map[workloadRef]*stats)
+	for i, h := range history {
+		ref := workloadRef{h.Kind, h.WorkloadID}
+		st := byWorkload[ref]
+		if st == nil {
+			st = &stats{}
+			byWorkload[ref] = st
+		}
+		if h.Time.Before(windowStart) {
+			st.baselineSum += h.Restarts
+			st.baselineRuns++
+		} else {
+			st.latest = &history[i] // oldest first, so the last one wins
+		}
+	}
+
+	names, err := s.workloadNames()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := RestartStormsResponse{
+		At:            now.Unix(),
+		WindowSec:     int64(health.Window / time.Second),
+		BaselineHours: int64(baseline / time.Hour),
+		Factor:        factor,
+		MinRestarts:   minRestarts,
+		Storms:        []RestartStorm{},
+	}
+	for ref, st := range byWorkload {
+		wl, ok := names[ref]
+		if st.latest == nil || !ok || namespace != "" && wl.Namespace != namespace {
+			continue
+		}
+		storm := RestartStorm{Kind: ref.kind, ID: ref.id, Name: wl.Name, Namespace: wl.Namespace, Restarts: st.latest.Restarts, OOMKills: st.latest.OOMKills}
+		if st.baselineRuns > 0 {
+			storm.BaselinePerHour = float64(st.baselineSum) / float64(st.baselineRuns)
+		}
+		if storm.Restarts < minRestarts || float64(storm.Restarts) <= factor*storm.BaselinePerHour {
+			continue
+		}
+		if storm.BaselinePerHour > 0 {
+			ratio := math.Round(float64(storm.Restarts)/storm.BaselinePerHour*10) / 10
+			storm.Ratio = &ratio
+		}
+		storm.BaselinePerHour = math.Round(storm.BaselinePerHour*10) / 10
+		resp.Storms = append(resp.Storms, storm)
+	}
+	if len(resp.Storms) == 0 {
+		writeJSON(w, resp)
+		return
+	}
+
+	if err := s.correlateStorms(resp.Storms, windowStart.Add(-health.Window)); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(resp.Storms, func(i, j int) bool {
+		a, b := resp.Storms[i], resp.Storms[j]
+		if a.Restarts != b.Restarts {
+			return a.Restarts > b.Restarts
+		}
+		return a.Kind < b.Kind || a.Kind == b.Kind && a.ID < b.ID
+	})
+	writeJSON(w, resp)
+}
+
+// correlateStorms adds the rollouts and config changes since the given
+// time and the latest OOM kill of their pods to storms
+func (s *Server) correlateStorms(storms []RestartStorm, since time.Time) error {
+	rows, err := s.sqlite.Query(`SELECT a.id, a.time, a.namespace_id, a.type, a.kind, a.name, a.message, coalesce(n.name, '')
+		FROM annotations a LEFT JOIN namespaces n ON n.id = a.namespace_id
+		WHERE a.time >= ? AND a.type IN ('rollout', 'config_change') ORDER BY a.time`, since.UTC())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a Annotation
+		var t time.Time
+		var ns string
+		if err := rows.Scan(&a.ID, &t, &a.NamespaceID, &a.Type, &a.Kind, &a.Name, &a.Message, &ns); err != nil {
+			return err
+		}
+		a.Time = t.Unix()
+		for i := range storms {
+			st := &storms[i]
+			switch {
+			case st.Namespace != ns:
+			case a.Type == "rollout" && a.Kind == st.Kind && a.Name == st.Name:
+				st.Rollouts = append(st.Rollouts, a)
+			case a.Type == "config_change":
+				st.ConfigChanges = append(st.ConfigChanges, a)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	pods, err := s.sqlite.WorkloadPods()
+	if err != nil {
+		return err
+	}
+	lastOOM := make(map[workloadRef]time.Time)
+	for _, p := range pods {
+		ref := workloadRef{p.Kind, p.WorkloadID}
+		if p.OOMKilledAt != nil && p.OOMKilledAt.After(lastOOM[ref]) {
+			lastOOM[ref] = *p.OOMKilledAt
+		}
+	}
+	for i := range storms {
+		st := &storms[i]
+		if st.Rollouts == nil {
+			st.Rollouts = []Annotation{}
+		}
+		if st.ConfigChanges == nil {
+			st.ConfigChanges = []Annotation{}
+		}
+		if t, ok := lastOOM[workloadRef{st.Kind, st.ID}]; ok {
+			at := t.Unix()
+			st.LastOOMAt = &at
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/health"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// A workload restarting well above its baseline is listed with its
+// rollout, one restarting at its usual rate is not
+func TestRestartStorms(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		deployments := env.Client.AppsV1().Deployments("storms")
+		ids := map[string]int64{}
+		for _, name := range []string{"flappy", "steady"} {
+			dep := synctest.Deployment("storms", name, 1)
+			dep.Annotations = map[string]string{"deployment.kubernetes.io/revision": "1"}
+			if _, err := deployments.Create(ctx, dep, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+			if err := env.Eventually(synctest.Timeout, "deployment synced", func() (bool, error) {
+				id, err := env.QueryInt("SELECT id FROM deployments WHERE uid = ? AND revision = '1'", string(dep.UID))
+				ids[name] = id
+				return id > 0, err
+			}); err != nil {
+				return err
+			}
+		}
+		flappy, err := deployments.Get(ctx, "flappy", metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		flappy.Annotations["deployment.kubernetes.io/revision"] = "2"
+		if _, err := deployments.Update(ctx, flappy, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "rollout annotated", func() (bool, error) {
+			n, err := env.QueryInt("SELECT count(*) FROM annotations WHERE type = 'rollout' AND kind = 'deployment' AND name = 'flappy'")
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+
+		// An hour's restarts per run: flappy usually restarts once, steady four times
+		now := env.Clock.Now()
+		var rows []store.WorkloadHealth
+		for _, ago := range []time.Duration{4 * time.Hour, 3 * time.Hour, 2 * time.Hour, 5 * time.Minute} {
+			latest := ago < health.Window
+			row := func(name string, restarts, latestRestarts int) store.WorkloadHealth {
+				h := store.WorkloadHealth{Time: now.Add(-ago), Kind: "deployment", WorkloadID: ids[name], Score: 100, Restarts: restarts}
+				if latest {
+					h.Restarts = latestRestarts
+				}
+				return h
+			}
+			flappyRow := row("flappy", 1, 9)
+			if latest {
+				flappyRow.OOMKills = 2
+			}
+			rows = append(rows, flappyRow, row("steady", 4, 5))
+		}
+		if err := env.SQLite.InsertWorkloadHealth(rows); err != nil {
+			return err
+		}
+
+		var resp api.RestartStormsResponse
+		if err := env.GetJSON("/api/v1/analysis/restart-storms?namespace=storms", &resp); err != nil {
+			return err
+		}
+		if len(resp.Storms) != 1 {
+			return fmt.Errorf("storms = %+v, want flappy only", resp.Storms)
+		}
+		st := resp.Storms[0]
+		if st.Name != "flappy" || st.Restarts != 9 || st.BaselinePerHour != 1 || st.Ratio == nil || *st.Ratio != 9 || st.OOMKills != 2 {
+			return fmt.Errorf("flappy storm = %+v", st)
+		}
+		if len(st.Rollouts) != 1 || st.Rollouts[0].Message != "Rolled out revision 2 (was 1)" {
+			return fmt.Errorf("flappy rollouts = %+v", st.Rollouts)
+		}
+		// steady's 5 restarts are within 3x its baseline, but not within 1.2x
+		if err := env.GetJSON("/api/v1/analysis/restart-storms?namespace=storms&factor=1.2", &resp); err != nil {
+			return err
+		}
+		if len(resp.Storms) != 2 || resp.Storms[1].Name != "steady" {
+			return fmt.Errorf("storms with factor 1.2 = %+v", resp.Storms)
+		}
+		return nil
+	})
+}
@@ -2,35 +2,194 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/lastseen"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/rollup"
 	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
 )
 
 type Server struct {
 	sqlite *store.SQLiteStore
+	duck   *store.DuckDBStore
 	ring   *buffer.RingBuffer
+	events EventSource
+	clock  clock.Clock
+	totals DeploymentTotals
+	seen   *lastseen.Tracker
+	cache  CatalogCache
+	status ClusterStatus
+
+	podLogs     PodLogSource
+	podLogsAuth PodLogsAuth
+	processes   bool
+	flusher     Flusher
+	health      bool
+	maxPoints   int
+
+	statusPage  *StatusPage
+	statusCache statusPageCache
+
+	local *store.Source
+}
+
+// DeploymentTotals provides running per-deployment usage for the live
+// deployment cards
+type DeploymentTotals interface {
+	Totals() []rollup.DeploymentTotal
+}
+
+// CatalogCache holds ids of catalog rows, which a purge invalidates
+type CatalogCache interface {
+	Forget(ids map[string][]int64)
 }
 
-func NewServer(sqlite *store.SQLiteStore, ring *buffer.RingBuffer) *Server {
+func NewServer(sqlite *store.SQLiteStore, duck *store.DuckDBStore, ring *buffer.RingBuffer, events EventSource) *Server {
 	return &Server{
 		sqlite: sqlite,
+		duck:   duck,
 		ring:   ring,
+		events: events,
+		clock:  clock.Real,
 	}
 }
 
+// SetClock replaces the clock used for "now" in live and default time ranges
+func (s *Server) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// SetLastSeen adds last_seen and stale to pods and nodes, and enables the
+// ?stale= filter
+func (s *Server) SetLastSeen(t *lastseen.Tracker) {
+	s.seen = t
+}
+
+// SetCatalogCache makes purges invalidate the syncer's cached ids
+func (s *Server) SetCatalogCache(c CatalogCache) {
+	s.cache = c
+}
+
+// SetPodLogs enables /api/v1/pods/{id}/logs for callers auth admits
+func (s *Server) SetPodLogs(src PodLogSource, auth PodLogsAuth) {
+	s.podLogs = src
+	s.podLogsAuth = auth
+}
+
+// SetClusterStatus enables /api/v1/status
+func (s *Server) SetClusterStatus(c ClusterStatus) {
+	s.status = c
+}
+
+// SetDeploymentTotals enables /api/v1/metrics/live/deployments
+func (s *Server) SetDeploymentTotals(t DeploymentTotals) {
+	s.totals = t
+}
+
 func (s *Server) RegisterRoutes(mux *http.ServeMux) {
+	if s.status != nil {
+		mux.HandleFunc("GET /api/v1/status", s.handleStatus)
+	}
+	if s.statusPage != nil {
+		mux.HandleFunc("GET /status", s.handleStatusPage)
+	}
+
 	// List endpoints
 	mux.HandleFunc("/api/v1/nodes", s.handleListNodes)
+	mux.HandleFunc("/api/v1/node-groups", s.handleNodeGroups)
 	mux.HandleFunc("/api/v1/namespaces", s.handleListNamespaces)
 	mux.HandleFunc("/api/v1/deployments", s.handleListDeployments)
 	mux.HandleFunc("/api/v1/pods", s.handleListPods)
+	mux.HandleFunc("GET /api/v1/pods/{id}", s.handlePodDetail)
+	if s.podLogs != nil && s.podLogsAuth.Enabled() {
+		mux.HandleFunc("GET /api/v1/pods/{id}/logs", s.handlePodLogs)
+	}
+	if s.processes {
+		mux.HandleFunc("GET /api/v1/pods/{id}/processes", s.handlePodProcesses)
+	}
 	mux.HandleFunc("/api/v1/pvcs", s.handleListPVCs)
+	mux.HandleFunc("GET /api/v1/pvcs/{id}/resizes", s.handlePVCResizes)
+	mux.HandleFunc("/api/v1/persistentvolumes", s.handleListPersistentVolumes)
+	mux.HandleFunc("/api/v1/storageclasses", s.handleListStorageClasses)
+	mux.HandleFunc("/api/v1/storage/usage", s.handleStorageUsage)
+	mux.HandleFunc("/api/v1/storage/failures", s.handleVolumeFailures)
+	mux.HandleFunc("/api/v1/pdbs", s.handleListPDBs)
+	mux.HandleFunc("/api/v1/ingresses", s.handleListIngresses)
+	mux.HandleFunc("/api/v1/ingresses/pods", s.handleIngressPods)
+
+	// Incremental catalog mirror
+	mux.HandleFunc("/api/v1/catalog", s.handleCatalog)
+
+	// Where stored rows came from (cluster, consumer instance, agent version)
+	mux.HandleFunc("/api/v1/sources", s.handleSources)
+
+	// ConfigMap/Secret blast radius (names only)
+	mux.HandleFunc("GET /api/v1/configmaps/{name}/consumers", s.handleConfigMapConsumers)
+	mux.HandleFunc("GET /api/v1/secrets/{name}/consumers", s.handleSecretConsumers)
+	mux.HandleFunc("/api/v1/annotations", s.handleListAnnotations)
+	mux.HandleFunc("/api/v1/timeline", s.handleTimeline)
 
 	// Live metrics
 	mux.HandleFunc("/api/v1/metrics/live", s.handleLiveMetrics)
+	mux.HandleFunc("/api/v1/metrics/live/snapshot", s.handleLiveSnapshot)
+	mux.HandleFunc("/api/v1/metrics/live/deployments", s.handleLiveDeployments)
+
+	// History
+	mux.HandleFunc("/api/v1/metrics/series", s.handleSeries)
+	mux.HandleFunc("/api/v1/metrics/query", s.handleBatchQuery)
+	mux.HandleFunc("/api/v1/metrics/totals", s.handleNodeTotals)
+	mux.HandleFunc("/api/v1/metrics/memory", s.handleMemoryHistory)
+	mux.HandleFunc("/api/v1/metrics/heatmap", s.handleHeatmap)
+	mux.HandleFunc("/api/v1/metrics/compare", s.handleCompare)
+	mux.HandleFunc("/api/v1/metrics/units", s.handleUnits)
+	mux.HandleFunc("/api/v1/metrics/latest", s.handleLatest)
+	mux.HandleFunc("/api/v1/deployments/replicas", s.handleDeploymentReplicas)
+	mux.HandleFunc("/api/v1/statefulsets/replicas", s.handleStatefulSetReplicas)
+	mux.HandleFunc("GET /api/v1/daemonsets/{id}/coverage", s.handleDaemonSetCoverage)
+
+	// Shared, parameterized history queries
+	mux.HandleFunc("GET /api/v1/query-templates", s.handleListQueryTemplates)
+	mux.HandleFunc("GET /api/v1/query-templates/{name}", s.handleGetQueryTemplate)
+	mux.HandleFunc("PUT /api/v1/query-templates/{name}", s.handlePutQueryTemplate)
+	mux.HandleFunc("DELETE /api/v1/query-templates/{name}", s.handleDeleteQueryTemplate)
+	mux.HandleFunc("POST /api/v1/query-templates/{name}/run", s.handleRunQueryTemplate)
+
+	// Dashboard dropdowns
+	mux.HandleFunc("/api/v1/values", s.handleValues)
+
+	// Analysis
+	mux.HandleFunc("/api/v1/analysis/capacity", s.handleCapacity)
+	mux.HandleFunc("/api/v1/analysis/throttling", s.handleThrottling)
+	mux.HandleFunc("/api/v1/analysis/volumes", s.handleVolumeForecast)
+	mux.HandleFunc("/api/v1/analysis/pod-latency", s.handlePodLatency)
+	mux.HandleFunc("/api/v1/analysis/noisy-neighbors", s.handleNoisyNeighbors)
+	mux.HandleFunc("/api/v1/analysis/placement", s.handlePlacement)
+	if s.health {
+		mux.HandleFunc("GET /api/v1/health/workloads", s.handleWorkloadHealth)
+		mux.HandleFunc("GET /api/v1/health/workloads/{kind}/{id}/history", s.handleWorkloadHealthHistory)
+		mux.HandleFunc("GET /api/v1/analysis/restart-storms", s.handleRestartStorms)
+	}
+
+	// Ingest volume per namespace/node
+	mux.HandleFunc("/api/v1/usage", s.handleUsage)
+
+	// Remove a resource and all its data; authenticated callers only
+	mux.HandleFunc("DELETE /api/v1/admin/resources/{kind}/{id}", RequireAuthenticated(s.handlePurgeResource))
+
+	// Force (authenticated callers only) and inspect buffer flushes
+	if s.flusher != nil {
+		mux.HandleFunc("GET /api/v1/admin/flush", s.handleFlushStatus)
+		mux.HandleFunc("POST /api/v1/admin/flush", RequireAuthenticated(s.handleFlush))
+	}
+
+	// Per-user UI preferences
+	mux.HandleFunc("/api/v1/preferences", s.handlePreferences)
 }
 
 // Helper functions
@@ -56,3 +215,50 @@ func getQueryInt(r *http.Request, param string) (int64, bool) {
 	}
 	return i, true
 }
+
+// placeholders returns "?,?,?" for n SQL parameters
+func placeholders(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strings.Repeat("?,", n-1) + "?"
+}
+
+// getAsOf reads ?asOf= as unix seconds or RFC3339
+func getAsOf(r *http.Request) (time.Time, bool, error) {
+	val := r.URL.Query().Get("asOf")
+	if val == "" {
+		return time.Time{}, false, nil
+	}
+	if v, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return time.Unix(v, 0), true, nil
+	}
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid asOf %q, want unix seconds or RFC3339", val)
+	}
+	return t, true, nil
+}
+
+// now is the time a request is evaluated at: ?asOf= when given, otherwise
+// the server clock. Unparseable values fall back like other query params.
+func (s *Server) now(r *http.Request) time.Time {
+	if t, ok, err := getAsOf(r); ok && err == nil {
+		return t
+	}
+	return s.clock.Now()
+}
+
+// getTimeRange reads ?from= and ?to= (unix seconds), defaulting to the hour
+// before now(r)
+func (s *Server) getTimeRange(r *http.Request) (time.Time, time.Time) {
+	to := s.now(r)
+	if v, ok := getQueryInt(r, "to"); ok {
+		to = time.Unix(v, 0)
+	}
+	from := to.Add(-time.Hour)
+	if v, ok := getQueryInt(r, "from"); ok {
+		from = time.Unix(v, 0)
+	}
+	return from, to
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+)
+
+// Snapshot wire format ("VKS1"), all integers varint-encoded
+// (encoding/binary Uvarint/Varint), strings as uvarint length + bytes:
+//
+//	magic       "VKS1"
+//	base_ms     uvarint  earliest sample time, unix milliseconds
+//	types       uvarint count, then string per metric type
+//	resources   uvarint count, then per resource:
+//	              kind byte (0 unresolved, 1 pod, 2 pvc)
+//	              id varint, uid string, name string, namespace string
+//	samples     uvarint count, then per sample:
+//	              resource uvarint (index into resources)
+//	              type uvarint (index into types)
+//	              offset_ms uvarint (from base_ms)
+//	              value 8 bytes, IEEE 754 float64 little endian
+//
+// Samples are grouped by resource and ordered by time within a resource.
+const snapshotMagic = "VKS1"
+
+const (
+	snapshotUnresolved byte = iota
+	snapshotPod
+	snapshotPVC
+)
+
+// snapshotResource is one dictionary entry
+type snapshotResource struct {
+	kind      byte
+	id        int64
+	uid       string
+	name      string
+	namespace string
+}
+
+// snapshotKind decides what a sample's resource ID refers to. Volume
+// metrics of non-PVC volumes are keyed by pod, but are indistinguishable
+// once buffered, so all volume metrics are treated as PVC samples.
+func snapshotKind(m buffer.Metric) byte {
+	switch {
+	case m.ResourceID <= 0:
+		return snapshotUnresolved
+	case m.Type == "total_mb" || m.Type == "used_mb" || m.Type == "free_mb":
+		return snapshotPVC
+	default:
+		return snapshotPod
+	}
+}
+
+// handleLiveSnapshot streams the whole ring buffer in the VKS1 format, for
+// external processors mirroring the hot state
+func (s *Server) handleLiveSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	metrics := s.ring.ReadAll()
+
+	type key struct {
+		kind byte
+		id   int64
+	}
+	resIndex := make(map[key]int)
+	var resources []snapshotResource
+	typeIndex := make(map[string]int)
+	var types []string
+	var baseMs int64 = math.MaxInt64
+	sampleRes := make([]int, len(metrics))
+	for i, m := range metrics {
+		k := key{snapshotKind(m), m.ResourceID}
+		ri, ok := resIndex[k]
+		if !ok {
+			ri = len(resources)
+			resIndex[k] = ri
+			resources = append(resources, snapshotResource{kind: k.kind, id: k.id})
+		}
+		sampleRes[i] = ri
+		if _, ok := typeIndex[m.Type]; !ok {
+			typeIndex[m.Type] = len(types)
+			types = append(types, m.Type)
+		}
+		if ms := m.Time.UnixMilli(); ms < baseMs {
+			baseMs = ms
+		}
+	}
+	if len(metrics) == 0 {
+		baseMs = s.clock.Now().UnixMilli()
+	}
+	if err := s.describeSnapshotResources(resources); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Group samples by resource; a counting sort keeps the ring buffer's
+	// per-resource order
+	start := make([]int, len(resources)+1)
+	for _, ri := range sampleRes {
+		start[ri+1]++
+	}
+	for i := 1; i < len(start); i++ {
+		start[i] += start[i-1]
+	}
+	order := make([]int, len(metrics))
+	for i, ri := range sampleRes {
+		order[start[ri]] = i
+		start[ri]++
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Snapshot-Format", snapshotMagic)
+	w.Header().Set("X-Snapshot-Samples", strconv.Itoa(len(metrics)))
+
+	bw := bufio.NewWriter(w)
+	enc := snapshotEncoder{w: bw}
+	bw.WriteString(snapshotMagic)
+	enc.uvarint(uint64(baseMs))
+	enc.uvarint(uint64(len(types)))
+	for _, t := range types {
+		enc.string(t)
+	}
+	enc.uvarint(uint64(len(resources)))
+	for _, res := range resources {
+		bw.WriteByte(res.kind)
+		enc.varint(res.id)
+		enc.string(res.uid)
+		enc.string(res.name)
+		enc.string(res.namespace)
+	}
+	enc.uvarint(uint64(len(metrics)))
+	for _, i := range order {
+		m := metrics[i]
+		enc.uvarint(uint64(sampleRes[i]))
+		enc.uvarint(uint64(typeIndex[m.Type]))
+		enc.uvarint(uint64(m.Time.UnixMilli() - baseMs))
+		enc.float64(m.Value)
+	}
+	bw.Flush()
+}
+
+// describeSnapshotResources fills in uid/name/namespace from the catalog
+func (s *Server) describeSnapshotResources(resources []snapshotResource) error {
+	byKind := map[byte][]int{}
+	for i, res := range resources {
+		if res.kind != snapshotUnresolved {
+			byKind[res.kind] = append(byKind[res.kind], i)
+		}
+	}
+
+	for kind, idx := range byKind {
+		table := "pods"
+		if kind == snapshotPVC {
+			table = "pvcs"
+		}
+		args := make([]interface{}, len(idx))
+		pos := make(map[int64]int, len(idx))
+		for i, ri := range idx {
+			args[i] = resources[ri].id
+			pos[resources[ri].id] = ri
+		}
+
+		rows, err := s.sqlite.Query(`
+			SELECT t.id, t.uid, t.name, ns.name
+			FROM `+table+` t
+			JOIN namespaces ns ON t.namespace_id = ns.id
+			WHERE t.id IN (`+placeholders(len(args))+`)`, args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int64
+			var uid, name, namespace string
+			if err := rows.Scan(&id, &uid, &name, &namespace); err != nil {
+				continue
+			}
+			res := &resources[pos[id]]
+			res.uid, res.name, res.namespace = uid, name, namespace
+		}
+		rows.Close()
+	}
+	return nil
+}
+
+// snapshotEncoder writes the VKS1 primitives
+type snapshotEncoder struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (e *snapshotEncoder) uvarint(v uint64) {
+	n := binary.PutUvarint(e.buf[:], v)
+	e.w.Write(e.buf[:n])
+}
+
+func (e *snapshotEncoder) varint(v int64) {
+	n := binary.PutVarint(e.buf[:], v)
+	e.w.Write(e.buf[:n])
+}
+
+func (e *snapshotEncoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.w.WriteString(s)
+}
+
+func (e *snapshotEncoder) float64(v float64) {
+	binary.LittleEndian.PutUint64(e.buf[:8], math.Float64bits(v))
+	e.w.Write(e.buf[:8])
+}
@@ -0,0 +1,57 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestPodSpecSnapshot verifies the pod detail carries spec fields but no
+// env values or credential args
+func TestPodSpecSnapshot(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		pod := synctest.Pod("default", "api", "node-a", nil)
+		pod.Spec.ServiceAccountName = "api-sa"
+		c := &pod.Spec.Containers[0]
+		c.Image = "registry.local/api:1.2"
+		c.ImagePullPolicy = corev1.PullIfNotPresent
+		c.Args = []string{"serve", "--db-password=hunter2", "--api-token", "abc123", "--workers=4"}
+		c.Env = []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "debug"}, {Name: "DB_URL", Value: "postgres://u:p@db"}}
+		if _, err := env.Client.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pod synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pods WHERE uid = ? AND service_account IS NOT NULL", string(pod.UID))
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+		id, err := env.QueryInt("SELECT id FROM pods WHERE uid = ?", string(pod.UID))
+		if err != nil {
+			return err
+		}
+
+		var detail api.PodDetail
+		if err := env.GetJSON(fmt.Sprintf("/api/v1/pods/%d", id), &detail); err != nil {
+			return err
+		}
+		if detail.Name != "api" || detail.ServiceAccount != "api-sa" || len(detail.Containers) != 1 {
+			return fmt.Errorf("detail = %+v", detail)
+		}
+		got := detail.Containers[0]
+		wantArgs := []string{"serve", "--db-password=<redacted>", "--api-token", "<redacted>", "--workers=4"}
+		if got.Image != c.Image || got.ImagePullPolicy != "IfNotPresent" || fmt.Sprint(got.Args) != fmt.Sprint(wantArgs) ||
+			fmt.Sprint(got.EnvNames) != "[LOG_LEVEL DB_URL]" || got.CPURequestM == nil || *got.CPURequestM != 100 {
+			return fmt.Errorf("container = %+v", got)
+		}
+		if n, err := env.QueryInt("SELECT COUNT(*) FROM pod_containers WHERE args LIKE '%hunter2%' OR env_names LIKE '%postgres%'"); err != nil || n != 0 {
+			return fmt.Errorf("secret values stored (%d rows, %v)", n, err)
+		}
+		return nil
+	})
+}
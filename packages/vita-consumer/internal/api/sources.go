@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// SourcesResponse lists where stored rows came from. Local is this
+// consumer's own source, which stamps the catalog.
+type SourcesResponse struct {
+	Local   *store.Source  `json:"local,omitempty"`
+	Sources []store.Source `json:"sources"`
+}
+
+// SetLocalSource reports src as this consumer's own source
+func (s *Server) SetLocalSource(src store.Source) {
+	s.local = &src
+}
+
+// handleSources lists every source this consumer recorded, plus those only
+// the metrics files list (data restored from another consumer)
+func (s *Server) handleSources(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	recorded, err := s.sqlite.ListSources()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	listed, err := s.duck.ListSources(r.Context())
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	known := make(map[int64]bool, len(recorded))
+	for _, src := range recorded {
+		known[src.ID] = true
+	}
+	for _, src := range listed {
+		if !known[src.ID] {
+			recorded = append(recorded, src)
+		}
+	}
+	writeJSON(w, SourcesResponse{Local: s.local, Sources: recorded})
+}
+
+// describeSources fills in sources the metrics files do not list from the
+// catalog's record
+func (s *Server) describeSources(sources []store.Source) ([]store.Source, error) {
+	var missing []int64
+	for _, src := range sources {
+		if src.ClusterID == "" && src.InstanceID == "" {
+			missing = append(missing, src.ID)
+		}
+	}
+	if len(missing) == 0 {
+		return sources, nil
+	}
+	recorded, err := s.sqlite.SourcesByID(missing)
+	if err != nil {
+		return nil, err
+	}
+	for i, src := range sources {
+		if rec, ok := recorded[src.ID]; ok {
+			sources[i] = rec
+		}
+	}
+	return sources, nil
+}
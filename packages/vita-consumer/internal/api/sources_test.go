@@ -0,0 +1,126 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/ingest"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/persist"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/provenance"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestProvenance verifies catalog rows and stored points carry their
+// source, that series and the catalog feed report it, and that sources
+// listed only by a restored metrics file still resolve
+func TestProvenance(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		sources, err := provenance.New("prod-eu", "consumer-0", env.SQLite, env.Duck, env.Clock)
+		if err != nil {
+			return err
+		}
+		local := sources.Local()
+
+		if _, err := env.Client.CoreV1().Nodes().Create(ctx, synctest.Node("node-a", "4", "8Gi"), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		pod, err := env.Client.CoreV1().Pods("default").Create(ctx, synctest.Pod("default", "web-0", "node-a", nil), metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		var podID int64
+		if err := env.Eventually(synctest.Timeout, "pod stamped", func() (bool, error) {
+			var err error
+			if podID, err = env.QueryInt("SELECT id FROM pods WHERE uid = ?", string(pod.UID)); err != nil || podID == 0 {
+				return false, err
+			}
+			src, err := env.QueryInt("SELECT source_id FROM pods WHERE id = ?", podID)
+			return src == local.ID, err
+		}); err != nil {
+			return err
+		}
+
+		// Points carry the version of the agent that posted them
+		srv := ingest.NewIngestionServer(env.Ring, env.Resolver)
+		srv.SetClock(env.Clock)
+		srv.SetSources(sources)
+		now := env.Clock.Now()
+		slice := "kubepods-pod" + strings.ReplaceAll(string(pod.UID), "-", "_") + ".slice"
+		body := fmt.Sprintf(`{"node": "node-a", "agent_version": "0.2.0", "metrics": [{"type": "container", "pod_id": %q, "key": "mem_mb", "value": 64, "ts": %d}]}`, slice, now.Add(-time.Minute).Unix())
+		rec := httptest.NewRecorder()
+		srv.HandleIngest(rec, httptest.NewRequest(http.MethodPost, "/api/v1/ingest", strings.NewReader(body)))
+		if rec.Code != http.StatusAccepted {
+			return fmt.Errorf("ingest: %d %s", rec.Code, rec.Body.String())
+		}
+		posted := env.Ring.ReadByResource(podID)
+		agent := sources.ID("0.2.0")
+		if len(posted) != 1 || posted[0].SourceID != agent {
+			return fmt.Errorf("buffered points %+v, want one from source %d", posted, agent)
+		}
+		if err := persist.NewDuckDBSink(env.Duck).Write(ctx, posted); err != nil {
+			return err
+		}
+
+		// Points written without a source (rollups, rules) are the consumer's;
+		// a restored file may list a source this consumer never recorded
+		restored := store.Source{ID: store.SourceID("prod-us", "consumer-1", "0.1.0"), ClusterID: "prod-us", InstanceID: "consumer-1", AgentVersion: "0.1.0"}
+		env.Duck.RegisterSource(restored)
+		if err := env.Duck.BatchInsert([]store.MetricPoint{
+			{Time: now.Add(-2 * time.Minute), ResourceID: podID, MetricType: "mem_mb", Value: 60},
+			{Time: now.Add(-3 * time.Minute), ResourceID: podID, MetricType: "mem_mb", Value: 58, SourceID: restored.ID},
+		}); err != nil {
+			return err
+		}
+
+		var series api.SeriesResponse
+		if err := env.GetJSON(fmt.Sprintf("/api/v1/metrics/series?resource=%d&type=mem_mb&from=%d&to=%d", podID, now.Add(-time.Hour).Unix(), now.Unix()), &series); err != nil {
+			return err
+		}
+		got := map[int64]store.Source{}
+		for _, src := range series.Sources {
+			got[src.ID] = src
+		}
+		if len(got) != 3 {
+			return fmt.Errorf("series sources %+v, want 3", series.Sources)
+		}
+		if src := got[agent]; src.ClusterID != "prod-eu" || src.InstanceID != "consumer-0" || src.AgentVersion != "0.2.0" {
+			return fmt.Errorf("agent source %+v", src)
+		}
+		if src := got[local.ID]; src.AgentVersion != "" || src.ClusterID != "prod-eu" {
+			return fmt.Errorf("local source %+v", src)
+		}
+		if src := got[restored.ID]; src.ClusterID != "prod-us" || src.InstanceID != "consumer-1" {
+			return fmt.Errorf("restored source %+v", src)
+		}
+
+		var list api.SourcesResponse
+		if err := env.GetJSON("/api/v1/sources", &list); err != nil {
+			return err
+		}
+		listed := map[int64]bool{}
+		for _, src := range list.Sources {
+			listed[src.ID] = true
+		}
+		if !listed[local.ID] || !listed[agent] || !listed[restored.ID] {
+			return fmt.Errorf("sources %+v, want local, agent and restored", list.Sources)
+		}
+
+		var feed api.CatalogResponse
+		if err := env.GetJSON("/api/v1/catalog", &feed); err != nil {
+			return err
+		}
+		change := findChange(feed.Changes, "pod", func(c store.CatalogChange) bool { return c.ID == podID })
+		if change == nil || change.Source == nil || change.Source.ID != local.ID || change.Source.InstanceID != "consumer-0" {
+			return fmt.Errorf("catalog change for pod %d: %+v", podID, change)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// StatefulSetReplicasResponse lists a StatefulSet's replicas by ordinal
+type StatefulSetReplicasResponse struct {
+	StatefulSetID int64             `json:"statefulset_id"`
+	Replicas      []ReplicaIdentity `json:"replicas"`
+}
+
+// ReplicaIdentity is a stable replica (e.g. web-0) and every pod that has
+// filled it, oldest first. Series for it are queried with
+// /api/v1/metrics/series?statefulset=&ordinal=.
+type ReplicaIdentity struct {
+	Ordinal int          `json:"ordinal"`
+	Name    string       `json:"name"`
+	Pods    []ReplicaPod `json:"pods"`
+}
+
+type ReplicaPod struct {
+	ID   int64  `json:"id"`
+	UID  string `json:"uid"`
+	Node string `json:"node"`
+}
+
+// handleStatefulSetReplicas serves /api/v1/statefulsets/replicas?statefulset=.
+// Pods of StatefulSets previously deleted under the same name are included.
+func (s *Server) handleStatefulSetReplicas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stsID, ok := getQueryInt(r, "statefulset")
+	if !ok {
+		writeError(w, "statefulset parameter is required", http.StatusBadRequest)
+		return
+	}
+	pods, err := s.sqlite.StatefulSetReplicas(stsID)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := StatefulSetReplicasResponse{StatefulSetID: stsID, Replicas: []ReplicaIdentity{}}
+	for _, p := range pods {
+		n := len(resp.Replicas)
+		if n == 0 || resp.Replicas[n-1].Ordinal != p.Ordinal {
+			resp.Replicas = append(resp.Replicas, ReplicaIdentity{Ordinal: p.Ordinal})
+			n++
+		}
+		// The latest pod names the replica
+		resp.Replicas[n-1].Name = p.Name
+		resp.Replicas[n-1].Pods = append(resp.Replicas[n-1].Pods, ReplicaPod{ID: p.PodID, UID: p.UID, Node: p.Node})
+	}
+	writeJSON(w, resp)
+}
+
+// replicaIdentity reads the statefulset and ordinal parameters
+func replicaIdentity(r *http.Request) (stsID int64, ordinal int, ok bool) {
+	stsID, ok = getQueryInt(r, "statefulset")
+	if !ok {
+		return 0, 0, false
+	}
+	ordinal, err := strconv.Atoi(r.URL.Query().Get("ordinal"))
+	if err != nil || ordinal < 0 {
+		return 0, 0, false
+	}
+	return stsID, ordinal, true
+}
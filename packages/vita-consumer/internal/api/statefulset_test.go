@@ -0,0 +1,98 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestStatefulSetReplicas replaces a StatefulSet pod: its replacement
+// keeps the ordinal and replica history spans both pods
+func TestStatefulSetReplicas(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		sts := synctest.StatefulSet("default", "db", 2)
+		if _, err := env.Client.AppsV1().StatefulSets("default").Create(ctx, sts, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "statefulset synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM statefulsets WHERE uid = ?", string(sts.UID))
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+		stsID, err := env.QueryInt("SELECT id FROM statefulsets WHERE uid = ?", string(sts.UID))
+		if err != nil {
+			return err
+		}
+
+		podID := func(pod *corev1.Pod) (int64, error) {
+			if err := env.Eventually(synctest.Timeout, pod.Name+" synced", func() (bool, error) {
+				n, err := env.QueryInt("SELECT COUNT(*) FROM pods WHERE uid = ? AND ordinal IS NOT NULL", string(pod.UID))
+				return n == 1, err
+			}); err != nil {
+				return 0, err
+			}
+			return env.QueryInt("SELECT id FROM pods WHERE uid = ?", string(pod.UID))
+		}
+		var ids []int64
+		for _, pod := range []*corev1.Pod{synctest.StatefulPod(sts, 0, "node-a"), synctest.StatefulPod(sts, 1, "node-a")} {
+			if _, err := env.Client.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+			id, err := podID(pod)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+
+		// db-0 is rescheduled: same name, new UID
+		if err := env.Client.CoreV1().Pods("default").Delete(ctx, "db-0", metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+		replacement := synctest.StatefulPod(sts, 0, "node-b")
+		if _, err := env.Client.CoreV1().Pods("default").Create(ctx, replacement, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		newID, err := podID(replacement)
+		if err != nil {
+			return err
+		}
+
+		var replicas api.StatefulSetReplicasResponse
+		if err := env.GetJSON(fmt.Sprintf("/api/v1/statefulsets/replicas?statefulset=%d", stsID), &replicas); err != nil {
+			return err
+		}
+		if len(replicas.Replicas) != 2 || replicas.Replicas[0].Name != "db-0" || len(replicas.Replicas[0].Pods) != 2 || len(replicas.Replicas[1].Pods) != 1 {
+			return fmt.Errorf("replicas = %+v, want db-0 with 2 pods and db-1 with 1", replicas.Replicas)
+		}
+
+		now := env.Clock.Now().Truncate(time.Second)
+		if err := env.Duck.BatchInsert([]store.MetricPoint{
+			{Time: now.Add(-20 * time.Minute), ResourceID: ids[0], MetricType: "mem_mb", Value: 100},
+			{Time: now.Add(-15 * time.Minute), ResourceID: ids[1], MetricType: "mem_mb", Value: 300},
+			{Time: now.Add(-10 * time.Minute), ResourceID: newID, MetricType: "mem_mb", Value: 200},
+		}); err != nil {
+			return err
+		}
+		var series api.SeriesResponse
+		if err := env.GetJSON(fmt.Sprintf("/api/v1/metrics/series?statefulset=%d&ordinal=0&type=mem_mb&from=%d&to=%d",
+			stsID, now.Add(-time.Hour).Unix(), now.Unix()), &series); err != nil {
+			return err
+		}
+		if len(series.Points) != 2 || series.Points[0].V != 100 || series.Points[1].V != 200 {
+			return fmt.Errorf("db-0 series = %+v, want 100 then 200", series.Points)
+		}
+		if series.ResourceID != newID {
+			return fmt.Errorf("db-0 series resource = %d, want current pod %d", series.ResourceID, newID)
+		}
+		return nil
+	})
+}
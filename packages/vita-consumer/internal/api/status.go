@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/syncer"
+)
+
+// ClusterStatus reports the syncer's link to the Kubernetes API
+type ClusterStatus interface {
+	Status() syncer.Status
+}
+
+// StatusResponse tells the UI whether the catalog is live. While the
+// Kubernetes API is not synced, resources are served as last stored and
+// metrics of pods started since are not resolved.
+type StatusResponse struct {
+	Kubernetes syncer.Status `json:"kubernetes"`
+	Degraded   bool          `json:"degraded"`
+}
+
+// handleStatus serves GET /api/v1/status
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	st := s.status.Status()
+	writeJSON(w, StatusResponse{Kubernetes: st, Degraded: st.State != syncer.StateSynced})
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/syncer"
+)
+
+// Alerts the status page raises: the conditions the consumer can check
+// itself, and SLO burn alerts when SLOs are tracked.
+const (
+	alertNodesNotReady    = "nodes_not_ready"
+	alertIngestStale      = "ingest_stale"
+	alertClusterNotSynced = "kubernetes_not_synced"
+	alertDiskLow          = "disk_low"
+	alertSLOBurn          = "slo_burn"
+)
+
+// DiskGuard reports whether local storage is too full to accept data
+type DiskGuard interface {
+	Low() bool
+}
+
+// StatusPage configures the public /status page
+type StatusPage struct {
+	// CacheFor is how long one summary is served, to the server and to
+	// clients; wallboards polling the page never reach the stores faster
+	CacheFor time.Duration
+	// Disk, when set, raises disk_low
+	Disk DiskGuard
+}
+
+// PublicStatus summarizes cluster health without naming any resource
+type PublicStatus struct {
+	Status       string   `json:"status"` // "ok" or "degraded"
+	GeneratedAt  int64    `json:"generated_at"`
+	NodesReady   int      `json:"nodes_ready"`
+	NodesTotal   int      `json:"nodes_total"`
+	FiringAlerts int      `json:"firing_alerts"`
+	Alerts       []string `json:"alerts"`
+	// LastIngest is the unix time of the newest sample; absent until one
+	// arrives or when freshness is not tracked
+	LastIngest  *int64 `json:"last_ingest,omitempty"`
+	IngestFresh bool   `json:"ingest_fresh"`
+}
+
+// statusPageCache holds the last computed summary
+type statusPageCache struct {
+	mu   sync.Mutex
+	page *PublicStatus
+	at   time.Time
+}
+
+// SetStatusPage enables the unauthenticated /status page
+func (s *Server) SetStatusPage(p StatusPage) {
+	s.statusPage = &p
+}
+
+// handleStatusPage serves GET /status, as HTML for browsers (or
+// ?format=html) and JSON otherwise
+func (s *Server) handleStatusPage(w http.ResponseWriter, r *http.Request) {
+	page, err := s.publicStatus()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	maxAge := int(s.statusPage.CacheFor.Seconds())
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	if r.URL.Query().Get("format") == "html" || strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		statusPageHTML.Execute(w, struct {
+			*PublicStatus
+			Refresh    int
+			LastIngest string
+		}{page, max(maxAge, 5), lastIngestText(page)})
+		return
+	}
+	writeJSON(w, page)
+}
+
+// publicStatus returns the cached summary, recomputing it once CacheFor
+// has passed
+func (s *Server) publicStatus() (*PublicStatus, error) {
+	c := &s.statusCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := s.clock.Now()
+	if c.page != nil && now.Sub(c.at) < s.statusPage.CacheFor {
+		return c.page, nil
+	}
+
+	page := &PublicStatus{Status: "ok", GeneratedAt: now.Unix(), Alerts: []string{}}
+	var err error
+	if page.NodesReady, page.NodesTotal, err = s.sqlite.NodeReadiness(); err != nil {
+		return nil, err
+	}
+	if page.NodesReady < page.NodesTotal {
+		page.Alerts = append(page.Alerts, alertNodesNotReady)
+	}
+	if s.seen != nil {
+		at, ok := s.seen.Latest()
+		if ok {
+			unix := at.Unix()
+			page.LastIngest = &unix
+		}
+		page.IngestFresh = !s.seen.StaleAt(at, ok, staleAfter)
+		if !page.IngestFresh {
+			page.Alerts = append(page.Alerts, alertIngestStale)
+		}
+	}
+	if s.status != nil && s.status.Status().State != syncer.StateSynced {
+		page.Alerts = append(page.Alerts, alertClusterNotSynced)
+	}
+	if s.statusPage.Disk != nil && s.statusPage.Disk.Low() {
+		page.Alerts = append(page.Alerts, alertDiskLow)
+	}
+	burning, err := s.sqlite.CountSLOAlerts()
+	if err != nil {
+		return nil, err
+	}
+	if burning > 0 {
+		page.Alerts = append(page.Alerts, alertSLOBurn)
+	}
+	page.FiringAlerts = len(page.Alerts)
+	if page.FiringAlerts > 0 {
+		page.Status = "degraded"
+	}
+
+	c.page, c.at = page, now
+	return page, nil
+}
+
+func lastIngestText(p *PublicStatus) string {
+	if p.LastIngest == nil {
+		return "never"
+	}
+	return time.Unix(*p.LastIngest, 0).UTC().Format(time.RFC3339)
+}
+
+var statusPageHTML = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>Cluster status: {{.Status}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.ok { color: #1a7f37; } .degraded { color: #cf222e; }
+td { padding: 0.2em 1em 0.2em 0; }
+</style>
+</head>
+<body>
+<h1 class="{{.Status}}">{{.Status}}</h1>
+<table>
+<tr><td>Nodes ready</td><td>{{.NodesReady}} / {{.NodesTotal}}</td></tr>
+<tr><td>Firing alerts</td><td>{{.FiringAlerts}}{{range .Alerts}} &middot; {{.}}{{end}}</td></tr>
+<tr><td>Last ingest</td><td>{{.LastIngest}}{{if not .IngestFresh}} (stale){{end}}</td></tr>
+</table>
+</body>
+</html>
+`))
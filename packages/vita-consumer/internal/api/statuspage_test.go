@@ -0,0 +1,85 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestStatusPage counts ready nodes on the public page, serves the cached
+// summary until it expires, and renders HTML for browsers
+func TestStatusPage(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		ready := synctest.Node("node-a", "4", "8Gi")
+		ready.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+		notReady := synctest.Node("node-b", "4", "8Gi")
+		for _, n := range []*corev1.Node{ready, notReady} {
+			if _, err := env.Client.CoreV1().Nodes().Create(ctx, n, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		if err := env.Eventually(synctest.Timeout, "nodes synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM nodes WHERE ready IS NOT NULL")
+			return n == 2, err
+		}); err != nil {
+			return err
+		}
+
+		var page api.PublicStatus
+		if err := env.GetJSON("/status", &page); err != nil {
+			return err
+		}
+		if page.Status != "degraded" || page.NodesReady != 1 || page.NodesTotal != 2 ||
+			page.FiringAlerts != 1 || page.Alerts[0] != "nodes_not_ready" || !page.IngestFresh {
+			return fmt.Errorf("page = %+v, want 1/2 nodes ready and nodes_not_ready firing", page)
+		}
+
+		notReady.Status.Conditions = ready.Status.Conditions
+		if _, err := env.Client.CoreV1().Nodes().Update(ctx, notReady, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "node-b ready", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM nodes WHERE ready = 1")
+			return n == 2, err
+		}); err != nil {
+			return err
+		}
+		if err := env.GetJSON("/status", &page); err != nil {
+			return err
+		}
+		if page.NodesReady != 1 {
+			return fmt.Errorf("cached page recomputed early: %+v", page)
+		}
+		env.Clock.Advance(31 * time.Second)
+		if err := env.GetJSON("/status", &page); err != nil {
+			return err
+		}
+		if page.Status != "ok" || page.NodesReady != 2 || page.FiringAlerts != 0 {
+			return fmt.Errorf("page after expiry = %+v, want ok with 2/2 ready", page)
+		}
+
+		resp, err := http.Get(env.API.URL + "/status?format=html")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") ||
+			!strings.Contains(string(body), "2 / 2") || resp.Header.Get("Cache-Control") != "public, max-age=30" {
+			return fmt.Errorf("html page: %s %q\n%s", ct, resp.Header.Get("Cache-Control"), body)
+		}
+		return nil
+	})
+}
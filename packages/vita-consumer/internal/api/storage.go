@@ -0,0 +1,260 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// usageWindow is how far back a claim's latest used_mb sample may be
+const usageWindow = time.Hour
+
+// StorageClass is a StorageClass with what is provisioned from it
+type StorageClass struct {
+	ID             int64   `json:"id"`
+	Name           string  `json:"name"`
+	UID            string  `json:"uid"`
+	Provisioner    string  `json:"provisioner"`
+	ReclaimPolicy  *string `json:"reclaim_policy,omitempty"`
+	BindingMode    *string `json:"binding_mode,omitempty"`
+	AllowExpansion bool    `json:"allow_expansion"`
+	Default        bool    `json:"default"`
+	Volumes        int     `json:"volumes"`
+	Claims         int     `json:"claims"`
+	CapacityMB     float64 `json:"capacity_mb"` // sum over its volumes
+}
+
+// PersistentVolume is a PersistentVolume with the claim bound to it
+type PersistentVolume struct {
+	ID             int64    `json:"id"`
+	Name           string   `json:"name"`
+	UID            string   `json:"uid"`
+	StorageClass   *string  `json:"storage_class,omitempty"`
+	Provisioner    *string  `json:"provisioner,omitempty"`
+	Driver         *string  `json:"driver,omitempty"`
+	CapacityMB     float64  `json:"capacity_mb"`
+	ReclaimPolicy  string   `json:"reclaim_policy"`
+	Phase          string   `json:"phase"`
+	AccessModes    []string `json:"access_modes"`
+	ClaimNamespace *string  `json:"claim_namespace,omitempty"`
+	ClaimName      *string  `json:"claim_name,omitempty"`
+	ClaimID        *int64   `json:"claim_id,omitempty"`
+}
+
+func (s *Server) handleListStorageClasses(w http.ResponseWriter, r *http.Request) {
+	serveList(s, w, r, "storageclass", func(id int64) ([]StorageClass, error) { return s.queryStorageClasses(id) })
+}
+
+func (s *Server) handleListPersistentVolumes(w http.ResponseWriter, r *http.Request) {
+	serveList(s, w, r, "pv", func(id int64) ([]PersistentVolume, error) { return s.queryPersistentVolumes(r, id) })
+}
+
+func (s *Server) queryStorageClasses(id int64) ([]StorageClass, error) {
+	query := `
+		SELECT sc.id, sc.name, sc.uid, sc.provisioner, sc.reclaim_policy, sc.binding_mode, sc.allow_expansion, sc.is_default,
+			(SELECT COUNT(*) FROM persistent_volumes pv WHERE pv.storage_class = sc.name),
+			(SELECT COUNT(*) FROM pvcs WHERE pvcs.storage_class = sc.name AND pvcs.deleted_at IS NULL),
+			(SELECT COALESCE(SUM(pv.capacity_mb), 0) FROM persistent_volumes pv WHERE pv.storage_class = sc.name)
+		FROM storage_classes sc
+		WHERE 1=1
+	`
+	args := []interface{}{}
+
+	if id > 0 {
+		query += " AND sc.id = ?"
+		args = append(args, id)
+	}
+
+	query += " ORDER BY sc.name"
+
+	rows, err := s.sqlite.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	classes := []StorageClass{}
+	for rows.Next() {
+		var c StorageClass
+		if err := rows.Scan(&c.ID, &c.Name, &c.UID, &c.Provisioner, &c.ReclaimPolicy, &c.BindingMode, &c.AllowExpansion, &c.Default,
+			&c.Volumes, &c.Claims, &c.CapacityMB); err != nil {
+			continue
+		}
+		classes = append(classes, c)
+	}
+	return classes, nil
+}
+
+// queryPersistentVolumes lists volumes, optionally by ?storage_class= and ?phase=
+func (s *Server) queryPersistentVolumes(r *http.Request, id int64) ([]PersistentVolume, error) {
+	query := `
+		SELECT pv.id, pv.name, pv.uid, pv.storage_class, sc.provisioner, NULLIF(pv.driver, ''),
+			COALESCE(pv.capacity_mb, 0), COALESCE(pv.reclaim_policy, ''), COALESCE(pv.phase, ''), COALESCE(pv.access_modes, ''),
+			pv.claim_namespace, pv.claim_name, pvc.id
+		FROM persistent_volumes pv
+		LEFT JOIN storage_classes sc ON sc.name = pv.storage_class
+		LEFT JOIN namespaces ns ON ns.name = pv.claim_namespace
+		LEFT JOIN pvcs pvc ON pvc.namespace_id = ns.id AND pvc.name = pv.claim_name AND pvc.deleted_at IS NULL
+		WHERE 1=1
+	`
+	args := []interface{}{}
+
+	if sc := r.URL.Query().Get("storage_class"); sc != "" {
+		query += " AND pv.storage_class = ?"
+		args = append(args, sc)
+	}
+	if phase := r.URL.Query().Get("phase"); phase != "" {
+		query += " AND pv.phase = ?"
+		args = append(args, phase)
+	}
+	if id > 0 {
+		query += " AND pv.id = ?"
+		args = append(args, id)
+	}
+
+	query += " ORDER BY pv.name"
+
+	rows, err := s.sqlite.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	volumes := []PersistentVolume{}
+	for rows.Next() {
+		var v PersistentVolume
+		var modes string
+		if err := rows.Scan(&v.ID, &v.Name, &v.UID, &v.StorageClass, &v.Provisioner, &v.Driver,
+			&v.CapacityMB, &v.ReclaimPolicy, &v.Phase, &modes,
+			&v.ClaimNamespace, &v.ClaimName, &v.ClaimID); err != nil {
+			continue
+		}
+		v.AccessModes = []string{}
+		if modes != "" {
+			v.AccessModes = strings.Split(modes, ",")
+		}
+		volumes = append(volumes, v)
+	}
+	return volumes, nil
+}
+
+// StorageUsageResponse groups live claims by storage class or provisioner
+type StorageUsageResponse struct {
+	By     string              `json:"by"`
+	Groups []StorageUsageGroup `json:"groups"`
+}
+
+// StorageUsageGroup sums the claims of one class or provisioner. UsedMB
+// covers the Reporting claims, those with a used_mb sample in the last
+// hour; UsedPct compares it with their capacity only.
+type StorageUsageGroup struct {
+	Key         string   `json:"key"`
+	Provisioner *string  `json:"provisioner,omitempty"` // by=storage_class only
+	Claims      int      `json:"claims"`
+	RequestedMB float64  `json:"requested_mb"`
+	CapacityMB  float64  `json:"capacity_mb"`
+	UsedMB      float64  `json:"used_mb"`
+	Reporting   int      `json:"reporting"`
+	UsedPct     *float64 `json:"used_pct,omitempty"`
+}
+
+// unclassified is the group of claims with no storage class
+const unclassified = "(none)"
+
+// handleStorageUsage serves /api/v1/storage/usage[?by=storage_class|provisioner&namespace=].
+// A claim's class falls back to its bound volume's, and its capacity to the
+// volume's when the claim status has none yet.
+func (s *Server) handleStorageUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		by = "storage_class"
+	}
+	if by != "storage_class" && by != "provisioner" {
+		writeError(w, "by must be storage_class or provisioner", http.StatusBadRequest)
+		return
+	}
+
+	query := `
+		SELECT pvc.id, COALESCE(pvc.storage_class, pv.storage_class), sc.provisioner,
+			COALESCE(pvc.requested_mb, 0), COALESCE(pvc.capacity_mb, pv.capacity_mb, 0)
+		FROM pvcs pvc
+		LEFT JOIN persistent_volumes pv ON pv.name = pvc.volume_name
+		LEFT JOIN storage_classes sc ON sc.name = COALESCE(pvc.storage_class, pv.storage_class)
+		WHERE pvc.deleted_at IS NULL
+	`
+	args := []interface{}{}
+	if nsID, ok := getQueryInt(r, "namespace"); ok {
+		query += " AND pvc.namespace_id = ?"
+		args = append(args, nsID)
+	}
+
+	used, err := s.sqlite.LatestValues(s.now(r).Add(-usageWindow), "pvc", "used_mb")
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := s.sqlite.Query(query, args...)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	groups := map[string]*StorageUsageGroup{}
+	reportedCap := map[string]float64{}
+	for rows.Next() {
+		var id int64
+		var class, provisioner *string
+		var requested, capacity float64
+		if err := rows.Scan(&id, &class, &provisioner, &requested, &capacity); err != nil {
+			continue
+		}
+
+		key := unclassified
+		if by == "storage_class" && class != nil {
+			key = *class
+		} else if by == "provisioner" && provisioner != nil {
+			key = *provisioner
+		}
+		g := groups[key]
+		if g == nil {
+			g = &StorageUsageGroup{Key: key}
+			if by == "storage_class" {
+				g.Provisioner = provisioner
+			}
+			groups[key] = g
+		}
+		g.Claims++
+		g.RequestedMB += requested
+		g.CapacityMB += capacity
+		if v, ok := used[id]; ok {
+			g.UsedMB += v
+			g.Reporting++
+			reportedCap[key] += capacity
+		}
+	}
+
+	resp := StorageUsageResponse{By: by, Groups: make([]StorageUsageGroup, 0, len(groups))}
+	for key, g := range groups {
+		if c := reportedCap[key]; c > 0 {
+			pct := g.UsedMB / c * 100
+			g.UsedPct = &pct
+		}
+		resp.Groups = append(resp.Groups, *g)
+	}
+	sort.Slice(resp.Groups, func(i, j int) bool {
+		if resp.Groups[i].CapacityMB != resp.Groups[j].CapacityMB {
+			return resp.Groups[i].CapacityMB > resp.Groups[j].CapacityMB
+		}
+		return resp.Groups[i].Key < resp.Groups[j].Key
+	})
+
+	writeJSON(w, resp)
+}
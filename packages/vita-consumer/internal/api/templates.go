@@ -0,0 +1,384 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+const (
+	maxTemplateBytes     = 64 << 10
+	maxTemplateParams    = 16
+	maxTemplateParamSize = 256
+)
+
+var (
+	templateNameRegex  = regexp.MustCompile(`^[a-z0-9_.-]{1,64}$`)
+	templateParamRegex = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+)
+
+// TemplateParam declares a parameter of a query template. Type is "int" or
+// "string"; Enum limits a string to the listed values. A parameter without
+// a default must be given on every run.
+type TemplateParam struct {
+	Name        string          `json:"name"`
+	Type        string          `json:"type"`
+	Description string          `json:"description,omitempty"`
+	Default     json.RawMessage `json:"default,omitempty"`
+	Enum        []string        `json:"enum,omitempty"`
+}
+
+// QueryTemplateRequest is the body of PUT /api/v1/query-templates/{name}.
+// Queries are BatchQuerySpecs, each with a unique id, in which any string
+// value "$param" is replaced by that parameter's value, keeping its type:
+//
+//	{"params": [{"name": "namespace", "type": "int"}],
+//	 "queries": [{"id": "memory", "type": "ns_memory", "resource": "$namespace"}]}
+//
+// A string that starts with "$$" stands for itself less one "$".
+type QueryTemplateRequest struct {
+	Description string          `json:"description,omitempty"`
+	Params      []TemplateParam `json:"params"`
+	Queries     json.RawMessage `json:"queries"`
+}
+
+// RunTemplateRequest is the body of POST /api/v1/query-templates/{name}/run.
+// From/To and TimeoutMs are as in BatchQueryRequest.
+type RunTemplateRequest struct {
+	Params    map[string]json.RawMessage `json:"params,omitempty"`
+	From      int64                      `json:"from,omitempty"`
+	To        int64                      `json:"to,omitempty"`
+	TimeoutMs int64                      `json:"timeout_ms,omitempty"`
+}
+
+// handleListQueryTemplates serves GET /api/v1/query-templates
+func (s *Server) handleListQueryTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := s.sqlite.ListQueryTemplates()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, templates)
+}
+
+// handleGetQueryTemplate serves GET /api/v1/query-templates/{name}
+func (s *Server) handleGetQueryTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.queryTemplate(w, r.PathValue("name"))
+	if ok {
+		writeJSON(w, t)
+	}
+}
+
+// handlePutQueryTemplate creates or replaces a template after checking
+// that its queries bind with every parameter and run as batch queries.
+// Templates are shared: anyone who may call the API may change them.
+func (s *Server) handlePutQueryTemplate(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !templateNameRegex.MatchString(name) {
+		writeError(w, "invalid template name", http.StatusBadRequest)
+		return
+	}
+	var req QueryTemplateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTemplateBytes)).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := validateTemplate(req); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	params, _ := json.Marshal(req.Params)
+	var queries bytes.Buffer
+	json.Compact(&queries, req.Queries)
+	t := store.QueryTemplate{
+		Name:        name,
+		Description: req.Description,
+		Params:      params,
+		Queries:     queries.Bytes(),
+		UpdatedAt:   s.clock.Now().UTC().Truncate(time.Second),
+	}
+	if p := PrincipalFrom(r.Context()); p.Authenticated() {
+		t.UpdatedBy = p.Name
+	}
+	created, err := s.sqlite.PutQueryTemplate(t)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if created {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(t)
+		return
+	}
+	writeJSON(w, t)
+}
+
+// handleDeleteQueryTemplate serves DELETE /api/v1/query-templates/{name}
+func (s *Server) handleDeleteQueryTemplate(w http.ResponseWriter, r *http.Request) {
+	found, err := s.sqlite.DeleteQueryTemplate(r.PathValue("name"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		writeError(w, "template not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRunQueryTemplate binds the given parameters into a template's
+// queries and answers like /api/v1/metrics/query
+func (s *Server) handleRunQueryTemplate(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.queryTemplate(w, r.PathValue("name"))
+	if !ok {
+		return
+	}
+	var req RunTemplateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	var params []TemplateParam
+	if err := json.Unmarshal(t.Params, &params); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	queries, err := bindTemplate(params, t.Queries, req.Params)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	from, to := s.batchRange(r, req.From, req.To)
+	writeJSON(w, BatchQueryResponse{Results: s.runBatch(r.Context(), queries, from, to, req.TimeoutMs)})
+}
+
+func (s *Server) queryTemplate(w http.ResponseWriter, name string) (store.QueryTemplate, bool) {
+	t, err := s.sqlite.GetQueryTemplate(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, "template not found", http.StatusNotFound)
+		return t, false
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return t, false
+	}
+	return t, true
+}
+
+// validateTemplate checks the declared parameters, that the queries use
+// each of them and nothing else, and that they bind into batch queries
+// with unique ids
+func validateTemplate(req QueryTemplateRequest) error {
+	if len(req.Params) > maxTemplateParams {
+		return fmt.Errorf("at most %d params", maxTemplateParams)
+	}
+	sample := make(map[string]json.RawMessage, len(req.Params))
+	for _, p := range req.Params {
+		if !templateParamRegex.MatchString(p.Name) {
+			return fmt.Errorf("invalid param name %q", p.Name)
+		}
+		if _, dup := sample[p.Name]; dup {
+			return fmt.Errorf("param %q is declared twice", p.Name)
+		}
+		switch {
+		case p.Type == "int" && len(p.Enum) == 0:
+			sample[p.Name] = json.RawMessage("1")
+		case p.Type == "string" && len(p.Enum) == 0:
+			sample[p.Name] = json.RawMessage(`"x"`)
+		case p.Type == "string":
+			sample[p.Name], _ = json.Marshal(p.Enum[0])
+		case p.Type == "int":
+			return fmt.Errorf("param %q: enum only applies to strings", p.Name)
+		default:
+			return fmt.Errorf("param %q: type must be int or string", p.Name)
+		}
+		if p.Default != nil {
+			if _, err := bindParam(p, p.Default); err != nil {
+				return fmt.Errorf("default of %w", err)
+			}
+		}
+	}
+
+	var doc interface{}
+	if err := decodeNumbers(req.Queries, &doc); err != nil {
+		return fmt.Errorf("queries: %w", err)
+	}
+	list, ok := doc.([]interface{})
+	if !ok || len(list) == 0 {
+		return errors.New("queries must be a non-empty array")
+	}
+	if len(list) > maxBatchQueries {
+		return errors.New("too many queries")
+	}
+	used := map[string]bool{}
+	if err := walkTemplate(doc, func(name string) (interface{}, error) {
+		used[name] = true
+		return nil, nil
+	}); err != nil {
+		return err
+	}
+	for _, p := range req.Params {
+		if !used[p.Name] {
+			return fmt.Errorf("param %q is not used by any query", p.Name)
+		}
+	}
+
+	queries, err := bindTemplate(req.Params, req.Queries, sample)
+	if err != nil {
+		return err
+	}
+	ids := map[string]bool{}
+	for i, q := range queries {
+		if q.ID == "" || ids[q.ID] {
+			return fmt.Errorf("query %d: id must be set and unique", i)
+		}
+		ids[q.ID] = true
+		if q.Type == "" {
+			return fmt.Errorf("query %q: type is required", q.ID)
+		}
+	}
+	return nil
+}
+
+// bindTemplate replaces the parameter references in queries with given
+// values, or parameter defaults, and decodes the result. Unknown,
+// missing and mistyped parameters are errors.
+func bindTemplate(params []TemplateParam, queries json.RawMessage, given map[string]json.RawMessage) ([]BatchQuerySpec, error) {
+	values := make(map[string]interface{}, len(params))
+	for _, p := range params {
+		raw, ok := given[p.Name]
+		if !ok {
+			raw = p.Default
+		}
+		if raw == nil {
+			return nil, fmt.Errorf("param %q is required", p.Name)
+		}
+		v, err := bindParam(p, raw)
+		if err != nil {
+			return nil, err
+		}
+		values[p.Name] = v
+	}
+	for name := range given {
+		if _, ok := values[name]; !ok {
+			return nil, fmt.Errorf("unknown param %q", name)
+		}
+	}
+
+	var doc interface{}
+	if err := decodeNumbers(queries, &doc); err != nil {
+		return nil, err
+	}
+	err := walkTemplate(doc, func(name string) (interface{}, error) {
+		v, ok := values[name]
+		if !ok {
+			return nil, fmt.Errorf("queries use undeclared param %q", name)
+		}
+		return v, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	bound, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var out []BatchQuerySpec
+	dec := json.NewDecoder(bytes.NewReader(bound))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&out); err != nil {
+		return nil, fmt.Errorf("queries: %w", err)
+	}
+	return out, nil
+}
+
+// bindParam checks a parameter value against its declaration. Ints may
+// also be given as decimal strings, as query builders tend to send them.
+func bindParam(p TemplateParam, raw json.RawMessage) (interface{}, error) {
+	var v interface{}
+	if err := decodeNumbers(raw, &v); err != nil {
+		return nil, fmt.Errorf("param %q: %w", p.Name, err)
+	}
+	switch p.Type {
+	case "int":
+		var n int64
+		var err error
+		switch v := v.(type) {
+		case json.Number:
+			n, err = v.Int64()
+		case string:
+			n, err = strconv.ParseInt(v, 10, 64)
+		default:
+			err = errors.New("not a number")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("param %q must be an integer", p.Name)
+		}
+		return n, nil
+	default:
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("param %q must be a string", p.Name)
+		}
+		if len(str) > maxTemplateParamSize {
+			return nil, fmt.Errorf("param %q is too long", p.Name)
+		}
+		if len(p.Enum) > 0 && !slices.Contains(p.Enum, str) {
+			return nil, fmt.Errorf("param %q must be one of %s", p.Name, strings.Join(p.Enum, ", "))
+		}
+		return str, nil
+	}
+}
+
+// walkTemplate replaces every "$name" string in doc, in place, with what
+// bind returns for name, and unescapes "$$"
+func walkTemplate(doc interface{}, bind func(name string) (interface{}, error)) error {
+	replace := func(v interface{}) (interface{}, error) {
+		str, ok := v.(string)
+		switch {
+		case !ok || !strings.HasPrefix(str, "$"):
+			return v, walkTemplate(v, bind)
+		case strings.HasPrefix(str, "$$"):
+			return str[1:], nil
+		default:
+			return bind(str[1:])
+		}
+	}
+	var err error
+	switch doc := doc.(type) {
+	case map[string]interface{}:
+		for k, v := range doc {
+			if doc[k], err = replace(v); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, v := range doc {
+			if doc[i], err = replace(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func decodeNumbers(raw []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
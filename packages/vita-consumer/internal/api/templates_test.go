@@ -0,0 +1,120 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+)
+
+// Templates are validated on save, bind typed parameters with defaults on
+// run and answer like the batch query API
+func TestQueryTemplates(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		const pod = 979001
+		now := env.Clock.Now()
+		if err := env.Duck.BatchInsert([]store.MetricPoint{
+			{Time: now.Add(-10 * time.Minute), ResourceID: pod, MetricType: "mem_mb", Value: 100},
+			{Time: now.Add(-5 * time.Minute), ResourceID: pod, MetricType: "mem_mb", Value: 200},
+			{Time: now.Add(-5 * time.Minute), ResourceID: pod, MetricType: "cpu_ms", Value: 7},
+		}); err != nil {
+			return err
+		}
+
+		call := func(method, path, body string) (int, []byte, error) {
+			req, err := http.NewRequestWithContext(ctx, method, env.API.URL+path, strings.NewReader(body))
+			if err != nil {
+				return 0, nil, err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return 0, nil, err
+			}
+			defer resp.Body.Close()
+			out, err := io.ReadAll(resp.Body)
+			return resp.StatusCode, out, err
+		}
+
+		for _, bad := range []string{
+			`{"params": [], "queries": [{"id": "m", "type": "mem_mb", "resource": "$pod"}]}`,
+			`{"params": [{"name": "pod", "type": "int"}], "queries": [{"id": "m", "type": "mem_mb", "resource": 1}]}`,
+			`{"params": [{"name": "pod", "type": "string"}], "queries": [{"id": "m", "type": "mem_mb", "resource": "$pod"}]}`,
+			`{"params": [], "queries": [{"id": "m", "type": "mem_mb", "resourse": 1}]}`,
+			`{"params": [], "queries": [{"id": "m", "type": "mem_mb"}, {"id": "m", "type": "cpu_ms"}]}`,
+		} {
+			code, body, err := call(http.MethodPut, "/api/v1/query-templates/bad", bad)
+			if err != nil {
+				return err
+			}
+			if code != http.StatusBadRequest {
+				return fmt.Errorf("template %s saved with HTTP %d: %s", bad, code, body)
+			}
+		}
+
+		template := `{"description": "usage of one pod",
+		"params": [{"name": "pod", "type": "int"}, {"name": "metric", "type": "string", "enum": ["mem_mb", "cpu_ms"], "default": "mem_mb"}],
+		"queries": [{"id": "usage", "type": "$metric", "resource": "$pod"}]}`
+		if code, body, err := call(http.MethodPut, "/api/v1/query-templates/pod-usage", template); err != nil || code != http.StatusCreated {
+			return fmt.Errorf("creating template: HTTP %d %s (%v)", code, body, err)
+		}
+		if code, body, err := call(http.MethodPut, "/api/v1/query-templates/pod-usage", template); err != nil || code != http.StatusOK {
+			return fmt.Errorf("replacing template: HTTP %d %s (%v)", code, body, err)
+		}
+		var list []store.QueryTemplate
+		if err := env.GetJSON("/api/v1/query-templates", &list); err != nil {
+			return err
+		}
+		if len(list) != 1 || list[0].Name != "pod-usage" || list[0].Description != "usage of one pod" {
+			return fmt.Errorf("templates listed as %+v", list)
+		}
+
+		run := func(params string) (int, api.BatchQueryResponse, error) {
+			var out api.BatchQueryResponse
+			code, body, err := call(http.MethodPost, "/api/v1/query-templates/pod-usage/run", `{"params": `+params+`}`)
+			if err == nil && code == http.StatusOK {
+				err = json.Unmarshal(body, &out)
+			}
+			return code, out, err
+		}
+		for params, want := range map[string]float64{
+			fmt.Sprintf(`{"pod": %d}`, pod):                       300,
+			fmt.Sprintf(`{"pod": "%d", "metric": "cpu_ms"}`, pod): 7,
+		} {
+			code, out, err := run(params)
+			if err != nil || code != http.StatusOK {
+				return fmt.Errorf("running with %s: HTTP %d (%v)", params, code, err)
+			}
+			if len(out.Results) != 1 || out.Results[0].ID != "usage" || out.Results[0].Series == nil {
+				return fmt.Errorf("running with %s returned %+v", params, out)
+			}
+			var total float64
+			for _, p := range out.Results[0].Series.Points {
+				total += p.V
+			}
+			if total != want {
+				return fmt.Errorf("running with %s: points %+v, want %v", params, out.Results[0].Series.Points, want)
+			}
+		}
+		for _, params := range []string{`{}`, `{"pod": "web"}`, `{"pod": 1, "metric": "disk_mb"}`, `{"pod": 1, "namespace": 2}`} {
+			if code, _, err := run(params); err != nil || code != http.StatusBadRequest {
+				return fmt.Errorf("running with %s: HTTP %d (%v), want 400", params, code, err)
+			}
+		}
+
+		if code, _, err := call(http.MethodDelete, "/api/v1/query-templates/pod-usage", ""); err != nil || code != http.StatusNoContent {
+			return fmt.Errorf("deleting template: HTTP %d (%v)", code, err)
+		}
+		if code, _, err := run(`{}`); err != nil || code != http.StatusNotFound {
+			return fmt.Errorf("running deleted template: HTTP %d (%v)", code, err)
+		}
+		return nil
+	})
+}
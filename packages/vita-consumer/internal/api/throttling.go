@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+const (
+	// defaultThrottledPct is the share of throttled CFS periods flagged
+	// when ?min_pct= is absent
+	defaultThrottledPct = 25
+	// limitHeadroom is added to peak demand when suggesting a limit
+	limitHeadroom = 1.2
+	// limitStepM rounds suggested limits up to a multiple of 50m
+	limitStepM = 50
+)
+
+// ThrottlingResponse lists pods whose CPU limit throttles them
+type ThrottlingResponse struct {
+	From   int64          `json:"from"`
+	To     int64          `json:"to"`
+	Step   int64          `json:"step"`
+	MinPct float64        `json:"min_pct"`
+	Pods   []ThrottledPod `json:"pods"`
+}
+
+// ThrottledPod is one pod's throttling over the window. Demand is usage
+// plus the time spent throttled, i.e. roughly what the pod would have used
+// without a limit.
+type ThrottledPod struct {
+	PodID            int64   `json:"pod_id"`
+	Pod              string  `json:"pod"`
+	Namespace        string  `json:"namespace"`
+	CPULimitM        *int64  `json:"cpu_limit_m,omitempty"`
+	ThrottledPct     float64 `json:"throttled_pct"`      // throttled periods over the window
+	PeakThrottledPct float64 `json:"peak_throttled_pct"` // worst bucket
+	AvgUsageM        float64 `json:"avg_usage_m"`
+	PeakDemandM      float64 `json:"peak_demand_m"`
+	SuggestedLimitM  *int64  `json:"suggested_limit_m,omitempty"`
+}
+
+// throttleBucket accumulates the counter rates of one pod and bucket
+type throttleBucket struct {
+	usage, throttledMs, periods, throttled float64
+}
+
+// handleThrottling serves /api/v1/analysis/throttling[?hours=&step=&min_pct=&namespace=].
+// hours of history (default 24) are bucketed by step seconds (default
+// 3600); pods with at least min_pct (default 25) of CFS periods throttled
+// are listed, worst first, with a limit suggestion: peak demand plus 20%,
+// and at least the current limit grown by the throttled share.
+func (s *Server) handleThrottling(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hours, ok := getQueryInt(r, "hours")
+	if !ok || hours <= 0 {
+		hours = 24
+	}
+	step, ok := getQueryInt(r, "step")
+	if !ok || step <= 0 {
+		step = 3600
+	}
+	minPct := float64(defaultThrottledPct)
+	if raw := r.URL.Query().Get("min_pct"); raw != "" {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v < 0 || v > 100 {
+			writeError(w, "min_pct must be a percentage", http.StatusBadRequest)
+			return
+		}
+		minPct = v
+	}
+	namespace := r.URL.Query().Get("namespace")
+
+	to := s.now(r)
+	from := to.Add(-time.Duration(hours) * time.Hour)
+	buckets, err := s.throttleBuckets(r.Context(), from, to, store.Bucketing{Step: time.Duration(step) * time.Second})
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pods, err := s.podCPULimits()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := ThrottlingResponse{From: from.Unix(), To: to.Unix(), Step: step, MinPct: minPct, Pods: []ThrottledPod{}}
+	for id, series := range buckets {
+		p, ok := pods[id]
+		if !ok || namespace != "" && p.Namespace != namespace {
+			continue
+		}
+		analyzeThrottling(&p, series)
+		if p.ThrottledPct > 0 && p.ThrottledPct >= minPct {
+			resp.Pods = append(resp.Pods, p)
+		}
+	}
+	sort.Slice(resp.Pods, func(i, j int) bool {
+		if resp.Pods[i].ThrottledPct != resp.Pods[j].ThrottledPct {
+			return resp.Pods[i].ThrottledPct > resp.Pods[j].ThrottledPct
+		}
+		return resp.Pods[i].PodID < resp.Pods[j].PodID
+	})
+	writeJSON(w, resp)
+}
+
+// throttleBuckets reads the per-second rates of the CPU counters per pod
+// and bucket
+func (s *Server) throttleBuckets(ctx context.Context, from, to time.Time, b store.Bucketing) (map[int64]map[time.Time]*throttleBucket, error) {
+	out := make(map[int64]map[time.Time]*throttleBucket)
+	for _, metric := range []string{"cpu_ms", "cpu_throttled_ms", "cpu_periods", "cpu_throttled_periods"} {
+		points, err := s.duck.QueryBucketedByResource(ctx, "pod", metric, from, to, b, "rate")
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range points {
+			if out[p.ResourceID] == nil {
+				out[p.ResourceID] = make(map[time.Time]*throttleBucket)
+			}
+			tb := out[p.ResourceID][p.Time]
+			if tb == nil {
+				tb = &throttleBucket{}
+				out[p.ResourceID][p.Time] = tb
+			}
+			switch metric {
+			case "cpu_ms":
+				tb.usage = p.Value
+			case "cpu_throttled_ms":
+				tb.throttledMs = p.Value
+			case "cpu_periods":
+				tb.periods = p.Value
+			case "cpu_throttled_periods":
+				tb.throttled = p.Value
+			}
+		}
+	}
+	return out, nil
+}
+
+// podCPULimits returns every pod with its CPU limit, set only when all its
+// containers have one
+func (s *Server) podCPULimits() (map[int64]ThrottledPod, error) {
+	rows, err := s.sqlite.Query(`SELECT p.id, p.name, ns.name, SUM(pc.cpu_limit_m), COUNT(pc.pod_id), COUNT(pc.cpu_limit_m)
+		FROM pods p
+		JOIN namespaces ns ON p.namespace_id = ns.id
+		LEFT JOIN pod_containers pc ON pc.pod_id = p.id
+		GROUP BY p.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]ThrottledPod)
+	for rows.Next() {
+		var p ThrottledPod
+		var limit *int64
+		var containers, limited int
+		if err := rows.Scan(&p.PodID, &p.Pod, &p.Namespace, &limit, &containers, &limited); err != nil {
+			continue
+		}
+		if containers > 0 && containers == limited {
+			p.CPULimitM = limit
+		}
+		out[p.PodID] = p
+	}
+	return out, rows.Err()
+}
+
+// analyzeThrottling fills in the throttling figures and limit suggestion
+func analyzeThrottling(p *ThrottledPod, series map[time.Time]*throttleBucket) {
+	var periods, throttled, usage float64
+	for _, b := range series {
+		periods += b.periods
+		throttled += b.throttled
+		usage += b.usage
+		if b.periods > 0 {
+			p.PeakThrottledPct = max(p.PeakThrottledPct, b.throttled/b.periods*100)
+		}
+		p.PeakDemandM = max(p.PeakDemandM, b.usage+b.throttledMs)
+	}
+	if periods > 0 {
+		p.ThrottledPct = throttled / periods * 100
+	}
+	p.AvgUsageM = usage / float64(len(series))
+
+	if p.CPULimitM == nil || p.ThrottledPct == 0 {
+		return
+	}
+	want := max(p.PeakDemandM*limitHeadroom, float64(*p.CPULimitM)*(1+p.ThrottledPct/100))
+	suggested := int64(math.Ceil(want/limitStepM)) * limitStepM
+	p.SuggestedLimitM = &suggested
+}
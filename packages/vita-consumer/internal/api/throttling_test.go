@@ -0,0 +1,73 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestCPUThrottling verifies a pod throttled in half its CFS periods is
+// flagged with a limit covering its demand (usage plus throttled time)
+func TestCPUThrottling(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		pod := synctest.Pod("default", "busy", "node-a", nil)
+		pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")}
+		if _, err := env.Client.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pod synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pod_containers WHERE cpu_limit_m = 500")
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+		id, err := env.QueryInt("SELECT id FROM pods WHERE name = 'busy'")
+		if err != nil {
+			return err
+		}
+
+		// Over one minute: 500m used, 300m throttled, 300 of 600 periods
+		t0, _ := time.Parse(time.RFC3339, "2024-05-01T10:10:00Z")
+		t1 := t0.Add(time.Minute)
+		var points []store.MetricPoint
+		for metric, delta := range map[string]float64{"cpu_ms": 30000, "cpu_throttled_ms": 18000, "cpu_periods": 600, "cpu_throttled_periods": 300} {
+			points = append(points,
+				store.MetricPoint{Time: t0, ResourceID: id, MetricType: metric, Value: 1000},
+				store.MetricPoint{Time: t1, ResourceID: id, MetricType: metric, Value: 1000 + delta})
+		}
+		if err := env.Duck.BatchInsert(points); err != nil {
+			return err
+		}
+
+		var resp api.ThrottlingResponse
+		if err := env.GetJSON("/api/v1/analysis/throttling?asOf=2024-05-01T12:00:00Z&hours=6", &resp); err != nil {
+			return err
+		}
+		if len(resp.Pods) != 1 {
+			return fmt.Errorf("throttled pods = %+v, want busy", resp.Pods)
+		}
+		p := resp.Pods[0]
+		if p.Pod != "busy" || p.ThrottledPct != 50 || p.PeakDemandM != 800 || p.CPULimitM == nil || *p.CPULimitM != 500 {
+			return fmt.Errorf("busy = %+v, want 50%% throttled, 800m demand, 500m limit", p)
+		}
+		if p.SuggestedLimitM == nil || *p.SuggestedLimitM != 1000 {
+			return fmt.Errorf("suggested limit = %v, want 1000m (800m plus 20%%, rounded up)", p.SuggestedLimitM)
+		}
+
+		if err := env.GetJSON("/api/v1/analysis/throttling?asOf=2024-05-01T12:00:00Z&hours=6&min_pct=60", &resp); err != nil {
+			return err
+		}
+		if len(resp.Pods) != 0 {
+			return fmt.Errorf("min_pct=60 pods = %+v, want none", resp.Pods)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,370 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+// healthDropPoints is how far a workload's health score must fall between
+// two scoring runs to show on the timeline
+const healthDropPoints = 20
+
+// TimelineResponse is everything recorded about a deployment, the nodes
+// its pods ran on and the PVCs they mount over a window, oldest first
+type TimelineResponse struct {
+	DeploymentID int64           `json:"deployment_id"`
+	Deployment   string          `json:"deployment"`
+	Namespace    string          `json:"namespace"`
+	From         int64           `json:"from"`
+	To           int64           `json:"to"`
+	Nodes        []string        `json:"nodes"`
+	PVCs         []string        `json:"pvcs"`
+	Entries      []TimelineEntry `json:"entries"`
+}
+
+// TimelineEntry is one thing that happened. Source is where it was
+// recorded: "annotation", "pod", "volume", "node" or "health".
+type TimelineEntry struct {
+	Time    int64  `json:"time"`
+	Source  string `json:"source"`
+	Type    string `json:"type"`
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+// timelineScope is what a deployment depends on: its pods, past and
+// present, and the nodes, PVCs and configs they use
+type timelineScope struct {
+	id        int64
+	nsID      int64
+	namespace string
+	podIDs    []interface{}
+	nodes     []string
+	pvcs      []string
+	configs   map[string]bool // kind + "/" + name
+}
+
+// handleTimeline serves /api/v1/timeline?deployment=[&hours=]: the
+// deployment's annotations (rollouts, SLO burn alerts, changes of the
+// configs and PVCs its pods use, retirement of their nodes), OOM kills and
+// deletions of its pods, volume failures, node deletions and health score
+// drops within the last hours (default 24), merged into one chronological
+// list for incident response
+func (s *Server) handleTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := getQueryInt(r, "deployment")
+	if !ok || id <= 0 {
+		writeError(w, "deployment is required", http.StatusBadRequest)
+		return
+	}
+	hours, ok := getQueryInt(r, "hours")
+	if !ok || hours <= 0 {
+		hours = 24
+	}
+	to := s.now(r)
+	from := to.Add(-time.Duration(hours) * time.Hour)
+
+	nsID, name, err := s.sqlite.WorkloadNamespace("deployment", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, "Deployment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	scope, err := s.timelineScope(nsID, id)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp := TimelineResponse{
+		DeploymentID: id,
+		Deployment:   name,
+		Namespace:    scope.namespace,
+		From:         from.Unix(),
+		To:           to.Unix(),
+		Nodes:        scope.nodes,
+		PVCs:         scope.pvcs,
+		Entries:      []TimelineEntry{},
+	}
+
+	for _, collect := range []func(*timelineScope, string, time.Time, time.Time) ([]TimelineEntry, error){
+		s.timelineAnnotations,
+		s.timelinePods,
+		s.timelineVolumes,
+		s.timelineNodes,
+		s.timelineHealth,
+	} {
+		entries, err := collect(scope, name, from, to)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.Entries = append(resp.Entries, entries...)
+	}
+	sort.SliceStable(resp.Entries, func(i, j int) bool { return resp.Entries[i].Time < resp.Entries[j].Time })
+	writeJSON(w, resp)
+}
+
+func (s *Server) timelineScope(nsID, deploymentID int64) (*timelineScope, error) {
+	scope := &timelineScope{id: deploymentID, nsID: nsID, nodes: []string{}, pvcs: []string{}, configs: make(map[string]bool)}
+	ns, err := s.sqlite.Query(`SELECT name FROM namespaces WHERE id = ?`, nsID)
+	if err != nil {
+		return nil, err
+	}
+	if ns.Next() {
+		ns.Scan(&scope.namespace)
+	}
+	ns.Close()
+
+	rows, err := s.sqlite.Query(`SELECT p.id, n.name FROM pods p JOIN nodes n ON n.id = p.node_id
+		WHERE p.deployment_id = ? ORDER BY p.id`, deploymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seenNode := make(map[string]bool)
+	for rows.Next() {
+		var id int64
+		var node string
+		if err := rows.Scan(&id, &node); err != nil {
+			return nil, err
+		}
+		scope.podIDs = append(scope.podIDs, id)
+		if !seenNode[node] {
+			seenNode[node] = true
+			scope.nodes = append(scope.nodes, node)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(scope.podIDs) == 0 {
+		return scope, nil
+	}
+
+	marks := placeholders(len(scope.podIDs))
+	claims, err := s.sqlite.Query(`SELECT DISTINCT name FROM pod_claims WHERE pod_id IN (`+marks+`) ORDER BY name`, scope.podIDs...)
+	if err != nil {
+		return nil, err
+	}
+	defer claims.Close()
+	for claims.Next() {
+		var name string
+		if err := claims.Scan(&name); err != nil {
+			return nil, err
+		}
+		scope.pvcs = append(scope.pvcs, name)
+	}
+	if err := claims.Err(); err != nil {
+		return nil, err
+	}
+
+	refs, err := s.sqlite.Query(`SELECT DISTINCT kind, name FROM config_refs WHERE pod_id IN (`+marks+`)`, scope.podIDs...)
+	if err != nil {
+		return nil, err
+	}
+	defer refs.Close()
+	for refs.Next() {
+		var kind, name string
+		if err := refs.Scan(&kind, &name); err != nil {
+			return nil, err
+		}
+		scope.configs[kind+"/"+name] = true
+	}
+	return scope, refs.Err()
+}
+
+// timelineAnnotations picks the annotations about the deployment, its SLOs
+// and what it depends on
+func (s *Server) timelineAnnotations(scope *timelineScope, name string, from, to time.Time) ([]TimelineEntry, error) {
+	slos := make(map[string]bool)
+	rows, err := s.sqlite.Query(`SELECT name FROM slos WHERE kind = 'deployment' AND workload_id = ?`, scope.id)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var slo string
+		if err := rows.Scan(&slo); err == nil {
+			slos[slo] = true
+		}
+	}
+	rows.Close()
+
+	rows, err = s.sqlite.Query(`SELECT time, namespace_id, type, kind, name, message FROM annotations
+		WHERE time >= ? AND time < ? ORDER BY time`, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []TimelineEntry{}
+	for rows.Next() {
+		var t time.Time
+		var nsID *int64
+		var e TimelineEntry
+		if err := rows.Scan(&t, &nsID, &e.Type, &e.Kind, &e.Name, &e.Message); err != nil {
+			return nil, err
+		}
+		inNamespace := nsID != nil && *nsID == scope.nsID
+		var match bool
+		switch {
+		case e.Type == "slo_burn":
+			match = slos[e.Name]
+		case e.Kind == "deployment":
+			match = inNamespace && e.Name == name
+		case e.Kind == "pvc":
+			match = inNamespace && slices.Contains(scope.pvcs, e.Name)
+		case e.Kind == "node":
+			match = slices.Contains(scope.nodes, e.Name)
+		case e.Type == "config_change":
+			match = inNamespace && scope.configs[e.Kind+"/"+e.Name]
+		}
+		if match {
+			e.Time, e.Source = t.Unix(), "annotation"
+			entries = append(entries, e)
+		}
+	}
+	return entries, rows.Err()
+}
+
+// timelinePods lists the OOM kills and deletions of the deployment's pods.
+// Only the latest OOM kill of each pod is kept.
+func (s *Server) timelinePods(scope *timelineScope, _ string, from, to time.Time) ([]TimelineEntry, error) {
+	entries := []TimelineEntry{}
+	if len(scope.podIDs) == 0 {
+		return entries, nil
+	}
+	rows, err := s.sqlite.Query(`SELECT name, oom_killed_at, deleted_at, coalesce(restarts, 0) FROM pods
+		WHERE id IN (`+placeholders(len(scope.podIDs))+`)`, scope.podIDs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	within := func(t *time.Time) bool { return t != nil && !t.Before(from) && t.Before(to) }
+	for rows.Next() {
+		var name string
+		var oom, deleted *time.Time
+		var restarts int
+		if err := rows.Scan(&name, &oom, &deleted, &restarts); err != nil {
+			return nil, err
+		}
+		if within(oom) {
+			entries = append(entries, TimelineEntry{Time: oom.Unix(), Source: "pod", Type: "oom_kill", Kind: "pod", Name: name,
+				Message: fmt.Sprintf("Container OOM killed (%d restarts so far)", restarts)})
+		}
+		if within(deleted) {
+			entries = append(entries, TimelineEntry{Time: deleted.Unix(), Source: "pod", Type: "pod_deleted", Kind: "pod", Name: name, Message: "Pod deleted"})
+		}
+	}
+	return entries, rows.Err()
+}
+
+// timelineVolumes lists volume failures of the deployment's pods or its
+// PVCs, and when they were resolved
+func (s *Server) timelineVolumes(scope *timelineScope, _ string, from, to time.Time) ([]TimelineEntry, error) {
+	entries := []TimelineEntry{}
+	if len(scope.podIDs) == 0 {
+		return entries, nil
+	}
+	args := slices.Clone(scope.podIDs)
+	query := `SELECT vf.pod_name, coalesce(pvc.name, ''), vf.reason, vf.message, vf.count, vf.first_seen, vf.resolved_at
+		FROM volume_failures vf LEFT JOIN pvcs pvc ON pvc.id = vf.pvc_id
+		WHERE vf.pod_id IN (` + placeholders(len(scope.podIDs)) + `)`
+	if len(scope.pvcs) > 0 {
+		query += ` OR (vf.namespace_id = ? AND pvc.name IN (` + placeholders(len(scope.pvcs)) + `))`
+		args = append(args, scope.nsID)
+		for _, name := range scope.pvcs {
+			args = append(args, name)
+		}
+	}
+	rows, err := s.sqlite.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pod, pvc, reason, message string
+		var count int
+		var first time.Time
+		var resolved *time.Time
+		if err := rows.Scan(&pod, &pvc, &reason, &message, &count, &first, &resolved); err != nil {
+			return nil, err
+		}
+		kind, name := "pod", pod
+		if pvc != "" {
+			kind, name = "pvc", pvc
+		}
+		if !first.Before(from) && first.Before(to) {
+			entries = append(entries, TimelineEntry{Time: first.Unix(), Source: "volume", Type: "volume_failure", Kind: kind, Name: name,
+				Message: fmt.Sprintf("%s for pod %s: %s (seen %d times)", reason, pod, message, count)})
+		}
+		if resolved != nil && !resolved.Before(from) && resolved.Before(to) {
+			entries = append(entries, TimelineEntry{Time: resolved.Unix(), Source: "volume", Type: "volume_resolved", Kind: kind, Name: name,
+				Message: fmt.Sprintf("Pod %s became ready", pod)})
+		}
+	}
+	return entries, rows.Err()
+}
+
+// timelineNodes lists deletions of the nodes the deployment's pods ran on
+func (s *Server) timelineNodes(scope *timelineScope, _ string, from, to time.Time) ([]TimelineEntry, error) {
+	entries := []TimelineEntry{}
+	if len(scope.nodes) == 0 {
+		return entries, nil
+	}
+	args := []interface{}{from.UTC(), to.UTC()}
+	for _, name := range scope.nodes {
+		args = append(args, name)
+	}
+	rows, err := s.sqlite.Query(`SELECT name, deleted_at FROM nodes WHERE deleted_at >= ? AND deleted_at < ?
+		AND name IN (`+placeholders(len(scope.nodes))+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var deleted time.Time
+		if err := rows.Scan(&name, &deleted); err != nil {
+			return nil, err
+		}
+		entries = append(entries, TimelineEntry{Time: deleted.Unix(), Source: "node", Type: "node_deleted", Kind: "node", Name: name, Message: "Node deleted"})
+	}
+	return entries, rows.Err()
+}
+
+// timelineHealth flags scoring runs where the deployment's health fell by
+// healthDropPoints or more, and its recovery to full health afterwards
+func (s *Server) timelineHealth(scope *timelineScope, name string, from, to time.Time) ([]TimelineEntry, error) {
+	entries := []TimelineEntry{}
+	if !s.health {
+		return entries, nil
+	}
+	history, err := s.sqlite.WorkloadHealthHistory("deployment", scope.id, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i < len(history); i++ {
+		prev, h := history[i-1], history[i]
+		switch {
+		case prev.Score-h.Score >= healthDropPoints:
+			entries = append(entries, TimelineEntry{Time: h.Time.Unix(), Source: "health", Type: "health_drop", Kind: "deployment", Name: name,
+				Message: fmt.Sprintf("Health score fell from %.0f to %.0f (%d restarts, %d OOM kills, %d pods erroring in the last hour)",
+					prev.Score, h.Score, h.Restarts, h.OOMKills, h.Errors)})
+		case prev.Score < 100 && h.Score == 100:
+			entries = append(entries, TimelineEntry{Time: h.Time.Unix(), Source: "health", Type: "health_recovered", Kind: "deployment", Name: name,
+				Message: fmt.Sprintf("Health score recovered from %.0f", prev.Score)})
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,140 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// A deployment's timeline merges its rollout, changes of
+// the config and claim its pods use, an OOM kill, a mount failure and a
+// health drop in time order, leaving out changes it does not depend on
+func TestTimeline(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		pvc := synctest.BoundPVC("incident", "checkout-data", "fast", "1Gi", "pv-checkout")
+		if _, err := env.Client.CoreV1().PersistentVolumeClaims("incident").Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		dep := synctest.Deployment("incident", "checkout", 1)
+		dep.Annotations = map[string]string{"deployment.kubernetes.io/revision": "1"}
+		rs, depID, err := env.CreateDeployment(ctx, dep)
+		if err != nil {
+			return err
+		}
+		pod := synctest.Pod("incident", "checkout-0", "node-a", rs)
+		pod.Spec.Volumes = []corev1.Volume{
+			{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "checkout-data"}}},
+		}
+		pod.Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{
+			{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "checkout-config"}}},
+		}
+		pods := env.Client.CoreV1().Pods("incident")
+		if _, err := pods.Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pod linked with its claim", func() (bool, error) {
+			n, err := env.QueryInt(`SELECT COUNT(*) FROM pods p JOIN pod_claims pc ON pc.pod_id = p.id
+			WHERE p.name = 'checkout-0' AND p.deployment_id IS NOT NULL AND pc.name = 'checkout-data'`)
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+		nsID, err := env.QueryInt("SELECT id FROM namespaces WHERE name = 'incident'")
+		if err != nil {
+			return err
+		}
+
+		// A rollout, then the config and claim change; other-config is unused
+		latest, err := env.Client.AppsV1().Deployments("incident").Get(ctx, "checkout", metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		latest.Annotations["deployment.kubernetes.io/revision"] = "2"
+		if _, err := env.Client.AppsV1().Deployments("incident").Update(ctx, latest, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "rollout annotated", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM annotations WHERE type = 'rollout' AND name = 'checkout'")
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, a := range []store.Annotation{
+			{Time: now.Add(time.Second), NamespaceID: &nsID, Type: "config_change", Kind: "configmap", Name: "checkout-config", Message: "checkout-config changed"},
+			{Time: now.Add(time.Second), NamespaceID: &nsID, Type: "config_change", Kind: "configmap", Name: "other-config", Message: "other-config changed"},
+			{Time: now.Add(2 * time.Second), NamespaceID: &nsID, Type: "pvc_resize", Kind: "pvc", Name: "checkout-data", Message: "Resized from 1024 MiB to 2048 MiB"},
+		} {
+			if err := env.SQLite.InsertAnnotation(a); err != nil {
+				return err
+			}
+		}
+
+		// The pod is OOM killed, fails to remount its claim and health drops
+		current, err := pods.Get(ctx, "checkout-0", metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		current.Status.ContainerStatuses = []corev1.ContainerStatus{{
+			Name:         "app",
+			RestartCount: 1,
+			LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+				Reason: "OOMKilled", ExitCode: 137, FinishedAt: metav1.NewTime(now.Add(3 * time.Second)),
+			}},
+		}}
+		if _, err := pods.UpdateStatus(ctx, current, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		event := synctest.WarningEvent(current, "FailedMount", `MountVolume.SetUp failed for volume "data" : mount failed: exit status 32`, now.Add(4*time.Second))
+		if _, err := env.Client.CoreV1().Events("incident").Create(ctx, event, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "oom kill and mount failure recorded", func() (bool, error) {
+			n, err := env.QueryInt(`SELECT (SELECT COUNT(*) FROM pods WHERE name = 'checkout-0' AND oom_killed_at IS NOT NULL)
+			+ (SELECT COUNT(*) FROM volume_failures vf JOIN pvcs ON pvcs.id = vf.pvc_id WHERE pvcs.name = 'checkout-data')`)
+			return n == 2, err
+		}); err != nil {
+			return err
+		}
+		if err := env.SQLite.InsertWorkloadHealth([]store.WorkloadHealth{
+			{Time: now.Add(-time.Minute), Kind: "deployment", WorkloadID: depID, Score: 100},
+			{Time: now.Add(5 * time.Second), Kind: "deployment", WorkloadID: depID, Score: 60, Restarts: 1, OOMKills: 1},
+		}); err != nil {
+			return err
+		}
+		if env.Clock.Now().Before(now.Add(time.Minute)) {
+			env.Clock.Set(now.Add(time.Minute))
+		}
+
+		var resp api.TimelineResponse
+		if err := env.GetJSON(fmt.Sprintf("/api/v1/timeline?deployment=%d", depID), &resp); err != nil {
+			return err
+		}
+		if resp.Deployment != "checkout" || resp.Namespace != "incident" || !slices.Equal(resp.PVCs, []string{"checkout-data"}) || !slices.Equal(resp.Nodes, []string{"node-a"}) {
+			return fmt.Errorf("timeline scope = %+v", resp)
+		}
+		var types []string
+		for i, e := range resp.Entries {
+			if i > 0 && e.Time < resp.Entries[i-1].Time {
+				return fmt.Errorf("timeline out of order: %+v", resp.Entries)
+			}
+			if e.Name == "other-config" {
+				return fmt.Errorf("unrelated config change listed: %+v", e)
+			}
+			types = append(types, e.Source+"/"+e.Type)
+		}
+		want := []string{"annotation/rollout", "annotation/config_change", "annotation/pvc_resize", "pod/oom_kill", "volume/volume_failure", "health/health_drop"}
+		if !slices.Equal(types, want) {
+			return fmt.Errorf("timeline = %v, want %v", types, want)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/usage"
+)
+
+// UsageResponse breaks ingested datapoints down by namespace or node
+type UsageResponse struct {
+	Scope   string      `json:"scope"`
+	GroupBy string      `json:"group_by,omitempty"`
+	Owners  string      `json:"owners,omitempty"`
+	From    int64       `json:"from"`
+	To      int64       `json:"to"`
+	Points  int64       `json:"points"`
+	Dropped int64       `json:"dropped"`
+	Items   []UsageItem `json:"items"`
+}
+
+// UsageItem is one namespace or node, or one value of the group_by
+// annotation or node group, largest first. Dropped counts points rejected by ingest
+// quotas.
+type UsageItem struct {
+	Name     string      `json:"name"`
+	Points   int64       `json:"points"`
+	Dropped  int64       `json:"dropped"`
+	SharePct float64     `json:"share_pct"`
+	Hours    []UsageHour `json:"hours"`
+}
+
+type UsageHour struct {
+	Hour    int64 `json:"hour"`
+	Points  int64 `json:"points"`
+	Dropped int64 `json:"dropped"`
+}
+
+// handleUsage serves /api/v1/usage[?scope=namespace|node&hours=&group_by=&owners=].
+// Counts cover the last hours (default 24, at most 720) including the
+// current one, and trail ingest by up to 30 seconds. With the namespace
+// scope, owners keeps namespaces whose ownership annotations match
+// ("team:payments") and group_by totals them by an annotation; with the
+// node scope, group_by totals nodes by zone, region, nodepool or
+// capacity_type.
+func (s *Server) handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	scope := r.URL.Query().Get("scope")
+	if scope == "" {
+		scope = usage.Namespace
+	}
+	if scope != usage.Namespace && scope != usage.Node {
+		writeError(w, "scope must be namespace or node", http.StatusBadRequest)
+		return
+	}
+	hours, ok := getQueryInt(r, "hours")
+	if !ok || hours <= 0 {
+		hours = 24
+	}
+	if hours > 720 {
+		hours = 720
+	}
+	groupBy := r.URL.Query().Get("group_by")
+	owners, err := store.ParseOwnerFilter(r.URL.Query().Get("owners"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var nsOwners map[string]map[string]string
+	var nodeGroups map[string]store.NodeGroup
+	switch {
+	case scope == usage.Node && len(owners) > 0:
+		writeError(w, "owners needs the namespace scope", http.StatusBadRequest)
+		return
+	case scope == usage.Node && groupBy != "":
+		if err := store.ValidNodeGroupDimension(groupBy); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if nodeGroups, err = s.sqlite.NodeGroupsByName(); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case groupBy != "" || len(owners) > 0:
+		if nsOwners, err = s.sqlite.NamespaceOwnership(); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	to := s.clock.Now().Truncate(time.Hour).Add(time.Hour)
+	from := to.Add(-time.Duration(hours) * time.Hour)
+	rows, err := s.sqlite.IngestUsageRange(scope, from, to)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := UsageResponse{Scope: scope, GroupBy: groupBy, Owners: owners.String(), From: from.Unix(), To: to.Unix(), Items: []UsageItem{}}
+	byName := make(map[string]*UsageItem)
+	for _, u := range rows {
+		name := u.Name
+		if nsOwners != nil {
+			if !owners.Matches(nsOwners[name]) {
+				continue
+			}
+			if groupBy != "" {
+				name = nsOwners[name][groupBy] // empty for namespaces without it
+			}
+		}
+		if nodeGroups != nil {
+			name = nodeGroupName(nodeGroups[name], groupBy)
+		}
+		item := byName[name]
+		if item == nil {
+			item = &UsageItem{Name: name}
+			byName[name] = item
+		}
+		item.Points += u.Points
+		item.Dropped += u.Dropped
+		item.Hours = appendUsageHour(item.Hours, UsageHour{Hour: u.Hour.Unix(), Points: u.Points, Dropped: u.Dropped})
+		resp.Points += u.Points
+		resp.Dropped += u.Dropped
+	}
+	for _, item := range byName {
+		if resp.Points > 0 {
+			item.SharePct = float64(item.Points) / float64(resp.Points) * 100
+		}
+		resp.Items = append(resp.Items, *item)
+	}
+	sort.Slice(resp.Items, func(i, j int) bool {
+		a, b := resp.Items[i], resp.Items[j]
+		if a.Points != b.Points {
+			return a.Points > b.Points
+		}
+		return a.Name < b.Name
+	})
+	writeJSON(w, resp)
+}
+
+// appendUsageHour adds h to hours, which are in order, merging it into the
+// last one when a grouped item already has that hour
+func appendUsageHour(hours []UsageHour, h UsageHour) []UsageHour {
+	if n := len(hours); n > 0 && hours[n-1].Hour == h.Hour {
+		hours[n-1].Points += h.Points
+		hours[n-1].Dropped += h.Dropped
+		return hours
+	}
+	return append(hours, h)
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DimensionValue is one dropdown entry. Count is the number of pods for
+// catalog dimensions and the number of reporting resources for metric_type.
+type DimensionValue struct {
+	Value string `json:"value"`
+	ID    *int64 `json:"id,omitempty"`
+	Count int64  `json:"count"`
+}
+
+type ValuesResponse struct {
+	Dimension string           `json:"dimension"`
+	Values    []DimensionValue `json:"values"`
+}
+
+// valueQueries select (id, name, pod count) per catalog dimension
+var valueQueries = map[string]string{
+	"namespace": `SELECT ns.id, ns.name, COUNT(p.id) FROM namespaces ns
+		LEFT JOIN pods p ON p.namespace_id = ns.id WHERE 1=1`,
+	"node": `SELECT n.id, n.name, COUNT(p.id) FROM nodes n
+		LEFT JOIN pods p ON p.node_id = n.id WHERE n.decommissioned_at IS NULL`,
+	"deployment": `SELECT d.id, d.name, COUNT(p.id) FROM deployments d
+		LEFT JOIN pods p ON p.deployment_id = d.id WHERE 1=1`,
+}
+
+// valueColumns are the name and namespace columns used for filtering
+var valueColumns = map[string][2]string{
+	"namespace":  {"ns.name", "ns.id"},
+	"node":       {"n.name", "p.namespace_id"},
+	"deployment": {"d.name", "d.namespace_id"},
+}
+
+// handleValues serves /api/v1/values?dimension=namespace|node|deployment|metric_type
+// [&filter=&namespace=&limit=]. filter is a case-insensitive substring;
+// namespace (ID) narrows nodes and deployments for dependent dropdowns.
+// metric_type honours from/to (default last hour).
+func (s *Server) handleValues(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	dim := q.Get("dimension")
+	filter := strings.ToLower(q.Get("filter"))
+	limit, ok := getQueryInt(r, "limit")
+	if !ok || limit <= 0 {
+		limit = 100
+	}
+
+	resp := ValuesResponse{Dimension: dim, Values: []DimensionValue{}}
+
+	if dim == "metric_type" {
+		from, to := s.getTimeRange(r)
+		types, err := s.duck.MetricTypes(r.Context(), from, to)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, t := range types {
+			if filter != "" && !strings.Contains(strings.ToLower(t.Type), filter) {
+				continue
+			}
+			if int64(len(resp.Values)) >= limit {
+				break
+			}
+			resp.Values = append(resp.Values, DimensionValue{Value: t.Type, Count: t.Resources})
+		}
+		writeJSON(w, resp)
+		return
+	}
+
+	query, ok := valueQueries[dim]
+	if !ok {
+		writeError(w, "dimension must be one of namespace, node, deployment, metric_type", http.StatusBadRequest)
+		return
+	}
+	cols := valueColumns[dim]
+	args := []interface{}{}
+	if filter != "" {
+		query += " AND instr(lower(" + cols[0] + "), ?) > 0"
+		args = append(args, filter)
+	}
+	if nsID, ok := getQueryInt(r, "namespace"); ok {
+		query += " AND " + cols[1] + " = ?"
+		args = append(args, nsID)
+	}
+	query += " GROUP BY 1, 2 ORDER BY 2 LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.sqlite.Query(query, args...)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v DimensionValue
+		var id int64
+		if err := rows.Scan(&id, &v.Value, &v.Count); err != nil {
+			continue
+		}
+		v.ID = &id
+		resp.Values = append(resp.Values, v)
+	}
+	writeJSON(w, resp)
+}
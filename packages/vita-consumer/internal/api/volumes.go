@@ -0,0 +1,219 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/analysis"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+const (
+	// defaultWarnDays is how close projected exhaustion must be for a
+	// volume to get a resize recommendation
+	defaultWarnDays = 14
+	// resizeHeadroomDays of growth a recommended size should absorb
+	resizeHeadroomDays = 90
+	// resizeTargetPct is how full a resized volume should be after that growth
+	resizeTargetPct = 80
+)
+
+// VolumeForecastResponse projects each claim's usage to its capacity
+type VolumeForecastResponse struct {
+	From     int64            `json:"from"`
+	To       int64            `json:"to"`
+	WarnDays int64            `json:"warn_days"`
+	Volumes  []VolumeForecast `json:"volumes"`
+}
+
+// VolumeForecast is one claim's trend. FullAt is omitted when usage is
+// flat or shrinking.
+type VolumeForecast struct {
+	ID             int64                 `json:"id"`
+	Name           string                `json:"name"`
+	Namespace      string                `json:"namespace"`
+	StorageClass   *string               `json:"storage_class,omitempty"`
+	UsedMB         float64               `json:"used_mb"`
+	CapacityMB     float64               `json:"capacity_mb"`
+	UsedPct        float64               `json:"used_pct"`
+	GrowthMBPerDay float64               `json:"growth_mb_per_day"`
+	FullAt         *int64                `json:"full_at,omitempty"`
+	DaysUntilFull  *float64              `json:"days_until_full,omitempty"`
+	LastResize     *store.PVCResize      `json:"last_resize,omitempty"`
+	Recommendation *ResizeRecommendation `json:"recommendation,omitempty"`
+}
+
+// ResizeRecommendation is a size that holds resizeHeadroomDays of growth
+// at resizeTargetPct full, with the merge patch that requests it.
+// Expandable is false when the storage class does not allow expansion, in
+// which case the patch will be refused.
+type ResizeRecommendation struct {
+	TargetMB   float64         `json:"target_mb"`
+	TargetSize string          `json:"target_size"`
+	Expandable *bool           `json:"expandable,omitempty"`
+	Patch      json.RawMessage `json:"patch"`
+	Command    string          `json:"command"`
+}
+
+// handleVolumeForecast serves /api/v1/analysis/volumes[?days=&warn_days=&namespace=].
+// Usage over days of history (default 7) is fitted per claim and projected
+// to its bound capacity, or the capacity the agent reports when the claim
+// has none. Volumes filling within warn_days get a recommendation.
+func (s *Server) handleVolumeForecast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	days, ok := getQueryInt(r, "days")
+	if !ok || days <= 0 {
+		days = 7
+	}
+	warnDays, ok := getQueryInt(r, "warn_days")
+	if !ok || warnDays <= 0 {
+		warnDays = defaultWarnDays
+	}
+
+	to := s.now(r)
+	from := to.Add(-time.Duration(days) * 24 * time.Hour)
+	hourly := store.Bucketing{Step: time.Hour}
+
+	used, err := s.duck.QueryBucketedByResource(r.Context(), "pvc", "used_mb", from, to, hourly, "avg")
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	reported, err := s.sqlite.LatestValues(from, "pvc", "total_mb")
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resizes, err := s.sqlite.LastPVCResizes()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	series := map[int64][]store.MetricPoint{}
+	for _, p := range used {
+		series[p.ResourceID] = append(series[p.ResourceID], p)
+	}
+
+	query := `
+		SELECT pvc.id, pvc.name, ns.name, pvc.storage_class, pvc.capacity_mb, sc.allow_expansion
+		FROM pvcs pvc
+		JOIN namespaces ns ON pvc.namespace_id = ns.id
+		LEFT JOIN storage_classes sc ON sc.name = pvc.storage_class
+		WHERE pvc.deleted_at IS NULL
+	`
+	args := []interface{}{}
+	if nsID, ok := getQueryInt(r, "namespace"); ok {
+		query += " AND pvc.namespace_id = ?"
+		args = append(args, nsID)
+	}
+	rows, err := s.sqlite.Query(query, args...)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	resp := VolumeForecastResponse{From: from.Unix(), To: to.Unix(), WarnDays: warnDays, Volumes: []VolumeForecast{}}
+	for rows.Next() {
+		var f VolumeForecast
+		var capacity *float64
+		var expandable *bool
+		if err := rows.Scan(&f.ID, &f.Name, &f.Namespace, &f.StorageClass, &capacity, &expandable); err != nil {
+			continue
+		}
+		if capacity != nil {
+			f.CapacityMB = *capacity
+		} else {
+			f.CapacityMB = reported[f.ID]
+		}
+		points := series[f.ID]
+		if len(points) < 2 || f.CapacityMB <= 0 {
+			continue
+		}
+
+		ts := make([]time.Time, len(points))
+		vs := make([]float64, len(points))
+		for i, p := range points {
+			ts[i], vs[i] = p.Time, p.Value
+		}
+		trend := analysis.Fit(ts, vs)
+		f.UsedMB = vs[len(vs)-1]
+		f.UsedPct = f.UsedMB / f.CapacityMB * 100
+		f.GrowthMBPerDay = trend.PerDay()
+		if rs, ok := resizes[f.ID]; ok {
+			f.LastResize = &rs
+		}
+
+		if fullAt, ok := trend.Reaches(f.CapacityMB, to); ok {
+			at := fullAt.Unix()
+			d := fullAt.Sub(to).Hours() / 24
+			f.FullAt, f.DaysUntilFull = &at, &d
+			if d <= float64(warnDays) {
+				projected := math.Max(f.UsedMB, trend.At(to.Add(resizeHeadroomDays*24*time.Hour)))
+				f.Recommendation = recommendResize(f.Namespace, f.Name, f.CapacityMB, projected, expandable)
+			}
+		}
+		resp.Volumes = append(resp.Volumes, f)
+	}
+
+	// Soonest to fill first, then the fullest of those that are not filling
+	sort.Slice(resp.Volumes, func(i, j int) bool {
+		a, b := resp.Volumes[i], resp.Volumes[j]
+		if (a.FullAt == nil) != (b.FullAt == nil) {
+			return a.FullAt != nil
+		}
+		if a.FullAt != nil && *a.FullAt != *b.FullAt {
+			return *a.FullAt < *b.FullAt
+		}
+		return a.UsedPct > b.UsedPct
+	})
+
+	writeJSON(w, resp)
+}
+
+// recommendResize sizes a claim so projected usage fills resizeTargetPct
+// of it, in whole GiB and at least one more than it has now
+func recommendResize(namespace, name string, capacityMB, projectedMB float64, expandable *bool) *ResizeRecommendation {
+	gib := math.Ceil(projectedMB * 100 / resizeTargetPct / 1024)
+	gib = math.Max(gib, math.Floor(capacityMB/1024)+1)
+	size := strconv.FormatFloat(gib, 'f', 0, 64) + "Gi"
+
+	patch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"resources": map[string]interface{}{
+				"requests": map[string]string{"storage": size},
+			},
+		},
+	})
+	return &ResizeRecommendation{
+		TargetMB:   gib * 1024,
+		TargetSize: size,
+		Expandable: expandable,
+		Patch:      patch,
+		Command:    fmt.Sprintf("kubectl patch pvc %s -n %s --type merge -p '%s'", name, namespace, patch),
+	}
+}
+
+// handlePVCResizes serves GET /api/v1/pvcs/{id}/resizes, oldest first
+func (s *Server) handlePVCResizes(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+	resizes, err := s.sqlite.PVCResizes(id)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, resizes)
+}
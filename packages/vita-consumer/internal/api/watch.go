@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/syncer"
+)
+
+// EventSource delivers resource change notifications
+type EventSource interface {
+	Subscribe() (<-chan syncer.Event, func())
+}
+
+// WatchEvent is one line of a watch stream
+type WatchEvent struct {
+	Type   string      `json:"type"` // "added", "updated", "deleted", "heartbeat"
+	ID     int64       `json:"id,omitempty"`
+	Object interface{} `json:"object,omitempty"`
+}
+
+const watchHeartbeat = 30 * time.Second
+
+// serveList answers a list request, or streams changes when ?watch=true.
+// query returns the filtered rows; a non-zero id restricts it to one row.
+// ?fields= projects both (see parseFields).
+func serveList[T any](s *Server, w http.ResponseWriter, r *http.Request, kind string, query func(id int64) ([]T, error)) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.URL.Query().Get("watch") == "true" {
+		serveWatch(s, w, r, kind, query)
+		return
+	}
+
+	items, err := query(0)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeFields(w, r, items)
+}
+
+// serveWatch holds the connection open and writes one JSON object per line
+// for every change to a resource of the given kind that matches the
+// request's filters.
+func serveWatch[T any](s *Server, w http.ResponseWriter, r *http.Request, kind string, query func(id int64) ([]T, error)) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || s.events == nil {
+		writeError(w, "Watch not supported", http.StatusNotImplemented)
+		return
+	}
+
+	events, cancel := s.events.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if err := enc.Encode(WatchEvent{Type: "heartbeat"}); err != nil {
+				return
+			}
+			flusher.Flush()
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev.Kind != kind {
+				continue
+			}
+
+			// Re-read through the list query so filters apply to watch too
+			items, err := query(ev.ID)
+			if err != nil || len(items) == 0 {
+				continue
+			}
+
+			object, err := project(r, items[0])
+			if err != nil {
+				continue
+			}
+			out := WatchEvent{Type: string(ev.Type), ID: ev.ID, Object: object}
+			if err := enc.Encode(out); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package buffer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Class ranks metric types for room in the buffer. When it fills up, lower
+// classes stop being admitted first, so the series core dashboards read
+// stay complete through an overload.
+type Class uint8
+
+const (
+	// BestEffort is admitted while the buffer is below 70% full: custom
+	// application metrics and any type without a class
+	BestEffort Class = iota
+	// Standard is admitted below 90%: secondary container and volume
+	// metrics
+	Standard
+	// Critical may use the whole buffer: node and pod CPU and memory
+	Critical
+
+	classCount = 3
+)
+
+// classShare is the share of capacity up to which each class is admitted
+var classShare = [classCount]float64{BestEffort: 0.7, Standard: 0.9, Critical: 1}
+
+var classNames = [classCount]string{BestEffort: "best-effort", Standard: "standard", Critical: "critical"}
+
+func (c Class) String() string {
+	if int(c) < classCount {
+		return classNames[c]
+	}
+	return fmt.Sprintf("class(%d)", c)
+}
+
+// ParseClass reads a class name
+func ParseClass(name string) (Class, error) {
+	for c, n := range classNames {
+		if strings.EqualFold(strings.TrimSpace(name), n) {
+			return Class(c), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown metric class %q, want critical, standard or best-effort", name)
+}
+
+// DefaultClasses are the classes of the metric types agents report. Other
+// types are best-effort.
+func DefaultClasses() map[string]Class {
+	return map[string]Class{
+		"cpu_ms":             Critical,
+		"mem_mb":             Critical,
+		"mem_limit_mb":       Critical,
+		"mem_working_set_mb": Critical,
+
+		"cpu_throttled_ms":      Standard,
+		"cpu_periods":           Standard,
+		"cpu_throttled_periods": Standard,
+		"mem_rss_mb":            Standard,
+		"mem_cache_mb":          Standard,
+		"mem_swap_mb":           Standard,
+		"total_mb":              Standard,
+		"used_mb":               Standard,
+		"free_mb":               Standard,
+	}
+}
+
+// ParseClasses applies overrides like "used_mb=critical,app_qps=standard"
+// to the default classes
+func ParseClasses(spec string) (map[string]Class, error) {
+	classes := DefaultClasses()
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		metricType, name, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(metricType) == "" {
+			return nil, fmt.Errorf("invalid metric class %q, want type=class", entry)
+		}
+		c, err := ParseClass(name)
+		if err != nil {
+			return nil, err
+		}
+		classes[strings.TrimSpace(metricType)] = c
+	}
+	return classes, nil
+}
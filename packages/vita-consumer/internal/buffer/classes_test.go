@@ -0,0 +1,64 @@
+package buffer
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+// A filling buffer refuses best-effort metrics first, then standard ones,
+// keeping room for critical CPU and memory; classes can be overridden per
+// type
+func TestClasses(t *testing.T) {
+	ring := NewRingBuffer(100)
+	now := time.Now()
+	batch := func(metricType string, n int) []Metric {
+		out := make([]Metric, n)
+		for i := range out {
+			out[i] = Metric{Time: now, ResourceID: int64(i), Type: metricType, Value: 1}
+		}
+		return out
+	}
+	count := func(metricType string) int { return len(ring.ReadByTypes(metricType)) }
+
+	// Custom metrics stop at 70%, volume usage at 90%
+	ring.AddBatch(batch("app_requests", 80))
+	ring.AddBatch(batch("used_mb", 30))
+	if got := count("app_requests"); got != 70 {
+		t.Fatalf("best-effort admitted %d, want 70", got)
+	}
+	if got := count("used_mb"); got != 20 {
+		t.Fatalf("standard admitted %d, want 20", got)
+	}
+
+	// Critical types fill the rest; within one batch they reserve first
+	mixed := append(batch("app_requests", 5), batch("mem_mb", 15)...)
+	ring.AddBatch(mixed)
+	ring.Add(Metric{Time: now, ResourceID: 1, Type: "cpu_ms", Value: 1})
+	if got := count("mem_mb"); got != 10 {
+		t.Fatalf("critical admitted %d, want 10", got)
+	}
+	if got := count("cpu_ms"); got != 0 || ring.Len() != 100 {
+		t.Fatalf("full buffer: %d cpu_ms, %d held", got, ring.Len())
+	}
+	want := map[string]int64{"best-effort": 15, "standard": 10, "critical": 6}
+	if got := ring.Dropped(); !maps.Equal(got, want) {
+		t.Fatalf("dropped %v, want %v", got, want)
+	}
+
+	// Overrides move a type up
+	classes, err := ParseClasses("app_requests=critical, used_mb = best-effort")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseClasses("app_requests=urgent"); err == nil {
+		t.Fatal("unknown class accepted")
+	}
+	ring = NewRingBuffer(100)
+	ring.SetClasses(classes)
+	ring.AddBatch(batch("used_mb", 80))
+	ring.AddBatch(batch("app_requests", 40))
+	if count("used_mb") != 70 || count("app_requests") != 30 {
+		t.Fatalf("with overrides: %d used_mb, %d app_requests, want 70 and 30", count("used_mb"), count("app_requests"))
+	}
+}
@@ -1,63 +1,337 @@
 package buffer
 
 import (
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
 )
 
 type Metric struct {
 	Time       time.Time
 	ResourceID int64
+	Kind       string // what ResourceID refers to: pod or pvc
 	Type       string
 	Value      float64
+	SourceID   int64 // where the point came from, see store.Source
+	SampleRate int   // set when its series kept 1 in SampleRate points, see sampling
 }
 
-// RingBuffer is a simplified circular buffer or slice-based buffer
+// shardCount must be a power of two (see shardOf)
+const shardCount = 16
+
+// RingBuffer holds metrics between flushes. It is split into shards by
+// resource ID so ingest writers and live readers touching different
+// resources do not contend on one lock. All samples of a resource live in
+// one shard, so per-resource order is preserved across reads.
 type RingBuffer struct {
+	shards  [shardCount]shard
+	size    atomic.Int64 // across shards; only changed under a shard lock
+	maxSize int
+	clock   clock.Clock
+	observe []Observer
+	// flushes counts Flush calls, see Generation
+	flushes atomic.Uint64
+
+	// classes rank types for room (see Class); limits is how full the
+	// buffer may be for each class to be admitted
+	classes map[string]Class
+	limits  [classCount]int
+	dropped [classCount]atomic.Int64
+}
+
+// Observer sees every metric handed to the buffer, including ones dropped
+// for lack of room, so live aggregates do not depend on flush timing
+type Observer interface {
+	Observe(ms []Metric)
+}
+
+type shard struct {
 	mu      sync.RWMutex
 	metrics []Metric
-	maxSize int
+	// Positions in metrics by type and by resource, for ReadByTypes and
+	// ReadByResource
+	byType     map[string][]int32
+	byResource map[int64][]int32
+}
+
+// append adds m and indexes it. Callers hold the shard lock.
+func (sh *shard) append(m Metric) {
+	i := int32(len(sh.metrics))
+	sh.metrics = append(sh.metrics, m)
+	sh.byType[m.Type] = append(sh.byType[m.Type], i)
+	sh.byResource[m.ResourceID] = append(sh.byResource[m.ResourceID], i)
 }
 
 func NewRingBuffer(maxSize int) *RingBuffer {
-	return &RingBuffer{
-		metrics: make([]Metric, 0, maxSize),
+	rb := &RingBuffer{
 		maxSize: maxSize,
+		clock:   clock.Real,
+	}
+	rb.SetClasses(DefaultClasses())
+	for i := range rb.shards {
+		rb.shards[i].metrics = make([]Metric, 0, maxSize/shardCount)
+		rb.shards[i].byType = make(map[string][]int32)
+		rb.shards[i].byResource = make(map[int64][]int32)
+	}
+	return rb
+}
+
+// SetClock replaces the clock Recent measures against. Must be called
+// before the buffer is shared.
+func (rb *RingBuffer) SetClock(c clock.Clock) {
+	rb.clock = c
+}
+
+// SetClasses replaces the class of each metric type; types not listed are
+// best-effort. Must be called before the buffer is shared.
+func (rb *RingBuffer) SetClasses(classes map[string]Class) {
+	rb.classes = classes
+	for c, share := range classShare {
+		rb.limits[c] = int(float64(rb.maxSize) * share)
+	}
+}
+
+// ClassOf returns the class of a metric type
+func (rb *RingBuffer) ClassOf(metricType string) Class {
+	if c, ok := rb.classes[metricType]; ok {
+		return c
+	}
+	return BestEffort
+}
+
+// Dropped returns how many metrics of each class were refused for lack of
+// room since the buffer was created
+func (rb *RingBuffer) Dropped() map[string]int64 {
+	out := make(map[string]int64, classCount)
+	for c := range rb.dropped {
+		out[Class(c).String()] = rb.dropped[c].Load()
+	}
+	return out
+}
+
+// AddObserver registers o to be called on every Add and AddBatch. Must be
+// called before the buffer is shared.
+func (rb *RingBuffer) AddObserver(o Observer) {
+	rb.observe = append(rb.observe, o)
+}
+
+// shardOf spreads sequential resource IDs evenly (Fibonacci hashing)
+func shardOf(resourceID int64) int {
+	return int(uint64(resourceID) * 0x9E3779B97F4A7C15 >> 60)
+}
+
+// reserve claims room for up to n metrics of class c and returns how many
+// fit below the class's limit. Callers hold a shard lock, which keeps Flush
+// from resetting size between the reservation and the append.
+func (rb *RingBuffer) reserve(n int, c Class) int {
+	total := rb.size.Add(int64(n))
+	if over := int(total) - rb.limits[c]; over > 0 {
+		if over > n {
+			over = n
+		}
+		rb.size.Add(-int64(over))
+		rb.dropped[c].Add(int64(over))
+		n -= over
 	}
+	return n
 }
 
 func (rb *RingBuffer) Add(m Metric) {
-	rb.mu.Lock()
-	defer rb.mu.Unlock()
-
-	// Simple slice append for now.
-	// In a real circular buffer we would overwrite.
-	// Here we just append and rely on Flush to clear.
-	if len(rb.metrics) >= rb.maxSize {
-		// Drop oldest? or Block?
-		// For efficiency, let's just drop for now or expand.
-		// Dropping is safer for memory.
+	for _, o := range rb.observe {
+		o.Observe([]Metric{m})
+	}
+
+	sh := &rb.shards[shardOf(m.ResourceID)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	// Dropping is safer for memory than blocking ingest
+	if rb.reserve(1, rb.ClassOf(m.Type)) == 0 {
 		return
 	}
-	rb.metrics = append(rb.metrics, m)
+	sh.append(m)
+}
+
+// AddBatch appends many metrics taking each involved shard's lock once.
+// Metrics beyond their class's room are dropped, same as Add; within a
+// shard, higher classes reserve first.
+func (rb *RingBuffer) AddBatch(ms []Metric) {
+	for _, o := range rb.observe {
+		o.Observe(ms)
+	}
+
+	var counts [shardCount][classCount]int
+	for i := range ms {
+		counts[shardOf(ms[i].ResourceID)][rb.ClassOf(ms[i].Type)]++
+	}
+
+	for s, want := range counts {
+		if want == [classCount]int{} {
+			continue
+		}
+		sh := &rb.shards[s]
+		sh.mu.Lock()
+		var room [classCount]int
+		left := 0
+		for c := classCount - 1; c >= 0; c-- {
+			room[c] = rb.reserve(want[c], Class(c))
+			left += room[c]
+		}
+		for i := range ms {
+			if left == 0 {
+				break
+			}
+			if shardOf(ms[i].ResourceID) != s {
+				continue
+			}
+			if c := rb.ClassOf(ms[i].Type); room[c] > 0 {
+				sh.append(ms[i])
+				room[c]--
+				left--
+			}
+		}
+		sh.mu.Unlock()
+	}
 }
 
+// Flush empties the buffer and returns its contents. All shards are locked
+// for the swap, so no metric is returned twice or lost to a concurrent Add.
 func (rb *RingBuffer) Flush() []Metric {
-	rb.mu.Lock()
-	defer rb.mu.Unlock()
+	for i := range rb.shards {
+		rb.shards[i].mu.Lock()
+	}
+
+	out := make([]Metric, 0, rb.size.Load())
+	for i := range rb.shards {
+		sh := &rb.shards[i]
+		out = append(out, sh.metrics...)
+		// out is a copy, so the shard keeps its backing array
+		clear(sh.metrics)
+		sh.metrics = sh.metrics[:0]
+		// The set of types is small and stable, so their position slices
+		// are kept for reuse; resources come and go
+		for t, pos := range sh.byType {
+			sh.byType[t] = pos[:0]
+		}
+		clear(sh.byResource)
+	}
+	rb.size.Store(0)
+	rb.flushes.Add(1)
+
+	for i := range rb.shards {
+		rb.shards[i].mu.Unlock()
+	}
+	return out
+}
 
-	// Swap buffer
-	old := rb.metrics
-	rb.metrics = make([]Metric, 0, rb.maxSize)
-	return old
+// Generation counts flushes. Reads copy one shard at a time, so a read
+// that sees a different generation after it finished may have missed
+// metrics a concurrent flush took from shards it had not reached yet.
+func (rb *RingBuffer) Generation() uint64 {
+	return rb.flushes.Load()
 }
 
 func (rb *RingBuffer) ReadAll() []Metric {
-	rb.mu.RLock()
-	defer rb.mu.RUnlock()
+	return rb.read(func(Metric) bool { return true })
+}
+
+// Recent copies the metrics stamped within window of the clock's current
+// time. Samples stamped ahead of it are kept, since agent clocks drift.
+func (rb *RingBuffer) Recent(window time.Duration) []Metric {
+	cutoff := rb.clock.Now().Add(-window)
+	return rb.read(func(m Metric) bool { return m.Time.After(cutoff) })
+}
+
+// ReadByTypes copies the metrics of the given types, in the same order as
+// ReadAll, without scanning the others
+func (rb *RingBuffer) ReadByTypes(types ...string) []Metric {
+	types = slices.Compact(slices.Sorted(slices.Values(types)))
+	return rb.readIndexed(func(_ int, sh *shard) [][]int32 {
+		lists := make([][]int32, 0, len(types))
+		for _, t := range types {
+			lists = append(lists, sh.byType[t])
+		}
+		return lists
+	})
+}
+
+// ReadByResource copies the metrics of the given resources, in the same
+// order as ReadAll
+func (rb *RingBuffer) ReadByResource(ids ...int64) []Metric {
+	ids = slices.Compact(slices.Sorted(slices.Values(ids)))
+	var byShard [shardCount][]int64
+	for _, id := range ids {
+		s := shardOf(id)
+		byShard[s] = append(byShard[s], id)
+	}
+	return rb.readIndexed(func(s int, sh *shard) [][]int32 {
+		lists := make([][]int32, 0, len(byShard[s]))
+		for _, id := range byShard[s] {
+			lists = append(lists, sh.byResource[id])
+		}
+		return lists
+	})
+}
+
+// readIndexed copies the metrics at the positions lists returns for each
+// shard. Positions from several lists are merged back into buffer order.
+func (rb *RingBuffer) readIndexed(lists func(i int, sh *shard) [][]int32) []Metric {
+	var result []Metric
+	var merged []int32
+	for i := range rb.shards {
+		sh := &rb.shards[i]
+		sh.mu.RLock()
+		ls := lists(i, sh)
+		positions := merged[:0]
+		switch len(ls) {
+		case 0:
+		case 1:
+			positions = ls[0]
+		default:
+			for _, l := range ls {
+				positions = append(positions, l...)
+			}
+			slices.Sort(positions)
+			merged = positions
+		}
+		for _, p := range positions {
+			result = append(result, sh.metrics[p])
+		}
+		sh.mu.RUnlock()
+	}
+	if result == nil {
+		result = []Metric{}
+	}
+	return result
+}
 
-	// Copy to avoid race conditions during iteration by caller
-	result := make([]Metric, len(rb.metrics))
-	copy(result, rb.metrics)
+// read merges matching metrics from every shard into a fresh slice, so the
+// caller can iterate without holding any lock. Shards are read one at a
+// time; the result is consistent per resource, not across resources.
+func (rb *RingBuffer) read(keep func(Metric) bool) []Metric {
+	result := make([]Metric, 0, rb.size.Load())
+	for i := range rb.shards {
+		sh := &rb.shards[i]
+		sh.mu.RLock()
+		for _, m := range sh.metrics {
+			if keep(m) {
+				result = append(result, m)
+			}
+		}
+		sh.mu.RUnlock()
+	}
 	return result
 }
+
+// Len returns the number of metrics currently held in the buffer
+func (rb *RingBuffer) Len() int {
+	return int(rb.size.Load())
+}
+
+// Cap returns the maximum number of metrics the buffer holds before dropping
+func (rb *RingBuffer) Cap() int {
+	return rb.maxSize
+}
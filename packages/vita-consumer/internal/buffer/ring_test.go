@@ -0,0 +1,125 @@
+package buffer
+
+import (
+	"testing"
+	"time"
+)
+
+var benchTypes = []string{"cpu_ms", "mem_mb", "mem_limit_mb"}
+
+// benchBatch is one agent post's worth of metrics: three types for each
+// of pods pods
+func benchBatch(pods int) []Metric {
+	now := time.Now()
+	ms := make([]Metric, 0, pods*len(benchTypes))
+	for i := 0; i < pods; i++ {
+		for _, t := range benchTypes {
+			ms = append(ms, Metric{Time: now, ResourceID: int64(i + 1), Kind: "pod", Type: t, Value: float64(i)})
+		}
+	}
+	return ms
+}
+
+// BenchmarkAddBatch appends posts of 100 pods from parallel writers,
+// flushing whenever the buffer fills
+func BenchmarkAddBatch(b *testing.B) {
+	batch := benchBatch(100)
+	rb := NewRingBuffer(len(batch) * 64)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rb.AddBatch(batch)
+			if rb.Len() > rb.Cap()-len(batch) {
+				rb.Flush()
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N*len(batch))/b.Elapsed().Seconds(), "metrics/s")
+}
+
+// BenchmarkReadByTypes reads a full buffer the way the live API does,
+// while a writer keeps appending
+func BenchmarkReadByTypes(b *testing.B) {
+	batch := benchBatch(100)
+	rb := NewRingBuffer(len(batch) * 64)
+	for rb.Len() <= rb.Cap()-len(batch) {
+		rb.AddBatch(batch)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				rb.Flush()
+				for rb.Len() <= rb.Cap()-len(batch) {
+					rb.AddBatch(batch)
+				}
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			rb.ReadByTypes(benchTypes...)
+		}
+	})
+}
+
+// Indexed reads return what filtering ReadAll would, across a flush that
+// resets the indexes
+func TestTypedReads(t *testing.T) {
+	ring := NewRingBuffer(1000)
+	fill := func(from time.Time) {
+		for i := 0; i < 5; i++ {
+			var batch []Metric
+			for id := int64(1); id <= 40; id++ {
+				for _, typ := range []string{"cpu_ms", "mem_mb", "net_rx_bytes"} {
+					batch = append(batch, Metric{Time: from.Add(time.Duration(i) * time.Second), ResourceID: id, Type: typ, Value: float64(i)})
+				}
+			}
+			ring.AddBatch(batch)
+		}
+	}
+	same := func(name string, got []Metric, keep func(Metric) bool) {
+		t.Helper()
+		var want []Metric
+		for _, m := range ring.ReadAll() {
+			if keep(m) {
+				want = append(want, m)
+			}
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: %d metrics, want %d", name, len(got), len(want))
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("%s: metric %d = %+v, want %+v", name, i, got[i], want[i])
+			}
+		}
+	}
+
+	now := time.Now()
+	for round := 0; round < 2; round++ {
+		fill(now)
+		same("cpu and memory", ring.ReadByTypes("mem_mb", "cpu_ms", "mem_mb"), func(m Metric) bool {
+			return m.Type == "cpu_ms" || m.Type == "mem_mb"
+		})
+		same("two pods", ring.ReadByResource(7, 23), func(m Metric) bool {
+			return m.ResourceID == 7 || m.ResourceID == 23
+		})
+		if got := ring.ReadByTypes("disk_mb"); len(got) != 0 {
+			t.Fatalf("unknown type returned %d metrics", len(got))
+		}
+		ring.Flush()
+		if got := ring.ReadByTypes("cpu_ms"); len(got) != 0 {
+			t.Fatalf("after flush: %d cpu_ms metrics left", len(got))
+		}
+	}
+}
@@ -0,0 +1,275 @@
+// Package compression compresses HTTP responses with the best encoding the
+// client accepts (zstd, gzip or deflate by default). A response is held
+// back until it reaches the size threshold; smaller ones, those already
+// encoded or of compressed types, and streams flushed before the threshold
+// (watches) are sent as they are.
+package compression
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encodings offered by default, in order of preference
+var Encodings = []string{"zstd", "gzip", "deflate"}
+
+// DefaultMinSize is the smallest body compressed by default, in bytes
+const DefaultMinSize = 1024
+
+// stats holds <encoding>.responses, .bytes_in and .bytes_out
+var stats = expvar.NewMap("compression")
+
+// Config selects what is offered
+type Config struct {
+	// Encodings offered, in order of preference; empty disables compression
+	Encodings []string
+	// MinSize is the smallest body compressed, in bytes
+	MinSize int
+}
+
+// ParseEncodings reads a comma-separated list of encodings in order of
+// preference. "off" offers none.
+func ParseEncodings(spec string) ([]string, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return Encodings, nil
+	}
+	if spec == "off" {
+		return nil, nil
+	}
+	var out []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := pools[name]; !ok {
+			return nil, fmt.Errorf("unknown encoding %q (want zstd, gzip or deflate)", name)
+		}
+		if !slices.Contains(out, name) {
+			out = append(out, name)
+		}
+	}
+	return out, nil
+}
+
+// encoder is what the pooled writers have in common
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+var pools = map[string]*sync.Pool{
+	"zstd": {New: func() any {
+		e, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true))
+		return e
+	}},
+	"gzip": {New: func() any { return gzip.NewWriter(nil) }},
+	// HTTP's deflate is the zlib format
+	"deflate": {New: func() any { return zlib.NewWriter(nil) }},
+}
+
+// Handler compresses next's responses as cfg allows
+func Handler(next http.Handler, cfg Config) http.Handler {
+	if len(cfg.Encodings) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := Negotiate(r.Header.Get("Accept-Encoding"), cfg.Encodings)
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &writer{ResponseWriter: w, encoding: encoding, minSize: cfg.MinSize, status: http.StatusOK}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// Negotiate picks the offered encoding the Accept-Encoding header weighs
+// highest, the earlier offered on ties, or "" for none
+func Negotiate(header string, offered []string) string {
+	if header == "" {
+		return ""
+	}
+	weights := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		switch name {
+		case "*":
+			wildcard = q
+		case "x-gzip":
+			weights["gzip"] = q
+		default:
+			weights[name] = q
+		}
+	}
+	best, bestQ := "", 0.0
+	for _, enc := range offered {
+		q, ok := weights[enc]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// writer holds the body back until it reaches minSize, then decides
+// whether to compress it
+type writer struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status      int
+	wroteHeader bool // by the handler
+	decided     bool
+	buf         []byte
+	enc         encoder
+	out         countWriter
+	in          int64
+}
+
+func (w *writer) WriteHeader(code int) {
+	if w.decided || code < http.StatusOK {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) >= w.minSize {
+			if err := w.decide(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	if w.enc != nil {
+		w.in += int64(len(p))
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush sends a body still below the threshold uncompressed, so streams
+// are not held back
+func (w *writer) Flush() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *writer) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// decide writes the header, compressing when wanted and worthwhile, and
+// then what was held back
+func (w *writer) decide(compress bool) error {
+	w.decided = true
+	h := w.Header()
+	if compress && compressible(h, w.status) {
+		if h.Get("Content-Type") == "" {
+			// Sniffed from the plain body, as net/http would otherwise
+			// sniff the compressed one
+			h.Set("Content-Type", http.DetectContentType(w.buf))
+		}
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		w.out = countWriter{w: w.ResponseWriter}
+		w.enc = pools[w.encoding].Get().(encoder)
+		w.enc.Reset(&w.out)
+	}
+	if w.wroteHeader || w.enc != nil {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// close finishes the response once the handler returns
+func (w *writer) close() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.enc == nil {
+		return
+	}
+	w.enc.Close()
+	w.enc.Reset(nil)
+	pools[w.encoding].Put(w.enc)
+	w.enc = nil
+	stats.Add(w.encoding+".responses", 1)
+	stats.Add(w.encoding+".bytes_in", w.in)
+	stats.Add(w.encoding+".bytes_out", w.out.n)
+}
+
+// compressible reports whether a response is worth compressing: it has a
+// body, is not encoded already and is not of an already compressed type
+func compressible(h http.Header, status int) bool {
+	switch status {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	ct := strings.ToLower(h.Get("Content-Type"))
+	switch {
+	case strings.HasPrefix(ct, "image/") && !strings.HasPrefix(ct, "image/svg"),
+		strings.HasPrefix(ct, "video/"), strings.HasPrefix(ct, "audio/"), strings.HasPrefix(ct, "font/woff"),
+		strings.HasPrefix(ct, "application/gzip"), strings.HasPrefix(ct, "application/x-gzip"),
+		strings.HasPrefix(ct, "application/zip"), strings.HasPrefix(ct, "application/zstd"):
+		return false
+	}
+	return true
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,154 @@
+package compression_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/compression"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// API responses are compressed with the encoding the client prefers among
+// those offered, small and already encoded ones are sent as they are, and
+// watch streams are not held back
+func TestCompression(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		for _, c := range []struct {
+			header string
+			want   string
+		}{
+			{"gzip, deflate, br, zstd", "zstd"},
+			{"gzip;q=1, zstd;q=0.5", "gzip"},
+			{"deflate, zstd;q=0", "deflate"},
+			{"x-gzip", "gzip"},
+			{"*;q=0.1, zstd;q=0", "gzip"},
+			{"identity", ""},
+			{"br", ""},
+		} {
+			if got := compression.Negotiate(c.header, compression.Encodings); got != c.want {
+				return fmt.Errorf("Accept-Encoding %q negotiated %q, want %q", c.header, got, c.want)
+			}
+		}
+		if _, err := compression.ParseEncodings("gzip,br"); err == nil {
+			return fmt.Errorf("unknown encoding accepted")
+		}
+
+		// A catalog large enough to cross the threshold, served by the API
+		// behind the middleware
+		for i := range 20 {
+			if _, err := env.Client.CoreV1().Nodes().Create(ctx, synctest.Node(fmt.Sprintf("node-%02d", i), "4", "16Gi"), metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		if err := env.Eventually(synctest.Timeout, "nodes synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT count(*) FROM nodes")
+			return n == 20, err
+		}); err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/api/", env.API.Config.Handler)
+		mux.HandleFunc("/encoded", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write(bytes.Repeat([]byte("x"), 4096))
+			gz.Close()
+		})
+		mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"type":"heartbeat"}` + "\n"))
+			w.(http.Flusher).Flush()
+			w.Write(bytes.Repeat([]byte(`{"type":"heartbeat"}`+"\n"), 100))
+		})
+		handler := compression.Handler(mux, compression.Config{Encodings: compression.Encodings, MinSize: compression.DefaultMinSize})
+		get := func(path, accept string) (*httptest.ResponseRecorder, []byte, error) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("Accept-Encoding", accept)
+			handler.ServeHTTP(rec, req)
+			body := rec.Body.Bytes()
+			var rd io.Reader
+			switch rec.Header().Get("Content-Encoding") {
+			case "":
+				return rec, body, nil
+			case "gzip":
+				gz, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					return rec, nil, err
+				}
+				rd = gz
+			case "deflate":
+				zr, err := zlib.NewReader(bytes.NewReader(body))
+				if err != nil {
+					return rec, nil, err
+				}
+				rd = zr
+			case "zstd":
+				zr, err := zstd.NewReader(bytes.NewReader(body))
+				if err != nil {
+					return rec, nil, err
+				}
+				defer zr.Close()
+				rd = zr
+			}
+			plain, err := io.ReadAll(rd)
+			return rec, plain, err
+		}
+
+		_, plain, err := get("/api/v1/nodes", "")
+		if err != nil {
+			return err
+		}
+		if len(plain) < compression.DefaultMinSize {
+			return fmt.Errorf("node list is only %d bytes", len(plain))
+		}
+		for _, encoding := range compression.Encodings {
+			rec, body, err := get("/api/v1/nodes", encoding)
+			if err != nil {
+				return fmt.Errorf("%s: %w", encoding, err)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != encoding || rec.Body.Len() >= len(plain) {
+				return fmt.Errorf("%s: encoded %q, %d bytes of %d", encoding, got, rec.Body.Len(), len(plain))
+			}
+			if !bytes.Equal(body, plain) || rec.Header().Get("Content-Type") != "application/json" ||
+				rec.Header().Get("Content-Length") != "" || !strings.Contains(rec.Header().Get("Vary"), "Accept-Encoding") {
+				return fmt.Errorf("%s: body or headers differ: %v", encoding, rec.Header())
+			}
+		}
+
+		// Small, already encoded, and errors below the threshold pass through
+		for path, want := range map[string]int{
+			"/api/v1/namespaces":  http.StatusOK,
+			"/api/v1/pods/999999": http.StatusNotFound,
+			"/encoded":            http.StatusOK,
+		} {
+			rec, _, err := get(path, "gzip")
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if rec.Code != want || (path != "/encoded" && rec.Header().Get("Content-Encoding") != "") {
+				return fmt.Errorf("%s answered %d encoded %q", path, rec.Code, rec.Header().Get("Content-Encoding"))
+			}
+		}
+		// A stream flushed before the threshold goes out as it is
+		rec, body, err := get("/stream", "gzip")
+		if err != nil {
+			return err
+		}
+		if rec.Header().Get("Content-Encoding") != "" || !rec.Flushed || bytes.Count(body, []byte("\n")) != 101 {
+			return fmt.Errorf("stream encoded %q, flushed %v", rec.Header().Get("Content-Encoding"), rec.Flushed)
+		}
+		return nil
+	})
+}
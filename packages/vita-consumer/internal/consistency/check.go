@@ -0,0 +1,269 @@
+// Package consistency verifies the catalog and metrics invariants a crash
+// mid-write can break, on boot and on demand: catalog rows referencing
+// missing rows (pods of a lost node or namespace), points of resources no
+// longer in the catalog and node totals of purged nodes. Repair sets
+// broken optional references to NULL for the syncer to link again, moves
+// rows missing a required parent to the quarantine table, and deletes
+// orphaned points and node totals.
+package consistency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// quarantineListed is how many quarantined rows the endpoint returns
+const quarantineListed = 100
+
+// Finding is one broken invariant and what was done about it
+type Finding struct {
+	Check    string `json:"check"` // foreign_key, orphan_points or orphan_node_totals
+	Table    string `json:"table"`
+	Detail   string `json:"detail"`
+	Count    int64  `json:"count"`
+	Action   string `json:"action"` // cleared, quarantined, deleted, or none without repair
+	Repaired int64  `json:"repaired"`
+}
+
+// Report is the outcome of one check
+type Report struct {
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Trigger    string    `json:"trigger"` // "boot" or "manual"
+	Repair     bool      `json:"repair"`
+	Findings   []Finding `json:"findings"`
+	Errors     []string  `json:"errors,omitempty"`
+}
+
+// Checker runs checks and keeps the latest report
+type Checker struct {
+	sqlite *store.SQLiteStore
+	duck   *store.DuckDBStore
+
+	running sync.Mutex // held for the duration of a check
+	mu      sync.RWMutex
+	last    *Report
+}
+
+func NewChecker(sqlite *store.SQLiteStore, duck *store.DuckDBStore) *Checker {
+	return &Checker{sqlite: sqlite, duck: duck}
+}
+
+// Run checks every invariant and, with repair, fixes what it finds. The
+// boot check runs before the syncer and ingest start, so nothing writes
+// concurrently; later checks may briefly find points of a resource purged
+// moments ago.
+func (c *Checker) Run(ctx context.Context, trigger string, repair bool) Report {
+	c.running.Lock()
+	defer c.running.Unlock()
+
+	rep := Report{StartedAt: time.Now(), Trigger: trigger, Repair: repair, Findings: []Finding{}}
+	fail := func(check string, err error) {
+		rep.Errors = append(rep.Errors, fmt.Sprintf("%s: %v", check, err))
+	}
+	if err := c.checkForeignKeys(&rep, repair); err != nil {
+		fail("foreign_key", err)
+	}
+	// After the catalog repair, so points of quarantined rows go too
+	if err := c.checkPoints(ctx, &rep, repair); err != nil {
+		fail("orphan_points", err)
+	}
+	if err := c.checkNodeTotals(ctx, &rep, repair); err != nil {
+		fail("orphan_node_totals", err)
+	}
+	rep.DurationMs = time.Since(rep.StartedAt).Milliseconds()
+
+	var found int64
+	for _, f := range rep.Findings {
+		found += f.Count
+	}
+	switch {
+	case found > 0 && repair:
+		log.Printf("Consistency check found %d inconsistencies in %d places and repaired them (%dms)", found, len(rep.Findings), rep.DurationMs)
+	case found > 0:
+		log.Printf("Consistency check found %d inconsistencies in %d places, not repaired (%dms)", found, len(rep.Findings), rep.DurationMs)
+	}
+	for _, e := range rep.Errors {
+		log.Printf("Consistency check failed: %s", e)
+	}
+
+	c.mu.Lock()
+	c.last = &rep
+	c.mu.Unlock()
+	return rep
+}
+
+// checkForeignKeys clears broken optional references and quarantines rows
+// missing a required parent
+func (c *Checker) checkForeignKeys(rep *Report, repair bool) error {
+	violations, err := c.sqlite.ForeignKeyViolations()
+	if err != nil {
+		return err
+	}
+	findings := make(map[string]*Finding)
+	var order []string
+	now := time.Now()
+	for _, v := range violations {
+		key := v.Table + "." + v.Column
+		f, ok := findings[key]
+		if !ok {
+			f = &Finding{Check: "foreign_key", Table: v.Table, Detail: fmt.Sprintf("%s references missing %s", v.Column, v.Parent), Action: "none"}
+			findings[key] = f
+			order = append(order, key)
+		}
+		f.Count++
+		if !repair || v.RowID == 0 {
+			continue
+		}
+		if v.Nullable {
+			f.Action = "cleared"
+			err = c.sqlite.ClearReference(v)
+		} else {
+			f.Action = "quarantined"
+			err = c.sqlite.Quarantine(v.Table, v.RowID, f.Detail, now)
+		}
+		if err != nil {
+			rep.Errors = append(rep.Errors, fmt.Sprintf("foreign_key: %s row %d: %v", v.Table, v.RowID, err))
+			continue
+		}
+		f.Repaired++
+	}
+	for _, key := range order {
+		rep.Findings = append(rep.Findings, *findings[key])
+	}
+	return nil
+}
+
+// checkPoints deletes points of resources missing from the catalog, by
+// the kind their series record. Series of unknown kind, volume series
+// written before series recorded one among them, are not checked: their
+// id may be a pod's or a claim's.
+func (c *Checker) checkPoints(ctx context.Context, rep *Report, repair bool) error {
+	byKind, err := c.duck.SeriesResources(ctx)
+	if err != nil {
+		return err
+	}
+	kinds := slices.Sorted(maps.Keys(byKind))
+
+	var deleted int64
+	for _, kind := range kinds {
+		table := store.KindTable(kind)
+		if table == "" {
+			continue // e.g. the cluster-wide outputs of recording rules
+		}
+		known, err := c.sqlite.ResourceIDSet(table)
+		if err != nil {
+			return err
+		}
+		var orphans []int64
+		for _, id := range byKind[kind] {
+			if !known[id] {
+				orphans = append(orphans, id)
+			}
+		}
+		if len(orphans) == 0 {
+			continue
+		}
+		f := Finding{Check: "orphan_points", Table: table, Detail: fmt.Sprintf("%d %ss not in the catalog", len(orphans), kind), Count: int64(len(orphans)), Action: "none"}
+		if repair {
+			n, err := c.duck.DeleteResourcePoints(kind, orphans)
+			if err != nil {
+				return err
+			}
+			f.Action, f.Repaired = "deleted", int64(len(orphans))
+			f.Detail += fmt.Sprintf(", %d points", n)
+			deleted += n
+		}
+		rep.Findings = append(rep.Findings, f)
+	}
+	if deleted > 0 {
+		if _, err := c.duck.DeleteEmptySeries(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkNodeTotals deletes node totals of nodes missing from the catalog.
+// Node 0 is the cluster.
+func (c *Checker) checkNodeTotals(ctx context.Context, rep *Report, repair bool) error {
+	known, err := c.sqlite.ResourceIDSet("nodes")
+	if err != nil {
+		return err
+	}
+	latest, err := c.duck.LatestNodeTotals(ctx)
+	if err != nil {
+		return err
+	}
+	var orphans []int64
+	for id := range latest {
+		if !known[id] {
+			orphans = append(orphans, id)
+		}
+	}
+	if len(orphans) == 0 {
+		return nil
+	}
+	slices.Sort(orphans)
+	f := Finding{Check: "orphan_node_totals", Table: "node_totals", Detail: fmt.Sprintf("nodes %v not in the catalog", orphans), Count: int64(len(orphans)), Action: "none"}
+	if repair {
+		for _, id := range orphans {
+			if _, err := c.duck.DeleteNodeTotals(id); err != nil {
+				return err
+			}
+			f.Repaired++
+		}
+		f.Action = "deleted"
+	}
+	rep.Findings = append(rep.Findings, f)
+	return nil
+}
+
+// Last returns the latest report, nil before the first check
+func (c *Checker) Last() *Report {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.last
+}
+
+func (c *Checker) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/internal/consistency", c.handleConsistency)
+	mux.HandleFunc("GET /internal/consistency/quarantine", c.handleQuarantine)
+}
+
+// handleConsistency returns the latest report on GET and checks again on
+// POST, repairing only with ?repair=true
+func (c *Checker) handleConsistency(w http.ResponseWriter, r *http.Request) {
+	var body interface{}
+	switch r.Method {
+	case http.MethodGet:
+		body = c.Last()
+	case http.MethodPost:
+		body = c.Run(r.Context(), "manual", r.URL.Query().Get("repair") == "true")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// handleQuarantine lists the latest quarantined rows
+func (c *Checker) handleQuarantine(w http.ResponseWriter, r *http.Request) {
+	rows, err := c.sqlite.ListQuarantine(quarantineListed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rows)
+}
@@ -0,0 +1,163 @@
+package consistency_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/consistency"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+)
+
+// Rows written around the catalog's foreign keys, as a
+// crash mid-write leaves them, are reported, then repaired: a pod of a lost
+// node is quarantined with its claims and points, a lost deployment link is
+// cleared, and points and node totals of unknown resources are deleted
+func TestConsistency(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		// A second connection without foreign key enforcement stands in for
+		// the partial write
+		raw, err := sql.Open("sqlite3", env.SQLite.Path())
+		if err != nil {
+			return err
+		}
+		defer raw.Close()
+		var nodeID, nsID, ghostID, strayID int64
+		for _, step := range []struct {
+			query string
+			id    *int64
+		}{
+			{"INSERT INTO nodes (uid, name) VALUES ('node-uid', 'node-a')", &nodeID},
+			{"INSERT INTO namespaces (name) VALUES ('crashed')", &nsID},
+			{"INSERT INTO pods (uid, name, namespace_id, node_id) VALUES ('ghost-uid', 'ghost', (SELECT id FROM namespaces WHERE name = 'crashed'), 999999)", &ghostID},
+			{"INSERT INTO pods (uid, name, namespace_id, node_id, deployment_id) VALUES ('stray-uid', 'stray', (SELECT id FROM namespaces WHERE name = 'crashed'), (SELECT id FROM nodes WHERE name = 'node-a'), 888888)", &strayID},
+			{"INSERT INTO pod_claims (pod_id, name) VALUES ((SELECT id FROM pods WHERE name = 'ghost'), 'data')", nil},
+		} {
+			res, err := raw.Exec(step.query)
+			if err != nil {
+				return err
+			}
+			if step.id != nil {
+				*step.id, _ = res.LastInsertId()
+			}
+		}
+		now := env.Clock.Now()
+		var points []store.MetricPoint
+		for _, id := range []int64{ghostID, strayID, 777777} {
+			points = append(points, store.MetricPoint{Time: now.Add(-time.Hour), ResourceID: id, ResourceKind: "pod", MetricType: "cpu_ms", Value: 1})
+		}
+		// A live pod's emptyDir usage shares its types with claims, and a
+		// volume series written before kinds were recorded cannot be told
+		// either way; neither is orphaned. The claim is.
+		points = append(points,
+			store.MetricPoint{Time: now.Add(-time.Hour), ResourceID: strayID, ResourceKind: "pod", MetricType: "used_mb", Value: 1},
+			store.MetricPoint{Time: now.Add(-time.Hour), ResourceID: 777777, MetricType: "used_mb", Value: 1},
+			store.MetricPoint{Time: now.Add(-time.Hour), ResourceID: 555555, ResourceKind: "pvc", MetricType: "used_mb", Value: 1},
+		)
+		if err := env.Duck.BatchInsert(points); err != nil {
+			return err
+		}
+		if err := env.Duck.InsertNodeTotals([]store.NodeTotal{
+			{Time: now.Add(-time.Hour), NodeID: 0, MetricType: "cpu_ms", Value: 1},
+			{Time: now.Add(-time.Hour), NodeID: nodeID, MetricType: "cpu_ms", Value: 1},
+			{Time: now.Add(-time.Hour), NodeID: 666666, MetricType: "cpu_ms", Value: 1},
+		}); err != nil {
+			return err
+		}
+
+		checker := consistency.NewChecker(env.SQLite, env.Duck)
+		mux := http.NewServeMux()
+		checker.RegisterRoutes(mux)
+		call := func(method, path string, out interface{}) error {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+			if rec.Code != http.StatusOK {
+				return fmt.Errorf("%s %s answered %d", method, path, rec.Code)
+			}
+			return json.Unmarshal(rec.Body.Bytes(), out)
+		}
+		summary := func(rep consistency.Report) map[string]string {
+			out := make(map[string]string)
+			for _, f := range rep.Findings {
+				out[f.Check+" "+f.Table] = fmt.Sprintf("%d %s %d", f.Count, f.Action, f.Repaired)
+			}
+			return out
+		}
+
+		// Reported only: nothing changes
+		var rep consistency.Report
+		if err := call(http.MethodPost, "/internal/consistency", &rep); err != nil {
+			return err
+		}
+		// The lost node and the lost deployment are two findings on pods
+		got := summary(rep)
+		if len(rep.Findings) != 5 || got["orphan_points pods"] != "1 none 0" || got["orphan_points pvcs"] != "1 none 0" ||
+			got["orphan_node_totals node_totals"] != "1 none 0" || len(rep.Errors) != 0 {
+			return fmt.Errorf("report-only check = %+v", rep)
+		}
+		if n, err := env.QueryInt("SELECT count(*) FROM pods WHERE name IN ('ghost', 'stray')"); err != nil || n != 2 {
+			return fmt.Errorf("report-only check changed pods (%d, %v)", n, err)
+		}
+
+		rep = checker.Run(ctx, "boot", true)
+		byDetail := make(map[string]consistency.Finding)
+		for _, f := range rep.Findings {
+			byDetail[f.Detail] = f
+		}
+		lost, link := byDetail["node_id references missing nodes"], byDetail["deployment_id references missing deployments"]
+		if lost.Action != "quarantined" || lost.Repaired != 1 || link.Action != "cleared" || link.Repaired != 1 || len(rep.Errors) != 0 {
+			return fmt.Errorf("repair = %+v", rep)
+		}
+		// The quarantined pod's points are now orphans too
+		if got := summary(rep); got["orphan_points pods"] != "2 deleted 2" || got["orphan_points pvcs"] != "1 deleted 1" {
+			return fmt.Errorf("orphan points = %v", got)
+		}
+		if n, err := env.QueryInt(`SELECT
+		(SELECT count(*) FROM pods WHERE name = 'stray' AND deployment_id IS NULL)
+		+ (SELECT count(*) FROM pods WHERE name = 'ghost')
+		+ (SELECT count(*) FROM pod_claims)`); err != nil || n != 1 {
+			return fmt.Errorf("catalog after repair (%d, %v)", n, err)
+		}
+		for id, want := range map[int64]int64{strayID: 1, ghostID: 0, 777777: 0} {
+			if n, err := env.Duck.CountSeries(ctx, id, "cpu_ms", now.Add(-2*time.Hour), now); err != nil || n != want {
+				return fmt.Errorf("pod %d kept %d points, want %d (%v)", id, n, want, err)
+			}
+		}
+		for id, want := range map[int64]int64{strayID: 1, 777777: 1, 555555: 0} {
+			if n, err := env.Duck.CountSeries(ctx, id, "used_mb", now.Add(-2*time.Hour), now); err != nil || n != want {
+				return fmt.Errorf("resource %d kept %d volume points, want %d (%v)", id, n, want, err)
+			}
+		}
+		totals, err := env.Duck.LatestNodeTotals(ctx)
+		if err != nil {
+			return err
+		}
+		if _, ok := totals[666666]; ok || len(totals) != 1 {
+			return fmt.Errorf("node totals after repair = %v", totals)
+		}
+
+		var quarantined []store.QuarantinedRow
+		if err := call(http.MethodGet, "/internal/consistency/quarantine", &quarantined); err != nil {
+			return err
+		}
+		var row map[string]interface{}
+		if len(quarantined) != 1 || quarantined[0].Table != "pods" || json.Unmarshal(quarantined[0].Data, &row) != nil || row["name"] != "ghost" {
+			return fmt.Errorf("quarantine = %+v", quarantined)
+		}
+		// A second check finds nothing, and the latest report is served
+		checker.Run(ctx, "manual", true)
+		if err := call(http.MethodGet, "/internal/consistency", &rep); err != nil {
+			return err
+		}
+		if len(rep.Findings) != 0 || rep.Trigger != "manual" {
+			return fmt.Errorf("second check = %+v", rep)
+		}
+		return nil
+	})
+}
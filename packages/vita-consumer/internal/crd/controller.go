@@ -0,0 +1,198 @@
+// Package crd syncs monitoring settings declared as custom resources into
+// the consumer, so they can live in Git next to the workloads they watch:
+// a VitaRule becomes a recording rule and a VitaReport a report template.
+// Synced settings are read-only through the API and removed with their
+// resource. Alerting is configured through SLOs and has no resource yet,
+// nor do tenants, which the consumer does not model.
+package crd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Group is the API group of vitakube's custom resources
+const Group = "vitakube.io"
+
+var (
+	RuleResource   = schema.GroupVersionResource{Group: Group, Version: "v1alpha1", Resource: "vitarules"}
+	ReportResource = schema.GroupVersionResource{Group: Group, Version: "v1alpha1", Resource: "vitareports"}
+)
+
+// crdPollInterval is how often a missing resource definition is looked
+// for again
+const crdPollInterval = time.Minute
+
+// Controller watches the custom resources and keeps the settings synced
+// from them up to date
+type Controller struct {
+	client dynamic.Interface
+	sqlite *store.SQLiteStore
+	resync time.Duration
+}
+
+// kindSync ties a resource to the setting kind it is synced into
+type kindSync struct {
+	gvr    schema.GroupVersionResource
+	crKind string // "VitaRule"
+	kind   string // "rule", see store.ManagedBy
+	apply  func(u *unstructured.Unstructured, ref string) error
+}
+
+func NewController(client dynamic.Interface, sqlite *store.SQLiteStore) *Controller {
+	return &Controller{client: client, sqlite: sqlite, resync: 10 * time.Minute}
+}
+
+// Connect builds a controller for the cluster in kubeConfigPath (empty:
+// in-cluster config)
+func Connect(kubeConfigPath string, sqlite *store.SQLiteStore) (*Controller, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build kube config: %w", err)
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	return NewController(client, sqlite), nil
+}
+
+// Run watches every resource until ctx is done. A resource whose
+// definition is not installed is looked for again every minute.
+func (c *Controller) Run(ctx context.Context) {
+	kinds := []kindSync{
+		{RuleResource, "VitaRule", "rule", c.applyRule},
+		{ReportResource, "VitaReport", "report", c.applyReport},
+	}
+	for _, k := range kinds {
+		go c.watch(ctx, k)
+	}
+	<-ctx.Done()
+}
+
+func (c *Controller) watch(ctx context.Context, k kindSync) {
+	logged := false
+	for {
+		_, err := c.client.Resource(k.gvr).List(ctx, metav1.ListOptions{Limit: 1})
+		if err == nil {
+			break
+		}
+		if !logged {
+			if apierrors.IsNotFound(err) {
+				log.Printf("%s resources are not installed, looking again every %s", k.crKind, crdPollInterval)
+			} else {
+				log.Printf("Failed to list %s resources, retrying every %s: %v", k.crKind, crdPollInterval, err)
+			}
+			logged = true
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(crdPollInterval):
+		}
+	}
+
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(c.client, c.resync)
+	informer := factory.ForResource(k.gvr).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.sync(k, obj) },
+		UpdateFunc: func(_, obj interface{}) { c.sync(k, obj) },
+		DeleteFunc: func(obj interface{}) { c.remove(k, obj) },
+	})
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return
+	}
+	c.prune(k, informer.GetStore())
+	log.Printf("Syncing %s resources", k.crKind)
+}
+
+// ref names the resource a setting is synced from, e.g. "VitaRule ns/name"
+func ref(k kindSync, u *unstructured.Unstructured) string {
+	return k.crKind + " " + u.GetNamespace() + "/" + u.GetName()
+}
+
+func (c *Controller) sync(k kindSync, obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	r := ref(k, u)
+	if err := k.apply(u, r); err != nil {
+		log.Printf("Failed to sync %s: %v", r, err)
+	}
+}
+
+func (c *Controller) remove(k kindSync, obj interface{}) {
+	if tomb, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tomb.Obj
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	r := ref(k, u)
+	if err := c.sqlite.DeleteManaged(k.kind, r); err != nil {
+		log.Printf("Failed to remove the %s synced from %s: %v", k.kind, r, err)
+	}
+}
+
+// prune removes settings whose resource was deleted while the consumer
+// was not watching
+func (c *Controller) prune(k kindSync, objects cache.Store) {
+	live := make(map[string]bool)
+	for _, obj := range objects.List() {
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			live[ref(k, u)] = true
+		}
+	}
+	managed, err := c.sqlite.ManagedNames(k.kind)
+	if err != nil {
+		log.Printf("Failed to list synced %ss: %v", k.kind, err)
+		return
+	}
+	for r, name := range managed {
+		if live[r] {
+			continue
+		}
+		if err := c.sqlite.DeleteManaged(k.kind, r); err != nil {
+			log.Printf("Failed to remove %s %q of deleted %s: %v", k.kind, name, r, err)
+			continue
+		}
+		log.Printf("Removed %s %q: %s no longer exists", k.kind, name, r)
+	}
+}
+
+// claim checks that name is free for the resource r: not synced from
+// another resource. One created through the API is taken over. A setting
+// r synced under another name before is removed, as the resource was
+// renamed.
+func (c *Controller) claim(kind, name, r string) error {
+	owner, err := c.sqlite.ManagedBy(kind, name)
+	if err != nil {
+		return err
+	}
+	if owner != "" && owner != r {
+		return fmt.Errorf("%s %q is already synced from %s", kind, name, owner)
+	}
+	managed, err := c.sqlite.ManagedNames(kind)
+	if err != nil {
+		return err
+	}
+	if prev, ok := managed[r]; ok && prev != name {
+		return c.sqlite.DeleteManaged(kind, r)
+	}
+	return nil
+}
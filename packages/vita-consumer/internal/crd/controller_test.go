@@ -0,0 +1,116 @@
+package crd_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/crd"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/report"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/rules"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// VitaRule and VitaReport resources become a recording
+// rule and a report template that the API refuses to change, follow
+// updates and deletions, and settings of resources deleted while the
+// controller was down are removed when it starts
+func TestCRDConfig(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		if _, err := env.SQLite.UpsertRecordingRule(store.RecordingRule{
+			Name: "gone_rule", Expr: "sum(cpu_ms rate) by namespace", IntervalSec: 60, ManagedBy: "VitaRule gitops/gone-rule",
+		}); err != nil {
+			return err
+		}
+
+		client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			crd.RuleResource:   "VitaRuleList",
+			crd.ReportResource: "VitaReportList",
+		})
+		object := func(kind, name string, spec map[string]interface{}) *unstructured.Unstructured {
+			return &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": crd.Group + "/v1alpha1",
+				"kind":       kind,
+				"metadata":   map[string]interface{}{"namespace": "gitops", "name": name},
+				"spec":       spec,
+			}}
+		}
+		rulesAPI := client.Resource(crd.RuleResource).Namespace("gitops")
+		reportsAPI := client.Resource(crd.ReportResource).Namespace("gitops")
+		if _, err := rulesAPI.Create(ctx, object("VitaRule", "ns-cpu", map[string]interface{}{
+			"expr": "sum(cpu_ms rate) by namespace", "intervalSec": int64(120),
+		}), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if _, err := reportsAPI.Create(ctx, object("VitaReport", "weekly", map[string]interface{}{
+			"schedule": "0 8 * * 1", "delivery": "webhook", "target": "https://hooks.example.com/a", "owners": "team:payments",
+		}), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if _, err := reportsAPI.Create(ctx, object("VitaReport", "broken", map[string]interface{}{
+			"schedule": "every monday", "delivery": "webhook", "target": "https://hooks.example.com/b",
+		}), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+
+		cctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go crd.NewController(client, env.SQLite).Run(cctx)
+		if err := env.Eventually(synctest.Timeout, "resources synced", func() (bool, error) {
+			n, err := env.QueryInt(`SELECT
+			(SELECT count(*) FROM recording_rules WHERE name = 'ns_cpu' AND interval_sec = 120 AND managed_by = 'VitaRule gitops/ns-cpu')
+			+ (SELECT count(*) FROM report_templates WHERE name = 'weekly' AND owners = 'team:payments' AND format = 'html'
+				AND period_days = 7 AND managed_by = 'VitaReport gitops/weekly')
+			- (SELECT count(*) FROM recording_rules WHERE name = 'gone_rule')`)
+			return n == 2, err
+		}); err != nil {
+			return err
+		}
+		if n, err := env.QueryInt("SELECT count(*) FROM report_templates WHERE name = 'broken'"); err != nil || n != 0 {
+			return fmt.Errorf("invalid VitaReport synced (%d, %v)", n, err)
+		}
+
+		mux := http.NewServeMux()
+		rules.NewEngine(env.SQLite, env.Duck).RegisterRoutes(mux)
+		report.NewScheduler(env.SQLite, env.Duck, report.SMTPConfig{}).RegisterRoutes(mux)
+		post := func(path, body string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+			return rec
+		}
+		if rec := post("/api/v1/rules", `{"rule": "ns_cpu = sum(mem_mb) by namespace"}`); rec.Code != http.StatusConflict {
+			return fmt.Errorf("changing a synced rule answered %d: %s", rec.Code, rec.Body.String())
+		}
+		if rec := post("/api/v1/reports/templates", `{"name": "weekly", "schedule": "0 9 * * *", "delivery": "webhook", "target": "https://x"}`); rec.Code != http.StatusConflict {
+			return fmt.Errorf("changing a synced report answered %d: %s", rec.Code, rec.Body.String())
+		}
+
+		// An edit in Git is synced; the resource going away removes the rule
+		weekly, err := reportsAPI.Get(ctx, "weekly", metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		unstructured.SetNestedField(weekly.Object, "https://hooks.example.com/c", "spec", "target")
+		if _, err := reportsAPI.Update(ctx, weekly, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		if err := rulesAPI.Delete(ctx, "ns-cpu", metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+		return env.Eventually(synctest.Timeout, "changes synced", func() (bool, error) {
+			n, err := env.QueryInt(`SELECT
+			(SELECT count(*) FROM report_templates WHERE name = 'weekly' AND target = 'https://hooks.example.com/c')
+			+ (SELECT count(*) FROM recording_rules WHERE name = 'ns_cpu')`)
+			return n == 1, err
+		})
+	})
+}
@@ -0,0 +1,117 @@
+package crd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/report"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/rules"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// RuleSpec is a VitaRule's spec: a recording rule as /api/v1/rules takes it
+type RuleSpec struct {
+	Name        string `json:"name,omitempty"` // default: the resource name, dashes as underscores
+	Expr        string `json:"expr"`
+	IntervalSec int    `json:"intervalSec,omitempty"` // default 60
+	Enabled     *bool  `json:"enabled,omitempty"`
+}
+
+// ReportSpec is a VitaReport's spec: a report template as
+// /api/v1/reports/templates takes it
+type ReportSpec struct {
+	Name       string `json:"name,omitempty"`   // default: the resource name
+	Format     string `json:"format,omitempty"` // default html
+	Body       string `json:"body,omitempty"`
+	Schedule   string `json:"schedule"`
+	PeriodDays int    `json:"periodDays,omitempty"` // default 7
+	Timezone   string `json:"timezone,omitempty"`
+	Delivery   string `json:"delivery"`
+	Target     string `json:"target"`
+	GroupBy    string `json:"groupBy,omitempty"`
+	Owners     string `json:"owners,omitempty"`
+	Enabled    *bool  `json:"enabled,omitempty"`
+}
+
+func decodeSpec(u *unstructured.Unstructured, spec interface{}) error {
+	raw, ok := u.Object["spec"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("spec is missing")
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(raw, spec)
+}
+
+func (c *Controller) applyRule(u *unstructured.Unstructured, r string) error {
+	var spec RuleSpec
+	if err := decodeSpec(u, &spec); err != nil {
+		return err
+	}
+	if spec.Name == "" {
+		spec.Name = strings.ReplaceAll(u.GetName(), "-", "_")
+	}
+	if err := rules.ValidateName(spec.Name); err != nil {
+		return err
+	}
+	expr, err := rules.ParseExpr(spec.Expr)
+	if err != nil {
+		return err
+	}
+	if spec.IntervalSec == 0 {
+		spec.IntervalSec = 60
+	}
+	if spec.IntervalSec < 10 {
+		return fmt.Errorf("intervalSec must be at least 10")
+	}
+	if err := c.claim("rule", spec.Name, r); err != nil {
+		return err
+	}
+	// The rules engine registers the rule's units when it next evaluates
+	_, err = c.sqlite.UpsertRecordingRule(store.RecordingRule{
+		Name:        spec.Name,
+		Expr:        expr.String(),
+		IntervalSec: spec.IntervalSec,
+		Enabled:     spec.Enabled == nil || *spec.Enabled,
+		ManagedBy:   r,
+	})
+	return err
+}
+
+func (c *Controller) applyReport(u *unstructured.Unstructured, r string) error {
+	var spec ReportSpec
+	if err := decodeSpec(u, &spec); err != nil {
+		return err
+	}
+	t := store.ReportTemplate{
+		Name:       spec.Name,
+		Format:     spec.Format,
+		Body:       spec.Body,
+		Schedule:   spec.Schedule,
+		PeriodDays: spec.PeriodDays,
+		Timezone:   spec.Timezone,
+		Delivery:   spec.Delivery,
+		Target:     spec.Target,
+		GroupBy:    spec.GroupBy,
+		Owners:     spec.Owners,
+		Enabled:    spec.Enabled == nil || *spec.Enabled,
+		ManagedBy:  r,
+	}
+	if t.Name == "" {
+		t.Name = u.GetName()
+	}
+	if t.Format == "" {
+		t.Format = "html"
+	}
+	if t.PeriodDays == 0 {
+		t.PeriodDays = 7
+	}
+	if err := report.Validate(t); err != nil {
+		return err
+	}
+	if err := c.claim("report", t.Name, r); err != nil {
+		return err
+	}
+	_, err := c.sqlite.UpsertReportTemplate(t)
+	return err
+}
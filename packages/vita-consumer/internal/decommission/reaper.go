@@ -0,0 +1,130 @@
+// Package decommission retires nodes that are gone for good: deleted from
+// the cluster and silent on ingest for a configured period. Decommissioned
+// nodes are hidden from node lists and filters, and their node totals can
+// optionally be purged.
+package decommission
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// Config controls when a node is decommissioned
+type Config struct {
+	After        time.Duration // since deletion and the last sample
+	PurgeMetrics bool          // also delete the node's node totals
+}
+
+// Reaper periodically decommissions nodes that qualify
+type Reaper struct {
+	sqlite *store.SQLiteStore
+	duck   *store.DuckDBStore
+	cfg    Config
+}
+
+func NewReaper(sqlite *store.SQLiteStore, duck *store.DuckDBStore, cfg Config) *Reaper {
+	return &Reaper{sqlite: sqlite, duck: duck, cfg: cfg}
+}
+
+// RunOnce decommissions nodes gone for long enough, as a scheduled job
+func (r *Reaper) RunOnce(ctx context.Context, now time.Time) error {
+	_, err := r.Reap(ctx, now)
+	return err
+}
+
+// Reap decommissions every node that was deleted and has not reported
+// since before now minus After, and returns their names. A node's rows are
+// judged together, so a stub left by its pods cannot keep it alive or be
+// retired without it.
+func (r *Reaper) Reap(ctx context.Context, now time.Time) ([]string, error) {
+	cutoff := now.Add(-r.cfg.After)
+
+	rows, err := r.sqlite.NodeRows()
+	if err != nil {
+		return nil, err
+	}
+	latest, err := r.duck.LatestNodeTotals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Agents of nodes the informer never saw still count as reporting
+	agents, err := r.sqlite.ListAgentRegistrations("")
+	if err != nil {
+		return nil, err
+	}
+	agentSeen := make(map[string]time.Time, len(agents))
+	for _, a := range agents {
+		agentSeen[a.Node] = a.LastSeen
+	}
+
+	byName := make(map[string][]store.NodeRow)
+	var names []string
+	for _, n := range rows {
+		if _, ok := byName[n.Name]; !ok {
+			names = append(names, n.Name)
+		}
+		byName[n.Name] = append(byName[n.Name], n)
+	}
+
+	var reaped []string
+	for _, name := range names {
+		nodes := byName[name]
+		if !qualifies(nodes, latest, agentSeen[name], cutoff) {
+			continue
+		}
+		ids := make([]int64, len(nodes))
+		for i, n := range nodes {
+			ids[i] = n.ID
+		}
+		if err := r.sqlite.MarkDecommissioned(ids, now); err != nil {
+			return reaped, fmt.Errorf("decommission node %s: %w", name, err)
+		}
+		reaped = append(reaped, name)
+
+		summary := fmt.Sprintf("deleted and silent for over %s", r.cfg.After)
+		if r.cfg.PurgeMetrics {
+			var removed int64
+			for _, id := range ids {
+				n, err := r.duck.DeleteNodeTotals(id)
+				if err != nil {
+					log.Printf("Failed to purge node totals of %s: %v", name, err)
+					continue
+				}
+				removed += n
+			}
+			summary += fmt.Sprintf("; purged %d node totals", removed)
+		}
+		log.Printf("Decommissioned node %s: %s", name, summary)
+		if err := r.sqlite.InsertAnnotation(store.Annotation{
+			Time:    now,
+			Type:    "decommission",
+			Kind:    "node",
+			Name:    name,
+			Message: "Decommissioned: " + summary,
+		}); err != nil {
+			log.Printf("Failed to record decommission annotation: %v", err)
+		}
+	}
+	return reaped, nil
+}
+
+// qualifies reports whether every row of a node is deleted, none is
+// decommissioned yet and nothing reported after cutoff
+func qualifies(nodes []store.NodeRow, latest map[int64]time.Time, agentSeen, cutoff time.Time) bool {
+	if agentSeen.After(cutoff) {
+		return false
+	}
+	for _, n := range nodes {
+		if n.DecommissionedAt != nil || n.DeletedAt == nil || n.DeletedAt.After(cutoff) {
+			return false
+		}
+		if latest[n.ID].After(cutoff) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+package decommission_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/decommission"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestNodeDecommission retires a node deleted and silent past the
+// threshold, keeps one still reporting, and hides the retired one from
+// node lists and filters
+func TestNodeDecommission(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		for _, name := range []string{"node-a", "node-b", "node-c"} {
+			if _, err := env.Client.CoreV1().Nodes().Create(ctx, synctest.Node(name, "4", "8Gi"), metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		if err := env.Eventually(synctest.Timeout, "nodes synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM nodes")
+			return n == 3, err
+		}); err != nil {
+			return err
+		}
+		for _, name := range []string{"node-b", "node-c"} {
+			if err := env.Client.CoreV1().Nodes().Delete(ctx, name, metav1.DeleteOptions{}); err != nil {
+				return err
+			}
+		}
+		if err := env.Eventually(synctest.Timeout, "deletions synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM nodes WHERE deleted_at IS NOT NULL")
+			return n == 2, err
+		}); err != nil {
+			return err
+		}
+		ids := map[string]int64{}
+		for _, name := range []string{"node-a", "node-b", "node-c"} {
+			id, err := env.QueryInt("SELECT id FROM nodes WHERE name = ?", name)
+			if err != nil {
+				return err
+			}
+			ids[name] = int64(id)
+		}
+
+		// node-c keeps reporting through its pods after the deletion
+		now := time.Now()
+		reapAt := now.Add(8 * 24 * time.Hour)
+		if err := env.Duck.InsertNodeTotals([]store.NodeTotal{
+			{Time: now, NodeID: ids["node-a"], MetricType: "cpu_cores", Value: 1},
+			{Time: now, NodeID: ids["node-b"], MetricType: "cpu_cores", Value: 1},
+			{Time: reapAt.Add(-24 * time.Hour), NodeID: ids["node-c"], MetricType: "cpu_cores", Value: 1},
+		}); err != nil {
+			return err
+		}
+
+		reaper := decommission.NewReaper(env.SQLite, env.Duck, decommission.Config{After: 7 * 24 * time.Hour, PurgeMetrics: true})
+		reaped, err := reaper.Reap(ctx, reapAt)
+		if err != nil {
+			return err
+		}
+		if !slices.Equal(reaped, []string{"node-b"}) {
+			return fmt.Errorf("reaped %v, want [node-b]", reaped)
+		}
+		if again, err := reaper.Reap(ctx, reapAt); err != nil || len(again) != 0 {
+			return fmt.Errorf("second sweep reaped %v (%v), want none", again, err)
+		}
+		latest, err := env.Duck.LatestNodeTotals(ctx)
+		if err != nil {
+			return err
+		}
+		if _, ok := latest[ids["node-b"]]; ok || len(latest) != 2 {
+			return fmt.Errorf("node totals after purge cover %v, want node-a and node-c only", latest)
+		}
+		if n, err := env.QueryInt("SELECT COUNT(*) FROM annotations WHERE type = 'decommission' AND name = 'node-b'"); err != nil || n != 1 {
+			return fmt.Errorf("decommission annotations = %d (%v), want 1", n, err)
+		}
+
+		names := func(path string) ([]string, error) {
+			var nodes []api.Node
+			if err := env.GetJSON(path, &nodes); err != nil {
+				return nil, err
+			}
+			var out []string
+			for _, n := range nodes {
+				out = append(out, n.Name)
+				if (n.DecommissionedAt != nil) != (n.Name == "node-b") {
+					return nil, fmt.Errorf("%s decommissioned_at = %v", n.Name, n.DecommissionedAt)
+				}
+			}
+			return out, nil
+		}
+		listed, err := names("/api/v1/nodes")
+		if err != nil {
+			return err
+		}
+		if !slices.Equal(listed, []string{"node-a", "node-c"}) {
+			return fmt.Errorf("nodes = %v, want node-a and node-c", listed)
+		}
+		if listed, err = names("/api/v1/nodes?decommissioned=true"); err != nil {
+			return err
+		}
+		if !slices.Equal(listed, []string{"node-a", "node-b", "node-c"}) {
+			return fmt.Errorf("nodes with decommissioned = %v, want all three", listed)
+		}
+
+		var values api.ValuesResponse
+		if err := env.GetJSON("/api/v1/values?dimension=node", &values); err != nil {
+			return err
+		}
+		for _, v := range values.Values {
+			if v.Value == "node-b" {
+				return fmt.Errorf("decommissioned node offered as a filter value")
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,137 @@
+package diskguard
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+)
+
+// While the disk stays low the emergency callback runs again after
+// minPruneBackoff, doubling up to maxPruneBackoff: space freed by one prune
+// may be eaten by the next hour's data, but pruning on every check would
+// keep DuckDB rewriting files on a full disk
+const (
+	minPruneBackoff = time.Minute
+	maxPruneBackoff = 30 * time.Minute
+)
+
+var (
+	expDiskFree = expvar.NewInt("disk_free_bytes")
+	expDiskLow  = expvar.NewInt("disk_low")
+)
+
+// Monitor watches free space on the data directory. When it drops below
+// the configured minimum it flips into "low" state, which ingest uses to
+// reject writes, and runs the emergency callback (pruning) when it does and
+// again, backing off, for as long as it stays there.
+type Monitor struct {
+	dir      string
+	minFree  uint64
+	onLow    func()
+	interval time.Duration
+	clock    clock.Clock
+
+	mu    sync.RWMutex
+	low   bool
+	free  uint64
+	total uint64
+
+	// Touched only by check
+	pruneAt      time.Time
+	pruneBackoff time.Duration
+}
+
+func NewMonitor(dir string, minFreeBytes uint64, onLow func()) *Monitor {
+	return &Monitor{
+		dir:      dir,
+		minFree:  minFreeBytes,
+		onLow:    onLow,
+		interval: 30 * time.Second,
+		clock:    clock.Real,
+	}
+}
+
+// SetClock replaces the clock the prune backoff is timed with
+func (m *Monitor) SetClock(c clock.Clock) {
+	m.clock = c
+}
+
+// Run checks free space immediately and then on every interval until ctx ends
+func (m *Monitor) Run(ctx context.Context) {
+	m.check()
+
+	ticker := m.clock.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			m.check()
+		}
+	}
+}
+
+func (m *Monitor) check() {
+	free, total, err := freeSpace(m.dir)
+	if err != nil {
+		log.Printf("Disk monitor: %v", err)
+		return
+	}
+
+	low := free < m.minFree
+
+	m.mu.Lock()
+	wasLow := m.low
+	m.low, m.free, m.total = low, free, total
+	m.mu.Unlock()
+
+	expDiskFree.Set(int64(free))
+	if low {
+		expDiskLow.Set(1)
+	} else {
+		expDiskLow.Set(0)
+	}
+
+	now := m.clock.Now()
+	switch {
+	case low && !wasLow:
+		log.Printf("ALERT: data dir %s low on space (%d MB free, minimum %d MB); rejecting ingest and pruning",
+			m.dir, free>>20, m.minFree>>20)
+		m.pruneBackoff = minPruneBackoff
+		m.prune(now)
+	case low && !now.Before(m.pruneAt):
+		log.Printf("Data dir %s still low on space (%d MB free); pruning again", m.dir, free>>20)
+		m.pruneBackoff = min(2*m.pruneBackoff, maxPruneBackoff)
+		m.prune(now)
+	case !low && wasLow:
+		log.Printf("Disk space on %s recovered (%d MB free); resuming ingest", m.dir, free>>20)
+	}
+}
+
+// prune runs the emergency callback and schedules the next one in case
+// the disk stays low
+func (m *Monitor) prune(now time.Time) {
+	if m.onLow != nil {
+		m.onLow()
+	}
+	m.pruneAt = now.Add(m.pruneBackoff)
+}
+
+// Low reports whether the data dir is below the free space threshold
+func (m *Monitor) Low() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.low
+}
+
+// Usage returns the last observed free and total bytes
+func (m *Monitor) Usage() (free, total uint64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.free, m.total
+}
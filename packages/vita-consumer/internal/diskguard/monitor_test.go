@@ -0,0 +1,41 @@
+package diskguard
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+)
+
+// A disk that stays low is pruned again, less and less often
+func TestPruneBacksOffWhileLow(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	prunes := 0
+	m := NewMonitor(t.TempDir(), math.MaxUint64, func() { prunes++ })
+	m.SetClock(clk)
+
+	var at []time.Duration
+	start := clk.Now()
+	for range 2 * 60 * 2 { // two hours of 30s checks
+		before := prunes
+		m.check()
+		if prunes > before {
+			at = append(at, clk.Now().Sub(start))
+		}
+		clk.Advance(m.interval)
+	}
+	if !m.Low() {
+		t.Fatal("monitor not low below an unreachable minimum")
+	}
+
+	want := []time.Duration{0, time.Minute, 3 * time.Minute, 7 * time.Minute, 15 * time.Minute, 31 * time.Minute, 61 * time.Minute, 91 * time.Minute}
+	if len(at) != len(want) {
+		t.Fatalf("pruned at %v, want %v", at, want)
+	}
+	for i := range want {
+		if at[i] != want[i] {
+			t.Fatalf("pruned at %v, want %v", at, want)
+		}
+	}
+}
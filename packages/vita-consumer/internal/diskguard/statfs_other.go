@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package diskguard
+
+import "errors"
+
+func freeSpace(path string) (free, total uint64, err error) {
+	return 0, 0, errors.New("disk usage monitoring not supported on this platform")
+}
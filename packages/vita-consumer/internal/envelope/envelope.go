@@ -0,0 +1,254 @@
+// Package envelope encrypts files for storage outside the consumer: each
+// file gets a fresh data key, which is itself encrypted (wrapped) with a
+// key the consumer never writes down, from a key file or a KMS.
+//
+// A file is a header (magic, key ID, wrapped data key, nonce prefix)
+// followed by AES-256-GCM chunks of up to 64 KiB. Chunk nonces count up
+// from the prefix and the header and a last-chunk flag are authenticated
+// with every chunk, so chunks cannot be reordered, dropped or truncated.
+package envelope
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	magic     = "VKENV001"
+	chunkSize = 64 << 10
+	keySize   = 32
+)
+
+// Ext is appended to the names of encrypted files
+const Ext = ".enc"
+
+// KeyWrapper encrypts and decrypts data keys. ID names the key, so a file
+// can tell which key it needs.
+type KeyWrapper interface {
+	ID() string
+	Wrap(dataKey []byte) ([]byte, error)
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+// Encrypt reads plaintext from r and writes it encrypted to w under a new
+// data key wrapped by kw
+func Encrypt(w io.Writer, r io.Reader, kw KeyWrapper) error {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	wrapped, err := kw.Wrap(dataKey)
+	if err != nil {
+		return fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	prefix := make([]byte, 8)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	header, err := encodeHeader(kw.ID(), wrapped, prefix)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	// Read one chunk ahead so the last one can be flagged
+	buf, next := make([]byte, chunkSize), make([]byte, chunkSize)
+	n, err := io.ReadFull(r, buf)
+	for counter := uint32(0); ; counter++ {
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		last := err != nil
+		var m int
+		if !last {
+			m, err = io.ReadFull(r, next)
+			last = err == io.EOF
+		}
+		if err := writeChunk(w, aead, header, prefix, counter, buf[:n], last); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		buf, next, n = next, buf, m
+		if counter == ^uint32(0) {
+			return errors.New("file too large to encrypt")
+		}
+	}
+}
+
+// Decrypt reads an encrypted file from r and writes the plaintext to w.
+// Output already written is not trustworthy if an error is returned.
+func Decrypt(w io.Writer, r io.Reader, kw KeyWrapper) error {
+	br := bufio.NewReader(r)
+	header, id, wrapped, prefix, err := readHeader(br)
+	if err != nil {
+		return err
+	}
+	if id != kw.ID() {
+		return fmt.Errorf("file was encrypted with key %q, not %q", id, kw.ID())
+	}
+	dataKey, err := kw.Unwrap(wrapped)
+	if err != nil {
+		return fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+
+	for counter := uint32(0); ; counter++ {
+		var head [5]byte
+		if _, err := io.ReadFull(br, head[:]); err != nil {
+			return fmt.Errorf("truncated file: %w", err)
+		}
+		size := binary.BigEndian.Uint32(head[1:])
+		if size > chunkSize+uint32(aead.Overhead()) {
+			return errors.New("corrupt chunk length")
+		}
+		sealed := make([]byte, size)
+		if _, err := io.ReadFull(br, sealed); err != nil {
+			return fmt.Errorf("truncated file: %w", err)
+		}
+		last := head[0] == 1
+		plain, err := aead.Open(nil, nonce(prefix, counter), sealed, chunkAAD(header, last))
+		if err != nil {
+			return errors.New("chunk failed authentication")
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			if _, err := br.ReadByte(); err != io.EOF {
+				return errors.New("data after the last chunk")
+			}
+			return nil
+		}
+	}
+}
+
+// EncryptFile writes src encrypted to dst
+func EncryptFile(src, dst string, kw KeyWrapper) error {
+	return transformFile(src, dst, func(w io.Writer, r io.Reader) error { return Encrypt(w, r, kw) })
+}
+
+// DecryptFile writes src decrypted to dst
+func DecryptFile(src, dst string, kw KeyWrapper) error {
+	return transformFile(src, dst, func(w io.Writer, r io.Reader) error { return Decrypt(w, r, kw) })
+}
+
+func transformFile(src, dst string, fn func(io.Writer, io.Reader) error) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(out)
+	if err := fn(bw, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func nonce(prefix []byte, counter uint32) []byte {
+	n := make([]byte, 12)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[8:], counter)
+	return n
+}
+
+func chunkAAD(header []byte, last bool) []byte {
+	flag := byte(0)
+	if last {
+		flag = 1
+	}
+	return append(append([]byte{}, header...), flag)
+}
+
+func writeChunk(w io.Writer, aead cipher.AEAD, header, prefix []byte, counter uint32, plain []byte, last bool) error {
+	sealed := aead.Seal(nil, nonce(prefix, counter), plain, chunkAAD(header, last))
+	var head [5]byte
+	if last {
+		head[0] = 1
+	}
+	binary.BigEndian.PutUint32(head[1:], uint32(len(sealed)))
+	if _, err := w.Write(head[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(sealed)
+	return err
+}
+
+func encodeHeader(id string, wrapped, prefix []byte) ([]byte, error) {
+	if len(id) > 0xffff || len(wrapped) > 0xffff {
+		return nil, errors.New("key ID or wrapped key too long")
+	}
+	h := []byte(magic)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(id)))
+	h = append(h, id...)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(wrapped)))
+	h = append(h, wrapped...)
+	return append(h, prefix...), nil
+}
+
+func readHeader(r io.Reader) (header []byte, id string, wrapped, prefix []byte, err error) {
+	field := func(n int) []byte {
+		if err != nil {
+			return nil
+		}
+		b := make([]byte, n)
+		if _, err = io.ReadFull(r, b); err != nil {
+			err = fmt.Errorf("truncated header: %w", err)
+			return nil
+		}
+		header = append(header, b...)
+		return b
+	}
+	sized := func() []byte {
+		n := field(2)
+		if err != nil {
+			return nil
+		}
+		return field(int(binary.BigEndian.Uint16(n)))
+	}
+
+	if m := field(len(magic)); err == nil && string(m) != magic {
+		return nil, "", nil, nil, errors.New("not an encrypted export")
+	}
+	id = string(sized())
+	wrapped = sized()
+	prefix = field(8)
+	return header, id, wrapped, prefix, err
+}
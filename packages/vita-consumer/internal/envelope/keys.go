@@ -0,0 +1,141 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// FileKey wraps data keys with a 256-bit key read from a file
+type FileKey struct {
+	key []byte
+	id  string
+}
+
+// LoadKeyFile reads a key file holding 32 bytes, raw, hex or base64
+// encoded. Its ID is derived from the key, so files name the key without
+// revealing it.
+func LoadKeyFile(path string) (*FileKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := raw
+	if len(raw) != keySize {
+		text := strings.TrimSpace(string(raw))
+		if b, err := hex.DecodeString(text); err == nil && len(b) == keySize {
+			key = b
+		} else if b, err := base64.StdEncoding.DecodeString(text); err == nil && len(b) == keySize {
+			key = b
+		} else {
+			return nil, fmt.Errorf("%s: want a 32-byte key, raw, hex or base64", path)
+		}
+	}
+	sum := sha256.Sum256(key)
+	return &FileKey{key: key, id: "file:" + hex.EncodeToString(sum[:4])}, nil
+}
+
+func (k *FileKey) ID() string { return k.id }
+
+func (k *FileKey) Wrap(dataKey []byte) ([]byte, error) {
+	aead, err := newAEAD(k.key)
+	if err != nil {
+		return nil, err
+	}
+	n := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(n); err != nil {
+		return nil, err
+	}
+	return aead.Seal(n, n, dataKey, []byte(k.id)), nil
+}
+
+func (k *FileKey) Unwrap(wrapped []byte) ([]byte, error) {
+	aead, err := newAEAD(k.key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key too short")
+	}
+	n, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	return aead.Open(nil, n, sealed, []byte(k.id))
+}
+
+// VaultTransit wraps data keys with a key of a HashiCorp Vault (or
+// OpenBao) transit engine, so the key itself never leaves the KMS
+type VaultTransit struct {
+	addr, mount, key, token string
+	client                  *http.Client
+}
+
+// NewVaultTransit uses the transit key named key at addr, mounted at mount
+// (default "transit")
+func NewVaultTransit(addr, mount, key, token string) *VaultTransit {
+	if mount == "" {
+		mount = "transit"
+	}
+	return &VaultTransit{
+		addr:   strings.TrimSuffix(addr, "/"),
+		mount:  strings.Trim(mount, "/"),
+		key:    key,
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (v *VaultTransit) ID() string { return "vault:" + v.mount + "/" + v.key }
+
+func (v *VaultTransit) Wrap(dataKey []byte) ([]byte, error) {
+	var out struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	if err := v.call("encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}, &out); err != nil {
+		return nil, err
+	}
+	return []byte(out.Data.Ciphertext), nil
+}
+
+func (v *VaultTransit) Unwrap(wrapped []byte) ([]byte, error) {
+	var out struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := v.call("decrypt", map[string]string{"ciphertext": string(wrapped)}, &out); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(out.Data.Plaintext)
+}
+
+func (v *VaultTransit) call(op string, body map[string]string, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/v1/%s/%s/%s", v.addr, v.mount, op, v.key), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault transit %s: %s", op, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,126 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Bus is an in-process pub/sub fan-out. Every subscriber gets its own
+// buffered channel. Lossless subscribers apply backpressure to publishers
+// when full; best-effort subscribers drop events instead.
+//
+// Lossless subscribers must keep draining their channel until they
+// unsubscribe, otherwise publishes to them block; unsubscribing releases
+// a blocked publish.
+type Bus[T any] struct {
+	mu   sync.RWMutex
+	next int
+	subs map[int]*subscription[T]
+}
+
+type subscription[T any] struct {
+	name     string
+	ch       chan T
+	lossless bool
+	dropped  atomic.Int64
+	// done is closed on unsubscribe, releasing blocked publishers. mu is
+	// held by publishers while sending, so ch is only closed once none is.
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+}
+
+// SubscriberStats describes one subscriber's queue
+type SubscriberStats struct {
+	Name     string `json:"name"`
+	Lossless bool   `json:"lossless"`
+	Queued   int    `json:"queued"`
+	Dropped  int64  `json:"dropped"`
+}
+
+func NewBus[T any]() *Bus[T] {
+	return &Bus[T]{subs: make(map[int]*subscription[T])}
+}
+
+// Subscribe registers a subscriber with a channel of the given size and
+// returns the channel plus a function that unsubscribes and closes it.
+func (b *Bus[T]) Subscribe(name string, size int, lossless bool) (<-chan T, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.next
+	b.next++
+	sub := &subscription[T]{name: name, ch: make(chan T, size), lossless: lossless, done: make(chan struct{})}
+	b.subs[id] = sub
+
+	return sub.ch, func() {
+		b.mu.Lock()
+		s, ok := b.subs[id]
+		delete(b.subs, id)
+		b.mu.Unlock()
+		if !ok {
+			return
+		}
+		close(s.done)
+		s.mu.Lock()
+		s.closed = true
+		close(s.ch)
+		s.mu.Unlock()
+	}
+}
+
+// Publish delivers ev to every current subscriber. Sends happen outside
+// the bus lock: a full lossless subscriber holds up the publishers sending
+// to it, but not Subscribe, which would otherwise stall every publisher
+// queued behind it, nor unsubscribing.
+func (b *Bus[T]) Publish(ev T) {
+	b.mu.RLock()
+	subs := make([]*subscription[T], 0, len(b.subs))
+	for _, sub := range b.subs {
+		subs = append(subs, sub)
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.send(ev)
+	}
+}
+
+// send delivers ev unless the subscriber has unsubscribed: lossless ones
+// wait for room, best-effort ones drop it when full
+func (s *subscription[T]) send(ev T) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	if s.lossless {
+		select {
+		case s.ch <- ev:
+		case <-s.done:
+		}
+		return
+	}
+	select {
+	case s.ch <- ev:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Stats reports queue depth and drops per subscriber
+func (b *Bus[T]) Stats() []SubscriberStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	out := make([]SubscriberStats, 0, len(b.subs))
+	for _, sub := range b.subs {
+		out = append(out, SubscriberStats{
+			Name:     sub.name,
+			Lossless: sub.lossless,
+			Queued:   len(sub.ch),
+			Dropped:  sub.dropped.Load(),
+		})
+	}
+	return out
+}
@@ -0,0 +1,72 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+// A publisher blocked on a full lossless subscriber must not hold up
+// subscribing, and the subscriber unsubscribing must release it
+func TestBlockedPublishDoesNotHoldTheBus(t *testing.T) {
+	b := NewBus[int]()
+	_, unsubscribe := b.Subscribe("slow", 1, true)
+	b.Publish(1) // fills the channel
+
+	published := make(chan struct{})
+	go func() {
+		b.Publish(2)
+		close(published)
+	}()
+	time.Sleep(50 * time.Millisecond) // let it block
+	select {
+	case <-published:
+		t.Fatal("publish to a full lossless subscriber did not wait")
+	default:
+	}
+
+	subscribed := make(chan struct{})
+	go func() {
+		b.Subscribe("late", 1, false)
+		close(subscribed)
+	}()
+	select {
+	case <-subscribed:
+	case <-time.After(time.Second):
+		t.Fatal("Subscribe blocked behind a stuck publish")
+	}
+
+	unsubscribed := make(chan struct{})
+	go func() {
+		unsubscribe()
+		close(unsubscribed)
+	}()
+	for name, ch := range map[string]chan struct{}{"unsubscribe": unsubscribed, "publish": published} {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("%s did not return after unsubscribing", name)
+		}
+	}
+}
+
+func TestBestEffortSubscriberDrops(t *testing.T) {
+	b := NewBus[int]()
+	ch, unsubscribe := b.Subscribe("lossy", 1, false)
+	b.Publish(1)
+	b.Publish(2)
+
+	stats := b.Stats()
+	if len(stats) != 1 || stats[0].Queued != 1 || stats[0].Dropped != 1 {
+		t.Fatalf("stats = %+v, want 1 queued and 1 dropped", stats)
+	}
+	if got := <-ch; got != 1 {
+		t.Fatalf("received %d, want 1", got)
+	}
+
+	unsubscribe()
+	unsubscribe() // a second call is a no-op
+	if _, ok := <-ch; ok {
+		t.Fatal("channel still open after unsubscribing")
+	}
+	b.Publish(3) // no subscribers left
+}
@@ -0,0 +1,272 @@
+// Package health scores workloads on a schedule from their pods' restarts,
+// OOM kills, CPU throttling, usage against limits and containers stuck on
+// errors, and keeps the score history so the worst can be listed first.
+package health
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+const (
+	// Window is how far back restarts, OOM kills, throttling and
+	// saturation count towards a score
+	Window = time.Hour
+	// bucketStep is the averaging period saturation peaks are taken over
+	bucketStep = 5 * time.Minute
+	// saturationFloor is the share of a limit used before it costs points
+	saturationFloor = 80
+)
+
+// Penalties per signal, each capped, subtracted from a perfect 100
+const (
+	restartPenalty = 10 // per restart
+	restartMax     = 30
+	oomPenalty     = 20 // per OOM kill
+	oomMax         = 40
+	throttleMax    = 20 // half a point per throttled percent
+	saturationMax  = 20 // a point per percent above saturationFloor
+	errorPenalty   = 15 // per pod waiting on an error
+	errorMax       = 30
+)
+
+// Config controls scoring. Zero values take the defaults.
+type Config struct {
+	Interval  time.Duration // between scoring runs, default 5 minutes
+	Retention time.Duration // of score history, default 7 days
+}
+
+type workloadKey struct {
+	kind string
+	id   int64
+}
+
+// podSeen is a pod's container state at the previous run
+type podSeen struct {
+	restarts int32
+	oom      *time.Time
+}
+
+// incident is a restart or OOM kill observed between two runs
+type incident struct {
+	at             time.Time
+	restarts, ooms int
+}
+
+// Scorer computes and stores workload health scores
+type Scorer struct {
+	sqlite *store.SQLiteStore
+	duck   *store.DuckDBStore
+	cfg    Config
+
+	mu        sync.Mutex
+	primed    bool // pods holds a baseline
+	last      time.Time
+	pods      map[int64]podSeen
+	incidents map[workloadKey][]incident
+}
+
+func NewScorer(sqlite *store.SQLiteStore, duck *store.DuckDBStore, cfg Config) *Scorer {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Minute
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = 7 * 24 * time.Hour
+	}
+	return &Scorer{
+		sqlite:    sqlite,
+		duck:      duck,
+		cfg:       cfg,
+		pods:      make(map[int64]podSeen),
+		incidents: make(map[workloadKey][]incident),
+	}
+}
+
+// Interval is how often RunOnce is meant to be scheduled
+func (s *Scorer) Interval() time.Duration {
+	return s.cfg.Interval
+}
+
+// RunOnce scores workloads and prunes old scores, as a scheduled job.
+// Restarts and OOM kills are counted from the first run on: those from
+// before the consumer started are not held against a workload.
+func (s *Scorer) RunOnce(ctx context.Context, now time.Time) error {
+	scores, err := s.Score(ctx, now)
+	if err != nil {
+		return err
+	}
+	if err := s.sqlite.InsertWorkloadHealth(scores); err != nil {
+		return err
+	}
+	return s.sqlite.DeleteWorkloadHealthBefore(now.Add(-s.cfg.Retention))
+}
+
+// Score computes the current score of every workload with live pods. It
+// advances the restart and OOM baseline, so it is only called by Run and
+// harnesses driving the scorer directly.
+func (s *Scorer) Score(ctx context.Context, now time.Time) ([]store.WorkloadHealth, error) {
+	pods, err := s.sqlite.WorkloadPods()
+	if err != nil {
+		return nil, err
+	}
+	usage, err := s.usage(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.observeLocked(pods, now)
+
+	scores := make(map[workloadKey]*store.WorkloadHealth)
+	throttle := make(map[workloadKey]*[2]float64) // throttled, total periods
+	for _, p := range pods {
+		k := workloadKey{p.Kind, p.WorkloadID}
+		h := scores[k]
+		if h == nil {
+			h = &store.WorkloadHealth{Time: now, Kind: p.Kind, WorkloadID: p.WorkloadID}
+			for _, in := range s.incidents[k] {
+				h.Restarts += in.restarts
+				h.OOMKills += in.ooms
+			}
+			scores[k] = h
+			throttle[k] = &[2]float64{}
+		}
+		if p.Waiting != "" {
+			h.Errors++
+		}
+		u := usage[p.PodID]
+		if u == nil {
+			continue
+		}
+		throttle[k][0] += u.throttled
+		throttle[k][1] += u.periods
+		if p.CPULimitM != nil && *p.CPULimitM > 0 {
+			h.CPUSaturationPct = max(h.CPUSaturationPct, u.peakCPU/float64(*p.CPULimitM)*100)
+		}
+		if p.MemLimitMB != nil && *p.MemLimitMB > 0 {
+			h.MemSaturationPct = max(h.MemSaturationPct, u.peakMem / *p.MemLimitMB * 100)
+		}
+	}
+
+	out := make([]store.WorkloadHealth, 0, len(scores))
+	for k, h := range scores {
+		if t := throttle[k]; t[1] > 0 {
+			h.ThrottledPct = round(t[0] / t[1] * 100)
+		}
+		h.CPUSaturationPct = round(h.CPUSaturationPct)
+		h.MemSaturationPct = round(h.MemSaturationPct)
+		h.Score = score(*h)
+		out = append(out, *h)
+	}
+	return out, nil
+}
+
+// observeLocked counts restarts and OOM kills since the previous run and
+// drops incidents that left the window
+func (s *Scorer) observeLocked(pods []store.WorkloadPod, now time.Time) {
+	seen := make(map[int64]podSeen, len(pods))
+	for _, p := range pods {
+		seen[p.PodID] = podSeen{restarts: p.Restarts, oom: p.OOMKilledAt}
+		if !s.primed {
+			continue
+		}
+		var in incident
+		prev, ok := s.pods[p.PodID]
+		if ok {
+			in.restarts = int(max(0, p.Restarts-prev.restarts))
+		} else {
+			in.restarts = int(p.Restarts) // a new pod restarted since the last run
+		}
+		if p.OOMKilledAt != nil {
+			since := s.last
+			if ok && prev.oom != nil {
+				since = *prev.oom
+			}
+			if p.OOMKilledAt.After(since) {
+				in.ooms = 1
+			}
+		}
+		if in.restarts > 0 || in.ooms > 0 {
+			in.at = now
+			k := workloadKey{p.Kind, p.WorkloadID}
+			s.incidents[k] = append(s.incidents[k], in)
+		}
+	}
+	s.pods = seen
+	s.primed = true
+	s.last = now
+
+	cutoff := now.Add(-Window)
+	for k, list := range s.incidents {
+		i := 0
+		for i < len(list) && !list[i].at.After(cutoff) {
+			i++
+		}
+		if i == len(list) {
+			delete(s.incidents, k)
+		} else {
+			s.incidents[k] = list[i:]
+		}
+	}
+}
+
+// podUsage is one pod's CPU and memory over the window
+type podUsage struct {
+	throttled, periods float64 // CFS periods per second, summed over buckets
+	peakCPU            float64 // millicores, highest bucket average
+	peakMem            float64 // MB, highest bucket average
+}
+
+func (s *Scorer) usage(ctx context.Context, now time.Time) (map[int64]*podUsage, error) {
+	out := make(map[int64]*podUsage)
+	b := store.Bucketing{Step: bucketStep}
+	for _, q := range []struct{ metric, agg string }{
+		{"cpu_periods", "rate"},
+		{"cpu_throttled_periods", "rate"},
+		{"cpu_ms", "rate"},
+		{"mem_mb", "avg"},
+	} {
+		points, err := s.duck.QueryBucketedByResource(ctx, "pod", q.metric, now.Add(-Window), now, b, q.agg)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range points {
+			u := out[p.ResourceID]
+			if u == nil {
+				u = &podUsage{}
+				out[p.ResourceID] = u
+			}
+			switch q.metric {
+			case "cpu_periods":
+				u.periods += p.Value
+			case "cpu_throttled_periods":
+				u.throttled += p.Value
+			case "cpu_ms":
+				u.peakCPU = max(u.peakCPU, p.Value)
+			case "mem_mb":
+				u.peakMem = max(u.peakMem, p.Value)
+			}
+		}
+	}
+	return out, nil
+}
+
+// score turns the signals into 0-100, 100 being healthy
+func score(h store.WorkloadHealth) float64 {
+	penalty := min(float64(h.Restarts*restartPenalty), restartMax) +
+		min(float64(h.OOMKills*oomPenalty), oomMax) +
+		min(h.ThrottledPct/2, throttleMax) +
+		min(max(h.CPUSaturationPct, h.MemSaturationPct, saturationFloor)-saturationFloor, saturationMax) +
+		min(float64(h.Errors*errorPenalty), errorMax)
+	return round(max(0, 100-penalty))
+}
+
+func round(v float64) float64 {
+	return math.Round(v*10) / 10
+}
@@ -0,0 +1,91 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrBaseline refuses a delta-encoded post whose baseline the consumer does
+// not hold: it was never received, was superseded, or was lost in a
+// restart. The agent resends absolute values (base_seq 0).
+var ErrBaseline = errors.New("baseline not held")
+
+// baselineIdle is how long a node's baseline is kept without posts
+const baselineIdle = 10 * time.Minute
+
+// seriesKey identifies one series of one agent across posts
+type seriesKey struct {
+	metricType, podID, podUID, volume, containerID, key string
+}
+
+func seriesOf(m *RawMetric) seriesKey {
+	return seriesKey{m.Type, m.PodID, m.PodUID, m.Volume, m.ContainerID, m.Key}
+}
+
+// baseline is the absolute value of every series in a node's last
+// accepted sequenced post
+type baseline struct {
+	seq    uint64
+	values map[seriesKey]float64
+	used   time.Time
+}
+
+// baselines reconstruct delta-encoded posts (payload version 3): a post
+// with seq set may carry, instead of a value, the difference d against the
+// same series in the post numbered base_seq. Only the latest accepted post
+// of a node can be a baseline, so an agent sends deltas against the post
+// it last had accepted.
+type baselines struct {
+	mu    sync.Mutex
+	nodes map[string]*baseline
+}
+
+// expand replaces the deltas of req with absolute values. Nothing is
+// recorded until the returned commit is called, once the post is accepted.
+func (b *baselines) expand(req *IngestRequest, now time.Time) (commit func(), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var base map[seriesKey]float64
+	if req.BaseSeq != 0 {
+		held := b.nodes[req.NodeName]
+		if held == nil || held.seq != req.BaseSeq {
+			return nil, fmt.Errorf("%w: post %d of node %q is against post %d", ErrBaseline, req.Seq, req.NodeName, req.BaseSeq)
+		}
+		base = held.values
+	}
+
+	values := make(map[seriesKey]float64, len(req.Metrics))
+	for i := range req.Metrics {
+		m := &req.Metrics[i]
+		k := seriesOf(m)
+		if m.Delta != nil {
+			prev, ok := base[k]
+			if !ok {
+				return nil, fmt.Errorf("%w: post %d of node %q has a delta for %s without a value in post %d", ErrBaseline, req.Seq, req.NodeName, m.Key, req.BaseSeq)
+			}
+			m.Value, m.Delta = prev+*m.Delta, nil
+		}
+		values[k] = m.Value
+	}
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.nodes == nil {
+			b.nodes = make(map[string]*baseline)
+		}
+		if req.BaseSeq == 0 {
+			// Absolute posts start a node's sequence, e.g. after an agent
+			// restart; take the chance to forget nodes gone quiet
+			for node, held := range b.nodes {
+				if now.Sub(held.used) > baselineIdle {
+					delete(b.nodes, node)
+				}
+			}
+		}
+		b.nodes[req.NodeName] = &baseline{seq: req.Seq, values: values, used: now}
+	}, nil
+}
@@ -0,0 +1,101 @@
+package ingest_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/ingest"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestDeltaEncoding verifies sequenced posts are rebuilt from the node's
+// last accepted post, and that deltas against any other are refused
+func TestDeltaEncoding(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		pod := synctest.Pod("default", "deltas", "node-a", nil)
+		if _, err := env.Client.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pod synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pods")
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+
+		srv := ingest.NewIngestionServer(env.Ring, env.Resolver)
+		srv.SetClock(env.Clock)
+		slice := "kubepods-pod" + strings.ReplaceAll(string(pod.UID), "-", "_") + ".slice"
+		delta := func(d float64) *float64 { return &d }
+		post := func(seq, base uint64, metrics ...ingest.RawMetric) *httptest.ResponseRecorder {
+			ts := env.Clock.Now().Unix()
+			for i := range metrics {
+				metrics[i].Type, metrics[i].PodID, metrics[i].Timestamp = "container", slice, ts
+			}
+			env.Clock.Advance(time.Second)
+			body, _ := json.Marshal(ingest.IngestRequest{Version: ingest.CurrentVersion, NodeName: "node-a", Seq: seq, BaseSeq: base, Metrics: metrics})
+			rec := httptest.NewRecorder()
+			srv.HandleIngest(rec, httptest.NewRequest(http.MethodPost, "/api/v1/ingest", strings.NewReader(string(body))))
+			return rec
+		}
+		latest := func(metricType string) []float64 {
+			var out []float64
+			for _, m := range env.Ring.Recent(time.Minute) {
+				if m.Type == metricType {
+					out = append(out, m.Value)
+				}
+			}
+			return out
+		}
+
+		if rec := post(1, 0, ingest.RawMetric{Key: "cpu_ms", Value: 1000}, ingest.RawMetric{Key: "mem_mb", Value: 64}); rec.Code != http.StatusAccepted {
+			return fmt.Errorf("absolute post: %d %q", rec.Code, rec.Body.String())
+		}
+		if rec := post(2, 1, ingest.RawMetric{Key: "cpu_ms", Delta: delta(250)}, ingest.RawMetric{Key: "mem_mb", Delta: delta(-4)}); rec.Code != http.StatusAccepted {
+			return fmt.Errorf("delta post: %d %q", rec.Code, rec.Body.String())
+		}
+		// A series may switch back to absolute within a delta post
+		if rec := post(3, 2, ingest.RawMetric{Key: "cpu_ms", Delta: delta(250)}, ingest.RawMetric{Key: "mem_mb", Value: 70}); rec.Code != http.StatusAccepted {
+			return fmt.Errorf("mixed post: %d %q", rec.Code, rec.Body.String())
+		}
+		if got := latest("cpu_ms"); !slices.Equal(got, []float64{1000, 1250, 1500}) {
+			return fmt.Errorf("cpu_ms rebuilt as %v", got)
+		}
+		if got := latest("mem_mb"); !slices.Equal(got, []float64{64, 60, 70}) {
+			return fmt.Errorf("mem_mb rebuilt as %v", got)
+		}
+
+		// Superseded baselines and series missing from the baseline are
+		// refused without touching the buffer or the node's baseline
+		held := env.Ring.Len()
+		if rec := post(4, 2, ingest.RawMetric{Key: "cpu_ms", Delta: delta(1)}); rec.Code != http.StatusConflict {
+			return fmt.Errorf("delta against superseded post: %d", rec.Code)
+		}
+		if rec := post(4, 3, ingest.RawMetric{Key: "mem_rss_mb", Delta: delta(1)}); rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "baseline") {
+			return fmt.Errorf("delta for a new series: %d %q", rec.Code, rec.Body.String())
+		}
+		if env.Ring.Len() != held {
+			return fmt.Errorf("refused posts buffered %d points", env.Ring.Len()-held)
+		}
+		if rec := post(4, 3, ingest.RawMetric{Key: "cpu_ms", Delta: delta(100)}); rec.Code != http.StatusAccepted || latest("cpu_ms")[3] != 1600 {
+			return fmt.Errorf("delta after refusals: %d, cpu_ms %v", rec.Code, latest("cpu_ms"))
+		}
+
+		// An agent restart starts over with an absolute post
+		if rec := post(1, 0, ingest.RawMetric{Key: "cpu_ms", Value: 10}); rec.Code != http.StatusAccepted {
+			return fmt.Errorf("restarted agent: %d", rec.Code)
+		}
+		if rec := post(2, 1, ingest.RawMetric{Key: "cpu_ms", Delta: delta(5)}); rec.Code != http.StatusAccepted || latest("cpu_ms")[5] != 15 {
+			return fmt.Errorf("delta after restart: %d, cpu_ms %v", rec.Code, latest("cpu_ms"))
+		}
+		return nil
+	})
+}
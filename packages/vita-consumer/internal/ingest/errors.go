@@ -0,0 +1,64 @@
+package ingest
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxRecentErrors bounds the refused and failed posts kept for support
+const maxRecentErrors = 100
+
+// IngestError is an agent post that was refused or failed to process.
+// Status is the response code, 0 for a queued post that failed afterwards.
+type IngestError struct {
+	Time    time.Time `json:"time"`
+	Node    string    `json:"node,omitempty"`
+	Remote  string    `json:"remote,omitempty"`
+	Status  int       `json:"status"`
+	Message string    `json:"message"`
+}
+
+// errorLog keeps the latest maxRecentErrors errors
+type errorLog struct {
+	mu   sync.Mutex
+	ring []IngestError
+	next int
+}
+
+func (l *errorLog) add(e IngestError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.ring) < maxRecentErrors {
+		l.ring = append(l.ring, e)
+		return
+	}
+	l.ring[l.next] = e
+	l.next = (l.next + 1) % maxRecentErrors
+}
+
+// RecentErrors returns the latest refused or failed posts, newest first
+func (s *IngestionServer) RecentErrors() []IngestError {
+	l := &s.errors
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]IngestError, 0, len(l.ring))
+	for i := len(l.ring) - 1; i >= 0; i-- {
+		out = append(out, l.ring[(l.next+i)%len(l.ring)])
+	}
+	return out
+}
+
+// refuse answers a post with an error and records it. err, when set, is
+// kept as the detail the agent is not sent.
+func (s *IngestionServer) refuse(w http.ResponseWriter, r *http.Request, node string, code int, msg string, err error) {
+	s.recordError(node, remoteHost(r), code, msg, err)
+	http.Error(w, msg, code)
+}
+
+func (s *IngestionServer) recordError(node, remote string, code int, msg string, err error) {
+	if err != nil && err.Error() != msg {
+		msg += ": " + err.Error()
+	}
+	s.errors.add(IngestError{Time: s.clock.Now(), Node: node, Remote: remote, Status: code, Message: msg})
+}
@@ -0,0 +1,167 @@
+package ingest_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/ingest"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestSubsecondIngest verifies samples 500ms apart, stamped with ts_ms or
+// RFC 3339, stay distinct and give the live CPU rate over half a second,
+// while old agents' second-resolution ts still works. Posts with a sample
+// without a valid time are refused; samples past the horizon are dropped.
+func TestSubsecondIngest(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		pod := synctest.Pod("default", "fast", "node-a", nil)
+		if _, err := env.Client.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pod synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pods")
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+
+		t0 := env.Clock.Now().Add(-2 * time.Second).Truncate(time.Millisecond)
+		slice := "kubepods-pod" + strings.ReplaceAll(string(pod.UID), "-", "_") + ".slice"
+		req := ingest.IngestRequest{NodeName: "node-a", Metrics: []ingest.RawMetric{
+			{Type: "container", PodID: slice, Key: "mem_mb", Value: 64, Timestamp: t0.Unix()},
+			{Type: "container", PodID: slice, Key: "cpu_ms", Value: 1000, TimestampMs: t0.UnixMilli()},
+			{Type: "container", PodID: slice, Key: "cpu_ms", Value: 1250, Time: t0.Add(500 * time.Millisecond).Format(time.RFC3339Nano)},
+			{Type: "container", PodID: slice, Key: "mem_mb", Value: 1, Timestamp: t0.Add(-25 * time.Hour).Unix()},
+		}}
+		srv := ingest.NewIngestionServer(env.Ring, env.Resolver)
+		srv.SetClock(env.Clock)
+		srv.SetHorizon(24 * time.Hour)
+		post := func(req ingest.IngestRequest) (int, error) {
+			body, err := json.Marshal(req)
+			if err != nil {
+				return 0, err
+			}
+			rec := httptest.NewRecorder()
+			srv.HandleIngest(rec, httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(string(body))))
+			return rec.Code, nil
+		}
+		for _, bad := range []ingest.RawMetric{
+			{Type: "container", PodID: slice, Key: "cpu_ms", Value: 9999},
+			{Type: "container", PodID: slice, Key: "cpu_ms", Value: 9999, Timestamp: t0.Unix(), Time: "yesterday"},
+		} {
+			code, err := post(ingest.IngestRequest{NodeName: "node-a", Metrics: append(req.Metrics[:1:1], bad)})
+			if err != nil {
+				return err
+			}
+			if code != http.StatusBadRequest {
+				return fmt.Errorf("post with %+v: status %d, want 400", bad, code)
+			}
+		}
+		if n := env.Ring.Len(); n != 0 {
+			return fmt.Errorf("%d metrics buffered from refused posts", n)
+		}
+		if code, err := post(req); err != nil || code != http.StatusAccepted {
+			return fmt.Errorf("ingest status = %d (%v), want 202", code, err)
+		}
+
+		var times []time.Time
+		for _, m := range env.Ring.Recent(time.Minute) {
+			if m.Type == "cpu_ms" {
+				times = append(times, m.Time)
+			}
+		}
+		if len(times) != 2 || times[1].Sub(times[0]) != 500*time.Millisecond {
+			return fmt.Errorf("cpu_ms sample times = %v, want two 500ms apart", times)
+		}
+		if errs := srv.RecentErrors(); len(errs) != 3 || errs[0].Message != "Dropped 1 samples older than 24h0m0s" {
+			return fmt.Errorf("ingest errors = %+v, want 2 refused posts and the expired sample", errs)
+		}
+
+		var live api.LiveMetricsResponse
+		if err := env.GetJSON("/api/v1/metrics/live", &live); err != nil {
+			return err
+		}
+		if len(live.Pods) != 1 || len(live.Pods[0].Containers) != 1 {
+			return fmt.Errorf("live pods = %+v, want one pod with one container", live.Pods)
+		}
+		c := live.Pods[0].Containers[0]
+		if c.CPUCores == nil || *c.CPUCores != 0.5 {
+			return fmt.Errorf("cpu_cores = %v, want 0.5 (250ms over 500ms)", c.CPUCores)
+		}
+		if c.MemMB != 64 {
+			return fmt.Errorf("mem_mb = %v, want 64 from a seconds-only ts", c.MemMB)
+		}
+		return nil
+	})
+}
+
+// TestIngestNodeAddress verifies posts are refused unless they come from
+// an address of the node they report for
+func TestIngestNodeAddress(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		node := synctest.Node("node-a", "4", "8Gi")
+		node.Status.Addresses = []corev1.NodeAddress{
+			{Type: corev1.NodeHostName, Address: "node-a"},
+			{Type: corev1.NodeInternalIP, Address: "10.0.0.5"},
+			{Type: corev1.NodeExternalIP, Address: "203.0.113.5"},
+		}
+		if _, err := env.Client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "node addresses known", func() (bool, error) {
+			_, ok := env.Syncer.NodeAddresses("node-a")
+			return ok, nil
+		}); err != nil {
+			return err
+		}
+
+		srv := ingest.NewIngestionServer(env.Ring, env.Resolver)
+		srv.SetClock(env.Clock)
+		srv.SetNodeVerification(env.Syncer)
+		post := func(node, remoteAddr string) int {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/ingest", strings.NewReader(`{"node": "`+node+`", "metrics": []}`))
+			req.RemoteAddr = remoteAddr
+			srv.HandleIngest(rec, req)
+			return rec.Code
+		}
+		for _, tc := range []struct {
+			node, from string
+			code       int
+		}{
+			{"node-a", "10.0.0.5:40112", http.StatusAccepted},
+			{"node-a", "203.0.113.5:40112", http.StatusAccepted},
+			{"node-a", "[::ffff:10.0.0.5]:40112", http.StatusAccepted},
+			{"node-a", "10.0.0.6:40112", http.StatusForbidden},
+			{"node-b", "10.0.0.5:40112", http.StatusForbidden},
+		} {
+			if got := post(tc.node, tc.from); got != tc.code {
+				return fmt.Errorf("post for %s from %s: %d, want %d", tc.node, tc.from, got, tc.code)
+			}
+		}
+
+		// A node that changes address is followed
+		node.Status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.6"}}
+		if _, err := env.Client.CoreV1().Nodes().UpdateStatus(ctx, node, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "new address accepted", func() (bool, error) {
+			return post("node-a", "10.0.0.6:40112") == http.StatusAccepted, nil
+		}); err != nil {
+			return err
+		}
+		if got := post("node-a", "10.0.0.5:40112"); got != http.StatusForbidden {
+			return fmt.Errorf("post from old address: %d, want %d", got, http.StatusForbidden)
+		}
+		return nil
+	})
+}
@@ -1,34 +1,159 @@
 package ingest
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/sink"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
 )
 
 type IDResolver interface {
 	GetResourceID(uid, rType string) (int64, bool)
+	// GetResourceIDs resolves many UIDs of one type at once; unresolved entries are 0
+	GetResourceIDs(rType string, uids []string) []int64
+}
+
+// defaultMaxBodyBytes bounds a single agent post unless SetMaxBodyBytes
+// says otherwise
+const defaultMaxBodyBytes = 8 << 20
+
+// DiskGuard reports whether local storage is too full to accept data
+type DiskGuard interface {
+	Low() bool
+}
+
+// Sink receives a copy of every processed batch. Enqueue must not block.
+type Sink interface {
+	Enqueue(b sink.Batch)
+}
+
+// Backfiller takes metrics too old for the live buffer
+type Backfiller interface {
+	Backfill(ms []buffer.Metric)
+}
+
+// ProcessRecorder takes per-process samples
+type ProcessRecorder interface {
+	Add(samples []store.ProcessSample)
+}
+
+// Accountant counts ingested points and returns those within quota
+type Accountant interface {
+	Admit(node string, ms []buffer.Metric) []buffer.Metric
+}
+
+// Sampler thins series while ingest is over budget, returning the points
+// to keep
+type Sampler interface {
+	Sample(ms []buffer.Metric) []buffer.Metric
+}
+
+// Registry decides which agents' posts are accepted
+type Registry interface {
+	Admit(node, remoteAddr string) bool
+}
+
+// NodeAddresses looks up the IP addresses of a cluster node
+type NodeAddresses interface {
+	NodeAddresses(node string) ([]string, bool)
+}
+
+// Sources hands out the source ids stored with each point
+type Sources interface {
+	ID(agentVersion string) int64
+	Cluster() string
+	Instance() string
 }
 
 type IngestionServer struct {
 	buffer   *buffer.RingBuffer
 	resolver IDResolver
+	disk     DiskGuard
+	sink     Sink
+	usage    Accountant
+	sampler  Sampler
+	registry Registry
+	nodes    NodeAddresses
+	sources  Sources
+	maxBody  int64
+	clock    clock.Clock
+
+	backfill  Backfiller
+	lateAfter time.Duration
+	horizon   time.Duration // samples older than this are dropped; 0 keeps all
+
+	processes ProcessRecorder
+
+	// refused counts posts refused by node verification since the last
+	// log line, written at most once a minute (loggedAt, unix seconds)
+	refused  atomic.Int64
+	loggedAt atomic.Int64
+
+	// queue holds decoded posts awaiting processing; nil means inline
+	// processing
+	queue chan *IngestRequest
+
+	deltas baselines
+
+	// errors keeps the latest refused and failed posts
+	errors errorLog
 }
 
 func NewIngestionServer(buf *buffer.RingBuffer, res IDResolver) *IngestionServer {
 	return &IngestionServer{
 		buffer:   buf,
 		resolver: res,
+		maxBody:  defaultMaxBodyBytes,
+		clock:    clock.Real,
 	}
 }
 
 type IngestRequest struct {
+	// Version is the payload format; absent from agents that predate
+	// versioning. Older versions are upgraded before processing.
+	Version  int         `json:"version,omitempty"`
 	NodeName string      `json:"node"`
 	Metrics  []RawMetric `json:"metrics"`
+	// AgentVersion is stored with every point as part of its source;
+	// absent from agents that predate it
+	AgentVersion string `json:"agent_version,omitempty"`
+	// Seq numbers an agent's posts, from version 3; metrics may then carry
+	// deltas against the post numbered BaseSeq (see baselines). BaseSeq 0
+	// means every metric has its absolute value.
+	Seq     uint64 `json:"seq,omitempty"`
+	BaseSeq uint64 `json:"base_seq,omitempty"`
+	// Processes are the top processes per pod, from agents that collect
+	// them; ignored unless process metrics are enabled
+	Processes []RawProcess `json:"processes,omitempty"`
+}
+
+// RawProcess is one process inside a pod's cgroup
+type RawProcess struct {
+	PodID       string  `json:"pod_id,omitempty"`  // cgroup slice, as for container metrics
+	PodUID      string  `json:"pod_uid,omitempty"` // or the pod UID itself
+	ContainerID string  `json:"container_id,omitempty"`
+	PID         int64   `json:"pid"`
+	Name        string  `json:"name"`
+	CPUms       float64 `json:"cpu_ms"` // cumulative
+	MemMB       float64 `json:"mem_mb"`
+	Timestamp   int64   `json:"ts"`
+	TimestampMs int64   `json:"ts_ms,omitempty"`
+	Time        string  `json:"time,omitempty"`
 }
 
 type RawMetric struct {
@@ -37,73 +162,521 @@ type RawMetric struct {
 	PodUID      string  `json:"pod_uid,omitempty"` // For PVCs (pod using the volume)
 	Volume      string  `json:"volume,omitempty"`  // For PVCs (volume name, may contain pvc UID)
 	ContainerID string  `json:"container_id,omitempty"`
-	Key         string  `json:"key"` // "cpu_ms", "mem_mb", "total_mb", "used_mb", "free_mb"
+	Key         string  `json:"key"` // "cpu_ms", "mem_mb", "mem_rss_mb", ..., "total_mb", "used_mb", "free_mb"
 	Value       float64 `json:"value"`
-	Timestamp   int64   `json:"ts"` // unix epoch
+	// Delta replaces Value in sequenced posts: the change since the same
+	// series in the baseline post
+	Delta     *float64 `json:"d,omitempty"`
+	Timestamp int64    `json:"ts"` // unix epoch seconds
+	// Agents sampling faster than once a second stamp with one of these
+	// instead; ts_ms wins over time, and either over ts
+	TimestampMs int64  `json:"ts_ms,omitempty"` // unix epoch milliseconds
+	Time        string `json:"time,omitempty"`  // RFC 3339, fractional seconds allowed
+}
+
+// stamp is the comparable part of a RawMetric that sets its time
+type stamp struct {
+	sec, ms int64
+	rfc     string
+}
+
+func (r *RawMetric) stamp() stamp {
+	return stamp{r.Timestamp, r.TimestampMs, r.Time}
+}
+
+// errNoStamp is returned for samples carrying none of ts, ts_ms and time
+var errNoStamp = errors.New("no timestamp")
+
+// time returns when the sample was taken, at the finest resolution given.
+// A sample without a stamp, or with a time that does not parse, has none:
+// falling back to ts would store it at the epoch or a second off.
+func (st stamp) time() (time.Time, error) {
+	switch {
+	case st.ms != 0:
+		return time.UnixMilli(st.ms), nil
+	case st.rfc != "":
+		t, err := time.Parse(time.RFC3339Nano, st.rfc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q", st.rfc)
+		}
+		return t, nil
+	case st.sec != 0:
+		return time.Unix(st.sec, 0), nil
+	}
+	return time.Time{}, errNoStamp
+}
+
+// checkStamps returns why the first metric or process of req without a
+// valid time has none
+func checkStamps(req *IngestRequest) error {
+	// Agents stamp a whole batch with few distinct timestamps; check each
+	// once
+	var last stamp
+	for i, m := range req.Metrics {
+		if st := m.stamp(); i == 0 || st != last {
+			last = st
+			if _, err := st.time(); err != nil {
+				return fmt.Errorf("metric %d: %w", i, err)
+			}
+		}
+	}
+	for i, p := range req.Processes {
+		if _, err := (stamp{p.Timestamp, p.TimestampMs, p.Time}).time(); err != nil {
+			return fmt.Errorf("process %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// dropExpired removes the metrics and processes of req taken before
+// cutoff, returning how many it removed. Stamps must have been checked.
+func dropExpired(req *IngestRequest, cutoff time.Time) int {
+	n := len(req.Metrics) + len(req.Processes)
+	var last stamp
+	var at time.Time
+	metrics := req.Metrics[:0]
+	for i, m := range req.Metrics {
+		if st := m.stamp(); i == 0 || st != last {
+			last = st
+			at, _ = st.time()
+		}
+		if !at.Before(cutoff) {
+			metrics = append(metrics, m)
+		}
+	}
+	req.Metrics = metrics
+	processes := req.Processes[:0]
+	for _, p := range req.Processes {
+		if at, _ := (stamp{p.Timestamp, p.TimestampMs, p.Time}).time(); !at.Before(cutoff) {
+			processes = append(processes, p)
+		}
+	}
+	req.Processes = processes
+	return n - len(req.Metrics) - len(req.Processes)
 }
 
 var podSliceRegex = regexp.MustCompile(`pod([0-9a-fA-F_]+)(?:\.slice)?`)
 var pvcVolumeRegex = regexp.MustCompile(`^pvc-([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})$`)
 
+// HandleIngest serves POST /api/v1/ingest. The handler reads and decodes
+// the post, admits its agent and refuses with 400 a post with a metric or
+// process without a valid time; posts over the body limit are refused
+// with 413. Deltas are expanded here, in the order posts arrive.
+// Resolving the resources and buffering the metrics happen on a worker
+// after the 202, so a 202 means the post was queued, not stored: failures
+// past that point show up in the ingest error log, not in the response.
 func (s *IngestionServer) HandleIngest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req IngestRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	if s.disk != nil && s.disk.Low() {
+		s.refuse(w, r, "", http.StatusInsufficientStorage, "Consumer storage is full", nil)
 		return
 	}
 
-	for _, raw := range req.Metrics {
-		var resourceID int64
-		var uid string
-		var rType string = "pod" // default
-
-		// 1. Resolve UID and Type based on metric type
-		if raw.Key == "pvc_usage" || strings.Contains(raw.Key, "_mb") && raw.Volume != "" {
-			// PVC/Volume metrics
-			// First, check if the volume name indicates an actual PVC
-			if matches := pvcVolumeRegex.FindStringSubmatch(raw.Volume); len(matches) > 1 {
-				// This is an actual PVC - extract PVC UID from volume name
-				uid = matches[1]
-				rType = "pvc"
-			} else {
-				// Non-PVC volume (configmap, secret, emptydir, etc.)
-				// Link to the pod consuming it
-				if raw.PodUID != "" {
-					uid = raw.PodUID
-					rType = "pod"
-				}
-			}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.maxBody))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.refuse(w, r, "", http.StatusRequestEntityTooLarge, fmt.Sprintf("Post exceeds %d bytes", s.maxBody), nil)
+			return
+		}
+		s.refuse(w, r, "", http.StatusBadRequest, "Failed to read body", err)
+		return
+	}
+	w.Header().Set(VersionHeader, strconv.Itoa(CurrentVersion))
+	req := new(IngestRequest)
+	if err := json.Unmarshal(body, req); err != nil {
+		s.refuse(w, r, "", http.StatusBadRequest, "Invalid JSON", err)
+		return
+	}
+	if err := checkVersion(payloadVersion(req.Version)); err != nil {
+		s.refuse(w, r, req.NodeName, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if s.nodes != nil && !s.fromNode(req.NodeName, remoteHost(r)) {
+		s.refuse(w, r, req.NodeName, http.StatusForbidden, "Source address does not match node", nil)
+		return
+	}
+	if s.registry != nil && !s.registry.Admit(req.NodeName, remoteHost(r)) {
+		s.refuse(w, r, req.NodeName, http.StatusForbidden, "Agent not approved", nil)
+		return
+	}
+	if err := checkStamps(req); err != nil {
+		s.refuse(w, r, req.NodeName, http.StatusBadRequest, "Invalid timestamp", err)
+		return
+	}
+
+	commit := func() {}
+	if req.Seq != 0 {
+		c, err := s.deltas.expand(req, s.clock.Now())
+		if err != nil {
+			s.refuse(w, r, req.NodeName, http.StatusConflict, err.Error(), nil)
+			return
+		}
+		commit = c
+	}
+
+	if s.queue == nil {
+		s.process(req)
+		commit()
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// Admission control: reject rather than queue unboundedly
+	select {
+	case s.queue <- req:
+		commit()
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.Header().Set("Retry-After", "1")
+		s.refuse(w, r, req.NodeName, http.StatusServiceUnavailable, "Ingest queue full", nil)
+	}
+}
+
+// SetDiskGuard makes ingest reject posts with 507 while storage is low
+func (s *IngestionServer) SetDiskGuard(g DiskGuard) {
+	s.disk = g
+}
+
+// SetClock replaces the clock late metrics are judged against
+func (s *IngestionServer) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// SetBackfill sends metrics older than lateAfter to b instead of the ring
+// buffer, so a recovering agent's backlog neither crowds out live data nor
+// shows up in the live view
+func (s *IngestionServer) SetBackfill(b Backfiller, lateAfter time.Duration) {
+	s.backfill = b
+	s.lateAfter = lateAfter
+}
+
+// SetHorizon drops metrics and processes older than maxAge, e.g. past
+// retention, rather than storing what retention would delete or an
+// agent's badly skewed clock. Drops are recorded in the error log.
+func (s *IngestionServer) SetHorizon(maxAge time.Duration) {
+	s.horizon = maxAge
+}
+
+// SetAccountant counts every batch per namespace and node, dropping points
+// over quota before they reach the buffer. The sink still sees every point.
+func (s *IngestionServer) SetAccountant(a Accountant) {
+	s.usage = a
+}
+
+// SetSampler thins points within quota while ingest is over budget. The
+// sink still sees every point.
+func (s *IngestionServer) SetSampler(sm Sampler) {
+	s.sampler = sm
+}
+
+// SetRegistry makes ingest refuse posts, with 403, from agents the
+// registry has not approved
+func (s *IngestionServer) SetRegistry(r Registry) {
+	s.registry = r
+}
+
+// SetNodeVerification makes ingest refuse posts, with 403, that do not come
+// from an address of the node they report for. Agents must reach the
+// consumer without NAT or a proxy in between (the agent runs with
+// hostNetwork).
+func (s *IngestionServer) SetNodeVerification(n NodeAddresses) {
+	s.nodes = n
+}
+
+// fromNode reports whether addr is one of node's addresses
+func (s *IngestionServer) fromNode(node, addr string) bool {
+	ip := net.ParseIP(addr)
+	addrs, known := s.nodes.NodeAddresses(node)
+	for _, a := range addrs {
+		if ip != nil && ip.Equal(net.ParseIP(a)) {
+			return true
+		}
+	}
+	n := s.refused.Add(1)
+	now := s.clock.Now().Unix()
+	if last := s.loggedAt.Load(); now-last >= 60 && s.loggedAt.CompareAndSwap(last, now) {
+		s.refused.Add(-n)
+		if known {
+			log.Printf("Refused %d posts since last report, latest for node %q from %s (node addresses %v)", n, node, addr, addrs)
 		} else {
-			// Container metrics
-			if raw.PodID != "" {
-				matches := podSliceRegex.FindStringSubmatch(raw.PodID)
-				if len(matches) > 1 {
-					uid = strings.ReplaceAll(matches[1], "_", "-")
-					rType = "pod"
+			log.Printf("Refused %d posts since last report, latest for unknown node %q from %s", n, node, addr)
+		}
+	}
+	return false
+}
+
+// SetSources stamps every point with its source: the cluster, this
+// consumer and the reporting agent's version
+func (s *IngestionServer) SetSources(src Sources) {
+	s.sources = src
+}
+
+// SetProcessRecorder enables per-process samples; without it they are
+// discarded
+func (s *IngestionServer) SetProcessRecorder(r ProcessRecorder) {
+	s.processes = r
+}
+
+// SetMaxBodyBytes bounds the size of a single post
+func (s *IngestionServer) SetMaxBodyBytes(n int64) {
+	s.maxBody = n
+}
+
+// SetSink tees every processed batch to an external stream
+func (s *IngestionServer) SetSink(sk Sink) {
+	s.sink = sk
+}
+
+// StartWorkers switches the server to asynchronous processing: decoded
+// posts are queued (up to queueSize) and resolved by a fixed set of
+// workers. Must be called before the handler is serving traffic.
+func (s *IngestionServer) StartWorkers(ctx context.Context, workers, queueSize int) {
+	if workers < 1 {
+		workers = 1
+	}
+	s.queue = make(chan *IngestRequest, queueSize)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case req := <-s.queue:
+					s.process(req)
 				}
 			}
+		}()
+	}
+}
+
+// remoteHost is the address a post came from, without the port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// QueueDepth reports how many posts are waiting for a worker
+func (s *IngestionServer) QueueDepth() int {
+	return len(s.queue)
+}
+
+// process resolves the resources of a decoded post and buffers the
+// metrics. Samples past the horizon are dropped and recorded in the error
+// log.
+func (s *IngestionServer) process(req *IngestRequest) {
+	upgrade(req)
+	if s.horizon > 0 {
+		if dropped := dropExpired(req, s.clock.Now().Add(-s.horizon)); dropped > 0 {
+			s.recordError(req.NodeName, "", 0, fmt.Sprintf("Dropped %d samples older than %s", dropped, s.horizon), nil)
 		}
+	}
 
-		// 2. Resolve DB ID
-		if uid != "" {
-			if id, ok := s.resolver.GetResourceID(uid, rType); ok {
-				resourceID = id
-			}
+	n := len(req.Metrics)
+	sc := getScratch(n)
+	defer putScratch(sc)
+
+	// 1. Extract UID and type for every metric
+	for i, raw := range req.Metrics {
+		uid, rType := resolveUID(raw)
+		if rType == "pvc" {
+			sc.pvcUIDs[i] = uid
+		} else {
+			sc.podUIDs[i] = uid
+		}
+	}
+
+	// 2. Resolve DB IDs in one pass per type
+	podIDs := s.resolver.GetResourceIDs("pod", sc.podUIDs)
+	pvcIDs := s.resolver.GetResourceIDs("pvc", sc.pvcUIDs)
+
+	var source int64
+	if s.sources != nil {
+		source = s.sources.ID(req.AgentVersion)
+	}
+
+	// Agents stamp a whole batch with few distinct timestamps; avoid
+	// converting the same one over and over.
+	var lastStamp stamp
+	var lastTime time.Time
+	for i, raw := range req.Metrics {
+		resourceID, kind := podIDs[i], "pod"
+		if sc.pvcUIDs[i] != "" {
+			resourceID, kind = pvcIDs[i], "pvc"
+		}
+
+		if st := raw.stamp(); st != lastStamp || lastTime.IsZero() {
+			lastStamp = st
+			lastTime, _ = st.time() // checked by HandleIngest
 		}
 
-		m := buffer.Metric{
-			Time:       time.Unix(raw.Timestamp, 0),
+		sc.metrics[i] = buffer.Metric{
+			Time:       lastTime,
 			ResourceID: resourceID,
+			Kind:       kind,
 			Type:       raw.Key,
 			Value:      raw.Value,
+			SourceID:   source,
+		}
+	}
+	admitted := sc.metrics
+	if s.usage != nil {
+		admitted = s.usage.Admit(req.NodeName, admitted)
+	}
+	if s.sampler != nil {
+		admitted = s.sampler.Sample(admitted)
+	}
+	if s.backfill != nil {
+		admitted = s.routeLate(admitted)
+	}
+	s.buffer.AddBatch(admitted)
+
+	if s.sink != nil {
+		b := sinkBatch(*req, sc)
+		if s.sources != nil {
+			b.Cluster, b.Instance = s.sources.Cluster(), s.sources.Instance()
+		}
+		s.sink.Enqueue(b)
+	}
+	if s.processes != nil && len(req.Processes) > 0 {
+		s.recordProcesses(req.Processes, source)
+	}
+}
+
+// recordProcesses resolves the pods of process samples and hands them to
+// the recorder. Samples of unknown pods are dropped.
+func (s *IngestionServer) recordProcesses(raw []RawProcess, source int64) {
+	uids := make([]string, len(raw))
+	for i, p := range raw {
+		uids[i] = p.PodUID
+		if uids[i] == "" {
+			uids[i], _ = resolveUID(RawMetric{PodID: p.PodID})
+		}
+	}
+	ids := s.resolver.GetResourceIDs("pod", uids)
+
+	samples := make([]store.ProcessSample, 0, len(raw))
+	for i, p := range raw {
+		if ids[i] == 0 {
+			continue
+		}
+		at, _ := stamp{p.Timestamp, p.TimestampMs, p.Time}.time() // checked by HandleIngest
+		samples = append(samples, store.ProcessSample{
+			Time:        at,
+			PodID:       ids[i],
+			ContainerID: p.ContainerID,
+			PID:         p.PID,
+			Name:        p.Name,
+			CPUms:       p.CPUms,
+			MemMB:       p.MemMB,
+			SourceID:    source,
+		})
+	}
+	s.processes.Add(samples)
+}
+
+// routeLate hands metrics older than lateAfter to the backfiller and
+// returns the rest. ms is not modified.
+func (s *IngestionServer) routeLate(ms []buffer.Metric) []buffer.Metric {
+	cutoff := s.clock.Now().Add(-s.lateAfter)
+	var live, late []buffer.Metric // allocated on the first late metric
+	for i, m := range ms {
+		if !m.Time.Before(cutoff) {
+			if late != nil {
+				live = append(live, m)
+			}
+			continue
+		}
+		if late == nil {
+			live = append(make([]buffer.Metric, 0, len(ms)), ms[:i]...)
 		}
-		s.buffer.Add(m)
+		late = append(late, m)
 	}
+	if late == nil {
+		return ms
+	}
+	s.backfill.Backfill(late)
+	return live
+}
 
-	w.WriteHeader(http.StatusAccepted)
+// sinkBatch copies a processed post out of the pooled scratch space
+func sinkBatch(req IngestRequest, sc *scratch) sink.Batch {
+	b := sink.Batch{Node: req.NodeName, AgentVersion: req.AgentVersion, Metrics: make([]sink.Metric, len(sc.metrics))}
+	for i, m := range sc.metrics {
+		uid, kind := sc.podUIDs[i], "pod"
+		if sc.pvcUIDs[i] != "" {
+			uid, kind = sc.pvcUIDs[i], "pvc"
+		}
+		b.Metrics[i] = sink.Metric{
+			Time:       m.Time,
+			ResourceID: m.ResourceID,
+			UID:        uid,
+			Kind:       kind,
+			Key:        m.Type,
+			Value:      m.Value,
+		}
+	}
+	return b
+}
+
+// scratch holds the per-batch working slices; pooled to keep the ingest
+// path allocation-free in steady state.
+type scratch struct {
+	podUIDs []string
+	pvcUIDs []string
+	metrics []buffer.Metric
+}
+
+var scratchPool = sync.Pool{New: func() any { return &scratch{} }}
+
+func getScratch(n int) *scratch {
+	sc := scratchPool.Get().(*scratch)
+	if cap(sc.metrics) < n {
+		sc.podUIDs = make([]string, n)
+		sc.pvcUIDs = make([]string, n)
+		sc.metrics = make([]buffer.Metric, n)
+	}
+	sc.podUIDs = sc.podUIDs[:n]
+	sc.pvcUIDs = sc.pvcUIDs[:n]
+	sc.metrics = sc.metrics[:n]
+	return sc
+}
+
+func putScratch(sc *scratch) {
+	clear(sc.podUIDs)
+	clear(sc.pvcUIDs)
+	scratchPool.Put(sc)
+}
+
+// resolveUID extracts the Kubernetes UID a metric refers to and its resource type
+func resolveUID(raw RawMetric) (uid string, rType string) {
+	rType = "pod" // default
+
+	if raw.Key == "pvc_usage" || strings.Contains(raw.Key, "_mb") && raw.Volume != "" {
+		// PVC/Volume metrics
+		// First, check if the volume name indicates an actual PVC
+		if matches := pvcVolumeRegex.FindStringSubmatch(raw.Volume); len(matches) > 1 {
+			// This is an actual PVC - extract PVC UID from volume name
+			return matches[1], "pvc"
+		}
+		// Non-PVC volume (configmap, secret, emptydir, etc.)
+		// Link to the pod consuming it
+		return raw.PodUID, rType
+	}
+
+	// Container metrics
+	if raw.PodID != "" {
+		if matches := podSliceRegex.FindStringSubmatch(raw.PodID); len(matches) > 1 {
+			return strings.ReplaceAll(matches[1], "_", "-"), rType
+		}
+	}
+	return "", rType
 }
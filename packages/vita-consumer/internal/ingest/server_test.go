@@ -0,0 +1,115 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+)
+
+// staticResolver resolves every UID it was given to a fixed ID
+type staticResolver map[string]int64
+
+func (r staticResolver) GetResourceID(uid, rType string) (int64, bool) {
+	id, ok := r[uid]
+	return id, ok
+}
+
+func (r staticResolver) GetResourceIDs(rType string, uids []string) []int64 {
+	out := make([]int64, len(uids))
+	for i, uid := range uids {
+		out[i] = r[uid]
+	}
+	return out
+}
+
+// agentPost is an agent post of three container metrics for each of pods
+// pods, and a resolver knowing them
+func agentPost(tb testing.TB, pods int) ([]byte, int, staticResolver) {
+	tb.Helper()
+	resolver := make(staticResolver, pods)
+	req := IngestRequest{Version: CurrentVersion, NodeName: "node-1"}
+	now := time.Now().Unix()
+	for i := 0; i < pods; i++ {
+		uid := fmt.Sprintf("0000%04d-0000-0000-0000-000000000000", i)
+		resolver[uid] = int64(i + 1)
+		slice := "kubepods-burstable-pod" + uid[:8] + "_0000_0000_0000_000000000000.slice"
+		for _, key := range []string{"cpu_ms", "mem_mb", "mem_limit_mb"} {
+			req.Metrics = append(req.Metrics, RawMetric{
+				Type: "container", PodID: slice, Key: key, Value: float64(i), Timestamp: now,
+			})
+		}
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return body, len(req.Metrics), resolver
+}
+
+// Posts over the size limit are refused with 413 before any of them is
+// buffered
+func TestOversizedPostRefused(t *testing.T) {
+	body, perPost, resolver := agentPost(t, 10)
+	ring := buffer.NewRingBuffer(perPost)
+	server := NewIngestionServer(ring, resolver)
+	server.SetMaxBodyBytes(int64(len(body)) - 1)
+
+	rec := httptest.NewRecorder()
+	server.HandleIngest(rec, httptest.NewRequest(http.MethodPost, "/api/v1/ingest", bytes.NewReader(body)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413", rec.Code)
+	}
+	if ring.Len() != 0 {
+		t.Fatalf("%d metrics buffered from a refused post", ring.Len())
+	}
+
+	server.SetMaxBodyBytes(int64(len(body)))
+	rec = httptest.NewRecorder()
+	server.HandleIngest(rec, httptest.NewRequest(http.MethodPost, "/api/v1/ingest", bytes.NewReader(body)))
+	if rec.Code != http.StatusAccepted || ring.Len() != perPost {
+		t.Fatalf("post at the limit: status %d, %d metrics buffered, want 202 and %d", rec.Code, ring.Len(), perPost)
+	}
+}
+
+// BenchmarkIngest drives the in-process metric path, decode -> resolve ->
+// buffer, with posts of 100 pods from parallel agents, flushing the buffer
+// whenever it fills. Reports cost per metric as well as per post.
+func BenchmarkIngest(b *testing.B) {
+	body, perPost, resolver := agentPost(b, 100)
+	ring := buffer.NewRingBuffer(perPost * 64)
+	server := NewIngestionServer(ring, resolver)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/ingest", bytes.NewReader(body))
+			rec := httptest.NewRecorder()
+			server.HandleIngest(rec, r)
+			if rec.Code != http.StatusAccepted {
+				b.Errorf("ingest returned %d", rec.Code)
+				return
+			}
+			if ring.Len() > ring.Cap()-perPost {
+				ring.Flush()
+			}
+		}
+	})
+	b.StopTimer()
+	runtime.ReadMemStats(&after)
+
+	metrics := float64(b.N * perPost)
+	b.ReportMetric(metrics/b.Elapsed().Seconds(), "metrics/s")
+	b.ReportMetric(float64(after.Mallocs-before.Mallocs)/metrics, "allocs/metric")
+	b.ReportMetric(float64(after.TotalAlloc-before.TotalAlloc)/metrics, "B/metric")
+}
@@ -0,0 +1,125 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Payload versions this consumer accepts. Agents that predate versioning
+// send none and are treated as version 1.
+const (
+	MinVersion     = 1
+	CurrentVersion = 3
+)
+
+// VersionHeader carries the payload version on every ingest response, so
+// an agent learns what the consumer speaks without a separate request
+const VersionHeader = "X-Vita-Ingest-Version"
+
+// Compatibility describes what changed in a payload version
+type Compatibility struct {
+	Version int    `json:"version"`
+	Changes string `json:"changes"`
+}
+
+// compatibility is the agent compatibility matrix, oldest first. Every
+// version below CurrentVersion whose payloads read differently in the next
+// one has an upgrade in upgrades.
+var compatibility = []Compatibility{
+	{1, "Unversioned payloads: ts in seconds; mem_limit_mb of 0 means no limit"},
+	{2, "version field; ts_ms and time stamps; processes; mem_limit_mb omitted when there is no limit"},
+	{3, "seq and base_seq; d, the change since post base_seq, instead of value (409 when that post is not the node's last accepted one)"},
+}
+
+// upgrades rewrite a request of the key version into the next one
+var upgrades = map[int]func(*IngestRequest){
+	1: upgradeV1,
+}
+
+// upgradeV1 drops the zero memory limits version 1 agents report for
+// unlimited containers; stored as-is they read as a limit of 0 MB
+func upgradeV1(req *IngestRequest) {
+	kept := req.Metrics[:0]
+	for _, m := range req.Metrics {
+		if m.Key == "mem_limit_mb" && m.Value == 0 {
+			continue
+		}
+		kept = append(kept, m)
+	}
+	req.Metrics = kept
+}
+
+// payloadVersion returns the version a request declares
+func payloadVersion(v int) int {
+	if v == 0 {
+		return MinVersion
+	}
+	return v
+}
+
+func checkVersion(v int) error {
+	if v < MinVersion || v > CurrentVersion {
+		return fmt.Errorf("unsupported payload version %d; this consumer accepts %d to %d", v, MinVersion, CurrentVersion)
+	}
+	return nil
+}
+
+// upgrade brings a request of an older version up to CurrentVersion
+func upgrade(req *IngestRequest) {
+	for v := payloadVersion(req.Version); v < CurrentVersion; v++ {
+		if up := upgrades[v]; up != nil {
+			up(req)
+		}
+	}
+	req.Version = CurrentVersion
+}
+
+// metricKeys are the keys agents report that the consumer reads. Others are
+// stored but nothing charts them.
+var metricKeys = []string{
+	"cpu_ms", "cpu_throttled_ms", "cpu_periods", "cpu_throttled_periods",
+	"mem_mb", "mem_limit_mb", "mem_working_set_mb", "mem_rss_mb", "mem_cache_mb", "mem_swap_mb",
+	"total_mb", "used_mb", "free_mb",
+}
+
+// Capabilities is what an agent can discover about the ingest endpoint
+// before posting
+type Capabilities struct {
+	MinVersion     int             `json:"min_version"`
+	CurrentVersion int             `json:"current_version"`
+	Formats        []string        `json:"formats"`
+	Timestamps     []string        `json:"timestamps"`
+	MetricKeys     []string        `json:"metric_keys"`
+	Processes      bool            `json:"processes"` // process samples are recorded
+	Deltas         bool            `json:"deltas"`    // values may be sent as deltas (version 3)
+	MaxBodyBytes   int64           `json:"max_body_bytes"`
+	Compatibility  []Compatibility `json:"compatibility"`
+}
+
+// Capabilities reports the payloads this server accepts
+func (s *IngestionServer) Capabilities() Capabilities {
+	return Capabilities{
+		MinVersion:     MinVersion,
+		CurrentVersion: CurrentVersion,
+		Formats:        []string{"application/json"},
+		Timestamps:     []string{"ts", "ts_ms", "time"},
+		MetricKeys:     metricKeys,
+		Processes:      s.processes != nil,
+		Deltas:         true,
+		MaxBodyBytes:   s.maxBody,
+		Compatibility:  compatibility,
+	}
+}
+
+// HandleCapabilities serves GET /api/v1/ingest/capabilities
+func (s *IngestionServer) HandleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(VersionHeader, strconv.Itoa(CurrentVersion))
+	json.NewEncoder(w).Encode(s.Capabilities())
+}
@@ -0,0 +1,99 @@
+package ingest_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/ingest"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestIngestVersions verifies capabilities discovery, that unversioned
+// payloads are upgraded and that versions from the future are refused
+func TestIngestVersions(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		pod := synctest.Pod("default", "legacy", "node-a", nil)
+		if _, err := env.Client.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pod synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pods")
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+
+		srv := ingest.NewIngestionServer(env.Ring, env.Resolver)
+		srv.SetClock(env.Clock)
+		rec := httptest.NewRecorder()
+		srv.HandleCapabilities(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ingest/capabilities", nil))
+		var caps ingest.Capabilities
+		if err := json.NewDecoder(rec.Body).Decode(&caps); err != nil {
+			return err
+		}
+		if caps.MinVersion != 1 || caps.CurrentVersion != ingest.CurrentVersion || len(caps.Compatibility) != ingest.CurrentVersion ||
+			!slices.Contains(caps.MetricKeys, "cpu_ms") || caps.Processes {
+			return fmt.Errorf("capabilities: %+v", caps)
+		}
+
+		slice := "kubepods-pod" + strings.ReplaceAll(string(pod.UID), "-", "_") + ".slice"
+		post := func(version int) (*httptest.ResponseRecorder, error) {
+			req := ingest.IngestRequest{Version: version, NodeName: "node-a", Metrics: []ingest.RawMetric{
+				{Type: "container", PodID: slice, Key: "mem_mb", Value: 64, Timestamp: env.Clock.Now().Unix()},
+				{Type: "container", PodID: slice, Key: "mem_limit_mb", Value: 0, Timestamp: env.Clock.Now().Unix()},
+			}}
+			body, err := json.Marshal(req)
+			if err != nil {
+				return nil, err
+			}
+			rec := httptest.NewRecorder()
+			srv.HandleIngest(rec, httptest.NewRequest(http.MethodPost, "/api/v1/ingest", strings.NewReader(string(body))))
+			return rec, nil
+		}
+		count := func(metricType string) int {
+			n := 0
+			for _, m := range env.Ring.Recent(time.Minute) {
+				if m.Type == metricType {
+					n++
+				}
+			}
+			return n
+		}
+
+		// An agent from before versioning: its 0 MB limit means unlimited
+		rec, err := post(0)
+		if err != nil {
+			return err
+		}
+		if rec.Code != http.StatusAccepted || rec.Header().Get(ingest.VersionHeader) != strconv.Itoa(ingest.CurrentVersion) {
+			return fmt.Errorf("unversioned post: %d, version header %q", rec.Code, rec.Header().Get(ingest.VersionHeader))
+		}
+		if count("mem_mb") != 1 || count("mem_limit_mb") != 0 {
+			return fmt.Errorf("unversioned post stored mem_mb %d, mem_limit_mb %d times", count("mem_mb"), count("mem_limit_mb"))
+		}
+
+		if rec, err = post(ingest.CurrentVersion); err != nil {
+			return err
+		}
+		if rec.Code != http.StatusAccepted || count("mem_mb") != 2 || count("mem_limit_mb") != 1 {
+			return fmt.Errorf("current post: %d, mem_limit_mb stored %d times", rec.Code, count("mem_limit_mb"))
+		}
+
+		if rec, err = post(ingest.CurrentVersion + 1); err != nil {
+			return err
+		}
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "unsupported payload version") || count("mem_mb") != 2 {
+			return fmt.Errorf("future post: %d %q", rec.Code, rec.Body.String())
+		}
+		return nil
+	})
+}
@@ -0,0 +1,317 @@
+// Package jobs runs the consumer's periodic background work (retention,
+// health scores, reports, rule and SLO evaluation, maintenance) from one
+// scheduler: named jobs on jittered intervals, never two runs of a job at
+// once, with per-job counters and admin endpoints to list, trigger, pause
+// and resume them. Loops that must never be paused or skipped stay
+// outside it: the ingest pipeline, usage counts and process samples flush
+// once more on shutdown (and the pipeline's ticker also paces its sinks'
+// retries), the disk guard must keep refusing ingest while space is low,
+// and the CRD controller only polls until definitions are installed,
+// inside its watch goroutines.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+)
+
+// resolution is how often due jobs are looked for; intervals are at
+// least this long
+const resolution = time.Second
+
+// jobStats holds <job>.runs, .failures, .skipped and .last_duration_ms
+var jobStats = expvar.NewMap("jobs")
+
+var (
+	ErrUnknownJob = errors.New("unknown job")
+	ErrRunning    = errors.New("job is already running")
+)
+
+// Job is a named piece of periodic work. Run gets the scheduler's context
+// and clock time.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// Jitter varies each wait by up to this fraction of Interval, below
+	// 1, so jobs sharing an interval do not all run at once
+	Jitter float64
+	// Immediate runs the job when the scheduler starts rather than one
+	// interval later
+	Immediate bool
+	Run       func(ctx context.Context, now time.Time) error
+}
+
+// Status is a job's schedule and latest outcome
+type Status struct {
+	Name           string    `json:"name"`
+	IntervalSec    float64   `json:"interval_sec"`
+	Paused         bool      `json:"paused"`
+	Running        bool      `json:"running"`
+	Next           time.Time `json:"next"`
+	LastStart      time.Time `json:"last_start"`
+	LastDurationMs int64     `json:"last_duration_ms"`
+	LastError      string    `json:"last_error,omitempty"`
+	Runs           int64     `json:"runs"`
+	Failures       int64     `json:"failures"`
+	Skipped        int64     `json:"skipped"` // came due while the previous run was still going
+}
+
+// entry is a job and its state, guarded by Scheduler.mu
+type entry struct {
+	job    Job
+	status Status
+}
+
+// Scheduler runs jobs until its context is done
+type Scheduler struct {
+	clock clock.Clock
+
+	mu      sync.Mutex
+	ctx     context.Context // of Run, for triggered runs
+	entries []*entry
+	byName  map[string]*entry
+	runs    sync.WaitGroup // in progress
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		clock:  clock.Real,
+		ctx:    context.Background(),
+		byName: make(map[string]*entry),
+	}
+}
+
+// SetClock replaces the clock jobs are scheduled by. Must be called before
+// Run.
+func (s *Scheduler) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// Add registers a job. Must be called before Run; names must be unique,
+// intervals at least a second and jitter in [0, 1).
+func (s *Scheduler) Add(job Job) {
+	if job.Interval < resolution {
+		panic("jobs: interval of " + job.Name + " is below 1s")
+	}
+	if job.Jitter < 0 || job.Jitter >= 1 {
+		panic("jobs: jitter of " + job.Name + " is outside [0, 1)")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byName[job.Name]; ok {
+		panic("jobs: duplicate job " + job.Name)
+	}
+	e := &entry{job: job, status: Status{Name: job.Name, IntervalSec: job.Interval.Seconds()}}
+	s.entries = append(s.entries, e)
+	s.byName[job.Name] = e
+}
+
+// Run starts due jobs until ctx is done, then waits for runs in progress,
+// which get ctx, so stores can be closed once it returns
+func (s *Scheduler) Run(ctx context.Context) {
+	// Before the immediate runs, so a clock moved once they are seen is
+	// not missed
+	ticker := s.clock.NewTicker(resolution)
+	defer ticker.Stop()
+
+	now := s.clock.Now()
+	s.mu.Lock()
+	s.ctx = ctx
+	for _, e := range s.entries {
+		if e.job.Immediate {
+			e.status.Next = now
+		} else {
+			e.status.Next = next(e.job, now, now)
+		}
+	}
+	s.mu.Unlock()
+	s.startDue(now)
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.runs.Wait()
+			return
+		case <-ticker.C():
+			// The clock's time rather than the tick's: a fake clock moved
+			// past several ticks delivers the first
+			s.startDue(s.clock.Now())
+		}
+	}
+}
+
+// next returns when a job last due at due is next due after now. It
+// steps from due rather than now, so the delay before a run is noticed
+// does not push back every later run; intervals missed while the job was
+// busy or paused are skipped, not made up.
+func next(job Job, due, now time.Time) time.Time {
+	if behind := now.Sub(due); behind > job.Interval {
+		due = due.Add(behind.Truncate(job.Interval) - job.Interval)
+	}
+	for {
+		d := job.Interval
+		if job.Jitter > 0 {
+			d += time.Duration((rand.Float64()*2 - 1) * job.Jitter * float64(d))
+		}
+		if due = due.Add(d); due.After(now) {
+			return due
+		}
+	}
+}
+
+func (s *Scheduler) startDue(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if e.status.Paused || now.Before(e.status.Next) {
+			continue
+		}
+		e.status.Next = next(e.job, e.status.Next, now)
+		if e.status.Running {
+			e.status.Skipped++
+			jobStats.Add(e.job.Name+".skipped", 1)
+			continue
+		}
+		s.startLocked(e, now)
+	}
+}
+
+func (s *Scheduler) startLocked(e *entry, now time.Time) {
+	e.status.Running = true
+	e.status.LastStart = now
+	s.runs.Add(1)
+	go s.run(s.ctx, e, now)
+}
+
+func (s *Scheduler) run(ctx context.Context, e *entry, now time.Time) {
+	defer s.runs.Done()
+	start := time.Now()
+	err := e.job.Run(ctx, now)
+	took := time.Since(start)
+
+	s.mu.Lock()
+	e.status.Running = false
+	e.status.Runs++
+	e.status.LastDurationMs = took.Milliseconds()
+	e.status.LastError = ""
+	if err != nil {
+		e.status.Failures++
+		e.status.LastError = err.Error()
+	}
+	s.mu.Unlock()
+
+	jobStats.Add(e.job.Name+".runs", 1)
+	last := new(expvar.Int)
+	last.Set(took.Milliseconds())
+	jobStats.Set(e.job.Name+".last_duration_ms", last)
+	if err != nil {
+		jobStats.Add(e.job.Name+".failures", 1)
+		log.Printf("Job %s failed: %v", e.job.Name, err)
+	}
+}
+
+// Trigger starts a run of a job now, paused or not, without moving its
+// schedule
+func (s *Scheduler) Trigger(name string) (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.byName[name]
+	if !ok {
+		return Status{}, ErrUnknownJob
+	}
+	if e.status.Running {
+		return e.status, ErrRunning
+	}
+	s.startLocked(e, s.clock.Now())
+	return e.status, nil
+}
+
+// SetPaused stops or resumes scheduled runs of a job. A job resumed past
+// its next run starts on the following tick.
+func (s *Scheduler) SetPaused(name string, paused bool) (Status, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.byName[name]
+	if !ok {
+		return Status{}, ErrUnknownJob
+	}
+	e.status.Paused = paused
+	return e.status, nil
+}
+
+// Statuses returns every job's status in the order they were added
+func (s *Scheduler) Statuses() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Status, 0, len(s.entries))
+	for _, e := range s.entries {
+		out = append(out, e.status)
+	}
+	return out
+}
+
+func (s *Scheduler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /internal/jobs", s.handleList)
+	mux.HandleFunc("POST /internal/jobs/{name}/run", s.handleRun)
+	mux.HandleFunc("POST /internal/jobs/{name}/pause", s.handlePause(true))
+	mux.HandleFunc("POST /internal/jobs/{name}/resume", s.handlePause(false))
+}
+
+func (s *Scheduler) handleList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Statuses())
+}
+
+// handleRun starts a job and answers 202 without waiting for it
+func (s *Scheduler) handleRun(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	status, err := s.Trigger(name)
+	if !writeStatusError(w, err) {
+		return
+	}
+	log.Printf("Job %s triggered", name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(status)
+}
+
+func (s *Scheduler) handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		status, err := s.SetPaused(name, paused)
+		if !writeStatusError(w, err) {
+			return
+		}
+		if paused {
+			log.Printf("Job %s paused", name)
+		} else {
+			log.Printf("Job %s resumed", name)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+// writeStatusError answers err, returning whether there was none
+func writeStatusError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrUnknownJob):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
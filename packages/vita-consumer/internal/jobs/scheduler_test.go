@@ -0,0 +1,186 @@
+package jobs_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/jobs"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+)
+
+// Jobs run on their interval, a run still going when the next
+// comes due is skipped rather than doubled, failures are counted, and the
+// admin endpoints list, trigger, pause and resume jobs
+func TestJobs(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		var fastRuns atomic.Int64
+		release := make(chan struct{})
+		sched := jobs.NewScheduler()
+		sched.SetClock(env.Clock)
+		sched.Add(jobs.Job{Name: "fast", Interval: 10 * time.Second, Immediate: true, Run: func(context.Context, time.Time) error {
+			fastRuns.Add(1)
+			return nil
+		}})
+		sched.Add(jobs.Job{Name: "slow", Interval: 10 * time.Second, Immediate: true, Run: func(ctx context.Context, _ time.Time) error {
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+			return nil
+		}})
+		sched.Add(jobs.Job{Name: "failing", Interval: time.Minute, Immediate: true, Run: func(context.Context, time.Time) error {
+			return errors.New("store unavailable")
+		}})
+
+		mux := http.NewServeMux()
+		sched.RegisterRoutes(mux)
+		call := func(method, path string) (int, []jobs.Status) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+			var list []jobs.Status
+			if method == http.MethodGet {
+				json.Unmarshal(rec.Body.Bytes(), &list)
+			}
+			return rec.Code, list
+		}
+		status := func(name string) jobs.Status {
+			for _, st := range sched.Statuses() {
+				if st.Name == name {
+					return st
+				}
+			}
+			return jobs.Status{}
+		}
+
+		runCtx, cancel := context.WithCancel(ctx)
+		stopped := make(chan struct{})
+		go func() {
+			sched.Run(runCtx)
+			close(stopped)
+		}()
+		defer func() {
+			cancel()
+			<-stopped
+		}()
+		if err := env.Eventually(synctest.Timeout, "immediate runs", func() (bool, error) {
+			failing := status("failing")
+			return status("fast").Runs == 1 && status("slow").Running && failing.Failures == 1 && failing.LastError == "store unavailable", nil
+		}); err != nil {
+			return err
+		}
+
+		env.Clock.Advance(10 * time.Second)
+		if err := env.Eventually(synctest.Timeout, "second interval", func() (bool, error) {
+			return status("fast").Runs == 2 && status("slow").Skipped == 1, nil
+		}); err != nil {
+			return err
+		}
+
+		if code, _ := call(http.MethodPost, "/internal/jobs/fast/pause"); code != http.StatusOK {
+			return fmt.Errorf("pause answered %d", code)
+		}
+		env.Clock.Advance(10 * time.Second)
+		if err := env.Eventually(synctest.Timeout, "slow skipped again", func() (bool, error) {
+			return status("slow").Skipped == 2, nil
+		}); err != nil {
+			return err
+		}
+		if n := fastRuns.Load(); n != 2 {
+			return fmt.Errorf("paused job ran: %d runs", n)
+		}
+
+		// Triggering runs a paused job, but never a second run of a busy one
+		for path, want := range map[string]int{
+			"/internal/jobs/fast/run": http.StatusAccepted,
+			"/internal/jobs/slow/run": http.StatusConflict,
+			"/internal/jobs/none/run": http.StatusNotFound,
+		} {
+			if code, _ := call(http.MethodPost, path); code != want {
+				return fmt.Errorf("POST %s answered %d, want %d", path, code, want)
+			}
+		}
+		if err := env.Eventually(synctest.Timeout, "triggered run", func() (bool, error) {
+			return fastRuns.Load() == 3, nil
+		}); err != nil {
+			return err
+		}
+
+		close(release)
+		if err := env.Eventually(synctest.Timeout, "slow released", func() (bool, error) {
+			st := status("slow")
+			return st.Runs == 1 && !st.Running, nil
+		}); err != nil {
+			return err
+		}
+		if code, _ := call(http.MethodPost, "/internal/jobs/fast/resume"); code != http.StatusOK {
+			return fmt.Errorf("resume answered %d", code)
+		}
+		env.Clock.Advance(10 * time.Second)
+		if err := env.Eventually(synctest.Timeout, "resumed", func() (bool, error) {
+			return fastRuns.Load() == 4 && status("slow").Runs == 2, nil
+		}); err != nil {
+			return err
+		}
+		code, list := call(http.MethodGet, "/internal/jobs")
+		if code != http.StatusOK || len(list) != 3 || list[0].Name != "fast" || list[0].Paused || list[2].Failures != 1 {
+			return fmt.Errorf("job list answered %d: %+v", code, list)
+		}
+		return nil
+	})
+}
+
+// A run noticed late does not push back later ones, and intervals missed
+// while the clock jumped are skipped rather than made up
+func TestJobsKeepPhase(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		var runs atomic.Int64
+		sched := jobs.NewScheduler()
+		sched.SetClock(env.Clock)
+		sched.Add(jobs.Job{Name: "tick", Interval: 10 * time.Second, Run: func(context.Context, time.Time) error {
+			runs.Add(1)
+			return nil
+		}})
+		runCtx, cancel := context.WithCancel(ctx)
+		stopped := make(chan struct{})
+		go func() {
+			sched.Run(runCtx)
+			close(stopped)
+		}()
+		defer func() {
+			cancel()
+			<-stopped
+		}()
+		start := env.Clock.Now()
+		if err := env.Eventually(synctest.Timeout, "scheduled", func() (bool, error) {
+			return sched.Statuses()[0].Next.Equal(start.Add(10 * time.Second)), nil
+		}); err != nil {
+			return err
+		}
+
+		for _, step := range []struct {
+			advance time.Duration
+			runs    int64
+			next    time.Duration // from start
+		}{
+			{13 * time.Second, 1, 20 * time.Second},
+			{7 * time.Second, 2, 30 * time.Second},
+			{45 * time.Second, 3, 70 * time.Second},
+		} {
+			env.Clock.Advance(step.advance)
+			if err := env.Eventually(synctest.Timeout, "run", func() (bool, error) {
+				st := sched.Statuses()[0]
+				return runs.Load() == step.runs && !st.Running && st.Next.Equal(start.Add(step.next)), nil
+			}); err != nil {
+				return fmt.Errorf("after %v more: %w (%+v)", step.advance, err, sched.Statuses()[0])
+			}
+		}
+		return nil
+	})
+}
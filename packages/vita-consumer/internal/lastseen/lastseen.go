@@ -0,0 +1,133 @@
+// Package lastseen records when each pod and PVC last reported a metric, so
+// the API can tell resources that stopped reporting from quiet ones.
+package lastseen
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// Kind of resource a metric type is keyed by
+type Kind int
+
+const (
+	Pod Kind = iota
+	PVC
+)
+
+// KindOf reports which resource a raw metric type belongs to. Derived
+// series (recording rules, rollups) are not tracked.
+func KindOf(metricType string) (Kind, bool) {
+	switch metricType {
+	case "cpu_ms", "cpu_throttled_ms", "cpu_periods", "cpu_throttled_periods",
+		"mem_mb", "mem_limit_mb", "mem_working_set_mb", "mem_rss_mb", "mem_cache_mb", "mem_swap_mb":
+		return Pod, true
+	case "total_mb", "used_mb", "free_mb":
+		return PVC, true
+	}
+	return 0, false
+}
+
+// trackedTypes are the metric types KindOf accepts
+var trackedTypes = []string{"cpu_ms", "cpu_throttled_ms", "cpu_periods", "cpu_throttled_periods", "mem_mb", "mem_limit_mb", "mem_working_set_mb", "mem_rss_mb", "mem_cache_mb", "mem_swap_mb", "total_mb", "used_mb", "free_mb"}
+
+// Tracker keeps the latest sample time per resource. It implements
+// buffer.Observer.
+type Tracker struct {
+	clock   clock.Clock
+	started time.Time
+
+	mu     sync.RWMutex
+	seen   [2]map[int64]time.Time
+	latest time.Time // newest sample of any resource
+}
+
+func New() *Tracker {
+	t := &Tracker{clock: clock.Real}
+	t.started = t.clock.Now()
+	for i := range t.seen {
+		t.seen[i] = make(map[int64]time.Time)
+	}
+	return t
+}
+
+// SetClock replaces the clock staleness is measured against. Must be called
+// before the tracker is shared.
+func (t *Tracker) SetClock(c clock.Clock) {
+	t.clock = c
+	t.started = c.Now()
+}
+
+// Observe implements buffer.Observer
+func (t *Tracker) Observe(ms []buffer.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, m := range ms {
+		if kind, ok := KindOf(m.Type); ok {
+			t.updateLocked(kind, m.ResourceID, m.Time)
+		}
+	}
+}
+
+func (t *Tracker) updateLocked(kind Kind, id int64, at time.Time) {
+	if at.After(t.seen[kind][id]) {
+		t.seen[kind][id] = at
+	}
+	if at.After(t.latest) {
+		t.latest = at
+	}
+}
+
+// Latest returns the time of the newest sample of any resource, ok false
+// before the first one
+func (t *Tracker) Latest() (time.Time, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.latest, !t.latest.IsZero()
+}
+
+// Load seeds the tracker from the latest values recorded since the given
+// time, so last-seen times survive restarts
+func (t *Tracker) Load(sqlite *store.SQLiteStore, since time.Time) error {
+	latest, err := sqlite.LatestPoints(since, "", trackedTypes, nil)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, p := range latest {
+		if kind, ok := KindOf(p.MetricType); ok {
+			t.updateLocked(kind, p.ResourceID, p.Time)
+		}
+	}
+	return nil
+}
+
+// LastSeen returns the time of the resource's latest sample
+func (t *Tracker) LastSeen(kind Kind, id int64) (time.Time, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	at, ok := t.seen[kind][id]
+	return at, ok
+}
+
+// Stale reports whether a resource has not reported within after
+func (t *Tracker) Stale(kind Kind, id int64, after time.Duration) bool {
+	at, ok := t.LastSeen(kind, id)
+	return t.StaleAt(at, ok, after)
+}
+
+// StaleAt applies the staleness rule to a last-seen time (ok false when
+// never seen). Resources never seen count as stale only once the tracker
+// itself is older than after, so a restart does not flag everything.
+func (t *Tracker) StaleAt(at time.Time, ok bool, after time.Duration) bool {
+	now := t.clock.Now()
+	if !ok {
+		return now.Sub(t.started) > after
+	}
+	return now.Sub(at) > after
+}
@@ -0,0 +1,234 @@
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// Window is a daily time-of-day range (local time) during which
+// maintenance is allowed to run, e.g. "03:00-04:00".
+type Window struct {
+	Start time.Duration // offset from midnight
+	End   time.Duration
+}
+
+// ParseWindow parses "HH:MM-HH:MM". Windows may wrap midnight ("23:30-01:00").
+func ParseWindow(s string) (Window, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return Window{}, fmt.Errorf("invalid maintenance window %q, want HH:MM-HH:MM", s)
+	}
+	start, err := parseClock(parts[0])
+	if err != nil {
+		return Window{}, err
+	}
+	end, err := parseClock(parts[1])
+	if err != nil {
+		return Window{}, err
+	}
+	return Window{Start: start, End: end}, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: %w", s, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t falls inside the window
+func (w Window) Contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// Report describes the outcome of one maintenance run
+type Report struct {
+	StartedAt      time.Time `json:"started_at"`
+	DurationMs     int64     `json:"duration_ms"`
+	Trigger        string    `json:"trigger"` // "schedule" or "manual"
+	SQLiteBefore   int64     `json:"sqlite_bytes_before"`
+	SQLiteAfter    int64     `json:"sqlite_bytes_after"`
+	DuckDBBefore   int64     `json:"duckdb_bytes_before"`
+	DuckDBAfter    int64     `json:"duckdb_bytes_after"`
+	ReclaimedBytes int64     `json:"reclaimed_bytes"`
+	Errors         []string  `json:"errors,omitempty"`
+}
+
+// Scheduler runs SQLite VACUUM/ANALYZE and DuckDB CHECKPOINT once per day
+// inside the configured window, and on demand via the admin endpoint.
+type Scheduler struct {
+	sqlite *store.SQLiteStore
+	duck   *store.DuckDBStore
+	window Window
+
+	running sync.Mutex // held for the duration of a run
+	mu      sync.RWMutex
+	last    *Report
+	lastDay string
+}
+
+func NewScheduler(sqlite *store.SQLiteStore, duck *store.DuckDBStore, window Window) *Scheduler {
+	return &Scheduler{
+		sqlite: sqlite,
+		duck:   duck,
+		window: window,
+	}
+}
+
+// RunScheduled performs maintenance if now is inside the window and it
+// has not run today, as a scheduled job
+func (s *Scheduler) RunScheduled(ctx context.Context, now time.Time) error {
+	if !s.window.Contains(now) {
+		return nil
+	}
+	day := now.Format("2006-01-02")
+	s.mu.RLock()
+	done := s.lastDay == day
+	s.mu.RUnlock()
+	if done {
+		return nil
+	}
+	if rep := s.RunNow("schedule"); len(rep.Errors) > 0 {
+		return errors.New(strings.Join(rep.Errors, "; "))
+	}
+	return nil
+}
+
+// RunNow performs maintenance immediately. Concurrent calls wait for the
+// in-progress run instead of starting a second one.
+func (s *Scheduler) RunNow(trigger string) Report {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	rep := Report{StartedAt: time.Now(), Trigger: trigger}
+	rep.SQLiteBefore = fileSize(s.sqlite.Path())
+	rep.DuckDBBefore = fileSize(s.duck.Files()...)
+
+	log.Printf("Starting %s maintenance", trigger)
+	if err := s.sqlite.Vacuum(); err != nil {
+		rep.Errors = append(rep.Errors, "sqlite: "+err.Error())
+	}
+	if err := s.duck.Checkpoint(); err != nil {
+		rep.Errors = append(rep.Errors, "duckdb: "+err.Error())
+	}
+
+	rep.SQLiteAfter = fileSize(s.sqlite.Path())
+	rep.DuckDBAfter = fileSize(s.duck.Files()...)
+	rep.ReclaimedBytes = (rep.SQLiteBefore - rep.SQLiteAfter) + (rep.DuckDBBefore - rep.DuckDBAfter)
+	rep.DurationMs = time.Since(rep.StartedAt).Milliseconds()
+	log.Printf("Maintenance finished in %dms, reclaimed %d bytes", rep.DurationMs, rep.ReclaimedBytes)
+
+	s.mu.Lock()
+	s.last = &rep
+	s.lastDay = rep.StartedAt.Format("2006-01-02")
+	s.mu.Unlock()
+
+	return rep
+}
+
+func fileSize(paths ...string) int64 {
+	var total int64
+	for _, path := range paths {
+		// Include WAL files, which hold most of the reclaimable space
+		for _, p := range []string{path, path + "-wal", path + ".wal"} {
+			if fi, err := os.Stat(p); err == nil {
+				total += fi.Size()
+			}
+		}
+	}
+	return total
+}
+
+func (s *Scheduler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/internal/maintenance", s.handleMaintenance)
+	mux.HandleFunc("GET /internal/duckdb/months", s.handleMonths)
+	mux.HandleFunc("POST /internal/duckdb/months/{month}/detach", s.handleDetachMonth)
+	mux.HandleFunc("DELETE /internal/duckdb/months/{month}", s.handleDeleteMonth)
+}
+
+// handleMonths lists the monthly DuckDB files
+func (s *Scheduler) handleMonths(w http.ResponseWriter, r *http.Request) {
+	months, err := s.duck.Months()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(months)
+}
+
+// handleDetachMonth detaches a past month's file so it can be moved to an
+// archive; it is attached again on restart if left in the data directory
+func (s *Scheduler) handleDetachMonth(w http.ResponseWriter, r *http.Request) {
+	month := r.PathValue("month")
+	if !s.pastMonth(w, month) {
+		return
+	}
+	path, err := s.duck.DetachMonth(month)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Detached DuckDB month %s (%s)", month, path)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"month": month, "path": path})
+}
+
+// handleDeleteMonth removes a past month's file
+func (s *Scheduler) handleDeleteMonth(w http.ResponseWriter, r *http.Request) {
+	month := r.PathValue("month")
+	if !s.pastMonth(w, month) {
+		return
+	}
+	n, err := s.duck.DeleteMonth(month)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Deleted DuckDB month %s (%d metrics)", month, n)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"month": month, "deleted": n})
+}
+
+// pastMonth rejects the current month, which is still being written
+func (s *Scheduler) pastMonth(w http.ResponseWriter, month string) bool {
+	if month == time.Now().UTC().Format("2006-01") {
+		http.Error(w, "the current month is still being written", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// handleMaintenance returns the last report on GET and runs maintenance on POST
+func (s *Scheduler) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	var body interface{}
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.RLock()
+		body = s.last
+		s.mu.RUnlock()
+	case http.MethodPost:
+		body = s.RunNow("manual")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
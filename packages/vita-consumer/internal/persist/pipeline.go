@@ -0,0 +1,365 @@
+// Package persist is the cold path: it periodically drains the ring buffer
+// and hands each flushed batch to every registered MetricSink.
+package persist
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/nchanged/vitakube/packages/vita-consumer/internal/persist")
+
+// Interval is how often the ring buffer is flushed
+const Interval = 60 * time.Second
+
+// defaultMaxPending bounds how many metrics a sink holds for retry while
+// its writes are failing
+const defaultMaxPending = 100000
+
+// MetricSink is a destination for flushed metrics. Write is called from
+// the sink's own goroutine, one batch at a time. A returned error makes the
+// pipeline retry the same metrics later, so sinks that cannot write
+// idempotently should handle their own errors and return nil.
+type MetricSink interface {
+	Name() string
+	Write(ctx context.Context, batch []buffer.Metric) error
+}
+
+// SinkOptions tune batching and retries per sink
+type SinkOptions struct {
+	// Interval accumulates flushes and writes them together every Interval.
+	// Zero writes after every flush.
+	Interval time.Duration
+	// MaxBatch splits writes into chunks of at most this many metrics.
+	// Zero writes everything in one call.
+	MaxBatch int
+	// MaxPending bounds metrics held while writes fail; the oldest are
+	// dropped beyond it. Zero uses defaultMaxPending.
+	MaxPending int
+	// AcceptLate makes the sink receive metrics passed to Backfill, which
+	// never enter the ring buffer
+	AcceptLate bool
+}
+
+// sinkStats publishes <sink>.written, .failed and .dropped counters
+var sinkStats = expvar.NewMap("persist_sinks")
+
+// Pipeline owns the flush loop and one runner per registered sink
+type Pipeline struct {
+	ring    *buffer.RingBuffer
+	clock   clock.Clock
+	runners []*runner
+
+	mu   sync.Mutex
+	last FlushReport
+}
+
+// FlushReport describes the last ring buffer flush and each sink's last
+// write
+type FlushReport struct {
+	Time       *time.Time   `json:"time,omitempty"`
+	Trigger    string       `json:"trigger,omitempty"` // "interval" or "manual"
+	Metrics    int          `json:"metrics"`           // drained from the ring buffer
+	DurationMs int64        `json:"duration_ms"`       // manual flushes: until every sink wrote
+	Buffered   int          `json:"buffered"`          // in the ring buffer now
+	Sinks      []SinkReport `json:"sinks"`
+
+	// Dropped counts metrics the buffer refused for lack of room since
+	// start, by class (see buffer.Class)
+	Dropped map[string]int64 `json:"dropped"`
+}
+
+// SinkReport is one sink's last write and backlog
+type SinkReport struct {
+	Name       string     `json:"name"`
+	LastWrite  *time.Time `json:"last_write,omitempty"`
+	DurationMs int64      `json:"duration_ms"`
+	Written    int        `json:"written"`         // metrics in the last write
+	Pending    int        `json:"pending"`         // queued, not written yet
+	Error      string     `json:"error,omitempty"` // last failure, cleared by a successful write
+}
+
+func NewPipeline(ring *buffer.RingBuffer) *Pipeline {
+	return &Pipeline{ring: ring, clock: clock.Real}
+}
+
+// SetClock replaces the clock driving the flush and sink tickers. Must be
+// called before Run.
+func (p *Pipeline) SetClock(c clock.Clock) {
+	p.clock = c
+}
+
+// Register adds a sink. Must be called before Run.
+func (p *Pipeline) Register(sink MetricSink, opts SinkOptions) {
+	if opts.MaxPending <= 0 {
+		opts.MaxPending = defaultMaxPending
+	}
+	p.runners = append(p.runners, &runner{sink: sink, opts: opts, notify: make(chan struct{}, 1), force: make(chan chan struct{})})
+}
+
+// Run flushes every Interval and runs each sink until ctx is done
+func (p *Pipeline) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range p.runners {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.run(ctx, p.clock)
+		}()
+	}
+	defer wg.Wait()
+
+	ticker := p.clock.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			p.flush("interval")
+		}
+	}
+}
+
+// FlushNow drains the ring buffer and queues its contents on every sink.
+// Sinks write asynchronously.
+func (p *Pipeline) FlushNow() {
+	p.flush("manual")
+}
+
+// Flush drains the ring buffer and waits until every sink has written all
+// it holds, including sinks that batch by Interval, so a restart loses
+// nothing. It returns early with ctx's error, or with the error of a sink
+// that failed to write; the report says what was left pending.
+func (p *Pipeline) Flush(ctx context.Context) (FlushReport, error) {
+	start := p.clock.Now()
+	p.flush("manual")
+
+	var failed error
+	for _, r := range p.runners {
+		done := make(chan struct{})
+		select {
+		case r.force <- done:
+		case <-ctx.Done():
+			return p.Report(), ctx.Err()
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return p.Report(), ctx.Err()
+		}
+		if err := r.lastError(); err != nil && failed == nil {
+			failed = fmt.Errorf("sink %s: %w", r.sink.Name(), err)
+		}
+	}
+
+	p.mu.Lock()
+	p.last.DurationMs = p.clock.Now().Sub(start).Milliseconds()
+	p.mu.Unlock()
+	return p.Report(), failed
+}
+
+func (p *Pipeline) flush(trigger string) {
+	_, span := tracer.Start(context.Background(), "persist.flush")
+	defer span.End()
+
+	now := p.clock.Now()
+	data := p.ring.Flush()
+	span.SetAttributes(attribute.Int("metrics", len(data)), attribute.String("trigger", trigger))
+	if len(data) > 0 {
+		log.Printf("Flushing %d metrics to %d sinks...", len(data), len(p.runners))
+	}
+	p.mu.Lock()
+	p.last = FlushReport{Time: &now, Trigger: trigger, Metrics: len(data)}
+	p.mu.Unlock()
+
+	// Sinks only read batches, so they can share one slice
+	for _, r := range p.runners {
+		r.offer(data, true)
+	}
+}
+
+// Report returns the last flush and every sink's state
+func (p *Pipeline) Report() FlushReport {
+	p.mu.Lock()
+	rep := p.last
+	p.mu.Unlock()
+
+	rep.Buffered = p.ring.Len()
+	rep.Dropped = p.ring.Dropped()
+	rep.Sinks = make([]SinkReport, 0, len(p.runners))
+	for _, r := range p.runners {
+		rep.Sinks = append(rep.Sinks, r.report())
+	}
+	return rep
+}
+
+// Backfill queues metrics on every sink that accepts late data, bypassing
+// the ring buffer. Used for samples too old to belong in the live view,
+// e.g. from an agent catching up after an outage.
+func (p *Pipeline) Backfill(batch []buffer.Metric) {
+	for _, r := range p.runners {
+		if r.opts.AcceptLate {
+			r.offer(batch, false)
+		}
+	}
+}
+
+// runner feeds one sink from its own inbox
+type runner struct {
+	sink   MetricSink
+	opts   SinkOptions
+	notify chan struct{}
+	// force asks for an immediate write, closing the channel sent once done
+	force chan chan struct{}
+
+	mu    sync.Mutex
+	inbox []buffer.Metric
+	// flushes counts offers, so sinks that skip empty batches still see
+	// every flush (rollups need the clock to move on)
+	flushes int
+	last    SinkReport
+	lastErr error
+}
+
+func (r *runner) report() SinkReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep := r.last
+	rep.Name = r.sink.Name()
+	rep.Pending = len(r.inbox)
+	if r.lastErr != nil {
+		rep.Error = r.lastErr.Error()
+	}
+	return rep
+}
+
+func (r *runner) lastError() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastErr
+}
+
+// offer queues a batch, dropping the oldest metrics beyond MaxPending.
+// flush tells ring buffer flushes from backfills.
+func (r *runner) offer(batch []buffer.Metric, flush bool) {
+	r.mu.Lock()
+	r.inbox = append(r.inbox, batch...)
+	if flush {
+		r.flushes++
+	}
+	r.trimLocked()
+	r.mu.Unlock()
+
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (r *runner) trimLocked() {
+	if over := len(r.inbox) - r.opts.MaxPending; over > 0 {
+		log.Printf("Sink %s: dropping %d metrics after repeated write failures", r.sink.Name(), over)
+		sinkStats.Add(r.sink.Name()+".dropped", int64(over))
+		r.inbox = append(r.inbox[:0:0], r.inbox[over:]...)
+	}
+}
+
+func (r *runner) run(ctx context.Context, clk clock.Clock) {
+	// Without an interval the ticker only paces retries
+	period := r.opts.Interval
+	if period <= 0 {
+		period = Interval
+	}
+	ticker := clk.NewTicker(period)
+	defer ticker.Stop()
+
+	var failing bool
+	for {
+		var done chan struct{}
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.notify:
+			if r.opts.Interval > 0 {
+				continue
+			}
+		case <-ticker.C():
+		case done = <-r.force:
+		}
+
+		err := r.drain(ctx, clk)
+		if done != nil {
+			close(done)
+		}
+		switch {
+		case err != nil && !failing:
+			log.Printf("Sink %s: write failed, will retry: %v", r.sink.Name(), err)
+		case err == nil && failing:
+			log.Printf("Sink %s: recovered", r.sink.Name())
+		}
+		failing = err != nil
+	}
+}
+
+// drain writes the inbox in MaxBatch chunks. On failure the unwritten
+// remainder goes back in front of anything queued meanwhile.
+func (r *runner) drain(ctx context.Context, clk clock.Clock) error {
+	r.mu.Lock()
+	batch, flushes := r.inbox, r.flushes
+	r.inbox, r.flushes = nil, 0
+	r.mu.Unlock()
+
+	if len(batch) == 0 && flushes == 0 {
+		return nil
+	}
+	start := clk.Now()
+	written := 0
+
+	ctx, span := tracer.Start(ctx, "persist.write", trace.WithAttributes(
+		attribute.String("sink", r.sink.Name()),
+		attribute.Int("metrics", len(batch)),
+	))
+	defer span.End()
+
+	// Empty flushes still reach the sink once, see runner.flushes
+	for {
+		n := len(batch)
+		if r.opts.MaxBatch > 0 && n > r.opts.MaxBatch {
+			n = r.opts.MaxBatch
+		}
+		if err := r.sink.Write(ctx, batch[:n]); err != nil {
+			sinkStats.Add(r.sink.Name()+".failed", 1)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			r.mu.Lock()
+			r.inbox = append(batch, r.inbox...)
+			r.trimLocked()
+			r.lastErr = err
+			r.mu.Unlock()
+			return err
+		}
+		sinkStats.Add(r.sink.Name()+".written", int64(n))
+		written += n
+		if batch = batch[n:]; len(batch) == 0 {
+			now := clk.Now()
+			r.mu.Lock()
+			r.last = SinkReport{LastWrite: &now, DurationMs: now.Sub(start).Milliseconds(), Written: written}
+			r.lastErr = nil
+			r.mu.Unlock()
+			return nil
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package persist
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/pseudonym"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/units"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RemoteWriteConfig configures forwarding to a Prometheus remote_write
+// endpoint (Prometheus, Mimir, Thanos Receive, VictoriaMetrics)
+type RemoteWriteConfig struct {
+	URL         string
+	Username    string // basic auth, optional
+	Password    string
+	BearerToken string // optional, instead of basic auth
+	TenantID    string // sent as X-Scope-OrgID (Mimir/Cortex), optional
+	// ExternalLabels are added to every series, e.g. cluster="prod"
+	ExternalLabels map[string]string
+	// Pseudonyms, when set, replaces the values of PseudonymLabels
+	// (DefaultPseudonymLabels when empty) with keyed hashes
+	Pseudonyms      *pseudonym.Hasher
+	PseudonymLabels []string
+}
+
+// DefaultPseudonymLabels are the resource labels that name workloads
+var DefaultPseudonymLabels = []string{"namespace", "pod", "workload", "persistentvolumeclaim"}
+
+// RemoteWriteSink encodes flushed metrics as a remote_write 1.0 request,
+// labelled from the SQLite catalog
+type RemoteWriteSink struct {
+	cfg    RemoteWriteConfig
+	sqlite *store.SQLiteStore
+	client *http.Client
+	hashed map[string]bool // labels pseudonymized
+}
+
+func NewRemoteWriteSink(sqlite *store.SQLiteStore, cfg RemoteWriteConfig) *RemoteWriteSink {
+	s := &RemoteWriteSink{cfg: cfg, sqlite: sqlite, client: &http.Client{Timeout: 30 * time.Second}}
+	if cfg.Pseudonyms != nil {
+		names := cfg.PseudonymLabels
+		if len(names) == 0 {
+			names = DefaultPseudonymLabels
+		}
+		s.hashed = make(map[string]bool, len(names))
+		for _, n := range names {
+			s.hashed[n] = true
+		}
+	}
+	return s
+}
+
+func (s *RemoteWriteSink) Name() string { return "remote_write" }
+
+// ParseLabels reads "k=v,k2=v2" into a label map
+func ParseLabels(spec string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, part := range strings.Split(spec, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		k = strings.TrimSpace(k)
+		if !ok || !validLabelName(k) {
+			return nil, fmt.Errorf("invalid label %q, want name=value", part)
+		}
+		out[k] = strings.TrimSpace(v)
+	}
+	return out, nil
+}
+
+func validLabelName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// seriesName maps a metric type to a Prometheus metric name in base units
+// and returns the factor from the reported unit, e.g. cpu_ms becomes
+// vitakube_pod_cpu_seconds_total (x 1e-3) and used_mb becomes
+// vitakube_pvc_used_bytes (x 2^20)
+func seriesName(kind, metricType string) (string, float64) {
+	info, ok := units.Lookup(metricType)
+	if !ok {
+		return "vitakube_" + kind + "_" + metricType, 1
+	}
+
+	base := metricType
+	if info.Dimension != units.DimensionCount {
+		if i := strings.LastIndexByte(base, '_'); i > 0 {
+			base = base[:i] // drop the unit suffix
+		}
+	}
+
+	var suffix, target string
+	switch info.Dimension {
+	case units.DimensionCPUTime:
+		suffix, target = "_seconds", "s"
+	case units.DimensionBytes:
+		suffix, target = "_bytes", "bytes"
+	}
+	if info.Counter {
+		suffix += "_total"
+	}
+
+	factor := 1.0
+	if target != "" {
+		if c, err := units.NewConverter(metricType, target); err == nil {
+			factor = c.Apply(1)
+		}
+	}
+	return "vitakube_" + kind + "_" + base + suffix, factor
+}
+
+// remoteKind decides whether a buffered sample belongs to a pod or a PVC,
+// the same split ingest makes
+func remoteKind(metricType string) string {
+	switch metricType {
+	case "total_mb", "used_mb", "free_mb":
+		return "pvc"
+	}
+	return "pod"
+}
+
+type label struct{ name, value string }
+
+type remoteSeries struct {
+	labels  []label
+	samples []buffer.Metric
+	factor  float64
+}
+
+func (s *RemoteWriteSink) Write(ctx context.Context, batch []buffer.Metric) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	podIDs, pvcIDs := map[int64]bool{}, map[int64]bool{}
+	for _, m := range batch {
+		if m.ResourceID <= 0 {
+			continue
+		}
+		if remoteKind(m.Type) == "pvc" {
+			pvcIDs[m.ResourceID] = true
+		} else {
+			podIDs[m.ResourceID] = true
+		}
+	}
+	pods, err := s.podLabels(podIDs)
+	if err != nil {
+		return err
+	}
+	pvcs, err := s.pvcLabels(pvcIDs)
+	if err != nil {
+		return err
+	}
+
+	type seriesKey struct {
+		kind string
+		id   int64
+		typ  string
+	}
+	series := map[seriesKey]*remoteSeries{}
+	var order []seriesKey
+	var unlabelled int
+	for _, m := range batch {
+		kind := remoteKind(m.Type)
+		catalog := pods
+		if kind == "pvc" {
+			catalog = pvcs
+		}
+		resLabels, ok := catalog[m.ResourceID]
+		if !ok {
+			unlabelled++
+			continue
+		}
+
+		key := seriesKey{kind, m.ResourceID, m.Type}
+		rs, ok := series[key]
+		if !ok {
+			name, factor := seriesName(kind, m.Type)
+			rs = &remoteSeries{factor: factor, labels: s.seriesLabels(name, resLabels)}
+			series[key] = rs
+			order = append(order, key)
+		}
+		rs.samples = append(rs.samples, m)
+	}
+	if unlabelled > 0 {
+		log.Printf("Remote write: skipped %d samples of resources missing from the catalog", unlabelled)
+	}
+	if len(order) == 0 {
+		return nil
+	}
+
+	var req []byte
+	for _, key := range order {
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, encodeSeries(series[key]))
+	}
+	return s.send(ctx, snappy.Encode(nil, req))
+}
+
+// seriesLabels combines the metric name, external and resource labels,
+// sorted by name as remote_write requires. Resource labels win over
+// external ones; those configured are pseudonymized.
+func (s *RemoteWriteSink) seriesLabels(name string, resLabels []label) []label {
+	merged := map[string]string{"__name__": name}
+	for k, v := range s.cfg.ExternalLabels {
+		merged[k] = v
+	}
+	for _, l := range resLabels {
+		if s.hashed[l.name] {
+			l.value = s.cfg.Pseudonyms.Name(l.name, l.value)
+		}
+		merged[l.name] = l.value
+	}
+	out := make([]label, 0, len(merged))
+	for k, v := range merged {
+		if v != "" {
+			out = append(out, label{k, v})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out
+}
+
+// encodeSeries writes a prometheus.TimeSeries message:
+// labels = 1 (name = 1, value = 2), samples = 2 (value = 1, timestamp = 2)
+func encodeSeries(rs *remoteSeries) []byte {
+	var b []byte
+	for _, l := range rs.labels {
+		var lb []byte
+		lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.name)
+		lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.value)
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, lb)
+	}
+
+	// Samples must be in time order within a series
+	sort.SliceStable(rs.samples, func(i, j int) bool { return rs.samples[i].Time.Before(rs.samples[j].Time) })
+	for _, m := range rs.samples {
+		var sb []byte
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(m.Value*rs.factor))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(m.Time.UnixMilli()))
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, sb)
+	}
+	return b
+}
+
+// remoteWriteError is a rejection that retrying cannot fix
+type remoteWriteError struct {
+	status int
+	body   string
+}
+
+func (e *remoteWriteError) Error() string {
+	return fmt.Sprintf("remote write rejected with %d: %s", e.status, e.body)
+}
+
+// send posts one compressed request. 5xx, 429 and network errors are
+// returned for the pipeline to retry; other 4xx mean the data itself was
+// refused (out of order, too old, limits), so the batch is dropped.
+func (s *RemoteWriteSink) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "vita-consumer")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if s.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", s.cfg.TenantID)
+	}
+	if s.cfg.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.BearerToken)
+	} else if s.cfg.Username != "" {
+		req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return &remoteWriteError{resp.StatusCode, strings.TrimSpace(string(msg))}
+	default:
+		log.Printf("Remote write: dropping batch: %v", &remoteWriteError{resp.StatusCode, strings.TrimSpace(string(msg))})
+		return nil
+	}
+}
+
+// podLabels loads namespace, pod, node and owning workload for pod IDs
+func (s *RemoteWriteSink) podLabels(ids map[int64]bool) (map[int64][]label, error) {
+	return s.catalogLabels(ids, `
+		SELECT p.id, ns.name, p.name, n.name,
+			CASE WHEN p.deployment_id IS NOT NULL THEN 'deployment'
+			     WHEN p.statefulset_id IS NOT NULL THEN 'statefulset'
+			     WHEN p.daemonset_id IS NOT NULL THEN 'daemonset' ELSE '' END,
+			COALESCE(d.name, sts.name, ds.name, '')
+		FROM pods p
+		JOIN namespaces ns ON p.namespace_id = ns.id
+		JOIN nodes n ON p.node_id = n.id
+		LEFT JOIN deployments d ON p.deployment_id = d.id
+		LEFT JOIN statefulsets sts ON p.statefulset_id = sts.id
+		LEFT JOIN daemonsets ds ON p.daemonset_id = ds.id
+		WHERE p.id IN (%s)`,
+		[]string{"namespace", "pod", "node", "workload_kind", "workload"})
+}
+
+// pvcLabels loads namespace and claim name for PVC IDs
+func (s *RemoteWriteSink) pvcLabels(ids map[int64]bool) (map[int64][]label, error) {
+	return s.catalogLabels(ids, `
+		SELECT v.id, ns.name, v.name
+		FROM pvcs v
+		JOIN namespaces ns ON v.namespace_id = ns.id
+		WHERE v.id IN (%s)`,
+		[]string{"namespace", "persistentvolumeclaim"})
+}
+
+// catalogLabels runs query (id first, then one column per label name)
+func (s *RemoteWriteSink) catalogLabels(ids map[int64]bool, query string, names []string) (map[int64][]label, error) {
+	out := make(map[int64][]label, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+	args := make([]interface{}, 0, len(ids))
+	marks := make([]string, 0, len(ids))
+	for id := range ids {
+		args = append(args, id)
+		marks = append(marks, "?")
+	}
+
+	rows, err := s.sqlite.Query(fmt.Sprintf(query, strings.Join(marks, ",")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		values := make([]string, len(names))
+		dest := []interface{}{&id}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			continue
+		}
+		labels := make([]label, len(names))
+		for i, n := range names {
+			labels[i] = label{n, values[i]}
+		}
+		out[id] = labels
+	}
+	return out, rows.Err()
+}
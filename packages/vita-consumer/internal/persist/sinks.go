@@ -0,0 +1,200 @@
+package persist
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/envelope"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/rollup"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+func toPoints(batch []buffer.Metric) []store.MetricPoint {
+	points := make([]store.MetricPoint, len(batch))
+	for i, m := range batch {
+		points[i] = store.MetricPoint{
+			Time:         m.Time,
+			ResourceID:   m.ResourceID,
+			ResourceKind: m.Kind,
+			MetricType:   m.Type,
+			Value:        m.Value,
+			SourceID:     m.SourceID,
+			SampleRate:   m.SampleRate,
+		}
+	}
+	return points
+}
+
+// DuckDBSink stores raw metrics in the local DuckDB
+type DuckDBSink struct {
+	duck *store.DuckDBStore
+}
+
+func NewDuckDBSink(duck *store.DuckDBStore) *DuckDBSink {
+	return &DuckDBSink{duck: duck}
+}
+
+func (s *DuckDBSink) Name() string { return "duckdb" }
+
+// Write is transactional, so a failed batch can be retried as a whole.
+// Points already stored are skipped, so retries and replays are harmless.
+func (s *DuckDBSink) Write(ctx context.Context, batch []buffer.Metric) error {
+	return s.duck.BatchInsert(toPoints(batch))
+}
+
+// TotalsSink writes the per-node rollups. NodeTotals is stateful, so
+// failures are logged rather than retried: re-adding a batch would count
+// it twice.
+type TotalsSink struct {
+	duck   *store.DuckDBStore
+	totals *rollup.NodeTotals
+	clock  clock.Clock
+}
+
+func NewTotalsSink(duck *store.DuckDBStore, totals *rollup.NodeTotals, clk clock.Clock) *TotalsSink {
+	return &TotalsSink{duck: duck, totals: totals, clock: clk}
+}
+
+func (s *TotalsSink) Name() string { return "node_totals" }
+
+func (s *TotalsSink) Write(ctx context.Context, batch []buffer.Metric) error {
+	if err := s.duck.InsertNodeTotals(s.totals.Add(batch, s.clock.Now())); err != nil {
+		log.Printf("Error writing node totals: %v", err)
+	}
+	return nil
+}
+
+// latestRetention is how long a series that stopped reporting keeps its
+// latest value
+const latestRetention = 30 * 24 * time.Hour
+
+// LatestSink keeps the newest value of every series in SQLite, so
+// endpoints that only need current values read one small table instead of
+// scanning DuckDB or the ring buffer
+type LatestSink struct {
+	sqlite *store.SQLiteStore
+	duck   *store.DuckDBStore
+	clock  clock.Clock
+	pruned time.Time
+}
+
+func NewLatestSink(sqlite *store.SQLiteStore, duck *store.DuckDBStore, clk clock.Clock) *LatestSink {
+	return &LatestSink{sqlite: sqlite, duck: duck, clock: clk}
+}
+
+func (s *LatestSink) Name() string { return "latest" }
+
+// Write never moves a series back to an older point, so retries and late
+// batches are harmless. Series silent for latestRetention are dropped
+// hourly.
+func (s *LatestSink) Write(ctx context.Context, batch []buffer.Metric) error {
+	if err := s.sqlite.UpsertLatest(toPoints(batch)); err != nil {
+		return err
+	}
+	if now := s.clock.Now(); now.Sub(s.pruned) >= time.Hour {
+		s.pruned = now
+		if _, err := s.sqlite.DeleteLatestBefore(now.Add(-latestRetention)); err != nil {
+			log.Printf("Failed to prune latest values: %v", err)
+		}
+	}
+	return nil
+}
+
+// Seed fills an empty latest values table from the points DuckDB stored
+// within latestRetention, e.g. on the first start after an upgrade
+func (s *LatestSink) Seed(ctx context.Context) error {
+	if ok, err := s.sqlite.HasLatest(); err != nil || ok {
+		return err
+	}
+	points, err := s.duck.LatestPoints(ctx, "", s.clock.Now().Add(-latestRetention))
+	if err != nil {
+		return err
+	}
+	return s.sqlite.UpsertLatest(points)
+}
+
+// ParquetSink writes each batch to its own Parquet file in a directory,
+// for shipping to object storage with the tool of your choice. Files are
+// written under a temporary name and renamed, so a syncing tool never
+// picks up a partial file.
+type ParquetSink struct {
+	dir     string
+	key     envelope.KeyWrapper
+	sources SourceLookup
+}
+
+// SourceLookup resolves the source ids points carry
+type SourceLookup interface {
+	Source(id int64) (store.Source, bool)
+}
+
+func NewParquetSink(dir string) (*ParquetSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &ParquetSink{dir: dir}, nil
+}
+
+func (s *ParquetSink) Name() string { return "parquet" }
+
+// SetEncryption envelope-encrypts every file with a data key wrapped by
+// kw; files get the envelope.Ext suffix. The plaintext file only exists
+// under its temporary name.
+func (s *ParquetSink) SetEncryption(kw envelope.KeyWrapper) {
+	s.key = kw
+}
+
+// SetSources spells out each row's cluster, consumer instance and agent
+// version next to its source id
+func (s *ParquetSink) SetSources(l SourceLookup) {
+	s.sources = l
+}
+
+func (s *ParquetSink) Write(ctx context.Context, batch []buffer.Metric) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	from, to := batch[0].Time, batch[0].Time
+	for _, m := range batch {
+		if m.Time.Before(from) {
+			from = m.Time
+		}
+		if m.Time.After(to) {
+			to = m.Time
+		}
+	}
+
+	name := fmt.Sprintf("metrics-%s-%s.parquet", from.UTC().Format("20060102T150405Z"), to.UTC().Format("20060102T150405Z"))
+	path := filepath.Join(s.dir, name)
+	tmp := filepath.Join(s.dir, "."+name+".tmp")
+	sources := map[int64]store.Source{}
+	if s.sources != nil {
+		for _, m := range batch {
+			if _, ok := sources[m.SourceID]; !ok {
+				if src, ok := s.sources.Source(m.SourceID); ok {
+					sources[m.SourceID] = src
+				}
+			}
+		}
+	}
+	if err := store.WriteParquet(ctx, tmp, toPoints(batch), sources); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if s.key == nil {
+		return os.Rename(tmp, path)
+	}
+
+	defer os.Remove(tmp)
+	sealed := filepath.Join(s.dir, "."+name+envelope.Ext+".tmp")
+	if err := envelope.EncryptFile(tmp, sealed, s.key); err != nil {
+		return err
+	}
+	return os.Rename(sealed, path+envelope.Ext)
+}
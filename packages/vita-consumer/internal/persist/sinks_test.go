@@ -0,0 +1,174 @@
+package persist_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/envelope"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/persist"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+)
+
+// TestExportEncryption verifies Parquet exports are only written
+// encrypted, decrypt with the right key only, and fail on tampering; and
+// that data keys can be wrapped by a Vault transit engine
+func TestExportEncryption(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		dir, err := os.MkdirTemp("", "export")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		keyPath := filepath.Join(dir, "key")
+		if err := os.WriteFile(keyPath, []byte(strings.Repeat("ab", 32)+"\n"), 0600); err != nil {
+			return err
+		}
+		key, err := envelope.LoadKeyFile(keyPath)
+		if err != nil {
+			return err
+		}
+
+		out := filepath.Join(dir, "out")
+		sink, err := persist.NewParquetSink(out)
+		if err != nil {
+			return err
+		}
+		sink.SetEncryption(key)
+		now := env.Clock.Now()
+		var batch []buffer.Metric
+		for i := 0; i < 1000; i++ {
+			batch = append(batch, buffer.Metric{Time: now.Add(time.Duration(i) * time.Millisecond), ResourceID: int64(i % 50), Type: "mem_mb", Value: float64(i)})
+		}
+		if err := sink.Write(ctx, batch); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(out)
+		if err != nil {
+			return err
+		}
+		if len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), ".parquet"+envelope.Ext) {
+			return fmt.Errorf("export dir holds %v, want one encrypted file", entries)
+		}
+		sealed := filepath.Join(out, entries[0].Name())
+		raw, err := os.ReadFile(sealed)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(raw, []byte("PAR1")) || bytes.Contains(raw, []byte("mem_mb")) {
+			return fmt.Errorf("encrypted export contains plaintext")
+		}
+
+		plain := filepath.Join(dir, "plain.parquet")
+		if err := envelope.DecryptFile(sealed, plain, key); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(plain)
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+			return fmt.Errorf("decrypted export is not a parquet file")
+		}
+
+		// Tampering, truncation and the wrong key are all refused, on a file
+		// spanning several chunks
+		big := make([]byte, 200<<10)
+		rand.Read(big)
+		bigPath, bigSealed := filepath.Join(dir, "big"), filepath.Join(dir, "big.enc")
+		if err := os.WriteFile(bigPath, big, 0600); err != nil {
+			return err
+		}
+		if err := envelope.EncryptFile(bigPath, bigSealed, key); err != nil {
+			return err
+		}
+		if err := envelope.DecryptFile(bigSealed, plain, key); err != nil {
+			return err
+		}
+		if back, err := os.ReadFile(plain); err != nil || !bytes.Equal(back, big) {
+			return fmt.Errorf("multi-chunk round trip changed the file (%v)", err)
+		}
+		if raw, err = os.ReadFile(bigSealed); err != nil {
+			return err
+		}
+		tampered := filepath.Join(dir, "tampered.enc")
+		for name, content := range map[string][]byte{
+			"flipped byte": append(append(append([]byte{}, raw[:len(raw)/2]...), raw[len(raw)/2]^1), raw[len(raw)/2+1:]...),
+			"truncated":    raw[:len(raw)-100],
+			"last chunk dropped": func() []byte {
+				// First chunk only: header, then flag, length and 64 KiB + tag
+				n := bytes.Index(raw, []byte{0, 0, 1, 0, 16})
+				return raw[:n+5+64<<10+16]
+			}(),
+		} {
+			if err := os.WriteFile(tampered, content, 0600); err != nil {
+				return err
+			}
+			if err := envelope.DecryptFile(tampered, plain, key); err == nil {
+				return fmt.Errorf("%s export decrypted", name)
+			}
+		}
+		otherPath := filepath.Join(dir, "other")
+		if err := os.WriteFile(otherPath, bytes.Repeat([]byte{7}, 32), 0600); err != nil {
+			return err
+		}
+		other, err := envelope.LoadKeyFile(otherPath)
+		if err != nil {
+			return err
+		}
+		if err := envelope.DecryptFile(sealed, plain, other); err == nil || !strings.Contains(err.Error(), key.ID()) {
+			return fmt.Errorf("decrypting with another key: %v", err)
+		}
+
+		// A fake transit engine: ciphertext is the reversed plaintext
+		vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Vault-Token") != "s.token" {
+				http.Error(w, "permission denied", http.StatusForbidden)
+				return
+			}
+			var in map[string]string
+			json.NewDecoder(r.Body).Decode(&in)
+			reverse := func(s string) string {
+				b := []byte(s)
+				slices.Reverse(b)
+				return string(b)
+			}
+			switch r.URL.Path {
+			case "/v1/transit/encrypt/vitakube":
+				json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"ciphertext": "vault:v1:" + reverse(in["plaintext"])}})
+			case "/v1/transit/decrypt/vitakube":
+				json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{"plaintext": reverse(strings.TrimPrefix(in["ciphertext"], "vault:v1:"))}})
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer vault.Close()
+		transit := envelope.NewVaultTransit(vault.URL, "", "vitakube", "s.token")
+		viaVault := filepath.Join(dir, "vault.enc")
+		if err := envelope.EncryptFile(bigPath, viaVault, transit); err != nil {
+			return err
+		}
+		roundTrip := filepath.Join(dir, "vault.parquet")
+		if err := envelope.DecryptFile(viaVault, roundTrip, transit); err != nil {
+			return err
+		}
+		if back, err := os.ReadFile(roundTrip); err != nil || !bytes.Equal(back, big) {
+			return fmt.Errorf("vault round trip changed the file (%v)", err)
+		}
+		if err := envelope.DecryptFile(viaVault, roundTrip, envelope.NewVaultTransit(vault.URL, "", "vitakube", "wrong")); err == nil {
+			return fmt.Errorf("decrypted with a refused vault token")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,130 @@
+// Package processes keeps the top processes agents report inside each pod.
+// The volume grows with pods times processes, so it is off unless enabled,
+// capped per pod and kept only briefly.
+package processes
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// flushInterval is how often samples are written to DuckDB
+const flushInterval = 15 * time.Second
+
+// maxPending bounds samples held between flushes; more are dropped
+const maxPending = 100000
+
+// Config sizes what is kept. Zero values take the defaults.
+type Config struct {
+	TopN      int           // processes kept per pod and sample (default 10)
+	Retention time.Duration // how long samples are kept (default 24h)
+}
+
+// Recorder batches process samples into DuckDB and prunes old ones
+type Recorder struct {
+	duck  *store.DuckDBStore
+	clock clock.Clock
+	cfg   Config
+
+	mu      sync.Mutex
+	pending []store.ProcessSample
+	dropped int
+}
+
+func New(duck *store.DuckDBStore, cfg Config) *Recorder {
+	if cfg.TopN <= 0 {
+		cfg.TopN = 10
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = 24 * time.Hour
+	}
+	return &Recorder{duck: duck, clock: clock.Real, cfg: cfg}
+}
+
+// SetClock replaces the clock retention is measured with. Must be called
+// before the recorder is shared.
+func (r *Recorder) SetClock(c clock.Clock) {
+	r.clock = c
+}
+
+// Add queues the samples of one agent post, keeping the TopN by CPU time
+// then memory for each pod and timestamp. samples is reordered.
+func (r *Recorder) Add(samples []store.ProcessSample) {
+	sort.SliceStable(samples, func(i, j int) bool {
+		a, b := samples[i], samples[j]
+		if a.PodID != b.PodID {
+			return a.PodID < b.PodID
+		}
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		if a.CPUms != b.CPUms {
+			return a.CPUms > b.CPUms
+		}
+		return a.MemMB > b.MemMB
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for i, p := range samples {
+		if i > 0 && (p.PodID != samples[i-1].PodID || !p.Time.Equal(samples[i-1].Time)) {
+			n = 0
+		}
+		if n++; n > r.cfg.TopN {
+			continue
+		}
+		if len(r.pending) >= maxPending {
+			r.dropped++
+			continue
+		}
+		r.pending = append(r.pending, p)
+	}
+}
+
+// Flush writes queued samples
+func (r *Recorder) Flush() {
+	r.mu.Lock()
+	batch, dropped := r.pending, r.dropped
+	r.pending, r.dropped = nil, 0
+	r.mu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("Processes: dropped %d samples, the write queue was full", dropped)
+	}
+	if err := r.duck.InsertProcesses(batch); err != nil {
+		log.Printf("Processes: failed to write %d samples: %v", len(batch), err)
+	}
+}
+
+// Prune removes samples past retention as of now, as a scheduled job
+func (r *Recorder) Prune(ctx context.Context, now time.Time) error {
+	if _, err := r.duck.DeleteProcessesBefore(now.Add(-r.cfg.Retention)); err != nil {
+		return fmt.Errorf("pruning process samples: %w", err)
+	}
+	return nil
+}
+
+// Run flushes every flushInterval until ctx is done, then once more.
+// Pruning is left to the job scheduler (see Prune).
+func (r *Recorder) Run(ctx context.Context) {
+	flush := r.clock.NewTicker(flushInterval)
+	defer flush.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.Flush()
+			return
+		case <-flush.C():
+			r.Flush()
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Package provenance stamps what the consumer stores with where it came
+// from: the cluster, this consumer instance and, for agent-reported
+// metrics, the agent's version (see store.Source).
+package provenance
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+const (
+	// Agent versions come from posts; bound what they can add to the
+	// sources table
+	maxAgentVersions = 64
+	maxVersionLen    = 64
+
+	// otherVersion stands in for versions beyond maxAgentVersions
+	otherVersion = "other"
+)
+
+// Sources hands out source ids for one cluster and consumer instance
+type Sources struct {
+	cluster  string
+	instance string
+	sqlite   *store.SQLiteStore
+	duck     *store.DuckDBStore
+	clock    clock.Clock
+	local    store.Source
+
+	mu        sync.RWMutex
+	byVersion map[string]int64
+	byID      map[int64]store.Source
+}
+
+// New registers the consumer's own source, which stamps the catalog and
+// every row written without an agent version
+func New(cluster, instance string, sqlite *store.SQLiteStore, duck *store.DuckDBStore, clk clock.Clock) (*Sources, error) {
+	p := &Sources{
+		cluster:   cluster,
+		instance:  instance,
+		sqlite:    sqlite,
+		duck:      duck,
+		clock:     clk,
+		byVersion: map[string]int64{},
+		byID:      map[int64]store.Source{},
+	}
+	local, err := p.register("")
+	if err != nil {
+		return nil, err
+	}
+	p.local = local
+	if err := sqlite.SetLocalSource(local.ID); err != nil {
+		return nil, err
+	}
+	duck.SetDefaultSource(local.ID)
+	return p, nil
+}
+
+// Cluster is the cluster id stamped on everything stored
+func (p *Sources) Cluster() string { return p.cluster }
+
+// Instance is this consumer's instance id
+func (p *Sources) Instance() string { return p.instance }
+
+// Local is the consumer's own source
+func (p *Sources) Local() store.Source { return p.local }
+
+// ID returns the source of points an agent of agentVersion reported,
+// registering it on first use. Agents that send no version get the local
+// source.
+func (p *Sources) ID(agentVersion string) int64 {
+	agentVersion = strings.TrimSpace(agentVersion)
+	if agentVersion == "" {
+		return p.local.ID
+	}
+	if len(agentVersion) > maxVersionLen {
+		agentVersion = agentVersion[:maxVersionLen]
+	}
+
+	p.mu.RLock()
+	id, ok := p.byVersion[agentVersion]
+	full := len(p.byVersion) >= maxAgentVersions
+	p.mu.RUnlock()
+	if ok {
+		return id
+	}
+	if full {
+		agentVersion = otherVersion
+		p.mu.RLock()
+		id, ok = p.byVersion[agentVersion]
+		p.mu.RUnlock()
+		if ok {
+			return id
+		}
+	}
+
+	src, err := p.register(agentVersion)
+	if err != nil {
+		log.Printf("Failed to record source for agent version %q: %v", agentVersion, err)
+	}
+	return src.ID
+}
+
+// Source resolves an id handed out by this instance
+func (p *Sources) Source(id int64) (store.Source, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	src, ok := p.byID[id]
+	return src, ok
+}
+
+// register records a source in both stores. The id does not depend on
+// either, so points keep it even if recording fails.
+func (p *Sources) register(agentVersion string) (store.Source, error) {
+	src := store.Source{
+		ID:           store.SourceID(p.cluster, p.instance, agentVersion),
+		ClusterID:    p.cluster,
+		InstanceID:   p.instance,
+		AgentVersion: agentVersion,
+		FirstSeen:    p.clock.Now().UTC(),
+	}
+	p.duck.RegisterSource(src)
+	p.mu.Lock()
+	p.byVersion[agentVersion] = src.ID
+	p.byID[src.ID] = src
+	p.mu.Unlock()
+	return src, p.sqlite.AddSource(src)
+}
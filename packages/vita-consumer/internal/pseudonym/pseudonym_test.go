@@ -0,0 +1,111 @@
+package pseudonym_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/persist"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/pseudonym"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExportPseudonyms(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		if _, err := env.Client.CoreV1().Nodes().Create(ctx, synctest.Node("pseudo-node", "2", "4Gi"), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if _, err := env.Client.CoreV1().Pods("payroll").Create(ctx, synctest.Pod("payroll", "salary-api", "pseudo-node", nil), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pod synced", func() (bool, error) {
+			n, err := env.QueryInt(`SELECT count(*) FROM pods p JOIN nodes n ON n.id = p.node_id
+			WHERE n.name = 'pseudo-node' AND p.name = 'salary-api'`)
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+		podID, err := env.QueryInt("SELECT id FROM pods WHERE name = 'salary-api'")
+		if err != nil {
+			return err
+		}
+
+		var bodies [][]byte
+		rw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			raw, _ := io.ReadAll(r.Body)
+			body, err := snappy.Decode(nil, raw)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			bodies = append(bodies, body)
+		}))
+		defer rw.Close()
+		batch := []buffer.Metric{{Time: env.Clock.Now(), ResourceID: podID, Type: "cpu_ms", Value: 1500}}
+		send := func(key string) ([]byte, error) {
+			cfg := persist.RemoteWriteConfig{URL: rw.URL}
+			if key != "" {
+				h, err := pseudonym.New([]byte(key))
+				if err != nil {
+					return nil, err
+				}
+				cfg.Pseudonyms = h
+			}
+			if err := persist.NewRemoteWriteSink(env.SQLite, cfg).Write(ctx, batch); err != nil {
+				return nil, err
+			}
+			if len(bodies) == 0 {
+				return nil, fmt.Errorf("nothing was written")
+			}
+			return bodies[len(bodies)-1], nil
+		}
+
+		plain, err := send("")
+		if err != nil {
+			return err
+		}
+		if !bytes.Contains(plain, []byte("salary-api")) || !bytes.Contains(plain, []byte("payroll")) {
+			return fmt.Errorf("plain export lacks the pod's names")
+		}
+		hashed, err := send("vendor-a-0123456789abcdef")
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(hashed, []byte("salary-api")) || bytes.Contains(hashed, []byte("payroll")) {
+			return fmt.Errorf("pseudonymized export leaks names")
+		}
+		if !bytes.Contains(hashed, []byte("pseudo-node")) {
+			return fmt.Errorf("node label was pseudonymized without being configured")
+		}
+		h, _ := pseudonym.New([]byte("vendor-a-0123456789abcdef"))
+		pod := h.Name("pod", "salary-api")
+		if !bytes.Contains(hashed, []byte(pod)) {
+			return fmt.Errorf("pseudonymized export lacks pod %s", pod)
+		}
+		again, err := send("vendor-a-0123456789abcdef")
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(again, hashed) {
+			return fmt.Errorf("pseudonyms changed between writes")
+		}
+		other, err := send("vendor-b-0123456789abcdef")
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(other, []byte(pod)) {
+			return fmt.Errorf("two keys gave the same pseudonym")
+		}
+		if _, err := pseudonym.New([]byte("short")); err == nil {
+			return fmt.Errorf("short key accepted")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,326 @@
+// Package querystats keeps execution statistics per HTTP endpoint and per
+// SQL statement shape, so slow dashboard panels can be traced back to the
+// queries behind them.
+package querystats
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// samples is how many recent latencies each key keeps for percentiles
+	samples = 256
+	// maxKeys bounds each table; statements past it are counted as "(other)"
+	maxKeys = 500
+	// maxKeyLen truncates very long statements
+	maxKeyLen = 1024
+)
+
+var (
+	endpoints  = newTable()
+	statements = newTable()
+)
+
+// Stat summarises one endpoint or statement shape
+type Stat struct {
+	Key        string  `json:"key"`
+	Backend    string  `json:"backend,omitempty"`
+	Count      int64   `json:"count"`
+	Errors     int64   `json:"errors"`
+	Rows       int64   `json:"rows"`
+	Statements int64   `json:"statements,omitempty"`
+	TotalMs    float64 `json:"total_ms"`
+	AvgMs      float64 `json:"avg_ms"`
+	P95Ms      float64 `json:"p95_ms"`
+	MaxMs      float64 `json:"max_ms"`
+}
+
+type entry struct {
+	backend    string
+	count      int64
+	errors     int64
+	rows       int64
+	statements int64
+	total, max time.Duration
+	recent     [samples]time.Duration
+	next       int
+}
+
+func (e *entry) add(d time.Duration, rows, stmts int64, failed bool) {
+	e.count++
+	e.rows += rows
+	e.statements += stmts
+	e.total += d
+	e.max = max(e.max, d)
+	e.recent[e.next%samples] = d
+	e.next++
+	if failed {
+		e.errors++
+	}
+}
+
+func (e *entry) stat(key string) Stat {
+	n := min(e.next, samples)
+	recent := make([]time.Duration, n)
+	copy(recent, e.recent[:n])
+	sort.Slice(recent, func(i, j int) bool { return recent[i] < recent[j] })
+
+	s := Stat{
+		Key:        key,
+		Backend:    e.backend,
+		Count:      e.count,
+		Errors:     e.errors,
+		Rows:       e.rows,
+		Statements: e.statements,
+		TotalMs:    ms(e.total),
+		MaxMs:      ms(e.max),
+	}
+	if e.count > 0 {
+		s.AvgMs = s.TotalMs / float64(e.count)
+	}
+	if n > 0 {
+		s.P95Ms = ms(recent[(n*95+99)/100-1])
+	}
+	return s
+}
+
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+type table struct {
+	mu sync.Mutex
+	m  map[string]*entry
+}
+
+func newTable() *table { return &table{m: make(map[string]*entry)} }
+
+func (t *table) record(key, backend string, d time.Duration, rows, stmts int64, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.m[key]
+	if e == nil {
+		if len(t.m) >= maxKeys {
+			key = "(other)"
+			e = t.m[key]
+		}
+		if e == nil {
+			e = &entry{backend: backend}
+			t.m[key] = e
+		}
+	}
+	e.add(d, rows, stmts, failed)
+}
+
+func (t *table) snapshot() []Stat {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]Stat, 0, len(t.m))
+	for k, e := range t.m {
+		out = append(out, e.stat(k))
+	}
+	return out
+}
+
+func (t *table) reset() {
+	t.mu.Lock()
+	t.m = make(map[string]*entry)
+	t.mu.Unlock()
+}
+
+// request accumulates the statements run on behalf of one HTTP request
+type request struct {
+	mu         sync.Mutex
+	rows       int64
+	statements int64
+}
+
+type ctxKey struct{}
+
+// RecordStatement records one statement execution. rows is the number of
+// rows returned (queries) or affected (exec). Statements run under a
+// request wrapped by Handler are also counted towards its endpoint.
+func RecordStatement(ctx context.Context, backend, query string, d time.Duration, rows int64, err error) {
+	statements.record(backend+": "+Normalize(query), backend, d, rows, 0, err != nil)
+	if req, ok := ctx.Value(ctxKey{}).(*request); ok {
+		req.mu.Lock()
+		req.rows += rows
+		req.statements++
+		req.mu.Unlock()
+	}
+}
+
+// Normalize reduces a statement to its shape: whitespace is collapsed and
+// placeholder lists of any length ("?,?,?") become "?,...", so IN filters
+// over different numbers of IDs share one entry.
+func Normalize(query string) string {
+	b := make([]byte, 0, min(len(query), maxKeyLen))
+	space := false
+	for i := 0; i < len(query) && len(b) < maxKeyLen; i++ {
+		c := query[i]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			space = len(b) > 0
+			continue
+		}
+		if space {
+			b = append(b, ' ')
+			space = false
+		}
+		b = append(b, c)
+		if c != '?' {
+			continue
+		}
+		// Swallow ", ?" repetitions following a placeholder
+		j, more := i+1, false
+		for {
+			k := skipSpace(query, j)
+			if k >= len(query) || query[k] != ',' {
+				break
+			}
+			k = skipSpace(query, k+1)
+			if k >= len(query) || query[k] != '?' {
+				break
+			}
+			j, more = k+1, true
+		}
+		if more {
+			b = append(b, ",..."...)
+			i = j - 1
+		}
+	}
+	return string(b)
+}
+
+func skipSpace(s string, i int) int {
+	for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// Handler records count, latency and the rows read by SQL statements for
+// every request, keyed by the route pattern the mux matched
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &request{}
+		inner := r.WithContext(context.WithValue(r.Context(), ctxKey{}, req))
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, inner)
+		// Hand the matched pattern back to outer middleware (tracing)
+		r.Pattern = inner.Pattern
+
+		key := inner.Pattern
+		if key == "" {
+			key = "(unmatched)"
+		} else if !strings.Contains(key, " ") {
+			key = r.Method + " " + key
+		}
+		req.mu.Lock()
+		rows, stmts := req.rows, req.statements
+		req.mu.Unlock()
+		endpoints.record(key, "", time.Since(start), rows, stmts, sw.status >= 500)
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Report is the /internal/querystats response
+type Report struct {
+	Endpoints  []Stat `json:"endpoints"`
+	Statements []Stat `json:"statements"`
+}
+
+// Snapshot returns the limit slowest endpoints and statements by total time
+func Snapshot(limit int) Report {
+	return Report{
+		Endpoints:  top(endpoints.snapshot(), orderings["total"], limit),
+		Statements: top(statements.snapshot(), orderings["total"], limit),
+	}
+}
+
+func RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/internal/querystats", handleStats)
+}
+
+// handleStats serves both tables, slowest first. Query parameters:
+// sort (total|p95|max|count|rows, default total), limit (default 50).
+// DELETE clears the tables.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		endpoints.reset()
+		statements.reset()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	by, ok := orderings[r.URL.Query().Get("sort")]
+	if !ok {
+		http.Error(w, "sort must be one of total, p95, max, count, rows", http.StatusBadRequest)
+		return
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	report := Report{
+		Endpoints:  top(endpoints.snapshot(), by, limit),
+		Statements: top(statements.snapshot(), by, limit),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+var orderings = map[string]func(Stat) float64{
+	"":      func(s Stat) float64 { return s.TotalMs },
+	"total": func(s Stat) float64 { return s.TotalMs },
+	"p95":   func(s Stat) float64 { return s.P95Ms },
+	"max":   func(s Stat) float64 { return s.MaxMs },
+	"count": func(s Stat) float64 { return float64(s.Count) },
+	"rows":  func(s Stat) float64 { return float64(s.Rows) },
+}
+
+func top(stats []Stat, by func(Stat) float64, limit int) []Stat {
+	sort.Slice(stats, func(i, j int) bool {
+		if a, b := by(stats[i]), by(stats[j]); a != b {
+			return a > b
+		}
+		return stats[i].Key < stats[j].Key
+	})
+	if len(stats) > limit {
+		stats = stats[:limit]
+	}
+	return stats
+}
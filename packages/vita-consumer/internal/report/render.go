@@ -0,0 +1,108 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// defaultHTML is used when a template has an empty body
+const defaultHTML = `<!DOCTYPE html>
+<html><body style="font-family: sans-serif">
+<h2>{{.Name}}</h2>
+<p>{{date .From}} &ndash; {{date .To}}{{if .Owners}} &middot; {{.Owners}}{{end}}</p>
+
+<h3>Top CPU consumers (millicores)</h3>
+<table>{{range .TopCPU}}<tr><td>{{.Namespace}}/{{.Pod}}</td><td>{{num .Value}}</td></tr>{{else}}<tr><td>No data</td></tr>{{end}}</table>
+
+<h3>Top memory consumers (MiB)</h3>
+<table>{{range .TopMemory}}<tr><td>{{.Namespace}}/{{.Pod}}</td><td>{{num .Value}}</td></tr>{{else}}<tr><td>No data</td></tr>{{end}}</table>
+
+<h3>Namespace growth</h3>
+<table><tr><th>Namespace</th><th>CPU (m)</th><th>CPU/day</th><th>Memory (MiB)</th><th>Memory/day</th></tr>
+{{range .Namespaces}}<tr><td>{{.Namespace}}</td><td>{{num .CPUMillicores}}</td><td>{{num .CPUGrowthPerDay}}</td><td>{{num .MemMB}}</td><td>{{num .MemGrowthPerDay}}</td></tr>{{end}}</table>
+
+{{if .GroupBy}}<h3>Usage by {{.GroupBy}}</h3>
+<table><tr><th>{{.GroupBy}}</th><th>Pods</th><th>CPU (m)</th><th>Memory (MiB)</th></tr>
+{{range .Groups}}<tr><td>{{if .Owner}}{{.Owner}}{{else}}(none){{end}}</td><td>{{.Pods}}</td><td>{{num .CPUMillicores}}</td><td>{{num .MemMB}}</td></tr>{{end}}</table>
+{{end}}
+<h3>Idle workloads</h3>
+<table>{{range .Idle}}<tr><td>{{.Kind}} {{.Namespace}}/{{.Name}}</td><td>{{num .CPUMillicores}}m used</td><td>{{.CPURequestM}}m requested</td></tr>{{else}}<tr><td>None</td></tr>{{end}}</table>
+
+<h3>Volumes filling up</h3>
+<table>{{range .PVCExhaustion}}<tr><td>{{.Namespace}}/{{.Name}}</td><td>{{num .UsedMB}} / {{num .TotalMB}} MiB</td><td>full in {{num .DaysUntilFull}} days</td></tr>{{else}}<tr><td>None</td></tr>{{end}}</table>
+</body></html>
+`
+
+var funcs = template.FuncMap{
+	"date": func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+	"num":  func(v float64) string { return fmt.Sprintf("%.1f", v) },
+}
+
+// Render produces the report body and its content type
+func Render(t store.ReportTemplate, sum *Summary) (string, []byte, error) {
+	switch t.Format {
+	case "json":
+		b, err := json.MarshalIndent(sum, "", "  ")
+		return "application/json", b, err
+	case "html", "":
+		src := t.Body
+		if src == "" {
+			src = defaultHTML
+		}
+		tmpl, err := template.New(t.Name).Funcs(funcs).Parse(src)
+		if err != nil {
+			return "", nil, err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, sum); err != nil {
+			return "", nil, err
+		}
+		return "text/html; charset=utf-8", buf.Bytes(), nil
+	default:
+		return "", nil, fmt.Errorf("unsupported format %q", t.Format)
+	}
+}
+
+// Validate checks a template before it is stored
+func Validate(t store.ReportTemplate) error {
+	if t.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if _, err := ParseCron(t.Schedule); err != nil {
+		return err
+	}
+	if t.PeriodDays <= 0 {
+		return fmt.Errorf("period_days must be positive")
+	}
+	if _, err := time.LoadLocation(t.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", t.Timezone)
+	}
+	switch t.Format {
+	case "json":
+	case "html":
+		if t.Body != "" {
+			if _, err := template.New(t.Name).Funcs(funcs).Parse(t.Body); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("format must be html or json")
+	}
+	switch t.Delivery {
+	case "smtp", "webhook":
+	default:
+		return fmt.Errorf("delivery must be smtp or webhook")
+	}
+	if t.Target == "" {
+		return fmt.Errorf("target is required")
+	}
+	if _, err := store.ParseOwnerFilter(t.Owners); err != nil {
+		return err
+	}
+	return nil
+}
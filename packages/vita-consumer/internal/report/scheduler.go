@@ -0,0 +1,303 @@
+package report
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// Scheduler checks report schedules every minute and delivers due reports
+type Scheduler struct {
+	sqlite *store.SQLiteStore
+	duck   *store.DuckDBStore
+	smtp   SMTPConfig
+
+	mu    sync.Mutex
+	fired map[int64]time.Time // minute each template last fired
+}
+
+func NewScheduler(sqlite *store.SQLiteStore, duck *store.DuckDBStore, smtp SMTPConfig) *Scheduler {
+	return &Scheduler{
+		sqlite: sqlite,
+		duck:   duck,
+		smtp:   smtp,
+		fired:  make(map[int64]time.Time),
+	}
+}
+
+// RunDue delivers the reports whose schedule matches now's minute, as a
+// scheduled job. A minute's reports go out once however often it runs.
+func (s *Scheduler) RunDue(ctx context.Context, now time.Time) error {
+	minute := now.Truncate(time.Minute)
+	templates, err := s.sqlite.ListReportTemplates()
+	if err != nil {
+		return fmt.Errorf("load report templates: %w", err)
+	}
+
+	for _, t := range templates {
+		if !t.Enabled {
+			continue
+		}
+		cron, err := ParseCron(t.Schedule)
+		if err != nil || !cron.Matches(minute.In(location(t))) {
+			continue
+		}
+
+		s.mu.Lock()
+		done := s.fired[t.ID].Equal(minute)
+		s.fired[t.ID] = minute
+		s.mu.Unlock()
+		if done {
+			continue
+		}
+
+		if err := s.Deliver(t, now); err != nil {
+			log.Printf("Report %q failed: %v", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// location is the zone a template's schedule and periods follow; the
+// consumer's own without one. Validate rejects unknown zones.
+func location(t store.ReportTemplate) *time.Location {
+	if t.Timezone != "" {
+		if loc, err := time.LoadLocation(t.Timezone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// Generate builds and renders a report without delivering it
+func (s *Scheduler) Generate(t store.ReportTemplate, now time.Time) (string, []byte, error) {
+	from, to, err := Window(t, now)
+	if err != nil {
+		return "", nil, err
+	}
+	scope, err := TemplateScope(t)
+	if err != nil {
+		return "", nil, err
+	}
+	sum, err := Build(s.sqlite, s.duck, t.Name, from, to, now, scope)
+	if err != nil {
+		return "", nil, err
+	}
+	return Render(t, sum)
+}
+
+// Deliver generates the report, sends it and records the outcome
+func (s *Scheduler) Deliver(t store.ReportTemplate, now time.Time) error {
+	err := s.deliver(t, now)
+	if markErr := s.sqlite.MarkReportRun(t.ID, now, err); markErr != nil {
+		log.Printf("Failed to record report run for %q: %v", t.Name, markErr)
+	}
+	if err == nil {
+		log.Printf("Delivered report %q via %s", t.Name, t.Delivery)
+	}
+	return err
+}
+
+func (s *Scheduler) deliver(t store.ReportTemplate, now time.Time) error {
+	contentType, body, err := s.Generate(t, now)
+	if err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("%s (%s)", t.Name, now.In(location(t)).Format("2006-01-02"))
+	switch t.Delivery {
+	case "smtp":
+		return sendMail(s.smtp, t.Target, subject, contentType, body, now)
+	case "webhook":
+		return postWebhook(t.Target, subject, contentType, body)
+	default:
+		return fmt.Errorf("unsupported delivery %q", t.Delivery)
+	}
+}
+
+func (s *Scheduler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/reports/templates", s.handleTemplates)
+	mux.HandleFunc("/api/v1/reports/preview", s.handlePreview)
+	mux.HandleFunc("/api/v1/reports/run", s.handleRun)
+}
+
+// handleTemplates lists (GET), creates or replaces by name (POST) and
+// deletes (DELETE ?id=) report templates
+func (s *Scheduler) handleTemplates(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		templates, err := s.sqlite.ListReportTemplates()
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, templates)
+
+	case http.MethodPost:
+		t := store.ReportTemplate{Format: "html", PeriodDays: 7, Enabled: true}
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			writeError(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := Validate(t); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		t.ManagedBy = ""
+		if !s.checkUnmanaged(w, t.Name) {
+			return
+		}
+		id, err := s.sqlite.UpsertReportTemplate(t)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		saved, err := s.sqlite.GetReportTemplate(id)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, saved)
+
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeError(w, "id parameter is required", http.StatusBadRequest)
+			return
+		}
+		if t, err := s.sqlite.GetReportTemplate(id); err == nil && !s.checkUnmanaged(w, t.Name) {
+			return
+		}
+		if err := s.sqlite.DeleteReportTemplate(id); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handlePreview renders a template (?id=) or the built-in layout
+// (?format=html|json&period_days=) without delivering it. tz overrides
+// the time zone periods are aligned to, group_by and owners the
+// ownership scope.
+func (s *Scheduler) handlePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	t := store.ReportTemplate{Name: "Weekly summary", Format: "html", PeriodDays: 7}
+	if v := q.Get("id"); v != "" {
+		var ok bool
+		if t, ok = s.lookup(w, v); !ok {
+			return
+		}
+	} else {
+		if f := q.Get("format"); f != "" {
+			t.Format = f
+		}
+		if d, err := strconv.Atoi(q.Get("period_days")); err == nil && d > 0 {
+			t.PeriodDays = d
+		}
+	}
+	if tz := q.Get("tz"); tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			writeError(w, fmt.Sprintf("unknown time zone %q", tz), http.StatusBadRequest)
+			return
+		}
+		t.Timezone = tz
+	}
+	if v, ok := q["group_by"]; ok {
+		t.GroupBy = v[0]
+	}
+	if v, ok := q["owners"]; ok {
+		if _, err := store.ParseOwnerFilter(v[0]); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		t.Owners = v[0]
+	}
+
+	contentType, body, err := s.Generate(t, time.Now())
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+// handleRun delivers a template immediately (POST ?id=)
+func (s *Scheduler) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	t, ok := s.lookup(w, r.URL.Query().Get("id"))
+	if !ok {
+		return
+	}
+	if err := s.Deliver(t, time.Now()); err != nil {
+		writeError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	saved, _ := s.sqlite.GetReportTemplate(t.ID)
+	writeJSON(w, saved)
+}
+
+// checkUnmanaged refuses changes to a template synced from a VitaReport,
+// which would be undone by the next sync
+func (s *Scheduler) checkUnmanaged(w http.ResponseWriter, name string) bool {
+	ref, err := s.sqlite.ManagedBy("report", name)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if ref != "" {
+		writeError(w, fmt.Sprintf("report template %q is managed by %s", name, ref), http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+func (s *Scheduler) lookup(w http.ResponseWriter, rawID string) (store.ReportTemplate, bool) {
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		writeError(w, "id parameter is required", http.StatusBadRequest)
+		return store.ReportTemplate{}, false
+	}
+	t, err := s.sqlite.GetReportTemplate(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, "report template not found", http.StatusNotFound)
+		return t, false
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return t, false
+	}
+	return t, true
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
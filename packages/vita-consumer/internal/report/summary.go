@@ -0,0 +1,480 @@
+// Package report renders periodic usage summaries and delivers them by
+// email or webhook on a cron schedule.
+package report
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/analysis"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+const (
+	topN = 10
+	// IdleCPUMillicores is the average usage below which a workload is idle
+	IdleCPUMillicores = 5
+	// ExhaustionHorizon limits PVC forecasts to volumes filling up soon
+	ExhaustionHorizon = 30 * 24 * time.Hour
+)
+
+// hourly buckets usage for averages and growth fits
+var hourly = store.Bucketing{Step: time.Hour}
+
+// Summary is the data every report is rendered from. Usage values are
+// averages over the period: CPU in millicores, memory and volumes in MiB.
+type Summary struct {
+	Name          string           `json:"name"`
+	GeneratedAt   time.Time        `json:"generated_at"`
+	From          time.Time        `json:"from"`
+	To            time.Time        `json:"to"`
+	TopCPU        []Consumer       `json:"top_cpu"`
+	TopMemory     []Consumer       `json:"top_memory"`
+	Namespaces    []NamespaceUsage `json:"namespaces"`
+	Idle          []IdleWorkload   `json:"idle_workloads"`
+	PVCExhaustion []PVCForecast    `json:"pvc_exhaustion"`
+	// Set when the report is scoped by ownership, see Scope
+	Owners  string       `json:"owners,omitempty"`
+	GroupBy string       `json:"group_by,omitempty"`
+	Groups  []OwnerUsage `json:"groups,omitempty"`
+}
+
+// Scope narrows a summary to the pods whose ownership annotations match
+// Owners and totals their usage by the GroupBy annotation
+type Scope struct {
+	GroupBy string
+	Owners  store.OwnerFilter
+}
+
+// TemplateScope is the scope a template's group_by and owners ask for
+func TemplateScope(t store.ReportTemplate) (Scope, error) {
+	owners, err := store.ParseOwnerFilter(t.Owners)
+	return Scope{GroupBy: t.GroupBy, Owners: owners}, err
+}
+
+// OwnerUsage is the usage of pods sharing one value of the GroupBy
+// annotation; Owner is empty for pods without it
+type OwnerUsage struct {
+	Owner         string  `json:"owner"`
+	Pods          int     `json:"pods"`
+	CPUMillicores float64 `json:"cpu_millicores"`
+	MemMB         float64 `json:"mem_mb"`
+}
+
+type Consumer struct {
+	Namespace string  `json:"namespace"`
+	Pod       string  `json:"pod"`
+	Value     float64 `json:"value"`
+}
+
+// NamespaceUsage includes the linear growth of the namespace total
+type NamespaceUsage struct {
+	Namespace       string  `json:"namespace"`
+	CPUMillicores   float64 `json:"cpu_millicores"`
+	MemMB           float64 `json:"mem_mb"`
+	CPUGrowthPerDay float64 `json:"cpu_growth_per_day"`
+	MemGrowthPerDay float64 `json:"mem_growth_per_day"`
+}
+
+// IdleWorkload is a deployment or statefulset whose pods barely used CPU
+type IdleWorkload struct {
+	Kind          string  `json:"kind"`
+	Namespace     string  `json:"namespace"`
+	Name          string  `json:"name"`
+	Pods          int     `json:"pods"`
+	CPUMillicores float64 `json:"cpu_millicores"`
+	MemMB         float64 `json:"mem_mb"`
+	CPURequestM   int64   `json:"cpu_request_m"`
+}
+
+// PVCForecast is a volume projected to fill within ExhaustionHorizon
+type PVCForecast struct {
+	Namespace      string    `json:"namespace"`
+	Name           string    `json:"name"`
+	UsedMB         float64   `json:"used_mb"`
+	TotalMB        float64   `json:"total_mb"`
+	GrowthMBPerDay float64   `json:"growth_mb_per_day"`
+	DaysUntilFull  float64   `json:"days_until_full"`
+	FullAt         time.Time `json:"full_at"`
+}
+
+type podInfo struct {
+	name, namespace string
+	workloadKind    string
+	workloadID      int64
+	workloadName    string
+	cpuRequestM     int64
+	owners          map[string]string
+}
+
+// Window is the period a report generated at now covers: the last
+// PeriodDays days, or with a time zone the PeriodDays whole local days
+// before today, so a daily report covers one business day
+func Window(t store.ReportTemplate, now time.Time) (from, to time.Time, err error) {
+	if t.Timezone == "" {
+		return now.Add(-time.Duration(t.PeriodDays) * 24 * time.Hour), now, nil
+	}
+	loc, err := time.LoadLocation(t.Timezone)
+	if err != nil {
+		return from, to, err
+	}
+	local := now.In(loc)
+	to = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	return to.AddDate(0, 0, -t.PeriodDays), to, nil
+}
+
+// Build computes the summary for [from, to) over the pods in scope
+func Build(sqlite *store.SQLiteStore, duck *store.DuckDBStore, name string, from, to, now time.Time, scope Scope) (*Summary, error) {
+	sum := &Summary{
+		Name:          name,
+		GeneratedAt:   now,
+		From:          from,
+		To:            to,
+		TopCPU:        []Consumer{},
+		TopMemory:     []Consumer{},
+		Namespaces:    []NamespaceUsage{},
+		Idle:          []IdleWorkload{},
+		PVCExhaustion: []PVCForecast{},
+	}
+
+	pods, err := loadPods(sqlite)
+	if err != nil {
+		return nil, err
+	}
+	var keepPVC func(namespace, name string) bool
+	if scope.GroupBy != "" || len(scope.Owners) > 0 {
+		sum.Owners, sum.GroupBy = scope.Owners.String(), scope.GroupBy
+		if keepPVC, err = applyScope(sqlite, pods, scope); err != nil {
+			return nil, err
+		}
+	}
+	cpu, err := duck.QueryBucketedByResource(context.Background(), "pod", "cpu_ms", from, to, hourly, "rate")
+	if err != nil {
+		return nil, err
+	}
+	mem, err := duck.QueryBucketedByResource(context.Background(), "pod", "mem_mb", from, to, hourly, "avg")
+	if err != nil {
+		return nil, err
+	}
+
+	cpuAvg, memAvg := averages(cpu), averages(mem)
+	sum.TopCPU = topConsumers(cpuAvg, pods)
+	sum.TopMemory = topConsumers(memAvg, pods)
+	sum.Namespaces = namespaceUsage(cpu, mem, cpuAvg, memAvg, pods)
+	sum.Idle = idleWorkloads(cpuAvg, memAvg, pods)
+	if scope.GroupBy != "" {
+		sum.Groups = ownerUsage(cpuAvg, memAvg, pods, scope.GroupBy)
+	}
+
+	if sum.PVCExhaustion, err = pvcExhaustion(sqlite, duck, from, to, keepPVC); err != nil {
+		return nil, err
+	}
+	return sum, nil
+}
+
+func loadPods(sqlite *store.SQLiteStore) (map[int64]*podInfo, error) {
+	rows, err := sqlite.Query(`
+		SELECT p.id, p.name, ns.name,
+			CASE WHEN p.deployment_id IS NOT NULL THEN 'deployment'
+			     WHEN p.statefulset_id IS NOT NULL THEN 'statefulset' END,
+			COALESCE(p.deployment_id, p.statefulset_id),
+			COALESCE(d.name, sts.name),
+			(SELECT COALESCE(SUM(cpu_request_m), 0) FROM pod_containers pc WHERE pc.pod_id = p.id)
+		FROM pods p
+		JOIN namespaces ns ON p.namespace_id = ns.id
+		LEFT JOIN deployments d ON p.deployment_id = d.id
+		LEFT JOIN statefulsets sts ON p.statefulset_id = sts.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]*podInfo)
+	for rows.Next() {
+		var id int64
+		var p podInfo
+		var kind, workload sql.NullString
+		var workloadID sql.NullInt64
+		if err := rows.Scan(&id, &p.name, &p.namespace, &kind, &workloadID, &workload, &p.cpuRequestM); err != nil {
+			continue
+		}
+		p.workloadKind, p.workloadID, p.workloadName = kind.String, workloadID.Int64, workload.String
+		out[id] = &p
+	}
+	return out, rows.Err()
+}
+
+// applyScope attaches ownership to pods and drops those outside the
+// scope's owners. The returned func keeps the volumes of a namespace that
+// matches or claimed by a pod that does; it is nil without owners.
+func applyScope(sqlite *store.SQLiteStore, pods map[int64]*podInfo, scope Scope) (func(namespace, name string) bool, error) {
+	owners, err := sqlite.PodOwnership()
+	if err != nil {
+		return nil, err
+	}
+	for id, p := range pods {
+		p.owners = owners[id]
+		if !scope.Owners.Matches(p.owners) {
+			delete(pods, id)
+		}
+	}
+	if len(scope.Owners) == 0 {
+		return nil, nil
+	}
+
+	namespaces, err := sqlite.NamespaceOwnership()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := sqlite.Query(`SELECT pod_id, name FROM pod_claims`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	claimed := map[string]bool{}
+	for rows.Next() {
+		var podID int64
+		var claim string
+		if err := rows.Scan(&podID, &claim); err != nil {
+			return nil, err
+		}
+		if p, ok := pods[podID]; ok {
+			claimed[p.namespace+"/"+claim] = true
+		}
+	}
+	keep := func(namespace, name string) bool {
+		if ns, ok := namespaces[namespace]; ok && scope.Owners.Matches(ns) {
+			return true
+		}
+		return claimed[namespace+"/"+name]
+	}
+	return keep, rows.Err()
+}
+
+// averages is the mean bucket value per resource
+func averages(points []store.MetricPoint) map[int64]float64 {
+	sums := make(map[int64]float64)
+	counts := make(map[int64]int)
+	for _, p := range points {
+		sums[p.ResourceID] += p.Value
+		counts[p.ResourceID]++
+	}
+	for id := range sums {
+		sums[id] /= float64(counts[id])
+	}
+	return sums
+}
+
+func topConsumers(avg map[int64]float64, pods map[int64]*podInfo) []Consumer {
+	out := []Consumer{}
+	for id, v := range avg {
+		if p, ok := pods[id]; ok {
+			out = append(out, Consumer{Namespace: p.namespace, Pod: p.name, Value: v})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Value > out[j].Value })
+	if len(out) > topN {
+		out = out[:topN]
+	}
+	return out
+}
+
+func namespaceUsage(cpu, mem []store.MetricPoint, cpuAvg, memAvg map[int64]float64, pods map[int64]*podInfo) []NamespaceUsage {
+	byNS := map[string]*NamespaceUsage{}
+	get := func(ns string) *NamespaceUsage {
+		if byNS[ns] == nil {
+			byNS[ns] = &NamespaceUsage{Namespace: ns}
+		}
+		return byNS[ns]
+	}
+	for id, v := range cpuAvg {
+		if p, ok := pods[id]; ok {
+			get(p.namespace).CPUMillicores += v
+		}
+	}
+	for id, v := range memAvg {
+		if p, ok := pods[id]; ok {
+			get(p.namespace).MemMB += v
+		}
+	}
+	for ns, trend := range namespaceTrends(cpu, pods) {
+		get(ns).CPUGrowthPerDay = trend.PerDay()
+	}
+	for ns, trend := range namespaceTrends(mem, pods) {
+		get(ns).MemGrowthPerDay = trend.PerDay()
+	}
+
+	out := make([]NamespaceUsage, 0, len(byNS))
+	for _, u := range byNS {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CPUMillicores > out[j].CPUMillicores })
+	return out
+}
+
+// namespaceTrends fits a line through each namespace's per-bucket total
+func namespaceTrends(points []store.MetricPoint, pods map[int64]*podInfo) map[string]analysis.Trend {
+	totals := map[string]map[time.Time]float64{}
+	for _, pt := range points {
+		p, ok := pods[pt.ResourceID]
+		if !ok {
+			continue
+		}
+		if totals[p.namespace] == nil {
+			totals[p.namespace] = map[time.Time]float64{}
+		}
+		totals[p.namespace][pt.Time] += pt.Value
+	}
+
+	out := make(map[string]analysis.Trend, len(totals))
+	for ns, series := range totals {
+		ts := make([]time.Time, 0, len(series))
+		for t := range series {
+			ts = append(ts, t)
+		}
+		sort.Slice(ts, func(i, j int) bool { return ts[i].Before(ts[j]) })
+		vs := make([]float64, len(ts))
+		for i, t := range ts {
+			vs[i] = series[t]
+		}
+		out[ns] = analysis.Fit(ts, vs)
+	}
+	return out
+}
+
+func idleWorkloads(cpuAvg, memAvg map[int64]float64, pods map[int64]*podInfo) []IdleWorkload {
+	type key struct {
+		kind string
+		id   int64
+	}
+	byWorkload := map[key]*IdleWorkload{}
+	for id, cpu := range cpuAvg {
+		p, ok := pods[id]
+		if !ok || p.workloadKind == "" {
+			continue
+		}
+		k := key{p.workloadKind, p.workloadID}
+		w := byWorkload[k]
+		if w == nil {
+			w = &IdleWorkload{Kind: p.workloadKind, Namespace: p.namespace, Name: p.workloadName}
+			byWorkload[k] = w
+		}
+		w.Pods++
+		w.CPUMillicores += cpu
+		w.MemMB += memAvg[id]
+		w.CPURequestM += p.cpuRequestM
+	}
+
+	out := []IdleWorkload{}
+	for _, w := range byWorkload {
+		if w.CPUMillicores < IdleCPUMillicores {
+			out = append(out, *w)
+		}
+	}
+	// Idle workloads holding the most reserved CPU first
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CPURequestM != out[j].CPURequestM {
+			return out[i].CPURequestM > out[j].CPURequestM
+		}
+		return out[i].Namespace+"/"+out[i].Name < out[j].Namespace+"/"+out[j].Name
+	})
+	return out
+}
+
+// ownerUsage totals usage by the key annotation, largest CPU first
+func ownerUsage(cpuAvg, memAvg map[int64]float64, pods map[int64]*podInfo, key string) []OwnerUsage {
+	byOwner := map[string]*OwnerUsage{}
+	for id, p := range pods {
+		cpu, hasCPU := cpuAvg[id]
+		mem, hasMem := memAvg[id]
+		if !hasCPU && !hasMem {
+			continue
+		}
+		owner := p.owners[key]
+		u := byOwner[owner]
+		if u == nil {
+			u = &OwnerUsage{Owner: owner}
+			byOwner[owner] = u
+		}
+		u.Pods++
+		u.CPUMillicores += cpu
+		u.MemMB += mem
+	}
+
+	out := make([]OwnerUsage, 0, len(byOwner))
+	for _, u := range byOwner {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CPUMillicores != out[j].CPUMillicores {
+			return out[i].CPUMillicores > out[j].CPUMillicores
+		}
+		return out[i].Owner < out[j].Owner
+	})
+	return out
+}
+
+// pvcExhaustion forecasts the volumes keep accepts, all when it is nil
+func pvcExhaustion(sqlite *store.SQLiteStore, duck *store.DuckDBStore, from, now time.Time, keep func(namespace, name string) bool) ([]PVCForecast, error) {
+	used, err := duck.QueryBucketedByResource(context.Background(), "pvc", "used_mb", from, now, hourly, "avg")
+	if err != nil {
+		return nil, err
+	}
+	total, err := duck.QueryBucketedByResource(context.Background(), "pvc", "total_mb", from, now, hourly, "last")
+	if err != nil {
+		return nil, err
+	}
+
+	latestTotal := map[int64]float64{}
+	for _, p := range total {
+		latestTotal[p.ResourceID] = p.Value // ordered by bucket
+	}
+	usedSeries := map[int64][]store.MetricPoint{}
+	for _, p := range used {
+		usedSeries[p.ResourceID] = append(usedSeries[p.ResourceID], p)
+	}
+
+	rows, err := sqlite.Query(`SELECT p.id, p.name, ns.name FROM pvcs p JOIN namespaces ns ON p.namespace_id = ns.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []PVCForecast{}
+	for rows.Next() {
+		var id int64
+		var f PVCForecast
+		if err := rows.Scan(&id, &f.Name, &f.Namespace); err != nil {
+			continue
+		}
+		if keep != nil && !keep(f.Namespace, f.Name) {
+			continue
+		}
+		series, capacity := usedSeries[id], latestTotal[id]
+		if len(series) < 2 || capacity <= 0 {
+			continue
+		}
+
+		ts := make([]time.Time, len(series))
+		vs := make([]float64, len(series))
+		for i, p := range series {
+			ts[i], vs[i] = p.Time, p.Value
+		}
+		trend := analysis.Fit(ts, vs)
+		fullAt, ok := trend.Reaches(capacity, now)
+		if !ok || fullAt.Sub(now) > ExhaustionHorizon {
+			continue
+		}
+
+		f.UsedMB = vs[len(vs)-1]
+		f.TotalMB = capacity
+		f.GrowthMBPerDay = trend.PerDay()
+		f.FullAt = fullAt
+		f.DaysUntilFull = fullAt.Sub(now).Hours() / 24
+		out = append(out, f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].FullAt.Before(out[j].FullAt) })
+	return out, rows.Err()
+}
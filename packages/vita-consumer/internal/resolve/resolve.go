@@ -0,0 +1,245 @@
+// Package resolve maps the pod and PVC UIDs agents report to catalog ids
+// through a chain of layers, cheapest first: typically the syncer's
+// in-memory cache, then SQLite, then the Kubernetes API.
+package resolve
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+)
+
+// Layer resolves what it can of a batch of UIDs of one type ("pod" or
+// "pvc"). It sets ids[i] for each uids[i] it knows and leaves the rest 0.
+type Layer interface {
+	Resolve(rType string, uids []string, ids []int64) error
+}
+
+// Filler is a layer that remembers ids found by the layers after it
+type Filler interface {
+	Fill(rType, uid string, id int64)
+}
+
+// maxMisses bounds the remembered misses; beyond it expired ones are
+// dropped, and if none are, all of them
+const maxMisses = 50000
+
+// LayerStats counts one layer's work. The first layer is asked per
+// metric, later ones per distinct UID.
+type LayerStats struct {
+	Name    string `json:"name"`
+	Lookups uint64 `json:"lookups"`
+	Hits    uint64 `json:"hits"`
+	Errors  uint64 `json:"errors"`
+}
+
+// Stats reports every layer and the UIDs no layer resolved
+type Stats struct {
+	Layers []LayerStats `json:"layers"`
+	// Unresolved counts distinct UIDs per batch that every layer missed;
+	// SkippedMisses those not looked up again within the miss TTL
+	Unresolved    uint64 `json:"unresolved"`
+	SkippedMisses uint64 `json:"skipped_misses"`
+}
+
+type layer struct {
+	name  string
+	impl  Layer
+	stats struct{ lookups, hits, errors atomic.Uint64 }
+
+	logMu   sync.Mutex
+	lastLog time.Time
+}
+
+// Composite resolves through its layers in the order they were added. It
+// satisfies ingest.IDResolver.
+type Composite struct {
+	layers  []*layer
+	missTTL time.Duration
+	clock   clock.Clock
+
+	mu            sync.Mutex
+	misses        map[string]time.Time // rType + "/" + uid -> expiry
+	unresolved    atomic.Uint64
+	skippedMisses atomic.Uint64
+}
+
+func New() *Composite {
+	return &Composite{clock: clock.Real, misses: make(map[string]time.Time)}
+}
+
+// AddLayer appends a layer, consulted after all those added before
+func (c *Composite) AddLayer(name string, l Layer) {
+	c.layers = append(c.layers, &layer{name: name, impl: l})
+}
+
+// SetMissTTL skips UIDs that every layer missed for d, rather than asking
+// the layers after the first one again on every batch. 0 disables it.
+func (c *Composite) SetMissTTL(d time.Duration) {
+	c.missTTL = d
+}
+
+// SetClock replaces the clock miss expiry is measured against
+func (c *Composite) SetClock(clk clock.Clock) {
+	c.clock = clk
+}
+
+func (c *Composite) GetResourceID(uid, rType string) (int64, bool) {
+	id := c.GetResourceIDs(rType, []string{uid})[0]
+	return id, id != 0
+}
+
+// GetResourceIDs resolves a batch of UIDs of the same type. The result is
+// positionally aligned with uids; unresolved entries are 0.
+func (c *Composite) GetResourceIDs(rType string, uids []string) []int64 {
+	ids := make([]int64, len(uids))
+	if len(c.layers) == 0 {
+		return ids
+	}
+
+	// The first layer takes the whole batch, so the common all-hit case
+	// costs no more than a plain cache lookup
+	first := c.layers[0]
+	first.lookup(rType, uids, ids)
+
+	// Later layers see each distinct remaining UID once
+	var pending []string
+	var positions map[string][]int
+	for i, uid := range uids {
+		if uid == "" || ids[i] != 0 {
+			continue
+		}
+		if positions == nil {
+			positions = make(map[string][]int)
+		}
+		if _, seen := positions[uid]; !seen {
+			pending = append(pending, uid)
+		}
+		positions[uid] = append(positions[uid], i)
+	}
+	if len(pending) == 0 {
+		return ids
+	}
+	pending = c.dropRecentMisses(rType, pending)
+
+	var fillers []Filler
+	if f, ok := first.impl.(Filler); ok {
+		fillers = append(fillers, f)
+	}
+	for _, l := range c.layers[1:] {
+		if len(pending) == 0 {
+			break
+		}
+		found := make([]int64, len(pending))
+		l.lookup(rType, pending, found)
+
+		rest := pending[:0:0]
+		for i, uid := range pending {
+			if found[i] == 0 {
+				rest = append(rest, uid)
+				continue
+			}
+			for _, pos := range positions[uid] {
+				ids[pos] = found[i]
+			}
+			for _, f := range fillers {
+				f.Fill(rType, uid, found[i])
+			}
+		}
+		pending = rest
+		if f, ok := l.impl.(Filler); ok {
+			fillers = append(fillers, f)
+		}
+	}
+
+	c.unresolved.Add(uint64(len(pending)))
+	c.rememberMisses(rType, pending)
+	return ids
+}
+
+func (l *layer) lookup(rType string, uids []string, ids []int64) {
+	l.stats.lookups.Add(uint64(len(uids)))
+	err := l.impl.Resolve(rType, uids, ids)
+	hits := 0
+	for _, id := range ids {
+		if id != 0 {
+			hits++
+		}
+	}
+	l.stats.hits.Add(uint64(hits))
+	if err == nil {
+		return
+	}
+
+	l.stats.errors.Add(1)
+	// A failing store would otherwise log on every ingest batch
+	l.logMu.Lock()
+	defer l.logMu.Unlock()
+	if time.Since(l.lastLog) >= time.Minute {
+		l.lastLog = time.Now()
+		log.Printf("ID resolution through %s failed: %v", l.name, err)
+	}
+}
+
+func (c *Composite) dropRecentMisses(rType string, uids []string) []string {
+	if c.missTTL <= 0 {
+		return uids
+	}
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := uids[:0:0]
+	for _, uid := range uids {
+		if exp, ok := c.misses[rType+"/"+uid]; ok && now.Before(exp) {
+			c.skippedMisses.Add(1)
+			continue
+		}
+		out = append(out, uid)
+	}
+	return out
+}
+
+func (c *Composite) rememberMisses(rType string, uids []string) {
+	if c.missTTL <= 0 || len(uids) == 0 {
+		return
+	}
+	now := c.clock.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.misses)+len(uids) > maxMisses {
+		for k, exp := range c.misses {
+			if !now.Before(exp) {
+				delete(c.misses, k)
+			}
+		}
+		if len(c.misses)+len(uids) > maxMisses {
+			c.misses = make(map[string]time.Time)
+		}
+	}
+	for _, uid := range uids {
+		c.misses[rType+"/"+uid] = now.Add(c.missTTL)
+	}
+}
+
+// Stats returns the counters accumulated since the resolver was built
+func (c *Composite) Stats() Stats {
+	st := Stats{
+		Layers:        make([]LayerStats, len(c.layers)),
+		Unresolved:    c.unresolved.Load(),
+		SkippedMisses: c.skippedMisses.Load(),
+	}
+	for i, l := range c.layers {
+		st.Layers[i] = LayerStats{
+			Name:    l.name,
+			Lookups: l.stats.lookups.Load(),
+			Hits:    l.stats.hits.Load(),
+			Errors:  l.stats.errors.Load(),
+		}
+	}
+	return st
+}
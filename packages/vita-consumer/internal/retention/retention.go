@@ -0,0 +1,198 @@
+// Package retention keeps metric points for as long, and as finely, as
+// their policy says: a cluster-wide default, replaced for the pods of a
+// namespace or workload annotated with vitakube.io/retention. Points older
+// than the retention are deleted; points past the raw window are thinned
+// to one per resolution step, so critical services can keep 10s points for
+// 30 days while batch jobs keep 5m ones.
+package retention
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// Annotation holds a namespace's or workload's policy, e.g.
+// "resolution=10s,retention=30d"
+const Annotation = "vitakube.io/retention"
+
+var (
+	expDeleted = expvar.NewInt("retention_deleted_points")
+	expThinned = expvar.NewInt("retention_thinned_points")
+)
+
+// Policy is how long points are kept and how far apart once they are past
+// the raw window
+type Policy struct {
+	Resolution time.Duration `json:"resolution"` // 0 keeps every point
+	Retention  time.Duration `json:"retention"`  // 0 keeps points forever
+}
+
+// ParsePolicy reads "resolution=5m,retention=30d" over base: keys left out
+// keep base's values. "raw" and "forever" lift the resolution and
+// retention limits.
+func ParsePolicy(spec string, base Policy) (Policy, error) {
+	p := base
+	for _, part := range strings.Split(spec, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok {
+			return base, fmt.Errorf("invalid retention policy %q, want key=value", part)
+		}
+		switch k {
+		case "resolution":
+			if v == "raw" {
+				p.Resolution = 0
+				continue
+			}
+			d, err := parseDuration(v)
+			if err != nil {
+				return base, err
+			}
+			if d < time.Second {
+				return base, fmt.Errorf("resolution %s is below 1s", v)
+			}
+			p.Resolution = d
+		case "retention":
+			if v == "forever" {
+				p.Retention = 0
+				continue
+			}
+			d, err := parseDuration(v)
+			if err != nil {
+				return base, err
+			}
+			if d < time.Hour {
+				return base, fmt.Errorf("retention %s is below 1h", v)
+			}
+			p.Retention = d
+		default:
+			return base, fmt.Errorf("unknown retention policy key %q", k)
+		}
+	}
+	return p, nil
+}
+
+// parseDuration accepts Go durations and whole days ("30d")
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// Config controls enforcement. Zero values take the defaults.
+type Config struct {
+	Default Policy        // of resources without an annotation; zero keeps everything
+	Raw     time.Duration // how long points stay unthinned, default 24 hours
+}
+
+// Result is what one run removed
+type Result struct {
+	Deleted   int64 `json:"deleted"`
+	Thinned   int64 `json:"thinned"`
+	Overrides int   `json:"overrides"` // pods under an annotated policy
+}
+
+// Enforcer applies retention policies to the stored points
+type Enforcer struct {
+	sqlite *store.SQLiteStore
+	duck   *store.DuckDBStore
+	cfg    Config
+}
+
+func NewEnforcer(sqlite *store.SQLiteStore, duck *store.DuckDBStore, cfg Config) *Enforcer {
+	if cfg.Raw <= 0 {
+		cfg.Raw = 24 * time.Hour
+	}
+	return &Enforcer{
+		sqlite: sqlite,
+		duck:   duck,
+		cfg:    cfg,
+	}
+}
+
+// RunOnce enforces the policies as of now, as a scheduled job
+func (e *Enforcer) RunOnce(ctx context.Context, now time.Time) error {
+	res, err := e.Enforce(now)
+	if res.Deleted > 0 || res.Thinned > 0 {
+		log.Printf("Retention deleted %d and thinned %d points (%d pods under annotated policies)", res.Deleted, res.Thinned, res.Overrides)
+	}
+	return err
+}
+
+// Enforce deletes and thins points as of now. Pods of annotated
+// namespaces and workloads follow their policy; everything else,
+// including nodes and claims, follows the default.
+func (e *Enforcer) Enforce(now time.Time) (Result, error) {
+	var res Result
+	specs, err := e.sqlite.PodRetention()
+	if err != nil {
+		return res, err
+	}
+	groups := make(map[Policy][]int64)
+	var overridden []int64
+	for id, spec := range specs {
+		p, err := ParsePolicy(spec, e.cfg.Default)
+		if err != nil || p == e.cfg.Default {
+			continue // invalid annotations are logged and cleared by the syncer
+		}
+		groups[p] = append(groups[p], id)
+		overridden = append(overridden, id)
+	}
+	res.Overrides = len(overridden)
+
+	apply := func(p Policy, set store.ResourceSet) error {
+		if p.Retention > 0 {
+			n, err := e.duck.DeleteSeriesBefore(set, now.Add(-p.Retention))
+			res.Deleted += n
+			expDeleted.Add(n)
+			if err != nil {
+				return err
+			}
+		}
+		if p.Resolution > 0 {
+			n, err := e.duck.ThinPoints(set, p.Resolution, now.Add(-e.cfg.Raw))
+			res.Thinned += n
+			expThinned.Add(n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := apply(e.cfg.Default, store.ResourceSet{IDs: overridden, Except: true}); err != nil {
+		return res, err
+	}
+	if e.cfg.Default.Retention > 0 {
+		if _, err := e.duck.DeleteNodeTotalsBefore(now.Add(-e.cfg.Default.Retention)); err != nil {
+			return res, err
+		}
+	}
+	for p, ids := range groups {
+		if err := apply(p, store.ResourceSet{IDs: ids}); err != nil {
+			return res, err
+		}
+	}
+	if res.Deleted > 0 || res.Thinned > 0 {
+		return res, e.duck.Checkpoint()
+	}
+	return res, nil
+}
@@ -0,0 +1,130 @@
+package retention_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/retention"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// A statefulset annotated with a finer, longer policy keeps its old
+// points, a namespace's annotation only changes the resolution, everything
+// else follows the default, invalid annotations are ignored, and a second
+// run removes nothing more. A deployment's series sharing an annotated
+// pod's ID follows the default too.
+func TestRetention(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "batch", Annotations: map[string]string{
+			retention.Annotation: "resolution=5m",
+		}}}
+		if _, err := env.Client.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		vault := synctest.StatefulSet("vault", "vault", 1)
+		vault.Annotations = map[string]string{retention.Annotation: "resolution=10s,retention=30d"}
+		broken := synctest.StatefulSet("vault", "vault-audit", 1)
+		broken.Annotations = map[string]string{retention.Annotation: "resolution=fast"}
+		for _, sts := range []*appsv1.StatefulSet{vault, broken} {
+			if _, err := env.Client.AppsV1().StatefulSets("vault").Create(ctx, sts, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		// Pods link to statefulsets already in the catalog
+		if err := env.Eventually(synctest.Timeout, "statefulsets synced", func() (bool, error) {
+			n, err := env.QueryInt(`SELECT count(*) FROM statefulsets WHERE
+			(name = 'vault' AND retention = 'resolution=10s,retention=30d') OR (name = 'vault-audit' AND retention IS NULL)`)
+			return n == 2, err
+		}); err != nil {
+			return err
+		}
+		for _, pod := range []*corev1.Pod{
+			synctest.StatefulPod(vault, 0, "node-a"),
+			synctest.Pod("batch", "job-1", "node-a", nil),
+			synctest.Pod("misc", "misc-1", "node-a", nil),
+		} {
+			if _, err := env.Client.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		if err := env.Eventually(synctest.Timeout, "pods synced", func() (bool, error) {
+			specs, err := env.SQLite.PodRetention()
+			if err != nil {
+				return false, err
+			}
+			n, err := env.QueryInt("SELECT count(*) FROM pods WHERE name IN ('vault-0', 'job-1', 'misc-1')")
+			return n == 3 && len(specs) == 2, err
+		}); err != nil {
+			return err
+		}
+
+		// Ten minutes of 10s points ten days, three days and an hour ago
+		now := env.Clock.Now()
+		ids := make(map[string]int64)
+		var points []store.MetricPoint
+		for _, name := range []string{"vault-0", "job-1", "misc-1"} {
+			id, err := env.QueryInt("SELECT id FROM pods WHERE name = ?", name)
+			if err != nil {
+				return err
+			}
+			ids[name] = id
+			for _, ago := range []time.Duration{10 * 24 * time.Hour, 3 * 24 * time.Hour, time.Hour} {
+				start := now.Add(-ago).Truncate(time.Hour)
+				for i := 0; i < 60; i++ {
+					points = append(points, store.MetricPoint{Time: start.Add(time.Duration(i) * 10 * time.Second), ResourceID: id, ResourceKind: "pod", MetricType: "mem_mb", Value: float64(i)})
+				}
+			}
+		}
+		for _, ago := range []time.Duration{10 * 24 * time.Hour, time.Hour} {
+			start := now.Add(-ago).Truncate(time.Hour)
+			for i := 0; i < 60; i++ {
+				points = append(points, store.MetricPoint{Time: start.Add(time.Duration(i) * 10 * time.Second), ResourceID: ids["vault-0"], ResourceKind: "deployment", MetricType: "replicas_ready", Value: 1})
+			}
+		}
+		if err := env.Duck.BatchInsert(points); err != nil {
+			return err
+		}
+
+		enforcer := retention.NewEnforcer(env.SQLite, env.Duck, retention.Config{
+			Default: retention.Policy{Resolution: time.Minute, Retention: 7 * 24 * time.Hour},
+		})
+		// vault-0 keeps everything; job-1 loses ten days ago and keeps one point
+		// per 5 minutes three days ago; misc-1 one per minute
+		want := map[string]int64{"vault-0": 180, "job-1": 60 + 2, "misc-1": 60 + 10}
+		for run := 1; run <= 2; run++ {
+			res, err := enforcer.Enforce(now)
+			if err != nil {
+				return err
+			}
+			if run == 1 && (res.Deleted != 120+60 || res.Thinned != 58+50 || res.Overrides != 2) {
+				return fmt.Errorf("first run = %+v", res)
+			}
+			if run == 2 && (res.Deleted != 0 || res.Thinned != 0) {
+				return fmt.Errorf("second run = %+v", res)
+			}
+			for name, n := range want {
+				got, err := env.Duck.CountSeries(ctx, ids[name], "mem_mb", now.Add(-30*24*time.Hour), now)
+				if err != nil {
+					return err
+				}
+				if got != n {
+					return fmt.Errorf("run %d: %s kept %d points, want %d", run, name, got, n)
+				}
+			}
+			got, err := env.Duck.CountSeries(ctx, ids["vault-0"], "replicas_ready", now.Add(-30*24*time.Hour), now)
+			if err != nil {
+				return err
+			}
+			if got != 60 {
+				return fmt.Errorf("run %d: deployment sharing vault-0's ID kept %d points, want 60", run, got)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,248 @@
+package rollup
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/syncer"
+)
+
+// liveStale is how long a pod keeps counting towards its deployment after
+// its last sample
+const liveStale = 10 * time.Second
+
+// DeploymentTotal is the current usage of all live pods of a deployment
+type DeploymentTotal struct {
+	DeploymentID  int64     `json:"deployment_id"`
+	CPUMillicores float64   `json:"cpu_millicores"`
+	MemMB         float64   `json:"mem_mb"`
+	Pods          int       `json:"pods"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// DeploymentLive keeps running per-deployment CPU and memory totals as
+// samples reach the ring buffer. Each sample adjusts its deployment's total
+// by the difference it makes to its pod's value, so reads are a map copy.
+type DeploymentLive struct {
+	sqlite *store.SQLiteStore
+	clock  clock.Clock
+
+	mu     sync.Mutex
+	owners map[int64]int64 // pod -> deployment (pods without one are absent)
+	pods   map[int64]*livePod
+	totals map[int64]*DeploymentTotal
+	swept  time.Time
+}
+
+type livePod struct {
+	deployment    int64
+	cpu           buffer.Metric // last cpu_ms sample
+	cpuMillicores float64
+	memMB         float64
+	seen          time.Time
+}
+
+func NewDeploymentLive(sqlite *store.SQLiteStore) *DeploymentLive {
+	return &DeploymentLive{
+		sqlite: sqlite,
+		clock:  clock.Real,
+		owners: make(map[int64]int64),
+		pods:   make(map[int64]*livePod),
+		totals: make(map[int64]*DeploymentTotal),
+	}
+}
+
+// SetClock replaces the clock staleness is measured against. Must be called
+// before Run.
+func (d *DeploymentLive) SetClock(c clock.Clock) {
+	d.clock = c
+}
+
+// Run loads pod ownership and follows pod changes from the syncer
+func (d *DeploymentLive) Run(events <-chan syncer.Event) {
+	if err := d.loadOwners(); err != nil {
+		log.Printf("Deployment totals: failed to load pod owners: %v", err)
+	}
+	for ev := range events {
+		if ev.Kind != "pod" {
+			continue
+		}
+		if ev.Type == syncer.EventDeleted {
+			d.setOwner(ev.ID, 0)
+			continue
+		}
+		dep, err := d.podOwner(ev.ID)
+		if err != nil {
+			log.Printf("Deployment totals: failed to load owner of pod %d: %v", ev.ID, err)
+			continue
+		}
+		d.setOwner(ev.ID, dep)
+	}
+}
+
+func (d *DeploymentLive) podOwner(pod int64) (int64, error) {
+	rows, err := d.sqlite.Query("SELECT deployment_id FROM pods WHERE id = ?", pod)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var dep sql.NullInt64
+	if rows.Next() {
+		if err := rows.Scan(&dep); err != nil {
+			return 0, err
+		}
+	}
+	return dep.Int64, rows.Err()
+}
+
+func (d *DeploymentLive) loadOwners() error {
+	rows, err := d.sqlite.Query("SELECT id, deployment_id FROM pods WHERE deployment_id IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	owners := make(map[int64]int64)
+	for rows.Next() {
+		var pod, dep int64
+		if err := rows.Scan(&pod, &dep); err != nil {
+			continue
+		}
+		owners[pod] = dep
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for pod, dep := range owners {
+		d.moveLocked(pod, dep)
+	}
+	return nil
+}
+
+// setOwner records a pod's deployment (0 for none) and moves its current
+// contribution over
+func (d *DeploymentLive) setOwner(pod, dep int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.moveLocked(pod, dep)
+}
+
+func (d *DeploymentLive) moveLocked(pod, dep int64) {
+	if dep == 0 {
+		delete(d.owners, pod)
+	} else {
+		d.owners[pod] = dep
+	}
+	p, ok := d.pods[pod]
+	if !ok || p.deployment == dep {
+		return
+	}
+	d.applyLocked(p, -p.cpuMillicores, -p.memMB, -1)
+	p.deployment = dep
+	d.applyLocked(p, p.cpuMillicores, p.memMB, 1)
+}
+
+// applyLocked adds deltas to the pod's deployment total
+func (d *DeploymentLive) applyLocked(p *livePod, cpu, mem float64, pods int) {
+	if p.deployment == 0 {
+		return
+	}
+	t := d.totals[p.deployment]
+	if t == nil {
+		t = &DeploymentTotal{DeploymentID: p.deployment}
+		d.totals[p.deployment] = t
+	}
+	t.CPUMillicores += cpu
+	t.MemMB += mem
+	t.Pods += pods
+	if p.seen.After(t.UpdatedAt) {
+		t.UpdatedAt = p.seen
+	}
+	if t.Pods <= 0 {
+		delete(d.totals, p.deployment) // also discards accumulated rounding
+	}
+}
+
+// Observe implements buffer.Observer
+func (d *DeploymentLive) Observe(ms []buffer.Metric) {
+	now := d.clock.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Without readers, stale pods would otherwise pile up
+	if now.Sub(d.swept) > liveStale {
+		d.expireLocked(now)
+	}
+
+	for _, m := range ms {
+		if m.ResourceID <= 0 || (m.Type != "cpu_ms" && m.Type != "mem_mb") {
+			continue
+		}
+		p := d.pods[m.ResourceID]
+		if p == nil {
+			p = &livePod{deployment: d.owners[m.ResourceID]}
+			d.pods[m.ResourceID] = p
+			d.applyLocked(p, 0, 0, 1)
+		}
+		if m.Time.After(p.seen) {
+			p.seen = m.Time
+		}
+
+		switch m.Type {
+		case "cpu_ms":
+			// cpu_ms is cumulative; usage is the rate between samples
+			prev := p.cpu
+			if !m.Time.After(prev.Time) {
+				continue // out of order
+			}
+			p.cpu = m
+			dt := m.Time.Sub(prev.Time).Seconds()
+			if prev.Time.IsZero() || m.Value < prev.Value {
+				continue // first sample, or counter reset on restart
+			}
+			rate := (m.Value - prev.Value) / dt
+			d.applyLocked(p, rate-p.cpuMillicores, 0, 0)
+			p.cpuMillicores = rate
+		case "mem_mb":
+			d.applyLocked(p, 0, m.Value-p.memMB, 0)
+			p.memMB = m.Value
+		}
+	}
+}
+
+// Totals returns the current totals of deployments with live pods. Pods
+// silent for longer than liveStale are dropped first.
+func (d *DeploymentLive) Totals() []DeploymentTotal {
+	now := d.clock.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.expireLocked(now)
+	out := make([]DeploymentTotal, 0, len(d.totals))
+	for _, t := range d.totals {
+		out = append(out, *t)
+	}
+	return out
+}
+
+func (d *DeploymentLive) expireLocked(now time.Time) {
+	cutoff := now.Add(-liveStale)
+	for id, p := range d.pods {
+		if p.seen.Before(cutoff) {
+			d.applyLocked(p, -p.cpuMillicores, -p.memMB, -1)
+			delete(d.pods, id)
+		}
+	}
+	d.swept = now
+}
@@ -0,0 +1,175 @@
+// Package rollup precomputes aggregate series while metrics are flushed,
+// so overview pages do not have to aggregate raw pod points on demand.
+package rollup
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// Series written to node_totals
+const (
+	CPUMillicores = "cpu_millicores"
+	MemMB         = "mem_mb"
+	Pods          = "pods"
+)
+
+// ClusterID is the node_id used for cluster-wide totals
+const ClusterID = 0
+
+// NodeTotals sums pod CPU and memory per node and cluster-wide into
+// one-minute buckets. Samples of the still-open minute are held back until
+// the next flush so every bucket is computed from complete data.
+type NodeTotals struct {
+	sqlite  *store.SQLiteStore
+	pending []buffer.Metric
+	done    time.Time // buckets before this have been emitted
+	simple  bool
+}
+
+func NewNodeTotals(sqlite *store.SQLiteStore) *NodeTotals {
+	return &NodeTotals{sqlite: sqlite}
+}
+
+// SetSimple trades accuracy for memory: each flushed batch becomes one
+// bucket at the flush minute, averaging whatever samples it holds, instead
+// of holding back the open minute until it is complete
+func (n *NodeTotals) SetSimple() {
+	n.simple = true
+}
+
+// Add consumes a flushed batch and returns totals for completed minutes
+func (n *NodeTotals) Add(batch []buffer.Metric, now time.Time) []store.NodeTotal {
+	boundary := now.Truncate(time.Minute)
+	if n.simple {
+		return n.addSimple(batch, boundary)
+	}
+
+	var ready []buffer.Metric
+	keep := n.pending[:0]
+	for _, src := range [][]buffer.Metric{n.pending, batch} {
+		for _, m := range src {
+			if m.Type != "cpu_ms" && m.Type != "mem_mb" {
+				continue
+			}
+			if m.Time.Before(n.done) {
+				continue // late sample for an emitted bucket
+			}
+			if m.Time.Before(boundary) {
+				ready = append(ready, m)
+			} else {
+				keep = append(keep, m)
+			}
+		}
+	}
+	n.pending = keep
+	if len(ready) == 0 {
+		return nil
+	}
+	n.done = boundary
+
+	podNodes, err := n.podNodes()
+	if err != nil {
+		log.Printf("Node rollup: failed to load pod placement: %v", err)
+	}
+	return totals(ready, podNodes, func(t time.Time) time.Time { return t.Truncate(time.Minute) })
+}
+
+func (n *NodeTotals) addSimple(batch []buffer.Metric, bucket time.Time) []store.NodeTotal {
+	var ready []buffer.Metric
+	for _, m := range batch {
+		if m.Type == "cpu_ms" || m.Type == "mem_mb" {
+			ready = append(ready, m)
+		}
+	}
+	if len(ready) == 0 {
+		return nil
+	}
+	podNodes, err := n.podNodes()
+	if err != nil {
+		log.Printf("Node rollup: failed to load pod placement: %v", err)
+	}
+	return totals(ready, podNodes, func(time.Time) time.Time { return bucket })
+}
+
+func (n *NodeTotals) podNodes() (map[int64]int64, error) {
+	rows, err := n.sqlite.Query("SELECT id, node_id FROM pods")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]int64)
+	for rows.Next() {
+		var pod, node int64
+		if err := rows.Scan(&pod, &node); err != nil {
+			continue
+		}
+		out[pod] = node
+	}
+	return out, rows.Err()
+}
+
+// totals computes per-pod values for each bucket, then sums them by node.
+// Pods no longer in the catalog only count towards the cluster.
+func totals(samples []buffer.Metric, podNodes map[int64]int64, bucketOf func(time.Time) time.Time) []store.NodeTotal {
+	type podKey struct {
+		bucket time.Time
+		pod    int64
+	}
+	cpu := map[podKey][]buffer.Metric{}
+	mem := map[podKey][]float64{}
+	for _, m := range samples {
+		k := podKey{bucketOf(m.Time), m.ResourceID}
+		if m.Type == "cpu_ms" {
+			cpu[k] = append(cpu[k], m)
+		} else {
+			mem[k] = append(mem[k], m.Value)
+		}
+	}
+
+	type nodeKey struct {
+		bucket time.Time
+		node   int64
+		typ    string
+	}
+	sums := map[nodeKey]float64{}
+	add := func(k podKey, typ string, v float64) {
+		sums[nodeKey{k.bucket, ClusterID, typ}] += v
+		if node, ok := podNodes[k.pod]; ok {
+			sums[nodeKey{k.bucket, node, typ}] += v
+		}
+	}
+
+	seen := map[podKey]bool{}
+	for k, ms := range cpu {
+		seen[k] = true
+		sort.Slice(ms, func(i, j int) bool { return ms[i].Time.Before(ms[j].Time) })
+		first, last := ms[0], ms[len(ms)-1]
+		dt := last.Time.Sub(first.Time).Seconds()
+		if dv := last.Value - first.Value; dt > 0 && dv >= 0 {
+			add(k, CPUMillicores, dv/dt) // ms per second
+		}
+	}
+	for k, vs := range mem {
+		seen[k] = true
+		var sum float64
+		for _, v := range vs {
+			sum += v
+		}
+		add(k, MemMB, sum/float64(len(vs)))
+	}
+	for k := range seen {
+		add(k, Pods, 1)
+	}
+
+	out := make([]store.NodeTotal, 0, len(sums))
+	for k, v := range sums {
+		out = append(out, store.NodeTotal{Time: k.bucket, NodeID: k.node, MetricType: k.typ, Value: v})
+	}
+	return out
+}
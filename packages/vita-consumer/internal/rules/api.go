@@ -0,0 +1,139 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/units"
+)
+
+func (e *Engine) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/rules", e.handleRules)
+}
+
+// ruleRequest accepts either name + expr or a combined "name = expr" rule
+type ruleRequest struct {
+	Rule        string `json:"rule"`
+	Name        string `json:"name"`
+	Expr        string `json:"expr"`
+	IntervalSec int    `json:"interval_sec"`
+	Enabled     *bool  `json:"enabled"`
+}
+
+// handleRules lists (GET), creates or replaces by name (POST) and deletes
+// (DELETE ?id=) recording rules. Output is queryable through
+// /api/v1/metrics/series with type=<rule name> and resource=<group id>
+// (namespace, node or workload ID; 0 for cluster-wide rules).
+func (e *Engine) handleRules(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := e.sqlite.ListRecordingRules()
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, rules)
+
+	case http.MethodPost:
+		var req ruleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if req.Rule != "" {
+			var err error
+			if req.Name, req.Expr, err = ParseDefinition(req.Rule); err != nil {
+				writeError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := ValidateName(req.Name); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		expr, err := ParseExpr(req.Expr)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.IntervalSec == 0 {
+			req.IntervalSec = 60
+		}
+		if req.IntervalSec < 10 {
+			writeError(w, "interval_sec must be at least 10", http.StatusBadRequest)
+			return
+		}
+		if !e.checkUnmanaged(w, req.Name) {
+			return
+		}
+
+		rule := store.RecordingRule{Name: req.Name, Expr: expr.String(), IntervalSec: req.IntervalSec, Enabled: req.Enabled == nil || *req.Enabled}
+		id, err := e.sqlite.UpsertRecordingRule(rule)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		registerUnits(rule.Name, expr)
+
+		saved, err := e.sqlite.GetRecordingRule(id)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, saved)
+
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			writeError(w, "id parameter is required", http.StatusBadRequest)
+			return
+		}
+		rule, err := e.sqlite.GetRecordingRule(id)
+		if err != nil {
+			writeError(w, "rule not found", http.StatusNotFound)
+			return
+		}
+		if !e.checkUnmanaged(w, rule.Name) {
+			return
+		}
+		if err := e.sqlite.DeleteRecordingRule(id); err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Points already written stay in DuckDB until retention removes them
+		units.Unregister(rule.Name)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// checkUnmanaged refuses changes to a rule synced from a VitaRule, which
+// would be undone by the next sync
+func (e *Engine) checkUnmanaged(w http.ResponseWriter, name string) bool {
+	ref, err := e.sqlite.ManagedBy("rule", name)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if ref != "" {
+		writeError(w, fmt.Sprintf("rule %q is managed by %s", name, ref), http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,201 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// evalDelay leaves time for the ring buffer to flush before a window
+	// is evaluated
+	evalDelay = 2 * time.Minute
+	// maxCatchUp bounds how much history one evaluation processes, both for
+	// new rules and after downtime
+	maxCatchUp = 6 * time.Hour
+	// firstWindow is backfilled when a rule is created
+	firstWindow = time.Hour
+)
+
+var tracer = otel.Tracer("github.com/nchanged/vitakube/packages/vita-consumer/internal/rules")
+
+// Engine evaluates enabled rules and writes their output to DuckDB
+type Engine struct {
+	sqlite *store.SQLiteStore
+	duck   *store.DuckDBStore
+}
+
+func NewEngine(sqlite *store.SQLiteStore, duck *store.DuckDBStore) *Engine {
+	return &Engine{sqlite: sqlite, duck: duck}
+}
+
+// EvaluateDue registers existing rules with the units registry and
+// evaluates those due, as a scheduled job
+func (e *Engine) EvaluateDue(ctx context.Context, now time.Time) error {
+	rules, err := e.sqlite.ListRecordingRules()
+	if err != nil {
+		return fmt.Errorf("load recording rules: %w", err)
+	}
+
+	for _, r := range rules {
+		expr, err := ParseExpr(r.Expr)
+		if err != nil {
+			continue // rejected on write; only possible after a schema change
+		}
+		registerUnits(r.Name, expr)
+		if !r.Enabled {
+			continue
+		}
+
+		through, n, err := e.Evaluate(r, expr, now)
+		if through.IsZero() {
+			continue // nothing due
+		}
+		if err != nil {
+			log.Printf("Recording rule %q failed: %v", r.Name, err)
+		} else if n > 0 {
+			log.Printf("Recording rule %q wrote %d points", r.Name, n)
+		}
+		if err := e.sqlite.MarkRuleEvaluated(r.ID, through, err); err != nil {
+			log.Printf("Failed to record evaluation of %q: %v", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// Evaluate computes all complete intervals since the rule's last
+// evaluation. It returns the end of the evaluated window (zero when nothing
+// is due) and the number of points written.
+func (e *Engine) Evaluate(r store.RecordingRule, expr Expr, now time.Time) (time.Time, int, error) {
+	ctx, span := tracer.Start(context.Background(), "rules.evaluate", trace.WithAttributes(attribute.String("rule", r.Name)))
+	defer span.End()
+
+	through, n, err := e.evaluate(ctx, r, expr, now)
+	span.SetAttributes(attribute.Int("points", n))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return through, n, err
+}
+
+func (e *Engine) evaluate(ctx context.Context, r store.RecordingRule, expr Expr, now time.Time) (time.Time, int, error) {
+	step := int64(r.IntervalSec)
+	if step <= 0 {
+		step = 60
+	}
+	end := time.Unix(now.Add(-evalDelay).Unix()/step*step, 0)
+
+	start := end.Add(-firstWindow)
+	if r.LastEvalAt != nil {
+		start = *r.LastEvalAt
+	}
+	if end.Sub(start) > maxCatchUp {
+		start = end.Add(-maxCatchUp)
+	}
+	if !end.After(start) {
+		return time.Time{}, 0, nil
+	}
+
+	srcAgg := "avg"
+	if expr.Rate {
+		srcAgg = "rate"
+	}
+	points, err := e.duck.QueryBucketedByResource(ctx, kinds[sources[expr.Metric]], expr.Metric, start, end, store.Bucketing{Step: time.Duration(step) * time.Second}, srcAgg)
+	if err != nil {
+		return end, 0, err
+	}
+
+	groups, err := e.groups(expr)
+	if err != nil {
+		return end, 0, err
+	}
+
+	type key struct {
+		t     time.Time
+		group int64
+	}
+	acc := map[key]*aggregate{}
+	for _, p := range points {
+		g := int64(0)
+		if groups != nil {
+			var ok bool
+			if g, ok = groups[p.ResourceID]; !ok {
+				continue // resource deleted, or not part of any group
+			}
+		}
+		k := key{p.Time, g}
+		if acc[k] == nil {
+			acc[k] = newAggregate()
+		}
+		acc[k].add(p.Value)
+	}
+
+	out := make([]store.MetricPoint, 0, len(acc))
+	for k, a := range acc {
+		out = append(out, store.MetricPoint{Time: k.t, ResourceID: k.group, ResourceKind: expr.Kind(), MetricType: r.Name, Value: a.result(expr.Agg)})
+	}
+	return end, len(out), e.duck.BatchInsert(out)
+}
+
+// groups maps source resource IDs to the ID of their group (namespace,
+// node, workload). It returns nil for cluster-wide rules.
+func (e *Engine) groups(expr Expr) (map[int64]int64, error) {
+	if expr.By == "" {
+		return nil, nil
+	}
+
+	// By and the table are validated by ParseExpr
+	rows, err := e.sqlite.Query(fmt.Sprintf("SELECT id, %s_id FROM %s WHERE %s_id IS NOT NULL", expr.By, sources[expr.Metric], expr.By))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]int64)
+	for rows.Next() {
+		var id, group int64
+		if err := rows.Scan(&id, &group); err != nil {
+			continue
+		}
+		out[id] = group
+	}
+	return out, rows.Err()
+}
+
+type aggregate struct {
+	sum, min, max float64
+	n             int
+}
+
+func newAggregate() *aggregate {
+	return &aggregate{min: math.Inf(1), max: math.Inf(-1)}
+}
+
+func (a *aggregate) add(v float64) {
+	a.sum += v
+	a.n++
+	a.min = math.Min(a.min, v)
+	a.max = math.Max(a.max, v)
+}
+
+func (a *aggregate) result(agg string) float64 {
+	switch agg {
+	case "avg":
+		return a.sum / float64(a.n)
+	case "min":
+		return a.min
+	case "max":
+		return a.max
+	default:
+		return a.sum
+	}
+}
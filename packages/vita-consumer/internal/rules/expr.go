@@ -0,0 +1,154 @@
+// Package rules evaluates recording rules: aggregations of raw series that
+// are written back to DuckDB as derived series on a fixed interval.
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/units"
+)
+
+// Expr is a parsed rule expression:
+//
+//	sum(cpu_ms rate) by namespace
+//	avg(rate(cpu_ms)) by node
+//	max(mem_mb)
+//
+// Without "by" the result is a single cluster-wide series.
+type Expr struct {
+	Agg    string // sum, avg, min, max
+	Metric string
+	Rate   bool
+	By     string // "" for cluster-wide
+}
+
+var exprRegex = regexp.MustCompile(`^(sum|avg|min|max)\s*\(\s*(?:rate\s*\(\s*([a-z0-9_]+)\s*\)|([a-z0-9_]+)(\s+rate)?)\s*\)(?:\s+by\s+([a-z]+))?$`)
+
+var nameRegex = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// sources maps metrics usable in rules to the table their resource IDs
+// refer to; dims lists the groupings each table supports and kinds the
+// series kind of its rows.
+var (
+	sources = map[string]string{
+		"cpu_ms":                "pods",
+		"cpu_throttled_ms":      "pods",
+		"cpu_periods":           "pods",
+		"cpu_throttled_periods": "pods",
+		"mem_mb":                "pods",
+		"mem_limit_mb":          "pods",
+		"mem_working_set_mb":    "pods",
+		"mem_rss_mb":            "pods",
+		"mem_cache_mb":          "pods",
+		"mem_swap_mb":           "pods",
+		"used_mb":               "pvcs",
+		"total_mb":              "pvcs",
+		"free_mb":               "pvcs",
+	}
+	dims = map[string][]string{
+		"pods": {"namespace", "node", "deployment", "statefulset", "daemonset"},
+		"pvcs": {"namespace"},
+	}
+	kinds = map[string]string{"pods": "pod", "pvcs": "pvc"}
+)
+
+// ParseExpr validates an expression against the known source metrics
+func ParseExpr(s string) (Expr, error) {
+	m := exprRegex.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return Expr{}, fmt.Errorf("invalid expression %q, want e.g. sum(cpu_ms rate) by namespace", s)
+	}
+
+	e := Expr{Agg: m[1], Metric: m[2], Rate: m[2] != "", By: m[5]}
+	if e.Metric == "" {
+		e.Metric, e.Rate = m[3], m[4] != ""
+	}
+
+	table, ok := sources[e.Metric]
+	if !ok {
+		return Expr{}, fmt.Errorf("metric %q cannot be used in rules", e.Metric)
+	}
+	if e.Rate {
+		if info, _ := units.Lookup(e.Metric); !info.Counter {
+			return Expr{}, fmt.Errorf("rate requires a counter, %q is a gauge", e.Metric)
+		}
+	}
+	if e.By != "" && !contains(dims[table], e.By) {
+		return Expr{}, fmt.Errorf("%q cannot be grouped by %q (supported: %s)", e.Metric, e.By, strings.Join(dims[table], ", "))
+	}
+	return e, nil
+}
+
+// ParseDefinition splits "name = expr"
+func ParseDefinition(def string) (string, string, error) {
+	name, expr, ok := strings.Cut(def, "=")
+	if !ok {
+		return "", "", fmt.Errorf("invalid rule %q, want name = expr", def)
+	}
+	return strings.TrimSpace(name), strings.TrimSpace(expr), nil
+}
+
+// ValidateName rejects names that are malformed or shadow a reported metric
+func ValidateName(name string) error {
+	if !nameRegex.MatchString(name) {
+		return fmt.Errorf("invalid rule name %q", name)
+	}
+	if info, ok := units.Lookup(name); ok && !info.Derived {
+		return fmt.Errorf("%q is a reported metric type", name)
+	}
+	return nil
+}
+
+// Info describes the derived series for the units registry. Rates of CPU
+// time counters come out in millicores.
+func (e Expr) Info(name string) units.Info {
+	src, _ := units.Lookup(e.Metric)
+	info := units.Info{
+		Type:        name,
+		Unit:        src.Unit,
+		Dimension:   src.Dimension,
+		Description: "Recording rule: " + e.String(),
+		Derived:     true,
+	}
+	if e.Rate && src.Dimension == units.DimensionCPUTime {
+		info.Unit, info.Dimension = "millicores", units.DimensionCPU
+	}
+	return info
+}
+
+// Kind is the resource kind of the rule's output series: its grouping, or
+// cluster for the cluster-wide series (ID 0)
+func (e Expr) Kind() string {
+	if e.By == "" {
+		return "cluster"
+	}
+	return e.By
+}
+
+func (e Expr) String() string {
+	s := e.Agg + "(" + e.Metric
+	if e.Rate {
+		s += " rate"
+	}
+	s += ")"
+	if e.By != "" {
+		s += " by " + e.By
+	}
+	return s
+}
+
+// registerUnits makes a rule's output visible to the series API
+func registerUnits(name string, e Expr) {
+	units.Register(e.Info(name))
+}
+
+func contains(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+// Package sampling thins best-effort series while ingest volume exceeds a
+// budget: each series keeps 1 in N of its points instead of the buffer and
+// database growing without bound or points being dropped blindly. Kept
+// points carry N, so readers can tell a series was thinned and by how much.
+package sampling
+
+import (
+	"expvar"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+)
+
+var (
+	expRate    = expvar.NewInt("sampling_rate")
+	expDropped = expvar.NewInt("sampling_dropped_points")
+)
+
+const (
+	// window is what the budget is measured over
+	window = time.Minute
+	// MaxRate bounds N: past it best-effort series keep 1 in MaxRate
+	// points even if the budget is still exceeded
+	MaxRate = 64
+)
+
+// Classifier tells which metric types are best-effort (see buffer.Class)
+type Classifier interface {
+	ClassOf(metricType string) buffer.Class
+}
+
+type seriesKey struct {
+	resourceID int64
+	metricType string
+}
+
+// Sampler picks N from the points offered over the trailing window,
+// before sampling, so N follows demand rather than oscillating with its
+// own effect. Only best-effort points are ever sampled; N is the smallest
+// that brings the total back within the budget.
+type Sampler struct {
+	budget  int64 // points per window
+	classes Classifier
+	clock   clock.Clock
+
+	mu    sync.Mutex
+	start time.Time // of the current window
+	// Points offered in the previous and current window, all and
+	// best-effort
+	prev, cur       [2]int64
+	rate            int
+	counters        map[seriesKey]int // best-effort points seen this window
+	dropped, logged int64             // points sampled out; as of the last log line
+}
+
+// New samples against a budget of points per minute
+func New(perMinute int64, classes Classifier) *Sampler {
+	return &Sampler{
+		budget:   perMinute,
+		classes:  classes,
+		clock:    clock.Real,
+		rate:     1,
+		counters: make(map[seriesKey]int),
+	}
+}
+
+// SetClock replaces the clock windows are taken from. Must be called
+// before the sampler is shared.
+func (s *Sampler) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// Sample returns the points of ms to keep, with SampleRate set on kept
+// best-effort points while sampling. ms is not modified; when nothing is
+// sampled it is returned as is.
+func (s *Sampler) Sample(ms []buffer.Metric) []buffer.Metric {
+	if len(ms) == 0 || s.budget <= 0 {
+		return ms
+	}
+	bestEffort := make([]bool, len(ms))
+	var n int64
+	for i := range ms {
+		if s.classes.ClassOf(ms[i].Type) == buffer.BestEffort {
+			bestEffort[i] = true
+			n++
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rollLocked(s.clock.Now())
+	s.cur[0] += int64(len(ms))
+	s.cur[1] += n
+	s.adjustLocked()
+	if s.rate == 1 || n == 0 {
+		return ms
+	}
+
+	kept := make([]buffer.Metric, 0, len(ms))
+	for i, m := range ms {
+		if bestEffort[i] {
+			k := seriesKey{m.ResourceID, m.Type}
+			seen := s.counters[k]
+			s.counters[k] = seen + 1
+			if seen%s.rate != 0 {
+				s.dropped++
+				continue
+			}
+			m.SampleRate = s.rate
+		}
+		kept = append(kept, m)
+	}
+	expDropped.Set(s.dropped)
+	return kept
+}
+
+// Rate is the current N: best-effort series keep 1 in Rate points
+func (s *Sampler) Rate() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rate
+}
+
+// rollLocked starts a new window once the current one is over
+func (s *Sampler) rollLocked(now time.Time) {
+	if s.start.IsZero() {
+		s.start = now
+		return
+	}
+	if now.Sub(s.start) < window {
+		return
+	}
+	s.prev = s.cur
+	if now.Sub(s.start) >= 2*window {
+		s.prev = [2]int64{} // nothing offered for a whole window
+	}
+	s.cur = [2]int64{}
+	s.start = now
+	// Restart every series' count so the map only holds series still
+	// reporting
+	clear(s.counters)
+}
+
+// adjustLocked recomputes N from the offered volume over the trailing
+// window, weighting the previous window by how much of it the trailing
+// one still covers
+func (s *Sampler) adjustLocked() {
+	f := 1 - float64(s.clock.Now().Sub(s.start))/float64(window)
+	if f < 0 {
+		f = 0
+	}
+	total := float64(s.prev[0])*f + float64(s.cur[0])
+	bestEffort := float64(s.prev[1])*f + float64(s.cur[1])
+
+	rate := 1
+	if excess := total - float64(s.budget); excess > 0 {
+		rate = MaxRate
+		if keep := bestEffort - excess; keep > 0 {
+			rate = min(MaxRate, int(math.Ceil(bestEffort/keep)))
+		}
+	}
+	if rate == s.rate {
+		return
+	}
+	switch {
+	case s.rate == 1:
+		log.Printf("Ingest over budget (%.0f points in the last minute, budget %d), keeping 1 in %d best-effort points per series", total, s.budget, rate)
+	case rate == 1:
+		log.Printf("Ingest back within budget, stopped sampling (%d points sampled out since it started)", s.dropped-s.logged)
+		s.logged = s.dropped
+	}
+	s.rate = rate
+	expRate.Set(int64(rate))
+}
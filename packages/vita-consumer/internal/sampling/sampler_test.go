@@ -0,0 +1,89 @@
+package sampling_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/sampling"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+)
+
+func TestAdaptiveSampling(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		sampler := sampling.New(100, env.Ring)
+		sampler.SetClock(env.Clock)
+		now := env.Clock.Now()
+		batch := func(metricType string, series, n int) []buffer.Metric {
+			out := make([]buffer.Metric, 0, series*n)
+			for j := 0; j < n; j++ {
+				for i := 0; i < series; i++ {
+					out = append(out, buffer.Metric{Time: now.Add(time.Duration(j) * time.Second), ResourceID: int64(9500 + i), Type: metricType, Value: float64(j)})
+				}
+			}
+			return out
+		}
+
+		// Within budget every point is kept as is
+		kept := sampler.Sample(append(batch("cpu_ms", 10, 2), batch("app_requests", 10, 2)...))
+		if len(kept) != 40 || sampler.Rate() != 1 {
+			return fmt.Errorf("within budget kept %d of 40 at rate %d", len(kept), sampler.Rate())
+		}
+
+		// 160 points offered against 100: the 100 best-effort ones must shed
+		// 60, so each series keeps 1 in 3; critical types are untouched
+		over := append(batch("cpu_ms", 10, 4), batch("app_requests", 10, 8)...)
+		kept = sampler.Sample(over)
+		if sampler.Rate() != 3 {
+			return fmt.Errorf("over budget rate %d, want 3", sampler.Rate())
+		}
+		var critical, sampled int
+		for _, m := range kept {
+			switch {
+			case m.Type == "cpu_ms" && m.SampleRate == 0:
+				critical++
+			case m.Type == "app_requests" && m.SampleRate == 3:
+				sampled++
+			default:
+				return fmt.Errorf("unexpected kept point %+v", m)
+			}
+		}
+		if critical != 40 || sampled != 30 {
+			return fmt.Errorf("kept %d cpu_ms and %d app_requests, want 40 and 30", critical, sampled)
+		}
+
+		// The marker is stored with the points and reported per series
+		points := make([]store.MetricPoint, len(kept))
+		for i, m := range kept {
+			points[i] = store.MetricPoint{Time: m.Time, ResourceID: m.ResourceID, MetricType: m.Type, Value: m.Value, SampleRate: m.SampleRate}
+		}
+		if err := env.Duck.BatchInsert(points); err != nil {
+			return err
+		}
+		stored, err := env.Duck.QuerySeries(ctx, "", 9500, []string{"app_requests"}, now.Add(-time.Minute), now.Add(time.Minute))
+		if err != nil {
+			return err
+		}
+		if len(stored) != 3 || stored[0].SampleRate != 3 || stored[1].Value != 3 {
+			return fmt.Errorf("stored sampled series %+v", stored)
+		}
+		ids := []int64{9500, 9501}
+		if n, err := env.Duck.SeriesSampleRate(ctx, ids, []string{"app_requests"}, now.Add(-time.Minute), now.Add(time.Minute)); err != nil || n != 3 {
+			return fmt.Errorf("app_requests sample rate %d (%v), want 3", n, err)
+		}
+		if n, err := env.Duck.SeriesSampleRate(ctx, ids, []string{"cpu_ms"}, now.Add(-time.Minute), now.Add(time.Minute)); err != nil || n != 0 {
+			return fmt.Errorf("cpu_ms sample rate %d (%v), want 0", n, err)
+		}
+
+		// Once demand falls off, sampling stops
+		env.Clock.Advance(2 * time.Minute)
+		kept = sampler.Sample(batch("app_requests", 10, 2))
+		if len(kept) != 20 || sampler.Rate() != 1 || kept[0].SampleRate != 0 {
+			return fmt.Errorf("after the burst kept %d of 20 at rate %d", len(kept), sampler.Rate())
+		}
+		return nil
+	})
+}
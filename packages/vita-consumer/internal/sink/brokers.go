@@ -0,0 +1,69 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaPublisher keys messages by node so one node's batches stay on one
+// partition, in order
+type kafkaPublisher struct {
+	w *kafka.Writer
+}
+
+func newKafka(brokers []string, topic string) *kafkaPublisher {
+	return &kafkaPublisher{w: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireOne,
+		BatchTimeout: 10 * time.Millisecond,
+		Compression:  kafka.Snappy,
+	}}
+}
+
+func (p *kafkaPublisher) Publish(ctx context.Context, key string, msg []byte) error {
+	return p.w.WriteMessages(ctx, kafka.Message{Key: []byte(key), Value: msg})
+}
+
+func (p *kafkaPublisher) Close() error {
+	return p.w.Close()
+}
+
+// natsPublisher publishes to a JetStream subject and waits for the stream's
+// ack. The stream itself is provisioned by the operator.
+type natsPublisher struct {
+	nc      *nats.Conn
+	js      jetstream.JetStream
+	subject string
+}
+
+func newNATS(url, subject string) (*natsPublisher, error) {
+	nc, err := nats.Connect(url, nats.Name("vita-consumer"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return &natsPublisher{nc: nc, js: js, subject: subject}, nil
+}
+
+func (p *natsPublisher) Publish(ctx context.Context, key string, msg []byte) error {
+	m := nats.NewMsg(p.subject)
+	m.Header.Set("Vitakube-Node", key)
+	m.Data = msg
+	_, err := p.js.PublishMsg(ctx, m)
+	return err
+}
+
+func (p *natsPublisher) Close() error {
+	return p.nc.Drain()
+}
@@ -0,0 +1,97 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func encoder(name string) (func(Batch) []byte, error) {
+	switch name {
+	case "", "json":
+		return encodeJSON, nil
+	case "line":
+		return encodeLine, nil
+	}
+	return nil, fmt.Errorf("unknown sink encoding %q, want json or line", name)
+}
+
+type jsonBatch struct {
+	Node         string       `json:"node"`
+	Cluster      string       `json:"cluster,omitempty"`
+	Instance     string       `json:"instance,omitempty"`
+	AgentVersion string       `json:"agent_version,omitempty"`
+	Metrics      []jsonMetric `json:"metrics"`
+}
+
+type jsonMetric struct {
+	Timestamp  int64   `json:"ts"`
+	TimeMs     int64   `json:"ts_ms"`
+	ResourceID int64   `json:"resource_id,omitempty"`
+	UID        string  `json:"uid,omitempty"`
+	Kind       string  `json:"kind"`
+	Key        string  `json:"key"`
+	Value      float64 `json:"value"`
+}
+
+// encodeJSON writes one object per batch, mirroring the agent post shape
+func encodeJSON(b Batch) []byte {
+	out := jsonBatch{Node: b.Node, Cluster: b.Cluster, Instance: b.Instance, AgentVersion: b.AgentVersion, Metrics: make([]jsonMetric, len(b.Metrics))}
+	for i, m := range b.Metrics {
+		out.Metrics[i] = jsonMetric{
+			Timestamp:  m.Time.Unix(),
+			TimeMs:     m.Time.UnixMilli(),
+			ResourceID: m.ResourceID,
+			UID:        m.UID,
+			Kind:       m.Kind,
+			Key:        m.Key,
+			Value:      m.Value,
+		}
+	}
+	data, _ := json.Marshal(out)
+	return data
+}
+
+// lineEscaper escapes tag values for the InfluxDB line protocol
+var lineEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// encodeLine writes InfluxDB line protocol, one line per metric:
+//
+//	vitakube,node=n1,kind=pod,uid=...,key=cpu_ms value=1234 1767268800000000000
+//
+// with cluster, instance and agent_version tags when known.
+func encodeLine(b Batch) []byte {
+	var sb strings.Builder
+	node := lineEscaper.Replace(b.Node)
+	var source string
+	for _, tag := range []struct{ key, value string }{{"cluster", b.Cluster}, {"instance", b.Instance}, {"agent_version", b.AgentVersion}} {
+		if tag.value != "" {
+			source += "," + tag.key + "=" + lineEscaper.Replace(tag.value)
+		}
+	}
+	for _, m := range b.Metrics {
+		sb.WriteString("vitakube,node=")
+		sb.WriteString(node)
+		sb.WriteString(source)
+		sb.WriteString(",kind=")
+		sb.WriteString(m.Kind)
+		if m.UID != "" {
+			sb.WriteString(",uid=")
+			sb.WriteString(lineEscaper.Replace(m.UID))
+		}
+		sb.WriteString(",key=")
+		sb.WriteString(lineEscaper.Replace(m.Key))
+		sb.WriteString(" value=")
+		sb.WriteString(strconv.FormatFloat(m.Value, 'g', -1, 64))
+		if m.ResourceID > 0 {
+			sb.WriteString(",resource_id=")
+			sb.WriteString(strconv.FormatInt(m.ResourceID, 10))
+			sb.WriteByte('i')
+		}
+		sb.WriteByte(' ')
+		sb.WriteString(strconv.FormatInt(m.Time.UnixNano(), 10))
+		sb.WriteByte('\n')
+	}
+	return []byte(sb.String())
+}
@@ -0,0 +1,137 @@
+// Package sink tees ingested metric batches to an external stream (Kafka
+// or NATS JetStream). Publishing is asynchronous and lossy under pressure:
+// ingest never waits on the sink.
+package sink
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Metric is one ingested sample with both the catalog ID and the
+// Kubernetes UID, so consumers outside vitakube can join on either
+type Metric struct {
+	Time       time.Time
+	ResourceID int64 // 0 when the UID was not resolved yet
+	UID        string
+	Kind       string // "pod" or "pvc"
+	Key        string
+	Value      float64
+}
+
+// Batch is one agent post. Cluster and Instance are set when the consumer
+// stamps provenance.
+type Batch struct {
+	Node         string
+	Cluster      string
+	Instance     string
+	AgentVersion string
+	Metrics      []Metric
+}
+
+// Publisher delivers encoded messages to a broker
+type Publisher interface {
+	Publish(ctx context.Context, key string, msg []byte) error
+	Close() error
+}
+
+// Config selects and configures the sink
+type Config struct {
+	Type     string // "kafka" or "nats"
+	URL      string // comma-separated Kafka brokers, or a NATS server URL
+	Topic    string // Kafka topic or JetStream subject
+	Encoding string // "json" (default) or "line"
+	Queue    int    // batches held while the broker is slow
+}
+
+var (
+	published = expvar.NewInt("sink_published_batches")
+	dropped   = expvar.NewInt("sink_dropped_batches")
+	failed    = expvar.NewInt("sink_failed_batches")
+)
+
+// publishTimeout bounds one broker write
+const publishTimeout = 10 * time.Second
+
+// Sink queues batches and publishes them from a single goroutine, which
+// keeps per-node ordering intact
+type Sink struct {
+	pub    Publisher
+	encode func(Batch) []byte
+	topic  string
+	queue  chan Batch
+}
+
+// New connects to the configured broker
+func New(cfg Config) (*Sink, error) {
+	encode, err := encoder(cfg.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.URL == "" || cfg.Topic == "" {
+		return nil, fmt.Errorf("sink needs a URL and a topic")
+	}
+	if cfg.Queue <= 0 {
+		cfg.Queue = 1024
+	}
+
+	var pub Publisher
+	switch cfg.Type {
+	case "kafka":
+		pub = newKafka(strings.Split(cfg.URL, ","), cfg.Topic)
+	case "nats":
+		if pub, err = newNATS(cfg.URL, cfg.Topic); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown sink type %q, want kafka or nats", cfg.Type)
+	}
+
+	return &Sink{pub: pub, encode: encode, topic: cfg.Topic, queue: make(chan Batch, cfg.Queue)}, nil
+}
+
+// Enqueue hands a batch to the publisher, dropping it if the queue is full.
+// The sink takes ownership of b.
+func (s *Sink) Enqueue(b Batch) {
+	select {
+	case s.queue <- b:
+	default:
+		dropped.Add(1)
+	}
+}
+
+// Run publishes queued batches until ctx is done, then closes the broker
+// connection
+func (s *Sink) Run(ctx context.Context) {
+	defer s.pub.Close()
+
+	// Log the first failure of a streak, not every batch
+	var failing bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case b := <-s.queue:
+			pctx, cancel := context.WithTimeout(ctx, publishTimeout)
+			err := s.pub.Publish(pctx, b.Node, s.encode(b))
+			cancel()
+			if err != nil {
+				failed.Add(1)
+				if !failing {
+					log.Printf("Sink publish to %s failed: %v", s.topic, err)
+				}
+				failing = true
+				continue
+			}
+			if failing {
+				log.Printf("Sink publish to %s recovered", s.topic)
+			}
+			failing = false
+			published.Add(1)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package slo
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+func (e *Engine) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/slos", e.handleList)
+	mux.HandleFunc("POST /api/v1/slos", e.handleUpsert)
+	mux.HandleFunc("GET /api/v1/slos/alerts", e.handleAlerts)
+	mux.HandleFunc("GET /api/v1/slos/{id}", e.handleGet)
+	mux.HandleFunc("DELETE /api/v1/slos/{id}", e.handleDelete)
+	mux.HandleFunc("GET /api/v1/slos/{id}/budget", e.handleBudget)
+}
+
+// sloRequest accepts either a spec ("p95 cpu < 80% over 30d") or the
+// objective fields
+type sloRequest struct {
+	Name         string  `json:"name"`
+	Kind         string  `json:"kind"`
+	WorkloadID   int64   `json:"workload_id"`
+	Spec         string  `json:"spec"`
+	Resource     string  `json:"resource"`
+	Objective    float64 `json:"objective"`
+	ThresholdPct float64 `json:"threshold_pct"`
+	WindowDays   int     `json:"window_days"`
+	Enabled      *bool   `json:"enabled"`
+}
+
+// status computes an SLO's status over its window
+func (e *Engine) status(s store.SLO) (Status, error) {
+	if s.RolledThrough == nil {
+		return Compute(s, nil), nil
+	}
+	buckets, err := e.sqlite.SLOBuckets(s.ID, s.RolledThrough.Add(-Window(s)), *s.RolledThrough)
+	if err != nil {
+		return Status{}, err
+	}
+	return Compute(s, buckets), nil
+}
+
+// getSLO loads the SLO named by the {id} path value, writing the error
+// response when it fails
+func (e *Engine) getSLO(w http.ResponseWriter, r *http.Request) (store.SLO, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, "Invalid id", http.StatusBadRequest)
+		return store.SLO{}, false
+	}
+	s, err := e.sqlite.GetSLO(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, "SLO not found", http.StatusNotFound)
+		return store.SLO{}, false
+	}
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return store.SLO{}, false
+	}
+	return s, true
+}
+
+// handleList serves GET /api/v1/slos with the status of every SLO
+func (e *Engine) handleList(w http.ResponseWriter, r *http.Request) {
+	slos, err := e.sqlite.ListSLOs()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := make([]Status, 0, len(slos))
+	for _, s := range slos {
+		st, err := e.status(s)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out = append(out, st)
+	}
+	writeJSON(w, out)
+}
+
+// handleUpsert serves POST /api/v1/slos, creating or replacing an SLO by
+// name
+func (e *Engine) handleUpsert(w http.ResponseWriter, r *http.Request) {
+	var req sloRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		writeError(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if _, ok := store.WorkloadKinds[req.Kind]; !ok {
+		writeError(w, "kind must be deployment, statefulset or daemonset", http.StatusBadRequest)
+		return
+	}
+
+	s := store.SLO{
+		Name:         req.Name,
+		Kind:         req.Kind,
+		WorkloadID:   req.WorkloadID,
+		Resource:     req.Resource,
+		Objective:    req.Objective,
+		ThresholdPct: req.ThresholdPct,
+		WindowDays:   req.WindowDays,
+		Enabled:      req.Enabled == nil || *req.Enabled,
+	}
+	if req.Spec != "" {
+		if err := ParseSpec(req.Spec, &s); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := Validate(s); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, _, err := e.sqlite.WorkloadNamespace(s.Kind, s.WorkloadID); errors.Is(err, sql.ErrNoRows) {
+		writeError(w, "workload not found", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	id, err := e.sqlite.UpsertSLO(s)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	saved, err := e.sqlite.GetSLO(id)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	st, err := e.status(saved)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, st)
+}
+
+// handleGet serves GET /api/v1/slos/{id}
+func (e *Engine) handleGet(w http.ResponseWriter, r *http.Request) {
+	s, ok := e.getSLO(w, r)
+	if !ok {
+		return
+	}
+	st, err := e.status(s)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, st)
+}
+
+// handleDelete serves DELETE /api/v1/slos/{id}
+func (e *Engine) handleDelete(w http.ResponseWriter, r *http.Request) {
+	s, ok := e.getSLO(w, r)
+	if !ok {
+		return
+	}
+	if err := e.sqlite.DeleteSLO(s.ID); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BudgetDay is the error budget left at the end of a day of the window
+type BudgetDay struct {
+	Day             int64   `json:"day"` // unix start of the day
+	BadBuckets      int     `json:"bad_buckets"`
+	ConsumedBuckets int     `json:"consumed_buckets"` // since the window's start
+	RemainingPct    float64 `json:"remaining_pct"`
+}
+
+// BudgetReport is an SLO's error budget with its daily burndown
+type BudgetReport struct {
+	ID        int64              `json:"id"`
+	Name      string             `json:"name"`
+	Spec      string             `json:"spec"`
+	Budget    Budget             `json:"error_budget"`
+	Burndown  []BudgetDay        `json:"burndown"`
+	BurnRates map[string]float64 `json:"burn_rates"`
+}
+
+// handleBudget serves GET /api/v1/slos/{id}/budget: the budget left and
+// how it was spent day by day over the window
+func (e *Engine) handleBudget(w http.ResponseWriter, r *http.Request) {
+	s, ok := e.getSLO(w, r)
+	if !ok {
+		return
+	}
+	resp := BudgetReport{ID: s.ID, Name: s.Name, Spec: Spec(s), Budget: budget(s, 0), Burndown: []BudgetDay{}, BurnRates: map[string]float64{}}
+	if s.RolledThrough == nil {
+		writeJSON(w, resp)
+		return
+	}
+
+	buckets, err := e.sqlite.SLOBuckets(s.ID, s.RolledThrough.Add(-Window(s)), *s.RolledThrough)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	st := Compute(s, buckets)
+	resp.Budget, resp.BurnRates = st.Budget, st.BurnRates
+
+	consumed := 0
+	for _, b := range buckets {
+		day := b.Time.UTC().Truncate(24 * time.Hour).Unix()
+		if n := len(resp.Burndown); n == 0 || resp.Burndown[n-1].Day != day {
+			resp.Burndown = append(resp.Burndown, BudgetDay{Day: day})
+		}
+		d := &resp.Burndown[len(resp.Burndown)-1]
+		if b.Bad {
+			d.BadBuckets++
+			consumed++
+		}
+		d.ConsumedBuckets = consumed
+		d.RemainingPct = budget(s, consumed).RemainingPct
+	}
+	writeJSON(w, resp)
+}
+
+// handleAlerts serves GET /api/v1/slos/alerts with the SLOs whose burn
+// alert is firing
+func (e *Engine) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	slos, err := e.sqlite.ListSLOs()
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := []Status{}
+	for _, s := range slos {
+		if !s.Enabled || s.Alert == "" {
+			continue
+		}
+		st, err := e.status(s)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out = append(out, st)
+	}
+	writeJSON(w, out)
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, message string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,160 @@
+package slo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+const (
+	// evalDelay leaves time for the ring buffer to flush before a bucket is
+	// rolled up
+	evalDelay = 2 * time.Minute
+	// maxCatchUp bounds how much history one roll-up processes, both for
+	// new SLOs and after downtime
+	maxCatchUp = 24 * time.Hour
+	// alertLookback covers the longest burn window
+	alertLookback = 6 * time.Hour
+)
+
+// Engine rolls up SLO buckets and raises burn alerts
+type Engine struct {
+	sqlite *store.SQLiteStore
+	duck   *store.DuckDBStore
+}
+
+func NewEngine(sqlite *store.SQLiteStore, duck *store.DuckDBStore) *Engine {
+	return &Engine{sqlite: sqlite, duck: duck}
+}
+
+// Evaluate rolls up the complete buckets of every enabled SLO since its
+// last roll-up and updates its burn alert. It runs as a scheduled job.
+func (e *Engine) Evaluate(ctx context.Context, now time.Time) error {
+	slos, err := e.sqlite.ListSLOs()
+	if err != nil {
+		return fmt.Errorf("load SLOs: %w", err)
+	}
+
+	for _, s := range slos {
+		if !s.Enabled {
+			continue
+		}
+		through, caughtUp, err := e.roll(ctx, s, now)
+		if err != nil {
+			log.Printf("SLO %q roll-up failed: %v", s.Name, err)
+			if err := e.sqlite.SetSLOError(s.ID, err); err != nil {
+				log.Printf("Failed to record SLO error of %q: %v", s.Name, err)
+			}
+			continue
+		}
+		if caughtUp {
+			e.alert(s, through, now)
+		}
+	}
+	return nil
+}
+
+// roll stores the buckets between the SLO's last roll-up and now. It
+// returns the end of the rolled-up range and whether it reaches now.
+func (e *Engine) roll(ctx context.Context, s store.SLO, now time.Time) (time.Time, bool, error) {
+	end := now.Add(-evalDelay).Truncate(Step)
+	keepFrom := end.Add(-Window(s))
+	start := keepFrom
+	if s.RolledThrough != nil && s.RolledThrough.After(start) {
+		start = *s.RolledThrough
+	}
+	caughtUp := true
+	if end.Sub(start) > maxCatchUp {
+		end = start.Add(maxCatchUp)
+		caughtUp = false
+	}
+	if !end.After(start) {
+		return start, caughtUp, nil
+	}
+
+	buckets, err := e.buckets(ctx, s, start, end)
+	if err != nil {
+		return end, false, err
+	}
+	return end, caughtUp, e.sqlite.AddSLOBuckets(s.ID, buckets, end, keepFrom)
+}
+
+// buckets computes the workload's utilization in [start, end): the usage
+// of its pods over the sum of their limits, counting only pods that
+// reported in the bucket
+func (e *Engine) buckets(ctx context.Context, s store.SLO, start, end time.Time) ([]store.SLOBucket, error) {
+	limits, err := e.sqlite.WorkloadPodLimits(s.Kind, s.WorkloadID, s.Resource)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(limits))
+	for id := range limits {
+		ids = append(ids, id)
+	}
+
+	metric, agg := "cpu_ms", "rate" // ms of CPU per second are millicores
+	if s.Resource == Memory {
+		metric, agg = "mem_mb", "avg"
+	}
+	points, err := e.duck.QueryBucketedForResources(ctx, "pod", metric, ids, start, end, store.Bucketing{Step: Step}, agg)
+	if err != nil {
+		return nil, err
+	}
+
+	type sums struct{ usage, limit float64 }
+	byTime := make(map[time.Time]*sums)
+	for _, p := range points {
+		t := byTime[p.Time]
+		if t == nil {
+			t = &sums{}
+			byTime[p.Time] = t
+		}
+		t.usage += p.Value
+		t.limit += limits[p.ResourceID]
+	}
+
+	out := make([]store.SLOBucket, 0, len(byTime))
+	for at, t := range byTime {
+		if t.limit <= 0 {
+			continue
+		}
+		util := round(t.usage / t.limit * 100)
+		out = append(out, store.SLOBucket{Time: at, UtilizationPct: util, Bad: util >= s.ThresholdPct})
+	}
+	return out, nil
+}
+
+// alert raises or clears the SLO's burn alert from the buckets up to end,
+// adding an annotation when one starts firing
+func (e *Engine) alert(s store.SLO, end, now time.Time) {
+	buckets, err := e.sqlite.SLOBuckets(s.ID, end.Add(-alertLookback), end)
+	if err != nil {
+		log.Printf("Failed to load buckets of SLO %q: %v", s.Name, err)
+		return
+	}
+	severity, rate, window := alertFor(s, buckets, end)
+	if severity == s.Alert {
+		return
+	}
+	if err := e.sqlite.SetSLOAlert(s.ID, severity, now); err != nil {
+		log.Printf("Failed to record alert of SLO %q: %v", s.Name, err)
+		return
+	}
+	if severity == "" {
+		log.Printf("SLO %q burn alert cleared", s.Name)
+		return
+	}
+
+	msg := fmt.Sprintf("%s: error budget burning %.1fx over %s (%s)", Spec(s), rate, formatWindow(window), severity)
+	log.Printf("SLO %q %s", s.Name, msg)
+	a := store.Annotation{Time: now, Type: "slo_burn", Kind: s.Kind, Name: s.Name, Message: msg}
+	if nsID, _, err := e.sqlite.WorkloadNamespace(s.Kind, s.WorkloadID); err == nil {
+		a.NamespaceID = &nsID
+	}
+	if err := e.sqlite.InsertAnnotation(a); err != nil {
+		log.Printf("Failed to annotate SLO %q alert: %v", s.Name, err)
+	}
+}
@@ -0,0 +1,114 @@
+package slo_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/slo"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestSLOBurn(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		rs, depID, err := env.CreateDeployment(ctx, synctest.Deployment("shop", "web", 1))
+		if err != nil {
+			return err
+		}
+		pod := synctest.Pod("shop", "web-0", "node-a", rs)
+		pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}
+		pods, err := env.CreatePods(ctx, pod)
+		if err != nil {
+			return err
+		}
+		podID := pods["web-0"]
+
+		// Two hours at 400m of a 1000m limit, then an hour at 900m
+		end := env.Clock.Now().Truncate(slo.Step)
+		var points []store.MetricPoint
+		for i := 36; i > 0; i-- {
+			bucket := end.Add(-time.Duration(i) * slo.Step)
+			rate := 400.0
+			if i <= 12 {
+				rate = 900
+			}
+			points = append(points,
+				store.MetricPoint{Time: bucket.Add(time.Minute), ResourceID: podID, MetricType: "cpu_ms", Value: 0},
+				store.MetricPoint{Time: bucket.Add(2 * time.Minute), ResourceID: podID, MetricType: "cpu_ms", Value: rate * 60})
+		}
+		if err := env.Duck.BatchInsert(points); err != nil {
+			return err
+		}
+
+		var created slo.Status
+		if err := env.PostJSON("/api/v1/slos", map[string]interface{}{
+			"name": "web-cpu", "kind": "deployment", "workload_id": depID, "spec": "p95 cpu < 80% over 1d",
+		}, &created); err != nil {
+			return err
+		}
+		if created.Spec != "p95 cpu < 80% over 1d" || created.Buckets != 0 || created.Alert != "" {
+			return fmt.Errorf("created SLO: %+v", created)
+		}
+
+		now := end.Add(3 * time.Minute) // end is the last complete bucket
+		env.Clock.Set(now)
+		env.SLOs.Evaluate(ctx, now)
+
+		var st slo.Status
+		if err := env.GetJSON(fmt.Sprintf("/api/v1/slos/%d", created.ID), &st); err != nil {
+			return err
+		}
+		if st.Buckets != 36 || st.BadBuckets != 12 || st.Compliance == nil || *st.Compliance != 0.667 || st.Met {
+			return fmt.Errorf("SLO status: %+v", st)
+		}
+		// 12 bad of 36 against the 5% allowed: 6.7x over 6h, 20x over the last hour
+		if st.BurnRates["1h"] != 20 || st.BurnRates["5m"] != 20 || st.BurnRates["6h"] != 6.667 || st.Alert != "page" {
+			return fmt.Errorf("burn rates %v, alert %q", st.BurnRates, st.Alert)
+		}
+
+		var budget slo.BudgetReport
+		if err := env.GetJSON(fmt.Sprintf("/api/v1/slos/%d/budget", created.ID), &budget); err != nil {
+			return err
+		}
+		// 5% of 288 buckets a day is 14.4, 12 of them spent
+		if b := budget.Budget; b.AllowedBuckets != 14.4 || b.ConsumedBuckets != 12 || b.RemainingPct != 16.667 {
+			return fmt.Errorf("error budget: %+v", b)
+		}
+		if n := len(budget.Burndown); n == 0 || budget.Burndown[n-1].ConsumedBuckets != 12 {
+			return fmt.Errorf("burndown: %+v", budget.Burndown)
+		}
+
+		var alerts []slo.Status
+		if err := env.GetJSON("/api/v1/slos/alerts", &alerts); err != nil {
+			return err
+		}
+		if len(alerts) != 1 || alerts[0].Name != "web-cpu" {
+			return fmt.Errorf("firing SLO alerts: %+v", alerts)
+		}
+		n, err := env.QueryInt("SELECT COUNT(*) FROM annotations WHERE type = 'slo_burn' AND name = 'web-cpu'")
+		if err != nil || n != 1 {
+			return fmt.Errorf("burn annotations: %d, %v", n, err)
+		}
+		var page api.PublicStatus
+		if err := env.GetJSON("/status", &page); err != nil {
+			return err
+		}
+		if !slices.Contains(page.Alerts, "slo_burn") {
+			return fmt.Errorf("status page alerts: %v", page.Alerts)
+		}
+
+		// Nothing new is due a minute later: the alert keeps firing once
+		env.SLOs.Evaluate(ctx, now.Add(time.Minute))
+		n, err = env.QueryInt("SELECT COUNT(*) FROM annotations WHERE type = 'slo_burn'")
+		if err != nil || n != 1 {
+			return fmt.Errorf("burn annotated again: %d, %v", n, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,197 @@
+// Package slo tracks saturation objectives per workload, such as "p95 cpu <
+// 80% over 30d": the share of five-minute buckets in which the workload's
+// pods use less than 80% of their limits must be at least 95%. Buckets are
+// rolled up from DuckDB into SQLite as time passes, so compliance, error
+// budget and burn rates over the whole window are read from the roll-up.
+package slo
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// Step is the length of a rolled-up bucket
+const Step = 5 * time.Minute
+
+// Resources an SLO can measure, against the pods' limits
+const (
+	CPU    = "cpu"
+	Memory = "mem"
+)
+
+var specRe = regexp.MustCompile(`(?i)^p(\d+(?:\.\d+)?)\s+(cpu|mem|memory)(?:\s+utili[sz]ation)?\s*<\s*(\d+(?:\.\d+)?)\s*%\s+over\s+(\d+)d$`)
+
+// ParseSpec reads "p95 cpu < 80% over 30d" (or "p99 memory utilization <
+// 90% over 7d") into the fields of slo
+func ParseSpec(spec string, slo *store.SLO) error {
+	m := specRe.FindStringSubmatch(strings.TrimSpace(spec))
+	if m == nil {
+		return fmt.Errorf(`spec must look like "p95 cpu < 80%% over 30d"`)
+	}
+	p, _ := strconv.ParseFloat(m[1], 64)
+	threshold, _ := strconv.ParseFloat(m[3], 64)
+	days, _ := strconv.Atoi(m[4])
+	slo.Objective = p / 100
+	slo.Resource = CPU
+	if strings.HasPrefix(strings.ToLower(m[2]), "mem") {
+		slo.Resource = Memory
+	}
+	slo.ThresholdPct = threshold
+	slo.WindowDays = days
+	return nil
+}
+
+// Spec formats an SLO's objective the way ParseSpec reads it
+func Spec(slo store.SLO) string {
+	return fmt.Sprintf("p%s %s < %s%% over %dd", formatFloat(slo.Objective*100), slo.Resource, formatFloat(slo.ThresholdPct), slo.WindowDays)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// Validate checks the objective fields
+func Validate(slo store.SLO) error {
+	if slo.Resource != CPU && slo.Resource != Memory {
+		return fmt.Errorf("resource must be cpu or mem")
+	}
+	if slo.Objective <= 0 || slo.Objective >= 1 {
+		return fmt.Errorf("objective must be between 0 and 1 (p0 and p100 exclusive)")
+	}
+	if slo.ThresholdPct <= 0 {
+		return fmt.Errorf("threshold_pct must be positive")
+	}
+	if slo.WindowDays < 1 || slo.WindowDays > 90 {
+		return fmt.Errorf("window_days must be between 1 and 90")
+	}
+	return nil
+}
+
+// Window is the compliance window of an SLO
+func Window(slo store.SLO) time.Duration {
+	return time.Duration(slo.WindowDays) * 24 * time.Hour
+}
+
+// burnWindows are the multi-window, multi-burn-rate alerts: a long window
+// shows the budget is really being spent, a short one that it still is.
+// 14.4x spends 2% of a 30-day budget in an hour, 6x spends 5% in 6 hours.
+var burnWindows = []struct {
+	severity    string
+	long, short time.Duration
+	rate        float64
+}{
+	{"page", time.Hour, 5 * time.Minute, 14.4},
+	{"ticket", 6 * time.Hour, 30 * time.Minute, 6},
+}
+
+// Status is an SLO's compliance over its window as far as it is rolled up
+type Status struct {
+	store.SLO
+	Spec string `json:"spec"`
+	// Buckets is how many buckets had data; those without (no pods
+	// reporting, or no limits) count neither way
+	Buckets    int      `json:"buckets"`
+	BadBuckets int      `json:"bad_buckets"`
+	Compliance *float64 `json:"compliance,omitempty"` // share of good buckets, absent without data
+	Met        bool     `json:"met"`
+	// BurnRates is how fast the error budget is spent over the alert
+	// windows and the whole window: 1 spends it exactly by the window's end
+	BurnRates map[string]float64 `json:"burn_rates"`
+	Budget    Budget             `json:"error_budget"`
+}
+
+// Budget is the error budget over a full window: the share of its buckets
+// allowed to be bad
+type Budget struct {
+	AllowedBuckets  float64 `json:"allowed_buckets"`
+	ConsumedBuckets int     `json:"consumed_buckets"`
+	RemainingPct    float64 `json:"remaining_pct"` // negative once exhausted
+}
+
+// allowedBuckets is the number of bad buckets a full window may hold
+func allowedBuckets(slo store.SLO) float64 {
+	return (1 - slo.Objective) * float64(Window(slo)/Step)
+}
+
+func budget(slo store.SLO, bad int) Budget {
+	b := Budget{AllowedBuckets: round(allowedBuckets(slo)), ConsumedBuckets: bad, RemainingPct: 100}
+	if allowed := allowedBuckets(slo); allowed > 0 {
+		b.RemainingPct = round((1 - float64(bad)/allowed) * 100)
+	}
+	return b
+}
+
+// burnRate is the share of bad buckets in [end-d, end) over the share the
+// objective allows
+func burnRate(slo store.SLO, buckets []store.SLOBucket, end time.Time, d time.Duration) float64 {
+	from := end.Add(-d)
+	var n, bad int
+	for _, b := range buckets {
+		if b.Time.Before(from) || !b.Time.Before(end) {
+			continue
+		}
+		n++
+		if b.Bad {
+			bad++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return round(float64(bad) / float64(n) / (1 - slo.Objective))
+}
+
+// Compute derives an SLO's status from its buckets over the window
+func Compute(slo store.SLO, buckets []store.SLOBucket) Status {
+	st := Status{SLO: slo, Spec: Spec(slo), BurnRates: map[string]float64{}, Met: true}
+	for _, b := range buckets {
+		st.Buckets++
+		if b.Bad {
+			st.BadBuckets++
+		}
+	}
+	if st.Buckets > 0 {
+		c := round(float64(st.Buckets-st.BadBuckets) / float64(st.Buckets))
+		st.Compliance = &c
+		st.Met = float64(st.Buckets-st.BadBuckets)/float64(st.Buckets) >= slo.Objective
+	}
+	st.Budget = budget(slo, st.BadBuckets)
+	if slo.RolledThrough != nil {
+		end := *slo.RolledThrough
+		for _, w := range burnWindows {
+			st.BurnRates[formatWindow(w.short)] = burnRate(slo, buckets, end, w.short)
+			st.BurnRates[formatWindow(w.long)] = burnRate(slo, buckets, end, w.long)
+		}
+		st.BurnRates[fmt.Sprintf("%dd", slo.WindowDays)] = burnRate(slo, buckets, end, Window(slo))
+	}
+	return st
+}
+
+// alertFor returns the most severe burn alert the buckets meet, with the
+// long-window rate that raised it
+func alertFor(slo store.SLO, buckets []store.SLOBucket, end time.Time) (string, float64, time.Duration) {
+	for _, w := range burnWindows {
+		long := burnRate(slo, buckets, end, w.long)
+		if long >= w.rate && burnRate(slo, buckets, end, w.short) >= w.rate {
+			return w.severity, long, w.long
+		}
+	}
+	return "", 0, 0
+}
+
+func formatWindow(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	}
+	return fmt.Sprintf("%dm", int(d/time.Minute))
+}
+
+func round(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hotQuery is a representative shape of a query the API runs on every
+// dashboard refresh, with the index it is expected to use
+type hotQuery struct {
+	name    string
+	query   string
+	table   string
+	alias   string
+	columns []string
+}
+
+var hotQueries = []hotQuery{
+	{"pods by namespace", `SELECT p.id FROM pods p JOIN namespaces ns ON p.namespace_id = ns.id
+		WHERE p.namespace_id = 1`, "pods", "p", []string{"namespace_id"}},
+	{"pods by node", `SELECT p.id FROM pods p JOIN nodes n ON p.node_id = n.id
+		WHERE p.node_id = 1`, "pods", "p", []string{"node_id"}},
+	{"pods by deployment", `SELECT p.id FROM pods p LEFT JOIN deployments d ON p.deployment_id = d.id
+		WHERE p.deployment_id = 1`, "pods", "p", []string{"deployment_id"}},
+	{"pod counts per namespace", `SELECT ns.id, COUNT(p.id) FROM namespaces ns
+		LEFT JOIN pods p ON p.namespace_id = ns.id GROUP BY ns.id`, "pods", "p", []string{"namespace_id"}},
+	{"pod counts per node", `SELECT n.id, COUNT(p.id) FROM nodes n
+		LEFT JOIN pods p ON p.node_id = n.id GROUP BY n.id`, "pods", "p", []string{"node_id"}},
+	{"pvcs by namespace", `SELECT pvc.id FROM pvcs pvc JOIN namespaces ns ON pvc.namespace_id = ns.id
+		WHERE pvc.namespace_id = 1`, "pvcs", "pvc", []string{"namespace_id"}},
+}
+
+// IndexAdvice reports a hot query that scans a table instead of using an
+// index
+type IndexAdvice struct {
+	Query   string `json:"query"`
+	Plan    string `json:"plan"`
+	Index   string `json:"index"`
+	Created bool   `json:"created"`
+}
+
+// AdviseIndexes runs EXPLAIN QUERY PLAN over the hot queries and reports
+// those that scan their table or rely on an automatic (per-query) index.
+// With create set, the missing indexes are created and the plan rechecked.
+func (s *SQLiteStore) AdviseIndexes(create bool) ([]IndexAdvice, error) {
+	var out []IndexAdvice
+	for _, q := range hotQueries {
+		plan, ok, err := s.usesIndex(q)
+		if err != nil {
+			return out, fmt.Errorf("%s: %w", q.name, err)
+		}
+		if ok {
+			continue
+		}
+
+		advice := IndexAdvice{
+			Query: q.name,
+			Plan:  plan,
+			Index: fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s(%s)",
+				indexName(q.table, q.columns), q.table, strings.Join(q.columns, ", ")),
+		}
+		if create {
+			if _, err := s.db.Exec(advice.Index); err != nil {
+				return out, fmt.Errorf("%s: %w", q.name, err)
+			}
+			if _, ok, err := s.usesIndex(q); err == nil && ok {
+				advice.Created = true
+			}
+		}
+		out = append(out, advice)
+	}
+	return out, nil
+}
+
+// usesIndex reports whether the plan reaches q.table through a persistent
+// index (or its primary key) rather than a scan
+func (s *SQLiteStore) usesIndex(q hotQuery) (string, bool, error) {
+	rows, err := s.db.Query("EXPLAIN QUERY PLAN " + q.query)
+	if err != nil {
+		return "", false, err
+	}
+	defer rows.Close()
+
+	var steps []string
+	ok := false
+	for rows.Next() {
+		var id, parent, notUsed int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notUsed, &detail); err != nil {
+			return "", false, err
+		}
+		steps = append(steps, detail)
+		// e.g. "SEARCH p USING INDEX idx_pods_node (node_id=?)"
+		if strings.HasPrefix(detail, "SEARCH "+q.alias+" ") && !strings.Contains(detail, "AUTOMATIC") {
+			ok = true
+		}
+	}
+	return strings.Join(steps, "; "), ok, rows.Err()
+}
+
+// indexName follows the schema's naming, e.g. idx_pods_node for node_id
+func indexName(table string, columns []string) string {
+	name := "idx_" + table
+	for _, c := range columns {
+		name += "_" + strings.TrimSuffix(c, "_id")
+	}
+	return name
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// AgentConfig is the stored collection settings of one node, or of every
+// node when Node is empty
+type AgentConfig struct {
+	Node      string          `json:"node"`
+	Config    json.RawMessage `json:"config"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+func (s *SQLiteStore) ListAgentConfigs() ([]AgentConfig, error) {
+	rows, err := s.db.Query("SELECT node, config, updated_at FROM agent_configs ORDER BY node")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []AgentConfig{}
+	for rows.Next() {
+		var c AgentConfig
+		var config string
+		if err := rows.Scan(&c.Node, &config, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		c.Config = json.RawMessage(config)
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// GetAgentConfig returns the stored settings of a node ("" for the
+// cluster default) and whether there are any
+func (s *SQLiteStore) GetAgentConfig(node string) (json.RawMessage, bool, error) {
+	var config string
+	err := s.db.QueryRow("SELECT config FROM agent_configs WHERE node = ?", node).Scan(&config)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return json.RawMessage(config), true, nil
+}
+
+func (s *SQLiteStore) SetAgentConfig(node string, config json.RawMessage) error {
+	_, err := s.db.Exec(`INSERT INTO agent_configs (node, config, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(node) DO UPDATE SET config = excluded.config, updated_at = CURRENT_TIMESTAMP`, node, string(config))
+	return err
+}
+
+// DeleteAgentConfig reports whether the node had settings
+func (s *SQLiteStore) DeleteAgentConfig(node string) (bool, error) {
+	res, err := s.db.Exec("DELETE FROM agent_configs WHERE node = ?", node)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// AgentRegistration is a node whose agent has posted metrics
+type AgentRegistration struct {
+	Node       string     `json:"node"`
+	Status     string     `json:"status"` // pending, approved or rejected
+	RemoteAddr string     `json:"remote_addr,omitempty"`
+	FirstSeen  time.Time  `json:"first_seen"`
+	LastSeen   time.Time  `json:"last_seen"`
+	DecidedAt  *time.Time `json:"decided_at,omitempty"`
+}
+
+// ListAgentRegistrations returns every registration, or those with status
+// when it is set
+func (s *SQLiteStore) ListAgentRegistrations(status string) ([]AgentRegistration, error) {
+	query := "SELECT node, status, remote_addr, first_seen, last_seen, decided_at FROM agent_registrations"
+	args := []interface{}{}
+	if status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	rows, err := s.db.Query(query+" ORDER BY node", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []AgentRegistration{}
+	for rows.Next() {
+		var a AgentRegistration
+		var remote sql.NullString
+		var decided sql.NullTime
+		if err := rows.Scan(&a.Node, &a.Status, &remote, &a.FirstSeen, &a.LastSeen, &decided); err != nil {
+			return nil, err
+		}
+		a.RemoteAddr = remote.String
+		if decided.Valid {
+			a.DecidedAt = &decided.Time
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// RegisterAgent records a node's first post with the given status; an
+// existing registration is left as it is
+func (s *SQLiteStore) RegisterAgent(node, status, remoteAddr string, at time.Time) error {
+	var decided interface{}
+	if status != "pending" {
+		decided = at.UTC()
+	}
+	_, err := s.db.Exec(`INSERT INTO agent_registrations (node, status, remote_addr, first_seen, last_seen, decided_at)
+		VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT(node) DO NOTHING`, node, status, remoteAddr, at.UTC(), at.UTC(), decided)
+	return err
+}
+
+// SetAgentStatus approves or rejects an agent, reporting whether it is
+// registered
+func (s *SQLiteStore) SetAgentStatus(node, status string, at time.Time) (bool, error) {
+	res, err := s.db.Exec("UPDATE agent_registrations SET status = ?, decided_at = ? WHERE node = ?", status, at.UTC(), node)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ApprovePendingAgent approves an agent unless it was decided already,
+// reporting whether it did
+func (s *SQLiteStore) ApprovePendingAgent(node string, at time.Time) (bool, error) {
+	res, err := s.db.Exec("UPDATE agent_registrations SET status = 'approved', decided_at = ? WHERE node = ? AND status = 'pending'", at.UTC(), node)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// TouchAgent moves an agent's last_seen forward
+func (s *SQLiteStore) TouchAgent(node, remoteAddr string, at time.Time) error {
+	_, err := s.db.Exec("UPDATE agent_registrations SET last_seen = ?, remote_addr = ? WHERE node = ?", at.UTC(), remoteAddr, node)
+	return err
+}
+
+// DeleteAgentRegistration forgets an agent, reporting whether it was
+// registered; its next post registers it again
+func (s *SQLiteStore) DeleteAgentRegistration(node string) (bool, error) {
+	res, err := s.db.Exec("DELETE FROM agent_registrations WHERE node = ?", node)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// NodeExists reports whether the synced catalog has a node of that name
+func (s *SQLiteStore) NodeExists(name string) (bool, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM nodes WHERE name = ?", name).Scan(&n)
+	return n > 0, err
+}
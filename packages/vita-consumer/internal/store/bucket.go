@@ -0,0 +1,84 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// Bucketing groups points into Step-wide buckets aligned to the Unix epoch
+// in UTC. With Loc set, Step must be whole days: buckets then start at
+// local midnight, and multiples of a week on Monday, so a daily bucket is
+// one business day even across DST changes (23 or 25 hours long).
+type Bucketing struct {
+	Step time.Duration
+	Loc  *time.Location
+}
+
+// Validate rejects local bucketing with a step that is not whole days
+func (b Bucketing) Validate() error {
+	if b.Loc != nil && (b.Step < day || b.Step%day != 0) {
+		return fmt.Errorf("step must be a whole number of days (86400s) with a time zone")
+	}
+	return nil
+}
+
+// keyExpr returns the SQL for the bucket of column time and its args. UTC
+// buckets are timestamps; local ones are bucket numbers, turned into times
+// by start.
+func (b Bucketing) keyExpr(from, to time.Time) (string, []interface{}) {
+	stepSec := int64(b.Step.Seconds())
+	if b.Loc == nil {
+		return "to_timestamp(floor(epoch(time::TIMESTAMP) / ?) * ?)", []interface{}{stepSec, stepSec}
+	}
+	offset, args := offsetExpr(b.Loc, from, to)
+	stepDays := int64(b.Step / day)
+	// Local day number, shifted so weeks begin on Monday (day 0 was a Thursday)
+	expr := "CAST(floor((floor((epoch(time::TIMESTAMP) + " + offset + ") / 86400) + ?) / ?) AS BIGINT)"
+	return expr, append(args, b.align(), stepDays)
+}
+
+// dest returns where to scan the bucket column and how to read it back
+func (b Bucketing) dest() (interface{}, func() time.Time) {
+	if b.Loc == nil {
+		var t time.Time
+		return &t, func() time.Time { return t }
+	}
+	var key int64
+	return &key, func() time.Time { return b.start(key) }
+}
+
+func (b Bucketing) align() int64 {
+	if b.Step%(7*day) == 0 {
+		return 3
+	}
+	return 0
+}
+
+// start is the local midnight that begins bucket key
+func (b Bucketing) start(key int64) time.Time {
+	firstDay := key*int64(b.Step/day) - b.align()
+	return time.Date(1970, 1, 1+int(firstDay), 0, 0, 0, 0, b.Loc)
+}
+
+// offsetExpr is a SQL expression for loc's UTC offset in seconds at each
+// row's time, with one branch per offset change in [from, to)
+func offsetExpr(loc *time.Location, from, to time.Time) (string, []interface{}) {
+	var sb strings.Builder
+	var args []interface{}
+	sb.WriteString("CASE")
+	t := from
+	for {
+		_, offset := t.In(loc).Zone()
+		_, end := t.In(loc).ZoneBounds()
+		if end.IsZero() || !end.Before(to) {
+			fmt.Fprintf(&sb, " ELSE %d END", offset)
+			return sb.String(), args
+		}
+		fmt.Fprintf(&sb, " WHEN time < ? THEN %d", offset)
+		args = append(args, end)
+		t = end
+	}
+}
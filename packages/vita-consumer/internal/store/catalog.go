@@ -0,0 +1,273 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// catalogKinds maps catalog tables to the kind reported in change feeds
+var catalogKinds = []struct{ table, kind string }{
+	{"namespaces", "namespace"},
+	{"nodes", "node"},
+	{"deployments", "deployment"},
+	{"statefulsets", "statefulset"},
+	{"daemonsets", "daemonset"},
+	{"pods", "pod"},
+	{"pvcs", "pvc"},
+	{"persistent_volumes", "pv"},
+	{"storage_classes", "storageclass"},
+	{"pdbs", "pdb"},
+	{"services", "service"},
+	{"ingresses", "ingress"},
+}
+
+// TableKind returns the kind of a catalog table's rows, as series and
+// change feeds record it
+func TableKind(table string) string {
+	for _, c := range catalogKinds {
+		if c.table == table {
+			return c.kind
+		}
+	}
+	return ""
+}
+
+// KindTable returns the catalog table of a kind, "" for kinds without one
+func KindTable(kind string) string {
+	for _, c := range catalogKinds {
+		if c.kind == kind {
+			return c.table
+		}
+	}
+	return ""
+}
+
+// installChangeLog (re)creates the triggers that append to catalog_changes.
+// Each resource keeps only its latest change, so the log stays as large as
+// the catalog plus tombstones. Updates that only touch updated_at (informer
+// resyncs) are not logged.
+func installChangeLog(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS catalog_changes (
+            seq INTEGER PRIMARY KEY AUTOINCREMENT,
+            kind TEXT NOT NULL,
+            resource_id INTEGER NOT NULL,
+            deleted INTEGER NOT NULL DEFAULT 0
+        );
+        CREATE UNIQUE INDEX IF NOT EXISTS idx_catalog_changes_resource ON catalog_changes(kind, resource_id);`); err != nil {
+		return err
+	}
+
+	for _, c := range catalogKinds {
+		columns, err := tableColumns(db, c.table)
+		if err != nil {
+			return err
+		}
+		var changed []string
+		for _, col := range columns {
+			if col != "updated_at" {
+				changed = append(changed, fmt.Sprintf("OLD.%s IS NOT NEW.%s", col, col))
+			}
+		}
+
+		// Drop the resource's previous entry, so its seq moves forward. Not
+		// INSERT OR REPLACE: the upserts' conflict handling would override it.
+		log := func(ref string, deleted int) string {
+			return fmt.Sprintf(`DELETE FROM catalog_changes WHERE kind = '%s' AND resource_id = %s.id;
+				INSERT INTO catalog_changes (kind, resource_id, deleted) VALUES ('%s', %s.id, %d);`, c.kind, ref, c.kind, ref, deleted)
+		}
+		stmts := []string{
+			fmt.Sprintf("DROP TRIGGER IF EXISTS catalog_%s_insert", c.table),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS catalog_%s_update", c.table),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS catalog_%s_delete", c.table),
+			fmt.Sprintf("CREATE TRIGGER catalog_%s_insert AFTER INSERT ON %s BEGIN %s END", c.table, c.table, log("NEW", 0)),
+			fmt.Sprintf("CREATE TRIGGER catalog_%s_update AFTER UPDATE ON %s WHEN %s BEGIN %s END", c.table, c.table, strings.Join(changed, " OR "), log("NEW", 0)),
+			fmt.Sprintf("CREATE TRIGGER catalog_%s_delete AFTER DELETE ON %s BEGIN %s END", c.table, c.table, log("OLD", 1)),
+			// Rows that predate the change log
+			fmt.Sprintf(`INSERT INTO catalog_changes (kind, resource_id)
+				SELECT '%s', id FROM %s WHERE id NOT IN (SELECT resource_id FROM catalog_changes WHERE kind = '%s')`, c.kind, c.table, c.kind),
+		}
+		for _, q := range stmts {
+			if _, err := db.Exec(q); err != nil {
+				return fmt.Errorf("change log for %s: %w", c.table, err)
+			}
+		}
+	}
+	return nil
+}
+
+func tableColumns(db *sql.DB, table string) ([]string, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		out = append(out, name)
+	}
+	return out, rows.Err()
+}
+
+// CatalogGeneration returns the seq of the latest catalog change. It grows
+// with every change, so two reads seeing the same generation saw the same
+// catalog; it matches the seq of /api/v1/catalog.
+func (s *SQLiteStore) CatalogGeneration() (int64, error) {
+	var gen int64
+	err := s.db.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM catalog_changes").Scan(&gen)
+	return gen, err
+}
+
+// ReadCatalog runs fn in one read transaction, so everything it reads is
+// from a single catalog generation, which it returns
+func (s *SQLiteStore) ReadCatalog(fn func(tx *sql.Tx) error) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// The first read fixes the snapshot the rest of the transaction sees
+	var gen int64
+	if err := tx.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM catalog_changes").Scan(&gen); err != nil {
+		return 0, err
+	}
+	if err := fn(tx); err != nil {
+		return 0, err
+	}
+	return gen, tx.Commit()
+}
+
+// CatalogChange is the latest change to one catalog resource. Resource holds
+// the row's columns and is nil for deletions; Source is who wrote the row.
+type CatalogChange struct {
+	Seq      int64          `json:"seq"`
+	Kind     string         `json:"kind"`
+	ID       int64          `json:"id"`
+	Deleted  bool           `json:"deleted"`
+	Resource map[string]any `json:"resource,omitempty"`
+	Source   *Source        `json:"source,omitempty"`
+}
+
+// CatalogChanges returns up to limit changes with a sequence above since, in
+// order, and whether more are pending. Changes and rows are read in one
+// transaction so a page is consistent.
+func (s *SQLiteStore) CatalogChanges(since int64, limit int) ([]CatalogChange, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT seq, kind, resource_id, deleted FROM catalog_changes
+		WHERE seq > ? ORDER BY seq LIMIT ?`, since, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	changes := []CatalogChange{}
+	for rows.Next() {
+		var c CatalogChange
+		if err := rows.Scan(&c.Seq, &c.Kind, &c.ID, &c.Deleted); err != nil {
+			rows.Close()
+			return nil, false, err
+		}
+		changes = append(changes, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	more := len(changes) > limit
+	if more {
+		changes = changes[:limit]
+	}
+
+	// Attach current rows, one query per kind
+	byKind := map[string][]int{}
+	for i, c := range changes {
+		if !c.Deleted {
+			byKind[c.Kind] = append(byKind[c.Kind], i)
+		}
+	}
+	for _, k := range catalogKinds {
+		idx := byKind[k.kind]
+		if len(idx) == 0 {
+			continue
+		}
+		ids := make([]any, len(idx))
+		at := make(map[int64]int, len(idx))
+		for j, i := range idx {
+			ids[j] = changes[i].ID
+			at[changes[i].ID] = i
+		}
+		resources, err := scanMaps(tx, fmt.Sprintf("SELECT * FROM %s WHERE id IN (%s)",
+			k.table, strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")), ids...)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, r := range resources {
+			if id, ok := r["id"].(int64); ok {
+				changes[at[id]].Resource = r
+			}
+		}
+	}
+
+	var sourceIDs []int64
+	for _, c := range changes {
+		if id, ok := c.Resource["source_id"].(int64); ok {
+			sourceIDs = append(sourceIDs, id)
+		}
+	}
+	sources, err := sourcesByID(tx, sourceIDs)
+	if err != nil {
+		return nil, false, err
+	}
+	for i, c := range changes {
+		if id, ok := c.Resource["source_id"].(int64); ok {
+			if src, ok := sources[id]; ok {
+				changes[i].Source = &src
+			}
+		}
+	}
+	return changes, more, nil
+}
+
+// scanMaps reads rows into column -> value maps
+func scanMaps(tx *sql.Tx, query string, args ...any) ([]map[string]any, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var out []map[string]any
+	for rows.Next() {
+		vals := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		m := make(map[string]any, len(cols))
+		for i, c := range cols {
+			if b, ok := vals[i].([]byte); ok {
+				vals[i] = string(b)
+			}
+			m[c] = vals[i]
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
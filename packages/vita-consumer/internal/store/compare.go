@@ -0,0 +1,37 @@
+package store
+
+import "fmt"
+
+// nameTables are the catalog tables ResourceNames reads
+var nameTables = map[string]bool{
+	"pods": true, "pvcs": true, "nodes": true, "namespaces": true,
+	"deployments": true, "statefulsets": true, "daemonsets": true,
+}
+
+// ResourceNames returns the names of ids in a catalog table. Unknown ids
+// are left out.
+func (s *SQLiteStore) ResourceNames(table string, ids []int64) (map[int64]string, error) {
+	if !nameTables[table] {
+		return nil, fmt.Errorf("unknown table %q", table)
+	}
+	out := make(map[int64]string, len(ids))
+	if len(ids) == 0 {
+		return out, nil
+	}
+	marks, args := inArgs(ids)
+	rows, err := s.db.Query(fmt.Sprintf("SELECT id, name FROM %s WHERE id IN (%s)", table, marks), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return nil, err
+		}
+		out[id] = name
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,257 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// FKViolation is a catalog row whose foreign key names a missing row
+type FKViolation struct {
+	Table    string
+	RowID    int64 // 0 for tables without rowids
+	Column   string
+	Parent   string
+	Nullable bool
+}
+
+// ForeignKeyViolations lists the rows whose references are broken, e.g.
+// pods of a node or namespace row lost to a partial write
+func (s *SQLiteStore) ForeignKeyViolations() ([]FKViolation, error) {
+	rows, err := s.db.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return nil, err
+	}
+	type check struct {
+		table, parent string
+		rowid         sql.NullInt64
+		fkid          int
+	}
+	var checks []check
+	for rows.Next() {
+		var c check
+		if err := rows.Scan(&c.table, &c.rowid, &c.parent, &c.fkid); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		checks = append(checks, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Column and nullability of each table's foreign keys, looked up once
+	type key struct {
+		table string
+		fkid  int
+	}
+	columns := make(map[key]FKViolation)
+	out := make([]FKViolation, 0, len(checks))
+	for _, c := range checks {
+		k := key{c.table, c.fkid}
+		v, ok := columns[k]
+		if !ok {
+			if v.Column, err = foreignKeyColumn(s.db, c.table, c.fkid); err != nil {
+				return nil, err
+			}
+			if v.Nullable, err = columnNullable(s.db, c.table, v.Column); err != nil {
+				return nil, err
+			}
+			columns[k] = v
+		}
+		v.Table, v.Parent, v.RowID = c.table, c.parent, c.rowid.Int64
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func foreignKeyColumn(db *sql.DB, table string, fkid int) (string, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA foreign_key_list(%q)", table))
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return "", err
+		}
+		row := make(map[string]interface{}, len(cols))
+		for i, c := range cols {
+			row[c] = vals[i]
+		}
+		if id, _ := row["id"].(int64); int(id) == fkid {
+			from, _ := row["from"].(string)
+			return from, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("foreign key %d of %s not found", fkid, table)
+}
+
+func columnNullable(db *sql.DB, table, column string) (bool, error) {
+	var notNull bool
+	err := db.QueryRow(fmt.Sprintf("SELECT \"notnull\" FROM pragma_table_info(%s) WHERE name = ?", quoteLiteral(table)), column).Scan(&notNull)
+	return !notNull, err
+}
+
+// ClearReference sets a broken nullable reference to NULL, leaving the
+// syncer to link the row again
+func (s *SQLiteStore) ClearReference(v FKViolation) error {
+	if !v.Nullable || v.RowID == 0 {
+		return fmt.Errorf("%s.%s cannot be cleared", v.Table, v.Column)
+	}
+	_, err := s.db.Exec(fmt.Sprintf("UPDATE %q SET %q = NULL WHERE rowid = ?", v.Table, v.Column), v.RowID)
+	return err
+}
+
+// Quarantine moves a catalog row into the quarantine table, as JSON with
+// the reason, so it can be inspected or restored by hand. Rows of its
+// children that cascade are deleted with it.
+func (s *SQLiteStore) Quarantine(table string, rowid int64, reason string, now time.Time) error {
+	rows, err := s.db.Query(fmt.Sprintf("SELECT * FROM %q WHERE rowid = ?", table), rowid)
+	if err != nil {
+		return err
+	}
+	cols, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		return err
+	}
+	row := make(map[string]interface{}, len(cols))
+	if rows.Next() {
+		vals := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			rows.Close()
+			return err
+		}
+		for i, c := range cols {
+			if b, ok := vals[i].([]byte); ok {
+				vals[i] = string(b)
+			}
+			row[c] = vals[i]
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(row) == 0 {
+		return nil // already gone
+	}
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO quarantine (time, table_name, row_id, reason, data) VALUES (?, ?, ?, ?, ?)`,
+		now.UTC(), table, rowid, reason, string(data)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %q WHERE rowid = ?", table), rowid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// QuarantinedRow is a catalog row set aside by the consistency check
+type QuarantinedRow struct {
+	Time   time.Time       `json:"time"`
+	Table  string          `json:"table"`
+	RowID  int64           `json:"row_id"`
+	Reason string          `json:"reason"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// ListQuarantine returns quarantined rows, newest first
+func (s *SQLiteStore) ListQuarantine(limit int) ([]QuarantinedRow, error) {
+	rows, err := s.db.Query(`SELECT time, table_name, row_id, reason, data FROM quarantine ORDER BY time DESC, id DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []QuarantinedRow{}
+	for rows.Next() {
+		var q QuarantinedRow
+		var data string
+		if err := rows.Scan(&q.Time, &q.Table, &q.RowID, &q.Reason, &data); err != nil {
+			return nil, err
+		}
+		q.Data = json.RawMessage(data)
+		out = append(out, q)
+	}
+	return out, rows.Err()
+}
+
+// ResourceIDSet returns the ids of a catalog table
+func (s *SQLiteStore) ResourceIDSet(table string) (map[int64]bool, error) {
+	ids, err := s.ids(fmt.Sprintf("SELECT id FROM %q", table))
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		out[id] = true
+	}
+	return out, nil
+}
+
+// SeriesResources returns the resources that have series in any file, by
+// kind. Series of unknown kind (see backfillSeriesKinds) are left out.
+func (s *DuckDBStore) SeriesResources(ctx context.Context) (map[string][]int64, error) {
+	type resource struct {
+		kind string
+		id   int64
+	}
+	seen := make(map[resource]bool)
+	out := make(map[string][]int64)
+	for _, table := range s.partitions("series") {
+		rows, err := s.db.QueryContext(ctx, "SELECT DISTINCT resource_kind, resource_id FROM "+table+" WHERE resource_kind IS NOT NULL")
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var r resource
+			if err := rows.Scan(&r.kind, &r.id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !seen[r] {
+				seen[r] = true
+				out[r.kind] = append(out[r.kind], r.id)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// DeleteEmptySeries removes series left without points
+func (s *DuckDBStore) DeleteEmptySeries() (int64, error) {
+	return s.execAll("series", "DELETE FROM {t} WHERE id NOT IN (SELECT DISTINCT series_id FROM {points})")
+}
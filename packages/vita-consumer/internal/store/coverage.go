@@ -0,0 +1,137 @@
+package store
+
+import "fmt"
+
+// SetPodStatus records a pod's phase and whether all its containers are ready
+func (s *SQLiteStore) SetPodStatus(id int64, phase string, ready bool) error {
+	_, err := s.db.Exec(`UPDATE pods SET phase = ?, ready = ? WHERE id = ?`, phase, ready, id)
+	return err
+}
+
+// SetDaemonSetDesired records how many nodes a DaemonSet should run on,
+// i.e. those its node selector and tolerations admit
+func (s *SQLiteStore) SetDaemonSetDesired(id int64, desired int32) error {
+	_, err := s.db.Exec(`UPDATE daemonsets SET desired_scheduled = ? WHERE id = ?`, desired, id)
+	return err
+}
+
+// MarkDeleted flags retained pod or node rows as gone from the cluster.
+// Nodes are matched by name (column "name"), as pods may have created a
+// stub row for them before the node itself was seen.
+func (s *SQLiteStore) MarkDeleted(table, column, value string) error {
+	_, err := s.db.Exec(fmt.Sprintf(`UPDATE %s SET deleted_at = CURRENT_TIMESTAMP
+		WHERE %s = ? AND deleted_at IS NULL`, table, column), value)
+	return err
+}
+
+// MarkDeletedExcept flags every row of table whose column value is not in
+// live as gone, for deletions missed while the consumer was down
+func (s *SQLiteStore) MarkDeletedExcept(table, column string, live map[string]bool) (int64, error) {
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT id, %s FROM %s WHERE deleted_at IS NULL`, column, table))
+	if err != nil {
+		return 0, err
+	}
+	var gone []int64
+	for rows.Next() {
+		var id int64
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !live[key] {
+			gone = append(gone, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// Chunked to stay under SQLite's bound variable limit
+	for start := 0; start < len(gone); start += 500 {
+		end := min(start+500, len(gone))
+		marks, args := inArgs(gone[start:end])
+		if _, err := s.db.Exec(fmt.Sprintf(`UPDATE %s SET deleted_at = CURRENT_TIMESTAMP WHERE id IN (%s)`, table, marks), args...); err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(gone)), nil
+}
+
+// DaemonSetInfo is a DaemonSet and how many nodes it should cover
+type DaemonSetInfo struct {
+	ID        int64
+	Name      string
+	Namespace string
+	Desired   *int32
+}
+
+// GetDaemonSet returns sql.ErrNoRows for unknown ids
+func (s *SQLiteStore) GetDaemonSet(id int64) (DaemonSetInfo, error) {
+	d := DaemonSetInfo{ID: id}
+	err := s.db.QueryRow(`SELECT d.name, n.name, d.desired_scheduled FROM daemonsets d
+		JOIN namespaces n ON d.namespace_id = n.id
+		WHERE d.id = ?`, id).Scan(&d.Name, &d.Namespace, &d.Desired)
+	return d, err
+}
+
+// NodePod is a live node and the DaemonSet pods on it, if any
+type NodePod struct {
+	NodeID   int64
+	NodeName string
+	PodID    *int64
+	PodName  *string
+	Phase    *string
+	Ready    bool
+}
+
+// DaemonSetCoverage lists every node not known to be deleted, with each
+// live pod of the DaemonSet on it; nodes without one have a nil PodID
+func (s *SQLiteStore) DaemonSetCoverage(dsID int64) ([]NodePod, error) {
+	rows, err := s.db.Query(`SELECT n.id, n.name, p.id, p.name, p.phase, COALESCE(p.ready, 0)
+		FROM nodes n
+		LEFT JOIN pods p ON p.node_id = n.id AND p.daemonset_id = ? AND p.deleted_at IS NULL
+		WHERE n.deleted_at IS NULL
+		ORDER BY n.name, p.id`, dsID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []NodePod
+	for rows.Next() {
+		var np NodePod
+		if err := rows.Scan(&np.NodeID, &np.NodeName, &np.PodID, &np.PodName, &np.Phase, &np.Ready); err != nil {
+			return nil, err
+		}
+		out = append(out, np)
+	}
+	return out, rows.Err()
+}
+
+// IDsByKey maps the key column (uid or name) of a catalog table to row
+// ids, leaving out pods and nodes known to be deleted. Where names repeat,
+// the newest row wins.
+func (s *SQLiteStore) IDsByKey(table, key string) (map[string]int64, error) {
+	query := fmt.Sprintf("SELECT %s, id FROM %s", key, table)
+	if table == "pods" || table == "nodes" {
+		query += " WHERE deleted_at IS NULL"
+	}
+	rows, err := s.db.Query(query + " ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]int64)
+	for rows.Next() {
+		var k string
+		var id int64
+		if err := rows.Scan(&k, &id); err != nil {
+			return nil, err
+		}
+		out[k] = id
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,51 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// NodeRow is one catalog row of a node. A node may have several: the real
+// one and a stub pods created before the node was first seen.
+type NodeRow struct {
+	ID               int64
+	Name             string
+	DeletedAt        *time.Time
+	DecommissionedAt *time.Time
+}
+
+func (s *SQLiteStore) NodeRows() ([]NodeRow, error) {
+	rows, err := s.db.Query("SELECT id, name, deleted_at, decommissioned_at FROM nodes ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []NodeRow
+	for rows.Next() {
+		var n NodeRow
+		var deleted, decommissioned sql.NullTime
+		if err := rows.Scan(&n.ID, &n.Name, &deleted, &decommissioned); err != nil {
+			return nil, err
+		}
+		if deleted.Valid {
+			n.DeletedAt = &deleted.Time
+		}
+		if decommissioned.Valid {
+			n.DecommissionedAt = &decommissioned.Time
+		}
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+// MarkDecommissioned hides node rows from node lists and filters
+func (s *SQLiteStore) MarkDecommissioned(ids []int64, at time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	marks, args := inArgs(ids)
+	_, err := s.db.Exec(fmt.Sprintf("UPDATE nodes SET decommissioned_at = ? WHERE id IN (%s)", marks), append([]interface{}{at.UTC()}, args...)...)
+	return err
+}
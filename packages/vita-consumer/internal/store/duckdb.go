@@ -1,80 +1,735 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/marcboeker/go-duckdb"
 )
 
 type DuckDBStore struct {
-	db *sql.DB
+	db   *sql.DB
+	path string
+
+	// monthly is set when data is split into one file per month (see
+	// NewMonthlyDuckDBStore)
+	monthly *monthSet
+	sources duckSources
+	// seriesMu serializes writes of points, which may add series (see
+	// assignSeries)
+	seriesMu sync.Mutex
 }
 
 type MetricPoint struct {
 	Time       time.Time
 	ResourceID int64
-	MetricType string
-	Value      float64
+	// ResourceKind is what ResourceID refers to: pod, pvc, deployment, or
+	// the group of a recording rule. Empty for series written before kinds
+	// were recorded whose kind could not be told from their type.
+	ResourceKind string
+	MetricType   string
+	Value        float64
+	SourceID     int64 // see RegisterSource; 0 when unknown
+	SampleRate   int   // the series kept 1 in SampleRate points; 0 when unsampled
 }
 
 func NewDuckDBStore(path string) (*DuckDBStore, error) {
-	db, err := sql.Open("duckdb", path)
+	db, err := openDB("duckdb", "duckdb", path)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := initDuckDBSchema(db); err != nil {
+	if err := initDuckDBSchema(db, ""); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec("CREATE OR REPLACE VIEW metrics AS " + metricsView("")); err != nil {
 		return nil, err
 	}
 
-	return &DuckDBStore{db: db}, nil
+	return &DuckDBStore{db: db, path: path}, nil
 }
 
-func initDuckDBSchema(db *sql.DB) error {
+// duckTables are the tables of a metrics database read through views of
+// the same name; points are read through the metrics view (see
+// metricsView)
+var duckTables = []string{"node_totals", "process_samples", "sources"}
+
+// initDuckDBSchema creates the tables, in the attached database named by
+// prefix (e.g. "m_2024_06.") if set, and moves points out of a metrics
+// table written before series were split out
+func initDuckDBSchema(db *sql.DB, prefix string) error {
 	query := `
-    CREATE TABLE IF NOT EXISTS metrics (
-        time TIMESTAMPTZ NOT NULL,
+    -- One row per (resource, metric type); ids are local to the file.
+    -- resource_kind (added below) says which catalog table resource_id is
+    -- from, NULL when a series predates it and its type does not tell.
+    CREATE TABLE IF NOT EXISTS {p}series (
+        id INTEGER NOT NULL,
         resource_id INTEGER NOT NULL,
-        metric_type TEXT NOT NULL,
+        metric_type TEXT NOT NULL
+    );
+
+    -- Points of series; sample_rate is set on points kept while ingest
+    -- sampled their series: 1 in sample_rate points was stored. NULL means
+    -- every point was.
+    CREATE TABLE IF NOT EXISTS {p}points (
+        time TIMESTAMPTZ NOT NULL,
+        series_id INTEGER NOT NULL,
         value DOUBLE NOT NULL,
-        agg_type TEXT DEFAULT 'raw'
+        agg_type TEXT DEFAULT 'raw',
+        source_id BIGINT,
+        sample_rate INTEGER
+    );
+
+    -- Per-node (node_id 0 = cluster) usage, one row per minute
+    CREATE TABLE IF NOT EXISTS {p}node_totals (
+        time TIMESTAMPTZ NOT NULL,
+        node_id INTEGER NOT NULL,
+        metric_type TEXT NOT NULL,
+        value DOUBLE NOT NULL
     );
+
+    -- Where rows of this file came from (see RegisterSource); time is when
+    -- a source first wrote to the file
+    CREATE TABLE IF NOT EXISTS {p}sources (
+        time TIMESTAMPTZ NOT NULL,
+        id BIGINT NOT NULL,
+        cluster_id TEXT NOT NULL,
+        instance_id TEXT NOT NULL,
+        agent_version TEXT NOT NULL
+    );
+
+    -- Top processes per pod, only written when process metrics are enabled
+    CREATE TABLE IF NOT EXISTS {p}process_samples (
+        time TIMESTAMPTZ NOT NULL,
+        pod_id INTEGER NOT NULL,
+        container_id TEXT NOT NULL,
+        pid BIGINT NOT NULL,
+        name TEXT NOT NULL,
+        cpu_ms DOUBLE NOT NULL,
+        mem_mb DOUBLE NOT NULL
+    );
+
+    -- Columns added after the initial schema, last so every file's tables
+    -- line up for the views unioning them
+    ALTER TABLE {p}node_totals ADD COLUMN IF NOT EXISTS source_id BIGINT;
+    ALTER TABLE {p}process_samples ADD COLUMN IF NOT EXISTS source_id BIGINT;
+    ALTER TABLE {p}series ADD COLUMN IF NOT EXISTS resource_kind TEXT;
     `
-	_, err := db.Exec(query)
-	return err
+	if _, err := db.Exec(strings.ReplaceAll(query, "{p}", prefix)); err != nil {
+		return err
+	}
+	if err := migrateSeries(db, prefix); err != nil {
+		return err
+	}
+	return backfillSeriesKinds(db, prefix)
 }
 
 func (s *DuckDBStore) Close() error {
 	return s.db.Close()
 }
 
+// Path returns the database file location, or the directory of monthly
+// files
+func (s *DuckDBStore) Path() string {
+	return s.path
+}
+
+// SetResourceLimits caps DuckDB's worker threads and memory, e.g. "64MB".
+// Queries that need more memory spill to disk or fail instead of growing
+// the process.
+func (s *DuckDBStore) SetResourceLimits(threads int, memoryLimit string) error {
+	if _, err := s.db.Exec(fmt.Sprintf("SET threads = %d", threads)); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(fmt.Sprintf("SET memory_limit = '%s'", memoryLimit))
+	return err
+}
+
+// Checkpoint refreshes table statistics and forces the WAL into the main
+// file so blocks freed by deletes can be reused.
+func (s *DuckDBStore) Checkpoint() error {
+	if _, err := s.db.Exec("ANALYZE"); err != nil {
+		return err
+	}
+	return s.checkpoint(true)
+}
+
+// BatchInsert stores points, skipping any whose (time, resource kind and
+// id, metric_type) is already stored or repeated earlier in the batch, so
+// replays and retried writes don't double-count in rate and sum queries.
+// The first write of a key wins. Points may arrive in any order and across
+// any number of batches; queries order by time themselves.
 func (s *DuckDBStore) BatchInsert(metrics []MetricPoint) error {
+	metrics = dedupPoints(metrics)
 	if len(metrics) == 0 {
 		return nil
 	}
+	tables, err := byTable(s, "points", metrics, func(m MetricPoint) time.Time { return m.Time })
+	if err != nil {
+		return err
+	}
+	for table, points := range tables {
+		if err := s.insertPoints(table, points); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertPoints stores deduplicated points in one points table
+func (s *DuckDBStore) insertPoints(table string, metrics []MetricPoint) error {
+	s.seriesMu.Lock()
+	defer s.seriesMu.Unlock()
 
-	// DuckDB appender API is faster, but for now simple batch INSERT is fine
-	// Or transaction.
 	tx, err := s.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	// Prepared statement
-	stmt, err := tx.Prepare("INSERT INTO metrics (time, resource_id, metric_type, value, agg_type) VALUES (?, ?, ?, ?, 'raw')")
+	// Stage the batch, then insert only keys not stored yet. The time
+	// bounds let DuckDB skip row groups outside the batch's range.
+	if _, err := tx.Exec(`CREATE OR REPLACE TEMP TABLE metrics_staging (
+        time TIMESTAMPTZ NOT NULL,
+        resource_kind TEXT,
+        resource_id INTEGER NOT NULL,
+        metric_type TEXT NOT NULL,
+        value DOUBLE NOT NULL,
+        source_id BIGINT,
+        sample_rate INTEGER
+    )`); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO metrics_staging (time, resource_kind, resource_id, metric_type, value, source_id, sample_rate) VALUES (?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
+	from, to := metrics[0].Time, metrics[0].Time
+	stamp := s.stamper()
+	sources := map[int64]bool{}
 	for _, m := range metrics {
-		_, err := stmt.Exec(m.Time, m.ResourceID, m.MetricType, m.Value)
+		src := stamp(m.SourceID)
+		if _, err := stmt.Exec(m.Time, nullIfEmpty(m.ResourceKind), m.ResourceID, m.MetricType, m.Value, src, sampleRate(m.SampleRate)); err != nil {
+			return err
+		}
+		if id, ok := src.(int64); ok {
+			sources[id] = true
+		}
+		if m.Time.Before(from) {
+			from = m.Time
+		}
+		if m.Time.After(to) {
+			to = m.Time
+		}
+	}
+
+	series := seriesTable(table)
+	if err := assignSeries(tx, series); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+        INSERT INTO `+table+` (time, series_id, value, agg_type, source_id, sample_rate)
+        SELECT s.time, d.id, s.value, 'raw', s.source_id, s.sample_rate
+        FROM metrics_staging s
+        JOIN `+series+` d ON d.resource_id = s.resource_id AND d.metric_type = s.metric_type
+            AND d.resource_kind IS NOT DISTINCT FROM s.resource_kind
+        WHERE NOT EXISTS (
+            SELECT 1 FROM `+table+` p
+            WHERE p.time >= ? AND p.time <= ?
+              AND p.time = s.time AND p.series_id = d.id
+        )`, from, to); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DROP TABLE metrics_staging"); err != nil {
+		return err
+	}
+	listed, err := s.listSources(tx, table, sources, from)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	listed()
+	return nil
+}
+
+// nullIfEmpty stores an unknown resource kind as NULL
+func nullIfEmpty(kind string) interface{} {
+	if kind == "" {
+		return nil
+	}
+	return kind
+}
+
+// sampleRate stores unsampled points' rate as NULL
+func sampleRate(n int) interface{} {
+	if n <= 1 {
+		return nil
+	}
+	return n
+}
+
+// dedupPoints drops repeats of a (time, resource, metric_type) key,
+// keeping the first
+func dedupPoints(metrics []MetricPoint) []MetricPoint {
+	type pointKey struct {
+		time         int64
+		resourceKind string
+		resourceID   int64
+		metricType   string
+	}
+	seen := make(map[pointKey]struct{}, len(metrics))
+	out := metrics[:0:0]
+	for _, m := range metrics {
+		// Microseconds, the resolution DuckDB stores
+		k := pointKey{m.Time.UnixMicro(), m.ResourceKind, m.ResourceID, m.MetricType}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		out = append(out, m)
+	}
+	return out
+}
+
+// DeleteResourcePoints removes every point of the given resources of one
+// kind, whatever their metric type, returning how many were removed
+func (s *DuckDBStore) DeleteResourcePoints(kind string, ids []int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	marks, args := inArgs(ids)
+	return s.execAll("points", fmt.Sprintf("DELETE FROM {t} WHERE series_id IN (SELECT id FROM {series} WHERE resource_kind = ? AND resource_id IN (%s))", marks),
+		append([]interface{}{kind}, args...)...)
+}
+
+// DeleteLegacyPoints removes the points of the given types of series of
+// unknown kind, written before series recorded one (see
+// backfillSeriesKinds), for the given resources
+func (s *DuckDBStore) DeleteLegacyPoints(ids []int64, types []string) (int64, error) {
+	if len(ids) == 0 || len(types) == 0 {
+		return 0, nil
+	}
+	marks, args := inArgs(ids)
+	for _, t := range types {
+		args = append(args, t)
+	}
+	return s.execAll("points", fmt.Sprintf("DELETE FROM {t} WHERE series_id IN (SELECT id FROM {series} WHERE resource_kind IS NULL AND resource_id IN (%s) AND metric_type IN (%s))",
+		marks, strings.TrimSuffix(strings.Repeat("?,", len(types)), ",")), args...)
+}
+
+// DeleteNodeTotals removes a node's rollups
+func (s *DuckDBStore) DeleteNodeTotals(nodeID int64) (int64, error) {
+	return s.execAll("node_totals", "DELETE FROM {t} WHERE node_id = ?", nodeID)
+}
+
+// LatestNodeTotals returns when each node last had a rollup, i.e. when its
+// pods last reported
+func (s *DuckDBStore) LatestNodeTotals(ctx context.Context) (map[int64]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT node_id, max(time) FROM node_totals WHERE node_id != 0 GROUP BY node_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]time.Time)
+	for rows.Next() {
+		var id int64
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			return nil, err
+		}
+		out[id] = at
+	}
+	return out, rows.Err()
+}
+
+// DeleteBefore removes all points older than t and checkpoints so the
+// freed blocks can be reused. With monthly files, months entirely before t
+// are removed as whole files. Returns the number of rows removed.
+func (s *DuckDBStore) DeleteBefore(t time.Time) (int64, error) {
+	var n int64
+	if s.monthly != nil {
+		dropped, err := s.dropMonthsBefore(t)
 		if err != nil {
+			return dropped, err
+		}
+		n = dropped
+	}
+	deleted, err := s.execAll("points", "DELETE FROM {t} WHERE time < ?", t)
+	n += deleted
+	if err != nil {
+		return n, err
+	}
+	if _, err := s.execAll("series", "DELETE FROM {t} WHERE id NOT IN (SELECT DISTINCT series_id FROM {points})"); err != nil {
+		return n, err
+	}
+
+	if _, err := s.execAll("node_totals", "DELETE FROM {t} WHERE time < ?", t); err != nil {
+		return n, err
+	}
+	if _, err := s.DeleteProcessesBefore(t); err != nil {
+		return n, err
+	}
+
+	if err := s.checkpoint(false); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// kindFilter narrows a query on metrics to series of kind, IDs being
+// unique only within a kind. Series written before series recorded their
+// kind match every kind, and so does an empty kind.
+func kindFilter(kind string) (string, []interface{}) {
+	if kind == "" {
+		return "", nil
+	}
+	return " AND (resource_kind = ? OR resource_kind IS NULL)", []interface{}{kind}
+}
+
+// QuerySeries returns raw points for one resource of kind and a set of
+// metric types in [from, to), ordered by time.
+func (s *DuckDBStore) QuerySeries(ctx context.Context, kind string, resourceID int64, types []string, from, to time.Time) ([]MetricPoint, error) {
+	if len(types) == 0 {
+		return []MetricPoint{}, nil
+	}
+
+	filter, kindArgs := kindFilter(kind)
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(types)), ",")
+	query := `SELECT time, resource_id, metric_type, value, coalesce(source_id, 0), coalesce(sample_rate, 0) FROM metrics
+		WHERE resource_id = ? AND metric_type IN (` + placeholders + `) AND time >= ? AND time < ?` + filter + `
+		ORDER BY time`
+
+	args := []interface{}{resourceID}
+	for _, t := range types {
+		args = append(args, t)
+	}
+	args = append(append(args, from, to), kindArgs...)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []MetricPoint{}
+	for rows.Next() {
+		p := MetricPoint{ResourceKind: kind}
+		if err := rows.Scan(&p.Time, &p.ResourceID, &p.MetricType, &p.Value, &p.SourceID, &p.SampleRate); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// CountSeries returns how many raw points one series has over [from, to)
+func (s *DuckDBStore) CountSeries(ctx context.Context, resourceID int64, metricType string, from, to time.Time) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM metrics
+		WHERE resource_id = ? AND metric_type = ? AND time >= ? AND time < ?`, resourceID, metricType, from, to).Scan(&n)
+	return n, err
+}
+
+// SeriesSampleRate returns the largest sample rate among resources'
+// points of the given types in [from, to), or 0 when none was sampled
+func (s *DuckDBStore) SeriesSampleRate(ctx context.Context, ids []int64, types []string, from, to time.Time) (int, error) {
+	if len(ids) == 0 || len(types) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, 0, len(ids)+len(types)+2)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	for _, t := range types {
+		args = append(args, t)
+	}
+	args = append(args, from, to)
+
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT coalesce(max(sample_rate), 0) FROM metrics
+		WHERE resource_id IN (`+strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")+`) AND metric_type IN (`+strings.TrimSuffix(strings.Repeat("?,", len(types)), ",")+`)
+		  AND time >= ? AND time < ?`, args...).Scan(&n)
+	return n, err
+}
+
+// QuerySeriesPage returns at most limit raw points of one series of kind
+// over [from, to), oldest first
+func (s *DuckDBStore) QuerySeriesPage(ctx context.Context, kind string, resourceID int64, metricType string, from, to time.Time, limit int) ([]MetricPoint, error) {
+	filter, kindArgs := kindFilter(kind)
+	args := append(append([]interface{}{resourceID, metricType, from, to}, kindArgs...), limit)
+	rows, err := s.db.QueryContext(ctx, `SELECT time, resource_id, metric_type, value, coalesce(source_id, 0), coalesce(sample_rate, 0) FROM metrics
+		WHERE resource_id = ? AND metric_type = ? AND time >= ? AND time < ?`+filter+`
+		ORDER BY time LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []MetricPoint{}
+	for rows.Next() {
+		p := MetricPoint{ResourceKind: kind}
+		if err := rows.Scan(&p.Time, &p.ResourceID, &p.MetricType, &p.Value, &p.SourceID, &p.SampleRate); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// bucketAggs maps supported aggregation names to SQL
+var bucketAggs = map[string]string{
+	"avg":  "avg(value)",
+	"min":  "min(value)",
+	"max":  "max(value)",
+	"sum":  "sum(value)",
+	"last": "arg_max(value, time)",
+}
+
+// QueryBucketed aggregates one series of kind into buckets over
+// [from, to). agg is one of avg, min, max, sum, last.
+func (s *DuckDBStore) QueryBucketed(ctx context.Context, kind string, resourceID int64, metricType string, from, to time.Time, b Bucketing, agg string) ([]MetricPoint, error) {
+	expr, ok := bucketAggs[agg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported aggregation %q", agg)
+	}
+
+	bucket, args := b.keyExpr(from, to)
+	filter, kindArgs := kindFilter(kind)
+	query := `SELECT ` + bucket + ` AS bucket, ` + expr + `
+		FROM metrics
+		WHERE resource_id = ? AND metric_type = ? AND time >= ? AND time < ?` + filter + `
+		GROUP BY bucket
+		ORDER BY bucket`
+
+	rows, err := s.db.QueryContext(ctx, query, append(append(args, resourceID, metricType, from, to), kindArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []MetricPoint{}
+	dest, bucketTime := b.dest()
+	for rows.Next() {
+		p := MetricPoint{ResourceID: resourceID, ResourceKind: kind, MetricType: metricType}
+		if err := rows.Scan(dest, &p.Value); err != nil {
+			return nil, err
+		}
+		p.Time = bucketTime()
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// rateAgg derives a per-second rate from a counter within each bucket
+const rateAgg = "(max(value) - min(value)) / nullif(epoch(max(time)::TIMESTAMP) - epoch(min(time)::TIMESTAMP), 0)"
+
+// QueryBucketedByResource aggregates one metric type for every resource of
+// kind into buckets over [from, to). agg is one of the QueryBucketed
+// aggregations or "rate", which turns counters into per-second values.
+// Buckets where the aggregation is undefined are omitted.
+func (s *DuckDBStore) QueryBucketedByResource(ctx context.Context, kind, metricType string, from, to time.Time, b Bucketing, agg string) ([]MetricPoint, error) {
+	return s.queryBucketedByResource(ctx, kind, metricType, nil, from, to, b, agg)
+}
+
+// QueryBucketedForResources is QueryBucketedByResource restricted to ids
+func (s *DuckDBStore) QueryBucketedForResources(ctx context.Context, kind, metricType string, ids []int64, from, to time.Time, b Bucketing, agg string) ([]MetricPoint, error) {
+	if len(ids) == 0 {
+		return []MetricPoint{}, nil
+	}
+	return s.queryBucketedByResource(ctx, kind, metricType, ids, from, to, b, agg)
+}
+
+func (s *DuckDBStore) queryBucketedByResource(ctx context.Context, kind, metricType string, ids []int64, from, to time.Time, b Bucketing, agg string) ([]MetricPoint, error) {
+	expr, ok := bucketAggs[agg]
+	if agg == "rate" {
+		expr, ok = rateAgg, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unsupported aggregation %q", agg)
+	}
+
+	bucket, args := b.keyExpr(from, to)
+	args = append(args, metricType, from, to)
+	filter, kindArgs := kindFilter(kind)
+	args = append(args, kindArgs...)
+	if ids != nil {
+		marks, idArgs := inArgs(ids)
+		filter += " AND resource_id IN (" + marks + ")"
+		args = append(args, idArgs...)
+	}
+	query := `SELECT ` + bucket + ` AS bucket, resource_id, ` + expr + ` AS v
+		FROM metrics
+		WHERE metric_type = ? AND time >= ? AND time < ?` + filter + `
+		GROUP BY bucket, resource_id
+		HAVING v IS NOT NULL
+		ORDER BY bucket`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []MetricPoint{}
+	dest, bucketTime := b.dest()
+	for rows.Next() {
+		p := MetricPoint{ResourceKind: kind, MetricType: metricType}
+		if err := rows.Scan(dest, &p.ResourceID, &p.Value); err != nil {
+			return nil, err
+		}
+		p.Time = bucketTime()
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// TypeCount is a metric type with the number of distinct resources reporting it
+type TypeCount struct {
+	Type      string
+	Resources int64
+}
+
+// MetricTypes lists metric types seen in [from, to)
+func (s *DuckDBStore) MetricTypes(ctx context.Context, from, to time.Time) ([]TypeCount, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT metric_type, count(DISTINCT resource_id) FROM metrics
+		WHERE time >= ? AND time < ? GROUP BY metric_type ORDER BY metric_type`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []TypeCount{}
+	for rows.Next() {
+		var tc TypeCount
+		if err := rows.Scan(&tc.Type, &tc.Resources); err != nil {
+			return nil, err
+		}
+		out = append(out, tc)
+	}
+	return out, rows.Err()
+}
+
+// NodeTotal is one precomputed per-node (or cluster, NodeID 0) value
+type NodeTotal struct {
+	Time       time.Time
+	NodeID     int64
+	MetricType string
+	Value      float64
+}
+
+func (s *DuckDBStore) InsertNodeTotals(totals []NodeTotal) error {
+	if len(totals) == 0 {
+		return nil
+	}
+	tables, err := byTable(s, "node_totals", totals, func(t NodeTotal) time.Time { return t.Time })
+	if err != nil {
+		return err
+	}
+	// A transaction may only write to one database file
+	for table, rows := range tables {
+		if err := s.insertNodeTotals(table, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DuckDBStore) insertNodeTotals(table string, totals []NodeTotal) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("INSERT INTO " + table + " (time, node_id, metric_type, value, source_id) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	// Rollups are the consumer's own
+	src := s.stamper()(0)
+	for _, t := range totals {
+		if _, err := stmt.Exec(t.Time, t.NodeID, t.MetricType, t.Value, src); err != nil {
 			return err
 		}
 	}
+	listed, err := s.listSources(tx, table, sourceSet(src), totals[0].Time)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	listed()
+	return nil
+}
+
+// QueryNodeTotals returns one precomputed series in [from, to). With a
+// step the per-minute rows are averaged into buckets.
+func (s *DuckDBStore) QueryNodeTotals(ctx context.Context, nodeID int64, metricType string, from, to time.Time, b Bucketing) ([]MetricPoint, error) {
+	query := `SELECT time, value FROM node_totals
+		WHERE node_id = ? AND metric_type = ? AND time >= ? AND time < ?
+		ORDER BY time`
+	args := []interface{}{nodeID, metricType, from, to}
+	dest, bucketTime := Bucketing{}.dest()
+	if b.Step > 0 {
+		bucket, bargs := b.keyExpr(from, to)
+		query = `SELECT ` + bucket + ` AS bucket, avg(value) FROM node_totals
+		WHERE node_id = ? AND metric_type = ? AND time >= ? AND time < ?
+		GROUP BY bucket
+		ORDER BY bucket`
+		args = append(bargs, args...)
+		dest, bucketTime = b.dest()
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []MetricPoint{}
+	for rows.Next() {
+		p := MetricPoint{ResourceID: nodeID, MetricType: metricType}
+		if err := rows.Scan(dest, &p.Value); err != nil {
+			return nil, err
+		}
+		p.Time = bucketTime()
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
 
-	return tx.Commit()
+// ScanRange calls fn for every raw point in [from, to) in time order,
+// stopping at the first error
+func (s *DuckDBStore) ScanRange(ctx context.Context, from, to time.Time, fn func(MetricPoint) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT time, resource_id, metric_type, value, coalesce(source_id, 0), coalesce(sample_rate, 0) FROM metrics
+		WHERE time >= ? AND time < ? AND agg_type = 'raw'
+		ORDER BY time`, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p MetricPoint
+		if err := rows.Scan(&p.Time, &p.ResourceID, &p.MetricType, &p.Value, &p.SourceID, &p.SampleRate); err != nil {
+			return err
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
@@ -0,0 +1,174 @@
+//go:build faults
+
+package store
+
+// Fault injection for exercising retry and degradation paths. Only compiled
+// with -tags faults; configured through STORE_FAULTS, one clause per backend:
+//
+//	STORE_FAULTS="duckdb:error=0.2,latency=100ms;sqlite:latency=20ms"
+//
+// error is the probability of a statement failing; latency is added to
+// every statement (uniformly jittered between 0.5x and 1.5x).
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"expvar"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type faultConfig struct {
+	errorRate float64
+	latency   time.Duration
+}
+
+var (
+	faults         = parseFaults(os.Getenv("STORE_FAULTS"))
+	injectedErrors = expvar.NewMap("store_injected_errors")
+)
+
+func parseFaults(spec string) map[string]faultConfig {
+	out := map[string]faultConfig{}
+	for _, clause := range strings.Split(spec, ";") {
+		backend, opts, ok := strings.Cut(strings.TrimSpace(clause), ":")
+		if !ok {
+			continue
+		}
+		var cfg faultConfig
+		for _, opt := range strings.Split(opts, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(opt), "=")
+			var err error
+			switch k {
+			case "error":
+				cfg.errorRate, err = strconv.ParseFloat(v, 64)
+			case "latency":
+				cfg.latency, err = time.ParseDuration(v)
+			default:
+				err = fmt.Errorf("unknown option")
+			}
+			if err != nil {
+				log.Fatalf("Invalid STORE_FAULTS option %q for %s: %v", opt, backend, err)
+			}
+		}
+		out[backend] = cfg
+		log.Printf("Store fault injection enabled for %s: error=%.2f latency=%s", backend, cfg.errorRate, cfg.latency)
+	}
+	return out
+}
+
+func openDB(backend, driverName, dsn string) (*sql.DB, error) {
+	c, err := driverConnector(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if cfg, ok := faults[backend]; ok {
+		c = &faultConnector{backend: backend, cfg: cfg, inner: c}
+	}
+	return openConnector(backend, c), nil
+}
+
+type faultConnector struct {
+	backend string
+	cfg     faultConfig
+	inner   driver.Connector
+}
+
+func (c *faultConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.inner.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &faultConn{Conn: conn, f: c}, nil
+}
+
+func (c *faultConnector) Driver() driver.Driver { return c.inner.Driver() }
+
+// inject sleeps and possibly fails before a statement runs
+func (c *faultConnector) inject(ctx context.Context, op string) error {
+	if c.cfg.latency > 0 {
+		d := time.Duration(float64(c.cfg.latency) * (0.5 + rand.Float64()))
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if rand.Float64() < c.cfg.errorRate {
+		injectedErrors.Add(c.backend, 1)
+		return fmt.Errorf("injected %s fault during %s", c.backend, op)
+	}
+	return nil
+}
+
+// faultConn intercepts statement entry points and delegates everything else
+type faultConn struct {
+	driver.Conn
+	f *faultConnector
+}
+
+func (c *faultConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *faultConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.f.inject(ctx, "prepare"); err != nil {
+		return nil, err
+	}
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *faultConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.f.inject(ctx, "exec"); err != nil {
+		return nil, err
+	}
+	return e.ExecContext(ctx, query, args)
+}
+
+func (c *faultConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.f.inject(ctx, "query"); err != nil {
+		return nil, err
+	}
+	return q.QueryContext(ctx, query, args)
+}
+
+func (c *faultConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.f.inject(ctx, "begin"); err != nil {
+		return nil, err
+	}
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *faultConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if chk, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return chk.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (c *faultConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package store
+
+// SetNodeReady records whether a node's Ready condition is true
+func (s *SQLiteStore) SetNodeReady(id int64, ready bool) error {
+	_, err := s.db.Exec(`UPDATE nodes SET ready = ? WHERE id = ?`, ready, id)
+	return err
+}
+
+// NodeReadiness counts nodes not known to be deleted, and those ready. A
+// node is counted once by name, as pods may have created a stub row for it.
+func (s *SQLiteStore) NodeReadiness() (ready, total int, err error) {
+	err = s.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(r), 0) FROM (
+		SELECT MAX(COALESCE(ready, 0)) AS r FROM nodes
+		WHERE deleted_at IS NULL
+		GROUP BY name)`).Scan(&total, &ready)
+	return ready, total, err
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// heatmapScopes maps a heatmap scope to the pods column selecting it
+var heatmapScopes = map[string]string{
+	"deployment":  "deployment_id",
+	"statefulset": "statefulset_id",
+	"daemonset":   "daemonset_id",
+	"namespace":   "namespace_id",
+	"node":        "node_id",
+}
+
+// ScopePodIDs returns the pods of a deployment, statefulset, daemonset,
+// namespace or node, including deleted ones so history stays complete
+func (s *SQLiteStore) ScopePodIDs(scope string, id int64) ([]int64, error) {
+	column, ok := heatmapScopes[scope]
+	if !ok {
+		return nil, fmt.Errorf("unknown scope %q", scope)
+	}
+	return s.queryIDs(fmt.Sprintf("SELECT id FROM pods WHERE %s = ?", column), id)
+}
+
+// NamespacePVCIDs returns the PVCs of a namespace
+func (s *SQLiteStore) NamespacePVCIDs(namespaceID int64) ([]int64, error) {
+	return s.queryIDs("SELECT id FROM pvcs WHERE namespace_id = ?", namespaceID)
+}
+
+func (s *SQLiteStore) queryIDs(query string, args ...interface{}) ([]int64, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// HeatCell counts the series whose value fell into one value bucket
+// during one time bucket
+type HeatCell struct {
+	Time   time.Time
+	Bucket int
+	Count  int64
+}
+
+// Heatmap is the distribution of per-resource values over time. Values
+// span [Lo, Hi] in n equal buckets; values outside fall into the edge
+// buckets.
+type Heatmap struct {
+	Lo, Hi float64
+	Cells  []HeatCell
+}
+
+// QueryHeatmap aggregates one metric type per resource and time bucket
+// (agg as for QueryBucketedByResource), then counts resources per value
+// bucket. lo and hi fix the value range; nil takes it from the data.
+func (s *DuckDBStore) QueryHeatmap(ctx context.Context, metricType string, ids []int64, from, to time.Time, b Bucketing, agg string, lo, hi *float64, n int) (Heatmap, error) {
+	out := Heatmap{Cells: []HeatCell{}}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	expr, ok := bucketAggs[agg]
+	if agg == "rate" {
+		expr, ok = rateAgg, true
+	}
+	if !ok {
+		return out, fmt.Errorf("unsupported aggregation %q", agg)
+	}
+
+	bucket, args := b.keyExpr(from, to)
+	marks, idArgs := inArgs(ids)
+	args = append(args, metricType, from, to)
+	args = append(args, idArgs...)
+	args = append(args, nullable(lo), nullable(hi), n, n)
+	// A range of zero width puts everything in the first bucket
+	query := `WITH per AS (
+			SELECT ` + bucket + ` AS bucket, resource_id, ` + expr + ` AS v
+			FROM metrics
+			WHERE metric_type = ? AND time >= ? AND time < ? AND resource_id IN (` + marks + `)
+			GROUP BY bucket, resource_id
+			HAVING v IS NOT NULL
+		), bounds AS (
+			SELECT coalesce(CAST(? AS DOUBLE), min(v)) AS lo, coalesce(CAST(? AS DOUBLE), max(v)) AS hi FROM per
+		)
+		SELECT bucket,
+			CAST(least(greatest(coalesce(floor((v - lo) / nullif((hi - lo) / ?, 0)), 0), 0), ? - 1) AS INTEGER) AS cell,
+			count(*), any_value(lo), any_value(hi)
+		FROM per, bounds
+		GROUP BY bucket, cell
+		ORDER BY bucket, cell`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+
+	dest, bucketTime := b.dest()
+	for rows.Next() {
+		var c HeatCell
+		if err := rows.Scan(dest, &c.Bucket, &c.Count, &out.Lo, &out.Hi); err != nil {
+			return out, err
+		}
+		c.Time = bucketTime()
+		out.Cells = append(out.Cells, c)
+	}
+	return out, rows.Err()
+}
+
+func nullable(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/querystats"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/nchanged/vitakube/packages/vita-consumer/internal/store")
+
+// tracingEnabled makes the instrumentation layer record spans
+var tracingEnabled bool
+
+// EnableTracing makes stores record a span for every
+// statement run under a traced context. Statements without a parent span
+// (background work) are not traced, to keep traces about requests.
+func EnableTracing() {
+	tracingEnabled = true
+}
+
+// maxStatementAttr bounds the SQL text attached to a span
+const maxStatementAttr = 2048
+
+// openConnector opens c behind the instrumentation layer, which feeds
+// querystats and, when enabled, tracing
+func openConnector(backend string, c driver.Connector) *sql.DB {
+	return sql.OpenDB(&instrumentedConnector{Connector: c, backend: backend})
+}
+
+// driverConnector returns a connector for a registered driver, the same
+// way sql.Open builds one
+func driverConnector(driverName, dsn string) (driver.Connector, error) {
+	// Borrow the registered driver; sql.Open does not connect yet
+	probe, err := sql.Open(driverName, "")
+	if err != nil {
+		return nil, err
+	}
+	drv := probe.Driver()
+	probe.Close()
+
+	if dc, ok := drv.(driver.DriverContext); ok {
+		return dc.OpenConnector(dsn)
+	}
+	return &dsnConnector{drv: drv, dsn: dsn}, nil
+}
+
+type dsnConnector struct {
+	drv driver.Driver
+	dsn string
+}
+
+func (c *dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.drv.Open(c.dsn) }
+func (c *dsnConnector) Driver() driver.Driver                        { return c.drv }
+
+type instrumentedConnector struct {
+	driver.Connector
+	backend string
+}
+
+func (c *instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{Conn: conn, backend: c.backend}, nil
+}
+
+// instrumentedConn times and traces direct Exec/Query calls. Prepared
+// statements are left alone: bulk inserts run them thousands of times per
+// flush.
+type instrumentedConn struct {
+	driver.Conn
+	backend string
+}
+
+// start returns a span when the statement runs under a traced context
+func (c *instrumentedConn) start(ctx context.Context, op, query string) (context.Context, trace.Span) {
+	if !tracingEnabled || !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return ctx, nil
+	}
+	if len(query) > maxStatementAttr {
+		query = query[:maxStatementAttr]
+	}
+	return tracer.Start(ctx, c.backend+"."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system.name", c.backend),
+			attribute.String("db.query.text", query),
+		))
+}
+
+// finish records a completed statement and ends its span, if any
+func (c *instrumentedConn) finish(ctx context.Context, span trace.Span, query string, start time.Time, rows int64, err error) {
+	querystats.RecordStatement(ctx, c.backend, query, time.Since(start), rows, err)
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	ctx, span := c.start(ctx, "exec", query)
+	res, err := e.ExecContext(ctx, query, args)
+	var n int64
+	if err == nil {
+		n, _ = res.RowsAffected()
+		if span != nil {
+			span.SetAttributes(attribute.Int64("db.rows_affected", n))
+		}
+	}
+	c.finish(ctx, span, query, start, n, err)
+	return res, err
+}
+
+// QueryContext keeps measuring until the rows are closed, so it covers
+// fetching as well as planning
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	ctx, span := c.start(ctx, "query", query)
+	rows, err := q.QueryContext(ctx, query, args)
+	if err != nil {
+		c.finish(ctx, span, query, start, 0, err)
+		return nil, err
+	}
+	return &instrumentedRows{Rows: rows, conn: c, ctx: ctx, span: span, query: query, start: start}, nil
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *instrumentedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if chk, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return chk.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (c *instrumentedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+type instrumentedRows struct {
+	driver.Rows
+	conn  *instrumentedConn
+	ctx   context.Context
+	span  trace.Span
+	query string
+	start time.Time
+	n     int64
+	err   error
+}
+
+func (r *instrumentedRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	switch {
+	case err == nil:
+		r.n++
+	case err != io.EOF:
+		r.err = err
+	}
+	return err
+}
+
+func (r *instrumentedRows) Close() error {
+	err := r.Rows.Close()
+	if r.span != nil {
+		r.span.SetAttributes(attribute.Int64("db.rows", r.n))
+	}
+	if r.err == nil {
+		r.err = err
+	}
+	r.conn.finish(r.ctx, r.span, r.query, r.start, r.n, r.err)
+	return err
+}
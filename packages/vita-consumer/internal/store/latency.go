@@ -0,0 +1,26 @@
+package store
+
+import "database/sql"
+
+// PodLatencies returns the scheduling and startup latency recorded for a
+// pod, each nil until recorded
+func (s *SQLiteStore) PodLatencies(id int64) (scheduling, startup *float64, err error) {
+	var sched, start sql.NullFloat64
+	err = s.db.QueryRow("SELECT scheduling_ms, startup_ms FROM pods WHERE id = ?", id).Scan(&sched, &start)
+	if sched.Valid {
+		scheduling = &sched.Float64
+	}
+	if start.Valid {
+		startup = &start.Float64
+	}
+	return scheduling, startup, err
+}
+
+// SetPodLatencies records a pod's scheduling and startup latency in
+// milliseconds. Nil values and values already recorded are left alone, so
+// each is recorded once per pod.
+func (s *SQLiteStore) SetPodLatencies(id int64, scheduling, startup *float64) error {
+	_, err := s.db.Exec(`UPDATE pods SET scheduling_ms = coalesce(scheduling_ms, ?), startup_ms = coalesce(startup_ms, ?)
+		WHERE id = ?`, scheduling, startup, id)
+	return err
+}
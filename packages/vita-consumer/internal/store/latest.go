@@ -0,0 +1,185 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// UpsertLatest records points as their series' newest value unless a newer
+// one is recorded already, so late and replayed points never move a
+// series back. Series are keyed by kind as well as ID, as IDs are only
+// unique within a kind.
+func (s *SQLiteStore) UpsertLatest(points []MetricPoint) error {
+	type seriesKey struct {
+		resourceKind string
+		resourceID   int64
+		metricType   string
+	}
+	newest := make(map[seriesKey]MetricPoint)
+	for _, p := range points {
+		k := seriesKey{p.ResourceKind, p.ResourceID, p.MetricType}
+		if cur, ok := newest[k]; !ok || p.Time.After(cur.Time) {
+			newest[k] = p
+		}
+	}
+	if len(newest) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO latest_values (resource_kind, resource_id, metric_type, time, value) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(resource_kind, resource_id, metric_type) DO UPDATE SET time = excluded.time, value = excluded.value
+		WHERE excluded.time > latest_values.time`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, p := range newest {
+		if _, err := stmt.Exec(p.ResourceKind, p.ResourceID, p.MetricType, p.Time.UTC(), p.Value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// HasLatest reports whether any latest value is recorded
+func (s *SQLiteStore) HasLatest() (bool, error) {
+	var ok bool
+	err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM latest_values)").Scan(&ok)
+	return ok, err
+}
+
+// DeleteLatestBefore forgets series whose newest value is older than t
+func (s *SQLiteStore) DeleteLatestBefore(t time.Time) (int64, error) {
+	res, err := s.db.Exec("DELETE FROM latest_values WHERE time < ?", t.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteLatest forgets the series of the given resources, by catalog
+// table, e.g. once they are purged
+func (s *SQLiteStore) DeleteLatest(ids map[string][]int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := deleteLatest(tx, ids); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteLatest(tx *sql.Tx, ids map[string][]int64) error {
+	for table, ids := range ids {
+		kind := TableKind(table)
+		if kind == "" || len(ids) == 0 {
+			continue
+		}
+		marks, args := inArgs(ids)
+		if _, err := tx.Exec("DELETE FROM latest_values WHERE resource_kind = ? AND resource_id IN ("+marks+")", append([]interface{}{kind}, args...)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// latestKindFilter is kindFilter for latest_values, where series from
+// before series recorded their kind have an empty kind
+func latestKindFilter(kind string) (string, []interface{}) {
+	if kind == "" {
+		return "", nil
+	}
+	return " AND resource_kind IN (?, '')", []interface{}{kind}
+}
+
+// LatestValues returns the latest value of a metric type per resource of
+// kind, for resources that reported it since the given time
+func (s *SQLiteStore) LatestValues(since time.Time, kind, metricType string) (map[int64]float64, error) {
+	filter, kindArgs := latestKindFilter(kind)
+	rows, err := s.db.Query(`SELECT resource_id, value FROM latest_values WHERE metric_type = ? AND time >= ?`+filter,
+		append([]interface{}{metricType, since.UTC()}, kindArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]float64)
+	for rows.Next() {
+		var id int64
+		var v float64
+		if err := rows.Scan(&id, &v); err != nil {
+			return nil, err
+		}
+		out[id] = v
+	}
+	return out, rows.Err()
+}
+
+// LatestPoints returns the newest point of every series of kind and the
+// given types reported since the given time, limited to ids if any are
+// given. An empty kind takes every series, each point carrying its kind.
+func (s *SQLiteStore) LatestPoints(since time.Time, kind string, types []string, ids []int64) ([]MetricPoint, error) {
+	if len(types) == 0 {
+		return []MetricPoint{}, nil
+	}
+	args := []interface{}{since.UTC()}
+	for _, t := range types {
+		args = append(args, t)
+	}
+	filter, kindArgs := latestKindFilter(kind)
+	query := `SELECT resource_kind, resource_id, metric_type, time, value FROM latest_values
+		WHERE time >= ? AND metric_type IN (` + strings.TrimSuffix(strings.Repeat("?,", len(types)), ",") + `)` + filter
+	args = append(args, kindArgs...)
+	if len(ids) > 0 {
+		marks, idArgs := inArgs(ids)
+		query += " AND resource_id IN (" + marks + ")"
+		args = append(args, idArgs...)
+	}
+	rows, err := s.db.Query(query+" ORDER BY resource_kind, resource_id, metric_type", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []MetricPoint{}
+	for rows.Next() {
+		var p MetricPoint
+		if err := rows.Scan(&p.ResourceKind, &p.ResourceID, &p.MetricType, &p.Time, &p.Value); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// LatestPoints returns the newest stored point of every series of kind
+// since the given time, to seed the latest values of a catalog that has
+// none. An empty kind takes every series, each point carrying its kind.
+func (s *DuckDBStore) LatestPoints(ctx context.Context, kind string, since time.Time) ([]MetricPoint, error) {
+	filter, kindArgs := kindFilter(kind)
+	rows, err := s.db.QueryContext(ctx, `SELECT coalesce(resource_kind, ''), resource_id, metric_type, max(time), arg_max(value, time) FROM metrics
+		WHERE time >= ?`+filter+` GROUP BY resource_kind, resource_id, metric_type`, append([]interface{}{since}, kindArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []MetricPoint
+	for rows.Next() {
+		var p MetricPoint
+		if err := rows.Scan(&p.ResourceKind, &p.ResourceID, &p.MetricType, &p.Time, &p.Value); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
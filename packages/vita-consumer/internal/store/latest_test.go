@@ -0,0 +1,66 @@
+package store
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A latest values table keyed without the kind is rebuilt on open, and
+// series of different kinds sharing an ID keep their own newest value
+func TestLatestValuesByKind(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "meta.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE TABLE latest_values (resource_id INTEGER NOT NULL, metric_type TEXT NOT NULL,
+		time DATETIME NOT NULL, value REAL NOT NULL, PRIMARY KEY (resource_id, metric_type));
+		INSERT INTO latest_values VALUES (7, 'mem_mb', '2024-06-15 12:00:00', 1)`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if ok, err := s.HasLatest(); err != nil || ok {
+		t.Fatalf("unkeyed latest values kept: %v (%v)", ok, err)
+	}
+
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	if err := s.UpsertLatest([]MetricPoint{
+		{Time: now, ResourceID: 7, ResourceKind: "pod", MetricType: "used_mb", Value: 5},
+		{Time: now, ResourceID: 7, ResourceKind: "pvc", MetricType: "used_mb", Value: 100},
+		{Time: now, ResourceID: 8, MetricType: "used_mb", Value: 50},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	values, err := s.LatestValues(now.Add(-time.Minute), "pvc", "used_mb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[7] != 100 || values[8] != 50 {
+		t.Fatalf("pvc used_mb = %v, want the claim's and the unkinded series'", values)
+	}
+	points, err := s.LatestPoints(now.Add(-time.Minute), "", []string{"used_mb"}, []int64{7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].ResourceKind != "pod" || points[1].ResourceKind != "pvc" {
+		t.Fatalf("latest points of 7 = %+v, want the pod's and the claim's", points)
+	}
+
+	if err := s.DeleteLatest(map[string][]int64{"pvcs": {7}}); err != nil {
+		t.Fatal(err)
+	}
+	if values, err = s.LatestValues(now.Add(-time.Minute), "pod", "used_mb"); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[7] != 5 {
+		t.Fatalf("pod used_mb after deleting the claim = %v, want the pod's kept", values)
+	}
+}
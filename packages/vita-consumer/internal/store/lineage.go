@@ -0,0 +1,31 @@
+package store
+
+import "fmt"
+
+// lineageTables are the kinds whose recreations are linked by name
+var lineageTables = map[string]bool{
+	"deployments":  true,
+	"statefulsets": true,
+	"daemonsets":   true,
+	"pvcs":         true,
+}
+
+// Lineage returns the ids of every row of table that shares id's namespace
+// and name, oldest first: the resource together with the ones it replaced
+// or was replaced by when deleted and recreated under a new UID. A resource
+// without successors or predecessors yields just its own id.
+func (s *SQLiteStore) Lineage(table string, id int64) ([]int64, error) {
+	if !lineageTables[table] {
+		return nil, fmt.Errorf("no lineage for %s", table)
+	}
+	ids, err := s.ids(fmt.Sprintf(`SELECT t.id FROM %s t
+		JOIN %s r ON r.namespace_id = t.namespace_id AND r.name = t.name
+		WHERE r.id = ? ORDER BY t.id`, table, table), id)
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []int64{id}, nil
+	}
+	return ids, nil
+}
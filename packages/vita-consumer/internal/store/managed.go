@@ -0,0 +1,63 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// managedTables are the settings that can be synced from custom resources
+var managedTables = map[string]string{
+	"rule":   "recording_rules",
+	"report": "report_templates",
+}
+
+// ManagedBy returns the custom resource ("VitaRule ns/name") a rule or
+// report of that name is synced from; empty when it is managed through
+// the API or does not exist
+func (s *SQLiteStore) ManagedBy(kind, name string) (string, error) {
+	table, ok := managedTables[kind]
+	if !ok {
+		return "", fmt.Errorf("unknown managed kind %q", kind)
+	}
+	var ref string
+	err := s.db.QueryRow(fmt.Sprintf("SELECT managed_by FROM %s WHERE name = ?", table), name).Scan(&ref)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return ref, err
+}
+
+// ManagedNames returns the rules or reports synced from custom resources,
+// name by resource
+func (s *SQLiteStore) ManagedNames(kind string) (map[string]string, error) {
+	table, ok := managedTables[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown managed kind %q", kind)
+	}
+	rows, err := s.db.Query(fmt.Sprintf("SELECT managed_by, name FROM %s WHERE managed_by != ''", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]string)
+	for rows.Next() {
+		var ref, name string
+		if err := rows.Scan(&ref, &name); err != nil {
+			return nil, err
+		}
+		out[ref] = name
+	}
+	return out, rows.Err()
+}
+
+// DeleteManaged removes the rule or report synced from a custom resource
+func (s *SQLiteStore) DeleteManaged(kind, ref string) error {
+	table, ok := managedTables[kind]
+	if !ok {
+		return fmt.Errorf("unknown managed kind %q", kind)
+	}
+	_, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE managed_by = ?", table), ref)
+	return err
+}
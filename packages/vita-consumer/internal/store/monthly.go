@@ -0,0 +1,382 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// legacyMonth names the single-file metrics.duckdb when monthly files are
+// enabled on an existing data directory: it stays readable until its rows
+// age out
+const legacyMonth = "legacy"
+
+var monthFileRe = regexp.MustCompile(`^metrics-(\d{4}-\d{2})\.duckdb$`)
+
+// monthSet tracks the per-month files attached to a DuckDBStore
+type monthSet struct {
+	mu       sync.Mutex
+	dir      string
+	attached map[string]string // month ("2006-01" or legacyMonth) -> file
+}
+
+// MonthFile is one attached database file
+type MonthFile struct {
+	Month string `json:"month"`
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// NewMonthlyDuckDBStore keeps metrics in one file per UTC month
+// (metrics-2024-06.duckdb) in dir. Every file found is attached and the
+// query layer reads views unioning them, so queries are unchanged; writes
+// go to the file of each row's month, created on first use. Whole months
+// can be archived or deleted by moving or removing their file.
+func NewMonthlyDuckDBStore(dir string, now time.Time) (*DuckDBStore, error) {
+	// An in-memory database holds only the views
+	db, err := openDB("duckdb", "duckdb", "")
+	if err != nil {
+		return nil, err
+	}
+	s := &DuckDBStore{db: db, path: dir, monthly: &monthSet{dir: dir, attached: map[string]string{}}}
+	m := s.monthly
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, e := range entries {
+		if match := monthFileRe.FindStringSubmatch(e.Name()); match != nil {
+			if err := s.attachLocked(match[1], filepath.Join(dir, e.Name())); err != nil {
+				db.Close()
+				return nil, err
+			}
+		}
+	}
+	if legacy := filepath.Join(dir, "metrics.duckdb"); fileExists(legacy) {
+		if err := s.attachLocked(legacyMonth, legacy); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	current := monthOf(now)
+	if _, ok := m.attached[current]; !ok {
+		if err := s.attachLocked(current, m.file(current)); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if err := s.rebuildViewsLocked(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func monthOf(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+func (m *monthSet) file(month string) string {
+	return filepath.Join(m.dir, "metrics-"+month+".duckdb")
+}
+
+// alias is the database name a month is attached as
+func monthAlias(month string) string {
+	return "m_" + strings.ReplaceAll(month, "-", "_")
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (s *DuckDBStore) attachLocked(month, path string) error {
+	alias := monthAlias(month)
+	if _, err := s.db.Exec(fmt.Sprintf("ATTACH %s AS %s", quoteLiteral(path), alias)); err != nil {
+		return fmt.Errorf("failed to attach %s: %w", path, err)
+	}
+	if err := initDuckDBSchema(s.db, alias+"."); err != nil {
+		return err
+	}
+	s.monthly.attached[month] = path
+	return nil
+}
+
+// monthsLocked returns the attached months, oldest first
+func (s *DuckDBStore) monthsLocked() []string {
+	months := make([]string, 0, len(s.monthly.attached))
+	for month := range s.monthly.attached {
+		months = append(months, month)
+	}
+	// The legacy file holds the oldest data
+	sort.Slice(months, func(i, j int) bool {
+		if (months[i] == legacyMonth) != (months[j] == legacyMonth) {
+			return months[i] == legacyMonth
+		}
+		return months[i] < months[j]
+	})
+	return months
+}
+
+// rebuildViewsLocked points the metrics, node_totals, process_samples and
+// sources views at every attached month. Series ids are local to each
+// file, so metrics unions each file's points joined with its own series.
+func (s *DuckDBStore) rebuildViewsLocked() error {
+	months := s.monthsLocked()
+	metrics := make([]string, len(months))
+	for i, month := range months {
+		metrics[i] = metricsView(monthAlias(month) + ".")
+	}
+	if _, err := s.db.Exec("CREATE OR REPLACE VIEW metrics AS " + strings.Join(metrics, " UNION ALL ")); err != nil {
+		return err
+	}
+	for _, table := range duckTables {
+		parts := make([]string, len(months))
+		for i, month := range months {
+			parts[i] = "SELECT * FROM " + monthAlias(month) + "." + table
+		}
+		if _, err := s.db.Exec("CREATE OR REPLACE VIEW " + table + " AS " + strings.Join(parts, " UNION ALL ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// table returns where rows of table at time t are written
+func (s *DuckDBStore) table(name string, t time.Time) (string, error) {
+	if s.monthly == nil {
+		return name, nil
+	}
+	m := s.monthly
+	month := monthOf(t)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.attached[month]; !ok {
+		if err := s.attachLocked(month, m.file(month)); err != nil {
+			return "", err
+		}
+		if err := s.rebuildViewsLocked(); err != nil {
+			return "", err
+		}
+	}
+	return monthAlias(month) + "." + name, nil
+}
+
+// partitions returns every table rows of table may be stored in, for
+// deletes
+func (s *DuckDBStore) partitions(name string) []string {
+	if s.monthly == nil {
+		return []string{name}
+	}
+	s.monthly.mu.Lock()
+	defer s.monthly.mu.Unlock()
+	var out []string
+	for _, month := range s.monthsLocked() {
+		out = append(out, monthAlias(month)+"."+name)
+	}
+	return out
+}
+
+// byTable groups rows by the table their time is written to
+func byTable[T any](s *DuckDBStore, name string, rows []T, at func(T) time.Time) (map[string][]T, error) {
+	out := make(map[string][]T)
+	for _, row := range rows {
+		table, err := s.table(name, at(row))
+		if err != nil {
+			return nil, err
+		}
+		out[table] = append(out[table], row)
+	}
+	return out, nil
+}
+
+// execAll runs query, with {t} replaced by each partition of table and
+// {points} and {series} by those tables in the same file, and sums the
+// rows affected
+func (s *DuckDBStore) execAll(table, query string, args ...interface{}) (int64, error) {
+	var total int64
+	for _, t := range s.partitions(table) {
+		r := strings.NewReplacer("{t}", t, "{points}", siblingTable(t, "points"), "{series}", seriesTable(t))
+		res, err := s.db.Exec(r.Replace(query), args...)
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	return total, nil
+}
+
+// checkpoint writes the WAL of every file into it
+func (s *DuckDBStore) checkpoint(force bool) error {
+	stmt := "CHECKPOINT"
+	if force {
+		stmt = "FORCE CHECKPOINT"
+	}
+	if s.monthly == nil {
+		_, err := s.db.Exec(stmt)
+		return err
+	}
+	s.monthly.mu.Lock()
+	defer s.monthly.mu.Unlock()
+	for _, month := range s.monthsLocked() {
+		if _, err := s.db.Exec(stmt + " " + monthAlias(month)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Files returns the database files, for size reporting
+func (s *DuckDBStore) Files() []string {
+	if s.monthly == nil {
+		return []string{s.path}
+	}
+	s.monthly.mu.Lock()
+	defer s.monthly.mu.Unlock()
+	var out []string
+	for _, month := range s.monthsLocked() {
+		out = append(out, s.monthly.attached[month])
+	}
+	return out
+}
+
+// ErrNotMonthly is returned by month operations on a single-file store
+var ErrNotMonthly = errors.New("metrics are not split into monthly files")
+
+// Months lists the attached files, oldest first
+func (s *DuckDBStore) Months() ([]MonthFile, error) {
+	if s.monthly == nil {
+		return nil, ErrNotMonthly
+	}
+	s.monthly.mu.Lock()
+	defer s.monthly.mu.Unlock()
+	out := []MonthFile{}
+	for _, month := range s.monthsLocked() {
+		f := MonthFile{Month: month, Path: s.monthly.attached[month]}
+		if fi, err := os.Stat(f.Path); err == nil {
+			f.Bytes = fi.Size()
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// DetachMonth stops reading and writing a month's file and returns its
+// path, so it can be archived. Late rows for the month start a new file;
+// the file is attached again on restart if left in place.
+func (s *DuckDBStore) DetachMonth(month string) (string, error) {
+	if s.monthly == nil {
+		return "", ErrNotMonthly
+	}
+	s.monthly.mu.Lock()
+	defer s.monthly.mu.Unlock()
+	return s.detachLocked(month)
+}
+
+// DeleteMonth detaches a month's file and removes it, returning how many
+// metric rows it held
+func (s *DuckDBStore) DeleteMonth(month string) (int64, error) {
+	if s.monthly == nil {
+		return 0, ErrNotMonthly
+	}
+	s.monthly.mu.Lock()
+	defer s.monthly.mu.Unlock()
+	return s.deleteMonthLocked(month)
+}
+
+func (s *DuckDBStore) detachLocked(month string) (string, error) {
+	path, ok := s.monthly.attached[month]
+	if !ok {
+		return "", fmt.Errorf("month %q is not attached", month)
+	}
+	if len(s.monthly.attached) == 1 {
+		return "", errors.New("cannot detach the only attached month")
+	}
+	delete(s.monthly.attached, month)
+	if err := s.rebuildViewsLocked(); err != nil {
+		s.monthly.attached[month] = path
+		return "", err
+	}
+	if _, err := s.db.Exec("DETACH " + monthAlias(month)); err != nil {
+		return "", err
+	}
+	s.forgetSources(monthAlias(month) + ".")
+	return path, nil
+}
+
+func (s *DuckDBStore) deleteMonthLocked(month string) (int64, error) {
+	var n int64
+	if err := s.db.QueryRow("SELECT count(*) FROM " + monthAlias(month) + ".points").Scan(&n); err != nil {
+		return 0, err
+	}
+	path, err := s.detachLocked(month)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Remove(path); err != nil {
+		return 0, err
+	}
+	os.Remove(path + ".wal")
+	return n, nil
+}
+
+// dropMonthsBefore deletes the files holding only rows older than t
+func (s *DuckDBStore) dropMonthsBefore(t time.Time) (int64, error) {
+	s.monthly.mu.Lock()
+	defer s.monthly.mu.Unlock()
+
+	var total int64
+	for _, month := range s.monthsLocked() {
+		if len(s.monthly.attached) == 1 {
+			break
+		}
+		old, err := s.olderThanLocked(month, t)
+		if err != nil {
+			return total, err
+		}
+		if !old {
+			continue
+		}
+		n, err := s.deleteMonthLocked(month)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// olderThanLocked reports whether a month's file has no rows from t on
+func (s *DuckDBStore) olderThanLocked(month string, t time.Time) (bool, error) {
+	if month != legacyMonth {
+		start, err := time.Parse("2006-01", month)
+		if err != nil {
+			return false, err
+		}
+		return !start.AddDate(0, 1, 0).After(t), nil
+	}
+	alias := monthAlias(month)
+	for _, table := range append([]string{"points"}, duckTables...) {
+		var newer bool
+		err := s.db.QueryRow("SELECT EXISTS (SELECT 1 FROM "+alias+"."+table+" WHERE time >= ?)", t).Scan(&newer)
+		if err != nil || newer {
+			return false, err
+		}
+	}
+	return true, nil
+}
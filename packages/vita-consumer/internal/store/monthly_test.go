@@ -0,0 +1,78 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Points written across month boundaries land in monthly files; queries
+// union them and old months drop as files
+func TestMonthlyFiles(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	duck, err := NewMonthlyDuckDBStore(dir, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer duck.Close()
+
+	var points []MetricPoint
+	for _, at := range []time.Time{now.AddDate(0, -2, 0), now.AddDate(0, -1, 0), now} {
+		points = append(points, MetricPoint{Time: at, ResourceID: 7, MetricType: "cpu_ms", Value: float64(at.Month())})
+	}
+	// Twice: retried writes must not duplicate rows in any month
+	for i := 0; i < 2; i++ {
+		if err := duck.BatchInsert(points); err != nil {
+			t.Fatal(err)
+		}
+	}
+	months, err := duck.Months()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(months) != 3 || months[0].Month != "2024-04" || months[2].Month != "2024-06" {
+		t.Fatalf("months = %+v, want 2024-04 through 2024-06", months)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "metrics-2024-05.duckdb")); err != nil {
+		t.Fatal(err)
+	}
+
+	series, err := duck.QuerySeries(t.Context(), "", 7, []string{"cpu_ms"}, now.AddDate(0, -3, 0), now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 3 || series[0].Value != 4 || series[2].Value != 6 {
+		t.Fatalf("series across months = %+v, want April, May and June once each", series)
+	}
+
+	// Retention ending mid-May drops April's file and May's older rows
+	n, err := duck.DeleteBefore(time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("DeleteBefore removed %d points, want 2", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "metrics-2024-04.duckdb")); !os.IsNotExist(err) {
+		t.Fatalf("April's file still exists (stat err %v)", err)
+	}
+	if months, _ = duck.Months(); len(months) != 2 {
+		t.Fatalf("months after prune = %+v, want May and June", months)
+	}
+
+	path, err := duck.DetachMonth("2024-05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if series, err = duck.QuerySeries(t.Context(), "", 7, []string{"cpu_ms"}, now.AddDate(0, -3, 0), now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if len(series) != 1 || series[0].Value != 6 {
+		t.Fatalf("series after detaching May = %+v, want June only", series)
+	}
+	if filepath.Base(path) != "metrics-2024-05.duckdb" {
+		t.Fatalf("detached path = %q", path)
+	}
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// VolumeFailure is a volume warning event reported for a pod
+type VolumeFailure struct {
+	EventUID    string
+	NamespaceID int64
+	PodID       *int64
+	PodName     string
+	PVCID       *int64
+	Volume      string
+	Reason      string
+	Message     string
+	Count       int32
+	FirstSeen   time.Time
+	LastSeen    time.Time
+}
+
+// UpsertVolumeFailure records a volume warning event, updating it as the
+// kubelet reports it again. A failure seen again after its pod became ready
+// is open again.
+func (s *SQLiteStore) UpsertVolumeFailure(f VolumeFailure) error {
+	_, err := s.db.Exec(`
+    INSERT INTO volume_failures (event_uid, namespace_id, pod_id, pod_name, pvc_id, volume, reason, message, count, first_seen, last_seen)
+    VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?)
+    ON CONFLICT(event_uid) DO UPDATE SET
+        pod_id = COALESCE(excluded.pod_id, pod_id),
+        pvc_id = COALESCE(excluded.pvc_id, pvc_id),
+        volume = COALESCE(excluded.volume, volume),
+        message = excluded.message,
+        count = excluded.count,
+        last_seen = excluded.last_seen,
+        resolved_at = CASE WHEN excluded.last_seen > last_seen THEN NULL ELSE resolved_at END`,
+		f.EventUID, f.NamespaceID, f.PodID, f.PodName, f.PVCID, f.Volume, f.Reason, f.Message, f.Count,
+		f.FirstSeen.UTC(), f.LastSeen.UTC())
+	return err
+}
+
+// ResolveVolumeFailures closes the open failures of a pod
+func (s *SQLiteStore) ResolveVolumeFailures(podID int64, at time.Time) error {
+	_, err := s.db.Exec(`UPDATE volume_failures SET resolved_at = ? WHERE pod_id = ? AND resolved_at IS NULL`, at.UTC(), podID)
+	return err
+}
+
+// PVCIDByName returns the live claim of a name in a namespace, or 0
+func (s *SQLiteStore) PVCIDByName(nsID int64, name string) (int64, error) {
+	var id int64
+	err := s.db.QueryRow(`SELECT id FROM pvcs WHERE namespace_id = ? AND name = ? AND deleted_at IS NULL
+		ORDER BY id DESC LIMIT 1`, nsID, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
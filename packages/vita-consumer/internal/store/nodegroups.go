@@ -0,0 +1,111 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// NodeGroup is where a node sits: its zone and region, the node pool it
+// was provisioned from, and whether it is spot or on-demand capacity.
+// Fields the node's labels do not say are empty.
+type NodeGroup struct {
+	Zone         string `json:"zone,omitempty"`
+	Region       string `json:"region,omitempty"`
+	Nodepool     string `json:"nodepool,omitempty"`
+	CapacityType string `json:"capacity_type,omitempty"` // spot or on-demand
+}
+
+// NodeGroupDimensions are the node group fields results can be grouped by
+var NodeGroupDimensions = []string{"zone", "region", "nodepool", "capacity_type"}
+
+// Value returns the field of a dimension in NodeGroupDimensions
+func (g NodeGroup) Value(dimension string) string {
+	switch dimension {
+	case "zone":
+		return g.Zone
+	case "region":
+		return g.Region
+	case "nodepool":
+		return g.Nodepool
+	case "capacity_type":
+		return g.CapacityType
+	}
+	return ""
+}
+
+// ValidNodeGroupDimension reports an error for a dimension not in
+// NodeGroupDimensions
+func ValidNodeGroupDimension(dimension string) error {
+	for _, d := range NodeGroupDimensions {
+		if d == dimension {
+			return nil
+		}
+	}
+	return fmt.Errorf("group_by must be one of zone, region, nodepool, capacity_type")
+}
+
+// SetNodeGroup records a node's group
+func (s *SQLiteStore) SetNodeGroup(id int64, g NodeGroup) error {
+	_, err := s.db.Exec(`UPDATE nodes SET zone = ?, region = ?, nodepool = ?, capacity_type = ? WHERE id = ?`,
+		g.Zone, g.Region, g.Nodepool, g.CapacityType, id)
+	return err
+}
+
+// NodeGroupsByID returns the group of every node, deleted ones included so
+// the usage of pods that ran on them stays attributable
+func (s *SQLiteStore) NodeGroupsByID() (map[int64]NodeGroup, error) {
+	return s.nodeGroups(`SELECT id, zone, region, nodepool, capacity_type FROM nodes`)
+}
+
+// NodeGroupsByName returns the group of every node by name. A name
+// registered again keeps the group of its live node.
+func (s *SQLiteStore) NodeGroupsByName() (map[string]NodeGroup, error) {
+	rows, err := s.db.Query(`SELECT name, zone, region, nodepool, capacity_type FROM nodes ORDER BY deleted_at IS NULL, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string]NodeGroup)
+	for rows.Next() {
+		var name string
+		var g NodeGroup
+		if err := scanNodeGroup(rows, &name, &g); err != nil {
+			return nil, err
+		}
+		out[name] = g
+	}
+	return out, rows.Err()
+}
+
+// PodNodeGroups maps pod IDs to the group of the node they ran on
+func (s *SQLiteStore) PodNodeGroups() (map[int64]NodeGroup, error) {
+	return s.nodeGroups(`SELECT p.id, n.zone, n.region, n.nodepool, n.capacity_type FROM pods p JOIN nodes n ON p.node_id = n.id`)
+}
+
+// nodeGroups reads (id, zone, region, nodepool, capacity_type) rows
+func (s *SQLiteStore) nodeGroups(query string) (map[int64]NodeGroup, error) {
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[int64]NodeGroup)
+	for rows.Next() {
+		var id int64
+		var g NodeGroup
+		if err := scanNodeGroup(rows, &id, &g); err != nil {
+			return nil, err
+		}
+		out[id] = g
+	}
+	return out, rows.Err()
+}
+
+func scanNodeGroup(rows *sql.Rows, key interface{}, g *NodeGroup) error {
+	var zone, region, nodepool, capacityType sql.NullString
+	if err := rows.Scan(key, &zone, &region, &nodepool, &capacityType); err != nil {
+		return err
+	}
+	*g = NodeGroup{Zone: zone.String, Region: region.String, Nodepool: nodepool.String, CapacityType: capacityType.String}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !faults
+
+package store
+
+import "database/sql"
+
+// openDB opens a database handle. Builds with the faults tag wrap the
+// driver to inject errors and latency (see faults.go).
+func openDB(backend, driverName, dsn string) (*sql.DB, error) {
+	c, err := driverConnector(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return openConnector(backend, c), nil
+}
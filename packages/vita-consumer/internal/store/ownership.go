@@ -0,0 +1,137 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ownershipTables are the catalog tables that keep ownership annotations
+var ownershipTables = map[string]string{
+	"namespace":   "namespaces",
+	"deployment":  "deployments",
+	"statefulset": "statefulsets",
+	"daemonset":   "daemonsets",
+}
+
+// SetOwnership records the ownership annotations (team, owner, ...) of a
+// namespace or workload. Empty values clear them.
+func (s *SQLiteStore) SetOwnership(kind string, id int64, values map[string]string) error {
+	table, ok := ownershipTables[kind]
+	if !ok {
+		return fmt.Errorf("unknown ownership kind %q", kind)
+	}
+	var encoded *string
+	if len(values) > 0 {
+		b, err := json.Marshal(values) // keys sorted, so unchanged values compare equal
+		if err != nil {
+			return err
+		}
+		v := string(b)
+		encoded = &v
+	}
+	_, err := s.db.Exec(fmt.Sprintf("UPDATE %s SET ownership = ? WHERE id = ? AND ownership IS NOT ?", table), encoded, id, encoded)
+	return err
+}
+
+// PodOwnership returns the ownership annotations of every pod: its
+// workload's, falling back key by key to its namespace's. Pods with none
+// are left out.
+func (s *SQLiteStore) PodOwnership() (map[int64]map[string]string, error) {
+	rows, err := s.db.Query(`
+		SELECT p.id, ns.ownership, COALESCE(d.ownership, sts.ownership, ds.ownership)
+		FROM pods p
+		JOIN namespaces ns ON p.namespace_id = ns.id
+		LEFT JOIN deployments d ON p.deployment_id = d.id
+		LEFT JOIN statefulsets sts ON p.statefulset_id = sts.id
+		LEFT JOIN daemonsets ds ON p.daemonset_id = ds.id
+		WHERE ns.ownership IS NOT NULL OR d.ownership IS NOT NULL
+			OR sts.ownership IS NOT NULL OR ds.ownership IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]map[string]string)
+	for rows.Next() {
+		var id int64
+		var namespace, workload sql.NullString
+		if err := rows.Scan(&id, &namespace, &workload); err != nil {
+			return nil, err
+		}
+		owners := map[string]string{}
+		for _, raw := range []sql.NullString{namespace, workload} {
+			if raw.Valid {
+				json.Unmarshal([]byte(raw.String), &owners) // workload keys overwrite
+			}
+		}
+		out[id] = owners
+	}
+	return out, rows.Err()
+}
+
+// NamespaceOwnership returns the ownership annotations of namespaces by name
+func (s *SQLiteStore) NamespaceOwnership() (map[string]map[string]string, error) {
+	rows, err := s.db.Query(`SELECT name, ownership FROM namespaces WHERE ownership IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]map[string]string)
+	for rows.Next() {
+		var name, raw string
+		if err := rows.Scan(&name, &raw); err != nil {
+			return nil, err
+		}
+		owners := map[string]string{}
+		if err := json.Unmarshal([]byte(raw), &owners); err != nil {
+			continue
+		}
+		out[name] = owners
+	}
+	return out, rows.Err()
+}
+
+// OwnerFilter selects resources by ownership annotations; every key must
+// match
+type OwnerFilter map[string]string
+
+// ParseOwnerFilter reads "team:payments,cost-center:42"
+func ParseOwnerFilter(spec string) (OwnerFilter, error) {
+	f := OwnerFilter{}
+	for _, part := range strings.Split(spec, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, ":")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" || v == "" {
+			return nil, fmt.Errorf("invalid owner filter %q, want key:value", part)
+		}
+		f[k] = v
+	}
+	return f, nil
+}
+
+// Matches reports whether owners satisfy the filter
+func (f OwnerFilter) Matches(owners map[string]string) bool {
+	for k, v := range f {
+		if owners[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// String is the filter in ParseOwnerFilter's form
+func (f OwnerFilter) String() string {
+	parts := make([]string, 0, len(f))
+	for k, v := range f {
+		parts = append(parts, k+":"+v)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,77 @@
+package store
+
+import "encoding/json"
+
+// PlacementRules are the scheduling constraints of a pod spec that decide
+// how its workload spreads: topology spread constraints and pod
+// (anti-)affinity terms. Selectors are in label selector syntax
+// ("app=web,tier in (a,b)").
+type PlacementRules struct {
+	Spread   []SpreadConstraint `json:"spread,omitempty"`
+	Affinity []AffinityTerm     `json:"affinity,omitempty"`
+}
+
+// SpreadConstraint is a topology spread constraint, matchLabelKeys folded
+// into Selector
+type SpreadConstraint struct {
+	TopologyKey       string `json:"topology_key"`
+	MaxSkew           int32  `json:"max_skew"`
+	WhenUnsatisfiable string `json:"when_unsatisfiable"` // DoNotSchedule or ScheduleAnyway
+	MinDomains        int32  `json:"min_domains,omitempty"`
+	Selector          string `json:"selector"`
+}
+
+// AffinityTerm is one pod affinity or anti-affinity term
+type AffinityTerm struct {
+	Anti        bool   `json:"anti"`
+	Required    bool   `json:"required"`         // requiredDuringScheduling, else preferred
+	Weight      int32  `json:"weight,omitempty"` // of a preferred term
+	TopologyKey string `json:"topology_key"`
+	// Namespaces the selector applies in; empty means the pod's own
+	Namespaces []string `json:"namespaces,omitempty"`
+	Selector   string   `json:"selector"`
+}
+
+// Empty reports whether there are no rules
+func (r PlacementRules) Empty() bool {
+	return len(r.Spread) == 0 && len(r.Affinity) == 0
+}
+
+// SetPodPlacement records a pod's labels and placement rules as JSON,
+// NULL when there are none
+func (s *SQLiteStore) SetPodPlacement(id int64, labels map[string]string, rules PlacementRules) error {
+	encodedLabels, err := jsonOrNull(labels, len(labels) == 0)
+	if err != nil {
+		return err
+	}
+	encodedRules, err := jsonOrNull(rules, rules.Empty())
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`UPDATE pods SET labels = ?, placement = ? WHERE id = ? AND (labels IS NOT ? OR placement IS NOT ?)`,
+		encodedLabels, encodedRules, id, encodedLabels, encodedRules)
+	return err
+}
+
+// SetNodeLabels records a node's labels as JSON, which topology keys
+// (zone, hostname, ...) are looked up in
+func (s *SQLiteStore) SetNodeLabels(id int64, labels map[string]string) error {
+	encoded, err := jsonOrNull(labels, len(labels) == 0)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`UPDATE nodes SET labels = ? WHERE id = ? AND labels IS NOT ?`, encoded, id, encoded)
+	return err
+}
+
+func jsonOrNull(v interface{}, empty bool) (*string, error) {
+	if empty {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	out := string(b)
+	return &out, nil
+}
@@ -0,0 +1,48 @@
+package store
+
+import "encoding/json"
+
+// GetPreferences returns every preference stored for a scope
+func (s *SQLiteStore) GetPreferences(scope string) (map[string]json.RawMessage, error) {
+	rows, err := s.db.Query("SELECT key, value FROM preferences WHERE scope = ? ORDER BY key", scope)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]json.RawMessage)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		out[key] = json.RawMessage(value)
+	}
+	return out, rows.Err()
+}
+
+// GetPreference returns sql.ErrNoRows when the key is unset
+func (s *SQLiteStore) GetPreference(scope, key string) (json.RawMessage, error) {
+	var value string
+	err := s.db.QueryRow("SELECT value FROM preferences WHERE scope = ? AND key = ?", scope, key).Scan(&value)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(value), nil
+}
+
+func (s *SQLiteStore) SetPreference(scope, key string, value json.RawMessage) error {
+	_, err := s.db.Exec(`INSERT INTO preferences (scope, key, value, updated_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(scope, key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP`, scope, key, string(value))
+	return err
+}
+
+// DeletePreference reports whether the key existed
+func (s *SQLiteStore) DeletePreference(scope, key string) (bool, error) {
+	res, err := s.db.Exec("DELETE FROM preferences WHERE scope = ? AND key = ?", scope, key)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
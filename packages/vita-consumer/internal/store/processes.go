@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ProcessSample is one process inside a pod as an agent saw it
+type ProcessSample struct {
+	Time        time.Time
+	PodID       int64
+	ContainerID string
+	PID         int64
+	Name        string
+	CPUms       float64 // cumulative
+	MemMB       float64
+	SourceID    int64 // see RegisterSource
+}
+
+// InsertProcesses stores process samples. Unlike metrics they are not
+// deduplicated: the recorder writes each sample once.
+func (s *DuckDBStore) InsertProcesses(samples []ProcessSample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	tables, err := byTable(s, "process_samples", samples, func(p ProcessSample) time.Time { return p.Time })
+	if err != nil {
+		return err
+	}
+	for table, rows := range tables {
+		if err := s.insertProcesses(table, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *DuckDBStore) insertProcesses(table string, samples []ProcessSample) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO ` + table + ` (time, pod_id, container_id, pid, name, cpu_ms, mem_mb, source_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	stamp := s.stamper()
+	sources := map[int64]bool{}
+	for _, p := range samples {
+		src := stamp(p.SourceID)
+		if _, err := stmt.Exec(p.Time, p.PodID, p.ContainerID, p.PID, p.Name, p.CPUms, p.MemMB, src); err != nil {
+			return err
+		}
+		if id, ok := src.(int64); ok {
+			sources[id] = true
+		}
+	}
+	listed, err := s.listSources(tx, table, sources, samples[0].Time)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	listed()
+	return nil
+}
+
+// QueryProcesses returns the process samples of one pod in [from, to),
+// ordered by time
+func (s *DuckDBStore) QueryProcesses(ctx context.Context, podID int64, from, to time.Time) ([]ProcessSample, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT time, container_id, pid, name, cpu_ms, mem_mb
+		FROM process_samples
+		WHERE pod_id = ? AND time >= ? AND time < ?
+		ORDER BY time`, podID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []ProcessSample{}
+	for rows.Next() {
+		p := ProcessSample{PodID: podID}
+		if err := rows.Scan(&p.Time, &p.ContainerID, &p.PID, &p.Name, &p.CPUms, &p.MemMB); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// DeleteProcessesBefore removes process samples older than t
+func (s *DuckDBStore) DeleteProcessesBefore(t time.Time) (int64, error) {
+	return s.execAll("process_samples", "DELETE FROM {t} WHERE time < ?", t)
+}
+
+// DeleteProcesses removes the process samples of the given pods
+func (s *DuckDBStore) DeleteProcesses(podIDs []int64) (int64, error) {
+	if len(podIDs) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, len(podIDs))
+	for i, id := range podIDs {
+		args[i] = id
+	}
+	return s.execAll("process_samples", fmt.Sprintf("DELETE FROM {t} WHERE pod_id IN (%s)",
+		strings.TrimSuffix(strings.Repeat("?,", len(podIDs)), ",")), args...)
+}
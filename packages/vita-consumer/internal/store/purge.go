@@ -0,0 +1,203 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrPurgeConflict is returned when other catalog rows still depend on the
+// resource and are not purged with it
+var ErrPurgeConflict = errors.New("resource is still referenced")
+
+// PurgeSet is a resource and everything removed along with it, by table
+type PurgeSet struct {
+	Kind string
+	ID   int64
+	Name string
+	IDs  map[string][]int64
+}
+
+// purgeKinds maps purgeable kinds to their tables
+var purgeKinds = map[string]string{
+	"namespace":  "namespaces",
+	"node":       "nodes",
+	"deployment": "deployments",
+	"pod":        "pods",
+	"pvc":        "pvcs",
+}
+
+// Count returns the number of rows of a table in the set
+func (p *PurgeSet) Count(table string) int {
+	return len(p.IDs[table])
+}
+
+// PurgeSet collects what purging a resource removes: a namespace takes
+// every namespaced resource in it, a deployment takes its pods. A node can
+// only go once no pod references it. Returns sql.ErrNoRows for unknown
+// resources.
+func (s *SQLiteStore) PurgeSet(kind string, id int64) (*PurgeSet, error) {
+	table, ok := purgeKinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("cannot purge kind %q", kind)
+	}
+	set := &PurgeSet{Kind: kind, ID: id, IDs: map[string][]int64{table: {id}}}
+	if err := s.db.QueryRow(fmt.Sprintf("SELECT name FROM %s WHERE id = ?", table), id).Scan(&set.Name); err != nil {
+		return nil, err
+	}
+
+	var err error
+	collect := func(table, where string) {
+		if err != nil {
+			return
+		}
+		var ids []int64
+		ids, err = s.ids(fmt.Sprintf("SELECT id FROM %s WHERE %s", table, where), id)
+		if len(ids) > 0 {
+			set.IDs[table] = ids
+		}
+	}
+	switch kind {
+	case "namespace":
+		for _, t := range []string{"pods", "pvcs", "deployments", "statefulsets", "daemonsets", "pdbs", "services", "ingresses"} {
+			collect(t, "namespace_id = ?")
+		}
+	case "deployment":
+		collect("pods", "deployment_id = ?")
+	case "node":
+		pods, qerr := s.ids("SELECT id FROM pods WHERE node_id = ? LIMIT 1", id)
+		if qerr != nil {
+			return nil, qerr
+		}
+		if len(pods) > 0 {
+			return nil, fmt.Errorf("%w: pods still reference node %s", ErrPurgeConflict, set.Name)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+func (s *SQLiteStore) ids(query string, args ...interface{}) ([]int64, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// inArgs returns placeholders and arguments for an IN (...) clause
+func inArgs(ids []int64) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", len(ids)), ","), args
+}
+
+// Purge deletes a set's catalog rows in one transaction. References from
+// rows outside the set (e.g. a service selecting a purged deployment) are
+// cleared rather than deleted. The rows are queued for a sweep at sweepAt
+// (see DuePurgeSweeps), to delete points that were still buffered when
+// the purge ran.
+func (s *SQLiteStore) Purge(set *PurgeSet, sweepAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	exec := func(query string, args ...interface{}) {
+		if err == nil {
+			_, err = tx.Exec(query, args...)
+		}
+	}
+
+	// Referencing rows first, as foreign keys are enforced
+	if ids := set.IDs["deployments"]; len(ids) > 0 {
+		marks, args := inArgs(ids)
+		for _, t := range []string{"pods", "pdbs", "services"} {
+			exec(fmt.Sprintf("UPDATE %s SET deployment_id = NULL WHERE deployment_id IN (%s)", t, marks), args...)
+		}
+	}
+	if set.Kind == "namespace" {
+		exec("DELETE FROM annotations WHERE namespace_id = ?", set.ID)
+	}
+	if ids := set.IDs["pvcs"]; len(ids) > 0 {
+		marks, args := inArgs(ids)
+		exec(fmt.Sprintf("DELETE FROM pvc_resizes WHERE pvc_id IN (%s)", marks), args...)
+		exec(fmt.Sprintf("DELETE FROM volume_failures WHERE pvc_id IN (%s)", marks), args...)
+	}
+	if ids := set.IDs["pods"]; len(ids) > 0 {
+		marks, args := inArgs(ids)
+		exec(fmt.Sprintf("DELETE FROM volume_failures WHERE pod_id IN (%s)", marks), args...)
+	}
+	if set.Kind == "namespace" {
+		exec("DELETE FROM volume_failures WHERE namespace_id = ?", set.ID)
+	}
+	for kind, t := range WorkloadKinds {
+		if ids := set.IDs[t]; len(ids) > 0 {
+			marks, args := inArgs(ids)
+			exec(fmt.Sprintf("DELETE FROM workload_health WHERE kind = '%s' AND workload_id IN (%s)", kind, marks), args...)
+			exec(fmt.Sprintf("DELETE FROM slo_buckets WHERE slo_id IN (SELECT id FROM slos WHERE kind = '%s' AND workload_id IN (%s))", kind, marks), args...)
+			exec(fmt.Sprintf("DELETE FROM slos WHERE kind = '%s' AND workload_id IN (%s)", kind, marks), args...)
+		}
+	}
+	for _, t := range []string{"pdbs", "services", "ingresses", "pods", "pvcs", "deployments", "statefulsets", "daemonsets", "nodes", "namespaces"} {
+		if ids := set.IDs[t]; len(ids) > 0 {
+			marks, args := inArgs(ids)
+			exec(fmt.Sprintf("DELETE FROM %s WHERE id IN (%s)", t, marks), args...)
+		}
+	}
+	if err == nil {
+		err = deleteLatest(tx, set.IDs)
+	}
+	for t, ids := range set.IDs {
+		for _, id := range ids {
+			exec("INSERT INTO purge_sweeps (table_name, resource_id, due_at) VALUES (?, ?, ?)", t, id, sweepAt.UTC())
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DuePurgeSweeps returns the purged rows whose sweep is due at now, by
+// table. IDs are never reused, so their points can be deleted however
+// late the sweep runs.
+func (s *SQLiteStore) DuePurgeSweeps(now time.Time) (map[string][]int64, error) {
+	rows, err := s.db.Query("SELECT table_name, resource_id FROM purge_sweeps WHERE due_at <= ?", now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := make(map[string][]int64)
+	for rows.Next() {
+		var table string
+		var id int64
+		if err := rows.Scan(&table, &id); err != nil {
+			return nil, err
+		}
+		out[table] = append(out[table], id)
+	}
+	return out, rows.Err()
+}
+
+// DeletePurgeSweeps removes the sweeps due at now, once they ran
+func (s *SQLiteStore) DeletePurgeSweeps(now time.Time) error {
+	_, err := s.db.Exec("DELETE FROM purge_sweeps WHERE due_at <= ?", now.UTC())
+	return err
+}
@@ -0,0 +1,56 @@
+package store
+
+import "fmt"
+
+// ReplicaPod is one incarnation of a StatefulSet replica
+type ReplicaPod struct {
+	Ordinal       int
+	PodID         int64
+	UID           string
+	Name          string
+	Node          string
+	StatefulSetID int64
+}
+
+// StatefulSetReplicas lists the pods that have filled each ordinal of a
+// StatefulSet, including pods of earlier StatefulSets with the same name,
+// by ordinal and then oldest first.
+func (s *SQLiteStore) StatefulSetReplicas(stsID int64) ([]ReplicaPod, error) {
+	lineage, err := s.Lineage("statefulsets", stsID)
+	if err != nil {
+		return nil, err
+	}
+	marks, args := inArgs(lineage)
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT p.ordinal, p.id, p.uid, p.name, COALESCE(n.name, ''), p.statefulset_id
+		FROM pods p
+		LEFT JOIN nodes n ON p.node_id = n.id
+		WHERE p.statefulset_id IN (%s) AND p.ordinal IS NOT NULL
+		ORDER BY p.ordinal, p.id`, marks), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ReplicaPod
+	for rows.Next() {
+		var r ReplicaPod
+		if err := rows.Scan(&r.Ordinal, &r.PodID, &r.UID, &r.Name, &r.Node, &r.StatefulSetID); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// ReplicaPodIDs returns the ids of the pods that have filled one ordinal
+// of a StatefulSet, oldest first
+func (s *SQLiteStore) ReplicaPodIDs(stsID int64, ordinal int) ([]int64, error) {
+	lineage, err := s.Lineage("statefulsets", stsID)
+	if err != nil {
+		return nil, err
+	}
+	marks, args := inArgs(lineage)
+	return s.ids(fmt.Sprintf(`SELECT id FROM pods
+		WHERE statefulset_id IN (%s) AND ordinal = ?
+		ORDER BY id`, marks), append(args, ordinal)...)
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ReportTemplate is a scheduled report definition
+type ReportTemplate struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Format     string     `json:"format"` // "html" or "json"
+	Body       string     `json:"body"`   // html/template source; empty uses the built-in layout
+	Schedule   string     `json:"schedule"`
+	PeriodDays int        `json:"period_days"`
+	Timezone   string     `json:"timezone,omitempty"` // IANA zone; periods then end at local midnight
+	Delivery   string     `json:"delivery"`           // "smtp" or "webhook"
+	Target     string     `json:"target"`             // comma-separated addresses, or a URL
+	GroupBy    string     `json:"group_by,omitempty"` // ownership annotation to total usage by
+	Owners     string     `json:"owners,omitempty"`   // only pods whose ownership matches, "team:payments"
+	Enabled    bool       `json:"enabled"`
+	ManagedBy  string     `json:"managed_by,omitempty"` // custom resource it is synced from, see ManagedBy
+	LastRunAt  *time.Time `json:"last_run_at,omitempty"`
+	LastError  *string    `json:"last_error,omitempty"`
+}
+
+const reportColumns = `id, name, format, body, schedule, period_days, timezone, delivery, target, group_by, owners, enabled, managed_by, last_run_at, last_error`
+
+func scanReportTemplate(row interface{ Scan(...interface{}) error }) (ReportTemplate, error) {
+	var t ReportTemplate
+	var lastRun sql.NullTime
+	var lastErr sql.NullString
+	err := row.Scan(&t.ID, &t.Name, &t.Format, &t.Body, &t.Schedule, &t.PeriodDays, &t.Timezone, &t.Delivery, &t.Target, &t.GroupBy, &t.Owners, &t.Enabled, &t.ManagedBy, &lastRun, &lastErr)
+	if lastRun.Valid {
+		t.LastRunAt = &lastRun.Time
+	}
+	if lastErr.Valid {
+		t.LastError = &lastErr.String
+	}
+	return t, err
+}
+
+// ListReportTemplates returns every template ordered by name
+func (s *SQLiteStore) ListReportTemplates() ([]ReportTemplate, error) {
+	rows, err := s.db.Query(`SELECT ` + reportColumns + ` FROM report_templates ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []ReportTemplate{}
+	for rows.Next() {
+		t, err := scanReportTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// GetReportTemplate returns sql.ErrNoRows when the template does not exist
+func (s *SQLiteStore) GetReportTemplate(id int64) (ReportTemplate, error) {
+	return scanReportTemplate(s.db.QueryRow(`SELECT `+reportColumns+` FROM report_templates WHERE id = ?`, id))
+}
+
+// UpsertReportTemplate creates or replaces a template by name
+func (s *SQLiteStore) UpsertReportTemplate(t ReportTemplate) (int64, error) {
+	var id int64
+	err := s.db.QueryRow(`
+    INSERT INTO report_templates (name, format, body, schedule, period_days, timezone, delivery, target, group_by, owners, enabled, managed_by, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+    ON CONFLICT(name) DO UPDATE SET
+        format = excluded.format,
+        body = excluded.body,
+        schedule = excluded.schedule,
+        period_days = excluded.period_days,
+        timezone = excluded.timezone,
+        delivery = excluded.delivery,
+        target = excluded.target,
+        group_by = excluded.group_by,
+        owners = excluded.owners,
+        enabled = excluded.enabled,
+        managed_by = excluded.managed_by,
+        updated_at = CURRENT_TIMESTAMP
+    RETURNING id;
+    `, t.Name, t.Format, t.Body, t.Schedule, t.PeriodDays, t.Timezone, t.Delivery, t.Target, t.GroupBy, t.Owners, t.Enabled, t.ManagedBy).Scan(&id)
+	return id, err
+}
+
+func (s *SQLiteStore) DeleteReportTemplate(id int64) error {
+	_, err := s.db.Exec("DELETE FROM report_templates WHERE id = ?", id)
+	return err
+}
+
+// MarkReportRun records the outcome of a delivery; runErr nil clears the last error
+func (s *SQLiteStore) MarkReportRun(id int64, at time.Time, runErr error) error {
+	var msg *string
+	if runErr != nil {
+		m := runErr.Error()
+		msg = &m
+	}
+	_, err := s.db.Exec("UPDATE report_templates SET last_run_at = ?, last_error = ? WHERE id = ?", at.UTC(), msg, id)
+	return err
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SetRetention records the retention annotation of a namespace or
+// workload (see the retention package). Empty clears it.
+func (s *SQLiteStore) SetRetention(kind string, id int64, spec string) error {
+	table, ok := ownershipTables[kind]
+	if !ok {
+		return fmt.Errorf("unknown retention kind %q", kind)
+	}
+	var value *string
+	if spec != "" {
+		value = &spec
+	}
+	_, err := s.db.Exec(fmt.Sprintf("UPDATE %s SET retention = ? WHERE id = ? AND retention IS NOT ?", table), value, id, value)
+	return err
+}
+
+// PodRetention returns the retention annotation of every pod that has one
+// through its workload or namespace. A workload's replaces its
+// namespace's.
+func (s *SQLiteStore) PodRetention() (map[int64]string, error) {
+	rows, err := s.db.Query(`
+		SELECT p.id, COALESCE(d.retention, sts.retention, ds.retention, ns.retention)
+		FROM pods p
+		JOIN namespaces ns ON p.namespace_id = ns.id
+		LEFT JOIN deployments d ON p.deployment_id = d.id
+		LEFT JOIN statefulsets sts ON p.statefulset_id = sts.id
+		LEFT JOIN daemonsets ds ON p.daemonset_id = ds.id
+		WHERE ns.retention IS NOT NULL OR d.retention IS NOT NULL
+			OR sts.retention IS NOT NULL OR ds.retention IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var spec string
+		if err := rows.Scan(&id, &spec); err != nil {
+			return nil, err
+		}
+		out[id] = spec
+	}
+	return out, rows.Err()
+}
+
+// ResourceSet picks series by pod: the listed pods' series, or with
+// Except set every series but theirs. Series of other kinds sharing a
+// listed ID are never taken for the pod's.
+type ResourceSet struct {
+	IDs    []int64
+	Except bool
+}
+
+// filter returns the condition on series_id selecting the set, with its
+// arguments
+func (r ResourceSet) filter() (string, []interface{}) {
+	if len(r.IDs) == 0 {
+		if r.Except {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	}
+	args := make([]interface{}, 0, len(r.IDs))
+	for _, id := range r.IDs {
+		args = append(args, id)
+	}
+	op := "IN"
+	if r.Except {
+		op = "NOT IN"
+	}
+	return fmt.Sprintf("series_id %s (SELECT id FROM {series} WHERE resource_kind = 'pod' AND resource_id IN (%s))",
+		op, strings.TrimSuffix(strings.Repeat("?,", len(r.IDs)), ",")), args
+}
+
+// DeleteSeriesBefore removes the points of the set's resources older than
+// t, and series left without points. Returns the number of points
+// removed.
+func (s *DuckDBStore) DeleteSeriesBefore(set ResourceSet, t time.Time) (int64, error) {
+	if len(set.IDs) == 0 && !set.Except {
+		return 0, nil
+	}
+	cond, args := set.filter()
+	n, err := s.execAll("points", "DELETE FROM {t} WHERE time < ? AND "+cond, append([]interface{}{t}, args...)...)
+	if err != nil || n == 0 {
+		return n, err
+	}
+	_, err = s.execAll("series", "DELETE FROM {t} WHERE id NOT IN (SELECT DISTINCT series_id FROM {points})")
+	return n, err
+}
+
+// ThinPoints keeps one point, the latest, per step of each series of the
+// set's resources among points older than before, and returns how many
+// were removed. Points of a step that straddles before are thinned again
+// as they age, so every step ends up with one point.
+func (s *DuckDBStore) ThinPoints(set ResourceSet, step time.Duration, before time.Time) (int64, error) {
+	if len(set.IDs) == 0 && !set.Except {
+		return 0, nil
+	}
+	cond, args := set.filter()
+	return s.execAll("points", `DELETE FROM {t} WHERE rowid IN (
+		SELECT rid FROM (
+			SELECT rowid AS rid, row_number() OVER (
+				PARTITION BY series_id, floor(epoch(time::TIMESTAMP) / ?::DOUBLE) ORDER BY time DESC
+			) AS n
+			FROM {t} WHERE time < ? AND `+cond+`
+		) WHERE n > 1
+	)`, append([]interface{}{step.Seconds(), before}, args...)...)
+}
+
+// DeleteNodeTotalsBefore removes node and cluster rollups older than t
+func (s *DuckDBStore) DeleteNodeTotalsBefore(t time.Time) (int64, error) {
+	return s.execAll("node_totals", "DELETE FROM {t} WHERE time < ?", t)
+}
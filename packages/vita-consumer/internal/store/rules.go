@@ -0,0 +1,93 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RecordingRule defines a derived series, e.g. name "namespace_cpu" with
+// expr "sum(cpu_ms rate) by namespace"
+type RecordingRule struct {
+	ID          int64      `json:"id"`
+	Name        string     `json:"name"`
+	Expr        string     `json:"expr"`
+	IntervalSec int        `json:"interval_sec"`
+	Enabled     bool       `json:"enabled"`
+	ManagedBy   string     `json:"managed_by,omitempty"`   // custom resource it is synced from, see ManagedBy
+	LastEvalAt  *time.Time `json:"last_eval_at,omitempty"` // end of the last evaluated window
+	LastError   *string    `json:"last_error,omitempty"`
+}
+
+const ruleColumns = `id, name, expr, interval_sec, enabled, managed_by, last_eval_at, last_error`
+
+func scanRecordingRule(row interface{ Scan(...interface{}) error }) (RecordingRule, error) {
+	var r RecordingRule
+	var lastEval sql.NullTime
+	var lastErr sql.NullString
+	err := row.Scan(&r.ID, &r.Name, &r.Expr, &r.IntervalSec, &r.Enabled, &r.ManagedBy, &lastEval, &lastErr)
+	if lastEval.Valid {
+		r.LastEvalAt = &lastEval.Time
+	}
+	if lastErr.Valid {
+		r.LastError = &lastErr.String
+	}
+	return r, err
+}
+
+func (s *SQLiteStore) ListRecordingRules() ([]RecordingRule, error) {
+	rows, err := s.db.Query(`SELECT ` + ruleColumns + ` FROM recording_rules ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []RecordingRule{}
+	for rows.Next() {
+		r, err := scanRecordingRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// GetRecordingRule returns sql.ErrNoRows when the rule does not exist
+func (s *SQLiteStore) GetRecordingRule(id int64) (RecordingRule, error) {
+	return scanRecordingRule(s.db.QueryRow(`SELECT `+ruleColumns+` FROM recording_rules WHERE id = ?`, id))
+}
+
+// UpsertRecordingRule creates or replaces a rule by name. Changing the
+// expression restarts evaluation from scratch.
+func (s *SQLiteStore) UpsertRecordingRule(r RecordingRule) (int64, error) {
+	var id int64
+	err := s.db.QueryRow(`
+    INSERT INTO recording_rules (name, expr, interval_sec, enabled, managed_by, updated_at)
+    VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+    ON CONFLICT(name) DO UPDATE SET
+        last_eval_at = CASE WHEN expr = excluded.expr AND interval_sec = excluded.interval_sec THEN last_eval_at END,
+        expr = excluded.expr,
+        interval_sec = excluded.interval_sec,
+        enabled = excluded.enabled,
+        managed_by = excluded.managed_by,
+        updated_at = CURRENT_TIMESTAMP
+    RETURNING id;
+    `, r.Name, r.Expr, r.IntervalSec, r.Enabled, r.ManagedBy).Scan(&id)
+	return id, err
+}
+
+func (s *SQLiteStore) DeleteRecordingRule(id int64) error {
+	_, err := s.db.Exec("DELETE FROM recording_rules WHERE id = ?", id)
+	return err
+}
+
+// MarkRuleEvaluated records how far a rule has been evaluated. On failure
+// only the error is stored so the window is retried.
+func (s *SQLiteStore) MarkRuleEvaluated(id int64, through time.Time, evalErr error) error {
+	if evalErr != nil {
+		_, err := s.db.Exec("UPDATE recording_rules SET last_error = ? WHERE id = ?", evalErr.Error(), id)
+		return err
+	}
+	_, err := s.db.Exec("UPDATE recording_rules SET last_eval_at = ?, last_error = NULL WHERE id = ?", through.UTC(), id)
+	return err
+}
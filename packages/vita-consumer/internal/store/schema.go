@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// SchemaInfo describes a database for support: its engine version, tables
+// and files
+type SchemaInfo struct {
+	Version string        `json:"version"`
+	Tables  []TableSchema `json:"tables"`
+	Files   []FileSize    `json:"files"`
+}
+
+// TableSchema is one table's columns and row count; DuckDB's is estimated
+type TableSchema struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Rows    int64    `json:"rows"`
+}
+
+// FileSize is a database file and its size on disk
+type FileSize struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+func fileSizes(paths []string) []FileSize {
+	out := []FileSize{}
+	for _, p := range paths {
+		f := FileSize{Path: p}
+		if st, err := os.Stat(p); err == nil {
+			f.Bytes = st.Size()
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+// Schema lists the catalog's tables with their columns and row counts
+func (s *SQLiteStore) Schema() (SchemaInfo, error) {
+	info := SchemaInfo{Tables: []TableSchema{}, Files: fileSizes([]string{s.path})}
+	if err := s.db.QueryRow("SELECT sqlite_version()").Scan(&info.Version); err != nil {
+		return info, err
+	}
+	rows, err := s.db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return info, err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return info, err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return info, err
+	}
+
+	for _, name := range names {
+		t := TableSchema{Name: name}
+		if t.Columns, err = tableColumns(s.db, name); err != nil {
+			return info, err
+		}
+		if err := s.db.QueryRow(fmt.Sprintf("SELECT count(*) FROM %q", name)).Scan(&t.Rows); err != nil {
+			return info, err
+		}
+		info.Tables = append(info.Tables, t)
+	}
+	return info, nil
+}
+
+// Schema lists the tables of every attached database file with their
+// columns and estimated row counts
+func (s *DuckDBStore) Schema(ctx context.Context) (SchemaInfo, error) {
+	info := SchemaInfo{Tables: []TableSchema{}, Files: fileSizes(s.Files())}
+	if err := s.db.QueryRowContext(ctx, "SELECT version()").Scan(&info.Version); err != nil {
+		return info, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT t.database_name || '.' || t.table_name, t.estimated_size, c.column_name
+		FROM duckdb_tables() t
+		JOIN duckdb_columns() c ON c.table_oid = t.table_oid
+		WHERE NOT t.internal
+		ORDER BY t.database_name, t.table_name, c.column_index`)
+	if err != nil {
+		return info, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, column string
+		var size int64
+		if err := rows.Scan(&name, &size, &column); err != nil {
+			return info, err
+		}
+		if n := len(info.Tables); n == 0 || info.Tables[n-1].Name != name {
+			info.Tables = append(info.Tables, TableSchema{Name: name, Rows: size})
+		}
+		t := &info.Tables[len(info.Tables)-1]
+		t.Columns = append(t.Columns, column)
+	}
+	return info, rows.Err()
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// metricsView reads the points of the database named by prefix with their
+// resource and metric type, as the metrics table stored them before series
+// were split out
+func metricsView(prefix string) string {
+	return strings.ReplaceAll(`SELECT p.time, s.resource_kind, s.resource_id, s.metric_type, p.value, p.agg_type, p.source_id, p.sample_rate
+        FROM {p}points p JOIN {p}series s ON s.id = p.series_id`, "{p}", prefix)
+}
+
+// siblingTable is name in the same database file as table
+func siblingTable(table, name string) string {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		return table[:i+1] + name
+	}
+	return name
+}
+
+// seriesTable is the series table in the same database file as table
+func seriesTable(table string) string {
+	return siblingTable(table, "series")
+}
+
+// assignSeries adds the series of metrics_staging that series does not
+// list yet, numbering on from its largest id. Callers hold seriesMu, so
+// no other write picks the same ids.
+func assignSeries(tx *sql.Tx, series string) error {
+	_, err := tx.Exec(`INSERT INTO ` + series + ` (id, resource_kind, resource_id, metric_type)
+        SELECT (SELECT coalesce(max(id), 0) FROM ` + series + `) + row_number() OVER (ORDER BY resource_kind, resource_id, metric_type), resource_kind, resource_id, metric_type
+        FROM (
+            SELECT DISTINCT resource_kind, resource_id, metric_type FROM metrics_staging
+            EXCEPT
+            SELECT resource_kind, resource_id, metric_type FROM ` + series + `
+        )`)
+	return err
+}
+
+// legacySeriesKinds are the kinds of the metric types written before
+// series recorded one. Volume types are not listed: they were written for
+// claims and for the pods mounting other volumes alike. Neither are
+// recording rule outputs, whose kind is their rule's grouping.
+var legacySeriesKinds = map[string][]string{
+	"pod": {
+		"cpu_ms", "cpu_throttled_ms", "cpu_periods", "cpu_throttled_periods",
+		"mem_mb", "mem_limit_mb", "mem_working_set_mb", "mem_rss_mb", "mem_cache_mb", "mem_swap_mb",
+		"scheduling_latency_ms", "startup_latency_ms",
+	},
+	"deployment": {"replicas_desired", "replicas_ready", "replicas_available", "replicas_updated"},
+}
+
+// backfillSeriesKinds sets the kind of series written before series
+// recorded one, where their type tells it
+func backfillSeriesKinds(db *sql.DB, prefix string) error {
+	for kind, types := range legacySeriesKinds {
+		args := []interface{}{kind}
+		for _, t := range types {
+			args = append(args, t)
+		}
+		if _, err := db.Exec(fmt.Sprintf(`UPDATE %sseries SET resource_kind = ? WHERE resource_kind IS NULL AND metric_type IN (%s)`,
+			prefix, strings.TrimSuffix(strings.Repeat("?,", len(types)), ",")), args...); err != nil {
+			return fmt.Errorf("setting kinds of %sseries: %w", prefix, err)
+		}
+	}
+	return nil
+}
+
+// migrateSeries moves the rows of a metrics table, as written before
+// series were split out, into series and points, and drops it. A file is
+// migrated in one transaction, the first time it is opened.
+func migrateSeries(db *sql.DB, prefix string) error {
+	catalog := "current_database()"
+	if prefix != "" {
+		catalog = quoteLiteral(strings.TrimSuffix(prefix, "."))
+	}
+	var legacy bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM duckdb_tables()
+        WHERE database_name = ` + catalog + ` AND schema_name = 'main' AND table_name = 'metrics')`).Scan(&legacy); err != nil {
+		return err
+	}
+	if !legacy {
+		return nil
+	}
+
+	start := time.Now()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	exec := func(q string) (int64, error) {
+		res, err := tx.Exec(strings.ReplaceAll(q, "{p}", prefix))
+		if err != nil {
+			return 0, fmt.Errorf("migrating %smetrics to series: %w", prefix, err)
+		}
+		return res.RowsAffected()
+	}
+	for _, q := range []string{
+		// Files from before these columns existed
+		`ALTER TABLE {p}metrics ADD COLUMN IF NOT EXISTS source_id BIGINT`,
+		`ALTER TABLE {p}metrics ADD COLUMN IF NOT EXISTS sample_rate INTEGER`,
+		`INSERT INTO {p}series (id, resource_id, metric_type)
+            SELECT (SELECT coalesce(max(id), 0) FROM {p}series) + row_number() OVER (ORDER BY resource_id, metric_type), resource_id, metric_type
+            FROM (SELECT DISTINCT resource_id, metric_type FROM {p}metrics
+                  EXCEPT SELECT resource_id, metric_type FROM {p}series)`,
+	} {
+		if _, err := exec(q); err != nil {
+			return err
+		}
+	}
+	moved, err := exec(`INSERT INTO {p}points (time, series_id, value, agg_type, source_id, sample_rate)
+        SELECT m.time, s.id, m.value, m.agg_type, m.source_id, m.sample_rate
+        FROM {p}metrics m JOIN {p}series s ON s.resource_id = m.resource_id AND s.metric_type = m.metric_type
+        ORDER BY m.time`)
+	if err != nil {
+		return err
+	}
+	if _, err := exec(`DROP TABLE {p}metrics`); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Moved %d points of %smetrics into series and points in %v", moved, prefix, time.Since(start).Round(time.Millisecond))
+	return nil
+}
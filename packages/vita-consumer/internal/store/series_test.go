@@ -0,0 +1,164 @@
+package store
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Metrics tables written before series were split out migrate, in a
+// single file and in a month file; series are numbered once and pruned
+// with their last point
+func TestSeriesDictionary(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	legacy := func(path string) {
+		t.Helper()
+		db, err := sql.Open("duckdb", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		_, err = db.Exec(`CREATE TABLE metrics (time TIMESTAMPTZ NOT NULL, resource_id INTEGER NOT NULL,
+				metric_type TEXT NOT NULL, value DOUBLE NOT NULL, agg_type TEXT DEFAULT 'raw');
+			INSERT INTO metrics (time, resource_id, metric_type, value) VALUES
+				(?::TIMESTAMPTZ, 1, 'cpu_ms', 10), (?::TIMESTAMPTZ, 1, 'cpu_ms', 20), (?::TIMESTAMPTZ, 1, 'mem_mb', 64), (?::TIMESTAMPTZ, 2, 'cpu_ms', 5)`,
+			now.Add(-time.Hour), now, now, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(duck *DuckDBStore, what string) {
+		t.Helper()
+		points, err := duck.QuerySeries(t.Context(), "", 1, []string{"cpu_ms", "mem_mb"}, now.Add(-2*time.Hour), now.Add(time.Second))
+		if err != nil {
+			t.Fatalf("%s: %v", what, err)
+		}
+		if len(points) != 3 || points[0].Value != 10 || points[0].MetricType != "cpu_ms" {
+			t.Fatalf("%s: migrated series read back as %+v", what, points)
+		}
+	}
+
+	single := filepath.Join(dir, "metrics.duckdb")
+	legacy(single)
+	duck, err := NewDuckDBStore(single)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(duck, "single file")
+	// New points of a known series reuse its id; a new series is added
+	if err := duck.BatchInsert([]MetricPoint{
+		{Time: now.Add(time.Second), ResourceID: 1, ResourceKind: "pod", MetricType: "cpu_ms", Value: 30},
+		{Time: now.Add(time.Second), ResourceID: 3, ResourceKind: "pod", MetricType: "cpu_ms", Value: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	duck.Close()
+	count := func(query string) (int, error) {
+		db, err := sql.Open("duckdb", single)
+		if err != nil {
+			return 0, err
+		}
+		defer db.Close()
+		var n int
+		return n, db.QueryRow(query).Scan(&n)
+	}
+	if n, err := count("SELECT count(*) FROM series"); err != nil || n != 4 {
+		t.Fatalf("%d series (%v), want 4", n, err)
+	}
+	if n, err := count("SELECT count(DISTINCT id) FROM series"); err != nil || n != 4 {
+		t.Fatalf("%d series ids (%v), want 4", n, err)
+	}
+	// Migrated series of pod types are known to be pods
+	if n, err := count("SELECT count(*) FROM series WHERE resource_kind = 'pod'"); err != nil || n != 4 {
+		t.Fatalf("%d pod series (%v), want 4", n, err)
+	}
+
+	// Reopening finds nothing left to migrate; series go with their last
+	// point
+	if duck, err = NewDuckDBStore(single); err != nil {
+		t.Fatal(err)
+	}
+	check(duck, "reopened")
+	if n, err := duck.DeleteBefore(now); err != nil || n != 1 {
+		t.Fatalf("deleted %d points (%v), want 1", n, err)
+	}
+	if n, err := duck.DeleteBefore(now.Add(time.Minute)); err != nil || n != 5 {
+		t.Fatalf("deleted %d points (%v), want 5", n, err)
+	}
+	duck.Close()
+	if n, err := count("SELECT count(*) FROM series"); err != nil || n != 0 {
+		t.Fatalf("%d series left without points (%v)", n, err)
+	}
+
+	// Month files, including the legacy single file, migrate on attach
+	monthly := filepath.Join(dir, "monthly")
+	if err := os.Mkdir(monthly, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	legacy(filepath.Join(monthly, "metrics-2024-06.duckdb"))
+	months, err := NewMonthlyDuckDBStore(monthly, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer months.Close()
+	check(months, "month file")
+	if err := months.BatchInsert([]MetricPoint{{Time: now.AddDate(0, 1, 0), ResourceID: 1, MetricType: "cpu_ms", Value: 40}}); err != nil {
+		t.Fatal(err)
+	}
+	points, err := months.QuerySeries(t.Context(), "", 1, []string{"cpu_ms"}, now.Add(-2*time.Hour), now.AddDate(0, 2, 0))
+	if err != nil || len(points) != 3 || points[2].Value != 40 {
+		t.Fatalf("series across month files: %+v (%v)", points, err)
+	}
+}
+
+// Reads by kind take only that kind's series of an ID, plus series of no
+// recorded kind
+func TestSeriesKinds(t *testing.T) {
+	duck, err := NewDuckDBStore(filepath.Join(t.TempDir(), "metrics.duckdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer duck.Close()
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	// A pod's emptyDir usage shares its type with a claim of the same ID
+	if err := duck.BatchInsert([]MetricPoint{
+		{Time: now, ResourceID: 5, ResourceKind: "pod", MetricType: "used_mb", Value: 1},
+		{Time: now, ResourceID: 5, ResourceKind: "pvc", MetricType: "used_mb", Value: 2},
+		{Time: now.Add(time.Second), ResourceID: 5, MetricType: "used_mb", Value: 4},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	from, to := now.Add(-time.Hour), now.Add(time.Hour)
+	for kind, want := range map[string]float64{"pod": 5, "pvc": 6, "": 7} {
+		points, err := duck.QuerySeries(t.Context(), kind, 5, []string{"used_mb"}, from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sum float64
+		for _, p := range points {
+			sum += p.Value
+		}
+		if sum != want {
+			t.Fatalf("kind %q: series read back as %+v, want a sum of %v", kind, points, want)
+		}
+		buckets, err := duck.QueryBucketedForResources(t.Context(), kind, "used_mb", []int64{5}, from, to, Bucketing{Step: time.Hour}, "sum")
+		if err != nil || len(buckets) != 1 || buckets[0].Value != want {
+			t.Fatalf("kind %q: buckets %+v (%v), want one of %v", kind, buckets, err, want)
+		}
+	}
+
+	latest, err := duck.LatestPoints(t.Context(), "", from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[string]float64{}
+	for _, p := range latest {
+		kinds[p.ResourceKind] = p.Value
+	}
+	if len(latest) != 3 || kinds["pod"] != 1 || kinds["pvc"] != 2 || kinds[""] != 4 {
+		t.Fatalf("latest points %+v, want one per kind", latest)
+	}
+}
@@ -0,0 +1,257 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SLO is a saturation objective for one workload, e.g. resource "cpu",
+// objective 0.95 and threshold 80: 95% of five-minute buckets over the
+// window have CPU usage below 80% of the pods' limits
+type SLO struct {
+	ID            int64      `json:"id"`
+	Name          string     `json:"name"`
+	Kind          string     `json:"kind"` // deployment, statefulset or daemonset
+	WorkloadID    int64      `json:"workload_id"`
+	Resource      string     `json:"resource"` // cpu or mem
+	Objective     float64    `json:"objective"`
+	ThresholdPct  float64    `json:"threshold_pct"`
+	WindowDays    int        `json:"window_days"`
+	Enabled       bool       `json:"enabled"`
+	RolledThrough *time.Time `json:"rolled_through,omitempty"` // end of the last rolled-up bucket
+	Alert         string     `json:"alert"`                    // "", "page" or "ticket"
+	AlertSince    *time.Time `json:"alert_since,omitempty"`
+	LastError     *string    `json:"last_error,omitempty"`
+}
+
+const sloColumns = `id, name, kind, workload_id, resource, objective, threshold_pct, window_days, enabled,
+	rolled_through, alert, alert_since, last_error`
+
+func scanSLO(row interface{ Scan(...interface{}) error }) (SLO, error) {
+	var s SLO
+	var rolled, since sql.NullTime
+	var lastErr sql.NullString
+	err := row.Scan(&s.ID, &s.Name, &s.Kind, &s.WorkloadID, &s.Resource, &s.Objective, &s.ThresholdPct, &s.WindowDays, &s.Enabled,
+		&rolled, &s.Alert, &since, &lastErr)
+	if rolled.Valid {
+		s.RolledThrough = &rolled.Time
+	}
+	if since.Valid {
+		s.AlertSince = &since.Time
+	}
+	if lastErr.Valid {
+		s.LastError = &lastErr.String
+	}
+	return s, err
+}
+
+func (s *SQLiteStore) ListSLOs() ([]SLO, error) {
+	rows, err := s.db.Query(`SELECT ` + sloColumns + ` FROM slos ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []SLO{}
+	for rows.Next() {
+		slo, err := scanSLO(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, slo)
+	}
+	return out, rows.Err()
+}
+
+// GetSLO returns sql.ErrNoRows when the SLO does not exist
+func (s *SQLiteStore) GetSLO(id int64) (SLO, error) {
+	return scanSLO(s.db.QueryRow(`SELECT `+sloColumns+` FROM slos WHERE id = ?`, id))
+}
+
+// UpsertSLO creates or replaces an SLO by name. Changing what is measured
+// discards the rolled-up buckets, so compliance restarts from scratch.
+func (s *SQLiteStore) UpsertSLO(slo SLO) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	var reset bool
+	err = tx.QueryRow(`
+    INSERT INTO slos (name, kind, workload_id, resource, objective, threshold_pct, window_days, enabled, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+    ON CONFLICT(name) DO UPDATE SET
+        rolled_through = CASE WHEN kind = excluded.kind AND workload_id = excluded.workload_id
+            AND resource = excluded.resource AND threshold_pct = excluded.threshold_pct THEN rolled_through END,
+        kind = excluded.kind,
+        workload_id = excluded.workload_id,
+        resource = excluded.resource,
+        objective = excluded.objective,
+        threshold_pct = excluded.threshold_pct,
+        window_days = excluded.window_days,
+        enabled = excluded.enabled,
+        updated_at = CURRENT_TIMESTAMP
+    RETURNING id, rolled_through IS NULL;
+    `, slo.Name, slo.Kind, slo.WorkloadID, slo.Resource, slo.Objective, slo.ThresholdPct, slo.WindowDays, slo.Enabled).Scan(&id, &reset)
+	if err != nil {
+		return 0, err
+	}
+	if reset {
+		if _, err := tx.Exec(`DELETE FROM slo_buckets WHERE slo_id = ?`, id); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`UPDATE slos SET alert = '', alert_since = NULL WHERE id = ?`, id); err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+func (s *SQLiteStore) DeleteSLO(id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM slo_buckets WHERE slo_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM slos WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SLOBucket is the utilization of a workload in one rolled-up bucket
+type SLOBucket struct {
+	Time           time.Time
+	UtilizationPct float64
+	Bad            bool // at or above the threshold
+}
+
+// AddSLOBuckets stores rolled-up buckets, drops those that left the
+// window and moves the SLO's rolled_through forward
+func (s *SQLiteStore) AddSLOBuckets(id int64, buckets []SLOBucket, through, keepFrom time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO slo_buckets (slo_id, bucket, utilization_pct, bad) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, b := range buckets {
+		if _, err := stmt.Exec(id, b.Time.Unix(), b.UtilizationPct, b.Bad); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM slo_buckets WHERE slo_id = ? AND bucket < ?`, id, keepFrom.Unix()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE slos SET rolled_through = ?, last_error = NULL WHERE id = ?`, through.UTC(), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SLOBuckets returns an SLO's buckets with from <= time < to, oldest first
+func (s *SQLiteStore) SLOBuckets(id int64, from, to time.Time) ([]SLOBucket, error) {
+	rows, err := s.db.Query(`SELECT bucket, utilization_pct, bad FROM slo_buckets
+		WHERE slo_id = ? AND bucket >= ? AND bucket < ? ORDER BY bucket`, id, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []SLOBucket{}
+	for rows.Next() {
+		var b SLOBucket
+		var t int64
+		if err := rows.Scan(&t, &b.UtilizationPct, &b.Bad); err != nil {
+			return nil, err
+		}
+		b.Time = time.Unix(t, 0).UTC()
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// SetSLOError records a failed roll-up; the window is retried
+func (s *SQLiteStore) SetSLOError(id int64, rollErr error) error {
+	_, err := s.db.Exec("UPDATE slos SET last_error = ? WHERE id = ?", rollErr.Error(), id)
+	return err
+}
+
+// SetSLOAlert records the SLO's burn alert ("" when none fires)
+func (s *SQLiteStore) SetSLOAlert(id int64, alert string, since time.Time) error {
+	var at interface{}
+	if alert != "" {
+		at = since.UTC()
+	}
+	_, err := s.db.Exec("UPDATE slos SET alert = ?, alert_since = ? WHERE id = ?", alert, at, id)
+	return err
+}
+
+// CountSLOAlerts returns how many enabled SLOs have a burn alert firing
+func (s *SQLiteStore) CountSLOAlerts() (int, error) {
+	var n int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM slos WHERE enabled AND alert != ''").Scan(&n)
+	return n, err
+}
+
+// WorkloadPodLimits returns the CPU (millicores) or memory (MB) limit of
+// every pod a workload has had, deleted ones included, keyed by pod ID.
+// Pods with a container without a limit are left out.
+func (s *SQLiteStore) WorkloadPodLimits(kind string, id int64, resource string) (map[int64]float64, error) {
+	if _, ok := WorkloadKinds[kind]; !ok {
+		return nil, fmt.Errorf("unknown workload kind %q", kind)
+	}
+	column := "cpu_limit_m"
+	if resource == "mem" {
+		column = "mem_limit_mb"
+	}
+	rows, err := s.db.Query(fmt.Sprintf(`SELECT p.id, SUM(pc.%s), COUNT(pc.pod_id), COUNT(pc.%s)
+		FROM pods p
+		JOIN pod_containers pc ON pc.pod_id = p.id
+		WHERE p.%s_id = ?
+		GROUP BY p.id`, column, column, kind), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]float64)
+	for rows.Next() {
+		var pod int64
+		var limit sql.NullFloat64
+		var containers, limited int
+		if err := rows.Scan(&pod, &limit, &containers, &limited); err != nil {
+			return nil, err
+		}
+		if containers == limited && limit.Float64 > 0 {
+			out[pod] = limit.Float64
+		}
+	}
+	return out, rows.Err()
+}
+
+// WorkloadNamespace returns a workload's namespace ID and name, or
+// sql.ErrNoRows when it does not exist
+func (s *SQLiteStore) WorkloadNamespace(kind string, id int64) (int64, string, error) {
+	table, ok := WorkloadKinds[kind]
+	if !ok {
+		return 0, "", fmt.Errorf("unknown workload kind %q", kind)
+	}
+	var nsID int64
+	var name string
+	err := s.db.QueryRow(fmt.Sprintf("SELECT namespace_id, name FROM %s WHERE id = ?", table), id).Scan(&nsID, &name)
+	return nsID, name, err
+}
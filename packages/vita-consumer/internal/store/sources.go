@@ -0,0 +1,314 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source is where stored rows came from: the cluster, the consumer instance
+// that wrote them and, for agent-reported metrics, the agent's version.
+// Rows the consumer derives itself (the catalog, rollups) have no agent
+// version.
+type Source struct {
+	ID           int64     `json:"id"`
+	ClusterID    string    `json:"cluster_id"`
+	InstanceID   string    `json:"instance_id"`
+	AgentVersion string    `json:"agent_version,omitempty"`
+	FirstSeen    time.Time `json:"first_seen"`
+}
+
+// SourceID derives a source's id from its fields, so one source has the
+// same id in every database: rows restored from a backup or merged from
+// another consumer keep resolving.
+func SourceID(cluster, instance, agentVersion string) int64 {
+	h := fnv.New64a()
+	for _, part := range []string{cluster, instance, agentVersion} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	id := int64(h.Sum64() >> 1) // positive, fits INTEGER and BIGINT
+	if id == 0 {
+		id = 1
+	}
+	return id
+}
+
+// AddSource records a source unless it is known already
+func (s *SQLiteStore) AddSource(src Source) error {
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO sources (id, cluster_id, instance_id, agent_version, first_seen)
+		VALUES (?, ?, ?, ?, ?)`, src.ID, src.ClusterID, src.InstanceID, src.AgentVersion, src.FirstSeen)
+	return err
+}
+
+// SetLocalSource makes new and updated catalog rows carry id (see
+// installSourceStamps)
+func (s *SQLiteStore) SetLocalSource(id int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM local_source"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO local_source (id) VALUES (?)", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListSources returns every recorded source, oldest first
+func (s *SQLiteStore) ListSources() ([]Source, error) {
+	return querySources(s.db, "SELECT id, cluster_id, instance_id, agent_version, first_seen FROM sources ORDER BY first_seen, id")
+}
+
+// SourcesByID returns the recorded sources among ids
+func (s *SQLiteStore) SourcesByID(ids []int64) (map[int64]Source, error) {
+	return sourcesByID(s.db, ids)
+}
+
+// querier is a database or transaction
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func sourcesByID(q querier, ids []int64) (map[int64]Source, error) {
+	out := map[int64]Source{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	marks, args := inArgs(ids)
+	list, err := querySources(q, "SELECT id, cluster_id, instance_id, agent_version, first_seen FROM sources WHERE id IN ("+marks+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	for _, src := range list {
+		out[src.ID] = src
+	}
+	return out, nil
+}
+
+func querySources(q querier, query string, args ...interface{}) ([]Source, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Source{}
+	for rows.Next() {
+		var src Source
+		if err := rows.Scan(&src.ID, &src.ClusterID, &src.InstanceID, &src.AgentVersion, &src.FirstSeen); err != nil {
+			return nil, err
+		}
+		out = append(out, src)
+	}
+	return out, rows.Err()
+}
+
+// installSourceStamps adds source_id to the catalog tables and (re)creates
+// the triggers that set it from local_source whenever a row is written,
+// so every upsert is stamped without the syncer passing it along. A row
+// carries the source that last wrote it.
+func installSourceStamps(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS local_source (id INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+	for _, c := range catalogKinds {
+		if err := addColumn(db, c.table, "source_id", "INTEGER"); err != nil {
+			return err
+		}
+		stamp := fmt.Sprintf(`UPDATE %s SET source_id = (SELECT id FROM local_source) WHERE id = NEW.id;`, c.table)
+		when := `EXISTS (SELECT 1 FROM local_source) AND NEW.source_id IS NOT (SELECT id FROM local_source)`
+		stmts := []string{
+			fmt.Sprintf("DROP TRIGGER IF EXISTS source_%s_insert", c.table),
+			fmt.Sprintf("DROP TRIGGER IF EXISTS source_%s_update", c.table),
+			fmt.Sprintf("CREATE TRIGGER source_%s_insert AFTER INSERT ON %s WHEN %s BEGIN %s END", c.table, c.table, when, stamp),
+			fmt.Sprintf("CREATE TRIGGER source_%s_update AFTER UPDATE ON %s WHEN %s BEGIN %s END", c.table, c.table, when, stamp),
+		}
+		for _, q := range stmts {
+			if _, err := db.Exec(q); err != nil {
+				return fmt.Errorf("source stamps for %s: %w", c.table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// duckSources are the sources a DuckDBStore may write, and which of them
+// each table's file already lists
+type duckSources struct {
+	mu       sync.Mutex
+	known    map[int64]Source
+	written  map[string]map[int64]bool // sources table -> ids
+	fallback int64
+}
+
+// RegisterSource lets points carry src. Each database file lists the
+// sources of its rows in its own sources table, so a month file that is
+// archived, restored or attached elsewhere still says where its rows came
+// from.
+func (s *DuckDBStore) RegisterSource(src Source) {
+	s.sources.mu.Lock()
+	defer s.sources.mu.Unlock()
+	if s.sources.known == nil {
+		s.sources.known = map[int64]Source{}
+	}
+	s.sources.known[src.ID] = src
+}
+
+// SetDefaultSource stamps rows written without a source (rollups, rule
+// results, points of agents that predate provenance) with id
+func (s *DuckDBStore) SetDefaultSource(id int64) {
+	s.sources.mu.Lock()
+	s.sources.fallback = id
+	s.sources.mu.Unlock()
+}
+
+// stamper returns how rows' source ids are stored: 0 becomes the default
+// source, and NULL without one
+func (s *DuckDBStore) stamper() func(id int64) interface{} {
+	s.sources.mu.Lock()
+	fallback := s.sources.fallback
+	s.sources.mu.Unlock()
+	return func(id int64) interface{} {
+		if id == 0 {
+			id = fallback
+		}
+		if id == 0 {
+			return nil
+		}
+		return id
+	}
+}
+
+// sourcesTable is the sources table in the same database file as table
+func sourcesTable(table string) string {
+	return siblingTable(table, "sources")
+}
+
+// listSources adds the registered sources among ids to the sources table
+// next to table, in the transaction writing the rows
+func (s *DuckDBStore) listSources(tx *sql.Tx, table string, ids map[int64]bool, at time.Time) (func(), error) {
+	dict := sourcesTable(table)
+	s.sources.mu.Lock()
+	var pending []Source
+	for id := range ids {
+		if src, ok := s.sources.known[id]; ok && !s.sources.written[dict][id] {
+			pending = append(pending, src)
+		}
+	}
+	s.sources.mu.Unlock()
+
+	for _, src := range pending {
+		if _, err := tx.Exec(`INSERT INTO `+dict+` (time, id, cluster_id, instance_id, agent_version)
+			SELECT ?::TIMESTAMPTZ, ?::BIGINT, ?::TEXT, ?::TEXT, ?::TEXT
+			WHERE NOT EXISTS (SELECT 1 FROM `+dict+` WHERE id = ?::BIGINT)`,
+			at, src.ID, src.ClusterID, src.InstanceID, src.AgentVersion, src.ID); err != nil {
+			return nil, err
+		}
+	}
+	// Called once the transaction committed
+	return func() {
+		if len(pending) == 0 {
+			return
+		}
+		s.sources.mu.Lock()
+		defer s.sources.mu.Unlock()
+		if s.sources.written == nil {
+			s.sources.written = map[string]map[int64]bool{}
+		}
+		if s.sources.written[dict] == nil {
+			s.sources.written[dict] = map[int64]bool{}
+		}
+		for _, src := range pending {
+			s.sources.written[dict][src.ID] = true
+		}
+	}, nil
+}
+
+// sourceSet holds the id a stamper returned, if any
+func sourceSet(src interface{}) map[int64]bool {
+	set := map[int64]bool{}
+	if id, ok := src.(int64); ok {
+		set[id] = true
+	}
+	return set
+}
+
+// forgetSources drops what is known about the sources tables of detached
+// or deleted files
+func (s *DuckDBStore) forgetSources(prefix string) {
+	s.sources.mu.Lock()
+	delete(s.sources.written, prefix+"sources")
+	s.sources.mu.Unlock()
+}
+
+// ListSources returns the sources listed in the metrics files, including
+// those of files restored from other consumers, oldest first
+func (s *DuckDBStore) ListSources(ctx context.Context) ([]Source, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, any_value(cluster_id), any_value(instance_id), any_value(agent_version), min(time)
+		FROM sources GROUP BY id ORDER BY min(time), id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Source{}
+	for rows.Next() {
+		var src Source
+		if err := rows.Scan(&src.ID, &src.ClusterID, &src.InstanceID, &src.AgentVersion, &src.FirstSeen); err != nil {
+			return nil, err
+		}
+		out = append(out, src)
+	}
+	return out, rows.Err()
+}
+
+// SeriesSources returns the sources of the points of resources' series of
+// the given types in [from, to). Sources no file lists are returned with
+// only their id.
+func (s *DuckDBStore) SeriesSources(ctx context.Context, ids []int64, types []string, from, to time.Time) ([]Source, error) {
+	if len(ids) == 0 || len(types) == 0 {
+		return []Source{}, nil
+	}
+	args := make([]interface{}, 0, len(ids)+len(types)+2)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	for _, t := range types {
+		args = append(args, t)
+	}
+	args = append(args, from, to)
+
+	rows, err := s.db.QueryContext(ctx, `SELECT m.source_id, coalesce(any_value(s.cluster_id), ''), coalesce(any_value(s.instance_id), ''),
+			coalesce(any_value(s.agent_version), ''), coalesce(min(s.time), min(m.first))
+		FROM (
+			SELECT source_id, min(time) AS first FROM metrics
+			WHERE resource_id IN (`+strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")+`) AND metric_type IN (`+strings.TrimSuffix(strings.Repeat("?,", len(types)), ",")+`)
+			  AND time >= ? AND time < ? AND source_id IS NOT NULL
+			GROUP BY source_id
+		) m LEFT JOIN sources s ON s.id = m.source_id
+		GROUP BY m.source_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Source{}
+	for rows.Next() {
+		var src Source
+		if err := rows.Scan(&src.ID, &src.ClusterID, &src.InstanceID, &src.AgentVersion, &src.FirstSeen); err != nil {
+			return nil, err
+		}
+		out = append(out, src)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].FirstSeen.Before(out[j].FirstSeen) })
+	return out, rows.Err()
+}
@@ -2,17 +2,21 @@ package store
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type SQLiteStore struct {
-	db *sql.DB
+	db   *sql.DB
+	path string
 }
 
 func NewSQLiteStore(path string) (*SQLiteStore, error) {
-	db, err := sql.Open("sqlite3", path)
+	db, err := openDB("sqlite", "sqlite3", path)
 	if err != nil {
 		return nil, err
 	}
@@ -26,7 +30,7 @@ func NewSQLiteStore(path string) (*SQLiteStore, error) {
 		return nil, err
 	}
 
-	return &SQLiteStore{db: db}, nil
+	return &SQLiteStore{db: db, path: path}, nil
 }
 
 func initSchema(db *sql.DB) error {
@@ -106,23 +110,472 @@ func initSchema(db *sql.DB) error {
             FOREIGN KEY(namespace_id) REFERENCES namespaces(id)
         );`,
 
+		// PersistentVolumes and StorageClasses are cluster-scoped; PVCs link
+		// to their volume, and volumes to their class, by name
+		`CREATE TABLE IF NOT EXISTS persistent_volumes (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            uid TEXT UNIQUE NOT NULL,
+            name TEXT NOT NULL,
+            storage_class TEXT,
+            capacity_mb REAL,
+            reclaim_policy TEXT,
+            phase TEXT,
+            access_modes TEXT,  -- comma-separated
+            driver TEXT,        -- CSI driver, or the in-tree volume type
+            claim_namespace TEXT,
+            claim_name TEXT,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+		// Bound capacity changes of a claim, as seen by the syncer
+		`CREATE TABLE IF NOT EXISTS pvc_resizes (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            pvc_id INTEGER NOT NULL,
+            time DATETIME NOT NULL,
+            from_mb REAL NOT NULL,
+            to_mb REAL NOT NULL,
+            FOREIGN KEY(pvc_id) REFERENCES pvcs(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_pvc_resizes_pvc ON pvc_resizes(pvc_id, time);`,
+		// Volume warning events (FailedMount, FailedAttachVolume), one row
+		// per event as the kubelet re-reports it
+		`CREATE TABLE IF NOT EXISTS volume_failures (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            event_uid TEXT UNIQUE NOT NULL,
+            namespace_id INTEGER NOT NULL,
+            pod_id INTEGER,
+            pod_name TEXT NOT NULL,
+            pvc_id INTEGER,
+            volume TEXT,
+            reason TEXT NOT NULL,
+            message TEXT NOT NULL,
+            count INTEGER NOT NULL DEFAULT 1,
+            first_seen DATETIME NOT NULL,
+            last_seen DATETIME NOT NULL,
+            resolved_at DATETIME,  -- the pod became ready
+            FOREIGN KEY(namespace_id) REFERENCES namespaces(id),
+            FOREIGN KEY(pod_id) REFERENCES pods(id),
+            FOREIGN KEY(pvc_id) REFERENCES pvcs(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_volume_failures_open ON volume_failures(pod_id) WHERE resolved_at IS NULL;`,
+		`CREATE TABLE IF NOT EXISTS storage_classes (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            uid TEXT UNIQUE NOT NULL,
+            name TEXT NOT NULL,
+            provisioner TEXT NOT NULL,
+            reclaim_policy TEXT,
+            binding_mode TEXT,
+            allow_expansion INTEGER NOT NULL DEFAULT 0,
+            is_default INTEGER NOT NULL DEFAULT 0,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+
+		// PodDisruptionBudgets (status snapshot + protected workload)
+		`CREATE TABLE IF NOT EXISTS pdbs (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            uid TEXT UNIQUE NOT NULL,
+            name TEXT NOT NULL,
+            namespace_id INTEGER NOT NULL,
+
+            min_available TEXT,
+            max_unavailable TEXT,
+            disruptions_allowed INTEGER NOT NULL DEFAULT 0,
+            current_healthy INTEGER NOT NULL DEFAULT 0,
+            desired_healthy INTEGER NOT NULL DEFAULT 0,
+            expected_pods INTEGER NOT NULL DEFAULT 0,
+
+            -- Workload whose pod template matches the selector
+            deployment_id INTEGER,
+            statefulset_id INTEGER,
+
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY(namespace_id) REFERENCES namespaces(id),
+            FOREIGN KEY(deployment_id) REFERENCES deployments(id),
+            FOREIGN KEY(statefulset_id) REFERENCES statefulsets(id)
+        );`,
+
+		// Services (linked to the workload their selector targets)
+		`CREATE TABLE IF NOT EXISTS services (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            uid TEXT UNIQUE NOT NULL,
+            name TEXT NOT NULL,
+            namespace_id INTEGER NOT NULL,
+            deployment_id INTEGER,
+            statefulset_id INTEGER,
+            daemonset_id INTEGER,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY(namespace_id) REFERENCES namespaces(id),
+            FOREIGN KEY(deployment_id) REFERENCES deployments(id),
+            FOREIGN KEY(statefulset_id) REFERENCES statefulsets(id),
+            FOREIGN KEY(daemonset_id) REFERENCES daemonsets(id)
+        );`,
+		// Ingresses and their host/path -> service rules
+		`CREATE TABLE IF NOT EXISTS ingresses (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            uid TEXT UNIQUE NOT NULL,
+            name TEXT NOT NULL,
+            namespace_id INTEGER NOT NULL,
+            class TEXT,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY(namespace_id) REFERENCES namespaces(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS ingress_rules (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            ingress_id INTEGER NOT NULL,
+            host TEXT NOT NULL,
+            path TEXT NOT NULL,
+            service_name TEXT NOT NULL,
+            service_port TEXT,
+            FOREIGN KEY(ingress_id) REFERENCES ingresses(id) ON DELETE CASCADE
+        );`,
+
+		// ConfigMap/Secret references from pod specs (names only, never data)
+		`CREATE TABLE IF NOT EXISTS config_refs (
+            pod_id INTEGER NOT NULL,
+            kind TEXT NOT NULL, -- 'configmap' or 'secret'
+            name TEXT NOT NULL,
+            via TEXT NOT NULL,  -- 'volume' or 'env'
+            PRIMARY KEY(pod_id, kind, name, via),
+            FOREIGN KEY(pod_id) REFERENCES pods(id) ON DELETE CASCADE
+        );`,
+		// PVCs a pod mounts, by name within its namespace
+		`CREATE TABLE IF NOT EXISTS pod_claims (
+            pod_id INTEGER NOT NULL,
+            name TEXT NOT NULL,
+            PRIMARY KEY(pod_id, name),
+            FOREIGN KEY(pod_id) REFERENCES pods(id) ON DELETE CASCADE
+        );`,
+		// Container resource requests/limits captured from pod specs
+		`CREATE TABLE IF NOT EXISTS pod_containers (
+            pod_id INTEGER NOT NULL,
+            name TEXT NOT NULL,
+            cpu_request_m INTEGER,   -- millicores
+            cpu_limit_m INTEGER,
+            mem_request_mb REAL,
+            mem_limit_mb REAL,
+            PRIMARY KEY(pod_id, name),
+            FOREIGN KEY(pod_id) REFERENCES pods(id) ON DELETE CASCADE
+        );`,
+		// Annotations: point-in-time change markers to overlay on charts
+		`CREATE TABLE IF NOT EXISTS annotations (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            time DATETIME NOT NULL,
+            namespace_id INTEGER,
+            type TEXT NOT NULL,
+            kind TEXT NOT NULL,
+            name TEXT NOT NULL,
+            message TEXT NOT NULL,
+            FOREIGN KEY(namespace_id) REFERENCES namespaces(id)
+        );`,
+		// Scheduled reports: template, cron schedule and delivery target
+		`CREATE TABLE IF NOT EXISTS report_templates (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT UNIQUE NOT NULL,
+            format TEXT NOT NULL DEFAULT 'html',
+            body TEXT NOT NULL DEFAULT '',
+            schedule TEXT NOT NULL,
+            period_days INTEGER NOT NULL DEFAULT 7,
+            delivery TEXT NOT NULL,
+            target TEXT NOT NULL,
+            enabled INTEGER NOT NULL DEFAULT 1,
+            last_run_at DATETIME,
+            last_error TEXT,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+		// Per-user UI preferences and saved filters (JSON values)
+		`CREATE TABLE IF NOT EXISTS preferences (
+            scope TEXT NOT NULL,
+            key TEXT NOT NULL,
+            value TEXT NOT NULL,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY(scope, key)
+        );`,
+		// Recording rules: derived series evaluated into DuckDB
+		`CREATE TABLE IF NOT EXISTS recording_rules (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT UNIQUE NOT NULL,
+            expr TEXT NOT NULL,
+            interval_sec INTEGER NOT NULL DEFAULT 60,
+            enabled INTEGER NOT NULL DEFAULT 1,
+            last_eval_at DATETIME,
+            last_error TEXT,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+		// Ingested datapoints per namespace/node and hour (unix seconds)
+		`CREATE TABLE IF NOT EXISTS ingest_usage (
+            hour INTEGER NOT NULL,
+            scope TEXT NOT NULL,
+            name TEXT NOT NULL,
+            points INTEGER NOT NULL DEFAULT 0,
+            dropped INTEGER NOT NULL DEFAULT 0,
+            PRIMARY KEY(hour, scope, name)
+        );`,
+		// Workload health scores per scoring run (unix seconds)
+		`CREATE TABLE IF NOT EXISTS workload_health (
+            time INTEGER NOT NULL,
+            kind TEXT NOT NULL, -- 'deployment', 'statefulset' or 'daemonset'
+            workload_id INTEGER NOT NULL,
+            score REAL NOT NULL,
+            restarts INTEGER NOT NULL DEFAULT 0,
+            oom_kills INTEGER NOT NULL DEFAULT 0,
+            throttled_pct REAL NOT NULL DEFAULT 0,
+            cpu_saturation_pct REAL NOT NULL DEFAULT 0,
+            mem_saturation_pct REAL NOT NULL DEFAULT 0,
+            errors INTEGER NOT NULL DEFAULT 0,
+            PRIMARY KEY(kind, workload_id, time)
+        );`,
+		// Saturation SLOs per workload and their rolled-up utilization
+		// buckets (unix seconds)
+		`CREATE TABLE IF NOT EXISTS slos (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            name TEXT UNIQUE NOT NULL,
+            kind TEXT NOT NULL,
+            workload_id INTEGER NOT NULL,
+            resource TEXT NOT NULL, -- 'cpu' or 'mem'
+            objective REAL NOT NULL,
+            threshold_pct REAL NOT NULL,
+            window_days INTEGER NOT NULL DEFAULT 30,
+            enabled INTEGER NOT NULL DEFAULT 1,
+            rolled_through DATETIME,
+            alert TEXT NOT NULL DEFAULT '',
+            alert_since DATETIME,
+            last_error TEXT,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+		`CREATE TABLE IF NOT EXISTS slo_buckets (
+            slo_id INTEGER NOT NULL,
+            bucket INTEGER NOT NULL,
+            utilization_pct REAL NOT NULL,
+            bad INTEGER NOT NULL,
+            PRIMARY KEY(slo_id, bucket),
+            FOREIGN KEY(slo_id) REFERENCES slos(id) ON DELETE CASCADE
+        );`,
+		// Collection settings agents poll: node '' is the cluster default,
+		// other rows override it per node (JSON)
+		`CREATE TABLE IF NOT EXISTS agent_configs (
+            node TEXT PRIMARY KEY,
+            config TEXT NOT NULL,
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+		// Agents that have posted, and whether their metrics are accepted
+		`CREATE TABLE IF NOT EXISTS agent_registrations (
+            node TEXT PRIMARY KEY,
+            status TEXT NOT NULL, -- 'pending', 'approved' or 'rejected'
+            remote_addr TEXT,
+            first_seen DATETIME NOT NULL,
+            last_seen DATETIME NOT NULL,
+            decided_at DATETIME
+        );`,
+
+		// Where rows came from, see Source
+		`CREATE TABLE IF NOT EXISTS sources (
+            id INTEGER PRIMARY KEY,
+            cluster_id TEXT NOT NULL,
+            instance_id TEXT NOT NULL,
+            agent_version TEXT NOT NULL DEFAULT '',
+            first_seen DATETIME NOT NULL
+        );`,
+
+		// Newest point of every series, kept up to date on flush (see
+		// UpsertLatest). The kind is '' for series written before series
+		// recorded one.
+		`CREATE TABLE IF NOT EXISTS latest_values (
+            resource_kind TEXT NOT NULL DEFAULT '',
+            resource_id INTEGER NOT NULL,
+            metric_type TEXT NOT NULL,
+            time DATETIME NOT NULL,
+            value REAL NOT NULL,
+            PRIMARY KEY (resource_kind, resource_id, metric_type)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_latest_values_type ON latest_values(metric_type, time);`,
+		// Named, parameterized history queries shared through the API
+		`CREATE TABLE IF NOT EXISTS query_templates (
+            name TEXT PRIMARY KEY,
+            description TEXT NOT NULL DEFAULT '',
+            params TEXT NOT NULL,
+            queries TEXT NOT NULL,
+            updated_by TEXT NOT NULL DEFAULT '',
+            updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );`,
+		// Catalog rows with broken references set aside by the
+		// consistency check, as JSON (see Quarantine)
+		`CREATE TABLE IF NOT EXISTS quarantine (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            time DATETIME NOT NULL,
+            table_name TEXT NOT NULL,
+            row_id INTEGER NOT NULL,
+            reason TEXT NOT NULL,
+            data TEXT NOT NULL
+        );`,
+		// Purged resources whose points are deleted again once what was
+		// buffered for them has been flushed (see Purge)
+		`CREATE TABLE IF NOT EXISTS purge_sweeps (
+            table_name TEXT NOT NULL,
+            resource_id INTEGER NOT NULL,
+            due_at DATETIME NOT NULL
+        );`,
+
 		// Indexes
 		`CREATE INDEX IF NOT EXISTS idx_pods_uid ON pods(uid);`,
+		`CREATE INDEX IF NOT EXISTS idx_pods_namespace ON pods(namespace_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_pods_node ON pods(node_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_pods_deployment ON pods(deployment_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_pods_statefulset ON pods(statefulset_id);`,
 		`CREATE INDEX IF NOT EXISTS idx_pvcs_uid ON pvcs(uid);`,
+		`CREATE INDEX IF NOT EXISTS idx_pvcs_namespace ON pvcs(namespace_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_ingress_rules_host ON ingress_rules(host);`,
+		`CREATE INDEX IF NOT EXISTS idx_services_ns_name ON services(namespace_id, name);`,
+		`CREATE INDEX IF NOT EXISTS idx_config_refs_name ON config_refs(kind, name);`,
+		`CREATE INDEX IF NOT EXISTS idx_annotations_time ON annotations(time);`,
+		`CREATE INDEX IF NOT EXISTS idx_workload_health_time ON workload_health(time);`,
 	}
 
+	if err := dropUnkeyedLatest(db); err != nil {
+		return err
+	}
 	for _, q := range schemas {
 		if _, err := db.Exec(q); err != nil {
 			return err
 		}
 	}
-	return nil
+
+	// Columns added after the initial schema; CREATE TABLE IF NOT EXISTS
+	// leaves existing tables untouched so they are added here.
+	columns := []struct{ table, column, def string }{
+		{"nodes", "cpu_allocatable_m", "INTEGER"},
+		{"nodes", "mem_allocatable_mb", "REAL"},
+		{"pods", "ordinal", "INTEGER"}, // StatefulSet replica index
+		{"pods", "phase", "TEXT"},
+		{"pods", "ready", "INTEGER"},
+		{"pods", "deleted_at", "DATETIME"},
+		{"nodes", "deleted_at", "DATETIME"},
+		{"daemonsets", "desired_scheduled", "INTEGER"},
+		{"pods", "service_account", "TEXT"},
+		{"pod_containers", "image", "TEXT"},
+		{"pod_containers", "image_pull_policy", "TEXT"},
+		{"pod_containers", "args", "TEXT"},      // JSON array, secret-looking values redacted
+		{"pod_containers", "env_names", "TEXT"}, // JSON array of names, never values
+		{"nodes", "ready", "INTEGER"},
+		{"report_templates", "timezone", "TEXT NOT NULL DEFAULT ''"},
+		{"pods", "restarts", "INTEGER"},
+		{"pods", "oom_killed_at", "DATETIME"},
+		{"pods", "waiting_reason", "TEXT"},
+		{"nodes", "decommissioned_at", "DATETIME"},
+		{"pvcs", "volume_name", "TEXT"},
+		{"pvcs", "storage_class", "TEXT"},
+		{"pvcs", "requested_mb", "REAL"},
+		{"pvcs", "capacity_mb", "REAL"}, // from status, once bound
+		{"pvcs", "phase", "TEXT"},
+		{"pvcs", "deleted_at", "DATETIME"},
+		{"pods", "scheduling_ms", "REAL"}, // once recorded, see SetPodLatencies
+		{"pods", "startup_ms", "REAL"},
+		{"deployments", "revision", "TEXT"}, // see SetWorkloadRevision
+		{"statefulsets", "revision", "TEXT"},
+		{"daemonsets", "revision", "TEXT"},
+		{"report_templates", "group_by", "TEXT NOT NULL DEFAULT ''"},
+		{"report_templates", "owners", "TEXT NOT NULL DEFAULT ''"},
+		{"namespaces", "ownership", "TEXT"}, // JSON object, see SetOwnership
+		{"recording_rules", "managed_by", "TEXT NOT NULL DEFAULT ''"},
+		{"report_templates", "managed_by", "TEXT NOT NULL DEFAULT ''"},
+		{"deployments", "ownership", "TEXT"},
+		{"statefulsets", "ownership", "TEXT"},
+		{"daemonsets", "ownership", "TEXT"},
+		{"namespaces", "retention", "TEXT"}, // see SetRetention
+		{"deployments", "retention", "TEXT"},
+		{"statefulsets", "retention", "TEXT"},
+		{"daemonsets", "retention", "TEXT"},
+		{"nodes", "labels", "TEXT"},   // JSON object, see SetNodeLabels
+		{"pods", "labels", "TEXT"},    // JSON object, see SetPodPlacement
+		{"pods", "placement", "TEXT"}, // JSON PlacementRules
+		{"nodes", "zone", "TEXT"},     // see SetNodeGroup
+		{"nodes", "region", "TEXT"},
+		{"nodes", "nodepool", "TEXT"},
+		{"nodes", "capacity_type", "TEXT"},
+	}
+	for _, c := range columns {
+		if err := addColumn(db, c.table, c.column, c.def); err != nil {
+			return err
+		}
+	}
+
+	if err := installSourceStamps(db); err != nil {
+		return err
+	}
+
+	// Triggers list every column, so they are rebuilt after columns change
+	return installChangeLog(db)
+}
+
+// dropUnkeyedLatest drops a latest_values table from before its key had
+// the resource kind, which SQLite cannot add to a primary key. The schema
+// recreates it empty and the latest sink seeds it again from DuckDB.
+func dropUnkeyedLatest(db *sql.DB) error {
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'latest_values')").Scan(&exists); err != nil || !exists {
+		return err
+	}
+	keyed, err := hasColumn(db, "latest_values", "resource_kind")
+	if err != nil || keyed {
+		return err
+	}
+	_, err = db.Exec("DROP TABLE latest_values")
+	return err
+}
+
+// addColumn adds a column unless the table already has it
+func addColumn(db *sql.DB, table, column, def string) error {
+	ok, err := hasColumn(db, table, column)
+	if err != nil || ok {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def))
+	return err
+}
+
+// hasColumn reports whether a table has a column
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name, typ  string
+			notNull    int
+			dflt       sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &primaryKey); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
 }
 
 func (s *SQLiteStore) Close() error {
 	return s.db.Close()
 }
 
+// Path returns the database file location
+func (s *SQLiteStore) Path() string {
+	return s.path
+}
+
+// Vacuum rebuilds the database file to reclaim free pages and refreshes
+// the query planner statistics.
+func (s *SQLiteStore) Vacuum() error {
+	if _, err := s.db.Exec("VACUUM"); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("ANALYZE")
+	return err
+}
+
 // --- Specific Upserts ---
 
 func (s *SQLiteStore) UpsertNamespace(name string) (int64, error) {
@@ -135,12 +588,20 @@ func (s *SQLiteStore) UpsertNamespace(name string) (int64, error) {
 
 func (s *SQLiteStore) UpsertNode(uid, name string) (int64, error) {
 	query := `INSERT INTO nodes (uid, name, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
-              ON CONFLICT(uid) DO UPDATE SET name=excluded.name, updated_at=CURRENT_TIMESTAMP RETURNING id`
+              ON CONFLICT(uid) DO UPDATE SET name=excluded.name, updated_at=CURRENT_TIMESTAMP,
+                  decommissioned_at=NULL RETURNING id`
 	var id int64
 	err := s.db.QueryRow(query, uid, name).Scan(&id)
 	return id, err
 }
 
+// SetNodeAllocatable records the schedulable CPU (millicores) and memory
+// (MiB) reported in the node status
+func (s *SQLiteStore) SetNodeAllocatable(id, cpuM int64, memMB float64) error {
+	_, err := s.db.Exec(`UPDATE nodes SET cpu_allocatable_m = ?, mem_allocatable_mb = ? WHERE id = ?`, cpuM, memMB, id)
+	return err
+}
+
 func (s *SQLiteStore) UpsertDeployment(uid, name string, nsID int64) (int64, error) {
 	query := `INSERT INTO deployments (uid, name, namespace_id, updated_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)
               ON CONFLICT(uid) DO UPDATE SET name=excluded.name, namespace_id=excluded.namespace_id, updated_at=CURRENT_TIMESTAMP RETURNING id`
@@ -165,10 +626,11 @@ func (s *SQLiteStore) UpsertDaemonSet(uid, name string, nsID int64) (int64, erro
 	return id, err
 }
 
-func (s *SQLiteStore) UpsertPod(uid, name string, nsID, nodeID int64, depID, stsID, dsID *int64) (int64, error) {
+// UpsertPod stores a pod; ordinal is its replica index for StatefulSet pods
+func (s *SQLiteStore) UpsertPod(uid, name string, nsID, nodeID int64, depID, stsID, dsID *int64, ordinal *int) (int64, error) {
 	query := `
-    INSERT INTO pods (uid, name, namespace_id, node_id, deployment_id, statefulset_id, daemonset_id, updated_at)
-    VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+    INSERT INTO pods (uid, name, namespace_id, node_id, deployment_id, statefulset_id, daemonset_id, ordinal, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
     ON CONFLICT(uid) DO UPDATE SET
         name = excluded.name,
         namespace_id = excluded.namespace_id,
@@ -176,11 +638,12 @@ func (s *SQLiteStore) UpsertPod(uid, name string, nsID, nodeID int64, depID, sts
         deployment_id = excluded.deployment_id,
         statefulset_id = excluded.statefulset_id,
         daemonset_id = excluded.daemonset_id,
+        ordinal = excluded.ordinal,
         updated_at = CURRENT_TIMESTAMP
     RETURNING id;
     `
 	var id int64
-	err := s.db.QueryRow(query, uid, name, nsID, nodeID, depID, stsID, dsID).Scan(&id)
+	err := s.db.QueryRow(query, uid, name, nsID, nodeID, depID, stsID, dsID, ordinal).Scan(&id)
 	return id, err
 }
 
@@ -199,6 +662,283 @@ func (s *SQLiteStore) UpsertPVC(uid, name string, nsID int64) (int64, error) {
 	return id, err
 }
 
+// PDBStatus is the disruption state of a PodDisruptionBudget
+type PDBStatus struct {
+	MinAvailable       *string
+	MaxUnavailable     *string
+	DisruptionsAllowed int32
+	CurrentHealthy     int32
+	DesiredHealthy     int32
+	ExpectedPods       int32
+}
+
+func (s *SQLiteStore) UpsertPDB(uid, name string, nsID int64, st PDBStatus, depID, stsID *int64) (int64, error) {
+	query := `
+    INSERT INTO pdbs (uid, name, namespace_id, min_available, max_unavailable, disruptions_allowed,
+        current_healthy, desired_healthy, expected_pods, deployment_id, statefulset_id, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+    ON CONFLICT(uid) DO UPDATE SET
+        name = excluded.name,
+        namespace_id = excluded.namespace_id,
+        min_available = excluded.min_available,
+        max_unavailable = excluded.max_unavailable,
+        disruptions_allowed = excluded.disruptions_allowed,
+        current_healthy = excluded.current_healthy,
+        desired_healthy = excluded.desired_healthy,
+        expected_pods = excluded.expected_pods,
+        deployment_id = excluded.deployment_id,
+        statefulset_id = excluded.statefulset_id,
+        updated_at = CURRENT_TIMESTAMP
+    RETURNING id;
+    `
+	var id int64
+	err := s.db.QueryRow(query, uid, name, nsID, st.MinAvailable, st.MaxUnavailable, st.DisruptionsAllowed,
+		st.CurrentHealthy, st.DesiredHealthy, st.ExpectedPods, depID, stsID).Scan(&id)
+	return id, err
+}
+
+func (s *SQLiteStore) UpsertService(uid, name string, nsID int64, depID, stsID, dsID *int64) (int64, error) {
+	query := `
+    INSERT INTO services (uid, name, namespace_id, deployment_id, statefulset_id, daemonset_id, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+    ON CONFLICT(uid) DO UPDATE SET
+        name = excluded.name,
+        namespace_id = excluded.namespace_id,
+        deployment_id = excluded.deployment_id,
+        statefulset_id = excluded.statefulset_id,
+        daemonset_id = excluded.daemonset_id,
+        updated_at = CURRENT_TIMESTAMP
+    RETURNING id;
+    `
+	var id int64
+	err := s.db.QueryRow(query, uid, name, nsID, depID, stsID, dsID).Scan(&id)
+	return id, err
+}
+
+// IngressRule maps a host/path to a backend service
+type IngressRule struct {
+	Host        string
+	Path        string
+	ServiceName string
+	ServicePort string
+}
+
+// UpsertIngress stores an ingress and replaces its rule set atomically
+func (s *SQLiteStore) UpsertIngress(uid, name string, nsID int64, class *string, rules []IngressRule) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`
+    INSERT INTO ingresses (uid, name, namespace_id, class, updated_at)
+    VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+    ON CONFLICT(uid) DO UPDATE SET
+        name = excluded.name,
+        namespace_id = excluded.namespace_id,
+        class = excluded.class,
+        updated_at = CURRENT_TIMESTAMP
+    RETURNING id;
+    `, uid, name, nsID, class).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM ingress_rules WHERE ingress_id = ?", id); err != nil {
+		return 0, err
+	}
+	for _, rule := range rules {
+		if _, err := tx.Exec("INSERT INTO ingress_rules (ingress_id, host, path, service_name, service_port) VALUES (?, ?, ?, ?, ?)",
+			id, rule.Host, rule.Path, rule.ServiceName, rule.ServicePort); err != nil {
+			return 0, err
+		}
+	}
+
+	return id, tx.Commit()
+}
+
+// DeleteIngress removes an ingress together with its rules
+func (s *SQLiteStore) DeleteIngress(uid string) error {
+	if _, err := s.db.Exec("DELETE FROM ingress_rules WHERE ingress_id IN (SELECT id FROM ingresses WHERE uid = ?)", uid); err != nil {
+		return err
+	}
+	_, err := s.db.Exec("DELETE FROM ingresses WHERE uid = ?", uid)
+	return err
+}
+
+// ConfigRef is a pod's reference to a configmap or secret
+type ConfigRef struct {
+	Kind string // "configmap" or "secret"
+	Name string
+	Via  string // "volume" or "env"
+}
+
+// ReplaceConfigRefs overwrites the configmap/secret references of a pod
+func (s *SQLiteStore) ReplaceConfigRefs(podID int64, refs []ConfigRef) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM config_refs WHERE pod_id = ?", podID); err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO config_refs (pod_id, kind, name, via) VALUES (?, ?, ?, ?)",
+			podID, ref.Kind, ref.Name, ref.Via); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ReplacePodClaims overwrites the PVCs a pod mounts
+func (s *SQLiteStore) ReplacePodClaims(podID int64, claims []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM pod_claims WHERE pod_id = ?", podID); err != nil {
+		return err
+	}
+	for _, name := range claims {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO pod_claims (pod_id, name) VALUES (?, ?)", podID, name); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ContainerResources are the requests/limits of one container; nil means unset
+type ContainerResources struct {
+	Name         string
+	CPURequestM  *int64
+	CPULimitM    *int64
+	MemRequestMB *float64
+	MemLimitMB   *float64
+}
+
+// ContainerSpec is a container's resources plus the spec fields kept for
+// debugging config changes. Env var values are never stored.
+type ContainerSpec struct {
+	ContainerResources
+	Image           string
+	ImagePullPolicy string
+	Args            []string
+	EnvNames        []string
+}
+
+// ReplacePodContainers overwrites the container specs of a pod
+func (s *SQLiteStore) ReplacePodContainers(podID int64, containers []ContainerSpec) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM pod_containers WHERE pod_id = ?", podID); err != nil {
+		return err
+	}
+	for _, c := range containers {
+		args, _ := json.Marshal(c.Args)
+		envNames, _ := json.Marshal(c.EnvNames)
+		if _, err := tx.Exec(`INSERT INTO pod_containers (pod_id, name, cpu_request_m, cpu_limit_m, mem_request_mb, mem_limit_mb,
+			image, image_pull_policy, args, env_names) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			podID, c.Name, c.CPURequestM, c.CPULimitM, c.MemRequestMB, c.MemLimitMB,
+			c.Image, c.ImagePullPolicy, string(args), string(envNames)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// PodContainers returns the stored container specs of a pod
+func (s *SQLiteStore) PodContainers(podID int64) ([]ContainerSpec, error) {
+	rows, err := s.db.Query(`SELECT name, cpu_request_m, cpu_limit_m, mem_request_mb, mem_limit_mb,
+		COALESCE(image, ''), COALESCE(image_pull_policy, ''), COALESCE(args, '[]'), COALESCE(env_names, '[]')
+		FROM pod_containers WHERE pod_id = ? ORDER BY rowid`, podID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ContainerSpec
+	for rows.Next() {
+		var c ContainerSpec
+		var args, envNames string
+		if err := rows.Scan(&c.Name, &c.CPURequestM, &c.CPULimitM, &c.MemRequestMB, &c.MemLimitMB,
+			&c.Image, &c.ImagePullPolicy, &args, &envNames); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(args), &c.Args)
+		json.Unmarshal([]byte(envNames), &c.EnvNames)
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// SetPodServiceAccount records the service account a pod runs as
+func (s *SQLiteStore) SetPodServiceAccount(id int64, name string) error {
+	_, err := s.db.Exec(`UPDATE pods SET service_account = ? WHERE id = ?`, name, id)
+	return err
+}
+
+// PodConfigRefs returns the configmaps and secrets a pod references
+func (s *SQLiteStore) PodConfigRefs(podID int64) ([]ConfigRef, error) {
+	rows, err := s.db.Query(`SELECT kind, name, via FROM config_refs WHERE pod_id = ? ORDER BY kind, name, via`, podID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ConfigRef
+	for rows.Next() {
+		var r ConfigRef
+		if err := rows.Scan(&r.Kind, &r.Name, &r.Via); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// CountConfigConsumers returns how many pods in a namespace reference the object
+func (s *SQLiteStore) CountConfigConsumers(nsID int64, kind, name string) (int, error) {
+	var n int
+	err := s.db.QueryRow(`
+		SELECT COUNT(DISTINCT cr.pod_id) FROM config_refs cr
+		JOIN pods p ON cr.pod_id = p.id
+		WHERE p.namespace_id = ? AND cr.kind = ? AND cr.name = ?`, nsID, kind, name).Scan(&n)
+	return n, err
+}
+
+// Annotation is a change marker shown alongside metric charts
+type Annotation struct {
+	Time        time.Time
+	NamespaceID *int64
+	Type        string // e.g. "config_change"
+	Kind        string
+	Name        string
+	Message     string
+}
+
+func (s *SQLiteStore) InsertAnnotation(a Annotation) error {
+	_, err := s.db.Exec("INSERT INTO annotations (time, namespace_id, type, kind, name, message) VALUES (?, ?, ?, ?, ?, ?)",
+		a.Time.UTC(), a.NamespaceID, a.Type, a.Kind, a.Name, a.Message)
+	return err
+}
+
+// DeleteByUID removes a catalog row that has no history attached
+func (s *SQLiteStore) DeleteByUID(table, uid string) error {
+	_, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE uid = ?", table), uid)
+	return err
+}
+
 func (s *SQLiteStore) GetResourceID(table, uid string) (int64, error) {
 	var id int64
 	query := fmt.Sprintf("SELECT id FROM %s WHERE uid = ?", table)
@@ -206,7 +946,57 @@ func (s *SQLiteStore) GetResourceID(table, uid string) (int64, error) {
 	return id, err
 }
 
+// IDsByUID looks up many rows of table by uid; uids not found are absent
+// from the result
+func (s *SQLiteStore) IDsByUID(table string, uids []string) (map[string]int64, error) {
+	out := make(map[string]int64, len(uids))
+	// Chunked to stay under SQLite's bound variable limit
+	for start := 0; start < len(uids); start += 500 {
+		chunk := uids[start:min(start+500, len(uids))]
+		args := make([]interface{}, len(chunk))
+		for i, uid := range chunk {
+			args[i] = uid
+		}
+		marks := strings.TrimSuffix(strings.Repeat("?,", len(chunk)), ",")
+		rows, err := s.db.Query(fmt.Sprintf("SELECT uid, id FROM %s WHERE uid IN (%s)", table, marks), args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var uid string
+			var id int64
+			if err := rows.Scan(&uid, &id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			out[uid] = id
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
 // Query executes a SQL query and returns rows
+// PodRef names a pod in the cluster
+type PodRef struct {
+	Namespace      string
+	Name           string
+	ServiceAccount string
+	Deleted        bool // a later pod may have taken the name
+}
+
+// GetPodRef returns sql.ErrNoRows for unknown ids
+func (s *SQLiteStore) GetPodRef(id int64) (PodRef, error) {
+	var p PodRef
+	err := s.db.QueryRow(`SELECT n.name, p.name, COALESCE(p.service_account, ''), p.deleted_at IS NOT NULL FROM pods p
+		JOIN namespaces n ON p.namespace_id = n.id
+		WHERE p.id = ?`, id).Scan(&p.Namespace, &p.Name, &p.ServiceAccount, &p.Deleted)
+	return p, err
+}
+
 func (s *SQLiteStore) Query(query string, args ...interface{}) (*sql.Rows, error) {
 	return s.db.Query(query, args...)
 }
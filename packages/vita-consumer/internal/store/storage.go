@@ -0,0 +1,147 @@
+package store
+
+import (
+	"time"
+)
+
+// StorageClass is the catalog row of a StorageClass
+type StorageClass struct {
+	UID            string
+	Name           string
+	Provisioner    string
+	ReclaimPolicy  string
+	BindingMode    string
+	AllowExpansion bool
+	Default        bool
+}
+
+func (s *SQLiteStore) UpsertStorageClass(sc StorageClass) (int64, error) {
+	query := `
+    INSERT INTO storage_classes (uid, name, provisioner, reclaim_policy, binding_mode, allow_expansion, is_default, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+    ON CONFLICT(uid) DO UPDATE SET
+        name = excluded.name,
+        provisioner = excluded.provisioner,
+        reclaim_policy = excluded.reclaim_policy,
+        binding_mode = excluded.binding_mode,
+        allow_expansion = excluded.allow_expansion,
+        is_default = excluded.is_default,
+        updated_at = CURRENT_TIMESTAMP
+    RETURNING id;
+    `
+	var id int64
+	err := s.db.QueryRow(query, sc.UID, sc.Name, sc.Provisioner, sc.ReclaimPolicy, sc.BindingMode, sc.AllowExpansion, sc.Default).Scan(&id)
+	return id, err
+}
+
+// PersistentVolume is the catalog row of a PersistentVolume
+type PersistentVolume struct {
+	UID            string
+	Name           string
+	StorageClass   string
+	CapacityMB     float64
+	ReclaimPolicy  string
+	Phase          string
+	AccessModes    string
+	Driver         string
+	ClaimNamespace string
+	ClaimName      string
+}
+
+func (s *SQLiteStore) UpsertPersistentVolume(pv PersistentVolume) (int64, error) {
+	query := `
+    INSERT INTO persistent_volumes (uid, name, storage_class, capacity_mb, reclaim_policy, phase, access_modes, driver,
+        claim_namespace, claim_name, updated_at)
+    VALUES (?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), CURRENT_TIMESTAMP)
+    ON CONFLICT(uid) DO UPDATE SET
+        name = excluded.name,
+        storage_class = excluded.storage_class,
+        capacity_mb = excluded.capacity_mb,
+        reclaim_policy = excluded.reclaim_policy,
+        phase = excluded.phase,
+        access_modes = excluded.access_modes,
+        driver = excluded.driver,
+        claim_namespace = excluded.claim_namespace,
+        claim_name = excluded.claim_name,
+        updated_at = CURRENT_TIMESTAMP
+    RETURNING id;
+    `
+	var id int64
+	err := s.db.QueryRow(query, pv.UID, pv.Name, pv.StorageClass, pv.CapacityMB, pv.ReclaimPolicy, pv.Phase, pv.AccessModes,
+		pv.Driver, pv.ClaimNamespace, pv.ClaimName).Scan(&id)
+	return id, err
+}
+
+// PVCStorage is what a claim asks for and what it is bound to
+type PVCStorage struct {
+	VolumeName   string
+	StorageClass string
+	RequestedMB  *float64
+	CapacityMB   *float64
+	Phase        string
+}
+
+// SetPVCStorage records a claim's class, request and binding, returning
+// the bound capacity it replaced
+func (s *SQLiteStore) SetPVCStorage(id int64, st PVCStorage) (*float64, error) {
+	var prev *float64
+	if err := s.db.QueryRow(`SELECT capacity_mb FROM pvcs WHERE id = ?`, id).Scan(&prev); err != nil {
+		return nil, err
+	}
+	_, err := s.db.Exec(`UPDATE pvcs SET volume_name = NULLIF(?, ''), storage_class = NULLIF(?, ''),
+		requested_mb = ?, capacity_mb = ?, phase = NULLIF(?, '') WHERE id = ?`,
+		st.VolumeName, st.StorageClass, st.RequestedMB, st.CapacityMB, st.Phase, id)
+	return prev, err
+}
+
+// PVCResize is a change of a claim's bound capacity
+type PVCResize struct {
+	Time   time.Time `json:"time"`
+	FromMB float64   `json:"from_mb"`
+	ToMB   float64   `json:"to_mb"`
+}
+
+func (s *SQLiteStore) InsertPVCResize(pvcID int64, r PVCResize) error {
+	_, err := s.db.Exec(`INSERT INTO pvc_resizes (pvc_id, time, from_mb, to_mb) VALUES (?, ?, ?, ?)`,
+		pvcID, r.Time.UTC(), r.FromMB, r.ToMB)
+	return err
+}
+
+// PVCResizes returns a claim's resizes, oldest first
+func (s *SQLiteStore) PVCResizes(pvcID int64) ([]PVCResize, error) {
+	rows, err := s.db.Query(`SELECT time, from_mb, to_mb FROM pvc_resizes WHERE pvc_id = ? ORDER BY time, id`, pvcID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []PVCResize{}
+	for rows.Next() {
+		var r PVCResize
+		if err := rows.Scan(&r.Time, &r.FromMB, &r.ToMB); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// LastPVCResizes returns the latest resize of every claim that has one
+func (s *SQLiteStore) LastPVCResizes() (map[int64]PVCResize, error) {
+	rows, err := s.db.Query(`SELECT pvc_id, time, from_mb, to_mb FROM pvc_resizes ORDER BY time, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]PVCResize)
+	for rows.Next() {
+		var id int64
+		var r PVCResize
+		if err := rows.Scan(&id, &r.Time, &r.FromMB, &r.ToMB); err != nil {
+			return nil, err
+		}
+		out[id] = r
+	}
+	return out, rows.Err()
+}
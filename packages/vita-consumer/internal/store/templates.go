@@ -0,0 +1,77 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// QueryTemplate is a named set of history queries with parameters, see
+// api.handleQueryTemplates. Params and Queries are validated by the API
+// and stored as given.
+type QueryTemplate struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Params      json.RawMessage `json:"params"`
+	Queries     json.RawMessage `json:"queries"`
+	UpdatedBy   string          `json:"updated_by,omitempty"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+const templateColumns = `name, description, params, queries, updated_by, updated_at`
+
+func scanQueryTemplate(row interface{ Scan(...interface{}) error }) (QueryTemplate, error) {
+	var t QueryTemplate
+	var params, queries string
+	err := row.Scan(&t.Name, &t.Description, &params, &queries, &t.UpdatedBy, &t.UpdatedAt)
+	t.Params, t.Queries = json.RawMessage(params), json.RawMessage(queries)
+	return t, err
+}
+
+func (s *SQLiteStore) ListQueryTemplates() ([]QueryTemplate, error) {
+	rows, err := s.db.Query(`SELECT ` + templateColumns + ` FROM query_templates ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []QueryTemplate{}
+	for rows.Next() {
+		t, err := scanQueryTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// GetQueryTemplate returns sql.ErrNoRows when the template does not exist
+func (s *SQLiteStore) GetQueryTemplate(name string) (QueryTemplate, error) {
+	return scanQueryTemplate(s.db.QueryRow(`SELECT `+templateColumns+` FROM query_templates WHERE name = ?`, name))
+}
+
+// PutQueryTemplate creates or replaces a template by name and reports
+// whether it was created
+func (s *SQLiteStore) PutQueryTemplate(t QueryTemplate) (bool, error) {
+	var created bool
+	err := s.db.QueryRow(`SELECT NOT EXISTS (SELECT 1 FROM query_templates WHERE name = ?)`, t.Name).Scan(&created)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.db.Exec(`INSERT INTO query_templates (name, description, params, queries, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET description = excluded.description, params = excluded.params,
+			queries = excluded.queries, updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+		t.Name, t.Description, string(t.Params), string(t.Queries), t.UpdatedBy, t.UpdatedAt.UTC())
+	return created, err
+}
+
+// DeleteQueryTemplate reports whether the template existed
+func (s *SQLiteStore) DeleteQueryTemplate(name string) (bool, error) {
+	res, err := s.db.Exec("DELETE FROM query_templates WHERE name = ?", name)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
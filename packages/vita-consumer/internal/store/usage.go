@@ -0,0 +1,64 @@
+package store
+
+import "time"
+
+// IngestUsage counts datapoints ingested for one namespace or node in one
+// hour. Dropped counts points rejected by quotas.
+type IngestUsage struct {
+	Hour    time.Time
+	Scope   string // "namespace" or "node"
+	Name    string
+	Points  int64
+	Dropped int64
+}
+
+// AddIngestUsage adds counts onto the stored hourly totals
+func (s *SQLiteStore) AddIngestUsage(rows []IngestUsage) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO ingest_usage (hour, scope, name, points, dropped) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(hour, scope, name) DO UPDATE SET points = points + excluded.points, dropped = dropped + excluded.dropped`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range rows {
+		if _, err := stmt.Exec(r.Hour.Unix(), r.Scope, r.Name, r.Points, r.Dropped); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// IngestUsageRange returns hourly totals for a scope with from <= hour < to
+func (s *SQLiteStore) IngestUsageRange(scope string, from, to time.Time) ([]IngestUsage, error) {
+	rows, err := s.db.Query(`SELECT hour, name, points, dropped FROM ingest_usage
+		WHERE scope = ? AND hour >= ? AND hour < ? ORDER BY hour, name`, scope, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []IngestUsage
+	for rows.Next() {
+		u := IngestUsage{Scope: scope}
+		var hour int64
+		if err := rows.Scan(&hour, &u.Name, &u.Points, &u.Dropped); err != nil {
+			return nil, err
+		}
+		u.Hour = time.Unix(hour, 0)
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// DeleteIngestUsageBefore drops hourly totals older than t
+func (s *SQLiteStore) DeleteIngestUsageBefore(t time.Time) error {
+	_, err := s.db.Exec("DELETE FROM ingest_usage WHERE hour < ?", t.Unix())
+	return err
+}
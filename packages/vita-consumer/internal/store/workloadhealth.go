@@ -0,0 +1,231 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// WorkloadKinds maps workload kinds to their tables
+var WorkloadKinds = map[string]string{
+	"deployment":  "deployments",
+	"statefulset": "statefulsets",
+	"daemonset":   "daemonsets",
+}
+
+// ContainerState summarizes a pod's container statuses
+type ContainerState struct {
+	Restarts    int32      // summed over containers
+	OOMKilledAt *time.Time // latest OOMKilled termination
+	Waiting     string     // first error a container is waiting on, e.g. CrashLoopBackOff
+}
+
+// SetPodContainerState records a pod's restarts, last OOM kill and error
+// waiting reason
+func (s *SQLiteStore) SetPodContainerState(id int64, st ContainerState) error {
+	var oom interface{}
+	if st.OOMKilledAt != nil {
+		oom = st.OOMKilledAt.UTC()
+	}
+	_, err := s.db.Exec(`UPDATE pods SET restarts = ?, oom_killed_at = ?, waiting_reason = ? WHERE id = ?`,
+		st.Restarts, oom, st.Waiting, id)
+	return err
+}
+
+// WorkloadPod is a live pod of a workload with its container state and
+// limits. Limits are set only when every container has one.
+type WorkloadPod struct {
+	PodID      int64
+	Kind       string
+	WorkloadID int64
+	ContainerState
+	CPULimitM  *int64
+	MemLimitMB *float64
+}
+
+// WorkloadPods returns the pods, not known to be deleted, that belong to a
+// deployment, statefulset or daemonset
+func (s *SQLiteStore) WorkloadPods() ([]WorkloadPod, error) {
+	rows, err := s.db.Query(`SELECT p.id, p.deployment_id, p.statefulset_id, p.daemonset_id,
+			COALESCE(p.restarts, 0), p.oom_killed_at, COALESCE(p.waiting_reason, ''),
+			SUM(pc.cpu_limit_m), SUM(pc.mem_limit_mb), COUNT(pc.pod_id), COUNT(pc.cpu_limit_m), COUNT(pc.mem_limit_mb)
+		FROM pods p
+		LEFT JOIN pod_containers pc ON pc.pod_id = p.id
+		WHERE p.deleted_at IS NULL
+			AND (p.deployment_id IS NOT NULL OR p.statefulset_id IS NOT NULL OR p.daemonset_id IS NOT NULL)
+		GROUP BY p.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []WorkloadPod
+	for rows.Next() {
+		var p WorkloadPod
+		var dep, sts, ds sql.NullInt64
+		var oom sql.NullTime
+		var cpu sql.NullInt64
+		var mem sql.NullFloat64
+		var containers, cpuLimited, memLimited int
+		if err := rows.Scan(&p.PodID, &dep, &sts, &ds, &p.Restarts, &oom, &p.Waiting,
+			&cpu, &mem, &containers, &cpuLimited, &memLimited); err != nil {
+			return nil, err
+		}
+		switch {
+		case dep.Valid:
+			p.Kind, p.WorkloadID = "deployment", dep.Int64
+		case sts.Valid:
+			p.Kind, p.WorkloadID = "statefulset", sts.Int64
+		default:
+			p.Kind, p.WorkloadID = "daemonset", ds.Int64
+		}
+		if oom.Valid {
+			p.OOMKilledAt = &oom.Time
+		}
+		if containers > 0 && containers == cpuLimited {
+			p.CPULimitM = &cpu.Int64
+		}
+		if containers > 0 && containers == memLimited {
+			p.MemLimitMB = &mem.Float64
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// WorkloadHealth is a workload's health score at one time, with the
+// signals it was computed from
+type WorkloadHealth struct {
+	Time             time.Time `json:"time"`
+	Kind             string    `json:"kind"`
+	WorkloadID       int64     `json:"workload_id"`
+	Score            float64   `json:"score"` // 100 is healthy
+	Restarts         int       `json:"restarts"`
+	OOMKills         int       `json:"oom_kills"`
+	ThrottledPct     float64   `json:"throttled_pct"`
+	CPUSaturationPct float64   `json:"cpu_saturation_pct"` // usage vs limit
+	MemSaturationPct float64   `json:"mem_saturation_pct"`
+	Errors           int       `json:"errors"` // pods waiting on an error
+}
+
+const workloadHealthColumns = `time, kind, workload_id, score, restarts, oom_kills,
+	throttled_pct, cpu_saturation_pct, mem_saturation_pct, errors`
+
+func scanWorkloadHealth(rows *sql.Rows) ([]WorkloadHealth, error) {
+	out := []WorkloadHealth{}
+	for rows.Next() {
+		var h WorkloadHealth
+		var t int64
+		if err := rows.Scan(&t, &h.Kind, &h.WorkloadID, &h.Score, &h.Restarts, &h.OOMKills,
+			&h.ThrottledPct, &h.CPUSaturationPct, &h.MemSaturationPct, &h.Errors); err != nil {
+			return nil, err
+		}
+		h.Time = time.Unix(t, 0).UTC()
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+// InsertWorkloadHealth stores one scoring run
+func (s *SQLiteStore) InsertWorkloadHealth(scores []WorkloadHealth) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT OR REPLACE INTO workload_health (` + workloadHealthColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, h := range scores {
+		if _, err := stmt.Exec(h.Time.Unix(), h.Kind, h.WorkloadID, h.Score, h.Restarts, h.OOMKills,
+			h.ThrottledPct, h.CPUSaturationPct, h.MemSaturationPct, h.Errors); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LatestWorkloadHealth returns the most recent score of every workload of
+// kind (all kinds when empty) that still exists
+func (s *SQLiteStore) LatestWorkloadHealth(kind string) ([]WorkloadHealth, error) {
+	query := `SELECT ` + workloadHealthColumns + ` FROM workload_health h
+		WHERE time = (SELECT MAX(time) FROM workload_health WHERE kind = h.kind AND workload_id = h.workload_id)`
+	args := []interface{}{}
+	if kind != "" {
+		if _, ok := WorkloadKinds[kind]; !ok {
+			return nil, fmt.Errorf("unknown workload kind %q", kind)
+		}
+		query += " AND kind = ?"
+		args = append(args, kind)
+	}
+	for k, table := range WorkloadKinds {
+		query += fmt.Sprintf(" AND (kind != '%s' OR workload_id IN (SELECT id FROM %s))", k, table)
+	}
+	query += " ORDER BY score, kind, workload_id"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanWorkloadHealth(rows)
+}
+
+// WorkloadHealthHistory returns a workload's scores with from <= time < to
+func (s *SQLiteStore) WorkloadHealthHistory(kind string, id int64, from, to time.Time) ([]WorkloadHealth, error) {
+	rows, err := s.db.Query(`SELECT `+workloadHealthColumns+` FROM workload_health
+		WHERE kind = ? AND workload_id = ? AND time >= ? AND time < ? ORDER BY time`, kind, id, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanWorkloadHealth(rows)
+}
+
+// WorkloadHealthBetween returns the scores of every workload with
+// from <= time < to, oldest first
+func (s *SQLiteStore) WorkloadHealthBetween(from, to time.Time) ([]WorkloadHealth, error) {
+	rows, err := s.db.Query(`SELECT `+workloadHealthColumns+` FROM workload_health
+		WHERE time >= ? AND time < ? ORDER BY time`, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanWorkloadHealth(rows)
+}
+
+// SetWorkloadRevision records the revision a workload of kind rolled out
+// and returns the one recorded before, empty if none
+func (s *SQLiteStore) SetWorkloadRevision(kind string, id int64, revision string) (string, error) {
+	table, ok := WorkloadKinds[kind]
+	if !ok {
+		return "", fmt.Errorf("unknown workload kind %q", kind)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	var prev sql.NullString
+	if err := tx.QueryRow(fmt.Sprintf("SELECT revision FROM %s WHERE id = ?", table), id).Scan(&prev); err != nil {
+		return "", err
+	}
+	if prev.String == revision {
+		return prev.String, nil
+	}
+	if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET revision = ? WHERE id = ?", table), revision, id); err != nil {
+		return "", err
+	}
+	return prev.String, tx.Commit()
+}
+
+// DeleteWorkloadHealthBefore drops scores older than t
+func (s *SQLiteStore) DeleteWorkloadHealthBefore(t time.Time) error {
+	_, err := s.db.Exec("DELETE FROM workload_health WHERE time < ?", t.Unix())
+	return err
+}
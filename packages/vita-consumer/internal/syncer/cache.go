@@ -0,0 +1,119 @@
+package syncer
+
+import "sync"
+
+const cacheShards = 32
+
+// idCache is a UID -> ID map sharded by key hash so that ingest lookups
+// and informer writes only contend when they land on the same shard.
+type idCache struct {
+	shards [cacheShards]idShard
+}
+
+type idShard struct {
+	mu sync.RWMutex
+	m  map[string]int64
+}
+
+func newIDCache() *idCache {
+	c := &idCache{}
+	for i := range c.shards {
+		c.shards[i].m = make(map[string]int64)
+	}
+	return c
+}
+
+// FNV-1a parameters (hash/fnv), inlined so lookups do not allocate
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// shardIndex hashes key with 32-bit FNV-1a
+func shardIndex(key string) int {
+	h := uint32(fnvOffset32)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= fnvPrime32
+	}
+	return int(h % cacheShards)
+}
+
+func (c *idCache) Get(key string) (int64, bool) {
+	sh := &c.shards[shardIndex(key)]
+	sh.mu.RLock()
+	id, ok := sh.m[key]
+	sh.mu.RUnlock()
+	return id, ok
+}
+
+func (c *idCache) Set(key string, id int64) {
+	sh := &c.shards[shardIndex(key)]
+	sh.mu.Lock()
+	sh.m[key] = id
+	sh.mu.Unlock()
+}
+
+func (c *idCache) Delete(key string) {
+	sh := &c.shards[shardIndex(key)]
+	sh.mu.Lock()
+	delete(sh.m, key)
+	sh.mu.Unlock()
+}
+
+func (c *idCache) Len() int {
+	n := 0
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.mu.RLock()
+		n += len(sh.m)
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+// GetMany resolves all keys, taking each shard's lock at most once.
+// Unknown keys resolve to 0.
+func (c *idCache) GetMany(keys []string) []int64 {
+	ids := make([]int64, len(keys))
+	if len(keys) == 0 {
+		return ids
+	}
+
+	// Group key positions by shard
+	var byShard [cacheShards][]int
+	for i, k := range keys {
+		if k == "" {
+			continue
+		}
+		idx := shardIndex(k)
+		byShard[idx] = append(byShard[idx], i)
+	}
+
+	for idx, positions := range byShard {
+		if len(positions) == 0 {
+			continue
+		}
+		sh := &c.shards[idx]
+		sh.mu.RLock()
+		for _, pos := range positions {
+			ids[pos] = sh.m[keys[pos]]
+		}
+		sh.mu.RUnlock()
+	}
+	return ids
+}
+
+// DeleteIDs removes every entry that maps to one of ids
+func (c *idCache) DeleteIDs(ids map[int64]bool) {
+	for i := range c.shards {
+		sh := &c.shards[i]
+		sh.mu.Lock()
+		for k, id := range sh.m {
+			if ids[id] {
+				delete(sh.m, k)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
@@ -0,0 +1,133 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ConfigObject is a configmap or secret whose resourceVersion changed.
+// Only metadata is ever fetched: data and secret values never reach the
+// consumer.
+type ConfigObject struct {
+	Kind        string // "configmap" or "secret"
+	Meta        *metav1.PartialObjectMetadata
+	PrevVersion string
+}
+
+var configResources = map[string]schema.GroupVersionResource{
+	"configmap": corev1.SchemeGroupVersion.WithResource("configmaps"),
+	"secret":    corev1.SchemeGroupVersion.WithResource("secrets"),
+}
+
+// startConfigInformers watches configmap/secret metadata and publishes a
+// ConfigObject on the object bus whenever one changes
+func (s *ResourceSyncer) startConfigInformers(ctx context.Context) error {
+	if s.config == nil {
+		return fmt.Errorf("no REST config")
+	}
+	client, err := metadata.NewForConfig(s.config)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata client: %w", err)
+	}
+	factory := metadatainformer.NewSharedInformerFactory(client, s.resync)
+
+	for kind, gvr := range configResources {
+		factory.ForResource(gvr).Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, new interface{}) {
+				o, ok1 := old.(*metav1.PartialObjectMetadata)
+				n, ok2 := new.(*metav1.PartialObjectMetadata)
+				if !ok1 || !ok2 || o.ResourceVersion == n.ResourceVersion {
+					return
+				}
+				s.objects.Publish(ObjectEvent{Type: EventUpdated, Obj: &ConfigObject{Kind: kind, Meta: n, PrevVersion: o.ResourceVersion}})
+			},
+		})
+	}
+
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	return nil
+}
+
+// syncConfigObject records an annotation when a configmap/secret that is
+// referenced by at least one pod changes
+func (s *ResourceSyncer) syncConfigObject(c *ConfigObject) {
+	nsID := s.getNamespaceID(c.Meta.Namespace)
+	if nsID == 0 {
+		return
+	}
+
+	n, err := s.sqlite.CountConfigConsumers(nsID, c.Kind, c.Meta.Name)
+	if err != nil || n == 0 {
+		return
+	}
+
+	err = s.sqlite.InsertAnnotation(store.Annotation{
+		Time:        s.clock.Now(),
+		NamespaceID: &nsID,
+		Type:        "config_change",
+		Kind:        c.Kind,
+		Name:        c.Meta.Name,
+		Message:     fmt.Sprintf("%s %s/%s changed (resourceVersion %s -> %s), used by %d pods", c.Kind, c.Meta.Namespace, c.Meta.Name, c.PrevVersion, c.Meta.ResourceVersion, n),
+	})
+	if err != nil {
+		log.Printf("Failed to record config change for %s %s: %v", c.Kind, c.Meta.Name, err)
+	}
+}
+
+// podConfigRefs lists the configmaps and secrets a pod mounts or reads env from
+func podConfigRefs(pod *corev1.Pod) []store.ConfigRef {
+	var refs []store.ConfigRef
+
+	for _, v := range pod.Spec.Volumes {
+		if v.ConfigMap != nil {
+			refs = append(refs, store.ConfigRef{Kind: "configmap", Name: v.ConfigMap.Name, Via: "volume"})
+		}
+		if v.Secret != nil {
+			refs = append(refs, store.ConfigRef{Kind: "secret", Name: v.Secret.SecretName, Via: "volume"})
+		}
+		if v.Projected != nil {
+			for _, src := range v.Projected.Sources {
+				if src.ConfigMap != nil {
+					refs = append(refs, store.ConfigRef{Kind: "configmap", Name: src.ConfigMap.Name, Via: "volume"})
+				}
+				if src.Secret != nil {
+					refs = append(refs, store.ConfigRef{Kind: "secret", Name: src.Secret.Name, Via: "volume"})
+				}
+			}
+		}
+	}
+
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, c := range containers {
+		for _, from := range c.EnvFrom {
+			if from.ConfigMapRef != nil {
+				refs = append(refs, store.ConfigRef{Kind: "configmap", Name: from.ConfigMapRef.Name, Via: "env"})
+			}
+			if from.SecretRef != nil {
+				refs = append(refs, store.ConfigRef{Kind: "secret", Name: from.SecretRef.Name, Via: "env"})
+			}
+		}
+		for _, env := range c.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+				refs = append(refs, store.ConfigRef{Kind: "configmap", Name: ref.Name, Via: "env"})
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+				refs = append(refs, store.ConfigRef{Kind: "secret", Name: ref.Name, Via: "env"})
+			}
+		}
+	}
+	return refs
+}
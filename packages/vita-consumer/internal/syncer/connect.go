@@ -0,0 +1,141 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Connection states reported by Status
+const (
+	StateConnecting = "connecting" // API server not reached yet; retrying
+	StateSyncing    = "syncing"    // informers are listing the cluster
+	StateSynced     = "synced"
+)
+
+// maxConnectBackoff caps the wait between connection attempts
+const maxConnectBackoff = time.Minute
+
+// errNotConnected is returned by calls that need the API server before
+// the syncer has reached it
+var errNotConnected = errors.New("kubernetes API server not connected yet")
+
+// Status describes the syncer's link to the Kubernetes API. Until it is
+// synced the catalog is served as last stored, and ingest only resolves
+// pods and PVCs known from before.
+type Status struct {
+	State     string    `json:"state"`
+	Since     time.Time `json:"since"`
+	Attempts  int       `json:"attempts,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Status returns the current connection state
+func (s *ResourceSyncer) Status() Status {
+	s.statusMu.RLock()
+	defer s.statusMu.RUnlock()
+	return s.status
+}
+
+func (s *ResourceSyncer) setStatus(state string, attempts int, err error) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	if s.status.State != state {
+		s.status.Since = time.Now()
+	}
+	s.status.State = state
+	s.status.Attempts = attempts
+	s.status.LastError = ""
+	if err != nil {
+		s.status.LastError = err.Error()
+	}
+}
+
+// kube returns the client, or nil before the syncer has connected
+func (s *ResourceSyncer) kube() kubernetes.Interface {
+	s.statusMu.RLock()
+	defer s.statusMu.RUnlock()
+	return s.client
+}
+
+// started reports whether Start has set up the informers; syncing an
+// object needs their listers
+func (s *ResourceSyncer) started() bool {
+	s.statusMu.RLock()
+	defer s.statusMu.RUnlock()
+	return s.factory != nil
+}
+
+// kubeConnector builds a client from a kubeconfig path (empty: in-cluster)
+func kubeConnector(kubeConfigPath string) func() (kubernetes.Interface, *rest.Config, error) {
+	return func() (kubernetes.Interface, *rest.Config, error) {
+		config, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to build kube config: %w", err)
+		}
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create clientset: %w", err)
+		}
+		return clientset, config, nil
+	}
+}
+
+// connect retries building a client and reaching the API server with
+// exponential backoff. It returns false if ctx is done first.
+func (s *ResourceSyncer) connect(ctx context.Context) bool {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		client, config, err := s.connector()
+		if err == nil {
+			_, err = client.Discovery().ServerVersion()
+		}
+		if err == nil {
+			s.statusMu.Lock()
+			s.client, s.config = client, config
+			s.statusMu.Unlock()
+			s.setStatus(StateSyncing, attempt, nil)
+			return true
+		}
+
+		s.setStatus(StateConnecting, attempt, err)
+		log.Printf("Kubernetes API unavailable (attempt %d), serving stored catalog; retrying in %s: %v", attempt, backoff, err)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxConnectBackoff)
+	}
+}
+
+// warmCaches loads id mappings from the catalog, so ingest resolves known
+// pods and PVCs before (or without) the informers syncing
+func (s *ResourceSyncer) warmCaches() {
+	for _, c := range []struct {
+		table, key string
+		set        func(k string, id int64)
+	}{
+		{"pods", "uid", s.pods.Set},
+		{"pvcs", "uid", s.pvcs.Set},
+		{"namespaces", "name", func(k string, id int64) { s.namespaces[k] = id }},
+		{"nodes", "name", func(k string, id int64) { s.nodes[k] = id }},
+	} {
+		ids, err := s.sqlite.IDsByKey(c.table, c.key)
+		if err != nil {
+			log.Printf("Failed to preload %s ids: %v", c.table, err)
+			continue
+		}
+		s.mu.Lock()
+		for k, id := range ids {
+			c.set(k, id)
+		}
+		s.mu.Unlock()
+	}
+}
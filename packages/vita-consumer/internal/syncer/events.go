@@ -0,0 +1,91 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/events"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var tracer = otel.Tracer("github.com/nchanged/vitakube/packages/vita-consumer/internal/syncer")
+
+// EventType describes what happened to a resource
+type EventType string
+
+const (
+	EventAdded   EventType = "added"
+	EventUpdated EventType = "updated"
+	EventDeleted EventType = "deleted"
+)
+
+// ObjectEvent is a raw informer notification. Obj is the Kubernetes object
+// (for deletes possibly a cache.DeletedFinalStateUnknown tombstone).
+type ObjectEvent struct {
+	Type EventType
+	Obj  interface{}
+}
+
+// Event is a resource change observed by the syncer, emitted after the
+// catalog has been written so subscribers can read the new state.
+type Event struct {
+	Type      EventType `json:"type"`
+	Kind      string    `json:"kind"` // "pod", "node", "deployment", "statefulset", "daemonset", "pvc", "namespace"
+	ID        int64     `json:"id"`
+	UID       string    `json:"uid"`
+	Name      string    `json:"name"`
+	Namespace string    `json:"namespace,omitempty"`
+
+	// Obj is the Kubernetes object that caused the change
+	Obj interface{} `json:"-"`
+}
+
+const (
+	// persistBuffer absorbs informer bursts (initial list) before the
+	// informer callbacks start blocking on SQLite.
+	persistBuffer = 4096
+	// subscriberBuffer is how many events a best-effort subscriber may lag
+	// behind before events are dropped for it.
+	subscriberBuffer = 256
+)
+
+// Objects is the bus of raw informer notifications. Subscribe here to react
+// to Kubernetes objects directly (e.g. inspect pod status) without touching
+// the catalog persistence path.
+func (s *ResourceSyncer) Objects() *events.Bus[ObjectEvent] {
+	return s.objects
+}
+
+// Changes is the bus of catalog change events (with database IDs)
+func (s *ResourceSyncer) Changes() *events.Bus[Event] {
+	return s.changes
+}
+
+// Subscribe returns a best-effort channel of catalog change events and a
+// function to stop receiving them.
+func (s *ResourceSyncer) Subscribe() (<-chan Event, func()) {
+	return s.changes.Subscribe("watch", subscriberBuffer, false)
+}
+
+func (s *ResourceSyncer) publish(ev Event) {
+	s.changes.Publish(ev)
+}
+
+// runPersister is the SQLite persistence subscriber of the object bus.
+// It drains for the lifetime of the process so informers never block on it
+// indefinitely.
+func (s *ResourceSyncer) runPersister(objects <-chan ObjectEvent) {
+	for ev := range objects {
+		_, span := tracer.Start(context.Background(), "syncer."+string(ev.Type))
+		if span.IsRecording() {
+			span.SetAttributes(attribute.String("object.type", fmt.Sprintf("%T", ev.Obj)))
+		}
+		if ev.Type == EventDeleted {
+			s.deleteObject(ev.Obj)
+		} else {
+			s.syncObject(ev.Type, ev.Obj)
+		}
+		span.End()
+	}
+}
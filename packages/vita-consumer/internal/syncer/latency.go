@@ -0,0 +1,40 @@
+package syncer
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodLatencies derives from a pod's status how long it waited for a node
+// after it was created (scheduling) and then to become ready (startup).
+// Either is nil until reached. Once a container restarted the Ready
+// condition marks the latest start rather than the first, so startup is
+// only derived for pods without restarts.
+func PodLatencies(pod *corev1.Pod) (scheduling, startup *time.Duration) {
+	created := pod.CreationTimestamp.Time
+	var scheduled, ready time.Time
+	for _, c := range pod.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case corev1.PodScheduled:
+			scheduled = c.LastTransitionTime.Time
+		case corev1.PodReady:
+			ready = c.LastTransitionTime.Time
+		}
+	}
+	if created.IsZero() || scheduled.IsZero() {
+		return nil, nil
+	}
+	// Condition times have second precision and may trail creation
+	d := max(scheduled.Sub(created), 0)
+	scheduling = &d
+
+	if ready.IsZero() || podContainerState(pod).Restarts > 0 {
+		return scheduling, nil
+	}
+	r := max(ready.Sub(scheduled), 0)
+	return scheduling, &r
+}
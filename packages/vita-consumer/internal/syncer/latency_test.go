@@ -0,0 +1,100 @@
+package syncer_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/syncer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/workload"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Scheduling and startup latency are derived from pod conditions once per
+// pod, skipped for restarted pods, and summarized per namespace
+func TestPodLatency(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		events, stop := env.Syncer.Changes().Subscribe("pod_latency", 1024, false)
+		defer stop()
+		go workload.NewLatencyRecorder(env.SQLite, env.Duck).Run(events)
+
+		created := env.Clock.Now().Add(-time.Hour).Truncate(time.Second)
+		pod := func(name string, scheduledAfter, readyAfter time.Duration, restarts int32) *corev1.Pod {
+			p := synctest.Pod("latency", name, "node-a", nil)
+			p.CreationTimestamp = metav1.NewTime(created)
+			scheduled := created.Add(scheduledAfter)
+			p.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(scheduled)}}
+			if readyAfter > 0 {
+				p.Status.Conditions = append(p.Status.Conditions, corev1.PodCondition{Type: corev1.PodReady, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(scheduled.Add(readyAfter))})
+			}
+			p.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "app", RestartCount: restarts}}
+			return p
+		}
+		pods := env.Client.CoreV1().Pods("latency")
+		for _, p := range []*corev1.Pod{
+			pod("fast", 2*time.Second, 8*time.Second, 0),
+			pod("slow", 4*time.Second, 0, 0),
+			pod("crashy", 6*time.Second, 90*time.Second, 3),
+		} {
+			if _, err := pods.Create(ctx, p, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		// slow becomes ready later; fast is updated without changing
+		for name, readyAfter := range map[string]time.Duration{"slow": 30 * time.Second, "fast": 8 * time.Second} {
+			p, err := pods.Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			p.Status = pod(name, p.Status.Conditions[0].LastTransitionTime.Sub(created), readyAfter, 0).Status
+			if _, err := pods.UpdateStatus(ctx, p, metav1.UpdateOptions{}); err != nil {
+				return err
+			}
+		}
+
+		if err := env.Eventually(synctest.Timeout, "latencies recorded", func() (bool, error) {
+			n, err := env.QueryInt(`SELECT count(*) FROM pods p JOIN namespaces ns ON ns.id = p.namespace_id
+			WHERE ns.name = 'latency' AND scheduling_ms IS NOT NULL AND (startup_ms IS NOT NULL OR p.name = 'crashy')`)
+			return n == 3, err
+		}); err != nil {
+			return err
+		}
+		// A restarted recorder finds them recorded already
+		restarted := make(chan syncer.Event, 1)
+		fast, err := pods.Get(ctx, "fast", metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		fastID, err := env.QueryInt("SELECT p.id FROM pods p JOIN namespaces ns ON ns.id = p.namespace_id WHERE ns.name = 'latency' AND p.name = 'fast'")
+		if err != nil {
+			return err
+		}
+		restarted <- syncer.Event{Type: syncer.EventUpdated, Kind: "pod", ID: fastID, Obj: fast}
+		close(restarted)
+		workload.NewLatencyRecorder(env.SQLite, env.Duck).Run(restarted)
+
+		var resp api.PodLatencyResponse
+		if err := env.GetJSON("/api/v1/analysis/pod-latency?namespace=latency", &resp); err != nil {
+			return err
+		}
+		if len(resp.Namespaces) != 1 || resp.Namespaces[0].Namespace != "latency" {
+			return fmt.Errorf("namespaces = %+v, want latency", resp.Namespaces)
+		}
+		want := api.NamespaceLatency{
+			Namespace:  "latency",
+			Scheduling: api.LatencyStats{Count: 3, P50: 4000, P90: 6000, P99: 6000, Max: 6000},
+			Startup:    api.LatencyStats{Count: 2, P50: 8000, P90: 30000, P99: 30000, Max: 30000},
+		}
+		if resp.Namespaces[0] != want {
+			return fmt.Errorf("latency = %+v, want %+v", resp.Namespaces[0], want)
+		}
+		if resp.Cluster.Scheduling.Count != 3 || resp.Cluster.Startup.Count != 2 {
+			return fmt.Errorf("cluster latency = %+v", resp.Cluster)
+		}
+		return nil
+	})
+}
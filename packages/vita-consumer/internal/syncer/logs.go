@@ -0,0 +1,18 @@
+package syncer
+
+import (
+	"context"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodLogs streams a container log through the API server with the
+// syncer's credentials
+func (s *ResourceSyncer) PodLogs(ctx context.Context, namespace, name string, opts *corev1.PodLogOptions) (io.ReadCloser, error) {
+	client := s.kube()
+	if client == nil {
+		return nil, errNotConnected
+	}
+	return client.CoreV1().Pods(namespace).GetLogs(name, opts).Stream(ctx)
+}
@@ -0,0 +1,139 @@
+package syncer
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// volumeReasons are the warning event reasons of volumes that fail to
+// attach or mount
+var volumeReasons = map[string]bool{
+	"FailedMount":        true,
+	"FailedAttachVolume": true,
+	"FailedMapVolume":    true,
+}
+
+var (
+	// `MountVolume.SetUp failed for volume "data" : ...`, where the volume
+	// is the pod's volume or, for attach errors, the PV
+	forVolumeRe = regexp.MustCompile(`for volume "([^"]+)"`)
+	// `Unable to attach or mount volumes: unmounted volumes=[data cache], ...`
+	unmountedRe = regexp.MustCompile(`unmounted volumes=\[([^\]]*)\]`)
+)
+
+// startVolumeEventInformer watches warning events and publishes the
+// volume ones on the object bus. Its own factory keeps the field selector
+// from applying to other informers.
+func (s *ResourceSyncer) startVolumeEventInformer(ctx context.Context) {
+	factory := informers.NewSharedInformerFactoryWithOptions(s.client, s.resync,
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) { o.FieldSelector = "type=" + corev1.EventTypeWarning }))
+
+	publish := func(t EventType, obj interface{}) {
+		if ev, ok := obj.(*corev1.Event); ok && ev.Type == corev1.EventTypeWarning && volumeReasons[ev.Reason] && ev.InvolvedObject.Kind == "Pod" {
+			s.objects.Publish(ObjectEvent{Type: t, Obj: ev})
+		}
+	}
+	factory.Core().V1().Events().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { publish(EventAdded, obj) },
+		UpdateFunc: func(old, new interface{}) { publish(EventUpdated, new) },
+	})
+
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+}
+
+// syncVolumeEvent records a volume warning against the pod and, when the
+// message names one, the claim
+func (s *ResourceSyncer) syncVolumeEvent(ev *corev1.Event) {
+	ref := ev.InvolvedObject
+	nsID := s.getNamespaceID(ref.Namespace)
+	if nsID == 0 {
+		return
+	}
+
+	f := store.VolumeFailure{
+		EventUID:    string(ev.UID),
+		NamespaceID: nsID,
+		PodName:     ref.Name,
+		Reason:      ev.Reason,
+		Message:     ev.Message,
+		Count:       max(ev.Count, 1),
+		FirstSeen:   ev.FirstTimestamp.Time,
+		LastSeen:    eventLastSeen(ev),
+	}
+	if ev.Series != nil {
+		f.Count = max(ev.Series.Count, f.Count)
+	}
+	if f.FirstSeen.IsZero() {
+		f.FirstSeen = f.LastSeen
+	}
+	if id, ok := s.pods.Get(string(ref.UID)); ok {
+		f.PodID = &id
+	}
+
+	var claim string
+	f.Volume, claim = s.failedVolume(ref.Namespace, ref.Name, ev.Message)
+	if claim != "" {
+		if id, err := s.sqlite.PVCIDByName(nsID, claim); err == nil && id > 0 {
+			f.PVCID = &id
+		}
+	}
+
+	if err := s.sqlite.UpsertVolumeFailure(f); err != nil {
+		log.Printf("Failed to record %s for pod %s: %v", ev.Reason, ref.Name, err)
+	}
+}
+
+// eventLastSeen is when an event last happened, whichever API shape set it
+func eventLastSeen(ev *corev1.Event) time.Time {
+	switch {
+	case ev.Series != nil && !ev.Series.LastObservedTime.IsZero():
+		return ev.Series.LastObservedTime.Time
+	case !ev.LastTimestamp.IsZero():
+		return ev.LastTimestamp.Time
+	case !ev.EventTime.IsZero():
+		return ev.EventTime.Time
+	}
+	return time.Now()
+}
+
+// failedVolume finds the volume a message is about and the claim behind
+// it, matching the pod's volumes first and then bound PVs
+func (s *ResourceSyncer) failedVolume(namespace, podName, message string) (volume, claim string) {
+	var names []string
+	if m := forVolumeRe.FindStringSubmatch(message); m != nil {
+		names = append(names, m[1])
+	}
+	if m := unmountedRe.FindStringSubmatch(message); m != nil {
+		names = append(names, strings.Fields(m[1])...)
+	}
+	if len(names) == 0 {
+		return "", ""
+	}
+
+	if pod, err := s.factory.Core().V1().Pods().Lister().Pods(namespace).Get(podName); err == nil {
+		for _, name := range names {
+			for _, v := range pod.Spec.Volumes {
+				if v.Name == name && v.PersistentVolumeClaim != nil {
+					return name, v.PersistentVolumeClaim.ClaimName
+				}
+			}
+		}
+	}
+	for _, name := range names {
+		pv, err := s.factory.Core().V1().PersistentVolumes().Lister().Get(name)
+		if err == nil && pv.Spec.ClaimRef != nil && pv.Spec.ClaimRef.Namespace == namespace {
+			return name, pv.Spec.ClaimRef.Name
+		}
+	}
+	return names[0], ""
+}
@@ -0,0 +1,120 @@
+package syncer_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestMountFailures verifies volume warning events are attributed to the
+// claim they concern, and stop counting once the pod is ready
+func TestMountFailures(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		data := synctest.BoundPVC("db", "data", "fast", "10Gi", "pv-data")
+		logs := synctest.BoundPVC("db", "logs", "fast", "10Gi", "pv-logs")
+		for _, pvc := range []*corev1.PersistentVolumeClaim{data, logs} {
+			if _, err := env.Client.CoreV1().PersistentVolumeClaims("db").Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		pv := synctest.PersistentVolume("pv-logs", "fast", "10Gi", "ebs.csi.aws.com", logs)
+		if _, err := env.Client.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		pod := synctest.Pod("db", "pg-0", "node-a", nil)
+		pod.Spec.Volumes = []corev1.Volume{
+			{Name: "pgdata", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+			{Name: "pglogs", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "logs"}}},
+		}
+		if _, err := env.Client.CoreV1().Pods("db").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pod, claims and volume synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT (SELECT COUNT(*) FROM pods WHERE name = 'pg-0') + (SELECT COUNT(*) FROM pvcs) + (SELECT COUNT(*) FROM persistent_volumes)")
+			return n == 4, err
+		}); err != nil {
+			return err
+		}
+
+		// One failure names the pod's volume, the other the PV
+		now := env.Clock.Now()
+		for _, ev := range []*corev1.Event{
+			synctest.WarningEvent(pod, "FailedMount", `MountVolume.SetUp failed for volume "pgdata" : mount failed: exit status 32`, now),
+			synctest.WarningEvent(pod, "FailedAttachVolume", `AttachVolume.Attach failed for volume "pv-logs" : rpc error: code = Internal`, now),
+			synctest.WarningEvent(pod, "BackOff", "Back-off restarting failed container", now),
+		} {
+			if _, err := env.Client.CoreV1().Events("db").Create(ctx, ev, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+
+		var failures []api.VolumeFailure
+		if err := env.Eventually(synctest.Timeout, "failing mounts listed", func() (bool, error) {
+			failures = nil
+			if err := env.GetJSON("/api/v1/storage/failures", &failures); err != nil {
+				return false, err
+			}
+			return len(failures) == 2, nil
+		}); err != nil {
+			return err
+		}
+		claims := map[string]string{}
+		for _, f := range failures {
+			if f.PVC == nil || f.PodID == nil || f.Pod != "pg-0" {
+				return fmt.Errorf("failure not attributed: %+v", f)
+			}
+			claims[f.Reason] = *f.PVC
+		}
+		if claims["FailedMount"] != "data" || claims["FailedAttachVolume"] != "logs" {
+			return fmt.Errorf("failures by reason = %v, want FailedMount on data and FailedAttachVolume on logs", claims)
+		}
+
+		var pvcs []api.PVC
+		if err := env.GetJSON("/api/v1/pvcs", &pvcs); err != nil {
+			return err
+		}
+		for _, p := range pvcs {
+			if p.Health != "failing" || p.MountError == nil {
+				return fmt.Errorf("pvc %s health = %s", p.Name, p.Health)
+			}
+		}
+
+		// Once the pod is ready its mounts are no longer failing
+		pod.Status.Phase = corev1.PodRunning
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+		if _, err := env.Client.CoreV1().Pods("db").UpdateStatus(ctx, pod, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "failures resolved", func() (bool, error) {
+			failures = nil
+			if err := env.GetJSON("/api/v1/storage/failures", &failures); err != nil {
+				return false, err
+			}
+			return len(failures) == 0, nil
+		}); err != nil {
+			return err
+		}
+		pvcs = nil
+		if err := env.GetJSON("/api/v1/pvcs", &pvcs); err != nil {
+			return err
+		}
+		for _, p := range pvcs {
+			if p.Health != "healthy" {
+				return fmt.Errorf("pvc %s health after recovery = %s", p.Name, p.Health)
+			}
+		}
+		failures = nil
+		if err := env.GetJSON("/api/v1/storage/failures?all=true", &failures); err != nil {
+			return err
+		}
+		if len(failures) != 2 || failures[0].ResolvedAt == nil {
+			return fmt.Errorf("all failures = %+v", failures)
+		}
+		return nil
+	})
+}
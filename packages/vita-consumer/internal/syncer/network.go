@@ -0,0 +1,70 @@
+package syncer
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func (s *ResourceSyncer) syncService(svc *corev1.Service) int64 {
+	nsID := s.getNamespaceID(svc.Namespace)
+
+	var depID, stsID, dsID *int64
+	if len(svc.Spec.Selector) > 0 {
+		depID, stsID, dsID = s.workloadsSelectedBy(svc.Namespace, labels.SelectorFromSet(svc.Spec.Selector))
+	}
+
+	id, err := s.sqlite.UpsertService(string(svc.UID), svc.Name, nsID, depID, stsID, dsID)
+	if err != nil {
+		log.Printf("Failed to sync service %s: %v", svc.Name, err)
+		return 0
+	}
+	return id
+}
+
+func (s *ResourceSyncer) syncIngress(ing *networkingv1.Ingress) int64 {
+	nsID := s.getNamespaceID(ing.Namespace)
+
+	var rules []store.IngressRule
+	if b := ing.Spec.DefaultBackend; b != nil && b.Service != nil {
+		rules = append(rules, ingressRule("*", "/", b.Service))
+	}
+	for _, r := range ing.Spec.Rules {
+		host := r.Host
+		if host == "" {
+			host = "*"
+		}
+		if r.HTTP == nil {
+			continue
+		}
+		for _, p := range r.HTTP.Paths {
+			if p.Backend.Service == nil {
+				continue
+			}
+			path := p.Path
+			if path == "" {
+				path = "/"
+			}
+			rules = append(rules, ingressRule(host, path, p.Backend.Service))
+		}
+	}
+
+	id, err := s.sqlite.UpsertIngress(string(ing.UID), ing.Name, nsID, ing.Spec.IngressClassName, rules)
+	if err != nil {
+		log.Printf("Failed to sync ingress %s: %v", ing.Name, err)
+		return 0
+	}
+	return id
+}
+
+func ingressRule(host, path string, svc *networkingv1.IngressServiceBackend) store.IngressRule {
+	port := svc.Port.Name
+	if port == "" && svc.Port.Number != 0 {
+		port = strconv.Itoa(int(svc.Port.Number))
+	}
+	return store.IngressRule{Host: host, Path: path, ServiceName: svc.Name, ServicePort: port}
+}
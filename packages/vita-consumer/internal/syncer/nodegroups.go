@@ -0,0 +1,68 @@
+package syncer
+
+import (
+	"strings"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultNodepoolLabels are the node labels naming a node's pool, first
+// present wins, unless SetNodepoolLabels says otherwise: Karpenter, GKE,
+// EKS managed node groups, AKS and DigitalOcean
+var DefaultNodepoolLabels = []string{
+	"karpenter.sh/nodepool",
+	"karpenter.sh/provisioner-name",
+	"cloud.google.com/gke-nodepool",
+	"eks.amazonaws.com/nodegroup",
+	"kubernetes.azure.com/agentpool",
+	"agentpool",
+	"doks.digitalocean.com/node-pool",
+}
+
+// SetNodepoolLabels sets the node labels read as the node pool, in order
+// of precedence. Must be called before Start.
+func (s *ResourceSyncer) SetNodepoolLabels(keys []string) {
+	s.nodepoolLabels = keys
+}
+
+// nodeGroup reads a node's zone, region, pool and capacity type from its
+// labels
+func (s *ResourceSyncer) nodeGroup(n *corev1.Node) store.NodeGroup {
+	l := n.Labels
+	return store.NodeGroup{
+		Zone:         firstLabel(l, corev1.LabelTopologyZone, corev1.LabelFailureDomainBetaZone),
+		Region:       firstLabel(l, corev1.LabelTopologyRegion, corev1.LabelFailureDomainBetaRegion),
+		Nodepool:     firstLabel(l, s.nodepoolLabels...),
+		CapacityType: capacityType(l),
+	}
+}
+
+// capacityType normalizes the providers' spot labels to spot or
+// on-demand. GKE and AKS only label spot nodes, so their other nodes are
+// on-demand; elsewhere a node without a label is left unknown.
+func capacityType(l map[string]string) string {
+	switch v := strings.ToLower(firstLabel(l, "karpenter.sh/capacity-type", "eks.amazonaws.com/capacityType")); v {
+	case "spot":
+		return "spot"
+	case "on-demand", "on_demand":
+		return "on-demand"
+	}
+	if l["cloud.google.com/gke-spot"] == "true" || l["cloud.google.com/gke-preemptible"] == "true" ||
+		strings.EqualFold(l["kubernetes.azure.com/scalesetpriority"], "spot") {
+		return "spot"
+	}
+	if l["cloud.google.com/gke-nodepool"] != "" || l["kubernetes.azure.com/agentpool"] != "" {
+		return "on-demand"
+	}
+	return ""
+}
+
+func firstLabel(l map[string]string, keys ...string) string {
+	for _, k := range keys {
+		if v := l[k]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
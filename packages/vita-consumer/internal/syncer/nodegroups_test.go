@@ -0,0 +1,153 @@
+package syncer_test
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Zone, region, node pool and spot or on-demand capacity
+// are read from node labels, and nodes, ingest usage and capacity are
+// totalled per group, unlabelled nodes under "(none)"
+func TestNodeGroups(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		for _, n := range []struct {
+			name   string
+			labels map[string]string
+		}{
+			{"spot-a", map[string]string{"topology.kubernetes.io/zone": "eu-1a", "topology.kubernetes.io/region": "eu-1",
+				"karpenter.sh/nodepool": "general", "karpenter.sh/capacity-type": "spot"}},
+			{"ondemand-b", map[string]string{"topology.kubernetes.io/zone": "eu-1b", "topology.kubernetes.io/region": "eu-1",
+				"karpenter.sh/nodepool": "general", "karpenter.sh/capacity-type": "on-demand"}},
+			{"gke-b", map[string]string{"failure-domain.beta.kubernetes.io/zone": "eu-1b", "cloud.google.com/gke-nodepool": "batch"}},
+			{"bare", nil},
+		} {
+			node := synctest.Node(n.name, "4", "8Gi")
+			node.Labels = n.labels
+			if _, err := env.Client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		for _, node := range []string{"spot-a", "gke-b"} {
+			if _, err := env.Client.CoreV1().Pods("jobs").Create(ctx, synctest.Pod("jobs", "worker-"+node, node, nil), metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		if err := env.Eventually(synctest.Timeout, "nodes and pods synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT (SELECT count(*) FROM nodes WHERE capacity_type IS NOT NULL) + (SELECT count(*) FROM pods)")
+			return n == 6, err
+		}); err != nil {
+			return err
+		}
+
+		var nodes []api.Node
+		if err := env.GetJSON("/api/v1/nodes", &nodes); err != nil {
+			return err
+		}
+		groups := make(map[string]store.NodeGroup)
+		for _, n := range nodes {
+			groups[n.Name] = n.NodeGroup
+		}
+		if want := map[string]store.NodeGroup{
+			"spot-a":     {Zone: "eu-1a", Region: "eu-1", Nodepool: "general", CapacityType: "spot"},
+			"ondemand-b": {Zone: "eu-1b", Region: "eu-1", Nodepool: "general", CapacityType: "on-demand"},
+			"gke-b":      {Zone: "eu-1b", Nodepool: "batch", CapacityType: "on-demand"},
+			"bare":       {},
+		}; !maps.Equal(groups, want) {
+			return fmt.Errorf("node groups = %+v", groups)
+		}
+
+		summary := func(path string) (string, error) {
+			var resp api.NodeGroupsResponse
+			if err := env.GetJSON(path, &resp); err != nil {
+				return "", err
+			}
+			var parts []string
+			for _, g := range resp.Groups {
+				parts = append(parts, fmt.Sprintf("%s:%d/%d", g.Name, g.Nodes, g.Pods))
+			}
+			return strings.Join(parts, " "), nil
+		}
+		for path, want := range map[string]string{
+			"/api/v1/node-groups":                        "(none):1/0 batch:1/1 general:2/1",
+			"/api/v1/node-groups?group_by=zone":          "(none):1/0 eu-1a:1/1 eu-1b:2/1",
+			"/api/v1/node-groups?group_by=capacity_type": "(none):1/0 on-demand:2/1 spot:1/1",
+			"/api/v1/node-groups?group_by=region":        "(none):2/1 eu-1:2/1",
+		} {
+			got, err := summary(path)
+			if err != nil {
+				return err
+			}
+			if got != want {
+				return fmt.Errorf("%s = %q, want %q", path, got, want)
+			}
+		}
+		if err := env.GetJSON("/api/v1/node-groups?group_by=rack", new(api.NodeGroupsResponse)); err == nil {
+			return fmt.Errorf("unknown dimension accepted")
+		}
+
+		// Ingest usage per zone
+		for node, points := range map[string]int{"spot-a": 2, "ondemand-b": 3, "gke-b": 4} {
+			batch := make([]buffer.Metric, points)
+			for i := range batch {
+				batch[i] = buffer.Metric{Time: env.Clock.Now(), Type: "node_cpu_ms", Value: 1}
+			}
+			env.Usage.Admit(node, batch)
+		}
+		env.Usage.Flush()
+		var byZone api.UsageResponse
+		if err := env.GetJSON("/api/v1/usage?scope=node&group_by=zone", &byZone); err != nil {
+			return err
+		}
+		if len(byZone.Items) != 2 || byZone.Items[0].Name != "eu-1b" || byZone.Items[0].Points != 7 || byZone.Items[1].Points != 2 {
+			return fmt.Errorf("usage by zone = %+v", byZone.Items)
+		}
+
+		// Capacity and memory use per capacity type
+		podOn := func(node string) (int64, error) {
+			return env.QueryInt("SELECT p.id FROM pods p JOIN nodes n ON p.node_id = n.id WHERE n.name = ?", node)
+		}
+		spotPod, err := podOn("spot-a")
+		if err != nil {
+			return err
+		}
+		batchPod, err := podOn("gke-b")
+		if err != nil {
+			return err
+		}
+		at := env.Clock.Now().Add(-30 * time.Minute)
+		if err := env.Duck.BatchInsert([]store.MetricPoint{
+			{Time: at, ResourceID: spotPod, MetricType: "mem_mb", Value: 1024},
+			{Time: at, ResourceID: batchPod, MetricType: "mem_mb", Value: 2048},
+		}); err != nil {
+			return err
+		}
+		var capacity api.CapacityResponse
+		if err := env.GetJSON("/api/v1/analysis/capacity?days=1&group_by=capacity_type", &capacity); err != nil {
+			return err
+		}
+		var memory api.CapacityResource
+		for _, res := range capacity.Resources {
+			if res.Resource == "memory" {
+				memory = res
+			}
+		}
+		got := make(map[string]string)
+		for _, g := range memory.Groups {
+			got[g.Name] = fmt.Sprintf("%.0f/%.0f", g.Current, g.Capacity)
+		}
+		if want := map[string]string{"spot": "1024/8192", "on-demand": "2048/16384", "(none)": "0/8192"}; !maps.Equal(got, want) {
+			return fmt.Errorf("memory by capacity type = %v, want %v", got, want)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,39 @@
+package syncer
+
+import (
+	"log"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultOwnershipKeys are the annotations captured as ownership unless
+// SetOwnershipKeys says otherwise
+var DefaultOwnershipKeys = []string{"team", "owner", "cost-center"}
+
+// SetOwnershipKeys sets the annotations of namespaces and workloads that
+// are recorded as ownership, e.g. "team" or "example.com/cost-center".
+// Must be called before Start.
+func (s *ResourceSyncer) SetOwnershipKeys(keys []string) {
+	s.ownershipKeys = keys
+}
+
+// recordOwnership stores the configured annotations of a namespace or
+// workload of kind
+func (s *ResourceSyncer) recordOwnership(kind string, id int64, name string, annotations map[string]string) {
+	values := make(map[string]string)
+	for _, key := range s.ownershipKeys {
+		if v := annotations[key]; v != "" {
+			values[key] = v
+		}
+	}
+	if err := s.sqlite.SetOwnership(kind, id, values); err != nil {
+		log.Printf("Failed to record ownership of %s %s: %v", kind, name, err)
+	}
+}
+
+func (s *ResourceSyncer) syncNamespace(ns *corev1.Namespace) {
+	if id := s.getNamespaceID(ns.Name); id != 0 {
+		s.recordOwnership("namespace", id, ns.Name, ns.Annotations)
+		s.recordRetention("namespace", id, ns.Name, ns.Annotations)
+	}
+}
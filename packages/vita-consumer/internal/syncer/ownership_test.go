@@ -0,0 +1,123 @@
+package syncer_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/report"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Team and cost-center annotations of a namespace and a
+// statefulset scope and group report summaries and ingest usage, the
+// statefulset's team overriding the namespace's
+func TestOwnership(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ledger", Annotations: map[string]string{
+			"team": "finance", "cost-center": "cc-7", "unrelated": "ignored",
+		}}}
+		if _, err := env.Client.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		sts := synctest.StatefulSet("ledger", "books", 1)
+		sts.Annotations = map[string]string{"team": "bookkeeping"}
+		if _, err := env.Client.AppsV1().StatefulSets("ledger").Create(ctx, sts, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		// Pods link to statefulsets already in the catalog
+		if err := env.Eventually(synctest.Timeout, "statefulset synced", func() (bool, error) {
+			n, err := env.QueryInt(`SELECT count(*) FROM statefulsets WHERE name = 'books' AND ownership = '{"team":"bookkeeping"}'`)
+			return n == 1, err
+		}); err != nil {
+			return err
+		}
+		for _, pod := range []*corev1.Pod{
+			synctest.StatefulPod(sts, 1, "node-a"),
+			synctest.Pod("ledger", "cron-1", "node-a", nil),
+			synctest.Pod("drifters", "stray-1", "node-a", nil),
+		} {
+			if _, err := env.Client.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		if err := env.Eventually(synctest.Timeout, "ownership recorded", func() (bool, error) {
+			n, err := env.QueryInt(`SELECT count(*) FROM pods p
+			JOIN namespaces ns ON ns.id = p.namespace_id
+			WHERE (ns.name = 'ledger' AND ns.ownership = '{"cost-center":"cc-7","team":"finance"}'
+				AND (p.name = 'cron-1' OR p.statefulset_id IS NOT NULL))
+				OR (ns.name = 'drifters' AND p.name = 'stray-1')`)
+			return n == 3, err
+		}); err != nil {
+			return err
+		}
+
+		// 300m, 200m and 100m of CPU in one hour two hours ago
+		bucket := env.Clock.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+		var points []store.MetricPoint
+		for name, millicores := range map[string]float64{"books-1": 300, "cron-1": 200, "stray-1": 100} {
+			id, err := env.QueryInt("SELECT id FROM pods WHERE name = ?", name)
+			if err != nil {
+				return err
+			}
+			points = append(points,
+				store.MetricPoint{Time: bucket.Add(time.Minute), ResourceID: id, MetricType: "cpu_ms", Value: 1e6},
+				store.MetricPoint{Time: bucket.Add(3 * time.Minute), ResourceID: id, MetricType: "cpu_ms", Value: 1e6 + millicores*120},
+			)
+		}
+		if err := env.Duck.BatchInsert(points); err != nil {
+			return err
+		}
+
+		now := env.Clock.Now()
+		build := func(scope report.Scope) (*report.Summary, error) {
+			return report.Build(env.SQLite, env.Duck, "ownership", now.Add(-24*time.Hour), now, now, scope)
+		}
+		sum, err := build(report.Scope{Owners: store.OwnerFilter{"team": "finance"}})
+		if err != nil {
+			return err
+		}
+		if len(sum.TopCPU) != 1 || sum.TopCPU[0].Pod != "cron-1" || sum.Owners != "team:finance" {
+			return fmt.Errorf("finance top CPU = %+v", sum.TopCPU)
+		}
+		// The deployment inherits the namespace's cost center
+		sum, err = build(report.Scope{Owners: store.OwnerFilter{"cost-center": "cc-7"}, GroupBy: "team"})
+		if err != nil {
+			return err
+		}
+		if len(sum.TopCPU) != 2 || sum.TopCPU[0].Pod != "books-1" || len(sum.Namespaces) != 1 || sum.Namespaces[0].Namespace != "ledger" {
+			return fmt.Errorf("cc-7 summary = %+v, %+v", sum.TopCPU, sum.Namespaces)
+		}
+		want := []report.OwnerUsage{{Owner: "bookkeeping", Pods: 1, CPUMillicores: 300}, {Owner: "finance", Pods: 1, CPUMillicores: 200}}
+		if len(sum.Groups) != 2 || sum.Groups[0] != want[0] || sum.Groups[1] != want[1] {
+			return fmt.Errorf("cc-7 groups = %+v, want %+v", sum.Groups, want)
+		}
+		if _, err := store.ParseOwnerFilter("team"); err == nil {
+			return fmt.Errorf("owner filter without a value accepted")
+		}
+
+		hour := env.Clock.Now().Truncate(time.Hour)
+		if err := env.SQLite.AddIngestUsage([]store.IngestUsage{
+			{Hour: hour, Scope: "namespace", Name: "ledger", Points: 100},
+			{Hour: hour, Scope: "namespace", Name: "drifters", Points: 50},
+		}); err != nil {
+			return err
+		}
+		var usage api.UsageResponse
+		if err := env.GetJSON("/api/v1/usage?group_by=team&owners=cost-center:cc-7", &usage); err != nil {
+			return err
+		}
+		if len(usage.Items) != 1 || usage.Items[0].Name != "finance" || usage.Items[0].Points != 100 || usage.Points != 100 {
+			return fmt.Errorf("usage by team = %+v", usage)
+		}
+		if err := env.GetJSON("/api/v1/usage?scope=node&group_by=team", &usage); err == nil {
+			return fmt.Errorf("grouped node usage accepted")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,116 @@
+package syncer
+
+import (
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// podPlacement extracts a pod's topology spread constraints and pod
+// (anti-)affinity terms. Constraints without a selector match no pods and
+// are left out, as are selectors the API server would have refused.
+func podPlacement(pod *corev1.Pod) store.PlacementRules {
+	var rules store.PlacementRules
+	for _, c := range pod.Spec.TopologySpreadConstraints {
+		selector, ok := placementSelector(c.LabelSelector, c.MatchLabelKeys, pod.Labels)
+		if !ok {
+			continue
+		}
+		sc := store.SpreadConstraint{
+			TopologyKey:       c.TopologyKey,
+			MaxSkew:           c.MaxSkew,
+			WhenUnsatisfiable: string(c.WhenUnsatisfiable),
+			Selector:          selector,
+		}
+		if c.MinDomains != nil {
+			sc.MinDomains = *c.MinDomains
+		}
+		rules.Spread = append(rules.Spread, sc)
+	}
+
+	affinity := pod.Spec.Affinity
+	if affinity == nil {
+		return rules
+	}
+	add := func(anti, required bool, weight int32, term corev1.PodAffinityTerm) {
+		selector, ok := placementSelector(term.LabelSelector, term.MatchLabelKeys, pod.Labels)
+		if !ok {
+			return
+		}
+		rules.Affinity = append(rules.Affinity, store.AffinityTerm{
+			Anti:        anti,
+			Required:    required,
+			Weight:      weight,
+			TopologyKey: term.TopologyKey,
+			Namespaces:  term.Namespaces,
+			Selector:    selector,
+		})
+	}
+	for _, a := range []struct {
+		anti      bool
+		required  []corev1.PodAffinityTerm
+		preferred []corev1.WeightedPodAffinityTerm
+	}{
+		{false, podAffinityRequired(affinity.PodAffinity), podAffinityPreferred(affinity.PodAffinity)},
+		{true, podAntiAffinityRequired(affinity.PodAntiAffinity), podAntiAffinityPreferred(affinity.PodAntiAffinity)},
+	} {
+		for _, term := range a.required {
+			add(a.anti, true, 0, term)
+		}
+		for _, term := range a.preferred {
+			add(a.anti, false, term.Weight, term.PodAffinityTerm)
+		}
+	}
+	return rules
+}
+
+// placementSelector renders a selector with the pod's values of
+// matchLabelKeys added
+func placementSelector(ls *metav1.LabelSelector, matchKeys []string, podLabels map[string]string) (string, bool) {
+	if ls == nil {
+		return "", false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(ls)
+	if err != nil {
+		return "", false
+	}
+	for _, key := range matchKeys {
+		if v, ok := podLabels[key]; ok {
+			req, err := labels.NewRequirement(key, "=", []string{v})
+			if err != nil {
+				return "", false
+			}
+			selector = selector.Add(*req)
+		}
+	}
+	return selector.String(), true
+}
+
+func podAffinityRequired(a *corev1.PodAffinity) []corev1.PodAffinityTerm {
+	if a == nil {
+		return nil
+	}
+	return a.RequiredDuringSchedulingIgnoredDuringExecution
+}
+
+func podAffinityPreferred(a *corev1.PodAffinity) []corev1.WeightedPodAffinityTerm {
+	if a == nil {
+		return nil
+	}
+	return a.PreferredDuringSchedulingIgnoredDuringExecution
+}
+
+func podAntiAffinityRequired(a *corev1.PodAntiAffinity) []corev1.PodAffinityTerm {
+	if a == nil {
+		return nil
+	}
+	return a.RequiredDuringSchedulingIgnoredDuringExecution
+}
+
+func podAntiAffinityPreferred(a *corev1.PodAntiAffinity) []corev1.WeightedPodAffinityTerm {
+	if a == nil {
+		return nil
+	}
+	return a.PreferredDuringSchedulingIgnoredDuringExecution
+}
@@ -0,0 +1,143 @@
+package syncer_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Spread constraints and (anti-)affinity terms are captured from pod specs
+// and checked against where the pods run: replicas sharing a node against
+// required anti-affinity are violated, a skewed best-effort spread and
+// replicas in one zone of two are at risk, and moving a pod away clears
+// the violation
+func TestPlacement(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		for name, zone := range map[string]string{"node-a": "zone-1", "node-b": "zone-1", "node-c": "zone-2"} {
+			node := synctest.Node(name, "4", "16Gi")
+			node.Labels = map[string]string{"kubernetes.io/hostname": name, "topology.kubernetes.io/zone": zone}
+			if _, err := env.Client.CoreV1().Nodes().Create(ctx, node, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		selector := func(app string) *metav1.LabelSelector {
+			return &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}
+		}
+		cache := synctest.StatefulSet("shop", "cache", 2)
+		db := synctest.StatefulSet("shop", "db", 3)
+		queue := synctest.StatefulSet("shop", "queue", 2)
+		for _, sts := range []*appsv1.StatefulSet{cache, db, queue} {
+			if _, err := env.Client.AppsV1().StatefulSets("shop").Create(ctx, sts, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		if err := env.Eventually(synctest.Timeout, "statefulsets synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT count(*) FROM statefulsets")
+			return n == 3, err
+		}); err != nil {
+			return err
+		}
+
+		pod := func(sts *appsv1.StatefulSet, ordinal int, node string, spec func(*corev1.PodSpec)) *corev1.Pod {
+			p := synctest.StatefulPod(sts, ordinal, node)
+			spec(&p.Spec)
+			return p
+		}
+		cacheSpec := func(spec *corev1.PodSpec) {
+			spec.Affinity = &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+					LabelSelector: selector("cache"), TopologyKey: "kubernetes.io/hostname",
+				}},
+			}}
+		}
+		dbSpec := func(spec *corev1.PodSpec) {
+			spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{
+				{MaxSkew: 1, TopologyKey: "topology.kubernetes.io/zone", WhenUnsatisfiable: corev1.DoNotSchedule, LabelSelector: selector("db")},
+				{MaxSkew: 1, TopologyKey: "kubernetes.io/hostname", WhenUnsatisfiable: corev1.DoNotSchedule, LabelSelector: selector("db")},
+			}
+		}
+		queueSpec := func(spec *corev1.PodSpec) {
+			spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{
+				{MaxSkew: 1, TopologyKey: "topology.kubernetes.io/zone", WhenUnsatisfiable: corev1.ScheduleAnyway, LabelSelector: selector("queue")},
+			}
+			spec.Affinity = &corev1.Affinity{PodAffinity: &corev1.PodAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{
+					Weight:          50,
+					PodAffinityTerm: corev1.PodAffinityTerm{LabelSelector: selector("db"), TopologyKey: "kubernetes.io/hostname"},
+				}},
+			}}
+		}
+		strayCache := pod(cache, 1, "node-a", cacheSpec)
+		for _, p := range []*corev1.Pod{
+			pod(cache, 0, "node-a", cacheSpec), strayCache,
+			pod(db, 0, "node-a", dbSpec), pod(db, 1, "node-b", dbSpec), pod(db, 2, "node-c", dbSpec),
+			pod(queue, 0, "node-a", queueSpec), pod(queue, 1, "node-b", queueSpec),
+		} {
+			if _, err := env.Client.CoreV1().Pods("shop").Create(ctx, p, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		if err := env.Eventually(synctest.Timeout, "pods synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT count(*) FROM pods WHERE statefulset_id IS NOT NULL AND labels IS NOT NULL AND placement IS NOT NULL")
+			return n == 7, err
+		}); err != nil {
+			return err
+		}
+
+		var placements []api.WorkloadPlacement
+		if err := env.GetJSON("/api/v1/analysis/placement", &placements); err != nil {
+			return err
+		}
+		got := make(map[string]api.WorkloadPlacement)
+		var order []string
+		for _, wp := range placements {
+			got[wp.Name] = wp
+			order = append(order, wp.Name+" "+wp.Status)
+		}
+		if want := []string{"cache violated", "queue at_risk", "db ok"}; !slices.Equal(order, want) {
+			return fmt.Errorf("placement order = %v, want %v", order, want)
+		}
+		if c := got["cache"]; len(c.Constraints) != 1 || c.Constraints[0].Type != "anti_affinity" || c.Constraints[0].Status != "violated" ||
+			len(c.Issues) != 1 || c.Issues[0].Type != "single_node" || c.Nodes["node-a"] != 2 {
+			return fmt.Errorf("cache placement = %+v", c)
+		}
+		if q := got["queue"]; len(q.Constraints) != 2 || q.Constraints[0].Skew != 2 || q.Constraints[0].Status != "at_risk" ||
+			q.Constraints[0].Domains["zone-2"] != 0 || q.Constraints[1].Type != "affinity" || q.Constraints[1].Status != "ok" ||
+			len(q.Issues) != 1 || q.Issues[0].Type != "single_zone" {
+			return fmt.Errorf("queue placement = %+v", q)
+		}
+		if d := got["db"]; len(d.Constraints) != 2 || d.Constraints[0].Skew != 1 || d.Constraints[1].Skew != 0 ||
+			d.Zones["zone-1"] != 2 || d.Zones["zone-2"] != 1 || len(d.Issues) != 0 {
+			return fmt.Errorf("db placement = %+v", d)
+		}
+		if err := env.GetJSON("/api/v1/analysis/placement?status=violated", &placements); err != nil {
+			return err
+		}
+		if len(placements) != 1 || placements[0].Name != "cache" {
+			return fmt.Errorf("violated placements = %+v", placements)
+		}
+
+		// Rescheduled onto another node, the cache replicas no longer collide
+		if err := env.Client.CoreV1().Pods("shop").Delete(ctx, strayCache.Name, metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+		moved := pod(cache, 1, "node-c", cacheSpec)
+		if _, err := env.Client.CoreV1().Pods("shop").Create(ctx, moved, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		cacheID := got["cache"].ID
+		return env.Eventually(synctest.Timeout, "cache placement repaired", func() (bool, error) {
+			if err := env.GetJSON(fmt.Sprintf("/api/v1/analysis/placement?kind=statefulset&id=%d", cacheID), &placements); err != nil {
+				return false, err
+			}
+			return len(placements) == 1 && placements[0].Status == "ok" && placements[0].Nodes["node-c"] == 1, nil
+		})
+	})
+}
@@ -0,0 +1,145 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/resolve"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Resolution layers, cheapest first
+const (
+	LayerCache  = "cache"  // ids the informers and catalog preload put in memory
+	LayerSQLite = "sqlite" // rows of the catalog, deleted pods included
+	LayerAPI    = "api"    // lists the cluster for objects the informers have not delivered yet
+)
+
+// DefaultResolverLayers is every layer, in lookup order
+var DefaultResolverLayers = []string{LayerCache, LayerSQLite, LayerAPI}
+
+// ResolverConfig picks how ingest resolves UIDs. Small installs may drop
+// the API layer; large clusters may want a longer APIInterval, as each
+// lookup lists every pod or PVC.
+type ResolverConfig struct {
+	Layers      []string      // in lookup order; empty means DefaultResolverLayers
+	MissTTL     time.Duration // how long a UID no layer knows is not looked up again
+	APIInterval time.Duration // minimum time between API lists per type
+}
+
+// Resolver builds the composite resolver ingest uses
+func (s *ResourceSyncer) Resolver(cfg ResolverConfig) (*resolve.Composite, error) {
+	layers := cfg.Layers
+	if len(layers) == 0 {
+		layers = DefaultResolverLayers
+	}
+	r := resolve.New()
+	r.SetMissTTL(cfg.MissTTL)
+	seen := make(map[string]bool)
+	for _, name := range layers {
+		if seen[name] {
+			return nil, fmt.Errorf("resolver layer %q listed twice", name)
+		}
+		seen[name] = true
+		switch name {
+		case LayerCache:
+			r.AddLayer(name, cacheLayer{s})
+		case LayerSQLite:
+			r.AddLayer(name, storeLayer{s})
+		case LayerAPI:
+			r.AddLayer(name, &apiLayer{s: s, interval: cfg.APIInterval, last: make(map[string]time.Time)})
+		default:
+			return nil, fmt.Errorf("unknown resolver layer %q (want cache, sqlite or api)", name)
+		}
+	}
+	return r, nil
+}
+
+// cacheLayer reads the syncer's in-memory id caches
+type cacheLayer struct{ s *ResourceSyncer }
+
+func (l cacheLayer) Resolve(rType string, uids []string, ids []int64) error {
+	copy(ids, l.s.cacheFor(rType).GetMany(uids))
+	return nil
+}
+
+func (l cacheLayer) Fill(rType, uid string, id int64) {
+	l.s.cacheFor(rType).Set(uid, id)
+}
+
+// storeLayer finds rows the caches do not hold, e.g. pods deleted before
+// a restart whose late metrics still arrive
+type storeLayer struct{ s *ResourceSyncer }
+
+func (l storeLayer) Resolve(rType string, uids []string, ids []int64) error {
+	table := "pods"
+	if rType == "pvc" {
+		table = "pvcs"
+	}
+	found, err := l.s.sqlite.IDsByUID(table, uids)
+	if err != nil {
+		return err
+	}
+	for i, uid := range uids {
+		ids[i] = found[uid]
+	}
+	return nil
+}
+
+// apiLayer lists pods or PVCs from the API server (its watch cache) and
+// syncs those asked for, covering objects an agent reports before their
+// watch event arrives. Lists are spaced by interval.
+type apiLayer struct {
+	s        *ResourceSyncer
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time // rType -> last list
+}
+
+func (l *apiLayer) Resolve(rType string, uids []string, ids []int64) error {
+	if !l.s.started() {
+		return nil // not connected yet; nothing to ask
+	}
+	client := l.s.kube()
+	l.mu.Lock()
+	if time.Since(l.last[rType]) < l.interval {
+		l.mu.Unlock()
+		return nil
+	}
+	l.last[rType] = time.Now()
+	l.mu.Unlock()
+
+	want := make(map[string]int, len(uids))
+	for i, uid := range uids {
+		want[uid] = i
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	opts := metav1.ListOptions{ResourceVersion: "0"}
+
+	if rType == "pvc" {
+		list, err := client.CoreV1().PersistentVolumeClaims("").List(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for i := range list.Items {
+			if pos, ok := want[string(list.Items[i].UID)]; ok {
+				ids[pos] = l.s.syncPVC(&list.Items[i])
+			}
+		}
+		return nil
+	}
+	list, err := client.CoreV1().Pods("").List(ctx, opts)
+	if err != nil {
+		return err
+	}
+	for i := range list.Items {
+		if pos, ok := want[string(list.Items[i].UID)]; ok {
+			ids[pos] = l.s.syncPod(&list.Items[i])
+		}
+	}
+	return nil
+}
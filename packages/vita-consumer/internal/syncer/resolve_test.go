@@ -0,0 +1,70 @@
+package syncer_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/syncer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestIDResolution resolves a synced pod from the cache, a pod only in the
+// catalog (stored by an earlier run) from SQLite, and remembers a UID no
+// layer knows so it is not looked up again until the miss expires
+func TestIDResolution(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		pod := synctest.Pod("default", "live", "node-a", nil)
+		if _, err := env.Client.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "pod synced", func() (bool, error) {
+			_, ok := env.Syncer.GetResourceID(string(pod.UID), "pod")
+			return ok, nil
+		}); err != nil {
+			return err
+		}
+		nsID, err := env.SQLite.UpsertNamespace("default")
+		if err != nil {
+			return err
+		}
+		nodeID, err := env.SQLite.UpsertNode("node-a-uid", "node-a")
+		if err != nil {
+			return err
+		}
+		storedID, err := env.SQLite.UpsertPod("stored-uid", "stored", nsID, nodeID, nil, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+
+		ids := env.Resolver.GetResourceIDs("pod", []string{string(pod.UID), "stored-uid", "stored-uid", "unknown-uid", ""})
+		if ids[0] == 0 || ids[1] != storedID || ids[2] != storedID || ids[3] != 0 || ids[4] != 0 {
+			return fmt.Errorf("ids = %v, want [live %d %d 0 0]", ids, storedID, storedID)
+		}
+		if id, ok := env.Syncer.GetResourceID("stored-uid", "pod"); !ok || id != storedID {
+			return fmt.Errorf("SQLite hit not filled into the cache: %d, %v", id, ok)
+		}
+		env.Resolver.GetResourceIDs("pod", []string{"unknown-uid"})
+
+		st := env.Resolver.Stats()
+		if len(st.Layers) != 3 || st.Layers[0].Name != syncer.LayerCache || st.Layers[2].Name != syncer.LayerAPI {
+			return fmt.Errorf("layers = %+v", st.Layers)
+		}
+		if sqlite := st.Layers[1]; sqlite.Lookups != 2 || sqlite.Hits != 1 {
+			return fmt.Errorf("sqlite layer = %+v, want stored and unknown looked up once each", sqlite)
+		}
+		if st.Unresolved != 1 || st.SkippedMisses != 1 {
+			return fmt.Errorf("unresolved %d, skipped %d, want 1 and 1", st.Unresolved, st.SkippedMisses)
+		}
+
+		// Once the miss expires the UID is tried again
+		env.Clock.Advance(2 * time.Minute)
+		env.Resolver.GetResourceIDs("pod", []string{"unknown-uid"})
+		if st := env.Resolver.Stats(); st.Layers[1].Lookups != 3 || st.Unresolved != 2 {
+			return fmt.Errorf("after expiry: %+v", st)
+		}
+		return nil
+	})
+}
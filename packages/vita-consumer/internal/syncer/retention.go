@@ -0,0 +1,22 @@
+package syncer
+
+import (
+	"log"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/retention"
+)
+
+// recordRetention stores the retention policy annotation of a namespace or
+// workload. Invalid policies are logged and recorded as none.
+func (s *ResourceSyncer) recordRetention(kind string, id int64, name string, annotations map[string]string) {
+	spec := annotations[retention.Annotation]
+	if spec != "" {
+		if _, err := retention.ParsePolicy(spec, retention.Policy{}); err != nil {
+			log.Printf("Ignoring %s of %s %s: %v", retention.Annotation, kind, name, err)
+			spec = ""
+		}
+	}
+	if err := s.sqlite.SetRetention(kind, id, spec); err != nil {
+		log.Printf("Failed to record retention of %s %s: %v", kind, name, err)
+	}
+}
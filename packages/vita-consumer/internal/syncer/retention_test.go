@@ -0,0 +1,81 @@
+package syncer_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/syncer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestDeleteRetention verifies deleted pods keep their catalog row, so
+// stored metrics stay attributable, while metric-less kinds are dropped
+func TestDeleteRetention(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		pod := synctest.Pod("default", "standalone", "node-a", nil)
+		svc := synctest.Service("default", "standalone")
+		if _, err := env.Client.CoreV1().Pods("default").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		if _, err := env.Client.CoreV1().Services("default").Create(ctx, svc, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		count := func(table, uid string) (int64, error) {
+			return env.QueryInt("SELECT COUNT(*) FROM "+table+" WHERE uid = ?", uid)
+		}
+		if err := env.Eventually(synctest.Timeout, "pod and service synced", func() (bool, error) {
+			p, err := count("pods", string(pod.UID))
+			if err != nil {
+				return false, err
+			}
+			sv, err := count("services", string(svc.UID))
+			return p == 1 && sv == 1, err
+		}); err != nil {
+			return err
+		}
+
+		events, cancel := env.Syncer.Subscribe()
+		defer cancel()
+		if err := env.Client.CoreV1().Pods("default").Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+		if err := env.Client.CoreV1().Services("default").Delete(ctx, svc.Name, metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+		if err := env.Eventually(synctest.Timeout, "service removed", func() (bool, error) {
+			n, err := count("services", string(svc.UID))
+			return n == 0, err
+		}); err != nil {
+			return err
+		}
+		if err := waitEvent(events, syncer.EventDeleted, "pod", string(pod.UID)); err != nil {
+			return err
+		}
+		n, err := count("pods", string(pod.UID))
+		if err != nil {
+			return err
+		}
+		if n != 1 {
+			return fmt.Errorf("deleted pod row was removed")
+		}
+		return nil
+	})
+}
+
+// waitEvent blocks until the syncer has published the given change
+func waitEvent(events <-chan syncer.Event, typ syncer.EventType, kind, uid string) error {
+	deadline := time.After(synctest.Timeout)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == typ && ev.Kind == kind && ev.UID == uid {
+				return nil
+			}
+		case <-deadline:
+			return fmt.Errorf("no %s event for %s %s after %s", typ, kind, uid, synctest.Timeout)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package syncer
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// Where each workload kind exposes the revision it rolls out: the
+// deployment controller's revision annotation, the statefulset's update
+// revision and the daemonset controller's template generation annotation
+const (
+	deploymentRevisionAnnotation = "deployment.kubernetes.io/revision"
+	daemonSetRevisionAnnotation  = "deprecated.daemonset.template.generation"
+)
+
+// recordRollout stores a workload's revision and annotates a change of it
+// as a rollout. The first revision seen for a workload is not a rollout.
+func (s *ResourceSyncer) recordRollout(kind string, id, nsID int64, name, revision string) {
+	if revision == "" {
+		return
+	}
+	prev, err := s.sqlite.SetWorkloadRevision(kind, id, revision)
+	if err != nil {
+		log.Printf("Failed to record revision of %s %s: %v", kind, name, err)
+		return
+	}
+	if prev == "" || prev == revision {
+		return
+	}
+	if err := s.sqlite.InsertAnnotation(store.Annotation{
+		Time:        s.clock.Now(),
+		NamespaceID: &nsID,
+		Type:        "rollout",
+		Kind:        kind,
+		Name:        name,
+		Message:     fmt.Sprintf("Rolled out revision %s (was %s)", revision, prev),
+	}); err != nil {
+		log.Printf("Failed to record rollout annotation: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package syncer
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// defaultClassAnnotation marks the StorageClass claims get when they name none
+const defaultClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+
+func (s *ResourceSyncer) syncStorageClass(sc *storagev1.StorageClass) int64 {
+	row := store.StorageClass{
+		UID:            string(sc.UID),
+		Name:           sc.Name,
+		Provisioner:    sc.Provisioner,
+		AllowExpansion: sc.AllowVolumeExpansion != nil && *sc.AllowVolumeExpansion,
+		Default:        sc.Annotations[defaultClassAnnotation] == "true",
+	}
+	if sc.ReclaimPolicy != nil {
+		row.ReclaimPolicy = string(*sc.ReclaimPolicy)
+	}
+	if sc.VolumeBindingMode != nil {
+		row.BindingMode = string(*sc.VolumeBindingMode)
+	}
+
+	id, err := s.sqlite.UpsertStorageClass(row)
+	if err != nil {
+		log.Printf("Failed to sync storage class %s: %v", sc.Name, err)
+		return 0
+	}
+	return id
+}
+
+func (s *ResourceSyncer) syncPV(pv *corev1.PersistentVolume) int64 {
+	row := store.PersistentVolume{
+		UID:           string(pv.UID),
+		Name:          pv.Name,
+		StorageClass:  pv.Spec.StorageClassName,
+		ReclaimPolicy: string(pv.Spec.PersistentVolumeReclaimPolicy),
+		Phase:         string(pv.Status.Phase),
+		AccessModes:   accessModes(pv.Spec.AccessModes),
+		Driver:        volumeDriver(pv.Spec.PersistentVolumeSource),
+	}
+	if q, ok := pv.Spec.Capacity[corev1.ResourceStorage]; ok {
+		row.CapacityMB = quantityMB(q)
+	}
+	if ref := pv.Spec.ClaimRef; ref != nil {
+		row.ClaimNamespace, row.ClaimName = ref.Namespace, ref.Name
+	}
+
+	id, err := s.sqlite.UpsertPersistentVolume(row)
+	if err != nil {
+		log.Printf("Failed to sync persistent volume %s: %v", pv.Name, err)
+		return 0
+	}
+	return id
+}
+
+// pvcStorage reads a claim's class, request and bound capacity
+func pvcStorage(pvc *corev1.PersistentVolumeClaim) store.PVCStorage {
+	st := store.PVCStorage{
+		VolumeName: pvc.Spec.VolumeName,
+		Phase:      string(pvc.Status.Phase),
+	}
+	if pvc.Spec.StorageClassName != nil {
+		st.StorageClass = *pvc.Spec.StorageClassName
+	}
+	if q, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+		mb := quantityMB(q)
+		st.RequestedMB = &mb
+	}
+	if q, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+		mb := quantityMB(q)
+		st.CapacityMB = &mb
+	}
+	return st
+}
+
+// recordResize keeps a change of a claim's bound capacity, and marks it
+// on the timeline
+func (s *ResourceSyncer) recordResize(pvc *corev1.PersistentVolumeClaim, id, nsID int64, fromMB, toMB float64) {
+	now := s.clock.Now()
+	if err := s.sqlite.InsertPVCResize(id, store.PVCResize{Time: now, FromMB: fromMB, ToMB: toMB}); err != nil {
+		log.Printf("Failed to record resize of pvc %s: %v", pvc.Name, err)
+		return
+	}
+	if err := s.sqlite.InsertAnnotation(store.Annotation{
+		Time:        now,
+		NamespaceID: &nsID,
+		Type:        "pvc_resize",
+		Kind:        "pvc",
+		Name:        pvc.Name,
+		Message:     fmt.Sprintf("Resized from %.0f MiB to %.0f MiB", fromMB, toMB),
+	}); err != nil {
+		log.Printf("Failed to record resize annotation: %v", err)
+	}
+}
+
+// podClaims lists the PVCs a pod mounts
+func podClaims(pod *corev1.Pod) []string {
+	var claims []string
+	for _, v := range pod.Spec.Volumes {
+		if v.PersistentVolumeClaim != nil {
+			claims = append(claims, v.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	return claims
+}
+
+func quantityMB(q resource.Quantity) float64 {
+	return float64(q.Value()) / (1024 * 1024)
+}
+
+func accessModes(modes []corev1.PersistentVolumeAccessMode) string {
+	out := make([]string, len(modes))
+	for i, m := range modes {
+		out[i] = string(m)
+	}
+	return strings.Join(out, ",")
+}
+
+// volumeDriver names the backend of a volume: the CSI driver, or the
+// in-tree source type
+func volumeDriver(src corev1.PersistentVolumeSource) string {
+	switch {
+	case src.CSI != nil:
+		return src.CSI.Driver
+	case src.HostPath != nil:
+		return "hostPath"
+	case src.Local != nil:
+		return "local"
+	case src.NFS != nil:
+		return "nfs"
+	case src.AWSElasticBlockStore != nil:
+		return "awsElasticBlockStore"
+	case src.GCEPersistentDisk != nil:
+		return "gcePersistentDisk"
+	case src.AzureDisk != nil:
+		return "azureDisk"
+	case src.AzureFile != nil:
+		return "azureFile"
+	case src.ISCSI != nil:
+		return "iscsi"
+	case src.FC != nil:
+		return "fc"
+	case src.CephFS != nil:
+		return "cephfs"
+	case src.RBD != nil:
+		return "rbd"
+	}
+	return ""
+}
@@ -0,0 +1,238 @@
+package syncer_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestStorageInventory verifies claims link to volumes and classes, and
+// usage groups claims by class and provisioner
+func TestStorageInventory(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		fast := synctest.StorageClass("fast", "ebs.csi.aws.com")
+		slow := synctest.StorageClass("slow", "ebs.csi.aws.com")
+		for _, sc := range []*storagev1.StorageClass{fast, slow} {
+			if _, err := env.Client.StorageV1().StorageClasses().Create(ctx, sc, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		claims := []*corev1.PersistentVolumeClaim{
+			synctest.BoundPVC("data", "pg-0", "fast", "10Gi", "pv-pg-0"),
+			synctest.BoundPVC("data", "pg-1", "fast", "10Gi", "pv-pg-1"),
+			synctest.BoundPVC("data", "logs", "slow", "100Gi", "pv-logs"),
+		}
+		for _, pvc := range claims {
+			if _, err := env.Client.CoreV1().PersistentVolumeClaims("data").Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+			pv := synctest.PersistentVolume(pvc.Spec.VolumeName, *pvc.Spec.StorageClassName, pvc.Spec.Resources.Requests.Storage().String(), "ebs.csi.aws.com", pvc)
+			if _, err := env.Client.CoreV1().PersistentVolumes().Create(ctx, pv, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+
+		var classes []api.StorageClass
+		if err := env.Eventually(synctest.Timeout, "volumes counted per class", func() (bool, error) {
+			if err := env.GetJSON("/api/v1/storageclasses", &classes); err != nil {
+				return false, err
+			}
+			return len(classes) == 2 && classes[0].Volumes == 2 && classes[0].Claims == 2 && classes[1].Volumes == 1 && classes[1].Claims == 1, nil
+		}); err != nil {
+			return err
+		}
+		if classes[0].Name != "fast" || classes[0].CapacityMB != 20*1024 || classes[0].Provisioner != "ebs.csi.aws.com" {
+			return fmt.Errorf("fast class = %+v", classes[0])
+		}
+
+		var volumes []api.PersistentVolume
+		if err := env.Eventually(synctest.Timeout, "volumes linked to claims", func() (bool, error) {
+			if err := env.GetJSON("/api/v1/persistentvolumes?storage_class=fast", &volumes); err != nil {
+				return false, err
+			}
+			return len(volumes) == 2 && volumes[0].ClaimID != nil, nil
+		}); err != nil {
+			return err
+		}
+		if v := volumes[0]; v.Name != "pv-pg-0" || *v.Driver != "ebs.csi.aws.com" || v.ReclaimPolicy != "Delete" || !slices.Equal(v.AccessModes, []string{"ReadWriteOnce"}) {
+			return fmt.Errorf("pv-pg-0 = %+v", v)
+		}
+
+		var pvcs []api.PVC
+		if err := env.GetJSON("/api/v1/pvcs?storage_class=slow", &pvcs); err != nil {
+			return err
+		}
+		if len(pvcs) != 1 || pvcs[0].CapacityMB == nil || *pvcs[0].CapacityMB != 100*1024 || *pvcs[0].VolumeName != "pv-logs" {
+			return fmt.Errorf("slow claims = %+v", pvcs)
+		}
+
+		// pg-0 is half full; the other claims have not reported
+		pg0, err := env.QueryInt("SELECT id FROM pvcs WHERE name = 'pg-0'")
+		if err != nil {
+			return err
+		}
+		used := []store.MetricPoint{
+			{Time: env.Clock.Now().Add(-2 * time.Minute), ResourceID: int64(pg0), ResourceKind: "pvc", MetricType: "used_mb", Value: 1024},
+			{Time: env.Clock.Now().Add(-time.Minute), ResourceID: int64(pg0), ResourceKind: "pvc", MetricType: "used_mb", Value: 5 * 1024},
+		}
+		if err := env.Duck.BatchInsert(used); err != nil {
+			return err
+		}
+		if err := env.SQLite.UpsertLatest(used); err != nil {
+			return err
+		}
+
+		var usage api.StorageUsageResponse
+		if err := env.GetJSON("/api/v1/storage/usage", &usage); err != nil {
+			return err
+		}
+		if len(usage.Groups) != 2 {
+			return fmt.Errorf("usage by class = %+v", usage.Groups)
+		}
+		slowG, fastG := usage.Groups[0], usage.Groups[1]
+		if slowG.Key != "slow" || slowG.Claims != 1 || slowG.Reporting != 0 || slowG.UsedPct != nil {
+			return fmt.Errorf("slow usage = %+v", slowG)
+		}
+		if fastG.Key != "fast" || fastG.Claims != 2 || fastG.UsedMB != 5*1024 || fastG.UsedPct == nil || *fastG.UsedPct != 50 {
+			return fmt.Errorf("fast usage = %+v", fastG)
+		}
+
+		if err := env.GetJSON("/api/v1/storage/usage?by=provisioner", &usage); err != nil {
+			return err
+		}
+		if len(usage.Groups) != 1 || usage.Groups[0].Key != "ebs.csi.aws.com" || usage.Groups[0].CapacityMB != 120*1024 {
+			return fmt.Errorf("usage by provisioner = %+v", usage.Groups)
+		}
+
+		// A deleted claim no longer counts
+		if err := env.Client.CoreV1().PersistentVolumeClaims("data").Delete(ctx, "logs", metav1.DeleteOptions{}); err != nil {
+			return err
+		}
+		return env.Eventually(synctest.Timeout, "deleted claim dropped from usage", func() (bool, error) {
+			if err := env.GetJSON("/api/v1/storage/usage", &usage); err != nil {
+				return false, err
+			}
+			return len(usage.Groups) == 1 && usage.Groups[0].Key == "fast", nil
+		})
+	})
+}
+
+// TestVolumeForecast verifies a filling volume gets a resize
+// recommendation, and that a resize is recorded once its capacity grows
+func TestVolumeForecast(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		sc := synctest.StorageClass("fast", "ebs.csi.aws.com")
+		expand := true
+		sc.AllowVolumeExpansion = &expand
+		if _, err := env.Client.StorageV1().StorageClasses().Create(ctx, sc, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		filling := synctest.BoundPVC("data", "pg-0", "fast", "10Gi", "pv-pg-0")
+		steady := synctest.BoundPVC("data", "logs", "fast", "10Gi", "pv-logs")
+		for _, pvc := range []*corev1.PersistentVolumeClaim{filling, steady} {
+			if _, err := env.Client.CoreV1().PersistentVolumeClaims("data").Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		ids := map[string]int64{}
+		if err := env.Eventually(synctest.Timeout, "claims synced with capacity", func() (bool, error) {
+			for _, name := range []string{"pg-0", "logs"} {
+				id, err := env.QueryInt("SELECT id FROM pvcs WHERE name = ? AND capacity_mb = 10240", name)
+				if err != nil || id == 0 {
+					return false, nil
+				}
+				ids[name] = int64(id)
+			}
+			return true, nil
+		}); err != nil {
+			return err
+		}
+
+		// pg-0 grows by 1 GiB a day from 5 GiB; logs holds at 2 GiB
+		now := env.Clock.Now()
+		var points []store.MetricPoint
+		for h := 24; h >= 1; h-- {
+			at := now.Add(-time.Duration(h) * time.Hour)
+			points = append(points,
+				store.MetricPoint{Time: at, ResourceID: ids["pg-0"], MetricType: "used_mb", Value: 5*1024 + float64(24-h)*1024/24},
+				store.MetricPoint{Time: at, ResourceID: ids["logs"], MetricType: "used_mb", Value: 2 * 1024},
+			)
+		}
+		if err := env.Duck.BatchInsert(points); err != nil {
+			return err
+		}
+
+		var resp api.VolumeForecastResponse
+		if err := env.GetJSON("/api/v1/analysis/volumes", &resp); err != nil {
+			return err
+		}
+		if len(resp.Volumes) != 2 || resp.Volumes[0].Name != "pg-0" {
+			return fmt.Errorf("forecast = %+v", resp.Volumes)
+		}
+		pg0, logs := resp.Volumes[0], resp.Volumes[1]
+		if pg0.DaysUntilFull == nil || *pg0.DaysUntilFull < 3 || *pg0.DaysUntilFull > 6 || math.Abs(pg0.GrowthMBPerDay-1024) > 1 {
+			return fmt.Errorf("pg-0 forecast = %+v", pg0)
+		}
+		rec := pg0.Recommendation
+		if rec == nil || rec.TargetMB <= pg0.CapacityMB || rec.Expandable == nil || !*rec.Expandable ||
+			!strings.Contains(string(rec.Patch), `"storage":"`+rec.TargetSize+`"`) || !strings.HasPrefix(rec.Command, "kubectl patch pvc pg-0 -n data") {
+			return fmt.Errorf("pg-0 recommendation = %+v", rec)
+		}
+		if logs.FullAt != nil || logs.Recommendation != nil {
+			return fmt.Errorf("steady volume forecast = %+v", logs)
+		}
+		resp = api.VolumeForecastResponse{}
+		if err := env.GetJSON("/api/v1/analysis/volumes?warn_days=2", &resp); err != nil {
+			return err
+		}
+		if resp.Volumes[0].Recommendation != nil {
+			return fmt.Errorf("recommendation outside the warning window: %+v", resp.Volumes[0].Recommendation)
+		}
+
+		// Expanding the claim records a resize
+		filling.Spec.Resources.Requests[corev1.ResourceStorage] = resource.MustParse("20Gi")
+		filling.Status.Capacity[corev1.ResourceStorage] = resource.MustParse("20Gi")
+		if _, err := env.Client.CoreV1().PersistentVolumeClaims("data").Update(ctx, filling, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+		var resizes []store.PVCResize
+		if err := env.Eventually(synctest.Timeout, "resize recorded", func() (bool, error) {
+			if err := env.GetJSON(fmt.Sprintf("/api/v1/pvcs/%d/resizes", ids["pg-0"]), &resizes); err != nil {
+				return false, err
+			}
+			return len(resizes) == 1, nil
+		}); err != nil {
+			return err
+		}
+		if resizes[0].FromMB != 10*1024 || resizes[0].ToMB != 20*1024 {
+			return fmt.Errorf("resize = %+v", resizes[0])
+		}
+		resp = api.VolumeForecastResponse{}
+		if err := env.GetJSON("/api/v1/analysis/volumes", &resp); err != nil {
+			return err
+		}
+		if v := resp.Volumes[0]; v.CapacityMB != 20*1024 || v.LastResize == nil || v.LastResize.ToMB != 20*1024 {
+			return fmt.Errorf("forecast after resize = %+v", v)
+		}
+		n, err := env.QueryInt("SELECT COUNT(*) FROM annotations WHERE type = 'pvc_resize' AND name = 'pg-0'")
+		if err != nil {
+			return err
+		}
+		if n != 1 {
+			return fmt.Errorf("%d resize annotations, want 1", n)
+		}
+		return nil
+	})
+}
@@ -2,29 +2,51 @@ package syncer
 
 import (
 	"context"
-	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/events"
 	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 type ResourceSyncer struct {
-	client  *kubernetes.Clientset
+	connector func() (kubernetes.Interface, *rest.Config, error)
+	config    *rest.Config // nil when constructed from a client only
+	client    kubernetes.Interface
+	statusMu  sync.RWMutex // guards client, config, factory and status
+	status    Status
+
 	sqlite  *store.SQLiteStore
 	factory informers.SharedInformerFactory
+	resync  time.Duration
+	clock   clock.Clock // stamps what the syncer records, see SetClock
+
+	// Annotations recorded as ownership, see SetOwnershipKeys
+	ownershipKeys []string
+	// Node labels naming the node pool, see SetNodepoolLabels
+	nodepoolLabels []string
+
+	// Caches: UID -> ID (hot path for ingest, sharded)
+	pods *idCache
+	pvcs *idCache
 
 	mu sync.RWMutex
-	// Caches: UID -> ID
-	pods map[string]int64
-	pvcs map[string]int64
 
 	// Namespace name -> ID
 	namespaces map[string]int64
@@ -35,32 +57,70 @@ type ResourceSyncer struct {
 	nodes map[string]int64
 	// ReplicaSet UID -> Deployment ID (for Pod->Deployment resolution)
 	replicaSets map[string]int64
+
+	objects *events.Bus[ObjectEvent]
+	changes *events.Bus[Event]
 }
 
-func NewResourceSyncer(kubeConfigPath string, sqlite *store.SQLiteStore) (*ResourceSyncer, error) {
-	config, err := clientcmd.BuildConfigFromFlags("", kubeConfigPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to build kube config: %w", err)
-	}
+// NewResourceSyncer builds a syncer for the cluster in kubeConfigPath
+// (empty: in-cluster config). Connecting is left to Start, which keeps
+// retrying while the API server is unreachable.
+func NewResourceSyncer(kubeConfigPath string, sqlite *store.SQLiteStore) *ResourceSyncer {
+	s := newResourceSyncer(sqlite)
+	s.connector = kubeConnector(kubeConfigPath)
+	return s
+}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create clientset: %w", err)
-	}
+// NewResourceSyncerForClient builds a syncer around an existing client, e.g.
+// a fake clientset in integration harnesses. config may be nil, in which
+// case ConfigMap/Secret tracking (which needs a metadata client) is skipped.
+func NewResourceSyncerForClient(client kubernetes.Interface, config *rest.Config, sqlite *store.SQLiteStore) *ResourceSyncer {
+	s := newResourceSyncer(sqlite)
+	s.connector = func() (kubernetes.Interface, *rest.Config, error) { return client, config, nil }
+	return s
+}
 
+func newResourceSyncer(sqlite *store.SQLiteStore) *ResourceSyncer {
 	return &ResourceSyncer{
-		client:      clientset,
-		sqlite:      sqlite,
-		pods:        make(map[string]int64),
-		pvcs:        make(map[string]int64),
-		namespaces:  make(map[string]int64),
-		nodes:       make(map[string]int64),
-		replicaSets: make(map[string]int64),
-	}, nil
+		sqlite:         sqlite,
+		status:         Status{State: StateConnecting, Since: time.Now()},
+		resync:         10 * time.Minute,
+		clock:          clock.Real,
+		ownershipKeys:  DefaultOwnershipKeys,
+		nodepoolLabels: DefaultNodepoolLabels,
+		pods:           newIDCache(),
+		pvcs:           newIDCache(),
+		namespaces:     make(map[string]int64),
+		nodes:          make(map[string]int64),
+		replicaSets:    make(map[string]int64),
+		objects:        events.NewBus[ObjectEvent](),
+		changes:        events.NewBus[Event](),
+	}
+}
+
+// SetClock replaces the clock that stamps annotations, resizes and
+// resolved volume failures. Must be called before Start.
+func (s *ResourceSyncer) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// SetResyncPeriod sets how often informers replay their cache. Must be
+// called before Start.
+func (s *ResourceSyncer) SetResyncPeriod(d time.Duration) {
+	s.resync = d
 }
 
+// Start connects to the API server and blocks until the informers have
+// synced or ctx is done. While the API server is unreachable it retries
+// with backoff; ids already in the catalog resolve meanwhile.
 func (s *ResourceSyncer) Start(ctx context.Context) {
-	s.factory = informers.NewSharedInformerFactory(s.client, 10*time.Minute)
+	s.warmCaches()
+	if !s.connect(ctx) {
+		return
+	}
+	s.statusMu.Lock()
+	s.factory = informers.NewSharedInformerFactory(s.client, s.resync)
+	s.statusMu.Unlock()
 
 	podInformer := s.factory.Core().V1().Pods().Informer()
 	pvcInformer := s.factory.Core().V1().PersistentVolumeClaims().Informer()
@@ -69,11 +129,22 @@ func (s *ResourceSyncer) Start(ctx context.Context) {
 	stsInformer := s.factory.Apps().V1().StatefulSets().Informer()
 	dsInformer := s.factory.Apps().V1().DaemonSets().Informer()
 	rsInformer := s.factory.Apps().V1().ReplicaSets().Informer()
+	pdbInformer := s.factory.Policy().V1().PodDisruptionBudgets().Informer()
+	svcInformer := s.factory.Core().V1().Services().Informer()
+	ingInformer := s.factory.Networking().V1().Ingresses().Informer()
+	pvInformer := s.factory.Core().V1().PersistentVolumes().Informer()
+	scInformer := s.factory.Storage().V1().StorageClasses().Informer()
+	nsInformer := s.factory.Core().V1().Namespaces().Informer()
+
+	// Informer callbacks only publish; persistence and other consumers
+	// subscribe to the object bus.
+	objects, _ := s.objects.Subscribe("sqlite", persistBuffer, true)
+	go s.runPersister(objects)
 
-	// Handlers
 	handler := cache.ResourceEventHandlerFuncs{
-		AddFunc:    s.syncObject,
-		UpdateFunc: func(old, new interface{}) { s.syncObject(new) },
+		AddFunc:    func(obj interface{}) { s.objects.Publish(ObjectEvent{Type: EventAdded, Obj: obj}) },
+		UpdateFunc: func(old, new interface{}) { s.objects.Publish(ObjectEvent{Type: EventUpdated, Obj: new}) },
+		DeleteFunc: func(obj interface{}) { s.objects.Publish(ObjectEvent{Type: EventDeleted, Obj: obj}) },
 	}
 
 	podInformer.AddEventHandler(handler)
@@ -83,29 +154,162 @@ func (s *ResourceSyncer) Start(ctx context.Context) {
 	stsInformer.AddEventHandler(handler)
 	dsInformer.AddEventHandler(handler)
 	rsInformer.AddEventHandler(handler)
+	pdbInformer.AddEventHandler(handler)
+	svcInformer.AddEventHandler(handler)
+	ingInformer.AddEventHandler(handler)
+	pvInformer.AddEventHandler(handler)
+	scInformer.AddEventHandler(handler)
+	nsInformer.AddEventHandler(handler)
 
 	s.factory.Start(ctx.Done())
-	s.factory.WaitForCacheSync(ctx.Done())
+	synced := true
+	for _, ok := range s.factory.WaitForCacheSync(ctx.Done()) {
+		synced = synced && ok
+	}
+	if !synced {
+		return
+	}
+	s.markMissingDeleted(podInformer.GetStore(), nodeInformer.GetStore())
+	s.setStatus(StateSynced, s.Status().Attempts, nil)
+
+	if err := s.startConfigInformers(ctx); err != nil {
+		log.Printf("ConfigMap/Secret tracking disabled: %v", err)
+	}
+	s.startVolumeEventInformer(ctx)
 
 	log.Println("Resource Syncer started and synced")
 }
 
-func (s *ResourceSyncer) syncObject(obj interface{}) {
+func (s *ResourceSyncer) syncObject(evType EventType, obj interface{}) {
+	var kind string
+	var id int64
+
 	switch o := obj.(type) {
 	case *corev1.Node:
-		s.syncNode(o)
+		kind, id = "node", s.syncNode(o)
 	case *corev1.Pod:
-		s.syncPod(o)
+		kind, id = "pod", s.syncPod(o)
 	case *corev1.PersistentVolumeClaim:
-		s.syncPVC(o)
+		kind, id = "pvc", s.syncPVC(o)
 	case *appsv1.Deployment:
-		s.syncDeployment(o)
+		kind, id = "deployment", s.syncDeployment(o)
 	case *appsv1.StatefulSet:
-		s.syncStatefulSet(o)
+		kind, id = "statefulset", s.syncStatefulSet(o)
 	case *appsv1.DaemonSet:
-		s.syncDaemonSet(o)
+		kind, id = "daemonset", s.syncDaemonSet(o)
+	case *policyv1.PodDisruptionBudget:
+		kind, id = "pdb", s.syncPDB(o)
+	case *corev1.Service:
+		kind, id = "service", s.syncService(o)
+	case *networkingv1.Ingress:
+		kind, id = "ingress", s.syncIngress(o)
+	case *corev1.PersistentVolume:
+		kind, id = "pv", s.syncPV(o)
+	case *storagev1.StorageClass:
+		kind, id = "storageclass", s.syncStorageClass(o)
 	case *appsv1.ReplicaSet:
-		s.syncReplicaSet(o)
+		if s.syncReplicaSet(o) {
+			s.linkReplicaSetPods(o)
+		}
+		return
+	case *corev1.Namespace:
+		s.syncNamespace(o)
+		return
+	case *ConfigObject:
+		s.syncConfigObject(o)
+		return
+	case *corev1.Event:
+		s.syncVolumeEvent(o)
+		return
+	}
+
+	if id == 0 {
+		return
+	}
+	if m, err := meta.Accessor(obj); err == nil {
+		s.publish(Event{Type: evType, Kind: kind, ID: id, UID: string(m.GetUID()), Name: m.GetName(), Namespace: m.GetNamespace(), Obj: obj})
+	}
+}
+
+// kindTables maps event kinds to their catalog tables
+var kindTables = map[string]string{
+	"node":         "nodes",
+	"pod":          "pods",
+	"pvc":          "pvcs",
+	"deployment":   "deployments",
+	"statefulset":  "statefulsets",
+	"daemonset":    "daemonsets",
+	"pdb":          "pdbs",
+	"service":      "services",
+	"ingress":      "ingresses",
+	"pv":           "persistent_volumes",
+	"storageclass": "storage_classes",
+}
+
+func (s *ResourceSyncer) deleteObject(obj interface{}) {
+	if tomb, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tomb.Obj
+	}
+
+	var kind string
+	switch obj.(type) {
+	case *corev1.Node:
+		kind = "node"
+	case *corev1.Pod:
+		kind = "pod"
+	case *corev1.PersistentVolumeClaim:
+		kind = "pvc"
+	case *appsv1.Deployment:
+		kind = "deployment"
+	case *appsv1.StatefulSet:
+		kind = "statefulset"
+	case *appsv1.DaemonSet:
+		kind = "daemonset"
+	case *policyv1.PodDisruptionBudget:
+		kind = "pdb"
+	case *corev1.Service:
+		kind = "service"
+	case *networkingv1.Ingress:
+		kind = "ingress"
+	case *corev1.PersistentVolume:
+		kind = "pv"
+	case *storagev1.StorageClass:
+		kind = "storageclass"
+	default:
+		return
+	}
+
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	// Catalog rows are kept so historical metrics stay attributable
+	id, err := s.sqlite.GetResourceID(kindTables[kind], string(m.GetUID()))
+	if err != nil {
+		return
+	}
+	s.publish(Event{Type: EventDeleted, Kind: kind, ID: id, UID: string(m.GetUID()), Name: m.GetName(), Namespace: m.GetNamespace(), Obj: obj})
+
+	// Kinds with metrics are marked deleted, keeping their history
+	// attributable; the rest carry none, so their rows go
+	switch kind {
+	case "pod":
+		err = s.sqlite.MarkDeleted("pods", "uid", string(m.GetUID()))
+	case "pvc":
+		err = s.sqlite.MarkDeleted("pvcs", "uid", string(m.GetUID()))
+	case "node":
+		// A node registering again under this name gets a new row
+		s.mu.Lock()
+		delete(s.nodes, m.GetName())
+		s.mu.Unlock()
+		err = s.sqlite.MarkDeleted("nodes", "name", m.GetName())
+	case "pdb", "service", "pv", "storageclass":
+		err = s.sqlite.DeleteByUID(kindTables[kind], string(m.GetUID()))
+	case "ingress":
+		err = s.sqlite.DeleteIngress(string(m.GetUID()))
+	}
+	if err != nil {
+		log.Printf("Failed to delete %s %s: %v", kind, m.GetName(), err)
 	}
 }
 
@@ -128,6 +332,8 @@ func (s *ResourceSyncer) getNamespaceID(name string) int64 {
 	s.mu.Lock()
 	s.namespaces[name] = id
 	s.mu.Unlock()
+
+	s.publish(Event{Type: EventAdded, Kind: "namespace", ID: id, Name: name})
 	return id
 }
 
@@ -174,35 +380,187 @@ func (s *ResourceSyncer) getNodeID(name, uid string) int64 {
 	return id
 }
 
-func (s *ResourceSyncer) syncNode(n *corev1.Node) {
-	s.getNodeID(n.Name, string(n.UID))
+func (s *ResourceSyncer) syncNode(n *corev1.Node) int64 {
+	id := s.getNodeID(n.Name, string(n.UID))
+	if id == 0 {
+		return 0
+	}
+
+	cpu := n.Status.Allocatable[corev1.ResourceCPU]
+	mem := n.Status.Allocatable[corev1.ResourceMemory]
+	if err := s.sqlite.SetNodeAllocatable(id, cpu.MilliValue(), float64(mem.Value())/(1<<20)); err != nil {
+		log.Printf("Failed to record allocatable for node %s: %v", n.Name, err)
+	}
+	if err := s.sqlite.SetNodeReady(id, nodeReady(n)); err != nil {
+		log.Printf("Failed to record readiness for node %s: %v", n.Name, err)
+	}
+	if err := s.sqlite.SetNodeLabels(id, n.Labels); err != nil {
+		log.Printf("Failed to record labels for node %s: %v", n.Name, err)
+	}
+	if err := s.sqlite.SetNodeGroup(id, s.nodeGroup(n)); err != nil {
+		log.Printf("Failed to record group of node %s: %v", n.Name, err)
+	}
+	return id
+}
+
+// nodeReady reports whether the node's Ready condition is true
+func nodeReady(n *corev1.Node) bool {
+	for _, c := range n.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// NodeAddresses returns the IP addresses a node reports in its status, as
+// the node informer last saw it. false means the node is not known (yet).
+func (s *ResourceSyncer) NodeAddresses(name string) ([]string, bool) {
+	s.statusMu.RLock()
+	factory := s.factory
+	s.statusMu.RUnlock()
+	if factory == nil {
+		return nil, false
+	}
+	n, err := factory.Core().V1().Nodes().Lister().Get(name)
+	if err != nil {
+		return nil, false
+	}
+	var addrs []string
+	for _, a := range n.Status.Addresses {
+		if a.Type == corev1.NodeInternalIP || a.Type == corev1.NodeExternalIP {
+			addrs = append(addrs, a.Address)
+		}
+	}
+	return addrs, true
 }
 
-func (s *ResourceSyncer) syncDeployment(d *appsv1.Deployment) {
+func (s *ResourceSyncer) syncDeployment(d *appsv1.Deployment) int64 {
 	nsID := s.getNamespaceID(d.Namespace)
-	_, err := s.sqlite.UpsertDeployment(string(d.UID), d.Name, nsID)
+	id, err := s.sqlite.UpsertDeployment(string(d.UID), d.Name, nsID)
 	if err != nil {
 		log.Printf("Failed to sync deployment %s: %v", d.Name, err)
+		return 0
 	}
+	s.recordRollout("deployment", id, nsID, d.Name, d.Annotations[deploymentRevisionAnnotation])
+	s.recordOwnership("deployment", id, d.Name, d.Annotations)
+	s.recordRetention("deployment", id, d.Name, d.Annotations)
+	return id
 }
 
-func (s *ResourceSyncer) syncStatefulSet(sts *appsv1.StatefulSet) {
+func (s *ResourceSyncer) syncStatefulSet(sts *appsv1.StatefulSet) int64 {
 	nsID := s.getNamespaceID(sts.Namespace)
-	_, err := s.sqlite.UpsertStatefulSet(string(sts.UID), sts.Name, nsID)
+	id, err := s.sqlite.UpsertStatefulSet(string(sts.UID), sts.Name, nsID)
 	if err != nil {
 		log.Printf("Failed to sync sts %s: %v", sts.Name, err)
+		return 0
 	}
+	s.recordRollout("statefulset", id, nsID, sts.Name, sts.Status.UpdateRevision)
+	s.recordOwnership("statefulset", id, sts.Name, sts.Annotations)
+	s.recordRetention("statefulset", id, sts.Name, sts.Annotations)
+	return id
 }
 
-func (s *ResourceSyncer) syncDaemonSet(ds *appsv1.DaemonSet) {
+func (s *ResourceSyncer) syncDaemonSet(ds *appsv1.DaemonSet) int64 {
 	nsID := s.getNamespaceID(ds.Namespace)
-	_, err := s.sqlite.UpsertDaemonSet(string(ds.UID), ds.Name, nsID)
+	id, err := s.sqlite.UpsertDaemonSet(string(ds.UID), ds.Name, nsID)
 	if err != nil {
 		log.Printf("Failed to sync ds %s: %v", ds.Name, err)
+		return 0
+	}
+	if err := s.sqlite.SetDaemonSetDesired(id, ds.Status.DesiredNumberScheduled); err != nil {
+		log.Printf("Failed to record desired nodes of ds %s: %v", ds.Name, err)
+	}
+	s.recordRollout("daemonset", id, nsID, ds.Name, ds.Annotations[daemonSetRevisionAnnotation])
+	s.recordOwnership("daemonset", id, ds.Name, ds.Annotations)
+	s.recordRetention("daemonset", id, ds.Name, ds.Annotations)
+	return id
+}
+
+func (s *ResourceSyncer) syncPDB(pdb *policyv1.PodDisruptionBudget) int64 {
+	nsID := s.getNamespaceID(pdb.Namespace)
+
+	st := store.PDBStatus{
+		DisruptionsAllowed: pdb.Status.DisruptionsAllowed,
+		CurrentHealthy:     pdb.Status.CurrentHealthy,
+		DesiredHealthy:     pdb.Status.DesiredHealthy,
+		ExpectedPods:       pdb.Status.ExpectedPods,
+	}
+	if v := pdb.Spec.MinAvailable; v != nil {
+		str := v.String()
+		st.MinAvailable = &str
+	}
+	if v := pdb.Spec.MaxUnavailable; v != nil {
+		str := v.String()
+		st.MaxUnavailable = &str
+	}
+
+	var depID, stsID *int64
+	if selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector); err == nil {
+		depID, stsID, _ = s.workloadsSelectedBy(pdb.Namespace, selector)
+	}
+
+	id, err := s.sqlite.UpsertPDB(string(pdb.UID), pdb.Name, nsID, st, depID, stsID)
+	if err != nil {
+		log.Printf("Failed to sync pdb %s: %v", pdb.Name, err)
+		return 0
+	}
+	return id
+}
+
+// workloadsSelectedBy finds the deployment, statefulset or daemonset in ns
+// whose pod template labels match selector (first match wins)
+func (s *ResourceSyncer) workloadsSelectedBy(ns string, selector labels.Selector) (depID, stsID, dsID *int64) {
+	if selector.Empty() {
+		return nil, nil, nil
+	}
+
+	deps, _ := s.factory.Apps().V1().Deployments().Lister().Deployments(ns).List(labels.Everything())
+	for _, d := range deps {
+		if selector.Matches(labels.Set(d.Spec.Template.Labels)) {
+			if id, err := s.sqlite.GetResourceID("deployments", string(d.UID)); err == nil {
+				return &id, nil, nil
+			}
+		}
+	}
+
+	stss, _ := s.factory.Apps().V1().StatefulSets().Lister().StatefulSets(ns).List(labels.Everything())
+	for _, sts := range stss {
+		if selector.Matches(labels.Set(sts.Spec.Template.Labels)) {
+			if id, err := s.sqlite.GetResourceID("statefulsets", string(sts.UID)); err == nil {
+				return nil, &id, nil
+			}
+		}
 	}
+
+	dss, _ := s.factory.Apps().V1().DaemonSets().Lister().DaemonSets(ns).List(labels.Everything())
+	for _, ds := range dss {
+		if selector.Matches(labels.Set(ds.Spec.Template.Labels)) {
+			if id, err := s.sqlite.GetResourceID("daemonsets", string(ds.UID)); err == nil {
+				return nil, nil, &id
+			}
+		}
+	}
+	return nil, nil, nil
 }
 
-func (s *ResourceSyncer) syncReplicaSet(rs *appsv1.ReplicaSet) {
+// resolveReplicaSet retries the RS -> Deployment mapping from the informer cache
+func (s *ResourceSyncer) resolveReplicaSet(namespace, name string) (int64, bool) {
+	rs, err := s.factory.Apps().V1().ReplicaSets().Lister().ReplicaSets(namespace).Get(name)
+	if err != nil {
+		return 0, false
+	}
+	s.syncReplicaSet(rs)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, ok := s.replicaSets[string(rs.UID)]
+	return id, ok
+}
+
+// syncReplicaSet reports whether it mapped rs to its Deployment for the
+// first time
+func (s *ResourceSyncer) syncReplicaSet(rs *appsv1.ReplicaSet) bool {
 	// We don't store RS in DB, but we cache the RS UID -> Deployment ID mapping
 	rsUID := string(rs.UID)
 
@@ -211,21 +569,44 @@ func (s *ResourceSyncer) syncReplicaSet(rs *appsv1.ReplicaSet) {
 			// Look up deployment ID
 			if depID, err := s.sqlite.GetResourceID("deployments", string(owner.UID)); err == nil {
 				s.mu.Lock()
+				_, known := s.replicaSets[rsUID]
 				s.replicaSets[rsUID] = depID
 				s.mu.Unlock()
-				return
+				return !known
+			}
+		}
+	}
+	return false
+}
+
+// linkReplicaSetPods syncs again the pods of rs stored before it was
+// mapped: informers deliver independently, so a pod can arrive before its
+// ReplicaSet and would stay unlinked from its Deployment until the resync
+func (s *ResourceSyncer) linkReplicaSetPods(rs *appsv1.ReplicaSet) {
+	pods, err := s.factory.Core().V1().Pods().Lister().Pods(rs.Namespace).List(labels.Everything())
+	if err != nil {
+		return
+	}
+	for _, pod := range pods {
+		if _, stored := s.pods.Get(string(pod.UID)); !stored {
+			continue // not synced yet; it links itself when it is
+		}
+		for _, owner := range pod.OwnerReferences {
+			if owner.UID == rs.UID {
+				s.syncObject(EventUpdated, pod)
+				break
 			}
 		}
 	}
 }
 
-func (s *ResourceSyncer) syncPod(pod *corev1.Pod) {
+func (s *ResourceSyncer) syncPod(pod *corev1.Pod) int64 {
 	uid := string(pod.UID)
 	nsID := s.getNamespaceID(pod.Namespace)
 
 	if nsID == 0 {
 		log.Printf("Failed to sync pod %s: namespace ID is 0", pod.Name)
-		return
+		return 0
 	}
 
 	var nodeID int64
@@ -233,20 +614,22 @@ func (s *ResourceSyncer) syncPod(pod *corev1.Pod) {
 		nodeID = s.getNodeID(pod.Spec.NodeName, "")
 		if nodeID == 0 {
 			log.Printf("Failed to sync pod %s: node ID is 0", pod.Name)
-			return
+			return 0
 		}
 	} else {
 		// Pod not scheduled yet, skip for now
-		return
+		return 0
 	}
 
 	var depID, stsID, dsID *int64
+	var ordinal *int
 
 	for _, owner := range pod.OwnerReferences {
 		if owner.Kind == "StatefulSet" {
 			if id, err := s.sqlite.GetResourceID("statefulsets", string(owner.UID)); err == nil {
 				stsID = &id
 			}
+			ordinal = podOrdinal(pod, owner.Name)
 		} else if owner.Kind == "DaemonSet" {
 			if id, err := s.sqlite.GetResourceID("daemonsets", string(owner.UID)); err == nil {
 				dsID = &id
@@ -254,47 +637,297 @@ func (s *ResourceSyncer) syncPod(pod *corev1.Pod) {
 		} else if owner.Kind == "ReplicaSet" {
 			// Check RS cache for deployment link
 			s.mu.RLock()
-			if id, ok := s.replicaSets[string(owner.UID)]; ok {
+			id, ok := s.replicaSets[string(owner.UID)]
+			s.mu.RUnlock()
+			if !ok {
+				// The RS may have been seen before its Deployment was stored
+				id, ok = s.resolveReplicaSet(pod.Namespace, owner.Name)
+			}
+			if ok {
 				depID = &id
 			}
-			s.mu.RUnlock()
 		}
 	}
 
-	id, err := s.sqlite.UpsertPod(uid, pod.Name, nsID, nodeID, depID, stsID, dsID)
+	id, err := s.sqlite.UpsertPod(uid, pod.Name, nsID, nodeID, depID, stsID, dsID, ordinal)
 	if err != nil {
 		log.Printf("Failed to sync pod %s: %v", pod.Name, err)
-		return
+		return 0
 	}
 
-	s.mu.Lock()
-	s.pods[uid] = id
-	s.mu.Unlock()
+	s.pods.Set(uid, id)
+
+	if err := s.sqlite.SetPodStatus(id, string(pod.Status.Phase), podReady(pod)); err != nil {
+		log.Printf("Failed to record status of pod %s: %v", pod.Name, err)
+	}
+	// Its volumes are mounted once a pod is ready
+	if podReady(pod) {
+		if err := s.sqlite.ResolveVolumeFailures(id, s.clock.Now()); err != nil {
+			log.Printf("Failed to resolve volume failures of pod %s: %v", pod.Name, err)
+		}
+	}
+	if err := s.sqlite.SetPodContainerState(id, podContainerState(pod)); err != nil {
+		log.Printf("Failed to record container state of pod %s: %v", pod.Name, err)
+	}
+
+	if err := s.sqlite.ReplaceConfigRefs(id, podConfigRefs(pod)); err != nil {
+		log.Printf("Failed to sync config refs for pod %s: %v", pod.Name, err)
+	}
+	if err := s.sqlite.ReplacePodClaims(id, podClaims(pod)); err != nil {
+		log.Printf("Failed to sync claims for pod %s: %v", pod.Name, err)
+	}
+	if err := s.sqlite.ReplacePodContainers(id, podContainerSpecs(pod)); err != nil {
+		log.Printf("Failed to sync container specs for pod %s: %v", pod.Name, err)
+	}
+	if err := s.sqlite.SetPodServiceAccount(id, pod.Spec.ServiceAccountName); err != nil {
+		log.Printf("Failed to record service account of pod %s: %v", pod.Name, err)
+	}
+	if err := s.sqlite.SetPodPlacement(id, pod.Labels, podPlacement(pod)); err != nil {
+		log.Printf("Failed to record placement rules of pod %s: %v", pod.Name, err)
+	}
+	return id
+}
+
+// podReady reports whether the pod's Ready condition is true
+func podReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// errorWaitReasons are the container waiting reasons that mean the
+// container cannot start, rather than that it is still starting
+var errorWaitReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"RunContainerError":          true,
+}
+
+// podContainerState sums restarts and finds the latest OOM kill and any
+// container stuck on an error, init containers included
+func podContainerState(pod *corev1.Pod) store.ContainerState {
+	var st store.ContainerState
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		st.Restarts += cs.RestartCount
+		for _, t := range []*corev1.ContainerStateTerminated{cs.LastTerminationState.Terminated, cs.State.Terminated} {
+			if t == nil || t.Reason != "OOMKilled" {
+				continue
+			}
+			if at := t.FinishedAt.Time; st.OOMKilledAt == nil || at.After(*st.OOMKilledAt) {
+				st.OOMKilledAt = &at
+			}
+		}
+		if w := cs.State.Waiting; w != nil && st.Waiting == "" && errorWaitReasons[w.Reason] {
+			st.Waiting = w.Reason
+		}
+	}
+	return st
 }
 
-func (s *ResourceSyncer) syncPVC(pvc *corev1.PersistentVolumeClaim) {
+// markMissingDeleted flags pods and nodes that were deleted while the
+// consumer was not watching, once the informers have listed what exists
+func (s *ResourceSyncer) markMissingDeleted(pods, nodes cache.Store) {
+	podUIDs := make(map[string]bool)
+	for _, obj := range pods.List() {
+		if m, err := meta.Accessor(obj); err == nil {
+			podUIDs[string(m.GetUID())] = true
+		}
+	}
+	nodeNames := make(map[string]bool)
+	for _, obj := range nodes.List() {
+		if m, err := meta.Accessor(obj); err == nil {
+			nodeNames[m.GetName()] = true
+		}
+	}
+
+	for _, t := range []struct {
+		table, column string
+		live          map[string]bool
+	}{
+		{"pods", "uid", podUIDs},
+		{"nodes", "name", nodeNames},
+	} {
+		n, err := s.sqlite.MarkDeletedExcept(t.table, t.column, t.live)
+		if err != nil {
+			log.Printf("Failed to reconcile deleted %s: %v", t.table, err)
+		} else if n > 0 {
+			log.Printf("Marked %d %s deleted while not watching", n, t.table)
+		}
+	}
+}
+
+// podOrdinal returns the replica index of a StatefulSet pod: the pod-index
+// label where the cluster sets it (1.28+), otherwise the <set>-<n> name
+func podOrdinal(pod *corev1.Pod, set string) *int {
+	v, ok := pod.Labels["apps.kubernetes.io/pod-index"]
+	if !ok {
+		if !strings.HasPrefix(pod.Name, set+"-") {
+			return nil
+		}
+		v = strings.TrimPrefix(pod.Name, set+"-")
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return nil
+	}
+	return &n
+}
+
+// podContainerSpecs extracts requests/limits and debugging fields of the
+// pod's app containers. Only env var names are kept, and args that look
+// like credentials are redacted.
+func podContainerSpecs(pod *corev1.Pod) []store.ContainerSpec {
+	out := make([]store.ContainerSpec, 0, len(pod.Spec.Containers))
+	for _, c := range pod.Spec.Containers {
+		cs := store.ContainerSpec{
+			ContainerResources: store.ContainerResources{Name: c.Name},
+			Image:              c.Image,
+			ImagePullPolicy:    string(c.ImagePullPolicy),
+			Args:               redactArgs(c.Args),
+		}
+		for _, env := range c.Env {
+			cs.EnvNames = append(cs.EnvNames, env.Name)
+		}
+		if q, ok := c.Resources.Requests[corev1.ResourceCPU]; ok {
+			v := q.MilliValue()
+			cs.CPURequestM = &v
+		}
+		if q, ok := c.Resources.Limits[corev1.ResourceCPU]; ok {
+			v := q.MilliValue()
+			cs.CPULimitM = &v
+		}
+		if q, ok := c.Resources.Requests[corev1.ResourceMemory]; ok {
+			v := float64(q.Value()) / (1 << 20)
+			cs.MemRequestMB = &v
+		}
+		if q, ok := c.Resources.Limits[corev1.ResourceMemory]; ok {
+			v := float64(q.Value()) / (1 << 20)
+			cs.MemLimitMB = &v
+		}
+		out = append(out, cs)
+	}
+	return out
+}
+
+// secretArgWords mark flags whose values are not stored
+var secretArgWords = []string{"password", "passwd", "secret", "token", "key", "credential"}
+
+// redactArgs replaces the values of credential-looking flags, given as
+// --flag=value or --flag value
+func redactArgs(args []string) []string {
+	isSecret := func(flag string) bool {
+		flag = strings.ToLower(strings.TrimLeft(flag, "-"))
+		for _, w := range secretArgWords {
+			if strings.Contains(flag, w) {
+				return true
+			}
+		}
+		return false
+	}
+
+	out := make([]string, len(args))
+	redactNext := false
+	for i, a := range args {
+		switch {
+		case redactNext && !strings.HasPrefix(a, "-"):
+			out[i] = "<redacted>"
+		case strings.HasPrefix(a, "-") && strings.Contains(a, "="):
+			flag, _, _ := strings.Cut(a, "=")
+			out[i] = a
+			if isSecret(flag) {
+				out[i] = flag + "=<redacted>"
+			}
+		default:
+			out[i] = a
+		}
+		redactNext = strings.HasPrefix(a, "-") && !strings.Contains(a, "=") && isSecret(a)
+	}
+	return out
+}
+
+func (s *ResourceSyncer) syncPVC(pvc *corev1.PersistentVolumeClaim) int64 {
 	uid := string(pvc.UID)
 	nsID := s.getNamespaceID(pvc.Namespace)
 
 	id, err := s.sqlite.UpsertPVC(uid, pvc.Name, nsID)
 	if err != nil {
 		log.Printf("Failed to sync pvc %s: %v", pvc.Name, err)
-		return
+		return 0
 	}
 
-	s.mu.Lock()
-	s.pvcs[uid] = id
-	s.mu.Unlock()
+	st := pvcStorage(pvc)
+	prev, err := s.sqlite.SetPVCStorage(id, st)
+	if err != nil {
+		log.Printf("Failed to record storage of pvc %s: %v", pvc.Name, err)
+	} else if prev != nil && st.CapacityMB != nil && *prev != *st.CapacityMB {
+		s.recordResize(pvc, id, nsID, *prev, *st.CapacityMB)
+	}
+
+	s.pvcs.Set(uid, id)
+	return id
 }
 
 func (s *ResourceSyncer) GetResourceID(uid, rType string) (int64, bool) {
+	return s.cacheFor(rType).Get(uid)
+}
+
+// GetResourceIDs resolves a batch of UIDs of the same type in one pass.
+// The result is positionally aligned with uids; unresolved entries are 0.
+func (s *ResourceSyncer) GetResourceIDs(rType string, uids []string) []int64 {
+	return s.cacheFor(rType).GetMany(uids)
+}
+
+func (s *ResourceSyncer) cacheFor(rType string) *idCache {
+	if rType == "pvc" {
+		return s.pvcs
+	}
+	return s.pods
+}
+
+// Forget drops cached mappings to catalog rows removed outside the syncer
+// (ids by table, as in store.PurgeSet), so they are re-created instead of
+// referenced
+func (s *ResourceSyncer) Forget(ids map[string][]int64) {
+	set := func(table string) map[int64]bool {
+		out := make(map[int64]bool, len(ids[table]))
+		for _, id := range ids[table] {
+			out[id] = true
+		}
+		return out
+	}
+	s.pods.DeleteIDs(set("pods"))
+	s.pvcs.DeleteIDs(set("pvcs"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for table, m := range map[string]map[string]int64{"namespaces": s.namespaces, "nodes": s.nodes, "deployments": s.replicaSets} {
+		gone := set(table)
+		for k, id := range m {
+			if gone[id] {
+				delete(m, k)
+			}
+		}
+	}
+}
+
+// CacheSizes reports the number of entries in each in-memory resolution cache
+func (s *ResourceSyncer) CacheSizes() map[string]int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if rType == "pvc" {
-		id, ok := s.pvcs[uid]
-		return id, ok
+	return map[string]int{
+		"pods":        s.pods.Len(),
+		"pvcs":        s.pvcs.Len(),
+		"namespaces":  len(s.namespaces),
+		"nodes":       len(s.nodes),
+		"replicasets": len(s.replicaSets),
 	}
-	id, ok := s.pods[uid]
-	return id, ok
 }
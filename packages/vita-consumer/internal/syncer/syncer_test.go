@@ -0,0 +1,91 @@
+package syncer_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/syncer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeAllocatable(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		if _, err := env.Client.CoreV1().Nodes().Create(ctx, synctest.Node("node-a", "4", "8Gi"), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		return env.Eventually(synctest.Timeout, "node allocatable recorded", func() (bool, error) {
+			cpu, err := env.QueryInt("SELECT cpu_allocatable_m FROM nodes WHERE name = ?", "node-a")
+			if err != nil {
+				return false, err
+			}
+			mem, err := env.QueryInt("SELECT mem_allocatable_mb FROM nodes WHERE name = ?", "node-a")
+			return cpu == 4000 && mem == 8192, err
+		})
+	})
+}
+
+// TestPodOwnerChain verifies a pod owned through a ReplicaSet is linked
+// to its Deployment, both in SQLite and in /api/v1/pods
+func TestPodOwnerChain(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		if _, err := env.Client.CoreV1().Nodes().Create(ctx, synctest.Node("node-a", "4", "8Gi"), metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		rs, _, err := env.CreateDeployment(ctx, synctest.Deployment("shop", "web", 1))
+		if err != nil {
+			return err
+		}
+
+		pod := synctest.Pod("shop", "web-5d4f8c-x7k2p", "node-a", rs)
+		if _, err := env.Client.CoreV1().Pods("shop").Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+
+		return env.Eventually(synctest.Timeout, "pod listed under deployment", func() (bool, error) {
+			var pods []api.Pod
+			if err := env.GetJSON("/api/v1/pods", &pods); err != nil {
+				return false, err
+			}
+			for _, p := range pods {
+				if p.UID != string(pod.UID) {
+					continue
+				}
+				if p.Namespace != "shop" || p.NodeName != "node-a" {
+					return false, fmt.Errorf("pod has namespace %q node %q", p.Namespace, p.NodeName)
+				}
+				return p.Deployment != nil && *p.Deployment == "web", nil
+			}
+			return false, nil
+		})
+	})
+}
+
+func TestPVC(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		pvc := synctest.PVC("data", "pg-0")
+		if _, err := env.Client.CoreV1().PersistentVolumeClaims("data").Create(ctx, pvc, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		return env.Eventually(synctest.Timeout, "pvc synced", func() (bool, error) {
+			id, ok := env.Syncer.GetResourceID(string(pvc.UID), "pvc")
+			return ok && id > 0, nil
+		})
+	})
+}
+
+// TestClusterStatus expects the syncer to report synced once its caches
+// have listed the fake cluster
+func TestClusterStatus(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		return env.Eventually(synctest.Timeout, "status synced", func() (bool, error) {
+			var st api.StatusResponse
+			if err := env.GetJSON("/api/v1/status", &st); err != nil {
+				return false, err
+			}
+			return st.Kubernetes.State == syncer.StateSynced && !st.Degraded && st.Kubernetes.Attempts == 1, nil
+		})
+	})
+}
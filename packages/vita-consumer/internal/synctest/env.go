@@ -0,0 +1,318 @@
+// Package synctest runs the real syncer, stores and API against a fake
+// Kubernetes API server so catalog behaviour can be checked end to end
+// without a cluster. Package tests run their scenarios through Run.
+//
+// The fake clientset has no controllers: fixtures create ReplicaSets and
+// Pods explicitly, with UIDs and owner references filled in.
+//
+// A fake clientset is enough, rather than envtest's API server: the syncer
+// only lists and watches through client-go informers, which the fake's
+// object tracker serves with the same add, update and delete events, and
+// nothing under test relies on what a real server adds (defaulting,
+// validation, admission, resource version conflicts). The fake ignores
+// field selectors, so fixtures only create objects a selector would pass,
+// e.g. warning events. Tests stay in-process and need no etcd or
+// kube-apiserver binaries.
+package synctest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/lastseen"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/resolve"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/rollup"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/slo"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/syncer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/usage"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// PodLogsToken is the bearer token the API accepts for pod logs
+const PodLogsToken = "synctest"
+
+// AdminToken is the bearer token Auth admits as the authenticated "admin";
+// GetJSON, PostJSON and DeleteJSON send it
+const AdminToken = "synctest-admin"
+
+// Auth admits AdminToken as an authenticated caller and anyone else
+// anonymously, as the "none" authenticator does
+type Auth struct{}
+
+func (Auth) ValidateRequest(r *http.Request) (*api.Principal, error) {
+	if r.Header.Get("Authorization") == "Bearer "+AdminToken {
+		return &api.Principal{Name: "admin", Method: api.AuthToken}, nil
+	}
+	return api.NoAuth{}.ValidateRequest(r)
+}
+
+// Timeout is how long scenarios wait for a change to sync
+const Timeout = 5 * time.Second
+
+// Env is one isolated syncer + stores + API instance
+type Env struct {
+	Client *fake.Clientset
+	SQLite *store.SQLiteStore
+	Duck   *store.DuckDBStore
+	Syncer *syncer.ResourceSyncer
+	// Resolver resolves through every layer; misses are remembered for
+	// a minute of Clock time
+	Resolver *resolve.Composite
+	Ring     *buffer.RingBuffer
+	Clock    *clock.Fake // drives the ring buffer and API; starts at the real time
+	API      *httptest.Server
+	Server   *api.Server // behind API, for its background work
+
+	// Deployments and LastSeen observe Ring, as in the consumer
+	Deployments *rollup.DeploymentLive
+	LastSeen    *lastseen.Tracker
+
+	// Usage counts what scenarios admit through it; it is not wired to Ring
+	Usage *usage.Accountant
+
+	// SLOs serves /api/v1/slos; scenarios drive its roll-ups
+	SLOs *slo.Engine
+
+	dir    string
+	cancel context.CancelFunc
+}
+
+// New starts a syncer against an empty fake cluster and waits for its
+// informers to sync
+func New() (*Env, error) {
+	dir, err := os.MkdirTemp("", "synctest")
+	if err != nil {
+		return nil, err
+	}
+	env := &Env{dir: dir, Client: fake.NewClientset(), Ring: buffer.NewRingBuffer(1000), Clock: clock.NewFake(time.Now())}
+	env.Ring.SetClock(env.Clock)
+
+	if env.SQLite, err = store.NewSQLiteStore(filepath.Join(dir, "meta.db")); err != nil {
+		env.Close()
+		return nil, err
+	}
+	if env.Duck, err = store.NewDuckDBStore(filepath.Join(dir, "metrics.duckdb")); err != nil {
+		env.Close()
+		return nil, err
+	}
+
+	env.Syncer = syncer.NewResourceSyncerForClient(env.Client, nil, env.SQLite)
+	env.Syncer.SetClock(env.Clock)
+	if env.Resolver, err = env.Syncer.Resolver(syncer.ResolverConfig{MissTTL: time.Minute}); err != nil {
+		env.Close()
+		return nil, err
+	}
+	env.Resolver.SetClock(env.Clock)
+	env.Deployments = rollup.NewDeploymentLive(env.SQLite)
+	env.Deployments.SetClock(env.Clock)
+	env.Ring.AddObserver(env.Deployments)
+	podEvents, _ := env.Syncer.Changes().Subscribe("deployment_live", 1024, false)
+	go env.Deployments.Run(podEvents)
+	env.LastSeen = lastseen.New()
+	env.LastSeen.SetClock(env.Clock)
+	env.Ring.AddObserver(env.LastSeen)
+	env.Usage = usage.New(env.SQLite)
+	env.Usage.SetClock(env.Clock)
+
+	var ctx context.Context
+	ctx, env.cancel = context.WithCancel(context.Background())
+	env.Syncer.Start(ctx)
+
+	mux := http.NewServeMux()
+	server := api.NewServer(env.SQLite, env.Duck, env.Ring, env.Syncer)
+	env.Server = server
+	server.SetClock(env.Clock)
+	server.SetDeploymentTotals(env.Deployments)
+	server.SetLastSeen(env.LastSeen)
+	server.SetCatalogCache(env.Syncer)
+	server.SetClusterStatus(env.Syncer)
+	server.SetStatusPage(api.StatusPage{CacheFor: 30 * time.Second})
+	server.SetPodLogs(env.Syncer, api.PodLogsAuth{Token: PodLogsToken})
+	server.SetProcessMetrics(true)
+	server.SetWorkloadHealth(true)
+	server.RegisterRoutes(mux)
+	env.SLOs = slo.NewEngine(env.SQLite, env.Duck)
+	env.SLOs.RegisterRoutes(mux)
+	env.API = httptest.NewServer(api.Authenticate(Auth{}, mux))
+	return env, nil
+}
+
+// Close stops the syncer and removes all state
+func (e *Env) Close() {
+	if e.API != nil {
+		e.API.Close()
+	}
+	if e.cancel != nil {
+		e.cancel()
+	}
+	if e.Duck != nil {
+		e.Duck.Close()
+	}
+	if e.SQLite != nil {
+		e.SQLite.Close()
+	}
+	os.RemoveAll(e.dir)
+}
+
+// Run gives scenario its own cluster and stores, failing t with the
+// error it returns
+func Run(t *testing.T, scenario func(ctx context.Context, env *Env) error) {
+	t.Helper()
+	env, err := New()
+	if err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	defer env.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), time.Minute)
+	defer cancel()
+	if err := scenario(ctx, env); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Eventually polls cond until it reports true, returns an error, or the
+// timeout expires. Informer delivery is asynchronous, so every assertion
+// on synced state goes through here.
+func (e *Env) Eventually(timeout time.Duration, what string, cond func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := cond()
+		if err != nil {
+			return fmt.Errorf("%s: %w", what, err)
+		}
+		if ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s: not satisfied after %s", what, timeout)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// QueryInt runs a single-value query against the catalog; a missing row
+// yields 0
+func (e *Env) QueryInt(query string, args ...interface{}) (int64, error) {
+	rows, err := e.SQLite.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var v int64
+	if rows.Next() {
+		if err := rows.Scan(&v); err != nil {
+			return 0, err
+		}
+	}
+	return v, rows.Err()
+}
+
+// CreateDeployment creates dep and the ReplicaSet owning its pods, as the
+// deployment controller would, and waits for the catalog to have the
+// deployment. Returns the ReplicaSet, for Pod, and the deployment's ID.
+func (e *Env) CreateDeployment(ctx context.Context, dep *appsv1.Deployment) (*appsv1.ReplicaSet, int64, error) {
+	rs := ReplicaSet(dep)
+	if _, err := e.Client.AppsV1().Deployments(dep.Namespace).Create(ctx, dep, metav1.CreateOptions{}); err != nil {
+		return nil, 0, err
+	}
+	if _, err := e.Client.AppsV1().ReplicaSets(rs.Namespace).Create(ctx, rs, metav1.CreateOptions{}); err != nil {
+		return nil, 0, err
+	}
+	var id int64
+	err := e.Eventually(Timeout, "deployment synced", func() (bool, error) {
+		var err error
+		id, err = e.QueryInt("SELECT id FROM deployments WHERE uid = ?", string(dep.UID))
+		return id != 0, err
+	})
+	return rs, id, err
+}
+
+// CreatePods creates pods and waits until the catalog has each of them,
+// linked to its deployment when a ReplicaSet owns it. Returns their IDs by
+// name.
+func (e *Env) CreatePods(ctx context.Context, pods ...*corev1.Pod) (map[string]int64, error) {
+	for _, pod := range pods {
+		if _, err := e.Client.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+			return nil, err
+		}
+	}
+	ids := make(map[string]int64, len(pods))
+	err := e.Eventually(Timeout, "pods linked", func() (bool, error) {
+		for _, pod := range pods {
+			if ids[pod.Name] != 0 {
+				continue
+			}
+			query := "SELECT id FROM pods WHERE uid = ?"
+			if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "ReplicaSet" {
+				query += " AND deployment_id IS NOT NULL"
+			}
+			id, err := e.QueryInt(query, string(pod.UID))
+			if err != nil || id == 0 {
+				return false, err
+			}
+			ids[pod.Name] = id
+		}
+		return true, nil
+	})
+	return ids, err
+}
+
+// GetJSON decodes an API response, failing on non-200 status
+func (e *Env) GetJSON(path string, out interface{}) error {
+	return e.doJSON(http.MethodGet, path, nil, out)
+}
+
+// PostJSON sends body as JSON and decodes the response, failing on non-200
+// status
+func (e *Env) PostJSON(path string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return e.doJSON(http.MethodPost, path, bytes.NewReader(data), out)
+}
+
+// DeleteJSON sends a DELETE and decodes the response, failing on non-200
+// status
+func (e *Env) DeleteJSON(path string, out interface{}) error {
+	return e.doJSON(http.MethodDelete, path, nil, out)
+}
+
+// doJSON sends a request as AdminToken and decodes the response
+func (e *Env) doJSON(method, path string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequest(method, e.API.URL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+AdminToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
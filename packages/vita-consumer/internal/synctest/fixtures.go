@@ -0,0 +1,198 @@
+package synctest
+
+import (
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// The fake clientset does not assign UIDs, so every fixture gets one here
+
+func objectMeta(namespace, name string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Namespace: namespace, Name: name, UID: uuid.NewUUID()}
+}
+
+// Node returns a node with the given allocatable CPU and memory
+func Node(name, cpu, memory string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: objectMeta("", name),
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpu),
+				corev1.ResourceMemory: resource.MustParse(memory),
+			},
+		},
+	}
+}
+
+// Deployment returns a deployment whose template carries app=<name>
+func Deployment(namespace, name string, replicas int32) *appsv1.Deployment {
+	labels := map[string]string{"app": name}
+	return &appsv1.Deployment{
+		ObjectMeta: objectMeta(namespace, name),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+		},
+	}
+}
+
+// ReplicaSet returns a replicaset owned by dep, as the deployment
+// controller would create it
+func ReplicaSet(dep *appsv1.Deployment) *appsv1.ReplicaSet {
+	return &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       dep.Namespace,
+			Name:            dep.Name + "-5d4f8c",
+			UID:             uuid.NewUUID(),
+			Labels:          dep.Spec.Template.Labels,
+			OwnerReferences: []metav1.OwnerReference{ownerRef("Deployment", dep.Name, dep.UID)},
+		},
+		Spec: appsv1.ReplicaSetSpec{
+			Replicas: dep.Spec.Replicas,
+			Selector: dep.Spec.Selector,
+			Template: dep.Spec.Template,
+		},
+	}
+}
+
+// Pod returns a pod scheduled on node, optionally owned by a replicaset
+func Pod(namespace, name, node string, owner *appsv1.ReplicaSet) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: objectMeta(namespace, name),
+		Spec: corev1.PodSpec{
+			NodeName: node,
+			Containers: []corev1.Container{{
+				Name: "app",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+				},
+			}},
+		},
+	}
+	if owner != nil {
+		pod.Labels = owner.Spec.Template.Labels
+		pod.OwnerReferences = []metav1.OwnerReference{ownerRef("ReplicaSet", owner.Name, owner.UID)}
+	}
+	return pod
+}
+
+// StatefulSet returns a statefulset whose template carries app=<name>
+func StatefulSet(namespace, name string, replicas int32) *appsv1.StatefulSet {
+	labels := map[string]string{"app": name}
+	return &appsv1.StatefulSet{
+		ObjectMeta: objectMeta(namespace, name),
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+		},
+	}
+}
+
+// StatefulPod returns replica ordinal of sts, named <sts>-<ordinal> as the
+// statefulset controller would
+func StatefulPod(sts *appsv1.StatefulSet, ordinal int, node string) *corev1.Pod {
+	pod := Pod(sts.Namespace, fmt.Sprintf("%s-%d", sts.Name, ordinal), node, nil)
+	pod.Labels = sts.Spec.Template.Labels
+	pod.OwnerReferences = []metav1.OwnerReference{ownerRef("StatefulSet", sts.Name, sts.UID)}
+	return pod
+}
+
+// DaemonSet returns a daemonset whose template carries app=<name>
+func DaemonSet(namespace, name string) *appsv1.DaemonSet {
+	labels := map[string]string{"app": name}
+	return &appsv1.DaemonSet{
+		ObjectMeta: objectMeta(namespace, name),
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}},
+		},
+	}
+}
+
+// DaemonPod returns the pod of ds on node, Running and Ready if ready is
+// set and Pending otherwise
+func DaemonPod(ds *appsv1.DaemonSet, node string, ready bool) *corev1.Pod {
+	pod := Pod(ds.Namespace, ds.Name+"-"+node, node, nil)
+	pod.Labels = ds.Spec.Template.Labels
+	pod.OwnerReferences = []metav1.OwnerReference{ownerRef("DaemonSet", ds.Name, ds.UID)}
+	pod.Status.Phase = corev1.PodPending
+	if ready {
+		pod.Status.Phase = corev1.PodRunning
+		pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	}
+	return pod
+}
+
+// PVC returns a bound-agnostic claim
+func PVC(namespace, name string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{ObjectMeta: objectMeta(namespace, name)}
+}
+
+// BoundPVC returns a claim of the given class and size, bound to volume
+func BoundPVC(namespace, name, class, size, volume string) *corev1.PersistentVolumeClaim {
+	pvc := PVC(namespace, name)
+	pvc.Spec.StorageClassName = &class
+	pvc.Spec.VolumeName = volume
+	pvc.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(size)}
+	pvc.Status.Phase = corev1.ClaimBound
+	pvc.Status.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(size)}
+	return pvc
+}
+
+// PersistentVolume returns a bound CSI volume of the given class and size
+func PersistentVolume(name, class, size, driver string, claim *corev1.PersistentVolumeClaim) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: objectMeta("", name),
+		Spec: corev1.PersistentVolumeSpec{
+			StorageClassName:              class,
+			Capacity:                      corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(size)},
+			AccessModes:                   []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+			PersistentVolumeSource:        corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{Driver: driver}},
+			ClaimRef:                      &corev1.ObjectReference{Namespace: claim.Namespace, Name: claim.Name, UID: claim.UID},
+		},
+		Status: corev1.PersistentVolumeStatus{Phase: corev1.VolumeBound},
+	}
+}
+
+// WarningEvent returns a warning event about a pod, last seen at
+func WarningEvent(pod *corev1.Pod, reason, message string, at time.Time) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta:     objectMeta(pod.Namespace, pod.Name+"."+reason),
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, UID: pod.UID},
+		Type:           corev1.EventTypeWarning,
+		Reason:         reason,
+		Message:        message,
+		Count:          1,
+		FirstTimestamp: metav1.NewTime(at),
+		LastTimestamp:  metav1.NewTime(at),
+	}
+}
+
+// StorageClass returns a class with the given provisioner
+func StorageClass(name, provisioner string) *storagev1.StorageClass {
+	return &storagev1.StorageClass{ObjectMeta: objectMeta("", name), Provisioner: provisioner}
+}
+
+func ownerRef(kind, name string, uid types.UID) metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{Kind: kind, Name: name, UID: uid, Controller: &controller}
+}
+
+// Service returns a ClusterIP service selecting app=<name>
+func Service(namespace, name string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: objectMeta(namespace, name),
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": name}},
+	}
+}
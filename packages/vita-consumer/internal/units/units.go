@@ -0,0 +1,166 @@
+// Package units describes the unit each metric type is reported in and
+// converts values to other units of the same dimension.
+package units
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Dimension groups units that can be converted into each other
+type Dimension string
+
+const (
+	DimensionCPUTime Dimension = "cpu_time" // cumulative CPU time
+	DimensionCPU     Dimension = "cpu"      // CPU usage rate
+	DimensionBytes   Dimension = "bytes"
+	DimensionCount   Dimension = "count"
+	DimensionTime    Dimension = "time" // elapsed wall time
+)
+
+// Info describes a metric type
+type Info struct {
+	Type      string    `json:"type"`
+	Unit      string    `json:"unit"` // unit the agent reports in
+	Dimension Dimension `json:"dimension"`
+	// Counter metrics only ever increase; rate units are derived from them
+	Counter     bool   `json:"counter"`
+	Description string `json:"description"`
+	// Derived series are computed by recording rules rather than reported
+	Derived bool `json:"derived,omitempty"`
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]Info{}
+)
+
+// Register adds or replaces a metric type description
+func Register(info Info) {
+	mu.Lock()
+	registry[info.Type] = info
+	mu.Unlock()
+}
+
+// Unregister removes a derived metric type; reported types are kept
+func Unregister(metricType string) {
+	mu.Lock()
+	if registry[metricType].Derived {
+		delete(registry, metricType)
+	}
+	mu.Unlock()
+}
+
+func init() {
+	for _, info := range []Info{
+		{Type: "cpu_ms", Unit: "ms", Dimension: DimensionCPUTime, Counter: true, Description: "Cumulative container CPU time"},
+		{Type: "cpu_throttled_ms", Unit: "ms", Dimension: DimensionCPUTime, Counter: true, Description: "Cumulative time container CPU was throttled by its limit"},
+		{Type: "cpu_periods", Unit: "count", Dimension: DimensionCount, Counter: true, Description: "Cumulative CFS enforcement periods"},
+		{Type: "cpu_throttled_periods", Unit: "count", Dimension: DimensionCount, Counter: true, Description: "Cumulative CFS periods in which the container was throttled"},
+		{Type: "mem_mb", Unit: "MiB", Dimension: DimensionBytes, Description: "Container memory usage"},
+		{Type: "mem_limit_mb", Unit: "MiB", Dimension: DimensionBytes, Description: "Container memory limit"},
+		{Type: "mem_working_set_mb", Unit: "MiB", Dimension: DimensionBytes, Description: "Container memory working set (usage minus inactive file cache)"},
+		{Type: "mem_rss_mb", Unit: "MiB", Dimension: DimensionBytes, Description: "Container anonymous memory (RSS)"},
+		{Type: "mem_cache_mb", Unit: "MiB", Dimension: DimensionBytes, Description: "Container page cache"},
+		{Type: "mem_swap_mb", Unit: "MiB", Dimension: DimensionBytes, Description: "Container swap usage"},
+		{Type: "total_mb", Unit: "MiB", Dimension: DimensionBytes, Description: "Volume capacity"},
+		{Type: "used_mb", Unit: "MiB", Dimension: DimensionBytes, Description: "Volume space used"},
+		{Type: "free_mb", Unit: "MiB", Dimension: DimensionBytes, Description: "Volume space free"},
+		{Type: "replicas_desired", Unit: "count", Dimension: DimensionCount, Description: "Deployment desired replicas"},
+		{Type: "replicas_ready", Unit: "count", Dimension: DimensionCount, Description: "Deployment ready replicas"},
+		{Type: "replicas_available", Unit: "count", Dimension: DimensionCount, Description: "Deployment available replicas"},
+		{Type: "replicas_updated", Unit: "count", Dimension: DimensionCount, Description: "Deployment up-to-date replicas"},
+		{Type: "scheduling_latency_ms", Unit: "ms", Dimension: DimensionTime, Description: "Time from pod creation until it was scheduled"},
+		{Type: "startup_latency_ms", Unit: "ms", Dimension: DimensionTime, Description: "Time from pod scheduling until it was first ready"},
+	} {
+		Register(info)
+	}
+}
+
+// Lookup returns the description of a metric type
+func Lookup(metricType string) (Info, bool) {
+	mu.RLock()
+	info, ok := registry[metricType]
+	mu.RUnlock()
+	return info, ok
+}
+
+// All returns every registered metric type, sorted by name
+func All() []Info {
+	mu.RLock()
+	out := make([]Info, 0, len(registry))
+	for _, info := range registry {
+		out = append(out, info)
+	}
+	mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Type < out[j].Type })
+	return out
+}
+
+// Scale factors to the base unit of each dimension
+var scales = map[Dimension]map[string]float64{
+	DimensionCPUTime: {"ns": 1e-6, "us": 1e-3, "ms": 1, "s": 1e3},
+	DimensionCPU:     {"millicores": 1e-3, "cores": 1},
+	DimensionBytes: {
+		"bytes": 1, "B": 1,
+		"KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30, "TiB": 1 << 40,
+		"KB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12,
+	},
+	DimensionCount: {"count": 1},
+	DimensionTime:  {"ms": 1, "s": 1e3, "min": 6e4},
+}
+
+// canonical maps case-insensitive unit spellings to the registry form
+func canonical(dim Dimension, unit string) (string, bool) {
+	for u := range scales[dim] {
+		if strings.EqualFold(u, unit) {
+			return u, true
+		}
+	}
+	return "", false
+}
+
+// Converter turns values of one metric type into a target unit
+type Converter struct {
+	From   Info
+	Unit   string // resolved target unit
+	factor float64
+	// Rate is set when converting a counter to a usage rate (e.g. cpu_ms to
+	// cores); values must then be differentiated over time before Apply.
+	Rate bool
+}
+
+// NewConverter validates that metricType can be expressed in target.
+// An empty target keeps the reported unit.
+func NewConverter(metricType, target string) (*Converter, error) {
+	info, ok := Lookup(metricType)
+	if !ok {
+		return nil, fmt.Errorf("unknown metric type %q", metricType)
+	}
+	if target == "" {
+		return &Converter{From: info, Unit: info.Unit, factor: 1}, nil
+	}
+
+	from := scales[info.Dimension][info.Unit]
+
+	// CPU time counters can be turned into a usage rate
+	if info.Counter && info.Dimension == DimensionCPUTime {
+		if u, ok := canonical(DimensionCPU, target); ok {
+			// ms of CPU per second of wall time = millicores
+			return &Converter{From: info, Unit: u, factor: from / 1e3 / scales[DimensionCPU][u], Rate: true}, nil
+		}
+	}
+
+	u, ok := canonical(info.Dimension, target)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %s (%s) to %q", metricType, info.Unit, target)
+	}
+	return &Converter{From: info, Unit: u, factor: from / scales[info.Dimension][u]}, nil
+}
+
+// Apply converts a value (or, for rate converters, a per-second delta)
+func (c *Converter) Apply(v float64) float64 {
+	return v * c.factor
+}
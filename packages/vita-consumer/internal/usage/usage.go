@@ -0,0 +1,331 @@
+// Package usage accounts ingested datapoints per namespace and node per
+// hour, and optionally enforces hourly quotas, so disproportionate
+// monitoring volume can be traced to the workloads producing it.
+package usage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/lastseen"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+)
+
+// Scopes counts are kept under
+const (
+	Namespace = "namespace"
+	Node      = "node"
+)
+
+// Unresolved is the namespace of points whose pod or PVC is not (yet) in
+// the catalog
+const Unresolved = "(unresolved)"
+
+// flushInterval is how often counts are written to SQLite
+const flushInterval = 30 * time.Second
+
+// retention is how long hourly totals are kept
+const retention = 30 * 24 * time.Hour
+
+// Quotas caps datapoints per hour; zero means unlimited
+type Quotas struct {
+	NamespacePerHour int64
+	NodePerHour      int64
+}
+
+type key struct {
+	scope, name string
+}
+
+type count struct {
+	points, dropped int64
+}
+
+// Accountant counts points as ingest admits them. Counts are attributed to
+// the hour they arrive in, not the sample timestamp.
+type Accountant struct {
+	sqlite *store.SQLiteStore
+	clock  clock.Clock
+	quotas Quotas
+
+	mu      sync.Mutex
+	hour    time.Time
+	current map[key]int64  // admitted this hour, including flushed counts
+	pending map[key]*count // not yet written, all for hour
+
+	nsMu       sync.RWMutex
+	namespaces [2]map[int64]string // pod/PVC id -> namespace name
+}
+
+func New(sqlite *store.SQLiteStore) *Accountant {
+	a := &Accountant{
+		sqlite:  sqlite,
+		clock:   clock.Real,
+		current: make(map[key]int64),
+		pending: make(map[key]*count),
+	}
+	for i := range a.namespaces {
+		a.namespaces[i] = make(map[int64]string)
+	}
+	return a
+}
+
+// SetClock replaces the clock hours are taken from. Must be called before
+// the accountant is shared.
+func (a *Accountant) SetClock(c clock.Clock) {
+	a.clock = c
+}
+
+// SetQuotas enables quota enforcement. Must be called before the
+// accountant is shared.
+func (a *Accountant) SetQuotas(q Quotas) {
+	a.quotas = q
+}
+
+// Admit counts a batch posted by node and returns the points within quota.
+// ms is not modified; without drops it is returned as is.
+func (a *Accountant) Admit(node string, ms []buffer.Metric) []buffer.Metric {
+	if len(ms) == 0 {
+		return ms
+	}
+	namespaces := a.resolve(ms)
+	hour := a.clock.Now().Truncate(time.Hour)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rollLocked(hour)
+	nodeKey := key{Node, node}
+
+	var admitted []buffer.Metric // allocated on the first drop
+	for i, m := range ms {
+		nsKey := key{Namespace, namespaces[i]}
+		if a.overLocked(nsKey, a.quotas.NamespacePerHour) || a.overLocked(nodeKey, a.quotas.NodePerHour) {
+			a.pendingLocked(nsKey).dropped++
+			a.pendingLocked(nodeKey).dropped++
+			if admitted == nil {
+				admitted = append(make([]buffer.Metric, 0, len(ms)), ms[:i]...)
+			}
+			continue
+		}
+		a.current[nsKey]++
+		a.current[nodeKey]++
+		a.pendingLocked(nsKey).points++
+		a.pendingLocked(nodeKey).points++
+		if admitted != nil {
+			admitted = append(admitted, m)
+		}
+	}
+	if admitted == nil {
+		return ms
+	}
+	return admitted
+}
+
+func (a *Accountant) overLocked(k key, quota int64) bool {
+	return quota > 0 && a.current[k] >= quota
+}
+
+func (a *Accountant) pendingLocked(k key) *count {
+	c := a.pending[k]
+	if c == nil {
+		c = &count{}
+		a.pending[k] = c
+	}
+	return c
+}
+
+// rollLocked starts a new quota hour. The previous hour's remainder is
+// handed to a background write, as pending counts are all for one hour.
+func (a *Accountant) rollLocked(hour time.Time) {
+	if hour.Equal(a.hour) {
+		return
+	}
+	if len(a.pending) > 0 {
+		rows := a.takeLocked()
+		go func() {
+			if err := a.sqlite.AddIngestUsage(rows); err != nil {
+				log.Printf("Usage: failed to write counts, %d rows lost: %v", len(rows), err)
+			}
+			if err := a.sqlite.DeleteIngestUsageBefore(hour.Add(-retention)); err != nil {
+				log.Printf("Usage: failed to prune counts: %v", err)
+			}
+		}()
+	}
+	a.hour = hour
+	a.current = make(map[key]int64)
+
+	// Pod and PVC namespaces never change, but ids of deleted resources
+	// would accumulate; start over once an hour
+	a.nsMu.Lock()
+	for i := range a.namespaces {
+		a.namespaces[i] = make(map[int64]string)
+	}
+	a.nsMu.Unlock()
+}
+
+// resolve returns the namespace of every point, querying the catalog for
+// ids not cached yet
+func (a *Accountant) resolve(ms []buffer.Metric) []string {
+	out := make([]string, len(ms))
+	var missing [2][]int64
+
+	a.nsMu.RLock()
+	for i, m := range ms {
+		kind, _ := lastseen.KindOf(m.Type)
+		if ns, ok := a.namespaces[kind][m.ResourceID]; ok {
+			out[i] = ns
+		} else if m.ResourceID > 0 {
+			missing[kind] = append(missing[kind], m.ResourceID)
+		}
+	}
+	a.nsMu.RUnlock()
+
+	if len(missing[lastseen.Pod]) == 0 && len(missing[lastseen.PVC]) == 0 {
+		for i := range out {
+			if out[i] == "" {
+				out[i] = Unresolved
+			}
+		}
+		return out
+	}
+
+	tables := [2]string{lastseen.Pod: "pods", lastseen.PVC: "pvcs"}
+	for kind, ids := range missing {
+		if len(ids) == 0 {
+			continue
+		}
+		found, err := a.lookup(tables[kind], ids)
+		if err != nil {
+			log.Printf("Usage: failed to resolve namespaces: %v", err)
+			continue
+		}
+		a.nsMu.Lock()
+		for id, ns := range found {
+			a.namespaces[kind][id] = ns
+		}
+		a.nsMu.Unlock()
+	}
+
+	a.nsMu.RLock()
+	defer a.nsMu.RUnlock()
+	for i, m := range ms {
+		if out[i] != "" {
+			continue
+		}
+		kind, _ := lastseen.KindOf(m.Type)
+		if ns, ok := a.namespaces[kind][m.ResourceID]; ok {
+			out[i] = ns
+		} else {
+			out[i] = Unresolved
+		}
+	}
+	return out
+}
+
+func (a *Accountant) lookup(table string, ids []int64) (map[int64]string, error) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := a.sqlite.Query(fmt.Sprintf(`SELECT r.id, n.name FROM %s r
+		JOIN namespaces n ON r.namespace_id = n.id
+		WHERE r.id IN (%s)`, table, strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]string, len(ids))
+	for rows.Next() {
+		var id int64
+		var ns string
+		if err := rows.Scan(&id, &ns); err != nil {
+			continue
+		}
+		out[id] = ns
+	}
+	return out, rows.Err()
+}
+
+// Run writes counts every flushInterval until ctx is done, then once more
+func (a *Accountant) Run(ctx context.Context) {
+	a.load()
+
+	ticker := a.clock.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			a.Flush()
+			return
+		case <-ticker.C():
+			a.Flush()
+		}
+	}
+}
+
+// load seeds this hour's quota counts from SQLite, so a restart does not
+// hand out a fresh quota
+func (a *Accountant) load() {
+	hour := a.clock.Now().Truncate(time.Hour)
+	stored := map[key]int64{}
+	for _, scope := range []string{Namespace, Node} {
+		rows, err := a.sqlite.IngestUsageRange(scope, hour, hour.Add(time.Hour))
+		if err != nil {
+			log.Printf("Usage: failed to load current hour: %v", err)
+			return
+		}
+		for _, r := range rows {
+			stored[key{scope, r.Name}] = r.Points
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rollLocked(hour)
+	for k, v := range stored {
+		a.current[k] += v
+	}
+}
+
+// Flush writes pending counts. Failed writes are retried on the next
+// flush within the same hour.
+func (a *Accountant) Flush() {
+	a.mu.Lock()
+	hour := a.hour
+	rows := a.takeLocked()
+	a.mu.Unlock()
+	if len(rows) == 0 {
+		return
+	}
+
+	if err := a.sqlite.AddIngestUsage(rows); err != nil {
+		log.Printf("Usage: failed to write counts: %v", err)
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.hour.Equal(hour) {
+			for _, r := range rows {
+				c := a.pendingLocked(key{r.Scope, r.Name})
+				c.points += r.Points
+				c.dropped += r.Dropped
+			}
+		}
+	}
+}
+
+func (a *Accountant) takeLocked() []store.IngestUsage {
+	rows := make([]store.IngestUsage, 0, len(a.pending))
+	for k, c := range a.pending {
+		rows = append(rows, store.IngestUsage{Hour: a.hour, Scope: k.scope, Name: k.name, Points: c.points, Dropped: c.dropped})
+	}
+	a.pending = make(map[key]*count)
+	return rows
+}
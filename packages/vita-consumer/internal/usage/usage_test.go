@@ -0,0 +1,70 @@
+package usage_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/synctest"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/usage"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestIngestUsage verifies points are counted per namespace and node, and
+// that points over a namespace quota are dropped and reported
+func TestIngestUsage(t *testing.T) {
+	synctest.Run(t, func(ctx context.Context, env *synctest.Env) error {
+		for _, ns := range []string{"team-a", "team-b"} {
+			if _, err := env.Client.CoreV1().Pods(ns).Create(ctx, synctest.Pod(ns, "app", "node-a", nil), metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		}
+		if err := env.Eventually(synctest.Timeout, "pods synced", func() (bool, error) {
+			n, err := env.QueryInt("SELECT COUNT(*) FROM pods")
+			return n == 2, err
+		}); err != nil {
+			return err
+		}
+		podA, err := env.QueryInt("SELECT p.id FROM pods p JOIN namespaces n ON p.namespace_id = n.id WHERE n.name = 'team-a'")
+		if err != nil {
+			return err
+		}
+		podB, err := env.QueryInt("SELECT p.id FROM pods p JOIN namespaces n ON p.namespace_id = n.id WHERE n.name = 'team-b'")
+		if err != nil {
+			return err
+		}
+
+		env.Usage.SetQuotas(usage.Quotas{NamespacePerHour: 3})
+		var batch []buffer.Metric
+		for i := 0; i < 5; i++ {
+			batch = append(batch, buffer.Metric{Time: env.Clock.Now(), ResourceID: podA, Type: "mem_mb", Value: 10})
+		}
+		batch = append(batch, buffer.Metric{Time: env.Clock.Now(), ResourceID: podB, Type: "mem_mb", Value: 10})
+		if admitted := env.Usage.Admit("node-a", batch); len(admitted) != 4 {
+			return fmt.Errorf("admitted %d points, want 4", len(admitted))
+		}
+		env.Usage.Flush()
+
+		var byNamespace api.UsageResponse
+		if err := env.GetJSON("/api/v1/usage", &byNamespace); err != nil {
+			return err
+		}
+		if len(byNamespace.Items) != 2 {
+			return fmt.Errorf("namespaces = %+v, want team-a and team-b", byNamespace.Items)
+		}
+		if a := byNamespace.Items[0]; a.Name != "team-a" || a.Points != 3 || a.Dropped != 2 || a.SharePct != 75 {
+			return fmt.Errorf("team-a usage = %+v, want 3 points, 2 dropped, 75%%", a)
+		}
+
+		var byNode api.UsageResponse
+		if err := env.GetJSON("/api/v1/usage?scope=node", &byNode); err != nil {
+			return err
+		}
+		if len(byNode.Items) != 1 || byNode.Items[0].Points != 4 || byNode.Items[0].Dropped != 2 {
+			return fmt.Errorf("node usage = %+v, want node-a with 4 points, 2 dropped", byNode.Items)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,87 @@
+package workload
+
+import (
+	"log"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/syncer"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Pod latency metric types, of kind pod with one point per pod: at
+// the time the pod was scheduled, and the time it was first ready
+const (
+	MetricSchedulingLatency = "scheduling_latency_ms"
+	MetricStartupLatency    = "startup_latency_ms"
+)
+
+// LatencyMetrics lists every pod latency metric type
+var LatencyMetrics = []string{MetricSchedulingLatency, MetricStartupLatency}
+
+// LatencyRecorder writes each pod's scheduling and startup latency to
+// DuckDB once, as soon as the syncer sees it scheduled and ready. The pod
+// row remembers what was written, so restarts and resyncs do not repeat it.
+type LatencyRecorder struct {
+	sqlite *store.SQLiteStore
+	duck   *store.DuckDBStore
+	// pods with both latencies recorded, to skip their updates
+	done map[int64]bool
+}
+
+func NewLatencyRecorder(sqlite *store.SQLiteStore, duck *store.DuckDBStore) *LatencyRecorder {
+	return &LatencyRecorder{sqlite: sqlite, duck: duck, done: make(map[int64]bool)}
+}
+
+// Run consumes catalog events until the channel is closed
+func (r *LatencyRecorder) Run(events <-chan syncer.Event) {
+	for ev := range events {
+		if ev.Kind != "pod" {
+			continue
+		}
+		if ev.Type == syncer.EventDeleted {
+			delete(r.done, ev.ID)
+			continue
+		}
+		pod, ok := ev.Obj.(*corev1.Pod)
+		if !ok || r.done[ev.ID] {
+			continue
+		}
+		if err := r.record(ev.ID, pod); err != nil {
+			log.Printf("Failed to record latencies of pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+	}
+}
+
+func (r *LatencyRecorder) record(id int64, pod *corev1.Pod) error {
+	scheduling, startup := syncer.PodLatencies(pod)
+	if scheduling == nil {
+		return nil
+	}
+	recSched, recStart, err := r.sqlite.PodLatencies(id)
+	if err != nil {
+		return err
+	}
+
+	scheduled := pod.CreationTimestamp.Add(*scheduling)
+	var points []store.MetricPoint
+	var schedMs, startMs *float64
+	if recSched == nil {
+		v := float64(scheduling.Milliseconds())
+		schedMs = &v
+		points = append(points, store.MetricPoint{Time: scheduled, ResourceID: id, ResourceKind: "pod", MetricType: MetricSchedulingLatency, Value: v})
+	}
+	if startup != nil && recStart == nil {
+		v := float64(startup.Milliseconds())
+		startMs = &v
+		points = append(points, store.MetricPoint{Time: scheduled.Add(*startup), ResourceID: id, ResourceKind: "pod", MetricType: MetricStartupLatency, Value: v})
+	}
+	r.done[id] = startup != nil || recStart != nil
+	if len(points) == 0 {
+		return nil
+	}
+	if err := r.duck.BatchInsert(points); err != nil {
+		delete(r.done, id)
+		return err
+	}
+	return r.sqlite.SetPodLatencies(id, schedMs, startMs)
+}
@@ -0,0 +1,89 @@
+package workload
+
+import (
+	"log"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/syncer"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+// Replica state metric types (the "workload_state" family). Their series
+// are of kind deployment, keyed by deployment ID, so they never enter the
+// live ring buffer.
+const (
+	MetricReplicasDesired   = "replicas_desired"
+	MetricReplicasReady     = "replicas_ready"
+	MetricReplicasAvailable = "replicas_available"
+	MetricReplicasUpdated   = "replicas_updated"
+)
+
+// StateMetrics lists every workload_state metric type
+var StateMetrics = []string{
+	MetricReplicasDesired,
+	MetricReplicasReady,
+	MetricReplicasAvailable,
+	MetricReplicasUpdated,
+}
+
+// workloadKey identifies a workload: IDs are only unique within a kind
+type workloadKey struct {
+	kind string
+	id   int64
+}
+
+// Recorder writes replica counts to DuckDB whenever they change
+type Recorder struct {
+	duck  *store.DuckDBStore
+	clock clock.Clock
+	// last recorded state per workload, to skip no-op updates
+	last map[workloadKey][4]int32
+}
+
+func NewRecorder(duck *store.DuckDBStore, clk clock.Clock) *Recorder {
+	return &Recorder{
+		duck:  duck,
+		clock: clk,
+		last:  make(map[workloadKey][4]int32),
+	}
+}
+
+// Run consumes catalog events until the channel is closed
+func (r *Recorder) Run(events <-chan syncer.Event) {
+	for ev := range events {
+		if ev.Kind != "deployment" || ev.Type == syncer.EventDeleted {
+			continue
+		}
+		d, ok := ev.Obj.(*appsv1.Deployment)
+		if !ok {
+			continue
+		}
+		if err := r.record(workloadKey{ev.Kind, ev.ID}, d); err != nil {
+			log.Printf("Failed to record replica state for %s/%s: %v", d.Namespace, d.Name, err)
+		}
+	}
+}
+
+func (r *Recorder) record(key workloadKey, d *appsv1.Deployment) error {
+	desired := int32(1)
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+
+	state := [4]int32{desired, d.Status.ReadyReplicas, d.Status.AvailableReplicas, d.Status.UpdatedReplicas}
+	if prev, ok := r.last[key]; ok && prev == state {
+		return nil
+	}
+
+	now := r.clock.Now()
+	points := make([]store.MetricPoint, len(StateMetrics))
+	for i, metricType := range StateMetrics {
+		points[i] = store.MetricPoint{Time: now, ResourceID: key.id, ResourceKind: key.kind, MetricType: metricType, Value: float64(state[i])}
+	}
+	if err := r.duck.BatchInsert(points); err != nil {
+		return err
+	}
+	r.last[key] = state
+	return nil
+}
@@ -0,0 +1,96 @@
+package vitacore
+
+import (
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/health"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/processes"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/syncer"
+)
+
+// Authenticators for AuthConfig.Mode
+const (
+	AuthNone  = api.AuthNone  // every request is admitted as "anonymous"
+	AuthToken = api.AuthToken // "Authorization: Bearer <token>" for one of Tokens
+	AuthOIDC  = api.AuthOIDC  // an ID token of the OIDC provider
+)
+
+// AuthConfig selects how API requests are authenticated
+type AuthConfig struct {
+	Mode   string            // empty is AuthNone
+	Tokens map[string]string // token -> principal name, for AuthToken
+	OIDC   OIDCConfig
+}
+
+// OIDCConfig configures AuthOIDC
+type OIDCConfig struct {
+	IssuerURL     string
+	Audience      string // the client id tokens must be issued for
+	UsernameClaim string // default "email", falling back to "sub"
+	GroupsClaim   string // default "groups"
+}
+
+func (a AuthConfig) internal() api.AuthConfig {
+	return api.AuthConfig{
+		Mode:   a.Mode,
+		Tokens: a.Tokens,
+		OIDC: api.OIDCConfig{
+			IssuerURL:     a.OIDC.IssuerURL,
+			Audience:      a.OIDC.Audience,
+			UsernameClaim: a.OIDC.UsernameClaim,
+			GroupsClaim:   a.OIDC.GroupsClaim,
+		},
+	}
+}
+
+// Resolver layers for ResolverConfig.Layers
+const (
+	LayerCache  = syncer.LayerCache  // ids the informers and catalog preload put in memory
+	LayerSQLite = syncer.LayerSQLite // rows of the catalog, deleted pods included
+	LayerAPI    = syncer.LayerAPI    // lists the cluster for objects the informers have not delivered yet
+)
+
+// ResolverConfig picks how ingest resolves the UIDs agents report
+type ResolverConfig struct {
+	Layers      []string      // in lookup order; empty means cache, SQLite, then the API server
+	MissTTL     time.Duration // how long a UID no layer knows is not looked up again
+	APIInterval time.Duration // minimum time between API lists per type
+}
+
+func (r ResolverConfig) internal() syncer.ResolverConfig {
+	return syncer.ResolverConfig{Layers: r.Layers, MissTTL: r.MissTTL, APIInterval: r.APIInterval}
+}
+
+// PodLogsAuth selects who may read pod logs; any one way admits a caller
+type PodLogsAuth struct {
+	Token string // a bearer token callers present
+	// TrustProxyUser admits a user set by an authenticating proxy, which
+	// is only safe when nothing else can reach Handler
+	TrustProxyUser bool
+	Authenticated  bool // any caller Auth proved the identity of
+}
+
+func (p PodLogsAuth) internal() api.PodLogsAuth {
+	return api.PodLogsAuth{Token: p.Token, TrustProxyUser: p.TrustProxyUser, Authenticated: p.Authenticated}
+}
+
+// ProcessesConfig sizes the per-process samples kept
+type ProcessesConfig struct {
+	TopN      int           // processes kept per pod and sample (default 10)
+	Retention time.Duration // how long samples are kept (default 24h)
+}
+
+func (p ProcessesConfig) internal() processes.Config {
+	return processes.Config{TopN: p.TopN, Retention: p.Retention}
+}
+
+// HealthConfig schedules workload health scoring
+type HealthConfig struct {
+	Interval  time.Duration // between scoring runs (default 5 minutes)
+	Retention time.Duration // of score history (default 7 days)
+}
+
+func (h HealthConfig) internal() health.Config {
+	return health.Config{Interval: h.Interval, Retention: h.Retention}
+}
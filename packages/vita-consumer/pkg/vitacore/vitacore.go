@@ -0,0 +1,330 @@
+// Package vitacore embeds vitakube's collection and query engine in another
+// Go program: the Kubernetes catalog syncer, the SQLite and DuckDB stores,
+// the in-memory metric buffer, agent ingestion and the dashboard API. The
+// host program serves the handlers on its own HTTP server.
+//
+//	core, err := vitacore.New(vitacore.Config{DataDir: "/var/lib/vita"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer core.Close()
+//	core.Start(ctx)
+//	mux.Handle("/api/v1/", core.Handler())
+//
+// Maintenance windows, recording rules, reports, stream sinks and the admin
+// endpoints stay with the standalone consumer.
+package vitacore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/agentconfig"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/api"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/buffer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/clock"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/health"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/ingest"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/jobs"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/lastseen"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/persist"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/processes"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/rollup"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/store"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/syncer"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/usage"
+	"github.com/nchanged/vitakube/packages/vita-consumer/internal/workload"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Config selects where data lives and which cluster is followed. Zero
+// values take the consumer's defaults.
+type Config struct {
+	// DataDir holds meta.db and metrics.duckdb and is created if missing
+	DataDir string
+
+	// KubeConfig is a kubeconfig path; empty uses the in-cluster config
+	KubeConfig string
+	// Client replaces the client built from KubeConfig. ConfigMap and
+	// Secret tracking needs a REST config and is skipped.
+	Client kubernetes.Interface
+
+	// BufferSize is the number of metrics held in memory between flushes
+	// (default 10000)
+	BufferSize int
+	// IngestWorkers and IngestQueue size the ingest worker pool (defaults
+	// runtime.NumCPU() and 256)
+	IngestWorkers int
+	IngestQueue   int
+	// LateAfter routes samples older than this straight to DuckDB instead
+	// of the buffer (default 5 minutes, negative disables)
+	LateAfter time.Duration
+	// MaxAge drops samples older than this (default a week, negative
+	// keeps all)
+	MaxAge time.Duration
+
+	// MonthlyFiles splits metrics into one DuckDB file per month
+	// (metrics-2024-06.duckdb) instead of metrics.duckdb
+	MonthlyFiles bool
+
+	// Resolver picks the layers ingest resolves UIDs through (default
+	// cache, SQLite, then the API server; misses remembered for a minute,
+	// API lists at most every 30s)
+	Resolver ResolverConfig
+
+	// Auth authenticates API requests (default none). Agent ingestion
+	// stays open. Host programs with their own SSO can leave this unset
+	// and wrap Handler instead.
+	Auth AuthConfig
+
+	// PodLogs enables /api/v1/pods/{id}/logs for callers it admits
+	PodLogs PodLogsAuth
+
+	// ProcessMetrics keeps the per-process samples agents send and serves
+	// them at /api/v1/pods/{id}/processes; zero TopN and Retention take
+	// the defaults (10 per pod, 24h)
+	ProcessMetrics bool
+	Processes      ProcessesConfig
+
+	// HealthScores scores workloads on a schedule and serves the scores at
+	// /api/v1/health/workloads and in the deployment list; zero Interval
+	// and Retention take the defaults (5 minutes, 7 days)
+	HealthScores bool
+	Health       HealthConfig
+}
+
+// Core is a running collection and query engine
+type Core struct {
+	cfg Config
+
+	sqlite    *store.SQLiteStore
+	duck      *store.DuckDBStore
+	syncer    *syncer.ResourceSyncer
+	ring      *buffer.RingBuffer
+	ingestion *ingest.IngestionServer
+	pipeline  *persist.Pipeline
+	latest    *persist.LatestSink
+	live      *rollup.DeploymentLive
+	seen      *lastseen.Tracker
+	usage     *usage.Accountant
+	processes *processes.Recorder
+	health    *health.Scorer
+	server    *api.Server
+	handler   http.Handler
+
+	startOnce sync.Once
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// New opens the stores in cfg.DataDir and wires the pipeline. Nothing runs
+// until Start; Close releases the stores.
+func New(cfg Config) (*Core, error) {
+	if cfg.DataDir == "" {
+		return nil, errors.New("vitacore: DataDir is required")
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 10000
+	}
+	if cfg.IngestWorkers <= 0 {
+		cfg.IngestWorkers = runtime.NumCPU()
+	}
+	if cfg.IngestQueue <= 0 {
+		cfg.IngestQueue = 256
+	}
+	if cfg.LateAfter == 0 {
+		cfg.LateAfter = 5 * time.Minute
+	}
+	if cfg.MaxAge == 0 {
+		cfg.MaxAge = 7 * 24 * time.Hour
+	}
+	if cfg.Resolver.MissTTL == 0 {
+		cfg.Resolver.MissTTL = time.Minute
+	}
+	if cfg.Resolver.APIInterval == 0 {
+		cfg.Resolver.APIInterval = 30 * time.Second
+	}
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		return nil, fmt.Errorf("vitacore: failed to create data dir: %w", err)
+	}
+
+	c := &Core{cfg: cfg}
+	var err error
+	if c.sqlite, err = store.NewSQLiteStore(filepath.Join(cfg.DataDir, "meta.db")); err != nil {
+		return nil, fmt.Errorf("vitacore: failed to open SQLite: %w", err)
+	}
+	if cfg.MonthlyFiles {
+		c.duck, err = store.NewMonthlyDuckDBStore(cfg.DataDir, clock.Real.Now())
+	} else {
+		c.duck, err = store.NewDuckDBStore(filepath.Join(cfg.DataDir, "metrics.duckdb"))
+	}
+	if err != nil {
+		c.sqlite.Close()
+		return nil, fmt.Errorf("vitacore: failed to open DuckDB: %w", err)
+	}
+
+	if cfg.Client != nil {
+		c.syncer = syncer.NewResourceSyncerForClient(cfg.Client, nil, c.sqlite)
+	} else {
+		c.syncer = syncer.NewResourceSyncer(cfg.KubeConfig, c.sqlite)
+	}
+
+	c.ring = buffer.NewRingBuffer(cfg.BufferSize)
+	c.live = rollup.NewDeploymentLive(c.sqlite)
+	c.ring.AddObserver(c.live)
+	c.seen = lastseen.New()
+	c.ring.AddObserver(c.seen)
+
+	c.pipeline = persist.NewPipeline(c.ring)
+	c.pipeline.Register(persist.NewDuckDBSink(c.duck), persist.SinkOptions{AcceptLate: true})
+	c.latest = persist.NewLatestSink(c.sqlite, c.duck, clock.Real)
+	c.pipeline.Register(c.latest, persist.SinkOptions{AcceptLate: true})
+	c.pipeline.Register(persist.NewTotalsSink(c.duck, rollup.NewNodeTotals(c.sqlite), clock.Real), persist.SinkOptions{})
+
+	resolver, err := c.syncer.Resolver(cfg.Resolver.internal())
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("vitacore: %w", err)
+	}
+	c.ingestion = ingest.NewIngestionServer(c.ring, resolver)
+	c.usage = usage.New(c.sqlite)
+	c.ingestion.SetAccountant(c.usage)
+	if cfg.LateAfter > 0 {
+		c.ingestion.SetBackfill(c.pipeline, cfg.LateAfter)
+	}
+	if cfg.MaxAge > 0 {
+		c.ingestion.SetHorizon(cfg.MaxAge)
+	}
+	if cfg.ProcessMetrics {
+		c.processes = processes.New(c.duck, cfg.Processes.internal())
+		c.ingestion.SetProcessRecorder(c.processes)
+	}
+
+	server := api.NewServer(c.sqlite, c.duck, c.ring, c.syncer)
+	c.server = server
+	server.SetDeploymentTotals(c.live)
+	server.SetLastSeen(c.seen)
+	server.SetCatalogCache(c.syncer)
+	server.SetClusterStatus(c.syncer)
+	server.SetProcessMetrics(cfg.ProcessMetrics)
+	server.SetFlusher(c.pipeline)
+	if cfg.HealthScores {
+		c.health = health.NewScorer(c.sqlite, c.duck, cfg.Health.internal())
+		server.SetWorkloadHealth(true)
+	}
+	if podLogs := cfg.PodLogs.internal(); podLogs.Enabled() {
+		server.SetPodLogs(c.syncer, podLogs)
+	}
+
+	auth, err := api.NewAuthenticator(cfg.Auth.internal())
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("vitacore: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/ingest", c.ingestion.HandleIngest)
+	mux.HandleFunc("/api/v1/ingest/capabilities", c.ingestion.HandleCapabilities)
+	agentConfigs := agentconfig.NewServer(c.sqlite)
+	mux.HandleFunc("/api/v1/agent-config", agentConfigs.HandleAgentConfig)
+	agentConfigs.RegisterRoutes(mux)
+	server.RegisterRoutes(mux)
+	c.handler = api.Authenticate(auth, mux, "/api/v1/ingest", "/api/v1/ingest/capabilities", "/api/v1/agent-config")
+	return c, nil
+}
+
+// Start begins syncing the cluster, processing ingested batches and
+// persisting the buffer. It returns immediately; the engine runs until ctx
+// is done or Close is called. Later calls are no-ops.
+func (c *Core) Start(ctx context.Context) {
+	c.startOnce.Do(func() {
+		ctx, c.cancel = context.WithCancel(ctx)
+
+		c.ingestion.StartWorkers(ctx, c.cfg.IngestWorkers, c.cfg.IngestQueue)
+
+		// Lossless, as in the consumer: dropped events would be history
+		// never recorded
+		replicaEvents, _ := c.syncer.Changes().Subscribe("workload_state", 1024, true)
+		go workload.NewRecorder(c.duck, clock.Real).Run(replicaEvents)
+		latencyEvents, _ := c.syncer.Changes().Subscribe("pod_latency", 1024, true)
+		go workload.NewLatencyRecorder(c.sqlite, c.duck).Run(latencyEvents)
+		podEvents, _ := c.syncer.Changes().Subscribe("deployment_live", 1024, false)
+		go c.live.Run(podEvents)
+		go c.syncer.Start(ctx)
+
+		go func() {
+			if err := c.latest.Seed(ctx); err != nil {
+				log.Printf("Failed to seed latest values: %v", err)
+			}
+			if err := c.seen.Load(c.sqlite, clock.Real.Now().Add(-24*time.Hour)); err != nil {
+				log.Printf("Failed to load last-seen times: %v", err)
+			}
+		}()
+
+		c.wg.Add(2)
+		go func() {
+			defer c.wg.Done()
+			c.pipeline.Run(ctx)
+		}()
+		go func() {
+			defer c.wg.Done()
+			c.usage.Run(ctx)
+		}()
+		if c.processes != nil {
+			c.wg.Add(1)
+			go func() {
+				defer c.wg.Done()
+				c.processes.Run(ctx)
+			}()
+		}
+		background := jobs.NewScheduler()
+		background.Add(jobs.Job{Name: "purge-sweep", Interval: time.Minute, Jitter: 0.1, Immediate: true, Run: c.server.SweepPurged})
+		if c.health != nil {
+			background.Add(jobs.Job{Name: "health-scores", Interval: c.health.Interval(), Jitter: 0.1, Immediate: true, Run: c.health.RunOnce})
+		}
+		if c.processes != nil {
+			background.Add(jobs.Job{Name: "process-prune", Interval: time.Hour, Jitter: 0.1, Immediate: true, Run: c.processes.Prune})
+		}
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			background.Run(ctx)
+		}()
+	})
+}
+
+// Handler serves agent ingestion (POST /api/v1/ingest) and the dashboard
+// API under /api/v1/. Ingestion should only receive traffic after Start.
+func (c *Core) Handler() http.Handler {
+	return c.handler
+}
+
+// Flush moves buffered metrics to the stores' write queues without waiting
+// for the next interval
+func (c *Core) Flush() {
+	c.pipeline.FlushNow()
+}
+
+// Close stops the engine and closes the stores
+func (c *Core) Close() error {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+
+	var errs []error
+	if c.duck != nil {
+		errs = append(errs, c.duck.Close())
+	}
+	if c.sqlite != nil {
+		errs = append(errs, c.sqlite.Close())
+	}
+	return errors.Join(errs...)
+}